@@ -0,0 +1,147 @@
+package kvdb
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/ananthvk/kvdb/internal/datafile"
+	"github.com/ananthvk/kvdb/internal/keydir"
+	"github.com/ananthvk/kvdb/internal/record"
+)
+
+// adoptedRecord is a record of the file being adopted, that has to be applied to the keydir
+type adoptedRecord struct {
+	key        []byte
+	valueSize  uint32
+	offset     int64
+	timestamp  time.Time
+	isDeletion bool
+	immutable  bool
+	valueType  uint8
+}
+
+// AdoptFile adds an externally produced data file (for example, one shipped from another node) to the datastore.
+// The file at path is validated first, i.e. it must have a compatible data file header, and every record must have
+// a valid checksum. If the file is valid, it's copied into the datastore with the next data file id, and it's records
+// are indexed. The file at path is left untouched.
+//
+// Records from the adopted file are applied like records of any other data file, i.e. a record only replaces (or deletes)
+// an existing key if it's timestamp is not older than the current value of the key. No hint file is written for the adopted
+// file, so it's scanned completely when the datastore is opened, until it's merged.
+func (dataStore *DataStore) AdoptFile(path string) error {
+	return dataStore.AdoptFileWithOptions(path, nil)
+}
+
+// AdoptOptions changes how the records of an adopted file are applied
+type AdoptOptions struct {
+	// OnlyMatching only applies a record if the key's current value has the same timestamp as the record, i.e. the
+	// adopted file holds the same version of the value. Other records (including deletes) are ignored, so adopting the
+	// file never changes the value of a key, it only moves the key to the adopted file, and the records it replaces are
+	// reclaimed by the next merge. This is used by replicas (which keep the timestamps of the primary, see
+	// PutWithTimestamp) to adopt files that were merged on the primary
+	OnlyMatching bool
+}
+
+// AdoptFileWithOptions is AdoptFile with options, opts can be nil
+func (dataStore *DataStore) AdoptFileWithOptions(path string, opts *AdoptOptions) error {
+	if err := dataStore.gate.enter(); err != nil {
+		return err
+	}
+	defer dataStore.gate.exit()
+	if opts == nil {
+		opts = &AdoptOptions{}
+	}
+	records, err := dataStore.validateForeignDataFile(path)
+	if err != nil {
+		return err
+	}
+
+	// Merge must not see the adopted file before it's indexed, otherwise all it's records would be treated as stale
+	dataStore.mergeLock.Lock()
+	defer dataStore.mergeLock.Unlock()
+
+	// The adoption is recorded in the merge manifest (as a merge without inputs) before the file is renamed, so a
+	// crash either completes it, or removes the temporary file when the datastore is opened
+	fileId, err := dataStore.fileManager.AdoptDataFile(path, func(tempName string, id int) error {
+		return writeMergeManifest(dataStore.fs, dataStore.path, &mergeManifest{
+			Inputs:  []int{},
+			Outputs: []mergeManifestOutput{{Temp: tempName, Id: id}},
+		})
+	})
+	if err != nil {
+		removeMergeManifest(dataStore.fs, dataStore.path)
+		return err
+	}
+
+	dataStore.mu.Lock()
+	for _, rec := range records {
+		adopted := keydir.KeydirRecord{
+			FileId:    fileId,
+			ValueSize: rec.valueSize,
+			ValuePos:  rec.offset,
+			Timestamp: rec.timestamp,
+			Immutable: rec.immutable,
+			ValueType: rec.valueType,
+		}
+		if opts.OnlyMatching {
+			current, exists := dataStore.keydir.GetKeydirRecord(rec.key)
+			if !rec.isDeletion && exists && current.Timestamp.Equal(rec.timestamp) {
+				dataStore.keydir.Add(rec.key, adopted)
+			}
+			continue
+		}
+		dataStore.observeTimestamp(rec.timestamp)
+		if rec.isDeletion {
+			dataStore.keydir.DeleteRecordIfNotNewer(rec.key, rec.timestamp)
+		} else {
+			dataStore.keydir.Add(rec.key, adopted)
+		}
+	}
+	dataStore.mu.Unlock()
+	dataStore.markManifestDirty()
+	return removeMergeManifest(dataStore.fs, dataStore.path)
+}
+
+// validateForeignDataFile checks that the file at path is a complete, uncorrupted data file, and returns it's records
+func (dataStore *DataStore) validateForeignDataFile(path string) ([]adoptedRecord, error) {
+	if _, err := datafile.ReadFileHeader(dataStore.fs, path); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidDataFile, err)
+	}
+	scanner, err := record.NewScanner(dataStore.fs, path)
+	if err != nil {
+		return nil, err
+	}
+	defer scanner.Close()
+	scanner.SetLimits(limitsOf(dataStore.metaInfo))
+	scanner.SetKeyring(dataStore.fileManager.Keyring())
+
+	var records []adoptedRecord
+	var nextOffset int64
+	for {
+		rec, offset, err := scanner.Scan()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			// A truncated record results in io.ErrUnexpectedEOF
+			return nil, fmt.Errorf("%w: record at offset %d: %w", ErrInvalidDataFile, nextOffset, err)
+		}
+		nextOffset = offset + rec.Size
+		if rec.Header.RecordType != record.RecordTypeDelete && !record.IsPut(rec.Header.RecordType) {
+			return nil, fmt.Errorf("%w: record at offset %d has unknown type 0x%x", ErrInvalidDataFile, offset, rec.Header.RecordType)
+		}
+		records = append(records, adoptedRecord{
+			// The scanner reuses it's buffer, so the key has to be copied
+			key:        append([]byte(nil), rec.Key...),
+			valueSize:  rec.Header.ValueSize,
+			offset:     offset,
+			timestamp:  rec.Header.Timestamp,
+			isDeletion: rec.Header.RecordType == record.RecordTypeDelete,
+			immutable:  rec.Header.RecordType == record.RecordTypePutImmutable,
+			valueType:  rec.Header.ValueType,
+		})
+	}
+	return records, nil
+}
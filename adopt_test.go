@@ -0,0 +1,237 @@
+package kvdb
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ananthvk/kvdb/internal/utils"
+	"github.com/spf13/afero"
+)
+
+func TestAdoptFile(t *testing.T) {
+	fs := afero.NewMemMapFs()
+
+	// Produce a data file in another datastore
+	source := helperCreateMultipleDataFiles(t, fs, "test_adopt_source.db")
+	source.Put([]byte("key1"), []byte("from_source"))
+	source.Put([]byte("key2"), []byte("from_source"))
+	source.Put([]byte("key3"), []byte("from_source"))
+	source.Delete([]byte("key3"))
+	source.Close()
+	sourceFile := filepath.Join("test_adopt_source.db", "data", "0000000001.dat")
+
+	store := helperCreateMultipleDataFiles(t, fs, "test_adopt.db")
+	defer store.Close()
+	store.Put([]byte("key3"), []byte("local"))
+	store.Put([]byte("key4"), []byte("local"))
+
+	// Writes made after the source file was written are newer, and must not be replaced
+	if err := store.AdoptFile(sourceFile); err != nil {
+		t.Fatalf("adopt failed: %v", err)
+	}
+	// key3 was deleted in the source, but the local value is newer
+	expected := map[string]string{"key1": "from_source", "key2": "from_source", "key3": "local", "key4": "local"}
+	for key, value := range expected {
+		val, err := store.Get([]byte(key))
+		if err != nil || string(val) != value {
+			t.Errorf("%s: expected %s, got %s (err: %v)", key, value, val, err)
+		}
+	}
+
+	// The adopted file should survive a restart
+	store.Close()
+	store, err := Open(fs, "test_adopt.db")
+	if err != nil {
+		t.Fatalf("failed to reopen store: %v", err)
+	}
+	for key, value := range expected {
+		val, err := store.Get([]byte(key))
+		if err != nil || string(val) != value {
+			t.Errorf("after reopen %s: expected %s, got %s (err: %v)", key, value, val, err)
+		}
+	}
+}
+
+func TestAdoptFileInvalid(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	store := helperCreateMultipleDataFiles(t, fs, "test_adopt_invalid.db")
+	defer store.Close()
+
+	afero.WriteFile(fs, "not_a_datafile", []byte("hello world, this is not a data file"), 0666)
+	if err := store.AdoptFile("not_a_datafile"); !errors.Is(err, ErrInvalidDataFile) {
+		t.Errorf("expected ErrInvalidDataFile, got %v", err)
+	}
+
+	// A truncated data file is rejected
+	source := helperCreateMultipleDataFiles(t, fs, "test_adopt_truncated.db")
+	source.Put([]byte("key1"), []byte("value1"))
+	source.Put([]byte("key2"), []byte("value2"))
+	source.Close()
+	sourceFile := filepath.Join("test_adopt_truncated.db", "data", "0000000001.dat")
+	data, _ := afero.ReadFile(fs, sourceFile)
+	afero.WriteFile(fs, "truncated", data[:len(data)-3], 0666)
+	if err := store.AdoptFile("truncated"); !errors.Is(err, ErrInvalidDataFile) {
+		t.Errorf("expected ErrInvalidDataFile, got %v", err)
+	}
+
+	if store.Size() != 0 {
+		t.Errorf("expected no keys after failed adoption, got %d", store.Size())
+	}
+}
+
+func TestAdoptedFileIsMerged(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	source := helperCreateMultipleDataFiles(t, fs, "test_adopt_merge_source.db")
+	source.Put([]byte("key1"), []byte("from_source"))
+	source.Close()
+	sourceFile := filepath.Join("test_adopt_merge_source.db", "data", "0000000001.dat")
+
+	path := "test_adopt_merge.db"
+	store := helperCreateMultipleDataFiles(t, fs, path)
+	defer store.Close()
+	store.Put([]byte("key2"), []byte("local"))
+	if err := store.AdoptFile(sourceFile); err != nil {
+		t.Fatalf("adopt failed: %v", err)
+	}
+	if exists, _ := afero.Exists(fs, filepath.Join(path, mergeManifestFileName)); exists {
+		t.Errorf("expected the manifest to be removed after the adoption")
+	}
+	// The adopted file is 2, and the active file is rotated past it
+	if active := store.fileManager.GetActiveFileId(); active <= 2 {
+		t.Fatalf("expected the active file to be rotated past the adopted file, got %d", active)
+	}
+	store.Put([]byte("key3"), []byte("local"))
+
+	if err := store.Merge(); err != nil {
+		t.Fatalf("merge failed: %v", err)
+	}
+	if exists, _ := afero.Exists(fs, filepath.Join(path, "data", utils.GetDataFileName(2))); exists {
+		t.Errorf("expected the adopted file to be merged")
+	}
+	expected := map[string]string{"key1": "from_source", "key2": "local", "key3": "local"}
+	for key, value := range expected {
+		val, err := store.Get([]byte(key))
+		if err != nil || string(val) != value {
+			t.Errorf("%s: expected %s, got %s (err: %v)", key, value, val, err)
+		}
+	}
+}
+
+func TestOpenCompletesInterruptedAdoption(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	source := helperCreateMultipleDataFiles(t, fs, "test_adopt_crash_source.db")
+	source.Put([]byte("key1"), []byte("from_source"))
+	source.Close()
+	data, err := afero.ReadFile(fs, filepath.Join("test_adopt_crash_source.db", "data", "0000000001.dat"))
+	if err != nil {
+		t.Fatalf("could not read source file: %v", err)
+	}
+
+	path := "test_adopt_crash.db"
+	store := helperCreateMultipleDataFiles(t, fs, path)
+	store.Put([]byte("key2"), []byte("local"))
+	store.Close()
+
+	// Crash after the manifest was written, before the copied file was renamed
+	afero.WriteFile(fs, filepath.Join(path, "data", "adopt-1"), data, 0666)
+	if err := writeMergeManifest(fs, path, &mergeManifest{Inputs: []int{}, Outputs: []mergeManifestOutput{{Temp: "adopt-1", Id: 5}}}); err != nil {
+		t.Fatalf("could not write manifest: %v", err)
+	}
+	// Crash in the middle of copying a file, before the manifest was written
+	afero.WriteFile(fs, filepath.Join(path, "data", "adopt-2"), data[:len(data)/2], 0666)
+
+	store, err = Open(fs, path)
+	if err != nil {
+		t.Fatalf("failed to reopen store: %v", err)
+	}
+	defer store.Close()
+	if store.mergeRecovery != MergeCompleted {
+		t.Errorf("expected the adoption to be completed, got %q", store.mergeRecovery)
+	}
+	for _, name := range []string{"adopt-1", "adopt-2"} {
+		if exists, _ := afero.Exists(fs, filepath.Join(path, "data", name)); exists {
+			t.Errorf("expected %s to be removed", name)
+		}
+	}
+	expected := map[string]string{"key1": "from_source", "key2": "local"}
+	for key, value := range expected {
+		val, err := store.Get([]byte(key))
+		if err != nil || string(val) != value {
+			t.Errorf("%s: expected %s, got %s (err: %v)", key, value, val, err)
+		}
+	}
+}
+
+func TestAdoptFileOnlyMatching(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	ts := time.UnixMicro(time.Now().UnixMicro())
+
+	// The primary writes three keys, and merges them into one file
+	primaryPath := "test_adopt_matching_primary.db"
+	primary := helperCreateMultipleDataFiles(t, fs, primaryPath)
+	for _, key := range []string{"key1", "key2", "key3"} {
+		primary.PutWithTimestamp([]byte(key), []byte("value"), ts)
+	}
+	primary.Close()
+	primary, err := Open(fs, primaryPath)
+	if err != nil {
+		t.Fatalf("failed to reopen store: %v", err)
+	}
+	defer primary.Close()
+	primary.Put([]byte("other"), []byte("value"))
+	var merged MergeEvent
+	primary.WatchMerges(func(event MergeEvent) { merged = event })
+	if err := primary.Merge(); err != nil || len(merged.Files) != 1 {
+		t.Fatalf("merge failed: %v (%+v)", err, merged)
+	}
+
+	// The replica has the same version of key1, a newer version of key2, and key3 was deleted
+	replica := helperCreateMultipleDataFiles(t, fs, "test_adopt_matching_replica.db")
+	defer replica.Close()
+	replica.PutWithTimestamp([]byte("key1"), []byte("value"), ts)
+	replica.PutWithTimestamp([]byte("key2"), []byte("newer"), ts.Add(time.Second))
+	replica.PutWithTimestamp([]byte("key3"), []byte("value"), ts)
+	replica.DeleteWithTimestamp([]byte("key3"), ts.Add(time.Second))
+
+	if err := replica.AdoptFileWithOptions(merged.Files[0], &AdoptOptions{OnlyMatching: true}); err != nil {
+		t.Fatalf("adopt failed: %v", err)
+	}
+	adoptedId := replica.fileManager.GetActiveFileId() - 1
+	if rec, _ := replica.keydir.GetKeydirRecord([]byte("key1")); rec.FileId != adoptedId {
+		t.Errorf("expected key1 to be moved to the adopted file %d, got %+v", adoptedId, rec)
+	}
+	if val, err := replica.Get([]byte("key2")); err != nil || string(val) != "newer" {
+		t.Errorf("expected key2 to keep the newer value, got %s (err: %v)", val, err)
+	}
+	if _, err := replica.Get([]byte("key3")); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("expected key3 to stay deleted, got %v", err)
+	}
+}
+
+func TestPutWithTimestamp(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	path := "test_put_with_timestamp.db"
+	store := helperCreateMultipleDataFiles(t, fs, path)
+	ts := time.Date(2024, 1, 2, 3, 4, 5, 6007, time.UTC)
+
+	var events []WatchEvent
+	store.Watch(func(event WatchEvent) { events = append(events, event) })
+	store.PutWithTimestamp([]byte("key1"), []byte("value1"), ts)
+	store.Put([]byte("key2"), []byte("value2"))
+	store.DeleteWithTimestamp([]byte("key2"), ts)
+	if len(events) != 3 || !events[0].Timestamp.Equal(ts.Truncate(time.Microsecond)) || !events[2].Timestamp.Equal(ts.Truncate(time.Microsecond)) {
+		t.Errorf("expected the events to carry the timestamps, got %+v", events)
+	}
+
+	store.Close()
+	store, err := Open(fs, path)
+	if err != nil {
+		t.Fatalf("failed to reopen store: %v", err)
+	}
+	defer store.Close()
+	if rec, _ := store.keydir.GetKeydirRecord([]byte("key1")); !rec.Timestamp.Equal(ts.Truncate(time.Microsecond)) {
+		t.Errorf("expected the timestamp to be kept, got %v", rec.Timestamp)
+	}
+}
@@ -0,0 +1,40 @@
+package kvdb
+
+import "fmt"
+
+// KeyValue is a key value pair written by PutBatch
+type KeyValue struct {
+	Key   []byte
+	Value []byte
+}
+
+// PutBatch sets the value of every pair in order, it's meant for bulk loads. The write lock is taken once for the
+// whole batch instead of once per pair, so a large batch blocks other writers (and readers) until it's written, callers
+// should keep batches to a few thousand pairs. Every pair is validated before anything is written, so a nil key or a
+// key or value above the limits (or a write-once key) fails the whole batch. Otherwise, the batch is not atomic, each
+// pair is written as if by Put (interceptors and watchers see every pair): if a write fails, the pairs before it remain
+// written, and their number is returned with the error
+func (dataStore *DataStore) PutBatch(pairs []KeyValue) (int, error) {
+	if err := dataStore.gate.enter(); err != nil {
+		return 0, err
+	}
+	defer dataStore.gate.exit()
+	for i, pair := range pairs {
+		if err := dataStore.checkWrite(pair.Key, pair.Value); err != nil {
+			return 0, fmt.Errorf("pair %d: %w", i, err)
+		}
+	}
+	dataStore.lockForWrite("datastore.put_batch")
+	defer dataStore.mu.Unlock()
+	for i, pair := range pairs {
+		if err := dataStore.checkImmutable(pair.Key); err != nil {
+			return 0, fmt.Errorf("pair %d: %w", i, err)
+		}
+	}
+	for i, pair := range pairs {
+		if err := dataStore.put(pair.Key, pair.Value); err != nil {
+			return i, err
+		}
+	}
+	return len(pairs), nil
+}
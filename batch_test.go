@@ -0,0 +1,51 @@
+package kvdb
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/spf13/afero"
+)
+
+func TestPutBatch(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	store, err := CreateWithOptions(fs, "test_put_batch.db", &Options{MaxValueSize: 8})
+	if err != nil {
+		t.Fatalf("error creating datastore: %v", err)
+	}
+
+	n, err := store.PutBatch([]KeyValue{{[]byte("key1"), []byte("value1")}, {[]byte("key2"), []byte("value2")}, {[]byte("key1"), []byte("new")}})
+	if err != nil || n != 3 {
+		t.Fatalf("expected 3 pairs to be written, got %d (err: %v)", n, err)
+	}
+	// Invalid pairs fail the batch before anything is written
+	n, err = store.PutBatch([]KeyValue{{[]byte("key4"), []byte("value4")}, {[]byte("key5"), []byte("more than eight bytes")}})
+	if !errors.Is(err, ErrValueTooLarge) || n != 0 {
+		t.Errorf("expected ErrValueTooLarge before any pair, got %d (err: %v)", n, err)
+	}
+	// A failed write stops the batch, the pairs before it remain written
+	store.Sync()
+	store.options.StallUnsyncedBytes, store.options.RejectStalledWrites = 1, true
+	n, err = store.PutBatch([]KeyValue{{[]byte("key3"), []byte("value3")}, {[]byte("key6"), []byte("value6")}})
+	if !errors.Is(err, ErrWriteStalled) || n != 1 {
+		t.Errorf("expected ErrWriteStalled after 1 pair, got %d (err: %v)", n, err)
+	}
+	store.options.StallUnsyncedBytes = 0
+	store.Close()
+
+	store, err = Open(fs, "test_put_batch.db")
+	if err != nil {
+		t.Fatalf("error opening datastore: %v", err)
+	}
+	defer store.Close()
+	for key, expected := range map[string]string{"key1": "new", "key2": "value2", "key3": "value3"} {
+		if value, err := store.Get([]byte(key)); err != nil || string(value) != expected {
+			t.Errorf("expected %q for %s, got %q (err: %v)", expected, key, value, err)
+		}
+	}
+	for _, key := range []string{"key4", "key5", "key6"} {
+		if _, err := store.Get([]byte(key)); !errors.Is(err, ErrKeyNotFound) {
+			t.Errorf("expected %s to not be written, got %v", key, err)
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package kvdb
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+
+	"github.com/ananthvk/kvdb/internal/bloom"
+	"github.com/ananthvk/kvdb/internal/filemanager"
+	"github.com/ananthvk/kvdb/internal/utils"
+	"github.com/spf13/afero"
+)
+
+// False positive rate of the bloom filters written by merges
+const bloomFalsePositiveRate = 0.01
+
+// bloomFileName returns the name of the bloom filter of a temporary merge file
+func bloomFileName(mergeFilePath string) string {
+	return filepath.Base(mergeFilePath) + ".bloom"
+}
+
+// writeMergeBloomFilters writes a bloom filter of the keys of every file written by a merge to the hint directory,
+// hashes has the hashes of the keys of every file by it's path. The files must have been closed
+func writeMergeBloomFilters(fs afero.Fs, layout filemanager.Layout, files []string, hashes map[string][]uint64) error {
+	for _, file := range files {
+		info, err := fs.Stat(file)
+		if err != nil {
+			return err
+		}
+		filter := bloom.New(len(hashes[file]), bloomFalsePositiveRate)
+		for _, hash := range hashes[file] {
+			filter.AddHash(hash)
+		}
+		if err := bloom.WriteFile(fs, layout.HintPath(bloomFileName(file)), filter, info.Size()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// MayContainKey reads the bloom filter of a data file of the datastore at path (written by merges when
+// Options.MergeBloomFilters is set) without opening the datastore. It returns false if the data file has no record of
+// the key, so a tool that reads the data files directly can skip the file. It returns true if the key may be in the
+// file, and also if the file has no bloom filter, or it's filter is damaged or stale
+func MayContainKey(fs afero.Fs, path string, fileId int, key []byte) (bool, error) {
+	layout, err := readLayout(fs, path)
+	if err != nil {
+		return true, err
+	}
+	filter, size, err := bloom.ReadFile(fs, layout.HintPath(utils.GetBloomFileName(fileId)))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) || errors.Is(err, bloom.ErrInvalidFile) {
+			return true, nil
+		}
+		return true, err
+	}
+	info, err := fs.Stat(layout.DataFilePath(fs, fileId))
+	if err != nil {
+		return true, err
+	}
+	if info.Size() != size {
+		// The data file was changed (for example, truncated by Repair) after the filter was written
+		return true, nil
+	}
+	return filter.MayContain(key), nil
+}
@@ -0,0 +1,138 @@
+package kvdb
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ananthvk/kvdb/internal/utils"
+	"github.com/spf13/afero"
+)
+
+func TestMergeBloomFilters(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	path := "test_merge_bloom.db"
+	store := helperMergeSyncStore(t, fs, path, &Options{MergeBloomFilters: true})
+	if err := store.Merge(); err != nil {
+		t.Fatalf("merge failed: %v", err)
+	}
+	stats, err := store.FileStats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, stat := range stats {
+		exists, _ := afero.Exists(fs, filepath.Join(path, "hint", utils.GetBloomFileName(stat.Id)))
+		if exists != stat.Merged {
+			t.Errorf("file %d: expected a bloom filter only for merged files, got %v", stat.Id, exists)
+		}
+		if !stat.Merged {
+			continue
+		}
+		for j := range 10 {
+			key := []byte(fmt.Sprintf("key%d", j))
+			// key0 was overwritten in the active file, so it's not in the merged file
+			mayContain, err := MayContainKey(fs, path, stat.Id, key)
+			if err != nil || (j != 0 && !mayContain) {
+				t.Errorf("file %d: expected key%d to be in the filter, got %v, %v", stat.Id, j, mayContain, err)
+			}
+		}
+		falsePositives := 0
+		for j := range 1000 {
+			if mayContain, _ := MayContainKey(fs, path, stat.Id, []byte(fmt.Sprintf("missing%d", j))); mayContain {
+				falsePositives++
+			}
+		}
+		if falsePositives > 50 {
+			t.Errorf("file %d: expected few false positives, got %d of 1000", stat.Id, falsePositives)
+		}
+	}
+
+	// Files without a filter may contain every key, like files whose filter is stale
+	active := store.fileManager.GetActiveFileId()
+	if mayContain, err := MayContainKey(fs, path, active, []byte("missing")); err != nil || !mayContain {
+		t.Errorf("expected a file without a filter to maybe contain the key, got %v, %v", mayContain, err)
+	}
+	merged := 0
+	for _, stat := range stats {
+		if stat.Merged {
+			merged = stat.Id
+		}
+	}
+	if exists, _ := afero.Exists(fs, filepath.Join(path, "hint", utils.GetBloomFileName(merged))); !exists {
+		t.Fatalf("expected merged file %d to have a bloom filter", merged)
+	}
+	// A key that the filter rejects while it's up to date
+	rejected := []byte("missing")
+	for j := 0; ; j++ {
+		if mayContain, _ := MayContainKey(fs, path, merged, rejected); !mayContain {
+			break
+		}
+		rejected = []byte(fmt.Sprintf("missing%d", j))
+	}
+	mergedPath := filepath.Join(path, "data", utils.GetDataFileName(merged))
+	data, _ := afero.ReadFile(fs, mergedPath)
+	afero.WriteFile(fs, mergedPath, append(append([]byte{}, data...), 'x'), 0644)
+	if mayContain, err := MayContainKey(fs, path, merged, rejected); err != nil || !mayContain {
+		t.Errorf("expected a stale filter to be ignored, got %v, %v", mayContain, err)
+	}
+	afero.WriteFile(fs, mergedPath, data, 0644)
+
+	// The filter is removed with it's data file
+	store.Put([]byte("key1"), []byte("latest"))
+	store.Close()
+	store, err = OpenWithOptions(fs, path, &Options{MergeBloomFilters: true})
+	if err != nil {
+		t.Fatalf("failed to reopen store: %v", err)
+	}
+	defer store.Close()
+	store.Put([]byte("key2"), []byte("latest"))
+	if err := store.Merge(); err != nil {
+		t.Fatalf("merge failed: %v", err)
+	}
+	if exists, _ := afero.Exists(fs, filepath.Join(path, "hint", utils.GetBloomFileName(merged))); exists {
+		t.Errorf("expected the filter of the merged file to be removed")
+	}
+}
+
+func TestMergeWithoutBloomFilters(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	path := "test_merge_no_bloom.db"
+	store := helperMergeSyncStore(t, fs, path, nil)
+	if err := store.Merge(); err != nil {
+		t.Fatalf("merge failed: %v", err)
+	}
+	entries, _ := afero.ReadDir(fs, filepath.Join(path, "hint"))
+	for _, entry := range entries {
+		if filepath.Ext(entry.Name()) == ".bloom" {
+			t.Errorf("expected no bloom filters, got %s", entry.Name())
+		}
+	}
+}
+
+func TestOpenCompletesMergeWithBloomFilter(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	path := "test_merge_bloom_rename.db"
+	before, manifest := helperMergeCrashStore(t, fs, path)
+
+	// Crash right after the manifest was written, with a bloom filter written by the merge
+	for name, data := range before {
+		afero.WriteFile(fs, name, data, os.ModePerm)
+	}
+	fs.Rename(filepath.Join(path, "data", utils.GetDataFileName(4)), filepath.Join(path, "data", "merge-1"))
+	fs.Rename(filepath.Join(path, "hint", utils.GetHintFileName(4)), filepath.Join(path, "hint", "merge-1"))
+	afero.WriteFile(fs, filepath.Join(path, "hint", "merge-1.bloom"), []byte("filter"), os.ModePerm)
+	if err := writeMergeManifest(fs, path, manifest); err != nil {
+		t.Fatalf("could not write manifest: %v", err)
+	}
+
+	store, err := Open(fs, path)
+	if err != nil {
+		t.Fatalf("failed to open store: %v", err)
+	}
+	defer store.Close()
+	helperCheckKeys(t, store)
+	if exists, _ := afero.Exists(fs, filepath.Join(path, "hint", utils.GetBloomFileName(4))); !exists {
+		t.Errorf("expected the bloom filter to be renamed")
+	}
+}
@@ -0,0 +1,116 @@
+package kvdb
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/ananthvk/kvdb/internal/record"
+	"github.com/spf13/afero"
+)
+
+// helperCheckChecksums checks that every data file of the store has records with checksums of the given algorithm
+func helperCheckChecksums(t *testing.T, store *DataStore, ids []int, checksum record.Checksum) {
+	t.Helper()
+	for _, id := range ids {
+		got, err := store.fileManager.DataFileChecksum(id)
+		if err != nil {
+			t.Fatalf("failed to read the checksum algorithm of file %d: %v", id, err)
+		}
+		if got != checksum {
+			t.Errorf("expected file %d to have %s checksums, got %s", id, checksum, got)
+		}
+	}
+}
+
+func TestChecksumAlgorithms(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	path := "test_checksum.db"
+	store, err := Create(fs, path)
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	expected := map[string]string{}
+	put := func(prefix string) {
+		for i := range 20 {
+			key, value := fmt.Sprintf("key%d", i), fmt.Sprintf("%s%d", prefix, i)
+			if err := store.Put([]byte(key), []byte(value)); err != nil {
+				t.Fatalf("put failed: %v", err)
+			}
+			expected[key] = value
+		}
+	}
+	put("crc32-")
+	store.Close()
+
+	// Every file keeps the algorithm it was written with, whatever the algorithm of new files is
+	store, err = OpenWithOptions(fs, path, &Options{Checksum: ChecksumCRC32C})
+	if err != nil {
+		t.Fatalf("failed to reopen store: %v", err)
+	}
+	put("crc32c-")
+	store.Close()
+	store, err = OpenWithOptions(fs, path, &Options{Checksum: ChecksumXXHash64, RecordFormat: RecordFormatV2})
+	if err != nil {
+		t.Fatalf("failed to reopen store: %v", err)
+	}
+	store.Delete([]byte("key0"))
+	delete(expected, "key0")
+	helperCheckValues(t, store, expected)
+	helperCheckChecksums(t, store, []int{1}, record.ChecksumCRC32)
+	helperCheckChecksums(t, store, []int{2}, record.ChecksumCRC32C)
+	helperCheckChecksums(t, store, []int{3}, record.ChecksumXXHash64)
+
+	// Merge rewrites every record with the current algorithm
+	if err := store.Merge(); err != nil {
+		t.Fatalf("merge failed: %v", err)
+	}
+	stats, err := store.FileStats()
+	if err != nil {
+		t.Fatalf("file stats failed: %v", err)
+	}
+	var mergedIds []int
+	for _, stat := range stats {
+		if stat.Merged {
+			mergedIds = append(mergedIds, stat.Id)
+		}
+	}
+	if len(mergedIds) == 0 {
+		t.Fatalf("expected merged files in %+v", stats)
+	}
+	helperCheckChecksums(t, store, mergedIds, record.ChecksumXXHash64)
+	helperCheckValues(t, store, expected)
+	store.Close()
+
+	// The files are read (from the hint files, and by scanning them) when the store is opened with the defaults
+	for _, removeHints := range []bool{false, true} {
+		if removeHints {
+			if err := fs.RemoveAll(path + "/hint"); err != nil {
+				t.Fatal(err)
+			}
+			if err := fs.Mkdir(path+"/hint", 0755); err != nil {
+				t.Fatal(err)
+			}
+		}
+		store, err = Open(fs, path)
+		if err != nil {
+			t.Fatalf("failed to reopen store: %v", err)
+		}
+		helperCheckValues(t, store, expected)
+		store.Close()
+	}
+}
+
+func TestChecksumInvalid(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	if _, err := CreateWithOptions(fs, "test_checksum_invalid.db", &Options{Checksum: 4}); err == nil {
+		t.Errorf("expected an error for an unknown checksum algorithm")
+	}
+	store, err := Create(fs, "test_checksum_invalid.db")
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	store.Close()
+	if _, err := OpenWithOptions(fs, "test_checksum_invalid.db", &Options{Checksum: -1}); err == nil {
+		t.Errorf("expected an error for an unknown checksum algorithm")
+	}
+}
@@ -0,0 +1,402 @@
+// Package client is a Go client for kvserver
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ananthvk/kvdb/internal/resp"
+)
+
+/*
+Client
+
+A Client talks RESP to a kvserver over a pool of connections, so it can be shared by many goroutines:
+
+	c := client.New("localhost:6379", &client.Options{Password: "secret"})
+	defer c.Close()
+	err := c.Set(ctx, "key", []byte("value"))
+	value, err := c.Get(ctx, "key")
+
+Connections are dialed when they are needed, and at most PoolSize are open at a time: a command waits for a connection
+to be returned to the pool if all of them are in use. A connection is authenticated with AUTH when it's dialed, if a
+password is set. A connection that fails (or whose command is cancelled) is closed instead of being returned to the pool.
+
+Every method takes a context, the deadline of the context is the deadline of the network operations, and cancelling the
+context interrupts the command (the connection is then closed, since the reply may still arrive on it).
+
+A command that fails with a network error (for example because the server closed an idle connection, see
+-idle-timeout) is retried on a new connection up to MaxRetries times, waiting RetryBackoff between attempts. Once a
+command has been sent, the server may have run it even though it's reply was not received, so only the commands that
+give the same result when they are run twice (see idempotentCommands) are retried then. The other commands (like INCR,
+APPEND, LPUSH or GETDEL) are only retried if the connection could not be made, before they were sent. Pipelines and
+transactions are not retried.
+
+Replies are returned by Do as Go values: simple and bulk strings as string, integers as int64, arrays as []any, and
+Null as nil (with ErrNil if it's the whole reply). An error reply is returned as an *Error. See pipeline.go for
+pipelining and transactions
+*/
+
+// Default values of the options
+const (
+	defaultPoolSize     = 10
+	defaultDialTimeout  = 5 * time.Second
+	defaultMaxRetries   = 3
+	defaultRetryBackoff = 100 * time.Millisecond
+)
+
+// Commands that can be sent again after a network error, running them twice gives the same state and reply. SET key
+// value is too, see idempotent
+var idempotentCommands = map[string]bool{
+	"GET": true, "TYPE": true, "KEYS": true, "SCAN": true, "DBSIZE": true, "PING": true, "ECHO": true,
+	"HGET": true, "HGETALL": true, "HLEN": true, "LLEN": true, "LRANGE": true, "SMEMBERS": true, "SCARD": true,
+	"SISMEMBER": true, "JSON.GET": true, "INFO": true,
+}
+
+var (
+	// ErrNil is returned when the reply is Null, e.g. by Get for a key that does not exist
+	ErrNil = errors.New("kvdb client: nil reply")
+	// ErrClosed is returned by the commands of a closed client
+	ErrClosed = errors.New("kvdb client: client is closed")
+	// ErrTxFailed is returned when a transaction is not run because a watched key was modified
+	ErrTxFailed = errors.New("kvdb client: transaction failed, a watched key was modified")
+)
+
+// Error is an error reply of the server
+type Error struct {
+	// Prefix is the first word of the error, like ERR, WRONGTYPE or NOPERM
+	Prefix  string
+	Message string
+}
+
+func (e *Error) Error() string {
+	return e.Prefix + " " + e.Message
+}
+
+// Options configure a Client, the zero value of each option selects it's default
+type Options struct {
+	// Username and Password are sent with AUTH when a connection is dialed, no AUTH is sent if Password is empty. The
+	// default user is used if Username is empty
+	Username string
+	Password string
+	// PoolSize is the maximum number of open connections, 10 by default
+	PoolSize int
+	// DialTimeout is the maximum time to connect to the server, 5 seconds by default
+	DialTimeout time.Duration
+	// MaxRetries is the number of times a command that failed with a network error is retried, 3 by default, a
+	// negative value disables the retries. A command that was sent is only retried if it's idempotent (like GET or
+	// SET key value), see client.go
+	MaxRetries int
+	// RetryBackoff is the time to wait before a retry, 100 milliseconds by default
+	RetryBackoff time.Duration
+}
+
+// Client is a pool of connections to a kvserver, it's safe for concurrent use
+type Client struct {
+	addr string
+	opts Options
+	// tokens holds a token for each connection that can be opened, a connection takes one while it's open
+	tokens chan struct{}
+
+	mu     sync.Mutex
+	idle   []*conn
+	closed bool
+}
+
+// New returns a client for the server at addr (host:port), opts can be nil. No connection is made until the first
+// command is sent
+func New(addr string, opts *Options) *Client {
+	var o Options
+	if opts != nil {
+		o = *opts
+	}
+	if o.PoolSize <= 0 {
+		o.PoolSize = defaultPoolSize
+	}
+	if o.DialTimeout <= 0 {
+		o.DialTimeout = defaultDialTimeout
+	}
+	if o.MaxRetries == 0 {
+		o.MaxRetries = defaultMaxRetries
+	}
+	if o.RetryBackoff <= 0 {
+		o.RetryBackoff = defaultRetryBackoff
+	}
+	c := &Client{addr: addr, opts: o, tokens: make(chan struct{}, o.PoolSize)}
+	for range o.PoolSize {
+		c.tokens <- struct{}{}
+	}
+	return c
+}
+
+// Close closes the idle connections, connections in use are closed when they are returned. Commands sent after Close
+// fail with ErrClosed
+func (c *Client) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.closed = true
+	var err error
+	for _, cn := range c.idle {
+		err = errors.Join(err, cn.close())
+	}
+	c.idle = nil
+	return err
+}
+
+// get returns an idle connection, or dials a new one. It waits until a connection is available if PoolSize
+// connections are open
+func (c *Client) get(ctx context.Context) (*conn, error) {
+	select {
+	case <-c.tokens:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		c.tokens <- struct{}{}
+		return nil, ErrClosed
+	}
+	if n := len(c.idle); n > 0 {
+		cn := c.idle[n-1]
+		c.idle = c.idle[:n-1]
+		c.mu.Unlock()
+		return cn, nil
+	}
+	c.mu.Unlock()
+
+	cn, err := c.dial(ctx)
+	if err != nil {
+		c.tokens <- struct{}{}
+		return nil, err
+	}
+	return cn, nil
+}
+
+// put returns a connection to the pool, a broken connection is closed
+func (c *Client) put(cn *conn, broken bool) {
+	c.mu.Lock()
+	if broken || c.closed {
+		cn.close()
+	} else {
+		c.idle = append(c.idle, cn)
+	}
+	c.mu.Unlock()
+	c.tokens <- struct{}{}
+}
+
+// dial connects to the server, and authenticates if a password is set
+func (c *Client) dial(ctx context.Context) (*conn, error) {
+	dialer := net.Dialer{Timeout: c.opts.DialTimeout}
+	netConn, err := dialer.DialContext(ctx, "tcp", c.addr)
+	if err != nil {
+		return nil, err
+	}
+	cn := newConn(netConn)
+	if c.opts.Password != "" {
+		args := []any{"AUTH", c.opts.Password}
+		if c.opts.Username != "" {
+			args = []any{"AUTH", c.opts.Username, c.opts.Password}
+		}
+		if _, err := cn.do(ctx, args); err != nil {
+			cn.close()
+			return nil, fmt.Errorf("kvdb client: authentication failed: %w", err)
+		}
+	}
+	return cn, nil
+}
+
+// Do sends a command and returns it's reply. The arguments can be strings, byte slices, integers, floats or booleans.
+// See client.go for the types of the replies
+func (c *Client) Do(ctx context.Context, args ...any) (any, error) {
+	retrySent := idempotent(args)
+	for attempt := 0; ; attempt++ {
+		reply, sent, err := c.doOnce(ctx, args)
+		if err != nil {
+			err = contextError(ctx, err)
+		}
+		if !isNetworkError(err) || (sent && !retrySent) || attempt >= c.opts.MaxRetries || ctx.Err() != nil {
+			return reply, err
+		}
+		select {
+		case <-time.After(c.opts.RetryBackoff):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// doOnce sends the command on a connection of the pool, sent is false if the command was not sent because no connection
+// could be made
+func (c *Client) doOnce(ctx context.Context, args []any) (reply any, sent bool, err error) {
+	cn, err := c.get(ctx)
+	if err != nil {
+		return nil, false, err
+	}
+	value, err := cn.do(ctx, args)
+	c.put(cn, err != nil)
+	if err != nil {
+		return nil, true, err
+	}
+	reply, err = replyOf(value)
+	return reply, true, err
+}
+
+// idempotent returns true if the command can be sent again after a network error, see idempotentCommands
+func idempotent(args []any) bool {
+	if len(args) == 0 {
+		return false
+	}
+	var name string
+	switch value := args[0].(type) {
+	case string:
+		name = value
+	case []byte:
+		name = string(value)
+	}
+	name = strings.ToUpper(name)
+	if name == "SET" {
+		// Options like NX or GET make the reply of a second SET differ
+		return len(args) == 3
+	}
+	return idempotentCommands[name]
+}
+
+// Get returns the value of the key, or ErrNil if it does not exist
+func (c *Client) Get(ctx context.Context, key string) ([]byte, error) {
+	reply, err := c.Do(ctx, "GET", key)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(reply.(string)), nil
+}
+
+// Set sets the value of the key
+func (c *Client) Set(ctx context.Context, key string, value []byte) error {
+	_, err := c.Do(ctx, "SET", key, value)
+	return err
+}
+
+// Del deletes the keys, and returns the number of keys that existed
+func (c *Client) Del(ctx context.Context, keys ...string) (int64, error) {
+	args := make([]any, 0, len(keys)+1)
+	args = append(args, "DEL")
+	for _, key := range keys {
+		args = append(args, key)
+	}
+	reply, err := c.Do(ctx, args...)
+	if err != nil {
+		return 0, err
+	}
+	return reply.(int64), nil
+}
+
+// Scan returns up to count keys in sorted order starting at cursor, and the cursor of the next page. The first cursor
+// is "0", and "0" is returned after the last page
+func (c *Client) Scan(ctx context.Context, cursor string, count int) ([]string, string, error) {
+	reply, err := c.Do(ctx, "SCAN", cursor, "COUNT", count)
+	if err != nil {
+		return nil, "", err
+	}
+	page, ok := reply.([]any)
+	if !ok || len(page) != 2 {
+		return nil, "", fmt.Errorf("%w: unexpected SCAN reply", resp.ErrProtocolError)
+	}
+	next, _ := page[0].(string)
+	values, _ := page[1].([]any)
+	keys := make([]string, 0, len(values))
+	for _, value := range values {
+		key, _ := value.(string)
+		keys = append(keys, key)
+	}
+	return keys, next, nil
+}
+
+// ScanAll calls fn for every key, in sorted order, fetching count keys at a time. It stops at the first error returned
+// by fn, and returns it
+func (c *Client) ScanAll(ctx context.Context, count int, fn func(key string) error) error {
+	cursor := "0"
+	for {
+		keys, next, err := c.Scan(ctx, cursor, count)
+		if err != nil {
+			return err
+		}
+		for _, key := range keys {
+			if err := fn(key); err != nil {
+				return err
+			}
+		}
+		if next == "0" {
+			return nil
+		}
+		cursor = next
+	}
+}
+
+// isNetworkError returns true if the error is a failure of the connection, after which a command can be retried
+func isNetworkError(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) || errors.Is(err, errConnClosed)
+}
+
+// arg returns an argument of a command as a bulk string
+func arg(value any) (resp.Value, error) {
+	var buf []byte
+	switch value := value.(type) {
+	case string:
+		buf = []byte(value)
+	case []byte:
+		buf = value
+	case int:
+		buf = strconv.AppendInt(nil, int64(value), 10)
+	case int64:
+		buf = strconv.AppendInt(nil, value, 10)
+	case uint64:
+		buf = strconv.AppendUint(nil, value, 10)
+	case float64:
+		buf = strconv.AppendFloat(nil, value, 'f', -1, 64)
+	case bool:
+		buf = []byte("0")
+		if value {
+			buf = []byte("1")
+		}
+	default:
+		return resp.Value{}, fmt.Errorf("kvdb client: unsupported argument type %T", value)
+	}
+	return resp.Value{Type: resp.ValueTypeBulkString, Buffer: buf}, nil
+}
+
+// replyOf converts a reply to a Go value, see client.go
+func replyOf(value resp.Value) (any, error) {
+	switch value.Type {
+	case resp.ValueTypeNull:
+		return nil, ErrNil
+	case resp.ValueTypeSimpleError:
+		return nil, &Error{Prefix: string(value.SimpleErrorPrefix), Message: string(value.Buffer)}
+	}
+	return valueOf(value), nil
+}
+
+func valueOf(value resp.Value) any {
+	switch value.Type {
+	case resp.ValueTypeSimpleString, resp.ValueTypeBulkString:
+		return string(value.Buffer)
+	case resp.ValueTypeInteger:
+		return value.Integer
+	case resp.ValueTypeSimpleError:
+		return &Error{Prefix: string(value.SimpleErrorPrefix), Message: string(value.Buffer)}
+	case resp.ValueTypeArray:
+		elements := make([]any, len(value.Array))
+		for i, element := range value.Array {
+			elements[i] = valueOf(element)
+		}
+		return elements
+	}
+	return nil
+}
@@ -0,0 +1,130 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ananthvk/kvdb/internal/resp"
+)
+
+// helperServer accepts connections and calls handle with each request and the number of the connection (starting from
+// 1), it replies with the returned value, or closes the connection if handle returns false
+func helperServer(t *testing.T, handle func(conn int, request resp.Value) (resp.Value, bool)) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	var conns atomic.Int64
+	go func() {
+		for {
+			netConn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			n := int(conns.Add(1))
+			go func() {
+				defer netConn.Close()
+				reader, writer := bufio.NewReader(netConn), bufio.NewWriter(netConn)
+				for {
+					request, err := resp.Deserialize(reader)
+					if err != nil {
+						return
+					}
+					reply, ok := handle(n, request)
+					if !ok {
+						return
+					}
+					resp.Serialize(reply, writer)
+					writer.Flush()
+				}
+			}()
+		}
+	}()
+	return listener.Addr().String()
+}
+
+func TestRetry(t *testing.T) {
+	ok := resp.Value{Type: resp.ValueTypeSimpleString, Buffer: []byte("OK")}
+	// The first two connections are closed before the reply
+	addr := helperServer(t, func(conn int, request resp.Value) (resp.Value, bool) {
+		return ok, conn > 2
+	})
+	c := New(addr, &Options{RetryBackoff: time.Millisecond})
+	defer c.Close()
+	if reply, err := c.Do(context.Background(), "SET", "key", []byte("value")); err != nil || reply != "OK" {
+		t.Errorf("expected OK after the retries, got %v, %v", reply, err)
+	}
+
+	addr = helperServer(t, func(conn int, request resp.Value) (resp.Value, bool) {
+		return ok, false
+	})
+	c = New(addr, &Options{MaxRetries: -1})
+	defer c.Close()
+	if _, err := c.Do(context.Background(), "PING"); !errors.Is(err, errConnClosed) {
+		t.Errorf("expected the error of the closed connection without retries, got %v", err)
+	}
+
+	// Commands that are not idempotent are not sent again, the server may have run them
+	var received atomic.Int64
+	addr = helperServer(t, func(conn int, request resp.Value) (resp.Value, bool) {
+		received.Add(1)
+		return ok, false
+	})
+	c = New(addr, &Options{RetryBackoff: time.Millisecond})
+	defer c.Close()
+	for _, test := range []struct {
+		args  []any
+		sends int64
+	}{
+		{[]any{"INCR", "counter"}, 1},
+		{[]any{"SET", "key", "value", "GET"}, 1},
+		{[]any{"GET", "key"}, defaultMaxRetries + 1},
+		{[]any{"set", "key", "value"}, defaultMaxRetries + 1},
+	} {
+		received.Store(0)
+		if _, err := c.Do(context.Background(), test.args...); !errors.Is(err, errConnClosed) {
+			t.Errorf("%v: expected the error of the closed connection, got %v", test.args, err)
+		}
+		if n := received.Load(); n != test.sends {
+			t.Errorf("%v: expected the command to be sent %d times, got %d", test.args, test.sends, n)
+		}
+	}
+}
+
+func TestContext(t *testing.T) {
+	blocked := make(chan struct{})
+	t.Cleanup(func() { close(blocked) })
+	addr := helperServer(t, func(conn int, request resp.Value) (resp.Value, bool) {
+		if string(request.Array[0].Buffer) == "BLOCK" {
+			<-blocked
+		}
+		return resp.Value{Type: resp.ValueTypeInteger, Integer: 1}, true
+	})
+	c := New(addr, &Options{PoolSize: 1})
+	defer c.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := c.Do(ctx, "BLOCK"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected the deadline to be exceeded, got %v", err)
+	}
+	// The interrupted connection is closed, the next command gets a new one
+	if reply, err := c.Do(context.Background(), "PING"); err != nil || reply != int64(1) {
+		t.Errorf("expected 1, got %v, %v", reply, err)
+	}
+	if _, err := c.Do(context.Background(), "SET", "key", struct{}{}); err == nil {
+		t.Errorf("expected an error for an unsupported argument")
+	}
+
+	c.Close()
+	if _, err := c.Do(context.Background(), "PING"); !errors.Is(err, ErrClosed) {
+		t.Errorf("expected ErrClosed, got %v", err)
+	}
+}
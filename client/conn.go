@@ -0,0 +1,103 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"time"
+
+	"github.com/ananthvk/kvdb/internal/resp"
+)
+
+// Size of the read and write buffers of a connection
+const connBufferSize = 16 * 1024
+
+// errConnClosed is returned when the server closes the connection before the reply is received
+var errConnClosed = errors.New("kvdb client: connection closed by the server")
+
+// conn is a connection to the server, it's used by one goroutine at a time
+type conn struct {
+	netConn net.Conn
+	reader  *bufio.Reader
+	writer  *bufio.Writer
+}
+
+func newConn(netConn net.Conn) *conn {
+	return &conn{
+		netConn: netConn,
+		reader:  bufio.NewReaderSize(netConn, connBufferSize),
+		writer:  bufio.NewWriterSize(netConn, connBufferSize),
+	}
+}
+
+func (cn *conn) close() error {
+	return cn.netConn.Close()
+}
+
+// do sends a command and reads it's reply
+func (cn *conn) do(ctx context.Context, args []any) (resp.Value, error) {
+	replies, err := cn.roundTrip(ctx, [][]any{args})
+	if err != nil {
+		return resp.Value{}, err
+	}
+	return replies[0], nil
+}
+
+// roundTrip sends the commands, and reads a reply for each of them. The connection can't be used after an error, the
+// error is ctx.Err() if the context was cancelled or it's deadline passed
+func (cn *conn) roundTrip(ctx context.Context, commands [][]any) (replies []resp.Value, err error) {
+	// A cancelled context interrupts the blocked read or write by moving the deadline to the past
+	deadline, _ := ctx.Deadline()
+	if err := cn.netConn.SetDeadline(deadline); err != nil {
+		return nil, err
+	}
+	stop := context.AfterFunc(ctx, func() { cn.netConn.SetDeadline(time.Now()) })
+	defer func() {
+		stop()
+		if err != nil {
+			err = contextError(ctx, err)
+		}
+	}()
+
+	for _, command := range commands {
+		values := make([]resp.Value, len(command))
+		for i, value := range command {
+			if values[i], err = arg(value); err != nil {
+				return nil, err
+			}
+		}
+		if err := resp.Serialize(resp.Value{Type: resp.ValueTypeArray, Array: values}, cn.writer); err != nil {
+			return nil, err
+		}
+	}
+	if err := cn.writer.Flush(); err != nil {
+		return nil, err
+	}
+	replies = make([]resp.Value, len(commands))
+	for i := range replies {
+		if replies[i], err = resp.Deserialize(cn.reader); err != nil {
+			if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+				return nil, fmt.Errorf("%w: %w", errConnClosed, err)
+			}
+			return nil, err
+		}
+	}
+	return replies, nil
+}
+
+// contextError returns ctx.Err() if the context is done, or context.DeadlineExceeded for a timeout of the network
+// connection after the deadline of the context. The connection times out at the deadline, which can be just before
+// the context itself is done
+func contextError(ctx context.Context, err error) error {
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return ctxErr
+	}
+	var netErr net.Error
+	if deadline, ok := ctx.Deadline(); ok && !time.Now().Before(deadline) && errors.As(err, &netErr) && netErr.Timeout() {
+		return context.DeadlineExceeded
+	}
+	return err
+}
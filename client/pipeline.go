@@ -0,0 +1,189 @@
+package client
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/ananthvk/kvdb/internal/resp"
+)
+
+/*
+Pipelines and transactions
+
+A Pipeline queues commands and sends them together when Exec is called, so they take a single round trip. The commands
+are not atomic, other clients' commands can run in between:
+
+	p := c.Pipeline()
+	p.Do("SET", "a", "1")
+	p.Do("GET", "a")
+	results, err := p.Exec(ctx)
+
+Exec returns an error if the commands could not be sent, or their replies read. Otherwise it returns a Result per
+command, with the command's error reply (or ErrNil) in it's Err.
+
+Watch runs an optimistic transaction, like WATCH / MULTI / EXEC. The keys are watched on a connection that's reserved
+for the transaction, and fn reads them with the Tx. The writes are queued in tx.Pipeline(), whose Exec runs them
+atomically with MULTI / EXEC, and fails with ErrTxFailed if a watched key was modified since it was watched:
+
+	err := c.Watch(ctx, func(tx *client.Tx) error {
+		value, err := tx.Get(ctx, "counter")
+		...
+		p := tx.Pipeline()
+		p.Do("SET", "counter", increment(value))
+		_, err = p.Exec(ctx)
+		return err
+	}, "counter")
+
+The caller decides whether to retry a failed transaction. The keys are unwatched when fn returns
+*/
+
+// Result is the reply of a command of a pipeline
+type Result struct {
+	// Value is the reply (see client.go for the types), nil if Err is set
+	Value any
+	// Err is the error reply of the command (an *Error), or ErrNil for a Null reply
+	Err error
+}
+
+// Pipeline queues commands that are sent together
+type Pipeline struct {
+	client   *Client
+	tx       *Tx
+	commands [][]any
+}
+
+// Pipeline returns an empty pipeline
+func (c *Client) Pipeline() *Pipeline {
+	return &Pipeline{client: c}
+}
+
+// Do queues a command, see Client.Do for the arguments
+func (p *Pipeline) Do(args ...any) {
+	p.commands = append(p.commands, args)
+}
+
+// Len returns the number of queued commands
+func (p *Pipeline) Len() int {
+	return len(p.commands)
+}
+
+// Exec sends the queued commands and returns their results, in the same order. The pipeline is empty afterwards
+func (p *Pipeline) Exec(ctx context.Context) ([]Result, error) {
+	commands := p.commands
+	p.commands = nil
+	if len(commands) == 0 {
+		return nil, nil
+	}
+	if p.tx != nil {
+		return p.tx.exec(ctx, commands)
+	}
+	cn, err := p.client.get(ctx)
+	if err != nil {
+		return nil, err
+	}
+	replies, err := cn.roundTrip(ctx, commands)
+	p.client.put(cn, err != nil)
+	if err != nil {
+		return nil, err
+	}
+	return results(replies), nil
+}
+
+func results(replies []resp.Value) []Result {
+	results := make([]Result, len(replies))
+	for i, reply := range replies {
+		results[i].Value, results[i].Err = replyOf(reply)
+	}
+	return results
+}
+
+// Tx is an optimistic transaction, see Client.Watch
+type Tx struct {
+	client *Client
+	cn     *conn
+	// broken is set if the connection failed, it's closed instead of being returned to the pool
+	broken bool
+}
+
+// Watch watches the keys and calls fn with a transaction, see pipeline.go. It returns the error returned by fn
+func (c *Client) Watch(ctx context.Context, fn func(tx *Tx) error, keys ...string) error {
+	cn, err := c.get(ctx)
+	if err != nil {
+		return contextError(ctx, err)
+	}
+	tx := &Tx{client: c, cn: cn}
+	defer func() {
+		// The keys stay watched on the connection if EXEC was not sent
+		if !tx.broken {
+			_, err := tx.Do(ctx, "UNWATCH")
+			tx.broken = err != nil
+		}
+		c.put(cn, tx.broken)
+	}()
+	if len(keys) > 0 {
+		args := make([]any, 0, len(keys)+1)
+		args = append(args, "WATCH")
+		for _, key := range keys {
+			args = append(args, key)
+		}
+		if _, err := tx.Do(ctx, args...); err != nil {
+			return err
+		}
+	}
+	return fn(tx)
+}
+
+// Do sends a command on the connection of the transaction, and returns it's reply
+func (tx *Tx) Do(ctx context.Context, args ...any) (any, error) {
+	value, err := tx.cn.do(ctx, args)
+	if err != nil {
+		tx.broken = true
+		return nil, err
+	}
+	return replyOf(value)
+}
+
+// Get returns the value of the key, or ErrNil if it does not exist
+func (tx *Tx) Get(ctx context.Context, key string) ([]byte, error) {
+	reply, err := tx.Do(ctx, "GET", key)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(reply.(string)), nil
+}
+
+// Pipeline returns an empty pipeline, whose commands are run atomically by Exec
+func (tx *Tx) Pipeline() *Pipeline {
+	return &Pipeline{client: tx.client, tx: tx}
+}
+
+// exec runs the commands with MULTI / EXEC
+func (tx *Tx) exec(ctx context.Context, commands [][]any) ([]Result, error) {
+	wrapped := make([][]any, 0, len(commands)+2)
+	wrapped = append(wrapped, []any{"MULTI"})
+	wrapped = append(wrapped, commands...)
+	wrapped = append(wrapped, []any{"EXEC"})
+	replies, err := tx.cn.roundTrip(ctx, wrapped)
+	if err != nil {
+		tx.broken = true
+		return nil, err
+	}
+	// A command that could not be queued aborts the transaction, EXEC then fails with EXECABORT
+	for _, reply := range replies[:len(replies)-1] {
+		if reply.Type == resp.ValueTypeSimpleError {
+			_, err := replyOf(reply)
+			return nil, err
+		}
+	}
+	exec := replies[len(replies)-1]
+	switch exec.Type {
+	case resp.ValueTypeNull:
+		return nil, ErrTxFailed
+	case resp.ValueTypeArray:
+		return results(exec.Array), nil
+	}
+	if _, err := replyOf(exec); err != nil {
+		return nil, err
+	}
+	return nil, fmt.Errorf("%w: unexpected EXEC reply", resp.ErrProtocolError)
+}
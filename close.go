@@ -0,0 +1,140 @@
+package kvdb
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+/*
+Closing a datastore
+
+Close can be called at any time, from any goroutine, and any number of times. It closes the datastore in three steps:
+
+ 1. New operations are rejected with ErrClosed (ErrStoreClosed is the same error). Running merges are cancelled (a
+    merge that is already renaming its files runs to completion), and LogTailer.Next calls that are waiting for a
+    record return ErrClosed
+ 2. Close waits for the operations that were already running to finish
+ 3. The counters are written to the stats file (if Options.StatsFlushInterval is set), the active data file is
+    synced, and all files are closed. A failed step does not stop the next ones, their errors are returned joined
+
+CloseContext bounds the wait in step 2. If ctx is done first, it returns the context's error without closing the files,
+since the running operations are still using them. The datastore keeps rejecting new operations, and Close can be
+called again to finish closing it. Once step 3 has run (even if it failed), Close returns nil.
+
+Every exported method that reads the data files or changes the datastore runs between closeGate.enter and
+closeGate.exit. Methods that only read in memory state (Stats, Size, OpenReport, Watch) also work after Close. A
+DataStore that was not returned by Create or Open (the zero value) is treated as closed: it's operations return
+ErrClosed, Close does nothing, Stats returns ErrClosed, and the other in memory methods return zero values
+*/
+
+// closeGate tracks the operations in flight, so that Close can wait for them
+type closeGate struct {
+	mu       sync.RWMutex
+	closing  bool
+	inFlight sync.WaitGroup
+	// Cancelled when Close is called, merges and tailers stop when it's done
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	// Held while the files are closed, so that they are only closed once
+	closeMu sync.Mutex
+	closed  bool
+}
+
+// opened returns false for the zero value DataStore, which has no files or keydir, see above
+func (dataStore *DataStore) opened() bool {
+	return dataStore.gate != nil
+}
+
+func newCloseGate() *closeGate {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &closeGate{ctx: ctx, cancel: cancel}
+}
+
+// enter registers an operation, it returns ErrClosed once Close has been called, or if the gate is nil (the datastore
+// was never opened). exit must be called when the operation is done
+func (g *closeGate) enter() error {
+	if g == nil {
+		return ErrClosed
+	}
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	if g.closing {
+		return ErrClosed
+	}
+	g.inFlight.Add(1)
+	return nil
+}
+
+func (g *closeGate) exit() {
+	g.inFlight.Done()
+}
+
+// shut rejects new operations, and cancels ctx
+func (g *closeGate) shut() {
+	g.mu.Lock()
+	g.closing = true
+	g.mu.Unlock()
+	g.cancel()
+}
+
+// wait waits for the operations in flight to finish, or until ctx is done
+func (g *closeGate) wait(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		g.inFlight.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Close closes the datastore, writes pending changes (if any), and frees resources. It waits for the operations that
+// are running, see CloseContext
+func (dataStore *DataStore) Close() error {
+	return dataStore.CloseContext(context.Background())
+}
+
+// CloseContext is like Close, but stops waiting for running operations when ctx is done, and returns the context's
+// error. The datastore is not usable after CloseContext has been called, even if it returned an error. The files are
+// closed even if writing the pending changes fails, see close.go
+func (dataStore *DataStore) CloseContext(ctx context.Context) error {
+	gate := dataStore.gate
+	if gate == nil {
+		return nil
+	}
+	gate.shut()
+	if err := gate.wait(ctx); err != nil {
+		return err
+	}
+
+	gate.closeMu.Lock()
+	defer gate.closeMu.Unlock()
+	if gate.closed {
+		return nil
+	}
+	gate.closed = true
+	if dataStore.committer != nil {
+		dataStore.committer.close()
+	}
+	var err error
+	if dataStore.statsFlusher != nil {
+		err = errors.Join(err, dataStore.statsFlusher.close())
+	}
+	<-dataStore.manifestStopped
+	err = errors.Join(err, dataStore.writeManifest())
+	dataStore.mu.Lock()
+	defer dataStore.mu.Unlock()
+	err = errors.Join(err, dataStore.fileManager.Sync())
+	err = errors.Join(err, dataStore.fileManager.Close())
+	if dataStore.inMemory {
+		// Nothing can open the files again, free them even if the datastore is still referenced
+		dataStore.fs.RemoveAll(dataStore.path)
+	}
+	return err
+}
@@ -0,0 +1,360 @@
+package kvdb
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/spf13/afero"
+)
+
+func TestCloseRejectsOperations(t *testing.T) {
+	store, err := Create(afero.NewMemMapFs(), "test_close_rejects.db")
+	if err != nil {
+		t.Fatalf("error creating datastore: %v", err)
+	}
+	store.Put([]byte("key"), []byte("value"))
+	if err := store.Close(); err != nil {
+		t.Fatalf("close failed: %v", err)
+	}
+	if err := store.Close(); err != nil {
+		t.Errorf("expected a second close to succeed, got %v", err)
+	}
+
+	if _, err := store.Get([]byte("key")); !errors.Is(err, ErrClosed) {
+		t.Errorf("expected ErrClosed from Get, got %v", err)
+	}
+	if err := store.Put([]byte("key"), []byte("value")); !errors.Is(err, ErrClosed) {
+		t.Errorf("expected ErrClosed from Put, got %v", err)
+	}
+	if err := store.Delete([]byte("key")); !errors.Is(err, ErrClosed) {
+		t.Errorf("expected ErrClosed from Delete, got %v", err)
+	}
+	if err := store.Merge(); !errors.Is(err, ErrClosed) {
+		t.Errorf("expected ErrClosed from Merge, got %v", err)
+	}
+	if err := store.Sync(); !errors.Is(err, ErrClosed) {
+		t.Errorf("expected ErrClosed from Sync, got %v", err)
+	}
+	if _, _, err := store.ListKeysPage("", 10); !errors.Is(err, ErrClosed) {
+		t.Errorf("expected ErrClosed from ListKeysPage, got %v", err)
+	}
+	// In memory state is still available
+	if store.Size() != 1 {
+		t.Errorf("expected Size to work after close, got %d", store.Size())
+	}
+	if _, err := store.Stats(); err != nil {
+		t.Errorf("expected Stats to work after close, got %v", err)
+	}
+}
+
+func TestCloseWaitsForOperations(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	entered := make(chan struct{})
+	release := make(chan struct{})
+	block := WriteInterceptor{Name: "block", Before: func(req *WriteRequest) error {
+		if string(req.Key) == "slow" {
+			close(entered)
+			<-release
+		}
+		return nil
+	}}
+	store, err := CreateWithOptions(fs, "test_close_waits.db", &Options{Interceptors: []WriteInterceptor{block}})
+	if err != nil {
+		t.Fatalf("error creating datastore: %v", err)
+	}
+	putDone := make(chan error)
+	go func() { putDone <- store.Put([]byte("slow"), []byte("value")) }()
+	<-entered
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := store.CloseContext(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected the close to time out while the put is running, got %v", err)
+	}
+	if _, err := store.Get([]byte("other")); !errors.Is(err, ErrClosed) {
+		t.Errorf("expected new operations to be rejected after a timed out close, got %v", err)
+	}
+
+	closeDone := make(chan error)
+	go func() { closeDone <- store.Close() }()
+	select {
+	case err := <-closeDone:
+		t.Fatalf("expected close to wait for the put, returned %v", err)
+	case <-time.After(20 * time.Millisecond):
+	}
+	close(release)
+	if err := <-putDone; err != nil {
+		t.Errorf("expected the running put to succeed, got %v", err)
+	}
+	if err := <-closeDone; err != nil {
+		t.Errorf("close failed: %v", err)
+	}
+
+	store, err = Open(fs, "test_close_waits.db")
+	if err != nil {
+		t.Fatalf("error opening datastore: %v", err)
+	}
+	defer store.Close()
+	if value, err := store.Get([]byte("slow")); err != nil || string(value) != "value" {
+		t.Errorf("expected the put to be written before close, got %q (err: %v)", value, err)
+	}
+}
+
+func TestCloseCancelsMergeAndTailers(t *testing.T) {
+	store, err := Create(afero.NewMemMapFs(), "test_close_cancels.db")
+	if err != nil {
+		t.Fatalf("error creating datastore: %v", err)
+	}
+	store.Put([]byte("key"), []byte("value"))
+	tailer, err := store.TailLog(1, 0)
+	if err != nil {
+		t.Fatalf("tail failed: %v", err)
+	}
+	if _, err := tailer.Next(context.Background()); err != nil {
+		t.Fatalf("expected a record, got %v", err)
+	}
+
+	// The merge waits for the merge lock until the datastore is closed
+	store.mergeLock.Lock()
+	defer store.mergeLock.Unlock()
+	mergeDone := make(chan error)
+	go func() { mergeDone <- store.Merge() }()
+	tailDone := make(chan error)
+	go func() {
+		_, err := tailer.Next(context.Background())
+		tailDone <- err
+	}()
+	time.Sleep(20 * time.Millisecond)
+
+	if err := store.Close(); err != nil {
+		t.Fatalf("close failed: %v", err)
+	}
+	if err := <-mergeDone; !errors.Is(err, ErrClosed) {
+		t.Errorf("expected the merge to return ErrClosed, got %v", err)
+	}
+	if err := <-tailDone; !errors.Is(err, ErrClosed) {
+		t.Errorf("expected the tailer to return ErrClosed, got %v", err)
+	}
+}
+
+func TestCloseRejectsEveryOperation(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	store, err := Create(fs, "test_close_every.db")
+	if err != nil {
+		t.Fatalf("error creating datastore: %v", err)
+	}
+	store.Put([]byte("key"), []byte("value"))
+	store.Hash([]byte("hash")).Set([]byte("field"), []byte("value"))
+	lease, err := store.AcquireLease([]byte("lease"), time.Minute)
+	if err != nil {
+		t.Fatalf("acquire failed: %v", err)
+	}
+	tailer, _ := store.TailLog(0, 0)
+	store.Close()
+
+	key, value := []byte("key"), []byte("value")
+	operations := map[string]func() error{
+		"Get":             func() error { _, err := store.Get(key); return err },
+		"GetInto":         func() error { _, err := store.GetInto(key, nil); return err },
+		"Put":             func() error { return store.Put(key, value) },
+		"PutWithOptions":  func() error { return store.PutWithOptions(key, value, &PutOptions{WriteOnce: true}) },
+		"PutWithPrevious": func() error { _, err := store.PutWithPrevious(key, value); return err },
+		"PutIfAbsent":     func() error { _, err := store.PutIfAbsent(key, value); return err },
+		"PutBatch":        func() error { _, err := store.PutBatch([]KeyValue{{Key: key, Value: value}}); return err },
+		"PutReader":       func() error { return store.PutReader(key, bytes.NewReader(value), 5) },
+		"PutVersion":      func() error { _, err := store.PutVersion(key, value, 1); return err },
+		"GetVersion":      func() error { _, _, err := store.GetVersion(key); return err },
+		"GetReader":       func() error { _, _, err := store.GetReader(key); return err },
+		"GetDelete":       func() error { _, err := store.GetDelete(key); return err },
+		"GetSet":          func() error { _, _, err := store.GetSet(key, value); return err },
+		"Delete":          func() error { return store.Delete(key) },
+		"DeleteWithExists": func() error {
+			_, err := store.DeleteWithExists(key)
+			return err
+		},
+		"ListKeys":     func() error { _, err := store.ListKeys(); return err },
+		"RandomKey":    func() error { _, err := store.RandomKey(); return err },
+		"Scan":         func() error { return store.Scan(nil, func(ScanEntry) error { return nil }) },
+		"Type":         func() error { _, err := store.Type(key); return err },
+		"PutJSON":      func() error { return store.PutJSON(key, 1) },
+		"GetJSON":      func() error { var v any; return store.GetJSON(key, &v) },
+		"PatchJSON":    func() error { return store.PatchJSON(key, "/a", 1) },
+		"PutContent":   func() error { _, err := store.PutContent(value); return err },
+		"HashGet":      func() error { _, err := store.Hash([]byte("hash")).Get([]byte("field")); return err },
+		"ListPushBack": func() error { _, err := store.List([]byte("list")).PushBack(value); return err },
+		"SetAdd":       func() error { _, err := store.Set([]byte("set")).Add(value); return err },
+		"AcquireLease": func() error { _, err := store.AcquireLease([]byte("other"), time.Minute); return err },
+		"LeaseRenew":   func() error { return lease.Renew(time.Minute) },
+		"LeaseRelease": func() error { return lease.Release() },
+		"TailLogNext":  func() error { _, err := tailer.Next(context.Background()); return err },
+		"Merge":        func() error { return store.Merge() },
+		"Sync":         func() error { return store.Sync() },
+		"Verify":       func() error { _, err := store.Verify(); return err },
+		"FileStats":    func() error { _, err := store.FileStats(); return err },
+		"GenerateHints": func() error {
+			_, err := store.GenerateHints()
+			return err
+		},
+		"ExpireDataFiles":   func() error { _, err := store.ExpireDataFiles(time.Hour); return err },
+		"ExportSnapshotDir": func() error { return store.ExportSnapshotDir("snapshot") },
+		"AdoptFile":         func() error { return store.AdoptFile("other.dat") },
+	}
+	for name, operation := range operations {
+		if err := operation(); !errors.Is(err, ErrStoreClosed) {
+			t.Errorf("%s: expected ErrStoreClosed, got %v", name, err)
+		}
+	}
+}
+
+func TestConcurrentClose(t *testing.T) {
+	store, err := CreateWithOptions(afero.NewMemMapFs(), "test_concurrent_close.db", &Options{MaxRecordsPerFile: 50})
+	if err != nil {
+		t.Fatalf("error creating datastore: %v", err)
+	}
+	// Operations that are running when the datastore is closed either complete, or return ErrClosed
+	var wg sync.WaitGroup
+	errs := make(chan error, 64)
+	for worker := range 8 {
+		wg.Go(func() {
+			for i := 0; ; i++ {
+				key := fmt.Appendf(nil, "key%d-%d", worker, i%100)
+				var err error
+				switch i % 5 {
+				case 0, 1:
+					err = store.Put(key, key)
+				case 2:
+					_, err = store.Get(key)
+					if errors.Is(err, ErrKeyNotFound) {
+						err = nil
+					}
+				case 3:
+					err = store.Delete(key)
+				case 4:
+					if worker == 0 {
+						err = store.Merge()
+					} else {
+						_, err = store.ListKeys()
+					}
+				}
+				if errors.Is(err, ErrClosed) {
+					return
+				}
+				if err != nil {
+					errs <- fmt.Errorf("worker %d: %w", worker, err)
+					return
+				}
+			}
+		})
+	}
+	time.Sleep(50 * time.Millisecond)
+	closeErrs := make(chan error, 4)
+	for range 4 {
+		go func() { closeErrs <- store.Close() }()
+	}
+	for range 4 {
+		if err := <-closeErrs; err != nil {
+			t.Errorf("close failed: %v", err)
+		}
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Errorf("expected the operations to complete or return ErrClosed, got %v", err)
+	}
+}
+
+// failingCreateFs fails to create files named name once fail is set
+type failingCreateFs struct {
+	afero.Fs
+	name string
+	fail atomic.Bool
+}
+
+func (fs *failingCreateFs) Create(name string) (afero.File, error) {
+	if fs.fail.Load() && filepath.Base(name) == fs.name {
+		return nil, errors.New("create failed")
+	}
+	return fs.Fs.Create(name)
+}
+
+func TestCloseAfterFailure(t *testing.T) {
+	fs := &failingCreateFs{Fs: afero.NewMemMapFs(), name: manifestFileName + ".tmp"}
+	store, err := Create(fs, "close_failure.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.Put([]byte("key"), []byte("value"))
+
+	// The manifest can't be written, the data file is still synced and closed
+	fs.fail.Store(true)
+	if err := store.Close(); err == nil {
+		t.Fatal("expected the error of the manifest")
+	}
+	if open := store.fileManager.OpenFiles(); open != 0 {
+		t.Errorf("expected every file to be closed, %d are open", open)
+	}
+	if err := store.Put([]byte("key"), []byte("other")); !errors.Is(err, ErrClosed) {
+		t.Errorf("expected ErrClosed, got %v", err)
+	}
+	if err := store.Close(); err != nil {
+		t.Errorf("expected a second Close to have nothing to do, got %v", err)
+	}
+
+	fs.fail.Store(false)
+	store, err = Open(fs, "close_failure.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	if value, err := store.Get([]byte("key")); err != nil || string(value) != "value" {
+		t.Errorf("expected the value written before Close, got %q, %v", value, err)
+	}
+}
+
+func TestUnopenedDataStore(t *testing.T) {
+	var store DataStore
+	if _, err := store.Get([]byte("key")); !errors.Is(err, ErrClosed) {
+		t.Errorf("expected ErrClosed from Get, got %v", err)
+	}
+	if err := store.Put([]byte("key"), []byte("value")); !errors.Is(err, ErrClosed) {
+		t.Errorf("expected ErrClosed from Put, got %v", err)
+	}
+	if err := store.Close(); err != nil {
+		t.Errorf("expected close to do nothing, got %v", err)
+	}
+
+	// The methods that don't go through the gate return zero values instead of panicking
+	if _, err := store.Stats(); !errors.Is(err, ErrClosed) {
+		t.Errorf("expected ErrClosed from Stats, got %v", err)
+	}
+	if size := store.Size(); size != 0 {
+		t.Errorf("expected a size of 0, got %d", size)
+	}
+	if store.MaxKeySize() != 0 || store.MaxValueSize() != 0 || store.ReclaimableBytes() != 0 {
+		t.Errorf("expected zero limits and reclaimable bytes")
+	}
+	if active, merged, hint := store.DataDirs(); active != "" || merged != "" || hint != "" {
+		t.Errorf("expected no data directories, got %q, %q, %q", active, merged, hint)
+	}
+	if report := store.OpenReport(); report.ReusedDataFile != 0 || len(report.UnknownFiles) != 0 {
+		t.Errorf("expected an empty open report, got %+v", report)
+	}
+	if err := store.Merge(); !errors.Is(err, ErrClosed) {
+		t.Errorf("expected ErrClosed from Merge, got %v", err)
+	}
+	tailer, err := store.TailLog(0, 0)
+	if err != nil {
+		t.Fatalf("tail log failed: %v", err)
+	}
+	if _, err := tailer.Next(context.Background()); !errors.Is(err, ErrClosed) {
+		t.Errorf("expected ErrClosed from the tailer, got %v", err)
+	}
+	store.Watch(func(WatchEvent) {})()
+}
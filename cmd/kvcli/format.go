@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+/*
+Values are printed in one of the output modes, so that binary values don't garble the terminal. The mode of a single
+lookup is picked with \get <key> --json | --hex | --base64 | --raw, and the mode of plain <key> lookups and \scan with
+\output <mode>:
+
+  - auto (the default): text is printed as it is. Values that are not valid UTF-8, or have control characters other
+    than tabs and newlines (escape sequences, NUL bytes), are printed as a hex dump
+  - json: the value is pretty printed, values that are not valid JSON are printed as in auto mode, with a note
+  - hex: a hex dump, with offsets and the printable characters, as printed by hexdump -C
+  - base64: standard base64, so that the value can be copied and decoded elsewhere
+  - raw: the bytes are written to the terminal unchanged
+*/
+
+type outputMode string
+
+const (
+	outputAuto   outputMode = "auto"
+	outputJSON   outputMode = "json"
+	outputHex    outputMode = "hex"
+	outputBase64 outputMode = "base64"
+	outputRaw    outputMode = "raw"
+)
+
+// parseOutputMode returns the output mode with the name, with or without the leading --
+func parseOutputMode(name string) (outputMode, error) {
+	mode := outputMode(strings.TrimPrefix(name, "--"))
+	switch mode {
+	case outputAuto, outputJSON, outputHex, outputBase64, outputRaw:
+		return mode, nil
+	}
+	return "", fmt.Errorf("unknown output mode %q, expected one of auto, json, hex, base64, raw", name)
+}
+
+// isPrintable returns true if the value is UTF-8 text without control characters other than tabs and newlines
+func isPrintable(value []byte) bool {
+	if !utf8.Valid(value) {
+		return false
+	}
+	for _, r := range string(value) {
+		if r != '\n' && r != '\t' && !unicode.IsPrint(r) {
+			return false
+		}
+	}
+	return true
+}
+
+// formatValue returns the value as it's printed in the output mode
+func formatValue(value []byte, mode outputMode) string {
+	switch mode {
+	case outputRaw:
+		return string(value)
+	case outputHex:
+		return strings.TrimSuffix(hex.Dump(value), "\n")
+	case outputBase64:
+		return base64.StdEncoding.EncodeToString(value)
+	case outputJSON:
+		var b bytes.Buffer
+		if err := json.Indent(&b, value, "", "  "); err != nil {
+			return formatValue(value, outputAuto) + "\n(not valid JSON: " + err.Error() + ")"
+		}
+		return b.String()
+	}
+	if isPrintable(value) {
+		return string(value)
+	}
+	return fmt.Sprintf("(binary, %d bytes)\n%s", len(value), formatValue(value, outputHex))
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestFormatValue(t *testing.T) {
+	tests := []struct {
+		name  string
+		value string
+		mode  outputMode
+		want  string
+	}{
+		{"text", "hello\tworld\n", outputAuto, "hello\tworld\n"},
+		{"unicode", "héllo, 世界", outputAuto, "héllo, 世界"},
+		{"binary", "\x00\xff", outputAuto, "(binary, 2 bytes)\n00000000  00 ff                                             |..|"},
+		{"escape sequence", "\x1b[2J", outputAuto, "(binary, 4 bytes)\n00000000  1b 5b 32 4a                                       |.[2J|"},
+		{"raw", "\x1b[2J", outputRaw, "\x1b[2J"},
+		{"base64", "\x00\xff", outputBase64, "AP8="},
+		{"hex", "ab", outputHex, "00000000  61 62                                             |ab|"},
+		{"json", `{"a":[1,2]}`, outputJSON, "{\n  \"a\": [\n    1,\n    2\n  ]\n}"},
+	}
+	for _, test := range tests {
+		if got := formatValue([]byte(test.value), test.mode); got != test.want {
+			t.Errorf("%s: expected %q, got %q", test.name, test.want, got)
+		}
+	}
+	if got := formatValue([]byte("not json"), outputJSON); !strings.HasPrefix(got, "not json\n(not valid JSON") {
+		t.Errorf("expected invalid JSON to be printed with a note, got %q", got)
+	}
+}
+
+func TestParseOutputMode(t *testing.T) {
+	if mode, err := parseOutputMode("--hex"); err != nil || mode != outputHex {
+		t.Errorf("expected hex, got %q (err: %v)", mode, err)
+	}
+	if mode, err := parseOutputMode("json"); err != nil || mode != outputJSON {
+		t.Errorf("expected json, got %q (err: %v)", mode, err)
+	}
+	if _, err := parseOutputMode("--yaml"); err == nil {
+		t.Errorf("expected an error for an unknown mode")
+	}
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"bufio"
+	"context"
 	"fmt"
 	"os"
 	"sort"
@@ -27,26 +28,26 @@ func main() {
 	}
 
 	filePath := os.Args[1]
-	var fs afero.Fs
-	if os.Args[1] == ":memory" {
-		fs = afero.NewMemMapFs()
-		filePath = "in-memory-" + time.Now().Format(time.RFC3339) + "-db"
-	} else {
-		fs = afero.NewOsFs()
-	}
 	fmt.Printf("Opened datastore %s\n", filePath)
 
 	start := time.Now()
-	store, err := kvdb.Open(fs, filePath)
-	if err != nil {
-		fmt.Println(err)
-		// Try creating it
-		store, err = kvdb.Create(fs, filePath)
+	var store *kvdb.DataStore
+	var err error
+	if filePath == ":memory" {
+		store, err = kvdb.CreateInMemory(nil)
+	} else {
+		fs := afero.NewOsFs()
+		store, err = kvdb.Open(fs, filePath)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "(error) CREATE: %s", err)
-			os.Exit(1)
+			fmt.Println(err)
+			// Try creating it
+			store, err = kvdb.Create(fs, filePath)
 		}
 	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "(error) CREATE: %s", err)
+		os.Exit(1)
+	}
 	openDuration := time.Since(start)
 	fmt.Printf("(took %s to open/create)\n", openDuration)
 	defer store.Close()
@@ -54,8 +55,13 @@ func main() {
 	fmt.Println("Welcome to kvdb cli, type \"exit\" to quit")
 	// TODO: NOTE: Cannot set/get a key called \key, introduce escape sequence or quotes "" to avoid this
 	fmt.Println("To set a value, use <key>=<value>, to retrieve a value just type <key>, to get all keys type \\keys, to delete a key \\delete <key>")
+	fmt.Println("To compact the datastore, use \\merge (\\merge --dry-run shows what a merge would do without merging)")
+	fmt.Println("To check the data files against the in-memory index, use \\verify")
+	fmt.Println("To export all keys to a file, use \\export <file>, and to load them back, \\import <file> (.csv files are CSV, anything else is NDJSON)")
+	fmt.Println("To print a value as JSON, hex or base64, use \\get <key> --json | --hex | --base64 | --raw, and \\output <mode> to change how values are printed (auto prints binary values as a hex dump)")
 	fmt.Println("Note: Spaces matter, so key =value is different from key=value")
 	fmt.Print("> ")
+	mode := outputAuto
 	scanner := bufio.NewScanner(os.Stdin)
 	for scanner.Scan() {
 		query := scanner.Text()
@@ -121,17 +127,26 @@ func main() {
 			}
 			output = "Seeding completed"
 		case "\\merge":
-			go func(startTs time.Time) {
-				startTime := time.Now()
-				err := store.Merge()
-				duration := time.Since(startTime)
-				if err == nil {
-					fmt.Printf("MERGE OK (took %s)\n> ", duration)
-				} else {
-					fmt.Println("MERGE ERR", err)
-				}
-			}(start)
-			output = "PENDING"
+			// The merge runs in the foreground, so that it's progress can be shown
+			if err := mergeWithProgress(store); err != nil {
+				output = fmt.Sprintf("MERGE ERR %s", err)
+			} else {
+				output = "MERGE OK"
+			}
+		case "\\merge --dry-run":
+			estimate, err := store.EstimateMerge()
+			if err != nil {
+				output = fmt.Sprintf("(error) \\merge --dry-run: %s", err)
+				break
+			}
+			output = formatMergeEstimate(estimate)
+		case "\\verify":
+			report, err := store.Verify()
+			if err != nil {
+				output = fmt.Sprintf("(error) \\verify: %s", err)
+				break
+			}
+			output = formatVerifyReport(report)
 		case "\\scan":
 			keys, err := store.ListKeys()
 			if err != nil {
@@ -145,11 +160,57 @@ func main() {
 				if err != nil {
 					values = append(values, fmt.Sprintf("(error) GET %s: %s", key, err))
 				} else {
-					values = append(values, fmt.Sprintf("%s=%s", key, value))
+					values = append(values, fmt.Sprintf("%s=%s", key, formatValue(value, mode)))
 				}
 			}
 			output = strings.Join(values, "\n")
 		default:
+			if path, ok := strings.CutPrefix(query, "\\export "); ok {
+				count, err := exportKeys(store, path)
+				if err != nil {
+					output = fmt.Sprintf("(error) \\export: %s (%d keys exported)", err, count)
+				} else {
+					output = fmt.Sprintf("exported %d keys to %s", count, path)
+				}
+				break
+			}
+			if path, ok := strings.CutPrefix(query, "\\import "); ok {
+				count, err := importKeys(store, path)
+				if err != nil {
+					output = fmt.Sprintf("(error) \\import: %s (%d keys imported)", err, count)
+				} else {
+					output = fmt.Sprintf("imported %d keys from %s", count, path)
+				}
+				break
+			}
+			if name, ok := strings.CutPrefix(query, "\\output "); ok {
+				newMode, err := parseOutputMode(name)
+				if err != nil {
+					output = fmt.Sprintf("(error) \\output: %s", err)
+				} else {
+					mode, output = newMode, "OK"
+				}
+				break
+			}
+			if args, ok := strings.CutPrefix(query, "\\get "); ok {
+				// The mode is the last argument, so that keys with spaces can be read
+				getMode := mode
+				if i := strings.LastIndex(args, " --"); i >= 0 {
+					newMode, err := parseOutputMode(args[i+1:])
+					if err != nil {
+						output = fmt.Sprintf("(error) \\get: %s", err)
+						break
+					}
+					args, getMode = args[:i], newMode
+				}
+				value, err := store.Get([]byte(args))
+				if err != nil {
+					output = fmt.Sprintf("(error) GET: %s", err)
+				} else {
+					output = formatValue(value, getMode)
+				}
+				break
+			}
 			if after, ok := strings.CutPrefix(query, "\\delete "); ok {
 				key := after
 				err := store.Delete([]byte(key))
@@ -177,7 +238,7 @@ func main() {
 				if err != nil {
 					output = fmt.Sprintf("(error) GET: %s", err)
 				} else {
-					output = string(op)
+					output = formatValue(op, mode)
 				}
 			}
 		}
@@ -187,3 +248,57 @@ func main() {
 		fmt.Print("> ")
 	}
 }
+
+// mergeWithProgress merges the datastore, and shows the progress of the merge
+func mergeWithProgress(store *kvdb.DataStore) error {
+	// The size of the files to merge is only known once the merge has started
+	bar := newProgress("merge", 0, 0)
+	var last kvdb.MergeProgress
+	err := store.MergeWithOptions(context.Background(), &kvdb.MergeOptions{Progress: func(p kvdb.MergeProgress) {
+		bar.totalBytes = p.TotalBytes
+		bar.update(p.ScannedRecords, p.ScannedBytes)
+		last = p
+	}})
+	bar.finish(last.ScannedRecords, last.ScannedBytes)
+	return err
+}
+
+// formatMergeEstimate returns a table of the files that would be merged, followed by the totals
+func formatMergeEstimate(estimate kvdb.MergeEstimate) string {
+	if len(estimate.Files) == 0 {
+		return "Nothing to merge, there are no immutable data files"
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "%-12s %14s %10s %14s %14s %8s\n", "FILE", "SIZE", "LIVE KEYS", "LIVE BYTES", "GARBAGE", "GARBAGE%")
+	for _, file := range estimate.Files {
+		garbagePercent := 0.0
+		if file.Size > 0 {
+			garbagePercent = float64(file.GarbageBytes()) * 100 / float64(file.Size)
+		}
+		fmt.Fprintf(&b, "%-12d %14d %10d %14d %14d %7.1f%%\n", file.Id, file.Size, file.LiveKeys, file.LiveBytes, file.GarbageBytes(), garbagePercent)
+	}
+	fmt.Fprintf(&b, "Files to merge:     %d\n", len(estimate.Files))
+	fmt.Fprintf(&b, "Input bytes:        %d\n", estimate.InputBytes)
+	fmt.Fprintf(&b, "Output bytes:       %d (%d keys)\n", estimate.OutputBytes, estimate.LiveKeys)
+	fmt.Fprintf(&b, "Reclaimed bytes:    %d\n", estimate.ReclaimedBytes)
+	fmt.Fprintf(&b, "Estimated duration: %s", estimate.EstimatedDuration)
+	return b.String()
+}
+
+// formatVerifyReport returns the problems found by Verify, followed by the totals
+func formatVerifyReport(report *kvdb.VerifyReport) string {
+	var b strings.Builder
+	for _, fileErr := range report.FileErrors {
+		fmt.Fprintf(&b, "problem: %s\n", fileErr)
+	}
+	for _, d := range report.Discrepancies {
+		fmt.Fprintf(&b, "problem: %s\n", d)
+	}
+	fmt.Fprintf(&b, "Checked %d records in %d data files against %d keys: ", report.Records, report.Files, report.Keys)
+	if report.OK() {
+		b.WriteString("OK")
+	} else {
+		fmt.Fprintf(&b, "%d problem(s) found", len(report.FileErrors)+len(report.Discrepancies))
+	}
+	return b.String()
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// Width of the bar drawn on terminals, in characters
+const progressBarWidth = 30
+
+// How often progress is redrawn on a terminal, and printed as a new line otherwise (for example when the output is
+// piped to a log file)
+const (
+	progressRedrawInterval = 100 * time.Millisecond
+	progressLogInterval    = 5 * time.Second
+)
+
+// progress reports the progress of a long operation (records processed, bytes, and an ETA) on stderr, so that it's not
+// mixed with the output of the command. On a terminal a bar is redrawn in place, otherwise a line is printed every
+// progressLogInterval. Nothing is printed for operations that finish before the first report
+type progress struct {
+	out   io.Writer
+	label string
+	// Totals the ETA is computed from, the byte total is used if it's known, 0 if a total is not known
+	totalRecords int64
+	totalBytes   int64
+	interactive  bool
+	now          func() time.Time
+	start        time.Time
+	lastReport   time.Time
+	reported     bool
+}
+
+func newProgress(label string, totalRecords, totalBytes int64) *progress {
+	return newProgressTo(os.Stderr, isTerminal(os.Stderr), label, totalRecords, totalBytes)
+}
+
+func newProgressTo(out io.Writer, interactive bool, label string, totalRecords, totalBytes int64) *progress {
+	now := time.Now()
+	return &progress{
+		out:          out,
+		label:        label,
+		totalRecords: totalRecords,
+		totalBytes:   totalBytes,
+		interactive:  interactive,
+		now:          time.Now,
+		start:        now,
+		lastReport:   now,
+	}
+}
+
+// isTerminal returns true if the file is a terminal (a character device)
+func isTerminal(file *os.File) bool {
+	info, err := file.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// update reports the records and bytes processed so far, if enough time has passed since the last report
+func (p *progress) update(records, bytes int64) {
+	interval := progressLogInterval
+	if p.interactive {
+		interval = progressRedrawInterval
+	}
+	now := p.now()
+	if now.Sub(p.lastReport) < interval {
+		return
+	}
+	p.lastReport = now
+	p.reported = true
+	if p.interactive {
+		// Pad the line, so that a shorter line overwrites all of the previous one
+		fmt.Fprintf(p.out, "\r%-100s", p.line(records, bytes, now))
+	} else {
+		fmt.Fprintln(p.out, p.line(records, bytes, now))
+	}
+}
+
+// finish ends the bar with the final counts, if progress was reported
+func (p *progress) finish(records, bytes int64) {
+	if !p.reported {
+		return
+	}
+	if p.interactive {
+		fmt.Fprintf(p.out, "\r%-100s\n", p.line(records, bytes, p.now()))
+	}
+}
+
+// line formats the progress at the given time
+func (p *progress) line(records, bytes int64, now time.Time) string {
+	fraction := -1.0
+	switch {
+	case p.totalBytes > 0:
+		fraction = float64(bytes) / float64(p.totalBytes)
+	case p.totalRecords > 0:
+		fraction = float64(records) / float64(p.totalRecords)
+	}
+	counts := fmt.Sprintf("%d records, %s", records, formatBytes(bytes))
+	if p.totalBytes > 0 {
+		counts = fmt.Sprintf("%d records, %s / %s", records, formatBytes(bytes), formatBytes(p.totalBytes))
+	} else if p.totalRecords > 0 {
+		counts = fmt.Sprintf("%d / %d records, %s", records, p.totalRecords, formatBytes(bytes))
+	}
+	if fraction < 0 {
+		return fmt.Sprintf("%s: %s", p.label, counts)
+	}
+	fraction = min(fraction, 1)
+	filled := int(fraction * progressBarWidth)
+	bar := strings.Repeat("=", filled) + strings.Repeat(" ", progressBarWidth-filled)
+	eta := "--"
+	if elapsed := now.Sub(p.start); fraction > 0 {
+		eta = time.Duration(float64(elapsed) * (1 - fraction) / fraction).Round(time.Second).String()
+	}
+	return fmt.Sprintf("%s [%s] %5.1f%% %s, ETA %s", p.label, bar, fraction*100, counts, eta)
+}
+
+// formatBytes formats a byte count with a binary unit
+func formatBytes(bytes int64) string {
+	const unit = 1024
+	if bytes < unit {
+		return fmt.Sprintf("%d B", bytes)
+	}
+	value := float64(bytes)
+	for _, suffix := range []string{"KiB", "MiB", "GiB", "TiB"} {
+		value /= unit
+		if value < unit || suffix == "TiB" {
+			return fmt.Sprintf("%.1f %s", value, suffix)
+		}
+	}
+	return ""
+}
+
+// countingReader counts the bytes read through it
+type countingReader struct {
+	reader io.Reader
+	n      int64
+}
+
+func (r *countingReader) Read(buf []byte) (int, error) {
+	n, err := r.reader.Read(buf)
+	r.n += int64(n)
+	return n, err
+}
+
+// countingWriter counts the bytes written through it
+type countingWriter struct {
+	writer io.Writer
+	n      int64
+}
+
+func (w *countingWriter) Write(buf []byte) (int, error) {
+	n, err := w.writer.Write(buf)
+	w.n += int64(n)
+	return n, err
+}
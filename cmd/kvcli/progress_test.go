@@ -0,0 +1,81 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+// helperFakeClock makes the progress read the time from the returned pointer
+func helperFakeClock(p *progress) *time.Time {
+	now := p.start
+	p.now = func() time.Time { return now }
+	return &now
+}
+
+func TestProgressLine(t *testing.T) {
+	var out bytes.Buffer
+	p := newProgressTo(&out, true, "import", 0, 4096)
+	now := helperFakeClock(p)
+
+	// Nothing is printed before the first redraw interval
+	p.update(10, 100)
+	if out.Len() != 0 {
+		t.Errorf("expected no output, got %q", out.String())
+	}
+
+	// A quarter of the bytes in 10s, so 30s are left
+	*now = now.Add(10 * time.Second)
+	p.update(250, 1024)
+	line := out.String()
+	for _, want := range []string{"\rimport [=======                       ]  25.0%", "250 records, 1.0 KiB / 4.0 KiB", "ETA 30s"} {
+		if !strings.Contains(line, want) {
+			t.Errorf("expected %q in %q", want, line)
+		}
+	}
+	p.finish(1000, 4096)
+	if !strings.HasSuffix(strings.TrimRight(out.String(), " \n"), "ETA 0s") || !strings.HasSuffix(out.String(), "\n") {
+		t.Errorf("expected a final line, got %q", out.String())
+	}
+}
+
+func TestProgressTotals(t *testing.T) {
+	now := time.Now()
+	// The record total is used if the byte total is not known
+	p := newProgressTo(nil, true, "export", 200, 0)
+	if line := p.line(50, 3*1024*1024, now); !strings.Contains(line, "25.0% 50 / 200 records, 3.0 MiB") {
+		t.Errorf("unexpected line %q", line)
+	}
+	// Without totals, only the counts are shown
+	p = newProgressTo(nil, true, "merge", 0, 0)
+	if line := p.line(50, 10, now); line != "merge: 50 records, 10 B" {
+		t.Errorf("unexpected line %q", line)
+	}
+}
+
+func TestProgressLog(t *testing.T) {
+	var out bytes.Buffer
+	p := newProgressTo(&out, false, "export", 100, 0)
+	now := helperFakeClock(p)
+	for i := range 10 {
+		*now = now.Add(time.Second)
+		p.update(int64(i), 0)
+	}
+	// A line every progressLogInterval, and no final line
+	p.finish(100, 0)
+	if lines := strings.Count(out.String(), "\n"); lines != 2 || strings.Contains(out.String(), "\r") {
+		t.Errorf("expected 2 lines, got %q", out.String())
+	}
+}
+
+func TestProgressQuiet(t *testing.T) {
+	var out bytes.Buffer
+	p := newProgressTo(&out, true, "import", 0, 100)
+	helperFakeClock(p)
+	p.update(1, 10)
+	p.finish(1, 100)
+	if out.Len() != 0 {
+		t.Errorf("expected no output for a fast operation, got %q", out.String())
+	}
+}
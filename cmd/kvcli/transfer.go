@@ -0,0 +1,287 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/ananthvk/kvdb"
+)
+
+/*
+\export and \import move key value pairs between the datastore and a file. The format is picked from the file
+extension, .csv files have one key,value,encoding row per pair (without a header), any other file is NDJSON, with one
+object per line:
+
+	{"key":"name","value":"kvdb"}
+
+Keys and values that are not valid UTF-8 can't be represented as JSON strings, so the pair is written with both
+fields base64 encoded instead:
+
+	{"key":"AAE=","value":"/w==","encoding":"base64"}
+
+CSV rows use the same encodings, the third column is empty for text pairs and base64 otherwise. A CSV reader turns
+\r\n inside a field into \n, so pairs with a carriage return are base64 encoded as well. Rows with only two columns
+(written by older versions) are read as text pairs
+
+	name,kvdb,
+	AAE=,/w==,base64
+
+Imported pairs are written with PutBatch, importBatchSize pairs at a time
+*/
+
+// Number of keys read from the store at a time during an export
+const exportPageSize = 1000
+
+// Number of pairs written to the store at a time during an import
+const importBatchSize = 1000
+
+const encodingBase64 = "base64"
+
+type ndjsonPair struct {
+	Key      string `json:"key"`
+	Value    string `json:"value"`
+	Encoding string `json:"encoding,omitempty"`
+}
+
+// pairWriter writes key value pairs in one of the export formats
+type pairWriter interface {
+	Write(key, value []byte) error
+	Flush() error
+}
+
+// pairReader reads key value pairs, it returns io.EOF after the last pair
+type pairReader interface {
+	Read() (key, value []byte, err error)
+}
+
+func isCSV(path string) bool {
+	return strings.EqualFold(filepath.Ext(path), ".csv")
+}
+
+// exportKeys writes every key value pair in the store to the file at path, and returns the number of pairs written
+func exportKeys(store *kvdb.DataStore, path string) (int, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	// The number of keys can change during the export, so the ETA is an estimate
+	counter := &countingWriter{writer: file}
+	progress := newProgress("export", int64(store.Size()), 0)
+	var writer pairWriter
+	if isCSV(path) {
+		writer = &csvPairWriter{csv.NewWriter(counter)}
+	} else {
+		buffered := bufio.NewWriter(counter)
+		writer = &ndjsonPairWriter{writer: buffered, encoder: json.NewEncoder(buffered)}
+	}
+
+	count := 0
+	cursor := ""
+	for {
+		keys, next, err := store.ListKeysPage(cursor, exportPageSize)
+		if err != nil {
+			return count, err
+		}
+		for _, key := range keys {
+			value, err := store.Get([]byte(key))
+			if err != nil {
+				if errors.Is(err, kvdb.ErrKeyNotFound) {
+					// Deleted after the page was read
+					continue
+				}
+				return count, err
+			}
+			if err := writer.Write([]byte(key), value); err != nil {
+				return count, err
+			}
+			count++
+			progress.update(int64(count), counter.n)
+		}
+		if next == "" {
+			break
+		}
+		cursor = next
+	}
+	if err := writer.Flush(); err != nil {
+		return count, err
+	}
+	progress.finish(int64(count), counter.n)
+	return count, file.Sync()
+}
+
+// importKeys puts every key value pair in the file at path into the store, and returns the number of pairs imported.
+// Existing keys are overwritten
+func importKeys(store *kvdb.DataStore, path string) (int, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	var totalBytes int64
+	if info, err := file.Stat(); err == nil {
+		totalBytes = info.Size()
+	}
+	// Progress is measured in bytes of the file, which are read ahead of the pairs by the buffered reader
+	counter := &countingReader{reader: file}
+	progress := newProgress("import", 0, totalBytes)
+	var reader pairReader
+	if isCSV(path) {
+		csvReader := csv.NewReader(bufio.NewReader(counter))
+		csvReader.FieldsPerRecord = -1
+		reader = &csvPairReader{csvReader}
+	} else {
+		scanner := bufio.NewScanner(counter)
+		// Allow lines as long as the largest record (with room for JSON escaping and base64)
+		scanner.Buffer(nil, 1<<30)
+		reader = &ndjsonPairReader{scanner: scanner}
+	}
+
+	count := 0
+	batch := make([]kvdb.KeyValue, 0, importBatchSize)
+	flush := func() error {
+		n, err := store.PutBatch(batch)
+		count += n
+		batch = batch[:0]
+		progress.update(int64(count), counter.n)
+		return err
+	}
+	for {
+		key, value, err := reader.Read()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			return count, err
+		}
+		batch = append(batch, kvdb.KeyValue{Key: key, Value: value})
+		if len(batch) == importBatchSize {
+			if err := flush(); err != nil {
+				return count, err
+			}
+		}
+	}
+	err = flush()
+	progress.finish(int64(count), counter.n)
+	return count, err
+}
+
+type ndjsonPairWriter struct {
+	writer  *bufio.Writer
+	encoder *json.Encoder
+}
+
+func (w *ndjsonPairWriter) Write(key, value []byte) error {
+	if utf8.Valid(key) && utf8.Valid(value) {
+		return w.encoder.Encode(ndjsonPair{Key: string(key), Value: string(value)})
+	}
+	return w.encoder.Encode(ndjsonPair{
+		Key:      base64.StdEncoding.EncodeToString(key),
+		Value:    base64.StdEncoding.EncodeToString(value),
+		Encoding: encodingBase64,
+	})
+}
+
+func (w *ndjsonPairWriter) Flush() error {
+	return w.writer.Flush()
+}
+
+type ndjsonPairReader struct {
+	scanner *bufio.Scanner
+	line    int
+}
+
+func (r *ndjsonPairReader) Read() ([]byte, []byte, error) {
+	for r.scanner.Scan() {
+		r.line++
+		line := r.scanner.Bytes()
+		if len(strings.TrimSpace(string(line))) == 0 {
+			continue
+		}
+		var pair ndjsonPair
+		if err := json.Unmarshal(line, &pair); err != nil {
+			return nil, nil, fmt.Errorf("line %d: %w", r.line, err)
+		}
+		key, value, err := decodePair(pair.Key, pair.Value, pair.Encoding)
+		if err != nil {
+			return nil, nil, fmt.Errorf("line %d: %w", r.line, err)
+		}
+		return key, value, nil
+	}
+	if err := r.scanner.Err(); err != nil {
+		return nil, nil, err
+	}
+	return nil, nil, io.EOF
+}
+
+type csvPairWriter struct {
+	writer *csv.Writer
+}
+
+func (w *csvPairWriter) Write(key, value []byte) error {
+	if utf8.Valid(key) && utf8.Valid(value) && !bytes.ContainsRune(key, '\r') && !bytes.ContainsRune(value, '\r') {
+		return w.writer.Write([]string{string(key), string(value), ""})
+	}
+	return w.writer.Write([]string{
+		base64.StdEncoding.EncodeToString(key),
+		base64.StdEncoding.EncodeToString(value),
+		encodingBase64,
+	})
+}
+
+func (w *csvPairWriter) Flush() error {
+	w.writer.Flush()
+	return w.writer.Error()
+}
+
+type csvPairReader struct {
+	reader *csv.Reader
+}
+
+func (r *csvPairReader) Read() ([]byte, []byte, error) {
+	row, err := r.reader.Read()
+	if err != nil {
+		return nil, nil, err
+	}
+	line, _ := r.reader.FieldPos(0)
+	if len(row) != 2 && len(row) != 3 {
+		return nil, nil, fmt.Errorf("line %d: expected 2 or 3 fields, got %d", line, len(row))
+	}
+	encoding := ""
+	if len(row) == 3 {
+		encoding = row[2]
+	}
+	key, value, err := decodePair(row[0], row[1], encoding)
+	if err != nil {
+		return nil, nil, fmt.Errorf("line %d: %w", line, err)
+	}
+	return key, value, nil
+}
+
+// decodePair decodes a key and value written with the given encoding ("" for text)
+func decodePair(key, value, encoding string) ([]byte, []byte, error) {
+	switch encoding {
+	case "":
+		return []byte(key), []byte(value), nil
+	case encodingBase64:
+		decodedKey, err1 := base64.StdEncoding.DecodeString(key)
+		decodedValue, err2 := base64.StdEncoding.DecodeString(value)
+		if err := errors.Join(err1, err2); err != nil {
+			return nil, nil, err
+		}
+		return decodedKey, decodedValue, nil
+	}
+	return nil, nil, fmt.Errorf("unknown encoding %q", encoding)
+}
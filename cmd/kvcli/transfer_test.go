@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ananthvk/kvdb"
+	"github.com/spf13/afero"
+)
+
+func TestExportImportRoundTrip(t *testing.T) {
+	pairs := map[string][]byte{
+		"text":            []byte("hello, \"world\"\nsecond line"),
+		"crlf":            []byte("line 1\r\nline 2\r\n"),
+		"carriage":        []byte("a\rb"),
+		"binary":          {0x00, 0xff, '\r', '\n', 0xfe},
+		"empty":           {},
+		"key\r\nwith\xff": []byte("value"),
+	}
+	// More pairs than fit in one import batch
+	for i := range 2*importBatchSize + 10 {
+		pairs[string(rune('a'+i%26))+"/"+string(rune(i))] = []byte{byte(i)}
+	}
+
+	for _, name := range []string{"keys.ndjson", "keys.csv"} {
+		t.Run(name, func(t *testing.T) {
+			src, err := kvdb.Create(afero.NewMemMapFs(), "src.db")
+			if err != nil {
+				t.Fatalf("could not create datastore: %v", err)
+			}
+			defer src.Close()
+			for key, value := range pairs {
+				src.Put([]byte(key), value)
+			}
+			path := filepath.Join(t.TempDir(), name)
+			if count, err := exportKeys(src, path); err != nil || count != len(pairs) {
+				t.Fatalf("expected %d keys to be exported, got %d (err: %v)", len(pairs), count, err)
+			}
+
+			dst, err := kvdb.Create(afero.NewMemMapFs(), "dst.db")
+			if err != nil {
+				t.Fatalf("could not create datastore: %v", err)
+			}
+			defer dst.Close()
+			if count, err := importKeys(dst, path); err != nil || count != len(pairs) {
+				t.Fatalf("expected %d keys to be imported, got %d (err: %v)", len(pairs), count, err)
+			}
+			if dst.Size() != len(pairs) {
+				t.Errorf("expected %d keys after import, got %d", len(pairs), dst.Size())
+			}
+			for key, expected := range pairs {
+				if value, err := dst.Get([]byte(key)); err != nil || !bytes.Equal(value, expected) {
+					t.Errorf("expected %q for %q, got %q (err: %v)", expected, key, value, err)
+				}
+			}
+		})
+	}
+}
+
+func TestImportCSVWithoutEncoding(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys.csv")
+	if err := os.WriteFile(path, []byte("name,kvdb\nZW5jb2RlZA==,AAE=,base64\nlanguage,go,\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	store, err := kvdb.Create(afero.NewMemMapFs(), "test.db")
+	if err != nil {
+		t.Fatalf("could not create datastore: %v", err)
+	}
+	defer store.Close()
+	if count, err := importKeys(store, path); err != nil || count != 3 {
+		t.Fatalf("expected 3 keys to be imported, got %d (err: %v)", count, err)
+	}
+	if value, _ := store.Get([]byte("name")); string(value) != "kvdb" {
+		t.Errorf("expected kvdb, got %q", value)
+	}
+	if value, _ := store.Get([]byte("encoded")); !bytes.Equal(value, []byte{0x00, 0x01}) {
+		t.Errorf("expected a decoded value, got %q", value)
+	}
+
+	if err := os.WriteFile(path, []byte("key,value,rot13\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := importKeys(store, path); err == nil {
+		t.Errorf("expected an error for an unknown encoding")
+	}
+}
@@ -0,0 +1,517 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ananthvk/kvdb"
+	"github.com/ananthvk/kvdb/internal/bloom"
+	"github.com/ananthvk/kvdb/internal/datafile"
+	"github.com/ananthvk/kvdb/internal/filemanager"
+	"github.com/ananthvk/kvdb/internal/hintfile"
+	"github.com/ananthvk/kvdb/internal/keydir"
+	"github.com/ananthvk/kvdb/internal/metafile"
+	"github.com/ananthvk/kvdb/internal/record"
+	"github.com/ananthvk/kvdb/internal/utils"
+	"github.com/spf13/afero"
+)
+
+/*
+kvdump reads the data files of a datastore directly (the datastore is not opened, so it can be used on a store that
+fails to open, or while a server is running), and prints per file statistics, optionally every record, and with
+-check, verifies the integrity of the whole store.
+
+A record is live if it's the value the datastore would return for it's key, i.e. the records are replayed in file id
+order like the datastore does when it's opened. Offsets are from the first record in the file (i.e. they do not include
+the 19 byte file header), same as the positions in hint files and in the keydir
+*/
+
+// Keys longer than this are truncated in the record listing
+const maxPrintedKeyLength = 64
+
+// Key and value size limits of the datastore, and the directories of it's files, read from the metafile
+var limits = record.DefaultLimits
+var layout filemanager.Layout
+
+type fileSummary struct {
+	id       int
+	size     int64
+	created  time.Time
+	format   record.Format
+	checksum record.Checksum
+	// Records that could be read, and the tombstones among them
+	records    int
+	tombstones int
+	liveKeys   int
+	liveBytes  int64
+	hasHint    bool
+	// Error that stopped the scan of the file (bad header, CRC mismatch, truncated record), nil if the whole file was read
+	err error
+}
+
+func main() {
+	check := flag.Bool("check", false, "verify the integrity of the store, and exit with status 1 if a problem is found")
+	records := flag.Bool("records", false, "print every record")
+	fileId := flag.Int("file", 0, "only print the records of the data file with this id (with -records)")
+	repair := flag.Bool("repair", false, "drop damaged records and rebuild hint files (the datastore must not be open), then exit")
+	key := flag.String("key", "", "print the records of this key, skipping the files whose bloom filter does not have it, then exit")
+	verify := flag.Bool("verify", false, "open the datastore (it must not be open elsewhere), cross-check it's keydir against the data files, then exit with status 1 if a problem is found")
+	flag.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: kvdump [-check] [-records [-file <id>]] <path>")
+		fmt.Fprintln(os.Stderr, "       kvdump -repair <path>")
+		fmt.Fprintln(os.Stderr, "       kvdump -key <key> <path>")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	path := flag.Arg(0)
+	fs := afero.NewOsFs()
+
+	exists, err := metafile.IsDatastore(fs, path)
+	if err != nil || !exists {
+		fmt.Fprintf(os.Stderr, "%s is not a datastore\n", path)
+		os.Exit(1)
+	}
+	meta, err := readMeta(fs, path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "could not read metafile: %s\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("datastore %s (type %s, version %s, created %s)\n", path, meta.Type, meta.Version, meta.Created)
+
+	if *repair {
+		report, err := kvdb.Repair(fs, path)
+		if report != nil {
+			printRepairReport(report)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "repair failed: %s\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if *verify {
+		if !verifyStore(fs, path) {
+			os.Exit(1)
+		}
+		return
+	}
+
+	ids, unknown, err := dataFileIds(fs)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "could not list data files: %s\n", err)
+		os.Exit(1)
+	}
+	for _, name := range unknown {
+		fmt.Printf("warning: unknown file %s in data directory\n", name)
+	}
+
+	if *key != "" {
+		if err := findKey(fs, path, ids, []byte(*key)); err != nil {
+			fmt.Fprintf(os.Stderr, "lookup failed: %s\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	// Replay every file to find the live records
+	kd := keydir.NewKeydir()
+	summaries := make([]*fileSummary, len(ids))
+	for i, id := range ids {
+		summaries[i] = scanFile(fs, id, kd)
+	}
+	byId := map[int]*fileSummary{}
+	for _, summary := range summaries {
+		byId[summary.id] = summary
+	}
+	kd.Range(func(key string, rec keydir.KeydirRecord) bool {
+		summary := byId[rec.FileId]
+		summary.liveKeys++
+		summary.liveBytes += summary.format.EncodedSize(uint32(len(key)), rec.ValueSize)
+		return true
+	})
+
+	if *records {
+		for _, summary := range summaries {
+			if *fileId != 0 && summary.id != *fileId {
+				continue
+			}
+			printRecords(fs, summary.id, kd)
+		}
+	}
+	printSummaries(summaries, kd.Size())
+
+	if *check {
+		problems := checkStore(fs, summaries)
+		for _, problem := range problems {
+			fmt.Printf("problem: %s\n", problem)
+		}
+		if len(problems) > 0 {
+			fmt.Printf("check failed, %d problem(s) found\n", len(problems))
+			os.Exit(1)
+		}
+		fmt.Println("check passed")
+	}
+}
+
+// readMeta reads the metafile of the datastore at path, and sets limits and layout from it
+func readMeta(fs afero.Fs, path string) (*metafile.MetaData, error) {
+	meta, err := metafile.ReadMetaFile(fs, path)
+	if err != nil {
+		return nil, err
+	}
+	limits.MaxKeySize = cmp.Or(meta.MaxKeySize, limits.MaxKeySize)
+	limits.MaxValueSize = cmp.Or(meta.MaxValueSize, limits.MaxValueSize)
+	layout = filemanager.ResolveLayout(path, meta.ActiveDir, meta.MergedDir, meta.HintDir)
+	return meta, nil
+}
+
+// dataFileIds returns the ids of the data files in increasing order, and the names of other files in the data directories
+func dataFileIds(fs afero.Fs) ([]int, []string, error) {
+	var entries []os.FileInfo
+	for _, dir := range layout.DataDirs() {
+		dirEntries, err := afero.ReadDir(fs, dir)
+		if err != nil {
+			return nil, nil, err
+		}
+		entries = append(entries, dirEntries...)
+	}
+	var ids []int
+	var unknown []string
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		idPart, ok := strings.CutSuffix(entry.Name(), ".dat")
+		id, err := strconv.Atoi(idPart)
+		if !ok || err != nil || utils.GetDataFileName(id) != entry.Name() {
+			unknown = append(unknown, entry.Name())
+			continue
+		}
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	return ids, unknown, nil
+}
+
+func dataFilePath(fs afero.Fs, id int) string {
+	return layout.DataFilePath(fs, id)
+}
+
+func hintFilePath(id int) string {
+	return layout.HintPath(utils.GetHintFileName(id))
+}
+
+func bloomFilePath(id int) string {
+	return layout.HintPath(utils.GetBloomFileName(id))
+}
+
+// findKey prints every record of the key, newest file first. Files whose bloom filter does not have the key are skipped
+// without reading them
+func findKey(fs afero.Fs, path string, ids []int, key []byte) error {
+	skipped, found := 0, 0
+	for i := len(ids) - 1; i >= 0; i-- {
+		id := ids[i]
+		mayContain, err := kvdb.MayContainKey(fs, path, id, key)
+		if err != nil {
+			return err
+		}
+		if !mayContain {
+			skipped++
+			continue
+		}
+		err = forEachRecord(fs, id, func(rec record.Record, offset int64) {
+			if !bytes.Equal(rec.Key, key) {
+				return
+			}
+			found++
+			fmt.Printf("file %d offset %d: %s at %s, %d byte value\n",
+				id, offset, recordTypeName(rec.Header.RecordType), rec.Header.Timestamp.UTC().Format("2006-01-02T15:04:05.000000Z"), rec.Header.ValueSize)
+		})
+		if err != nil {
+			fmt.Printf("file %d: scan stopped: %s\n", id, err)
+		}
+	}
+	fmt.Printf("%d record(s) found, %d of %d data files skipped by their bloom filter\n", found, skipped, len(ids))
+	return nil
+}
+
+// scanFile reads every record of the data file, and applies it to the keydir
+func scanFile(fs afero.Fs, id int, kd *keydir.Keydir) *fileSummary {
+	summary := &fileSummary{id: id, format: record.FormatV1}
+	if info, err := fs.Stat(dataFilePath(fs, id)); err == nil {
+		summary.size = info.Size()
+	}
+	if exists, err := afero.Exists(fs, hintFilePath(id)); err == nil {
+		summary.hasHint = exists
+	}
+	header, err := datafile.ReadFileHeader(fs, dataFilePath(fs, id))
+	if err != nil {
+		summary.err = fmt.Errorf("invalid file header: %w", err)
+		return summary
+	}
+	summary.created = header.Timestamp
+	encoding := header.Encoding()
+	summary.format = record.Format(encoding.RecordFormat)
+	summary.checksum = record.Checksum(encoding.Checksum)
+
+	summary.err = forEachRecord(fs, id, func(rec record.Record, offset int64) {
+		summary.records++
+		if rec.Header.RecordType == record.RecordTypeDelete {
+			summary.tombstones++
+			kd.DeleteRecordIfNotNewer(rec.Key, rec.Header.Timestamp)
+		} else {
+			kd.AddKeydirRecord(rec.Key, id, rec.Header.ValueSize, offset, rec.Header.Timestamp)
+		}
+	})
+	return summary
+}
+
+// recordTypeName returns the name of the record type printed in the listings, ONCE is the put of a write-once key
+func recordTypeName(recordType uint8) string {
+	switch recordType {
+	case record.RecordTypeDelete:
+		return "DEL"
+	case record.RecordTypePutImmutable:
+		return "ONCE"
+	default:
+		return "PUT"
+	}
+}
+
+// forEachRecord calls fn for every record in the data file, and returns the error that stopped the scan, if any. The
+// record is only valid during the call
+func forEachRecord(fs afero.Fs, id int, fn func(rec record.Record, offset int64)) error {
+	scanner, err := record.NewScanner(fs, dataFilePath(fs, id))
+	if err != nil {
+		return err
+	}
+	defer scanner.Close()
+	scanner.SetLimits(limits)
+	var end int64
+	for {
+		rec, offset, err := scanner.Scan()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			if errors.Is(err, io.ErrUnexpectedEOF) {
+				return fmt.Errorf("truncated record at offset %d", end)
+			}
+			return fmt.Errorf("record at offset %d: %w", end, err)
+		}
+		fn(rec, offset)
+		end = offset + rec.Size
+	}
+}
+
+// printRecords prints every record in the data file, the records are marked live if the keydir points to them
+func printRecords(fs afero.Fs, id int, kd *keydir.Keydir) {
+	fmt.Printf("file %d (%s)\n", id, dataFilePath(fs, id))
+	fmt.Printf("  %12s  %-27s  %-4s  %-4s  %10s  %s\n", "OFFSET", "TIMESTAMP", "TYPE", "LIVE", "VALUE SIZE", "KEY")
+	err := forEachRecord(fs, id, func(rec record.Record, offset int64) {
+		recordType := recordTypeName(rec.Header.RecordType)
+		live := "no"
+		if current, ok := kd.GetKeydirRecord(rec.Key); ok && current.FileId == id && current.ValuePos == offset {
+			live = "yes"
+		}
+		key := rec.Key
+		suffix := ""
+		if len(key) > maxPrintedKeyLength {
+			key = key[:maxPrintedKeyLength]
+			suffix = "..."
+		}
+		fmt.Printf("  %12d  %-27s  %-4s  %-4s  %10d  %q%s\n",
+			offset, rec.Header.Timestamp.UTC().Format("2006-01-02T15:04:05.000000Z"), recordType, live, rec.Header.ValueSize, key, suffix)
+	})
+	if err != nil {
+		fmt.Printf("  (scan stopped: %s)\n", err)
+	} else {
+		fmt.Printf("  (all records passed the checksum check)\n")
+	}
+}
+
+func printSummaries(summaries []*fileSummary, keys int) {
+	fmt.Printf("%-10s %6s %8s %12s %10s %10s %10s %12s %6s  %s\n", "FILE", "FORMAT", "CHECKSUM", "SIZE", "RECORDS", "TOMBSTONES", "LIVE KEYS", "LIVE BYTES", "HINT", "STATUS")
+	var totalSize, totalLive int64
+	totalRecords := 0
+	for _, s := range summaries {
+		status := "ok"
+		if s.err != nil {
+			status = s.err.Error()
+		}
+		hint := "no"
+		if s.hasHint {
+			hint = "yes"
+		}
+		fmt.Printf("%-10d %6s %8s %12d %10d %10d %10d %12d %6s  %s\n", s.id, fmt.Sprintf("v%d", s.format), s.checksum, s.size, s.records, s.tombstones, s.liveKeys, s.liveBytes, hint, status)
+		totalSize += s.size
+		totalLive += s.liveBytes
+		totalRecords += s.records
+	}
+	dead := totalSize - totalLive - int64(len(summaries))*datafile.FileHeaderSize
+	fmt.Printf("%d data files, %d bytes, %d records, %d live keys (%d bytes), %d bytes of dead records\n",
+		len(summaries), totalSize, totalRecords, keys, totalLive, max(dead, 0))
+}
+
+// checkStore returns the integrity problems found in the store
+func checkStore(fs afero.Fs, summaries []*fileSummary) []string {
+	var problems []string
+	ids := map[int]bool{}
+	for _, s := range summaries {
+		ids[s.id] = true
+		if s.err != nil {
+			problems = append(problems, fmt.Sprintf("data file %d: %s", s.id, s.err))
+			continue
+		}
+		if s.hasHint {
+			if err := checkHintFile(fs, s.id); err != nil {
+				problems = append(problems, fmt.Sprintf("hint file %d: %s", s.id, err))
+			}
+		}
+		if exists, _ := afero.Exists(fs, bloomFilePath(s.id)); exists {
+			if err := checkBloomFile(fs, s); err != nil {
+				problems = append(problems, fmt.Sprintf("bloom filter %d: %s", s.id, err))
+			}
+		}
+	}
+
+	entries, err := afero.ReadDir(fs, layout.HintDir)
+	if err != nil {
+		return append(problems, fmt.Sprintf("could not list hint files: %s", err))
+	}
+	for _, entry := range entries {
+		idPart, ok := strings.CutSuffix(entry.Name(), ".hint")
+		id, err := strconv.Atoi(idPart)
+		if ok && err == nil && utils.GetHintFileName(id) == entry.Name() && !ids[id] {
+			problems = append(problems, fmt.Sprintf("hint file %d has no data file", id))
+		}
+		idPart, ok = strings.CutSuffix(entry.Name(), ".bloom")
+		id, err = strconv.Atoi(idPart)
+		if ok && err == nil && utils.GetBloomFileName(id) == entry.Name() && !ids[id] {
+			problems = append(problems, fmt.Sprintf("bloom filter %d has no data file", id))
+		}
+	}
+	return problems
+}
+
+// checkBloomFile checks that the bloom filter describes the data file, and has every key of it
+func checkBloomFile(fs afero.Fs, s *fileSummary) error {
+	filter, size, err := bloom.ReadFile(fs, bloomFilePath(s.id))
+	if err != nil {
+		return err
+	}
+	if size != s.size {
+		return fmt.Errorf("stale, written for a %d byte data file, the data file has %d bytes", size, s.size)
+	}
+	var missing []string
+	err = forEachRecord(fs, s.id, func(rec record.Record, offset int64) {
+		if !filter.MayContain(rec.Key) {
+			missing = append(missing, fmt.Sprintf("%q", rec.Key))
+		}
+	})
+	if err != nil {
+		return err
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("%d key(s) of the data file are missing, for example %s", len(missing), missing[0])
+	}
+	return nil
+}
+
+// checkHintFile checks that every hint points to a matching record in the data file
+func checkHintFile(fs afero.Fs, id int) error {
+	scanner, err := hintfile.NewScanner(fs, hintFilePath(id))
+	if err != nil {
+		return err
+	}
+	defer scanner.Close()
+	scanner.SetLimits(limits)
+	reader, err := record.NewReader(fs, dataFilePath(fs, id))
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+	reader.SetLimits(limits)
+	for {
+		hint, err := scanner.Scan()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		}
+		rec, err := reader.ReadKeyAt(hint.ValuePos)
+		if err != nil {
+			return fmt.Errorf("hint for offset %d: %w", hint.ValuePos, err)
+		}
+		if record.IsPut(rec.Header.RecordType) == hint.Tombstone ||
+			(rec.Header.RecordType == record.RecordTypePutImmutable) != hint.Immutable ||
+			rec.Header.KeySize != hint.KeySize ||
+			rec.Header.ValueSize != hint.ValueSize ||
+			rec.Header.Timestamp.UnixMicro() != hint.Timestamp.UnixMicro() ||
+			!bytes.Equal(rec.Key, hint.Key) {
+			return fmt.Errorf("hint for offset %d does not match the record", hint.ValuePos)
+		}
+	}
+}
+
+func printRepairReport(report *kvdb.RepairReport) {
+	if len(report.Files) == 0 {
+		fmt.Println("no damaged data files found")
+	}
+	for _, file := range report.Files {
+		if file.InvalidHeader {
+			fmt.Printf("file %d: invalid file header, renamed to %s.corrupt\n", file.Id, utils.GetDataFileName(file.Id))
+			continue
+		}
+		fmt.Printf("file %d: kept %d records, dropped %d records (%d bytes) with bad CRC, dropped %d bytes at the end",
+			file.Id, file.KeptRecords, file.DroppedRecords, file.DroppedBytes, file.DroppedTailBytes)
+		if file.TailError != nil {
+			fmt.Printf(" (%s)", file.TailError)
+		}
+		fmt.Println()
+	}
+	fmt.Printf("rebuilt hint files: %v, removed hint files: %v\n", report.RebuiltHints, report.RemovedHints)
+}
+
+// verifyStore opens the datastore and prints the problems found by Verify, it returns false if there were any
+func verifyStore(fs afero.Fs, path string) bool {
+	store, err := kvdb.Open(fs, path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "could not open datastore: %s\n", err)
+		return false
+	}
+	defer store.Close()
+	report, err := store.Verify()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "verify failed: %s\n", err)
+		return false
+	}
+	for _, fileErr := range report.FileErrors {
+		fmt.Printf("problem: %s\n", fileErr)
+	}
+	for _, d := range report.Discrepancies {
+		fmt.Printf("problem: %s\n", d)
+	}
+	fmt.Printf("checked %d records in %d data files against %d keys\n", report.Records, report.Files, report.Keys)
+	if !report.OK() {
+		fmt.Printf("verify failed, %d problem(s) found\n", len(report.FileErrors)+len(report.Discrepancies))
+		return false
+	}
+	fmt.Println("verify passed")
+	return true
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"slices"
+	"testing"
+
+	"github.com/ananthvk/kvdb"
+	"github.com/ananthvk/kvdb/internal/keydir"
+	"github.com/spf13/afero"
+)
+
+func helperCreateStore(t *testing.T, fs afero.Fs, path string) map[string]string {
+	t.Helper()
+	store, err := kvdb.CreateWithOptions(fs, path, &kvdb.Options{MaxRecordsPerFile: 3})
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	expected := map[string]string{}
+	for i := range 8 {
+		key, value := fmt.Sprintf("key-%d", i), fmt.Sprintf("value-%d", i)
+		if err := store.Put([]byte(key), []byte(value)); err != nil {
+			t.Fatalf("put: %v", err)
+		}
+		expected[key] = value
+	}
+	// Merge so that the store has hint files, then overwrite and delete keys after it
+	if err := store.Merge(); err != nil {
+		t.Fatalf("merge: %v", err)
+	}
+	if err := store.Put([]byte("key-1"), []byte("overwritten")); err != nil {
+		t.Fatalf("put: %v", err)
+	}
+	expected["key-1"] = "overwritten"
+	for _, key := range []string{"key-2", "key-5"} {
+		if err := store.Delete([]byte(key)); err != nil {
+			t.Fatalf("delete: %v", err)
+		}
+		delete(expected, key)
+	}
+	if err := store.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+	return expected
+}
+
+func helperScanStore(t *testing.T, fs afero.Fs, path string) ([]*fileSummary, *keydir.Keydir) {
+	t.Helper()
+	if _, err := readMeta(fs, path); err != nil {
+		t.Fatalf("read metafile: %v", err)
+	}
+	ids, _, err := dataFileIds(fs)
+	if err != nil {
+		t.Fatalf("list data files: %v", err)
+	}
+	kd := keydir.NewKeydir()
+	var summaries []*fileSummary
+	for _, id := range ids {
+		summaries = append(summaries, scanFile(fs, id, kd))
+	}
+	return summaries, kd
+}
+
+func TestScanRoundTrip(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	expected := helperCreateStore(t, fs, "dump.db")
+	summaries, kd := helperScanStore(t, fs, "dump.db")
+
+	if len(summaries) < 2 {
+		t.Fatalf("expected the records to be spread over several data files, got %d", len(summaries))
+	}
+	tombstones, hints := 0, 0
+	for _, s := range summaries {
+		if s.err != nil {
+			t.Errorf("data file %d: %v", s.id, s.err)
+		}
+		tombstones += s.tombstones
+		if s.hasHint {
+			hints++
+		}
+	}
+	if tombstones != 2 {
+		t.Errorf("expected 2 tombstones, got %d", tombstones)
+	}
+	if hints == 0 {
+		t.Errorf("expected the merged files to have hint files")
+	}
+
+	keys := kd.GetAllKeys()
+	slices.Sort(keys)
+	var expectedKeys []string
+	for key := range expected {
+		expectedKeys = append(expectedKeys, key)
+	}
+	slices.Sort(expectedKeys)
+	if !slices.Equal(keys, expectedKeys) {
+		t.Fatalf("expected live keys %v, got %v", expectedKeys, keys)
+	}
+	for key, value := range expected {
+		rec, _ := kd.GetKeydirRecord([]byte(key))
+		if int(rec.ValueSize) != len(value) {
+			t.Errorf("%s: expected value size %d, got %d", key, len(value), rec.ValueSize)
+		}
+	}
+
+	if problems := checkStore(fs, summaries); len(problems) != 0 {
+		t.Errorf("expected no problems, got %v", problems)
+	}
+	if !verifyStore(fs, "dump.db") {
+		t.Errorf("expected verify to pass")
+	}
+}
+
+func TestCheckTruncatedFile(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	helperCreateStore(t, fs, "dump.db")
+	if _, err := readMeta(fs, "dump.db"); err != nil {
+		t.Fatalf("read metafile: %v", err)
+	}
+	ids, _, err := dataFileIds(fs)
+	if err != nil {
+		t.Fatalf("list data files: %v", err)
+	}
+	last := ids[len(ids)-1]
+	file, err := fs.OpenFile(dataFilePath(fs, last), os.O_RDWR, 0)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	info, _ := file.Stat()
+	if err := file.Truncate(info.Size() - 2); err != nil {
+		t.Fatalf("truncate: %v", err)
+	}
+	file.Close()
+
+	summaries, _ := helperScanStore(t, fs, "dump.db")
+	problems := checkStore(fs, summaries)
+	if len(problems) != 1 {
+		t.Fatalf("expected one problem for the truncated file, got %v", problems)
+	}
+}
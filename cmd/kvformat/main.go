@@ -0,0 +1,185 @@
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/ananthvk/kvdb/internal/datafile"
+	"github.com/ananthvk/kvdb/internal/format"
+	"github.com/ananthvk/kvdb/internal/metafile"
+	"github.com/ananthvk/kvdb/internal/utils"
+	"github.com/spf13/afero"
+)
+
+/*
+kvformat prints the on-disk format of data files and hint files as a JSON spec, validates files against the spec, and
+writes sample files that can be used as test vectors by other implementations of the format, or as seeds for fuzzers.
+
+Validation only uses the spec (not the readers of the datastore), so a file that passes validation can be decoded by
+any implementation that follows the spec
+*/
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "Usage: kvformat spec")
+	fmt.Fprintln(os.Stderr, "       kvformat validate <file or datastore>...")
+	fmt.Fprintln(os.Stderr, "       kvformat sample <dir>")
+	os.Exit(2)
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+	spec := format.Current()
+	switch os.Args[1] {
+	case "spec":
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(spec); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	case "validate":
+		if len(os.Args) < 3 {
+			usage()
+		}
+		failed := false
+		for _, path := range os.Args[2:] {
+			if !validatePath(spec, path) {
+				failed = true
+			}
+		}
+		if failed {
+			os.Exit(1)
+		}
+	case "sample":
+		if len(os.Args) != 3 {
+			usage()
+		}
+		if err := writeSample(os.Args[2]); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	default:
+		usage()
+	}
+}
+
+// validatePath validates a data file, a hint file, or every file of a datastore, and returns false if a file does not
+// match the spec
+func validatePath(spec *format.Spec, path string) bool {
+	info, err := os.Stat(path)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return false
+	}
+	if !info.IsDir() {
+		return validateFile(spec, path)
+	}
+	// A datastore can raise the size limits of the spec in it's metafile
+	if meta, err := metafile.ReadMetaFile(afero.NewOsFs(), path); err == nil {
+		storeSpec := *spec
+		storeSpec.MaxKeySize = cmp.Or(meta.MaxKeySize, spec.MaxKeySize)
+		storeSpec.MaxValueSize = cmp.Or(meta.MaxValueSize, spec.MaxValueSize)
+		spec = &storeSpec
+	}
+	var files []string
+	for _, pattern := range []string{"data/*.dat", "hint/*.hint"} {
+		matches, err := filepath.Glob(filepath.Join(path, pattern))
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return false
+		}
+		files = append(files, matches...)
+	}
+	if len(files) == 0 {
+		fmt.Fprintf(os.Stderr, "%s: no data or hint files found\n", path)
+		return false
+	}
+	ok := true
+	for _, file := range files {
+		if !validateFile(spec, file) {
+			ok = false
+		}
+	}
+	return ok
+}
+
+// validateFile validates a data file or a hint file (picked by the extension). A hint file is also checked against
+// it's data file, if the data file is found in the data directory next to the hint directory, or next to the hint file
+func validateFile(spec *format.Spec, path string) bool {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return false
+	}
+	var summary format.Summary
+	if strings.HasSuffix(path, ".hint") {
+		var dataFile []byte
+		dataFilePath := ""
+		name := strings.TrimSuffix(filepath.Base(path), ".hint") + ".dat"
+		for _, candidate := range []string{filepath.Join(filepath.Dir(path), "..", "data", name), filepath.Join(filepath.Dir(path), name)} {
+			if dataFile, err = os.ReadFile(candidate); err == nil {
+				dataFilePath = candidate
+				break
+			}
+			if !errors.Is(err, os.ErrNotExist) {
+				fmt.Fprintln(os.Stderr, err)
+				return false
+			}
+		}
+		summary, err = spec.ValidateHintFile(data, dataFile)
+		if err == nil && dataFilePath == "" {
+			fmt.Printf("%s: data file not found, hints were not checked against it\n", path)
+		}
+	} else {
+		summary, err = spec.ValidateDataFile(data)
+	}
+	if err != nil {
+		fmt.Printf("%s: FAIL: %v\n", path, err)
+		return false
+	}
+	fmt.Printf("%s: OK (%d records, %d tombstones)\n", path, summary.Records, summary.Tombstones)
+	return true
+}
+
+// writeSample writes the sample files to dir, in the same layout as a datastore. dir must not exist or be empty, so
+// that the samples are never mixed with (or overwrite) the files of a datastore
+func writeSample(dir string) error {
+	fs := afero.NewOsFs()
+	entries, err := afero.ReadDir(fs, dir)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	if len(entries) != 0 {
+		return fmt.Errorf("%s is not empty, refusing to write samples to it", dir)
+	}
+	for _, sub := range []string{"data", "hint"} {
+		if err := fs.MkdirAll(filepath.Join(dir, sub), 0755); err != nil {
+			return err
+		}
+	}
+	// Files 1 and 2 have v1 records, files 3 and 4 have v2 records, both with CRC32 checksums. Files 5 and 6 have v1
+	// records with CRC32C checksums, and files 7 and 8 have v2 records with xxHash64 checksums
+	encodings := []datafile.Encoding{
+		{RecordFormat: datafile.RecordFormatV1, Checksum: datafile.ChecksumCRC32},
+		{RecordFormat: datafile.RecordFormatV2, Checksum: datafile.ChecksumCRC32},
+		{RecordFormat: datafile.RecordFormatV1, Checksum: datafile.ChecksumCRC32C},
+		{RecordFormat: datafile.RecordFormatV2, Checksum: datafile.ChecksumXXHash64},
+	}
+	for i, encoding := range encodings {
+		dataPath := filepath.Join(dir, "data", utils.GetDataFileName(2*i+1))
+		hintDataPath := filepath.Join(dir, "data", utils.GetDataFileName(2*i+2))
+		hintPath := filepath.Join(dir, "hint", utils.GetHintFileName(2*i+2))
+		if err := format.WriteSample(fs, encoding, dataPath, hintDataPath, hintPath); err != nil {
+			return err
+		}
+		fmt.Printf("wrote %s, %s and %s\n", dataPath, hintDataPath, hintPath)
+	}
+	return nil
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ananthvk/kvdb/internal/format"
+)
+
+func TestWriteSample(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "sample")
+	if err := writeSample(dir); err != nil {
+		t.Fatalf("write sample: %v", err)
+	}
+	for _, pattern := range []string{"data/*.dat", "hint/*.hint"} {
+		matches, _ := filepath.Glob(filepath.Join(dir, pattern))
+		if len(matches) == 0 {
+			t.Errorf("expected files matching %s", pattern)
+		}
+	}
+	if !validatePath(format.Current(), dir) {
+		t.Errorf("expected the sample files to match the spec")
+	}
+
+	// An existing empty directory can also be used
+	empty := t.TempDir()
+	if err := writeSample(empty); err != nil {
+		t.Fatalf("write sample to an empty directory: %v", err)
+	}
+	if !validatePath(format.Current(), empty) {
+		t.Errorf("expected the sample files to match the spec")
+	}
+}
+
+func TestWriteSampleNonEmpty(t *testing.T) {
+	dir := t.TempDir()
+	existing := filepath.Join(dir, "existing")
+	if err := os.WriteFile(existing, []byte("keep me"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeSample(dir); err == nil {
+		t.Fatalf("expected an error for a non-empty directory")
+	}
+	if data, err := os.ReadFile(existing); err != nil || string(data) != "keep me" {
+		t.Errorf("expected the existing file to be untouched, got %q, %v", data, err)
+	}
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 1 {
+		t.Errorf("expected nothing to be written, got %d entries", len(entries))
+	}
+}
@@ -0,0 +1,23 @@
+module github.com/ananthvk/kvdb/cmd/kvgrpc
+
+go 1.25.5
+
+require (
+	github.com/ananthvk/kvdb v0.0.0
+	github.com/ananthvk/kvdb/proto v0.0.0
+	github.com/spf13/afero v1.15.0
+	google.golang.org/grpc v1.75.1
+)
+
+require (
+	golang.org/x/net v0.41.0 // indirect
+	golang.org/x/sys v0.33.0 // indirect
+	golang.org/x/text v0.28.0 // indirect
+	google.golang.org/genproto/googleapis/rpc v0.0.0-20250707201910-8d1bb00bc6a7 // indirect
+	google.golang.org/protobuf v1.36.12 // indirect
+)
+
+replace (
+	github.com/ananthvk/kvdb => ../..
+	github.com/ananthvk/kvdb/proto => ../../proto
+)
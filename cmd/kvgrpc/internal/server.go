@@ -0,0 +1,237 @@
+package internal
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ananthvk/kvdb"
+	"github.com/ananthvk/kvdb/proto/kvdbpb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+/*
+gRPC server for a datastore, it implements the KV service defined in proto/kvdb.proto
+
+Every call runs with the client's deadline, unary calls that don't have one get DefaultTimeout. Scan reads the keys a
+page at a time, and checks the deadline between pages, so a scan of a large store stops soon after the client gives up.
+Watch does not have a deadline, it runs until the client cancels it, or the connection is closed (dead connections are
+detected with keepalive pings, see cmd/kvgrpc).
+
+Errors are returned as status codes: NOT_FOUND for a missing key, INVALID_ARGUMENT for an empty or too large key or
+value, PERMISSION_DENIED for a write rejected by an interceptor, UNAVAILABLE for a stalled write, and INTERNAL otherwise
+*/
+
+const (
+	// Number of keys read from the store at a time by Scan
+	scanPageSize = 256
+	// Number of events buffered for a Watch call. The store calls the watch function with it's write lock held, so it
+	// can't wait for a slow client, the call fails with RESOURCE_EXHAUSTED if the buffer fills up
+	watchBufferSize = 1024
+)
+
+// Server implements the KV service for a datastore
+type Server struct {
+	kvdbpb.UnimplementedKVServer
+	Store *kvdb.DataStore
+	// DefaultTimeout is the deadline of unary calls that are made without one, 0 means no deadline
+	DefaultTimeout time.Duration
+}
+
+// NewGRPCServer returns a grpc server with the KV service registered
+func (s *Server) NewGRPCServer(opts ...grpc.ServerOption) *grpc.Server {
+	opts = append(opts, grpc.UnaryInterceptor(s.deadlineInterceptor))
+	server := grpc.NewServer(opts...)
+	kvdbpb.RegisterKVServer(server, s)
+	return server
+}
+
+// deadlineInterceptor sets DefaultTimeout as the deadline of calls that don't have one, and fails calls whose deadline
+// has already expired before they are run
+func (s *Server) deadlineInterceptor(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	if _, ok := ctx.Deadline(); !ok && s.DefaultTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.DefaultTimeout)
+		defer cancel()
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, status.FromContextError(err).Err()
+	}
+	return handler(ctx, req)
+}
+
+// storeError converts an error returned by the datastore to a status error
+func storeError(err error) error {
+	switch {
+	case errors.Is(err, kvdb.ErrKeyNotFound):
+		return status.Error(codes.NotFound, "key not found")
+	case errors.Is(err, kvdb.ErrKeyTooLarge), errors.Is(err, kvdb.ErrValueTooLarge), errors.Is(err, kvdb.ErrNilKey):
+		return status.Error(codes.InvalidArgument, err.Error())
+	case errors.Is(err, kvdb.ErrWriteRejected):
+		return status.Error(codes.PermissionDenied, err.Error())
+	case errors.Is(err, kvdb.ErrWriteStalled):
+		return status.Error(codes.Unavailable, err.Error())
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		return status.FromContextError(err).Err()
+	}
+	slog.Error("request failed", "error", err)
+	return status.Error(codes.Internal, err.Error())
+}
+
+func checkKey(key []byte) error {
+	if len(key) == 0 {
+		return status.Error(codes.InvalidArgument, "key must not be empty")
+	}
+	return nil
+}
+
+func (s *Server) Get(ctx context.Context, req *kvdbpb.GetRequest) (*kvdbpb.GetResponse, error) {
+	if err := checkKey(req.Key); err != nil {
+		return nil, err
+	}
+	value, err := s.Store.Get(req.Key)
+	if err != nil {
+		return nil, storeError(err)
+	}
+	return &kvdbpb.GetResponse{Value: value}, nil
+}
+
+func (s *Server) Put(ctx context.Context, req *kvdbpb.PutRequest) (*kvdbpb.PutResponse, error) {
+	if err := checkKey(req.Key); err != nil {
+		return nil, err
+	}
+	if err := s.Store.Put(req.Key, req.Value); err != nil {
+		return nil, storeError(err)
+	}
+	return &kvdbpb.PutResponse{}, nil
+}
+
+func (s *Server) Delete(ctx context.Context, req *kvdbpb.DeleteRequest) (*kvdbpb.DeleteResponse, error) {
+	if err := checkKey(req.Key); err != nil {
+		return nil, err
+	}
+	existed, err := s.Store.DeleteWithExists(req.Key)
+	if err != nil {
+		return nil, storeError(err)
+	}
+	return &kvdbpb.DeleteResponse{Existed: existed}, nil
+}
+
+func (s *Server) Scan(req *kvdbpb.ScanRequest, stream grpc.ServerStreamingServer[kvdbpb.ScanResponse]) error {
+	ctx := stream.Context()
+	prefix := string(req.Prefix)
+	// Keys are listed in sorted order, so the keys with the prefix are the ones from the prefix up to the first key
+	// without it
+	cursor := prefix
+	if len(req.StartAfter) > 0 {
+		cursor = max(cursor, string(req.StartAfter)+"\x00")
+	}
+	var sent uint32
+	for {
+		if err := ctx.Err(); err != nil {
+			return status.FromContextError(err).Err()
+		}
+		keys, next, err := s.Store.ListKeysPage(cursor, scanPageSize)
+		if err != nil {
+			return storeError(err)
+		}
+		for _, key := range keys {
+			if !strings.HasPrefix(key, prefix) {
+				return nil
+			}
+			res := &kvdbpb.ScanResponse{Key: []byte(key)}
+			if !req.KeysOnly {
+				value, err := s.Store.Get(res.Key)
+				if errors.Is(err, kvdb.ErrKeyNotFound) {
+					// Deleted after the page was listed
+					continue
+				}
+				if err != nil {
+					return storeError(err)
+				}
+				res.Value = value
+			}
+			if err := stream.Send(res); err != nil {
+				return err
+			}
+			sent++
+			if req.Limit > 0 && sent == req.Limit {
+				return nil
+			}
+		}
+		if next == "" {
+			return nil
+		}
+		cursor = next
+	}
+}
+
+func (s *Server) Watch(req *kvdbpb.WatchRequest, stream grpc.ServerStreamingServer[kvdbpb.WatchEvent]) error {
+	ctx := stream.Context()
+	events := make(chan *kvdbpb.WatchEvent, watchBufferSize)
+	overflow := make(chan struct{})
+	var overflowOnce sync.Once
+	cancel := s.Store.Watch(func(event kvdb.WatchEvent) {
+		if !bytes.HasPrefix(event.Key, req.Prefix) {
+			return
+		}
+		// Key and value are only valid during the call
+		e := &kvdbpb.WatchEvent{
+			Type:   kvdbpb.WatchEvent_TYPE_PUT,
+			Key:    bytes.Clone(event.Key),
+			Value:  bytes.Clone(event.Value),
+			FileId: int64(event.FileId),
+			Offset: event.Offset,
+		}
+		if event.Type == kvdb.WriteTypeDelete {
+			e.Type = kvdbpb.WatchEvent_TYPE_DELETE
+		}
+		select {
+		case events <- e:
+		default:
+			overflowOnce.Do(func() { close(overflow) })
+		}
+	})
+	defer cancel()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return status.FromContextError(ctx.Err()).Err()
+		case <-overflow:
+			return status.Error(codes.ResourceExhausted, "watch fell behind the writes to the store")
+		case e := <-events:
+			if err := stream.Send(e); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func (s *Server) Stats(ctx context.Context, req *kvdbpb.StatsRequest) (*kvdbpb.StatsResponse, error) {
+	stats, err := s.Store.Stats()
+	if err != nil {
+		return nil, storeError(err)
+	}
+	return &kvdbpb.StatsResponse{
+		Path:              stats.Path,
+		Version:           stats.Version,
+		Keys:              int64(stats.Keys),
+		DataFiles:         int64(stats.DataFiles),
+		DataFileBytes:     stats.DataFileBytes,
+		ActiveFileId:      int64(stats.ActiveFileId),
+		Gets:              stats.Gets,
+		Puts:              stats.Puts,
+		Deletes:           stats.Deletes,
+		Merges:            stats.Merges,
+		FailedMerges:      stats.FailedMerges,
+		MergeInProgress:   stats.MergeInProgress,
+		KeydirMemoryBytes: stats.KeydirMemoryBytes,
+	}, nil
+}
@@ -0,0 +1,218 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ananthvk/kvdb"
+	"github.com/ananthvk/kvdb/proto/kvdbpb"
+	"github.com/spf13/afero"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+func helperClient(t *testing.T, opts *kvdb.Options, defaultTimeout time.Duration) (kvdbpb.KVClient, *kvdb.DataStore) {
+	t.Helper()
+	store, err := kvdb.CreateWithOptions(afero.NewMemMapFs(), "test_kvgrpc.db", opts)
+	if err != nil {
+		t.Fatalf("could not create datastore: %v", err)
+	}
+	listener := bufconn.Listen(1024 * 1024)
+	server := (&Server{Store: store, DefaultTimeout: defaultTimeout}).NewGRPCServer()
+	go server.Serve(listener)
+
+	conn, err := grpc.NewClient("passthrough:///bufconn",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return listener.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatalf("could not connect: %v", err)
+	}
+	t.Cleanup(func() {
+		conn.Close()
+		server.Stop()
+		store.Close()
+	})
+	return kvdbpb.NewKVClient(conn), store
+}
+
+func TestGetPutDelete(t *testing.T) {
+	client, _ := helperClient(t, nil, 0)
+	ctx := context.Background()
+
+	if _, err := client.Put(ctx, &kvdbpb.PutRequest{Key: []byte("key1"), Value: []byte("value1")}); err != nil {
+		t.Fatalf("put failed: %v", err)
+	}
+	res, err := client.Get(ctx, &kvdbpb.GetRequest{Key: []byte("key1")})
+	if err != nil || string(res.Value) != "value1" {
+		t.Errorf("expected value1, got %v (err: %v)", res, err)
+	}
+	del, err := client.Delete(ctx, &kvdbpb.DeleteRequest{Key: []byte("key1")})
+	if err != nil || !del.Existed {
+		t.Errorf("expected the key to exist, got %v (err: %v)", del, err)
+	}
+	if _, err := client.Get(ctx, &kvdbpb.GetRequest{Key: []byte("key1")}); status.Code(err) != codes.NotFound {
+		t.Errorf("expected NOT_FOUND, got %v", err)
+	}
+	if del, err := client.Delete(ctx, &kvdbpb.DeleteRequest{Key: []byte("key1")}); err != nil || del.Existed {
+		t.Errorf("expected a delete of a missing key to succeed, got %v (err: %v)", del, err)
+	}
+	stats, err := client.Stats(ctx, &kvdbpb.StatsRequest{})
+	if err != nil || stats.Puts != 1 || stats.Gets != 2 || stats.Keys != 0 {
+		t.Errorf("unexpected stats %v (err: %v)", stats, err)
+	}
+}
+
+func TestErrorCodes(t *testing.T) {
+	rejectKey := kvdb.WriteInterceptor{Name: "reject", Before: func(req *kvdb.WriteRequest) error {
+		if string(req.Key) == "readonly" {
+			return errors.New("read only key")
+		}
+		return nil
+	}}
+	client, _ := helperClient(t, &kvdb.Options{MaxValueSize: 8, Interceptors: []kvdb.WriteInterceptor{rejectKey}}, 0)
+	ctx := context.Background()
+
+	_, err := client.Get(ctx, &kvdbpb.GetRequest{})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("expected INVALID_ARGUMENT for an empty key, got %v", err)
+	}
+	_, err = client.Put(ctx, &kvdbpb.PutRequest{Key: []byte("key"), Value: []byte("more than eight bytes")})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("expected INVALID_ARGUMENT for a large value, got %v", err)
+	}
+	_, err = client.Put(ctx, &kvdbpb.PutRequest{Key: []byte("readonly"), Value: []byte("value")})
+	if status.Code(err) != codes.PermissionDenied {
+		t.Errorf("expected PERMISSION_DENIED for a rejected write, got %v", err)
+	}
+}
+
+func TestDeadlines(t *testing.T) {
+	client, _ := helperClient(t, nil, time.Nanosecond)
+
+	// The default deadline applies to calls without one
+	if _, err := client.Stats(context.Background(), &kvdbpb.StatsRequest{}); status.Code(err) != codes.DeadlineExceeded {
+		t.Errorf("expected DEADLINE_EXCEEDED with the default timeout, got %v", err)
+	}
+	// The client's deadline is used if it has one
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := client.Stats(ctx, &kvdbpb.StatsRequest{}); err != nil {
+		t.Errorf("expected the client's deadline to be used, got %v", err)
+	}
+}
+
+func helperScan(t *testing.T, client kvdbpb.KVClient, req *kvdbpb.ScanRequest) ([]string, []string) {
+	t.Helper()
+	stream, err := client.Scan(context.Background(), req)
+	if err != nil {
+		t.Fatalf("scan failed: %v", err)
+	}
+	var keys, values []string
+	for {
+		res, err := stream.Recv()
+		if err == io.EOF {
+			return keys, values
+		}
+		if err != nil {
+			t.Fatalf("scan failed: %v", err)
+		}
+		keys = append(keys, string(res.Key))
+		values = append(values, string(res.Value))
+	}
+}
+
+func TestScan(t *testing.T) {
+	client, store := helperClient(t, nil, 0)
+	for _, key := range []string{"b/3", "a", "b/1", "c", "b/2", "b"} {
+		store.Put([]byte(key), []byte("value_"+key))
+	}
+
+	if keys, values := helperScan(t, client, &kvdbpb.ScanRequest{}); strings.Join(keys, ",") != "a,b,b/1,b/2,b/3,c" || values[0] != "value_a" {
+		t.Errorf("unexpected scan %v %v", keys, values)
+	}
+	if keys, values := helperScan(t, client, &kvdbpb.ScanRequest{Prefix: []byte("b/"), KeysOnly: true}); strings.Join(keys, ",") != "b/1,b/2,b/3" || values[0] != "" {
+		t.Errorf("unexpected scan with prefix %v %v", keys, values)
+	}
+	if keys, _ := helperScan(t, client, &kvdbpb.ScanRequest{Prefix: []byte("b"), StartAfter: []byte("b/1"), Limit: 1}); strings.Join(keys, ",") != "b/2" {
+		t.Errorf("unexpected resumed scan %v", keys)
+	}
+
+	// Scans read the keys in pages, a scan across several pages returns every key once
+	for i := range 3 * scanPageSize {
+		store.Put(fmt.Appendf(nil, "many/%04d", i), []byte("v"))
+	}
+	keys, _ := helperScan(t, client, &kvdbpb.ScanRequest{Prefix: []byte("many/"), KeysOnly: true})
+	if len(keys) != 3*scanPageSize || keys[0] != "many/0000" || keys[len(keys)-1] != fmt.Sprintf("many/%04d", 3*scanPageSize-1) {
+		t.Errorf("expected %d keys across pages, got %d", 3*scanPageSize, len(keys))
+	}
+}
+
+func TestScanCancelled(t *testing.T) {
+	client, store := helperClient(t, nil, 0)
+	store.Put([]byte("key"), []byte("value"))
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	stream, err := client.Scan(ctx, &kvdbpb.ScanRequest{})
+	if err == nil {
+		_, err = stream.Recv()
+	}
+	if status.Code(err) != codes.Canceled {
+		t.Errorf("expected CANCELED, got %v", err)
+	}
+}
+
+func TestWatch(t *testing.T) {
+	client, store := helperClient(t, nil, 0)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	stream, err := client.Watch(ctx, &kvdbpb.WatchRequest{Prefix: []byte("user/")})
+	if err != nil {
+		t.Fatalf("watch failed: %v", err)
+	}
+	// Writes are made until the watch has been registered on the server
+	events := make(chan *kvdbpb.WatchEvent)
+	go func() {
+		for {
+			event, err := stream.Recv()
+			if err != nil {
+				close(events)
+				return
+			}
+			events <- event
+		}
+	}()
+	var event *kvdbpb.WatchEvent
+	deadline := time.After(5 * time.Second)
+	for event == nil {
+		store.Put([]byte("other"), []byte("ignored"))
+		store.Put([]byte("user/1"), []byte("value"))
+		select {
+		case event = <-events:
+		case <-time.After(10 * time.Millisecond):
+		case <-deadline:
+			t.Fatalf("timed out waiting for a watch event")
+		}
+	}
+	if event.Type != kvdbpb.WatchEvent_TYPE_PUT || string(event.Key) != "user/1" || string(event.Value) != "value" {
+		t.Errorf("unexpected event %v", event)
+	}
+	store.Delete([]byte("user/1"))
+	for event = range events {
+		if event.Type == kvdbpb.WatchEvent_TYPE_DELETE {
+			break
+		}
+	}
+	if event == nil || string(event.Key) != "user/1" {
+		t.Errorf("expected a delete event, got %v", event)
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"os/signal"
+	"time"
+
+	"github.com/ananthvk/kvdb"
+	"github.com/ananthvk/kvdb/cmd/kvgrpc/internal"
+	"github.com/spf13/afero"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/keepalive"
+)
+
+func main() {
+	portPtr := flag.Uint("port", 50051, "specify the port on which to listen")
+	hostPtr := flag.String("host", "0.0.0.0", "specify the bind address")
+	dbPtr := flag.String("db", "", "specify the datastore directory path, :memory for an in-memory datastore")
+	defaultTimeoutPtr := flag.Duration("default-timeout", 10*time.Second, "deadline of calls that are made without one (except Scan and Watch), 0 to disable")
+	connectionTimeoutPtr := flag.Duration("connection-timeout", 20*time.Second, "maximum time for a new connection to complete it's handshake")
+	idleTimeoutPtr := flag.Duration("idle-timeout", 0, "close connections that have had no calls for this duration (e.g. 5m), 0 to disable")
+	keepaliveTimePtr := flag.Duration("keepalive-time", time.Minute, "ping clients after this duration of inactivity, to detect dead connections")
+	flag.Parse()
+	if *dbPtr == "" {
+		slog.Error("database directory path is required")
+		return
+	}
+
+	path := *dbPtr
+	var store *kvdb.DataStore
+	var err error
+	if path == ":memory" {
+		store, err = kvdb.CreateInMemory(nil)
+	} else {
+		fs := afero.NewOsFs()
+		store, err = kvdb.Open(fs, path)
+		if errors.Is(err, kvdb.ErrNotExist) {
+			store, err = kvdb.Create(fs, path)
+		}
+	}
+	if err != nil {
+		slog.Error("datastore could not be opened", "path", path, "error", err)
+		os.Exit(1)
+	}
+	defer func() {
+		slog.Info("closing store", "path", path)
+		store.Close()
+	}()
+
+	server := (&internal.Server{Store: store, DefaultTimeout: *defaultTimeoutPtr}).NewGRPCServer(
+		grpc.ConnectionTimeout(*connectionTimeoutPtr),
+		grpc.KeepaliveParams(keepalive.ServerParameters{
+			MaxConnectionIdle: *idleTimeoutPtr,
+			Time:              *keepaliveTimePtr,
+			Timeout:           20 * time.Second,
+		}),
+	)
+	address := fmt.Sprintf("%s:%d", *hostPtr, *portPtr)
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		slog.Error("could not listen", "address", address, "error", err)
+		return
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		// Watch calls only end when the client cancels them, so they are cut off if they don't finish in time
+		stopped := make(chan struct{})
+		go func() {
+			server.GracefulStop()
+			close(stopped)
+		}()
+		select {
+		case <-stopped:
+		case <-time.After(10 * time.Second):
+			server.Stop()
+		}
+	}()
+
+	slog.Info("grpc server listening", "address", address, "datastore", path)
+	// Serve returns once all calls have finished after GracefulStop (or Stop), so the store is closed after them
+	if err := server.Serve(listener); err != nil {
+		slog.Error("server failed", "error", err)
+	}
+}
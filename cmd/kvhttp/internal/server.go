@@ -0,0 +1,308 @@
+package internal
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/ananthvk/kvdb"
+)
+
+/*
+REST gateway for a datastore
+
+	GET    /keys/{key}      returns the value. The Content-Type is application/json if the value is valid JSON,
+	                        text/plain if it's valid UTF-8, and application/octet-stream otherwise. Values larger than
+	                        streamValueSize are streamed from the data file as application/octet-stream
+	PUT    /keys/{key}      stores the request body. The body must be valid JSON if the Content-Type is application/json
+	DELETE /keys/{key}      deletes the key
+	GET    /keys?prefix=p   lists the keys that start with p (all keys if prefix is empty) in sorted order, a page of at
+	                        most limit (default defaultListLimit) keys is returned. If there are more keys, the response
+	                        has a next_cursor, which is passed as cursor to get the next page
+	GET    /stats           returns the datastore statistics
+	POST   /merge           runs a merge, and returns once it completes
+
+Everything except the value returned by GET /keys/{key} is JSON, errors are returned as {"error": "message"}
+*/
+
+const (
+	// Number of keys returned by GET /keys if limit is not given, and the largest limit that is allowed
+	defaultListLimit = 1000
+	maxListLimit     = 10000
+	// Values larger than this are streamed by GET /keys/{key} instead of being read into memory
+	streamValueSize = 64 * 1024
+)
+
+// Server serves the REST API for a datastore
+type Server struct {
+	Store *kvdb.DataStore
+}
+
+// Handler returns the http handler with all the routes registered
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /keys/{key...}", s.handleGet)
+	mux.HandleFunc("PUT /keys/{key...}", s.handlePut)
+	mux.HandleFunc("DELETE /keys/{key...}", s.handleDelete)
+	mux.HandleFunc("GET /keys", s.handleList)
+	mux.HandleFunc("GET /stats", s.handleStats)
+	mux.HandleFunc("POST /merge", s.handleMerge)
+	return mux
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		slog.Warn("could not write response", "error", err)
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"error": message})
+}
+
+// pathKey returns the key from the request path, an error response is written if the key is not valid
+func (s *Server) pathKey(w http.ResponseWriter, r *http.Request) ([]byte, bool) {
+	key := r.PathValue("key")
+	if key == "" {
+		writeError(w, http.StatusBadRequest, "key must not be empty")
+		return nil, false
+	}
+	if len(key) > s.Store.MaxKeySize() {
+		writeError(w, http.StatusBadRequest, "key too large")
+		return nil, false
+	}
+	return []byte(key), true
+}
+
+// valueContentType returns the content type used to return a value
+func valueContentType(value []byte) string {
+	switch {
+	case json.Valid(value):
+		return "application/json"
+	case utf8.Valid(value):
+		return "text/plain; charset=utf-8"
+	default:
+		return "application/octet-stream"
+	}
+}
+
+func (s *Server) handleGet(w http.ResponseWriter, r *http.Request) {
+	key, ok := s.pathKey(w, r)
+	if !ok {
+		return
+	}
+	reader, size, err := s.Store.GetReader(key)
+	if err != nil {
+		if errors.Is(err, kvdb.ErrKeyNotFound) {
+			writeError(w, http.StatusNotFound, "key not found")
+			return
+		}
+		slog.Error("get failed", "key", string(key), "error", err)
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer reader.Close()
+	if size > streamValueSize {
+		// Streamed from the data file, so the content type can't be detected
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set("Content-Length", strconv.FormatInt(size, 10))
+		if _, err := io.Copy(w, reader); err != nil {
+			slog.Warn("could not stream value", "key", string(key), "error", err)
+		}
+		return
+	}
+	value, err := io.ReadAll(reader)
+	if err != nil {
+		slog.Error("get failed", "key", string(key), "error", err)
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", valueContentType(value))
+	w.Write(value)
+}
+
+func (s *Server) handlePut(w http.ResponseWriter, r *http.Request) {
+	key, ok := s.pathKey(w, r)
+	if !ok {
+		return
+	}
+	mediaType := "application/octet-stream"
+	if contentType := r.Header.Get("Content-Type"); contentType != "" {
+		var err error
+		mediaType, _, err = mime.ParseMediaType(contentType)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "invalid Content-Type")
+			return
+		}
+	}
+	if mediaType != "application/json" && mediaType != "application/octet-stream" && !strings.HasPrefix(mediaType, "text/") {
+		writeError(w, http.StatusUnsupportedMediaType, "unsupported Content-Type "+mediaType)
+		return
+	}
+
+	value, err := io.ReadAll(http.MaxBytesReader(w, r.Body, int64(s.Store.MaxValueSize())))
+	if err != nil {
+		var maxBytesError *http.MaxBytesError
+		if errors.As(err, &maxBytesError) {
+			writeError(w, http.StatusRequestEntityTooLarge, "value too large")
+			return
+		}
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if mediaType == "application/json" && !json.Valid(value) {
+		writeError(w, http.StatusBadRequest, "body is not valid JSON")
+		return
+	}
+	if mediaType != "application/octet-stream" && !utf8.Valid(value) {
+		writeError(w, http.StatusBadRequest, "body is not valid UTF-8")
+		return
+	}
+
+	if err := s.Store.Put(key, value); err != nil {
+		if errors.Is(err, kvdb.ErrWriteRejected) {
+			writeError(w, http.StatusForbidden, err.Error())
+			return
+		}
+		slog.Error("put failed", "key", string(key), "error", err)
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) handleDelete(w http.ResponseWriter, r *http.Request) {
+	key, ok := s.pathKey(w, r)
+	if !ok {
+		return
+	}
+	existed, err := s.Store.DeleteWithExists(key)
+	if err != nil {
+		if errors.Is(err, kvdb.ErrWriteRejected) {
+			writeError(w, http.StatusForbidden, err.Error())
+			return
+		}
+		slog.Error("delete failed", "key", string(key), "error", err)
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if !existed {
+		writeError(w, http.StatusNotFound, "key not found")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) handleList(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	prefix := query.Get("prefix")
+	limit := defaultListLimit
+	if value := query.Get("limit"); value != "" {
+		var err error
+		limit, err = strconv.Atoi(value)
+		if err != nil || limit <= 0 || limit > maxListLimit {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("limit must be between 1 and %d", maxListLimit))
+			return
+		}
+	}
+	// Keys are listed in sorted order, so the keys with the prefix are the ones from the prefix up to the first key
+	// without it
+	cursor := max(query.Get("cursor"), prefix)
+	keys, next, err := s.Store.ListKeysPage(cursor, limit)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	matched := keys[:0]
+	for _, key := range keys {
+		if !strings.HasPrefix(key, prefix) {
+			next = ""
+			break
+		}
+		matched = append(matched, key)
+	}
+	response := map[string]any{"keys": matched, "count": len(matched)}
+	if next != "" {
+		response["next_cursor"] = next
+	}
+	writeJSON(w, http.StatusOK, response)
+}
+
+// statsResponse is the JSON representation of kvdb.Stats
+type statsResponse struct {
+	Path                string `json:"path"`
+	Version             string `json:"version"`
+	Keys                int    `json:"keys"`
+	DataFiles           int    `json:"data_files"`
+	DataFileBytes       int64  `json:"data_file_bytes"`
+	ReclaimableBytes    int64  `json:"reclaimable_bytes"`
+	ActiveFileId        int    `json:"active_file_id"`
+	Gets                uint64 `json:"gets"`
+	Puts                uint64 `json:"puts"`
+	Deletes             uint64 `json:"deletes"`
+	Merges              uint64 `json:"merges"`
+	FailedMerges        uint64 `json:"failed_merges"`
+	MergeInProgress     bool   `json:"merge_in_progress"`
+	LastMergeTime       string `json:"last_merge_time,omitempty"`
+	LastMergeDurationMs int64  `json:"last_merge_duration_ms"`
+	LastMergeError      string `json:"last_merge_error,omitempty"`
+	KeydirMemoryBytes   int64  `json:"keydir_memory_bytes"`
+	OpenReaders         int    `json:"open_readers"`
+	OpenFiles           int64  `json:"open_files"`
+	MaxOpenFiles        int    `json:"max_open_files"`
+}
+
+func (s *Server) handleStats(w http.ResponseWriter, r *http.Request) {
+	stats, err := s.Store.Stats()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	response := statsResponse{
+		Path:                stats.Path,
+		Version:             stats.Version,
+		Keys:                stats.Keys,
+		DataFiles:           stats.DataFiles,
+		DataFileBytes:       stats.DataFileBytes,
+		ReclaimableBytes:    stats.ReclaimableBytes,
+		ActiveFileId:        stats.ActiveFileId,
+		Gets:                stats.Gets,
+		Puts:                stats.Puts,
+		Deletes:             stats.Deletes,
+		Merges:              stats.Merges,
+		FailedMerges:        stats.FailedMerges,
+		MergeInProgress:     stats.MergeInProgress,
+		LastMergeDurationMs: stats.LastMergeDuration.Milliseconds(),
+		KeydirMemoryBytes:   stats.KeydirMemoryBytes,
+		OpenReaders:         stats.OpenReaders,
+		OpenFiles:           stats.OpenFiles,
+		MaxOpenFiles:        stats.MaxOpenFiles,
+	}
+	if !stats.LastMergeTime.IsZero() {
+		response.LastMergeTime = stats.LastMergeTime.Format(time.RFC3339)
+	}
+	if stats.LastMergeError != nil {
+		response.LastMergeError = stats.LastMergeError.Error()
+	}
+	writeJSON(w, http.StatusOK, response)
+}
+
+func (s *Server) handleMerge(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	if err := s.Store.Merge(); err != nil {
+		slog.Error("merge failed", "error", err)
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"duration_ms": time.Since(start).Milliseconds()})
+}
@@ -0,0 +1,165 @@
+package internal
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/ananthvk/kvdb"
+	"github.com/spf13/afero"
+)
+
+func helperServer(t *testing.T, opts *kvdb.Options) *httptest.Server {
+	t.Helper()
+	store, err := kvdb.CreateWithOptions(afero.NewMemMapFs(), "test_kvhttp.db", opts)
+	if err != nil {
+		t.Fatalf("could not create datastore: %v", err)
+	}
+	t.Cleanup(func() { store.Close() })
+	server := httptest.NewServer((&Server{Store: store}).Handler())
+	t.Cleanup(server.Close)
+	return server
+}
+
+func doRequest(t *testing.T, server *httptest.Server, method, path, contentType, body string) (*http.Response, string) {
+	t.Helper()
+	req, err := http.NewRequest(method, server.URL+path, strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("could not create request: %v", err)
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	res, err := server.Client().Do(req)
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	defer res.Body.Close()
+	data, _ := io.ReadAll(res.Body)
+	return res, string(data)
+}
+
+func TestGetPutDelete(t *testing.T) {
+	server := helperServer(t, nil)
+
+	if res, _ := doRequest(t, server, "PUT", "/keys/user/1", "application/json", `{"name":"a"}`); res.StatusCode != http.StatusNoContent {
+		t.Fatalf("expected 204 for PUT, got %d", res.StatusCode)
+	}
+	res, body := doRequest(t, server, "GET", "/keys/user/1", "", "")
+	if res.StatusCode != http.StatusOK || body != `{"name":"a"}` || res.Header.Get("Content-Type") != "application/json" {
+		t.Errorf("unexpected GET response %d %q (%s)", res.StatusCode, body, res.Header.Get("Content-Type"))
+	}
+
+	doRequest(t, server, "PUT", "/keys/text", "text/plain", "hello")
+	if res, body := doRequest(t, server, "GET", "/keys/text", "", ""); body != "hello" || !strings.HasPrefix(res.Header.Get("Content-Type"), "text/plain") {
+		t.Errorf("unexpected GET response %q (%s)", body, res.Header.Get("Content-Type"))
+	}
+	doRequest(t, server, "PUT", "/keys/binary", "", "\xff\x00")
+	if res, body := doRequest(t, server, "GET", "/keys/binary", "", ""); body != "\xff\x00" || res.Header.Get("Content-Type") != "application/octet-stream" {
+		t.Errorf("unexpected GET response %q (%s)", body, res.Header.Get("Content-Type"))
+	}
+
+	// Large values are streamed
+	large := strings.Repeat("large value ", streamValueSize/10)
+	doRequest(t, server, "PUT", "/keys/large", "text/plain", large)
+	if res, body := doRequest(t, server, "GET", "/keys/large", "", ""); body != large || res.Header.Get("Content-Type") != "application/octet-stream" {
+		t.Errorf("unexpected GET response of %d bytes (%s)", len(body), res.Header.Get("Content-Type"))
+	}
+
+	if res, _ := doRequest(t, server, "DELETE", "/keys/user/1", "", ""); res.StatusCode != http.StatusNoContent {
+		t.Errorf("expected 204 for DELETE, got %d", res.StatusCode)
+	}
+	if res, _ := doRequest(t, server, "GET", "/keys/user/1", "", ""); res.StatusCode != http.StatusNotFound {
+		t.Errorf("expected 404 after DELETE, got %d", res.StatusCode)
+	}
+}
+
+func TestErrorStatuses(t *testing.T) {
+	rejectKey := kvdb.WriteInterceptor{Name: "reject", Before: func(req *kvdb.WriteRequest) error {
+		if string(req.Key) == "readonly" {
+			return errors.New("read only key")
+		}
+		return nil
+	}}
+	server := helperServer(t, &kvdb.Options{MaxValueSize: 8, Interceptors: []kvdb.WriteInterceptor{rejectKey}})
+
+	tests := []struct {
+		method, path, contentType, body string
+		status                          int
+	}{
+		{"GET", "/keys/missing", "", "", http.StatusNotFound},
+		{"DELETE", "/keys/missing", "", "", http.StatusNotFound},
+		{"PUT", "/keys/key", "application/json", "{", http.StatusBadRequest},
+		{"PUT", "/keys/key", "text/plain", "\xff", http.StatusBadRequest},
+		{"PUT", "/keys/key", "image/png", "data", http.StatusUnsupportedMediaType},
+		{"PUT", "/keys/key", "text/plain;;", "data", http.StatusBadRequest},
+		{"PUT", "/keys/key", "", "more than eight bytes", http.StatusRequestEntityTooLarge},
+		{"PUT", "/keys/readonly", "", "data", http.StatusForbidden},
+		{"GET", "/keys?limit=0", "", "", http.StatusBadRequest},
+	}
+	for _, test := range tests {
+		res, body := doRequest(t, server, test.method, test.path, test.contentType, test.body)
+		if res.StatusCode != test.status {
+			t.Errorf("%s %s: expected %d, got %d (%s)", test.method, test.path, test.status, res.StatusCode, body)
+			continue
+		}
+		var response map[string]string
+		if err := json.Unmarshal([]byte(body), &response); err != nil || response["error"] == "" {
+			t.Errorf("%s %s: expected a JSON error, got %q", test.method, test.path, body)
+		}
+	}
+}
+
+func TestList(t *testing.T) {
+	server := helperServer(t, nil)
+	for _, key := range []string{"b/3", "a", "b/1", "c", "b/2", "b"} {
+		doRequest(t, server, "PUT", "/keys/"+key, "", "value")
+	}
+
+	list := func(query string) ([]string, string) {
+		t.Helper()
+		res, body := doRequest(t, server, "GET", "/keys"+query, "", "")
+		if res.StatusCode != http.StatusOK {
+			t.Fatalf("GET /keys%s: expected 200, got %d (%s)", query, res.StatusCode, body)
+		}
+		var response struct {
+			Keys       []string `json:"keys"`
+			Count      int      `json:"count"`
+			NextCursor string   `json:"next_cursor"`
+		}
+		if err := json.Unmarshal([]byte(body), &response); err != nil || response.Count != len(response.Keys) {
+			t.Fatalf("GET /keys%s: invalid response %q", query, body)
+		}
+		return response.Keys, response.NextCursor
+	}
+
+	if keys, next := list(""); strings.Join(keys, ",") != "a,b,b/1,b/2,b/3,c" || next != "" {
+		t.Errorf("unexpected keys %v (next %q)", keys, next)
+	}
+	if keys, next := list("?prefix=b/"); strings.Join(keys, ",") != "b/1,b/2,b/3" || next != "" {
+		t.Errorf("unexpected keys with prefix %v (next %q)", keys, next)
+	}
+	if keys, _ := list("?prefix=x"); len(keys) != 0 {
+		t.Errorf("expected no keys, got %v", keys)
+	}
+
+	// Page through the keys with the prefix
+	var all []string
+	query := "?prefix=b&limit=2"
+	for {
+		keys, next := list(query)
+		all = append(all, keys...)
+		if next == "" {
+			break
+		}
+		query = "?prefix=b&limit=2&cursor=" + url.QueryEscape(next)
+	}
+	if strings.Join(all, ",") != "b,b/1,b/2,b/3" {
+		t.Errorf("unexpected keys when paging %v", all)
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"time"
+
+	"github.com/ananthvk/kvdb"
+	"github.com/ananthvk/kvdb/cmd/kvhttp/internal"
+	"github.com/spf13/afero"
+)
+
+func main() {
+	portPtr := flag.Uint("port", 8080, "specify the port on which to listen")
+	hostPtr := flag.String("host", "0.0.0.0", "specify the bind address")
+	dbPtr := flag.String("db", "", "specify the datastore directory path, :memory for an in-memory datastore")
+	flag.Parse()
+	if *dbPtr == "" {
+		slog.Error("database directory path is required")
+		return
+	}
+
+	path := *dbPtr
+	var store *kvdb.DataStore
+	var err error
+	if path == ":memory" {
+		store, err = kvdb.CreateInMemory(nil)
+	} else {
+		fs := afero.NewOsFs()
+		store, err = kvdb.Open(fs, path)
+		if errors.Is(err, kvdb.ErrNotExist) {
+			store, err = kvdb.Create(fs, path)
+		}
+	}
+	if err != nil {
+		slog.Error("datastore could not be opened", "path", path, "error", err)
+		os.Exit(1)
+	}
+	defer func() {
+		slog.Info("closing store", "path", path)
+		store.Close()
+	}()
+
+	server := &http.Server{
+		Addr:              fmt.Sprintf("%s:%d", *hostPtr, *portPtr),
+		Handler:           (&internal.Server{Store: store}).Handler(),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	shutdownDone := make(chan struct{})
+	go func() {
+		defer close(shutdownDone)
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		server.Shutdown(shutdownCtx)
+	}()
+
+	slog.Info("http server listening", "address", server.Addr, "datastore", path)
+	if err := server.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+		slog.Error("server failed", "error", err)
+		return
+	}
+	// Wait for in-flight requests to finish before the store is closed
+	<-shutdownDone
+}
@@ -0,0 +1,272 @@
+package internal
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/ananthvk/kvdb/internal/resp"
+)
+
+/*
+Access control
+
+A shared server can be given an ACL file (-acl-file) that limits what each user can do, so that tenants sharing the
+server can't read or overwrite each other's keys:
+
+	{
+		"max_value_size": 1048576,
+		"users": {
+			"alice": {"password": "secret", "key_prefixes": ["alice:"], "max_value_size": 4096},
+			"default": {"key_prefixes": ["public:"]}
+		}
+	}
+
+Clients log in as a user with AUTH <username> <password>. Clients that have not sent AUTH with a username are the
+default user, whose password is the one set with -requirepass (a password for "default" in the file is ignored).
+
+max_value_size (in bytes) applies to every user, and a user's own max_value_size can only lower it. A user with
+key_prefixes can only use keys that start with one of the prefixes: commands that name other keys are rejected, KEYS only
+returns the user's keys, and the commands that work on the whole store (COMPACT, SYNC, REPLICAOF, REPLFILE, STANDBY,
+SHUTDOWN, DBSIZE, RANDOMKEY, SLOWLOG, MONITOR, SAVE, BGSAVE, ATTACH, DETACH, CLIENT LIST and CLIENT KILL) are
+rejected. A user without key_prefixes can use every key (in every database). ATTACH and DETACH open and close
+datastores on the server, so with an ACL file they are only allowed for users with "admin": true. Commands that are not
+allowed are rejected with a NOPERM error before they are run (or queued in a transaction, which then fails)
+*/
+
+const defaultUser = "default"
+
+var (
+	ErrCommandNotAllowed = errors.New("no permissions to run the command")
+	ErrKeyNotAllowed     = errors.New("no permissions to access the key")
+	ErrValueTooLarge     = errors.New("value is larger than the allowed size")
+)
+
+// ACLError is the error of a command that was rejected by the ACL, it wraps one of ErrCommandNotAllowed,
+// ErrKeyNotAllowed or ErrValueTooLarge
+type ACLError struct {
+	User    string
+	Command string
+	// Key that is not allowed, or whose value is too large, nil for ErrCommandNotAllowed
+	Key []byte
+	Err error
+}
+
+func (e *ACLError) Error() string {
+	if e.Key == nil {
+		return fmt.Sprintf("%s: user '%s', command '%s'", e.Err, e.User, e.Command)
+	}
+	return fmt.Sprintf("%s: user '%s', command '%s', key '%s'", e.Err, e.User, e.Command, e.Key)
+}
+
+func (e *ACLError) Unwrap() error {
+	return e.Err
+}
+
+// Value returns the reply sent to the client
+func (e *ACLError) Value() resp.Value {
+	return resp.Value{
+		Type:              resp.ValueTypeSimpleError,
+		SimpleErrorPrefix: []byte("NOPERM"),
+		Buffer:            []byte(e.Error()),
+	}
+}
+
+// ACL is the access control policy of the server, see LoadACL
+type ACL struct {
+	// Largest value (in bytes) that any user can write, 0 for no limit
+	MaxValueSize int                 `json:"max_value_size"`
+	Users        map[string]*ACLUser `json:"users"`
+}
+
+// ACLUser is the policy of a user
+type ACLUser struct {
+	Password string `json:"password"`
+	// Largest value (in bytes) the user can write, the smaller of this and the global limit applies. 0 for no limit
+	MaxValueSize int `json:"max_value_size"`
+	// Prefixes of the keys the user can access, every key if it's empty
+	KeyPrefixes []string `json:"key_prefixes"`
+	// Admin allows the commands that only administrators can run, see adminCommands
+	Admin bool `json:"admin"`
+}
+
+// Commands that work on the whole store, they are not allowed for users that are limited to some keys
+var storeCommands = map[string]bool{
+	"COMPACT":   true,
+	"SYNC":      true,
+	"REPLICAOF": true,
+	"REPLFILE":  true,
+	"STANDBY":   true,
+	"SHUTDOWN":  true,
+	"DBSIZE":    true,
+	"RANDOMKEY": true,
+	"SLOWLOG":   true,
+	"MONITOR":   true,
+	"SAVE":      true,
+	"BGSAVE":    true,
+	"ATTACH":    true,
+	"DETACH":    true,
+}
+
+// Commands that change the datastores of the server, only users with Admin can run them
+var adminCommands = map[string]bool{
+	"ATTACH": true,
+	"DETACH": true,
+}
+
+// LoadACL reads an ACL file, every user except the default user must have a password
+func LoadACL(path string) (*ACL, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var acl ACL
+	if err := json.Unmarshal(data, &acl); err != nil {
+		return nil, fmt.Errorf("invalid ACL file: %w", err)
+	}
+	if acl.MaxValueSize < 0 {
+		return nil, fmt.Errorf("invalid ACL file: negative max_value_size")
+	}
+	for name, user := range acl.Users {
+		if user == nil || (name != defaultUser && user.Password == "") {
+			return nil, fmt.Errorf("invalid ACL file: user '%s' has no password", name)
+		}
+		if user.MaxValueSize < 0 {
+			return nil, fmt.Errorf("invalid ACL file: negative max_value_size for user '%s'", name)
+		}
+	}
+	return &acl, nil
+}
+
+// authenticate returns true if the user is in the ACL, and the password is correct. The default user is authenticated
+// with RequirePass instead
+func (acl *ACL) authenticate(name string, password []byte) bool {
+	if acl == nil || name == defaultUser {
+		return false
+	}
+	user, ok := acl.Users[name]
+	if !ok {
+		return false
+	}
+	return subtle.ConstantTimeCompare(password, []byte(user.Password)) == 1
+}
+
+// maxValueSize returns the largest value the user can write, 0 for no limit
+func (acl *ACL) maxValueSize(name string) int {
+	limit := acl.MaxValueSize
+	if user := acl.Users[name]; user != nil && user.MaxValueSize > 0 && (limit == 0 || user.MaxValueSize < limit) {
+		limit = user.MaxValueSize
+	}
+	return limit
+}
+
+// keyAllowed returns true if the user can access the key
+func (acl *ACL) keyAllowed(name string, key []byte) bool {
+	if acl == nil {
+		return true
+	}
+	user := acl.Users[name]
+	if user == nil || len(user.KeyPrefixes) == 0 {
+		return true
+	}
+	for _, prefix := range user.KeyPrefixes {
+		if bytes.HasPrefix(key, []byte(prefix)) {
+			return true
+		}
+	}
+	return false
+}
+
+// isStoreCommand returns true if the command works on the whole store, or on other clients (CLIENT LIST and CLIENT KILL)
+func isStoreCommand(command string, args []resp.Value) bool {
+	if command == "CLIENT" && len(args) > 0 {
+		return bytes.EqualFold(args[0].Buffer, []byte("LIST")) || bytes.EqualFold(args[0].Buffer, []byte("KILL"))
+	}
+	return storeCommands[command]
+}
+
+// check returns an error if the user is not allowed to run the command with the given arguments, nil otherwise
+func (acl *ACL) check(name, command string, args []resp.Value) *ACLError {
+	if acl == nil {
+		return nil
+	}
+	user := acl.Users[name]
+	if user != nil && len(user.KeyPrefixes) > 0 && isStoreCommand(command, args) {
+		return &ACLError{User: name, Command: command, Err: ErrCommandNotAllowed}
+	}
+	if adminCommands[command] && (user == nil || !user.Admin) {
+		return &ACLError{User: name, Command: command, Err: ErrCommandNotAllowed}
+	}
+	keys, values := commandArgs(command, args)
+	for _, key := range keys {
+		if !acl.keyAllowed(name, key.Buffer) {
+			return &ACLError{User: name, Command: command, Key: key.Buffer, Err: ErrKeyNotAllowed}
+		}
+	}
+	if limit := acl.maxValueSize(name); limit > 0 {
+		for _, value := range values {
+			if len(value.Buffer) > limit {
+				return &ACLError{User: name, Command: command, Key: args[0].Buffer, Err: ErrValueTooLarge}
+			}
+		}
+	}
+	return nil
+}
+
+// checkValueSize returns an error if the user is not allowed to write a value of the given size to the key, for values
+// that are not passed to check, see stream.go
+func (acl *ACL) checkValueSize(name, command string, key []byte, size int64) *ACLError {
+	if acl == nil {
+		return nil
+	}
+	if limit := acl.maxValueSize(name); limit > 0 && size > int64(limit) {
+		return &ACLError{User: name, Command: command, Key: key, Err: ErrValueTooLarge}
+	}
+	return nil
+}
+
+// commandArgs returns the arguments of a command that are keys, and the ones that are values written to a key
+func commandArgs(command string, args []resp.Value) (keys, values []resp.Value) {
+	if len(args) == 0 {
+		return nil, nil
+	}
+	switch command {
+	case "GET", "GETDEL", "GETEX", "TYPE", "JSON.GET", "JSON.DEL", "HGET", "HDEL", "HGETALL", "HLEN",
+		"LPOP", "RPOP", "LLEN", "LRANGE", "SREM", "SMEMBERS", "SCARD", "SISMEMBER":
+		return args[:1], nil
+	case "HSET":
+		for i := 2; i < len(args); i += 2 {
+			values = append(values, args[i])
+		}
+		return args[:1], values
+	case "LPUSH", "RPUSH", "SADD":
+		return args[:1], args[1:]
+	case "SET", "SETNX", "GETSET":
+		return args[:1], args[1:min(len(args), 2)]
+	case "JSON.SET":
+		if len(args) >= 3 {
+			return args[:1], args[2:3]
+		}
+		return args[:1], nil
+	case "DEL", "WATCH":
+		return args, nil
+	}
+	return nil, nil
+}
+
+// filterKeys returns the keys the user can access, keys is modified in place
+func (acl *ACL) filterKeys(name string, keys []string) []string {
+	if acl == nil {
+		return keys
+	}
+	allowed := keys[:0]
+	for _, key := range keys {
+		if acl.keyAllowed(name, []byte(key)) {
+			allowed = append(allowed, key)
+		}
+	}
+	return allowed
+}
@@ -0,0 +1,169 @@
+package internal
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/ananthvk/kvdb/internal/resp"
+)
+
+const testACL = `{
+	"max_value_size": 16,
+	"users": {
+		"alice": {"password": "alicepass", "key_prefixes": ["alice:"], "max_value_size": 8},
+		"admin": {"password": "adminpass", "admin": true}
+	}
+}`
+
+func helperLoadACL(t *testing.T, contents string) (*ACL, error) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "acl.json")
+	if err := os.WriteFile(path, []byte(contents), 0666); err != nil {
+		t.Fatalf("could not write ACL file: %v", err)
+	}
+	return LoadACL(path)
+}
+
+// helperCommand sends a command over the connection, and returns the reply
+func helperCommand(t *testing.T, conn net.Conn, reader *bufio.Reader, args ...string) resp.Value {
+	t.Helper()
+	values := make([]resp.Value, len(args))
+	for i, arg := range args {
+		values[i] = resp.Value{Type: resp.ValueTypeBulkString, Buffer: []byte(arg)}
+	}
+	writer := bufio.NewWriter(conn)
+	if err := sendResponse(resp.Value{Type: resp.ValueTypeArray, Array: values}, writer); err != nil {
+		t.Fatalf("could not send %v: %v", args, err)
+	}
+	reply, err := resp.Deserialize(reader)
+	if err != nil {
+		t.Fatalf("could not read the reply of %v: %v", args, err)
+	}
+	return reply
+}
+
+func TestLoadACL(t *testing.T) {
+	acl, err := helperLoadACL(t, testACL)
+	if err != nil {
+		t.Fatalf("could not load ACL: %v", err)
+	}
+	if acl.maxValueSize("alice") != 8 || acl.maxValueSize("admin") != 16 || acl.maxValueSize(defaultUser) != 16 {
+		t.Errorf("unexpected value size limits: %d, %d, %d", acl.maxValueSize("alice"), acl.maxValueSize("admin"),
+			acl.maxValueSize(defaultUser))
+	}
+	for _, contents := range []string{
+		`{"users": {"bob": {}}}`,
+		`{"users": {"bob": {"password": "x", "max_value_size": -1}}}`,
+		`{"max_value_size": -1}`,
+		`not json`,
+	} {
+		if _, err := helperLoadACL(t, contents); err == nil {
+			t.Errorf("%s: expected an error", contents)
+		}
+	}
+}
+
+func TestACLCheck(t *testing.T) {
+	acl, err := helperLoadACL(t, testACL)
+	if err != nil {
+		t.Fatalf("could not load ACL: %v", err)
+	}
+	args := func(args ...string) []resp.Value {
+		values := make([]resp.Value, len(args))
+		for i, arg := range args {
+			values[i] = resp.Value{Type: resp.ValueTypeBulkString, Buffer: []byte(arg)}
+		}
+		return values
+	}
+	tests := []struct {
+		user, command string
+		args          []resp.Value
+		expected      error
+	}{
+		{"alice", "GET", args("alice:1"), nil},
+		{"alice", "GET", args("bob:1"), ErrKeyNotAllowed},
+		{"alice", "DEL", args("alice:1", "bob:1"), ErrKeyNotAllowed},
+		{"alice", "WATCH", args("bob:1"), ErrKeyNotAllowed},
+		{"alice", "SET", args("alice:1", "12345678"), nil},
+		{"alice", "SET", args("alice:1", "123456789"), ErrValueTooLarge},
+		{"alice", "JSON.SET", args("alice:1", "$", `"1234567"`), ErrValueTooLarge},
+		{"alice", "SET", args("alice:1"), nil},
+		{"alice", "COMPACT", nil, ErrCommandNotAllowed},
+		{"alice", "SHUTDOWN", args("NOSAVE"), ErrCommandNotAllowed},
+		{"alice", "PING", nil, nil},
+		{"alice", "CLIENT", args("kill", "ID", "1"), ErrCommandNotAllowed},
+		{"alice", "CLIENT", args("SETNAME", "worker"), nil},
+		{"admin", "GET", args("bob:1"), nil},
+		{"admin", "COMPACT", nil, nil},
+		{"admin", "SET", args("bob:1", "12345678901234567"), ErrValueTooLarge},
+		{defaultUser, "SET", args("bob:1", "1234567890123456"), nil},
+		{"admin", "ATTACH", args("other"), nil},
+		{defaultUser, "ATTACH", args("other"), ErrCommandNotAllowed},
+		{defaultUser, "DETACH", args("1"), ErrCommandNotAllowed},
+	}
+	for _, test := range tests {
+		err := acl.check(test.user, test.command, test.args)
+		if test.expected == nil && err != nil {
+			t.Errorf("%s %s: expected no error, got %v", test.user, test.command, err)
+		}
+		if test.expected != nil && (err == nil || !errors.Is(err, test.expected)) {
+			t.Errorf("%s %s: expected %v, got %v", test.user, test.command, test.expected, err)
+		}
+	}
+}
+
+func TestACLServer(t *testing.T) {
+	store := helperMemoryStore(t)
+	acl, err := helperLoadACL(t, testACL)
+	if err != nil {
+		t.Fatalf("could not load ACL: %v", err)
+	}
+	store.ACL = acl
+	store.Store.Put([]byte("alice:1"), []byte("a"))
+	store.Store.Put([]byte("bob:1"), []byte("b"))
+	conn, err := net.Dial("tcp", helperServe(t, store))
+	if err != nil {
+		t.Fatalf("could not connect: %v", err)
+	}
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+
+	if reply := helperCommand(t, conn, reader, "AUTH", "alice", "wrong"); string(reply.SimpleErrorPrefix) != "WRONGPASS" {
+		t.Fatalf("expected WRONGPASS, got %+v", reply)
+	}
+	if reply := helperCommand(t, conn, reader, "AUTH", "alice", "alicepass"); reply.Type != resp.ValueTypeSimpleString {
+		t.Fatalf("expected AUTH to succeed, got %+v", reply)
+	}
+	if reply := helperCommand(t, conn, reader, "GET", "bob:1"); string(reply.SimpleErrorPrefix) != "NOPERM" {
+		t.Errorf("expected NOPERM, got %+v", reply)
+	}
+	if reply := helperCommand(t, conn, reader, "GET", "alice:1"); string(reply.Buffer) != "a" {
+		t.Errorf("expected a, got %+v", reply)
+	}
+	reply := helperCommand(t, conn, reader, "KEYS", "*")
+	keys := []string{}
+	for _, value := range reply.Array {
+		keys = append(keys, string(value.Buffer))
+	}
+	if !slices.Equal(keys, []string{"alice:1"}) {
+		t.Errorf("expected only alice's keys, got %v", keys)
+	}
+
+	// A rejected command aborts the transaction
+	helperCommand(t, conn, reader, "MULTI")
+	helperCommand(t, conn, reader, "SET", "alice:2", "x")
+	if reply := helperCommand(t, conn, reader, "SET", "bob:2", "x"); string(reply.SimpleErrorPrefix) != "NOPERM" {
+		t.Errorf("expected NOPERM, got %+v", reply)
+	}
+	if reply := helperCommand(t, conn, reader, "EXEC"); string(reply.SimpleErrorPrefix) != "EXECABORT" {
+		t.Errorf("expected EXECABORT, got %+v", reply)
+	}
+	if _, err := store.Store.Get([]byte("alice:2")); err == nil {
+		t.Errorf("expected the aborted transaction not to write alice:2")
+	}
+}
@@ -0,0 +1,159 @@
+package internal
+
+import (
+	"bufio"
+	"log/slog"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ananthvk/kvdb/internal/resp"
+)
+
+// Maximum number of replies and messages that can be queued for a subscribed client. If a client does not
+// read fast enough and the queue fills up, the client is disconnected
+const pushQueueSize = 1024
+
+// Client holds the per-connection state of a connected client
+type Client struct {
+	Conn net.Conn
+	// ID is a unique number of the connection, assigned when the client is registered
+	ID int64
+	// ConnectedAt is the time at which the client connected
+	ConnectedAt time.Time
+	// Authenticated is set once the client has issued a successful AUTH (or if no password is required)
+	Authenticated bool
+	// User is the user the client is logged in as, the default user until AUTH is sent with a username. It's only
+	// modified by the connection's goroutine, with infoMu held, other goroutines read it with infoMu held
+	User string
+
+	reader  *bufio.Reader
+	writeMu sync.Mutex
+	writer  *bufio.Writer
+	// Bytes held by replies and messages in pushQueue, and by the commands queued in tx
+	queuedBytes atomic.Int64
+
+	// Channels and patterns the client is subscribed to, these are only modified by the connection's goroutine
+	// (while holding the PubSub lock)
+	channels map[string]bool
+	patterns map[string]bool
+
+	// Once a client subscribes, all replies are sent through pushQueue, so that they are ordered with the
+	// published messages. pushQueue is only accessed by the connection's goroutine, or with the PubSub lock held
+	pushQueue chan resp.Value
+	pushDone  chan struct{}
+
+	// tx is non nil between MULTI and EXEC / DISCARD
+	tx *transaction
+	// Keys watched by the client (modified with the KVStore watchedKeys lock held), and txDirty is set when any of them
+	// is modified
+	watchedKeys map[string]bool
+	txDirty     atomic.Bool
+
+	// selectedDB is the number of the database selected with SELECT, see databases.go
+	selectedDB atomic.Int64
+
+	// isReplica is set once the client has issued SYNC, after that the connection only carries replication data
+	isReplica bool
+
+	// The name set with CLIENT SETNAME, and the last command of the client and when it was received. They are read by
+	// CLIENT LIST from other connections, so they are protected by infoMu
+	infoMu      sync.Mutex
+	name        string
+	lastCommand string
+	lastActive  time.Time
+}
+
+// NewClient returns the state for a newly accepted connection
+func NewClient(conn net.Conn, requirePass string) *Client {
+	now := time.Now()
+	return &Client{
+		Conn:          conn,
+		ConnectedAt:   now,
+		lastActive:    now,
+		Authenticated: requirePass == "",
+		User:          defaultUser,
+		reader:        bufio.NewReaderSize(conn, clientBufferSize),
+		writer:        bufio.NewWriterSize(conn, clientBufferSize),
+	}
+}
+
+// MemoryUsage returns the bytes used by the client's read and write buffers, and by the values queued for it
+func (c *Client) MemoryUsage() int64 {
+	return int64(c.reader.Size()+c.writer.Size()) + c.queuedBytes.Load()
+}
+
+// valueSize returns the number of bytes held by the value (and it's elements)
+func valueSize(value resp.Value) int64 {
+	size := int64(len(value.Buffer) + len(value.SimpleErrorPrefix))
+	for _, element := range value.Array {
+		size += valueSize(element)
+	}
+	return size
+}
+
+// Send writes the value to the client. If the client is in subscribed mode, the value is queued instead
+func (c *Client) Send(value resp.Value) error {
+	if c.pushQueue != nil {
+		c.queuedBytes.Add(valueSize(value))
+		c.pushQueue <- value
+		return nil
+	}
+	return c.write(value)
+}
+
+func (c *Client) write(value resp.Value) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	return sendResponse(value, c.writer)
+}
+
+// IsSubscribed returns true if the client is subscribed to atleast one channel or pattern
+func (c *Client) IsSubscribed() bool {
+	return len(c.channels) > 0 || len(c.patterns) > 0
+}
+
+// startPushQueue starts the goroutine that writes queued values to the client
+func (c *Client) startPushQueue() {
+	if c.pushQueue != nil {
+		return
+	}
+	c.pushQueue = make(chan resp.Value, pushQueueSize)
+	c.pushDone = make(chan struct{})
+	go func(queue chan resp.Value) {
+		defer close(c.pushDone)
+		for value := range queue {
+			err := c.write(value)
+			c.queuedBytes.Add(-valueSize(value))
+			if err != nil {
+				slog.Warn("could not push to client", "remote_address", c.Conn.RemoteAddr().String(), "error", err)
+				// Closing the connection makes the connection's goroutine exit, which closes the queue
+				c.Conn.Close()
+			}
+		}
+	}(c.pushQueue)
+}
+
+// tryPush queues a message without blocking. If the queue is full, the client is disconnected, since it's not keeping up
+func (c *Client) tryPush(value resp.Value) {
+	size := valueSize(value)
+	c.queuedBytes.Add(size)
+	select {
+	case c.pushQueue <- value:
+	default:
+		c.queuedBytes.Add(-size)
+		slog.Warn("disconnecting slow subscriber", "remote_address", c.Conn.RemoteAddr().String())
+		c.Conn.Close()
+	}
+}
+
+// closePushQueue stops the push goroutine after all queued values have been written. The client must not be
+// subscribed to anything when this is called
+func (c *Client) closePushQueue() {
+	if c.pushQueue == nil {
+		return
+	}
+	close(c.pushQueue)
+	<-c.pushDone
+}
@@ -0,0 +1,179 @@
+package internal
+
+import (
+	"bytes"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ananthvk/kvdb/internal/resp"
+)
+
+/*
+Client administration
+
+Every connection gets an id when it's accepted (incremented for every connection, starting from 1), and the server
+keeps the time it connected, and the last command it sent and when. The CLIENT command lets operators inspect the
+connected clients and disconnect misbehaving ones:
+
+  - CLIENT LIST returns a line per client, ordered by id, in the format of Redis:
+    id=3 addr=127.0.0.1:53412 name=worker age=12 idle=0 flags=N db=0 cmd=get user=default tot-mem=8192
+    age and idle are in seconds, flags is O for a monitor, S for a replica, and N for a normal client, db is the
+    selected database (see databases.go)
+  - CLIENT KILL addr disconnects the client connected from addr, CLIENT KILL <filter> <value> ... disconnects the
+    clients that match every filter (ID id, ADDR addr, USER username, SKIPME yes/no) and returns how many were
+    disconnected. SKIPME is yes by default, i.e. the client sending the command is not disconnected
+  - CLIENT SETNAME name names the connection (an empty name removes it), CLIENT GETNAME returns the name
+  - CLIENT ID returns the id of the connection
+
+A client is disconnected by closing it's connection, so a command that's running finishes, but it's reply is not sent.
+CLIENT LIST and CLIENT KILL are not allowed for users limited to some keys (see acl.go)
+*/
+
+// setLastCommand records the command (lower case name) the client sent at the given time
+func (c *Client) setLastCommand(name string, received time.Time) {
+	c.infoMu.Lock()
+	defer c.infoMu.Unlock()
+	c.lastCommand = name
+	c.lastActive = received
+}
+
+// setUser changes the user the client is logged in as
+func (c *Client) setUser(user string) {
+	c.infoMu.Lock()
+	defer c.infoMu.Unlock()
+	c.User = user
+}
+
+// clientList returns the connected clients, ordered by id
+func (kv *KVStore) clientList() []*Client {
+	kv.clientsMu.Lock()
+	clients := make([]*Client, 0, len(kv.clients))
+	for client := range kv.clients {
+		clients = append(clients, client)
+	}
+	kv.clientsMu.Unlock()
+	slices.SortFunc(clients, func(a, b *Client) int { return int(a.ID - b.ID) })
+	return clients
+}
+
+// clientInfo returns the CLIENT LIST line of the client
+func (kv *KVStore) clientInfo(client *Client, now time.Time) string {
+	flags := "N"
+	kv.monitorsMu.Lock()
+	if kv.monitors[client] {
+		flags = "O"
+	}
+	kv.monitorsMu.Unlock()
+	if kv.Replication != nil && kv.Replication.isReplica(client) {
+		flags = "S"
+	}
+	client.infoMu.Lock()
+	name, lastCommand, lastActive, user := client.name, client.lastCommand, client.lastActive, client.User
+	client.infoMu.Unlock()
+	if lastCommand == "" {
+		lastCommand = "NULL"
+	}
+	return fmt.Sprintf("id=%d addr=%s name=%s age=%d idle=%d flags=%s db=%d cmd=%s user=%s tot-mem=%d",
+		client.ID, client.Conn.RemoteAddr(), name, int64(now.Sub(client.ConnectedAt).Seconds()),
+		int64(now.Sub(lastActive).Seconds()), flags, client.selectedDB.Load(), lastCommand, user, client.MemoryUsage())
+}
+
+// CLIENT LIST | KILL | SETNAME | GETNAME | ID
+func handleClient(args []resp.Value, store *KVStore, client *Client) resp.Value {
+	if len(args) == 0 {
+		return errorValue([]byte("wrong number of arguments for 'CLIENT' command"))
+	}
+	subcommand := string(bytes.ToUpper(args[0].Buffer))
+	switch {
+	case subcommand == "LIST" && len(args) == 1:
+		var list strings.Builder
+		now := time.Now()
+		for _, connected := range store.clientList() {
+			list.WriteString(store.clientInfo(connected, now))
+			list.WriteString("\n")
+		}
+		return resp.Value{Type: resp.ValueTypeBulkString, Buffer: []byte(list.String())}
+	case subcommand == "KILL" && len(args) == 2:
+		// The old form, CLIENT KILL addr
+		for _, connected := range store.clientList() {
+			if connected.Conn.RemoteAddr().String() == string(args[1].Buffer) {
+				connected.Conn.Close()
+				return resp.Value{Type: resp.ValueTypeSimpleString, Buffer: []byte("OK")}
+			}
+		}
+		return errorValue([]byte("No such client"))
+	case subcommand == "KILL" && len(args) >= 3 && len(args)%2 == 1:
+		return killClients(args[1:], store, client)
+	case subcommand == "SETNAME" && len(args) == 2:
+		name := string(args[1].Buffer)
+		for _, c := range name {
+			if c <= ' ' || c > '~' {
+				return errorValue([]byte("Client names cannot contain spaces, newlines or special characters."))
+			}
+		}
+		client.infoMu.Lock()
+		client.name = name
+		client.infoMu.Unlock()
+		return resp.Value{Type: resp.ValueTypeSimpleString, Buffer: []byte("OK")}
+	case subcommand == "GETNAME" && len(args) == 1:
+		client.infoMu.Lock()
+		name := client.name
+		client.infoMu.Unlock()
+		if name == "" {
+			return resp.Value{Type: resp.ValueTypeNull}
+		}
+		return resp.Value{Type: resp.ValueTypeBulkString, Buffer: []byte(name)}
+	case subcommand == "ID" && len(args) == 1:
+		return resp.Value{Type: resp.ValueTypeInteger, Integer: client.ID}
+	}
+	return errorValue([]byte("unknown subcommand or wrong number of arguments for 'CLIENT' command"))
+}
+
+// killClients disconnects the clients that match every filter, and returns how many were disconnected
+func killClients(filters []resp.Value, store *KVStore, client *Client) resp.Value {
+	var id int64
+	var addr, user *string
+	skipMe := true
+	for i := 0; i < len(filters); i += 2 {
+		value := string(filters[i+1].Buffer)
+		switch string(bytes.ToUpper(filters[i].Buffer)) {
+		case "ID":
+			var err error
+			if id, err = strconv.ParseInt(value, 10, 64); err != nil || id <= 0 {
+				return errorValue([]byte("client-id should be greater than 0"))
+			}
+		case "ADDR":
+			addr = &value
+		case "USER":
+			user = &value
+		case "SKIPME":
+			switch strings.ToLower(value) {
+			case "yes":
+				skipMe = true
+			case "no":
+				skipMe = false
+			default:
+				return errorValue([]byte("syntax error"))
+			}
+		default:
+			return errorValue([]byte("syntax error"))
+		}
+	}
+
+	killed := 0
+	for _, connected := range store.clientList() {
+		connected.infoMu.Lock()
+		connectedUser := connected.User
+		connected.infoMu.Unlock()
+		if (skipMe && connected == client) || (id != 0 && connected.ID != id) ||
+			(addr != nil && connected.Conn.RemoteAddr().String() != *addr) || (user != nil && connectedUser != *user) {
+			continue
+		}
+		connected.Conn.Close()
+		killed++
+	}
+	return resp.Value{Type: resp.ValueTypeInteger, Integer: int64(killed)}
+}
@@ -0,0 +1,84 @@
+package internal
+
+import (
+	"bufio"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/ananthvk/kvdb/internal/resp"
+)
+
+func TestClientCommands(t *testing.T) {
+	store := helperMemoryStore(t)
+	address := helperServe(t, store)
+	conn, err := net.Dial("tcp", address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	other, err := net.Dial("tcp", address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Close()
+	otherReader := bufio.NewReader(other)
+
+	id := helperCommand(t, conn, reader, "CLIENT", "ID")
+	otherID := helperCommand(t, other, otherReader, "CLIENT", "ID")
+	if id.Type != resp.ValueTypeInteger || otherID.Integer <= id.Integer {
+		t.Fatalf("expected increasing ids, got %+v and %+v", id, otherID)
+	}
+
+	if reply := helperCommand(t, conn, reader, "CLIENT", "GETNAME"); reply.Type != resp.ValueTypeNull {
+		t.Errorf("GETNAME: expected Null before SETNAME, got %+v", reply)
+	}
+	if reply := helperCommand(t, conn, reader, "CLIENT", "SETNAME", "has space"); reply.Type != resp.ValueTypeSimpleError {
+		t.Errorf("SETNAME: expected an error for a name with a space, got %+v", reply)
+	}
+	helperCommand(t, conn, reader, "CLIENT", "SETNAME", "worker")
+	if reply := helperCommand(t, conn, reader, "CLIENT", "GETNAME"); string(reply.Buffer) != "worker" {
+		t.Errorf("GETNAME: expected worker, got %+v", reply)
+	}
+
+	helperCommand(t, other, otherReader, "GET", "key")
+	reply := helperCommand(t, conn, reader, "CLIENT", "LIST")
+	lines := strings.Split(strings.TrimSuffix(string(reply.Buffer), "\n"), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("LIST: expected 2 clients, got %q", reply.Buffer)
+	}
+	for _, want := range []string{"addr=" + conn.LocalAddr().String(), "name=worker", "cmd=client", "flags=N"} {
+		if !strings.Contains(lines[0], want) {
+			t.Errorf("LIST: expected %s in %q", want, lines[0])
+		}
+	}
+	if !strings.Contains(lines[1], "cmd=get") || !strings.Contains(lines[1], "user=default") {
+		t.Errorf("LIST: expected the last command and user of the other client in %q", lines[1])
+	}
+
+	// The sending client is skipped by default
+	if reply := helperCommand(t, conn, reader, "CLIENT", "KILL", "USER", "default"); reply.Integer != 1 {
+		t.Errorf("KILL USER: expected 1 client killed, got %+v", reply)
+	}
+	if _, err := resp.Deserialize(otherReader); err == nil {
+		t.Errorf("expected the other client to be disconnected")
+	}
+	waitFor(t, "the killed client to be unregistered", func() bool { return len(store.clientList()) == 1 })
+
+	if reply := helperCommand(t, conn, reader, "CLIENT", "KILL", "127.0.0.1:1"); reply.Type != resp.ValueTypeSimpleError {
+		t.Errorf("KILL addr: expected an error for an unknown address, got %+v", reply)
+	}
+	if reply := helperCommand(t, conn, reader, "CLIENT", "KILL", "ID", "0"); reply.Type != resp.ValueTypeSimpleError {
+		t.Errorf("KILL ID: expected an error for id 0, got %+v", reply)
+	}
+
+	// With SKIPME no a client can disconnect itself, the reply is not sent
+	kill := bulkArgs("CLIENT", "KILL", "ADDR", conn.LocalAddr().String(), "SKIPME", "no")
+	if err := sendResponse(resp.Value{Type: resp.ValueTypeArray, Array: kill}, bufio.NewWriter(conn)); err != nil {
+		t.Fatal(err)
+	}
+	if reply, err := resp.Deserialize(reader); err == nil {
+		t.Errorf("KILL SKIPME no: expected the client to be disconnected, got %+v", reply)
+	}
+}
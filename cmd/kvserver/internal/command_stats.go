@@ -0,0 +1,148 @@
+package internal
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"math/bits"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+/*
+Command statistics
+
+Every command that's run is counted per command name, with the total time it ran for, the number of calls that returned
+an error, and a histogram of it's latencies. The histogram has a bucket per power of two microseconds, so the percentiles
+are estimates: a percentile is reported as the upper bound of the bucket it falls in, at most twice the real latency.
+The time is measured like for the slow log (see slowlog.go), and the commands of a transaction are counted one by one.
+
+The statistics of a command are only updated with atomics, they are created for every command of the command table
+when the server starts, so that the commands of concurrent clients don't contend on a lock. They are reported in the
+commandstats and latencystats sections of INFO, and by the metrics endpoint (see metrics.go)
+*/
+
+// Number of buckets of a latency histogram, the last bucket holds the commands that took more than 2^(n-2)
+// microseconds (about 10 days)
+const latencyBuckets = 41
+
+// Percentiles reported for each command
+var latencyPercentiles = []float64{50, 99, 99.9}
+
+type commandStat struct {
+	calls       atomic.Uint64
+	failedCalls atomic.Uint64
+	usec        atomic.Uint64
+	// Bucket i counts the calls that took less than 2^i microseconds (and at least 2^(i-1))
+	latencies [latencyBuckets]atomic.Uint64
+}
+
+// commandStats holds the statistics of every command, indexed by the upper case name of the command
+type commandStats map[string]*commandStat
+
+// newCommandStats returns empty statistics for the commands of the command table
+func newCommandStats() commandStats {
+	stats := make(commandStats, len(Commands))
+	for name := range Commands {
+		stats[name] = &commandStat{}
+	}
+	return stats
+}
+
+// record counts a call of the command, it's ignored if the command is not in the command table
+func (s commandStats) record(name []byte, duration time.Duration, failed bool) {
+	stat := s[string(name)]
+	if stat == nil {
+		return
+	}
+	usec := uint64(max(duration.Microseconds(), 0))
+	stat.calls.Add(1)
+	stat.usec.Add(usec)
+	if failed {
+		stat.failedCalls.Add(1)
+	}
+	stat.latencies[min(bits.Len64(usec), latencyBuckets-1)].Add(1)
+}
+
+// percentile returns an estimate of the p-th percentile of the latencies (in microseconds), 0 if there were no calls
+func (stat *commandStat) percentile(p float64) uint64 {
+	var counts [latencyBuckets]uint64
+	var total uint64
+	for i := range counts {
+		counts[i] = stat.latencies[i].Load()
+		total += counts[i]
+	}
+	if total == 0 {
+		return 0
+	}
+	// The nearest rank, i.e. the smallest latency that's at least as large as p percent of the latencies
+	rank := max(uint64(math.Ceil(p/100*float64(total))), 1)
+	var seen uint64
+	for i, count := range counts {
+		seen += count
+		if seen >= rank {
+			return 1 << i
+		}
+	}
+	return 1 << (latencyBuckets - 1)
+}
+
+// called returns the names of the commands that have been called, in alphabetical order
+func (s commandStats) called() []string {
+	var names []string
+	for name, stat := range s {
+		if stat.calls.Load() > 0 {
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names)
+	return names
+}
+
+func writeInfoCommandStats(buf *bytes.Buffer, store *KVStore) error {
+	for _, name := range store.commandStats.called() {
+		stat := store.commandStats[name]
+		calls, usec := stat.calls.Load(), stat.usec.Load()
+		fmt.Fprintf(buf, "cmdstat_%s:calls=%d,usec=%d,usec_per_call=%.2f,failed_calls=%d\r\n",
+			strings.ToLower(name), calls, usec, float64(usec)/float64(max(calls, 1)), stat.failedCalls.Load())
+	}
+	return nil
+}
+
+func writeInfoLatencyStats(buf *bytes.Buffer, store *KVStore) error {
+	for _, name := range store.commandStats.called() {
+		stat := store.commandStats[name]
+		percentiles := make([]string, 0, len(latencyPercentiles))
+		for _, p := range latencyPercentiles {
+			percentiles = append(percentiles, fmt.Sprintf("p%g=%d", p, stat.percentile(p)))
+		}
+		fmt.Fprintf(buf, "latency_percentiles_usec_%s:%s\r\n", strings.ToLower(name), strings.Join(percentiles, ","))
+	}
+	return nil
+}
+
+// writeCommandMetrics writes the statistics of the commands that have been called in the Prometheus text format, with
+// the command as a label
+func writeCommandMetrics(buf *bytes.Buffer, store *KVStore) {
+	names := store.commandStats.called()
+	buf.WriteString("# HELP kvdb_command_failed_calls_total Number of calls of a command that returned an error\n")
+	buf.WriteString("# TYPE kvdb_command_failed_calls_total counter\n")
+	for _, name := range names {
+		failed := store.commandStats[name].failedCalls.Load()
+		fmt.Fprintf(buf, "kvdb_command_failed_calls_total{command=%q} %d\n", strings.ToLower(name), failed)
+	}
+	buf.WriteString("# HELP kvdb_command_duration_seconds Time a command ran for (the quantiles are estimates)\n")
+	buf.WriteString("# TYPE kvdb_command_duration_seconds summary\n")
+	for _, name := range names {
+		stat := store.commandStats[name]
+		label := strings.ToLower(name)
+		for _, p := range latencyPercentiles {
+			seconds := float64(stat.percentile(p)) / 1e6
+			fmt.Fprintf(buf, "kvdb_command_duration_seconds{command=%q,quantile=\"%.4g\"} %g\n", label, p/100, seconds)
+		}
+		fmt.Fprintf(buf, "kvdb_command_duration_seconds_sum{command=%q} %g\n", label, float64(stat.usec.Load())/1e6)
+		fmt.Fprintf(buf, "kvdb_command_duration_seconds_count{command=%q} %d\n", label, stat.calls.Load())
+	}
+}
@@ -0,0 +1,64 @@
+package internal
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCommandStats(t *testing.T) {
+	stats := newCommandStats()
+	for range 98 {
+		stats.record([]byte("GET"), 3*time.Microsecond, false)
+	}
+	stats.record([]byte("GET"), time.Millisecond, true)
+	stats.record([]byte("GET"), time.Second, false)
+	stats.record([]byte("NOSUCHCOMMAND"), time.Second, false)
+
+	get := stats["GET"]
+	if get.calls.Load() != 100 || get.failedCalls.Load() != 1 {
+		t.Errorf("expected 100 calls and 1 failed call, got %d and %d", get.calls.Load(), get.failedCalls.Load())
+	}
+	// Percentiles are the upper bound of their bucket
+	for p, want := range map[float64]uint64{50: 4, 99: 1024, 99.9: 1 << 20} {
+		if got := get.percentile(p); got != want {
+			t.Errorf("p%g: expected %d, got %d", p, want, got)
+		}
+	}
+	if names := stats.called(); len(names) != 1 || names[0] != "GET" {
+		t.Errorf("expected only GET to be reported, got %v", names)
+	}
+}
+
+func TestCommandStatsReported(t *testing.T) {
+	store := helperMemoryStore(t)
+	store.commandStats.record([]byte("SET"), 10*time.Microsecond, false)
+	store.commandStats.record([]byte("SET"), 30*time.Microsecond, true)
+
+	var buf bytes.Buffer
+	writeInfoCommandStats(&buf, store)
+	writeInfoLatencyStats(&buf, store)
+	for _, want := range []string{
+		"cmdstat_set:calls=2,usec=40,usec_per_call=20.00,failed_calls=1\r\n",
+		"latency_percentiles_usec_set:p50=16,p99=32,p99.9=32\r\n",
+	} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("expected INFO to contain %q, got:\n%s", want, buf.String())
+		}
+	}
+	buf.Reset()
+	if err := store.writeMetrics(&buf); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		`kvdb_command_failed_calls_total{command="set"} 1`,
+		`kvdb_command_duration_seconds{command="set",quantile="0.5"} 1.6e-05`,
+		`kvdb_command_duration_seconds{command="set",quantile="0.999"} 3.2e-05`,
+		`kvdb_command_duration_seconds_count{command="set"} 2`,
+	} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("expected the metrics to contain %q, got:\n%s", want, buf.String())
+		}
+	}
+}
@@ -1,14 +1,17 @@
 package internal
 
 import (
+	"crypto/subtle"
 	"errors"
 	"sort"
+	"strconv"
+	"strings"
 
 	"github.com/ananthvk/kvdb"
 	"github.com/ananthvk/kvdb/internal/resp"
 )
 
-func handleEcho(args []resp.Value, store *KVStore) resp.Value {
+func handleEcho(args []resp.Value, store *KVStore, client *Client) resp.Value {
 	if len(args) != 1 {
 		return resp.Value{
 			Type:              resp.ValueTypeSimpleError,
@@ -23,7 +26,7 @@ func handleEcho(args []resp.Value, store *KVStore) resp.Value {
 	}
 }
 
-func handlePing(args []resp.Value, store *KVStore) resp.Value {
+func handlePing(args []resp.Value, store *KVStore, client *Client) resp.Value {
 	switch len(args) {
 	case 0:
 		return resp.Value{
@@ -44,7 +47,7 @@ func handlePing(args []resp.Value, store *KVStore) resp.Value {
 	}
 }
 
-func handleGet(args []resp.Value, store *KVStore) resp.Value {
+func handleGet(args []resp.Value, store *KVStore, client *Client) resp.Value {
 	if len(args) != 1 {
 		return resp.Value{
 			Type:              resp.ValueTypeSimpleError,
@@ -52,7 +55,7 @@ func handleGet(args []resp.Value, store *KVStore) resp.Value {
 			Buffer:            []byte("wrong number of arguments for 'GET' command"),
 		}
 	}
-	value, err := store.Store.Get(args[0].Buffer)
+	value, t, err := store.db(client).GetWithType(args[0].Buffer)
 	if err != nil {
 		if errors.Is(err, kvdb.ErrKeyNotFound) {
 			return resp.Value{Type: resp.ValueTypeNull}
@@ -63,22 +66,112 @@ func handleGet(args []resp.Value, store *KVStore) resp.Value {
 			Buffer:            []byte(err.Error()),
 		}
 	}
+	if t != kvdb.ValueTypeString {
+		return wrongTypeValue()
+	}
 	return resp.Value{
 		Type:   resp.ValueTypeBulkString,
 		Buffer: value,
 	}
 }
 
-func handleSet(args []resp.Value, store *KVStore) resp.Value {
-	if len(args) != 2 {
+// setOptions are the options of a SET command
+type setOptions struct {
+	nx, xx, get bool
+}
+
+// parseSetOptions parses the options of SET key value [NX | XX] [GET] [EX seconds | PX milliseconds |
+// EXAT unix-time-seconds | PXAT unix-time-milliseconds | KEEPTTL]. Keys do not expire, so KEEPTTL does nothing, and the
+// expiry options are rejected once the command is known to be valid
+func parseSetOptions(args []resp.Value) (setOptions, *resp.Value) {
+	var opts setOptions
+	expiry, keepTTL := false, false
+	for i := 0; i < len(args); i++ {
+		switch option := strings.ToUpper(string(args[i].Buffer)); option {
+		case "NX", "XX":
+			if opts.nx || opts.xx {
+				return opts, syntaxError()
+			}
+			opts.nx, opts.xx = option == "NX", option == "XX"
+		case "GET":
+			opts.get = true
+		case "KEEPTTL":
+			if expiry || keepTTL {
+				return opts, syntaxError()
+			}
+			keepTTL = true
+		case "EX", "PX", "EXAT", "PXAT":
+			if expiry || keepTTL || i+1 == len(args) {
+				return opts, syntaxError()
+			}
+			i++
+			n, err := strconv.ParseInt(string(args[i].Buffer), 10, 64)
+			if err != nil {
+				reply := errorValue([]byte("value is not an integer or out of range"))
+				return opts, &reply
+			}
+			if n <= 0 {
+				reply := errorValue([]byte("invalid expire time in 'set' command"))
+				return opts, &reply
+			}
+			expiry = true
+		default:
+			return opts, syntaxError()
+		}
+	}
+	if expiry {
+		return opts, expiryNotSupported()
+	}
+	return opts, nil
+}
+
+func syntaxError() *resp.Value {
+	reply := errorValue([]byte("syntax error"))
+	return &reply
+}
+
+// expiryNotSupported is the error for the options that set an expiry, keys do not expire in kvdb
+func expiryNotSupported() *resp.Value {
+	reply := errorValue([]byte("key expiry is not supported"))
+	return &reply
+}
+
+// SET key value [NX | XX] [GET] [KEEPTTL] sets the value of the key, see parseSetOptions. It returns OK, or Null if NX or
+// XX did not allow the write. With GET, it returns the previous value (or Null if the key did not exist) instead
+func handleSet(args []resp.Value, store *KVStore, client *Client) resp.Value {
+	if len(args) < 2 {
 		return resp.Value{
 			Type:              resp.ValueTypeSimpleError,
 			SimpleErrorPrefix: []byte("ERR"),
 			Buffer:            []byte("wrong number of arguments for 'SET' command"),
 		}
 	}
+	opts, reply := parseSetOptions(args[2:])
+	if reply != nil {
+		return *reply
+	}
+	key, value := args[0].Buffer, args[1].Buffer
+	if opts.get {
+		// SET replaces a value of any type, but it can only return a string
+		if reply, ok := checkStringKey(store, client, key); !ok {
+			return reply
+		}
+	}
 
-	if err := store.Store.Put(args[0].Buffer, args[1].Buffer); err != nil {
+	var old []byte
+	existed, written := false, true
+	var err error
+	switch {
+	case opts.nx && !opts.get:
+		written, err = store.db(client).PutIfAbsent(key, value)
+	case opts.nx || opts.xx:
+		old, existed, written, err = setIfVersion(store.db(client), key, value, opts.nx)
+	case opts.get:
+		old, existed, err = store.db(client).GetSet(key, value)
+	default:
+		err = store.db(client).Put(key, value)
+	}
+	if err != nil {
 		return resp.Value{
 			Type:              resp.ValueTypeSimpleError,
 			SimpleErrorPrefix: []byte("INTERNAL_ERR"),
@@ -86,14 +179,62 @@ func handleSet(args []resp.Value, store *KVStore) resp.Value {
 		}
 	}
 
+	switch {
+	case opts.get && existed:
+		return resp.Value{Type: resp.ValueTypeBulkString, Buffer: old}
+	case opts.get, !written:
+		return resp.Value{Type: resp.ValueTypeNull}
+	}
 	return resp.Value{
 		Type:   resp.ValueTypeSimpleString,
 		Buffer: []byte{'O', 'K'},
 	}
 }
 
+// setIfVersion sets the value of the key if it does not exist (nx), or if it exists (!nx), and returns the previous
+// value. The key is read with it's version and written with PutVersion, which is retried if another write got in
+// between, so the condition and the previous value are those of the value that was replaced
+func setIfVersion(store *kvdb.DataStore, key, value []byte, nx bool) (old []byte, existed bool, written bool, err error) {
+	for {
+		old, version, err := store.GetVersion(key)
+		if err != nil && !errors.Is(err, kvdb.ErrKeyNotFound) {
+			return nil, false, false, err
+		}
+		existed := err == nil
+		if existed == nx {
+			return old, existed, false, nil
+		}
+		_, err = store.PutVersion(key, value, version)
+		if err == nil {
+			return old, existed, true, nil
+		}
+		if !errors.Is(err, kvdb.ErrVersionMismatch) {
+			return nil, false, false, err
+		}
+	}
+}
+
+// SETNX key value sets the value of the key if it does not exist, and returns 1 if it was set, and 0 if it was not
+func handleSetNX(args []resp.Value, store *KVStore, client *Client) resp.Value {
+	if len(args) != 2 {
+		return errorValue([]byte("wrong number of arguments for 'SETNX' command"))
+	}
+	written, err := store.db(client).PutIfAbsent(args[0].Buffer, args[1].Buffer)
+	if err != nil {
+		return resp.Value{
+			Type:              resp.ValueTypeSimpleError,
+			SimpleErrorPrefix: []byte("INTERNAL_ERR"),
+			Buffer:            []byte(err.Error()),
+		}
+	}
+	if written {
+		return resp.Value{Type: resp.ValueTypeInteger, Integer: 1}
+	}
+	return resp.Value{Type: resp.ValueTypeInteger, Integer: 0}
+}
+
 // Pattern is ignored though (for now, KEYS means KEYS *)
-func handleKeys(args []resp.Value, store *KVStore) resp.Value {
+func handleKeys(args []resp.Value, store *KVStore, client *Client) resp.Value {
 	if len(args) != 1 {
 		return resp.Value{
 			Type:              resp.ValueTypeSimpleError,
@@ -101,14 +242,13 @@ func handleKeys(args []resp.Value, store *KVStore) resp.Value {
 			Buffer:            []byte("wrong number of arguments for 'KEYS' command"),
 		}
 	}
-	keys, err := store.Store.ListKeys()
+	ctx, cancel := commandContext(store.KeysTimeout)
+	defer cancel()
+	keys, err := store.db(client).ListKeysContext(ctx)
 	if err != nil {
-		return resp.Value{
-			Type:              resp.ValueTypeSimpleError,
-			SimpleErrorPrefix: []byte("INTERNAL_ERR"),
-			Buffer:            []byte(err.Error()),
-		}
+		return cancellableCommandError("KEYS", store.KeysTimeout, err)
 	}
+	keys = store.ACL.filterKeys(client.User, keys)
 	sort.Strings(keys) // Sort the keys
 
 	values := make([]resp.Value, len(keys))
@@ -124,7 +264,77 @@ func handleKeys(args []resp.Value, store *KVStore) resp.Value {
 	}
 }
 
-func handleDel(args []resp.Value, store *KVStore) resp.Value {
+// Number of keys returned by SCAN if COUNT is not given
+const defaultScanCount = 10
+
+// SCAN cursor [COUNT count] returns the next cursor and up to count keys in sorted order, starting at cursor. The first
+// cursor is 0, and 0 is returned after the last page. Keys that exist for the whole iteration are returned exactly once
+// (see DataStore.ListKeysPage), and the keys the user can't access are left out, so a page can have fewer keys
+func handleScan(args []resp.Value, store *KVStore, client *Client) resp.Value {
+	if len(args) != 1 && len(args) != 3 {
+		return errorValue([]byte("wrong number of arguments for 'SCAN' command"))
+	}
+	count := defaultScanCount
+	if len(args) == 3 {
+		var err error
+		if !strings.EqualFold(string(args[1].Buffer), "COUNT") {
+			return errorValue([]byte("syntax error"))
+		}
+		if count, err = strconv.Atoi(string(args[2].Buffer)); err != nil || count <= 0 {
+			return errorValue([]byte("value is not an integer or out of range"))
+		}
+	}
+	// The cursors of ListKeysPage end with a zero byte, so they are never "0"
+	cursor := string(args[0].Buffer)
+	if cursor == "0" {
+		cursor = ""
+	}
+	keys, next, err := store.db(client).ListKeysPage(cursor, count)
+	if err != nil {
+		return errorValue([]byte(err.Error()))
+	}
+	if next == "" {
+		next = "0"
+	}
+	keys = store.ACL.filterKeys(client.User, keys)
+	values := make([]resp.Value, len(keys))
+	for i, key := range keys {
+		values[i] = resp.Value{Type: resp.ValueTypeBulkString, Buffer: []byte(key)}
+	}
+	return resp.Value{Type: resp.ValueTypeArray, Array: []resp.Value{
+		{Type: resp.ValueTypeBulkString, Buffer: []byte(next)},
+		{Type: resp.ValueTypeArray, Array: values},
+	}}
+}
+
+// DBSIZE returns the number of keys in the store
+func handleDBSize(args []resp.Value, store *KVStore, client *Client) resp.Value {
+	if len(args) != 0 {
+		return errorValue([]byte("wrong number of arguments for 'DBSIZE' command"))
+	}
+	return resp.Value{Type: resp.ValueTypeInteger, Integer: int64(store.db(client).Size())}
+}
+
+// RANDOMKEY returns a random key of the store, or Null if the store is empty
+func handleRandomKey(args []resp.Value, store *KVStore, client *Client) resp.Value {
+	if len(args) != 0 {
+		return errorValue([]byte("wrong number of arguments for 'RANDOMKEY' command"))
+	}
+	key, err := store.db(client).RandomKey()
+	if errors.Is(err, kvdb.ErrKeyNotFound) {
+		return resp.Value{Type: resp.ValueTypeNull}
+	}
+	if err != nil {
+		return resp.Value{
+			Type:              resp.ValueTypeSimpleError,
+			SimpleErrorPrefix: []byte("INTERNAL_ERR"),
+			Buffer:            []byte(err.Error()),
+		}
+	}
+	return resp.Value{Type: resp.ValueTypeBulkString, Buffer: key}
+}
+
+func handleDel(args []resp.Value, store *KVStore, client *Client) resp.Value {
 	if len(args) == 0 {
 		return resp.Value{
 			Type:              resp.ValueTypeSimpleError,
@@ -134,7 +344,7 @@ func handleDel(args []resp.Value, store *KVStore) resp.Value {
 	}
 	deleteCount := 0
 	for _, key := range args {
-		keyExisted, err := store.Store.DeleteWithExists(key.Buffer)
+		keyExisted, err := store.db(client).DeleteWithExists(key.Buffer)
 		if err != nil {
 			return resp.Value{
 				Type:              resp.ValueTypeSimpleError,
@@ -151,3 +361,137 @@ func handleDel(args []resp.Value, store *KVStore) resp.Value {
 		Integer: int64(deleteCount),
 	}
 }
+
+// GETDEL key returns the value of the key (or Null if it does not exist), and deletes the key
+func handleGetDel(args []resp.Value, store *KVStore, client *Client) resp.Value {
+	if len(args) != 1 {
+		return errorValue([]byte("wrong number of arguments for 'GETDEL' command"))
+	}
+	if reply, ok := checkStringKey(store, client, args[0].Buffer); !ok {
+		return reply
+	}
+	value, err := store.db(client).GetDelete(args[0].Buffer)
+	if err != nil {
+		if errors.Is(err, kvdb.ErrKeyNotFound) {
+			return resp.Value{Type: resp.ValueTypeNull}
+		}
+		return resp.Value{
+			Type:              resp.ValueTypeSimpleError,
+			SimpleErrorPrefix: []byte("INTERNAL_ERR"),
+			Buffer:            []byte(err.Error()),
+		}
+	}
+	return resp.Value{
+		Type:   resp.ValueTypeBulkString,
+		Buffer: value,
+	}
+}
+
+// GETEX key [EX seconds | PX milliseconds | EXAT unix-time-seconds | PXAT unix-time-milliseconds | PERSIST] returns the
+// value of the key, like GET. Keys do not expire, so PERSIST has nothing to remove, and the options that set an expiry
+// are rejected like in SET, see parseSetOptions
+func handleGetEx(args []resp.Value, store *KVStore, client *Client) resp.Value {
+	if len(args) == 0 {
+		return errorValue([]byte("wrong number of arguments for 'GETEX' command"))
+	}
+	option := ""
+	if len(args) > 1 {
+		option = strings.ToUpper(string(args[1].Buffer))
+	}
+	switch {
+	case len(args) == 1, len(args) == 2 && option == "PERSIST":
+	case len(args) == 3 && (option == "EX" || option == "PX" || option == "EXAT" || option == "PXAT"):
+		n, err := strconv.ParseInt(string(args[2].Buffer), 10, 64)
+		if err != nil {
+			return errorValue([]byte("value is not an integer or out of range"))
+		}
+		if n <= 0 {
+			return errorValue([]byte("invalid expire time in 'getex' command"))
+		}
+		return *expiryNotSupported()
+	default:
+		return *syntaxError()
+	}
+	return handleGet(args[:1], store, client)
+}
+
+// GETSET key value sets the value of the key, and returns the previous value (or Null if the key did not exist)
+func handleGetSet(args []resp.Value, store *KVStore, client *Client) resp.Value {
+	if len(args) != 2 {
+		return errorValue([]byte("wrong number of arguments for 'GETSET' command"))
+	}
+	if reply, ok := checkStringKey(store, client, args[0].Buffer); !ok {
+		return reply
+	}
+	old, existed, err := store.db(client).GetSet(args[0].Buffer, args[1].Buffer)
+	if err != nil {
+		return resp.Value{
+			Type:              resp.ValueTypeSimpleError,
+			SimpleErrorPrefix: []byte("INTERNAL_ERR"),
+			Buffer:            []byte(err.Error()),
+		}
+	}
+	if !existed {
+		return resp.Value{Type: resp.ValueTypeNull}
+	}
+	return resp.Value{
+		Type:   resp.ValueTypeBulkString,
+		Buffer: old,
+	}
+}
+
+func handleAuth(args []resp.Value, store *KVStore, client *Client) resp.Value {
+	var password []byte
+	switch len(args) {
+	case 1:
+		password = args[0].Buffer
+	case 2:
+		// AUTH <username> <password>, users other than the default user are defined in the ACL
+		if string(args[0].Buffer) != defaultUser {
+			if !store.ACL.authenticate(string(args[0].Buffer), args[1].Buffer) {
+				return resp.Value{
+					Type:              resp.ValueTypeSimpleError,
+					SimpleErrorPrefix: []byte("WRONGPASS"),
+					Buffer:            []byte("invalid username-password pair or user is disabled."),
+				}
+			}
+			client.setUser(string(args[0].Buffer))
+			client.Authenticated = true
+			return resp.Value{
+				Type:   resp.ValueTypeSimpleString,
+				Buffer: []byte{'O', 'K'},
+			}
+		}
+		password = args[1].Buffer
+	default:
+		return resp.Value{
+			Type:              resp.ValueTypeSimpleError,
+			SimpleErrorPrefix: []byte("ERR"),
+			Buffer:            []byte("wrong number of arguments for 'AUTH' command"),
+		}
+	}
+
+	if store.RequirePass == "" {
+		return resp.Value{
+			Type:              resp.ValueTypeSimpleError,
+			SimpleErrorPrefix: []byte("ERR"),
+			Buffer:            []byte("AUTH <password> called without any password configured for the default user. Are you sure your configuration is correct?"),
+		}
+	}
+
+	// Use a constant time comparison so that the password cannot be guessed through timing
+	if subtle.ConstantTimeCompare(password, []byte(store.RequirePass)) != 1 {
+		return resp.Value{
+			Type:              resp.ValueTypeSimpleError,
+			SimpleErrorPrefix: []byte("WRONGPASS"),
+			Buffer:            []byte("invalid username-password pair or user is disabled."),
+		}
+	}
+
+	client.Authenticated = true
+	client.setUser(defaultUser)
+	return resp.Value{
+		Type:   resp.ValueTypeSimpleString,
+		Buffer: []byte{'O', 'K'},
+	}
+}
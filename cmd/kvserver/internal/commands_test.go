@@ -0,0 +1,279 @@
+package internal
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/ananthvk/kvdb/internal/resp"
+)
+
+func bulkArgs(args ...string) []resp.Value {
+	values := make([]resp.Value, len(args))
+	for i, arg := range args {
+		values[i] = resp.Value{Type: resp.ValueTypeBulkString, Buffer: []byte(arg)}
+	}
+	return values
+}
+
+func TestSetOptions(t *testing.T) {
+	store := helperMemoryStore(t)
+	tests := []struct {
+		args []string
+		// Expected reply, "OK", "(nil)", "(error)" or the previous value
+		reply string
+		// Value of the key after the command, "" if it does not exist
+		value string
+	}{
+		{[]string{"key", "v1", "XX"}, "(nil)", ""},
+		{[]string{"key", "v1", "NX"}, "OK", "v1"},
+		{[]string{"key", "v2", "NX"}, "(nil)", "v1"},
+		{[]string{"key", "v2", "XX"}, "OK", "v2"},
+		{[]string{"key", "v3", "GET"}, "v2", "v3"},
+		{[]string{"key", "v4", "nx", "get"}, "v3", "v3"},
+		{[]string{"key", "v4", "XX", "GET", "KEEPTTL"}, "v3", "v4"},
+		{[]string{"other", "v1", "GET"}, "(nil)", "v1"},
+		{[]string{"key", "v5", "NX", "XX"}, "(error)", "v4"},
+		{[]string{"key", "v5", "EX"}, "(error)", "v4"},
+		{[]string{"key", "v5", "EX", "ten"}, "(error)", "v4"},
+		{[]string{"key", "v5", "PX", "0"}, "(error)", "v4"},
+		{[]string{"key", "v5", "EX", "10", "KEEPTTL"}, "(error)", "v4"},
+		{[]string{"key", "v5", "EX", "10"}, "(error)", "v4"},
+		{[]string{"key", "v5", "FOREVER"}, "(error)", "v4"},
+		{[]string{"key", "v5"}, "OK", "v5"},
+	}
+	for _, test := range tests {
+		reply := handleSet(bulkArgs(test.args...), store, nil)
+		got := string(reply.Buffer)
+		switch reply.Type {
+		case resp.ValueTypeNull:
+			got = "(nil)"
+		case resp.ValueTypeSimpleError:
+			got = "(error)"
+		}
+		if got != test.reply {
+			t.Errorf("SET %v: expected %s, got %s (%+v)", test.args, test.reply, got, reply)
+		}
+		key := test.args[0]
+		value, err := store.Store.Get([]byte(key))
+		if test.value == "" && err == nil || test.value != "" && string(value) != test.value {
+			t.Errorf("SET %v: expected %s to be %q, got %q (err: %v)", test.args, key, test.value, value, err)
+		}
+	}
+}
+
+func TestHashCommands(t *testing.T) {
+	store := helperMemoryStore(t)
+	if reply := handleHSet(bulkArgs("hash", "b", "2", "a", "1"), store, nil); reply.Integer != 2 {
+		t.Errorf("HSET: expected 2 new fields, got %+v", reply)
+	}
+	if reply := handleHGet(bulkArgs("hash", "a"), store, nil); string(reply.Buffer) != "1" {
+		t.Errorf("HGET: expected 1, got %+v", reply)
+	}
+	if reply := handleHGet(bulkArgs("hash", "c"), store, nil); reply.Type != resp.ValueTypeNull {
+		t.Errorf("HGET: expected Null for a missing field, got %+v", reply)
+	}
+	reply := handleHGetAll(bulkArgs("hash"), store, nil)
+	if len(reply.Array) != 4 || string(reply.Array[0].Buffer) != "a" || string(reply.Array[3].Buffer) != "2" {
+		t.Errorf("HGETALL: expected the fields in order, got %+v", reply)
+	}
+	if reply := handleHDel(bulkArgs("hash", "a", "b", "c"), store, nil); reply.Integer != 2 {
+		t.Errorf("HDEL: expected 2 removed fields, got %+v", reply)
+	}
+	if reply := handleHLen(bulkArgs("hash"), store, nil); reply.Type != resp.ValueTypeInteger || reply.Integer != 0 {
+		t.Errorf("HLEN: expected the hash to be deleted, got %+v", reply)
+	}
+
+	handleSet(bulkArgs("string", "value"), store, nil)
+	if reply := handleHSet(bulkArgs("string", "a", "1"), store, nil); string(reply.SimpleErrorPrefix) != "WRONGTYPE" {
+		t.Errorf("HSET: expected WRONGTYPE on a string, got %+v", reply)
+	}
+}
+
+func TestListCommands(t *testing.T) {
+	store := helperMemoryStore(t)
+	if reply := handleRPush(bulkArgs("list", "b", "c"), store, nil); reply.Integer != 2 {
+		t.Errorf("RPUSH: expected 2 values, got %+v", reply)
+	}
+	if reply := handleLPush(bulkArgs("list", "a"), store, nil); reply.Integer != 3 {
+		t.Errorf("LPUSH: expected 3 values, got %+v", reply)
+	}
+	reply := handleLRange(bulkArgs("list", "0", "-1"), store, nil)
+	if len(reply.Array) != 3 || string(reply.Array[0].Buffer) != "a" || string(reply.Array[2].Buffer) != "c" {
+		t.Errorf("LRANGE: expected a b c, got %+v", reply)
+	}
+	if reply := handleLRange(bulkArgs("list", "x", "1"), store, nil); reply.Type != resp.ValueTypeSimpleError {
+		t.Errorf("LRANGE: expected an error for a start that is not an integer, got %+v", reply)
+	}
+	if reply := handleLPop(bulkArgs("list"), store, nil); string(reply.Buffer) != "a" {
+		t.Errorf("LPOP: expected a, got %+v", reply)
+	}
+	reply = handleRPop(bulkArgs("list", "5"), store, nil)
+	if len(reply.Array) != 2 || string(reply.Array[0].Buffer) != "c" {
+		t.Errorf("RPOP: expected c b, got %+v", reply)
+	}
+	if reply := handleLLen(bulkArgs("list"), store, nil); reply.Type != resp.ValueTypeInteger || reply.Integer != 0 {
+		t.Errorf("LLEN: expected the list to be deleted, got %+v", reply)
+	}
+	if reply := handleLPop(bulkArgs("list", "1"), store, nil); reply.Type != resp.ValueTypeNull {
+		t.Errorf("LPOP: expected Null for a list that does not exist, got %+v", reply)
+	}
+
+	handleHSet(bulkArgs("hash", "a", "1"), store, nil)
+	if reply := handleLPush(bulkArgs("hash", "a"), store, nil); string(reply.SimpleErrorPrefix) != "WRONGTYPE" {
+		t.Errorf("LPUSH: expected WRONGTYPE on a hash, got %+v", reply)
+	}
+}
+
+func TestSetCommands(t *testing.T) {
+	store := helperMemoryStore(t)
+	if reply := handleSAdd(bulkArgs("set", "b", "a", "b"), store, nil); reply.Integer != 2 {
+		t.Errorf("SADD: expected 2 new members, got %+v", reply)
+	}
+	reply := handleSMembers(bulkArgs("set"), store, nil)
+	if len(reply.Array) != 2 || string(reply.Array[0].Buffer) != "a" || string(reply.Array[1].Buffer) != "b" {
+		t.Errorf("SMEMBERS: expected a b, got %+v", reply)
+	}
+	if reply := handleSIsMember(bulkArgs("set", "a"), store, nil); reply.Integer != 1 {
+		t.Errorf("SISMEMBER: expected 1, got %+v", reply)
+	}
+	if reply := handleSRem(bulkArgs("set", "a", "b", "c"), store, nil); reply.Integer != 2 {
+		t.Errorf("SREM: expected 2 removed members, got %+v", reply)
+	}
+	if reply := handleSCard(bulkArgs("set"), store, nil); reply.Type != resp.ValueTypeInteger || reply.Integer != 0 {
+		t.Errorf("SCARD: expected the set to be deleted, got %+v", reply)
+	}
+
+	handleSet(bulkArgs("string", "value"), store, nil)
+	if reply := handleSAdd(bulkArgs("string", "a"), store, nil); string(reply.SimpleErrorPrefix) != "WRONGTYPE" {
+		t.Errorf("SADD: expected WRONGTYPE on a string, got %+v", reply)
+	}
+	if reply := handleSIsMember(bulkArgs("string", "a"), store, nil); string(reply.SimpleErrorPrefix) != "WRONGTYPE" {
+		t.Errorf("SISMEMBER: expected WRONGTYPE on a string, got %+v", reply)
+	}
+}
+
+func TestTypeCommand(t *testing.T) {
+	store := helperMemoryStore(t)
+	handleSet(bulkArgs("string", "value"), store, nil)
+	handleHSet(bulkArgs("hash", "a", "1"), store, nil)
+	handleRPush(bulkArgs("list", "a"), store, nil)
+	handleSAdd(bulkArgs("set", "a"), store, nil)
+	for key, want := range map[string]string{"string": "string", "hash": "hash", "list": "list", "set": "set", "missing": "none"} {
+		if reply := handleType(bulkArgs(key), store, nil); string(reply.Buffer) != want {
+			t.Errorf("TYPE %s: expected %s, got %+v", key, want, reply)
+		}
+	}
+
+	// String commands fail on the other types, without changing the value
+	for _, reply := range []resp.Value{
+		handleGet(bulkArgs("hash"), store, nil),
+		handleGetEx(bulkArgs("list"), store, nil),
+		handleGetDel(bulkArgs("set"), store, nil),
+		handleGetSet(bulkArgs("hash", "value"), store, nil),
+		handleSet(bulkArgs("list", "value", "GET"), store, nil),
+	} {
+		if string(reply.SimpleErrorPrefix) != "WRONGTYPE" {
+			t.Errorf("expected WRONGTYPE, got %+v", reply)
+		}
+	}
+	for key, want := range map[string]string{"hash": "hash", "list": "list", "set": "set"} {
+		if reply := handleType(bulkArgs(key), store, nil); string(reply.Buffer) != want {
+			t.Errorf("TYPE %s: expected %s after the failed commands, got %+v", key, want, reply)
+		}
+	}
+	// SET without GET replaces a value of any type
+	if reply := handleSet(bulkArgs("hash", "value"), store, nil); string(reply.Buffer) != "OK" {
+		t.Errorf("SET: expected OK, got %+v", reply)
+	}
+	if reply := handleType(bulkArgs("hash"), store, nil); string(reply.Buffer) != "string" {
+		t.Errorf("TYPE: expected the hash to be replaced by a string, got %+v", reply)
+	}
+
+	// The type doesn't depend on the bytes of the value
+	handleSet(bulkArgs("binary", "\xffkv\x01garbage"), store, nil)
+	if reply := handleGet(bulkArgs("binary"), store, nil); string(reply.Buffer) != "\xffkv\x01garbage" {
+		t.Errorf("GET: expected the binary string, got %+v", reply)
+	}
+	if reply := handleType(bulkArgs("binary"), store, nil); string(reply.Buffer) != "string" {
+		t.Errorf("TYPE: expected string for the binary string, got %+v", reply)
+	}
+}
+
+func TestGetDel(t *testing.T) {
+	store := helperMemoryStore(t)
+	handleSet(bulkArgs("token", "value"), store, nil)
+	if reply := handleGetDel(bulkArgs("token"), store, nil); string(reply.Buffer) != "value" {
+		t.Errorf("GETDEL: expected the value, got %+v", reply)
+	}
+	if _, err := store.Store.Get([]byte("token")); err == nil {
+		t.Errorf("GETDEL: expected the key to be deleted")
+	}
+	if reply := handleGetDel(bulkArgs("token"), store, nil); reply.Type != resp.ValueTypeNull {
+		t.Errorf("GETDEL: expected Null for a deleted key, got %+v", reply)
+	}
+	if reply := handleGetDel(bulkArgs("a", "b"), store, nil); reply.Type != resp.ValueTypeSimpleError {
+		t.Errorf("GETDEL: expected an error for the wrong number of arguments, got %+v", reply)
+	}
+
+	// Only one of the clients that run GETDEL on the same key gets the value
+	handleSet(bulkArgs("nonce", "value"), store, nil)
+	var got atomic.Int64
+	var wg sync.WaitGroup
+	for range 8 {
+		wg.Go(func() {
+			if reply := handleGetDel(bulkArgs("nonce"), store, nil); reply.Type == resp.ValueTypeBulkString {
+				got.Add(1)
+			}
+		})
+	}
+	wg.Wait()
+	if got.Load() != 1 {
+		t.Errorf("GETDEL: expected the value to be returned once, got %d", got.Load())
+	}
+}
+
+func TestGetEx(t *testing.T) {
+	store := helperMemoryStore(t)
+	handleSet(bulkArgs("key", "value"), store, nil)
+	for _, args := range [][]string{{"key"}, {"key", "PERSIST"}, {"key", "persist"}} {
+		if reply := handleGetEx(bulkArgs(args...), store, nil); string(reply.Buffer) != "value" {
+			t.Errorf("GETEX %v: expected the value, got %+v", args, reply)
+		}
+	}
+	if reply := handleGetEx(bulkArgs("missing", "PERSIST"), store, nil); reply.Type != resp.ValueTypeNull {
+		t.Errorf("GETEX: expected Null for a missing key, got %+v", reply)
+	}
+
+	// The expiry options get the same error as in SET, after they are validated
+	setReply := handleSet(bulkArgs("key", "value", "EX", "10"), store, nil)
+	for _, option := range []string{"EX", "PX", "EXAT", "PXAT"} {
+		reply := handleGetEx(bulkArgs("key", option, "10"), store, nil)
+		if reply.Type != resp.ValueTypeSimpleError || string(reply.Buffer) != string(setReply.Buffer) {
+			t.Errorf("GETEX %s: expected %q, got %+v", option, setReply.Buffer, reply)
+		}
+	}
+	for _, args := range [][]string{{}, {"key", "EX"}, {"key", "EX", "abc"}, {"key", "PX", "0"}, {"key", "KEEPTTL"}} {
+		if reply := handleGetEx(bulkArgs(args...), store, nil); reply.Type != resp.ValueTypeSimpleError {
+			t.Errorf("GETEX %v: expected an error, got %+v", args, reply)
+		}
+	}
+}
+
+func TestDBSizeAndRandomKey(t *testing.T) {
+	store := helperMemoryStore(t)
+	if reply := handleRandomKey(nil, store, nil); reply.Type != resp.ValueTypeNull {
+		t.Errorf("RANDOMKEY: expected Null on an empty store, got %+v", reply)
+	}
+	handleSet(bulkArgs("a", "1"), store, nil)
+	handleSet(bulkArgs("b", "2"), store, nil)
+	if reply := handleDBSize(nil, store, nil); reply.Integer != 2 {
+		t.Errorf("DBSIZE: expected 2, got %+v", reply)
+	}
+	if reply := handleRandomKey(nil, store, nil); string(reply.Buffer) != "a" && string(reply.Buffer) != "b" {
+		t.Errorf("RANDOMKEY: expected a or b, got %+v", reply)
+	}
+	if reply := handleDBSize(bulkArgs("x"), store, nil); reply.Type != resp.ValueTypeSimpleError {
+		t.Errorf("DBSIZE: expected an error for an argument, got %+v", reply)
+	}
+}
@@ -0,0 +1,211 @@
+package internal
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+Configuration file
+
+The settings of the server can be read from a file (-config), a JSON object with the names of the command line flags as
+keys, and their values as strings, numbers or booleans:
+
+	{"db": "/var/lib/kvdb", "port": 6379, "log-level": "info", "slowlog-log-slower-than": "50ms", "maxclients": 1000}
+
+A flag given on the command line takes precedence over the file, also when the file is reloaded.
+
+When the server gets SIGHUP, it reads the file again, and applies the settings that can change while the server is
+running (reloadableSettings: log-level, slowlog-log-slower-than, slowlog-max-len, merge-interval, merge-window,
+merge-max-write-rate and maxclients). The other settings (like db or port) need a restart, so if the file changes any
+of them, the whole reload is rejected and logged, and the server keeps it's current settings. A setting that's removed
+from the file keeps it's current value
+*/
+
+// reloadableSettings are the settings that can be changed by reloading the config file. Each function checks the value,
+// and returns a function that applies it to the server
+var reloadableSettings = map[string]func(kv *KVStore, value string) (func(), error){
+	"log-level": func(kv *KVStore, value string) (func(), error) {
+		var level slog.Level
+		if err := level.UnmarshalText([]byte(value)); err != nil {
+			return nil, err
+		}
+		return func() { slog.SetLogLoggerLevel(level) }, nil
+	},
+	"slowlog-log-slower-than": func(kv *KVStore, value string) (func(), error) {
+		threshold, err := time.ParseDuration(value)
+		if err != nil {
+			return nil, err
+		}
+		return func() { kv.SlowLog.SetThreshold(threshold) }, nil
+	},
+	"slowlog-max-len": func(kv *KVStore, value string) (func(), error) {
+		maxLen, err := strconv.Atoi(value)
+		if err != nil {
+			return nil, err
+		}
+		return func() { kv.SlowLog.SetMaxLen(maxLen) }, nil
+	},
+	"merge-interval": func(kv *KVStore, value string) (func(), error) {
+		interval, err := time.ParseDuration(value)
+		if err != nil {
+			return nil, err
+		}
+		return func() { kv.SetMergeInterval(interval) }, nil
+	},
+	"merge-window": func(kv *KVStore, value string) (func(), error) {
+		window, err := ParseMergeWindow(value)
+		if err != nil {
+			return nil, err
+		}
+		return func() { kv.SetMergeWindow(window) }, nil
+	},
+	"merge-max-write-rate": func(kv *KVStore, value string) (func(), error) {
+		rate, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return nil, err
+		}
+		return func() { kv.SetMergeMaxWriteRate(rate) }, nil
+	},
+	"maxclients": func(kv *KVStore, value string) (func(), error) {
+		maxClients, err := strconv.Atoi(value)
+		if err != nil {
+			return nil, err
+		}
+		return func() { kv.SetMaxClients(maxClients) }, nil
+	},
+}
+
+// ConfigFile is a config file whose settings have been applied to a set of flags, see config.go
+type ConfigFile struct {
+	Path  string
+	flags *flag.FlagSet
+	// Flags given on the command line, they are not changed by the file
+	commandLine map[string]bool
+}
+
+// LoadConfigFile reads the config file at path, and sets the flags that were not given on the command line to the
+// values in the file. It must be called after the flags are parsed
+func LoadConfigFile(flags *flag.FlagSet, path string) (*ConfigFile, error) {
+	config := &ConfigFile{Path: path, flags: flags, commandLine: map[string]bool{}}
+	flags.Visit(func(f *flag.Flag) { config.commandLine[f.Name] = true })
+	settings, err := readConfigFile(path)
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range sortedKeys(settings) {
+		if flags.Lookup(name) == nil {
+			return nil, fmt.Errorf("invalid config file: unknown setting '%s'", name)
+		}
+		if config.commandLine[name] {
+			continue
+		}
+		if err := flags.Set(name, settings[name]); err != nil {
+			return nil, fmt.Errorf("invalid config file: setting '%s': %w", name, err)
+		}
+	}
+	return config, nil
+}
+
+// Reload reads the config file again, and applies the settings that changed to the server. It returns an error, and
+// changes nothing, if the file is invalid or changes a setting that is not reloadable
+func (c *ConfigFile) Reload(kv *KVStore) error {
+	settings, err := readConfigFile(c.Path)
+	if err != nil {
+		return err
+	}
+	var changed, notReloadable []string
+	for _, name := range sortedKeys(settings) {
+		f := c.flags.Lookup(name)
+		if f == nil {
+			return fmt.Errorf("invalid config file: unknown setting '%s'", name)
+		}
+		if c.commandLine[name] || sameValue(f, settings[name]) {
+			continue
+		}
+		if reloadableSettings[name] == nil {
+			notReloadable = append(notReloadable, name)
+		}
+		changed = append(changed, name)
+	}
+	if len(notReloadable) > 0 {
+		return fmt.Errorf("settings that need a restart were changed: %s", strings.Join(notReloadable, ", "))
+	}
+
+	// Every value is checked before any of them is applied
+	apply := make([]func(), 0, len(changed))
+	for _, name := range changed {
+		fn, err := reloadableSettings[name](kv, settings[name])
+		if err != nil {
+			return fmt.Errorf("invalid config file: setting '%s': %w", name, err)
+		}
+		apply = append(apply, fn)
+	}
+	for i, name := range changed {
+		apply[i]()
+		c.flags.Set(name, settings[name])
+		slog.Info("setting changed", "name", name, "value", settings[name])
+	}
+	return nil
+}
+
+// readConfigFile returns the settings in the config file, with their values in the format of the command line
+func readConfigFile(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var values map[string]any
+	if err := decoder.Decode(&values); err != nil {
+		return nil, fmt.Errorf("invalid config file: %w", err)
+	}
+	settings := make(map[string]string, len(values))
+	for name, value := range values {
+		switch value := value.(type) {
+		case string:
+			settings[name] = value
+		case json.Number:
+			settings[name] = value.String()
+		case bool:
+			settings[name] = strconv.FormatBool(value)
+		default:
+			return nil, fmt.Errorf("invalid config file: setting '%s' should be a string, number or boolean", name)
+		}
+	}
+	return settings, nil
+}
+
+// sameValue returns true if value is the current value of the flag. Durations and log levels are compared by value,
+// since "1m" and "1m0s" are the same duration
+func sameValue(f *flag.Flag, value string) bool {
+	if getter, ok := f.Value.(flag.Getter); ok {
+		switch current := getter.Get().(type) {
+		case time.Duration:
+			duration, err := time.ParseDuration(value)
+			return err == nil && duration == current
+		case *slog.Level:
+			var level slog.Level
+			return level.UnmarshalText([]byte(value)) == nil && level == *current
+		}
+	}
+	return f.Value.String() == value
+}
+
+func sortedKeys(settings map[string]string) []string {
+	names := make([]string, 0, len(settings))
+	for name := range settings {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
@@ -0,0 +1,72 @@
+package internal
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestConfigFile(t *testing.T) {
+	flags := flag.NewFlagSet("kvserver", flag.ContinueOnError)
+	db := flags.String("db", "", "")
+	port := flags.Uint("port", 6379, "")
+	maxClients := flags.Int("maxclients", 10000, "")
+	slowlogMaxLen := flags.Int("slowlog-max-len", 128, "")
+	mergeInterval := flags.Duration("merge-interval", 2*time.Minute, "")
+	if err := flags.Parse([]string{"-port", "7000"}); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "kvserver.json")
+	writeConfig := func(content string) {
+		t.Helper()
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The command line takes precedence over the file
+	writeConfig(`{"db": "data", "port": 8000, "maxclients": 10, "merge-interval": "1m"}`)
+	config, err := LoadConfigFile(flags, path)
+	if err != nil {
+		t.Fatalf("could not load the config file: %v", err)
+	}
+	if *db != "data" || *port != 7000 || *maxClients != 10 || *mergeInterval != time.Minute {
+		t.Fatalf("unexpected settings: db=%s port=%d maxclients=%d merge-interval=%s", *db, *port, *maxClients, *mergeInterval)
+	}
+
+	store := helperMemoryStore(t)
+	store.SetMaxClients(*maxClients)
+	writeConfig(`{"db": "data", "port": 8001, "maxclients": 20, "merge-interval": "60s", "slowlog-max-len": 1}`)
+	if err := config.Reload(store); err != nil {
+		t.Fatalf("expected the reload to succeed, got %v", err)
+	}
+	if store.MaxClients() != 20 || *maxClients != 20 || *slowlogMaxLen != 1 {
+		t.Errorf("expected the settings to be applied, got maxclients=%d slowlog-max-len=%d", store.MaxClients(), *slowlogMaxLen)
+	}
+	if store.MergeInterval() != defaultMergeInterval {
+		t.Errorf("expected the merge interval to be unchanged, got %s", store.MergeInterval())
+	}
+
+	// A change that needs a restart, or an invalid value, rejects the whole reload
+	for _, content := range []string{
+		`{"db": "other", "maxclients": 30}`,
+		`{"maxclients": 30, "slowlog-max-len": "x"}`,
+		`{"maxclients": 30, "unknown": 1}`,
+		`{"maxclients": [30]}`,
+	} {
+		writeConfig(content)
+		if err := config.Reload(store); err == nil {
+			t.Errorf("%s: expected the reload to fail", content)
+		}
+		if store.MaxClients() != 20 {
+			t.Errorf("%s: expected maxclients to be unchanged, got %d", content, store.MaxClients())
+		}
+	}
+
+	writeConfig(`{"port": "x"}`)
+	if _, err := LoadConfigFile(flag.NewFlagSet("kvserver", flag.ContinueOnError), path); err == nil {
+		t.Errorf("expected an error for an unknown setting")
+	}
+}
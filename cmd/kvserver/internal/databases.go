@@ -0,0 +1,227 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"path/filepath"
+	"strconv"
+
+	"github.com/ananthvk/kvdb"
+	"github.com/ananthvk/kvdb/internal/resp"
+)
+
+/*
+Attached datastores
+
+A server can front more than one datastore. The datastore of -db is database 0, and more datastores can be attached at
+startup (-attach <path>, repeated for each one) or at runtime with ATTACH <path>, which opens (or creates) the datastore
+at path and returns it's number. The path of ATTACH is relative to AttachDir (-attach-dir), it can't be absolute or
+leave the directory with "..", so that clients can only open datastores where the server allows them to. ATTACH is
+disabled if there is no AttachDir. Numbers are given in the order in which the datastores are attached, and are not
+reused. Attaching a path that's already attached returns it's number.
+
+A client selects a database with SELECT <number>, every key command (GET, SET, KEYS, SCAN, the hash, list and set
+commands...) then uses that datastore, until the next SELECT. DETACH <number> closes an attached datastore, the clients
+that selected it get errors until they select another database.
+
+Database 0 is the main datastore: it's the one that's replicated, merged by COMPACT, and written to snapshots. The
+attached datastores are merged by the background merge after database 0, SAVE and BGSAVE sync them, and SHUTDOWN closes
+them. WATCH works in every database, but a write to a key dirties the transactions watching a key with the same name in
+any database. With an ACL file, ATTACH and DETACH are only allowed for admin users (see acl.go).
+
+The datastore is opened without holding databasesMu, so that a slow open does not block the commands of the other
+clients, which look up their database with it
+*/
+
+// attachedDatabase is a datastore attached with ATTACH
+type attachedDatabase struct {
+	path     string
+	store    *kvdb.DataStore
+	detached bool
+}
+
+// db returns the datastore selected by the client (the main datastore if client is nil). The datastore of a detached
+// database is closed, so it's operations fail with kvdb.ErrClosed
+func (kv *KVStore) db(client *Client) *kvdb.DataStore {
+	if client == nil {
+		return kv.Store
+	}
+	index := client.selectedDB.Load()
+	if index == 0 {
+		return kv.Store
+	}
+	kv.databasesMu.RLock()
+	defer kv.databasesMu.RUnlock()
+	return kv.attached[index-1].store
+}
+
+// Attach opens (or creates) the datastore at path, and returns it's database number. If the path is already attached,
+// it's number is returned. The path is not checked, see attachPath for the paths of ATTACH
+func (kv *KVStore) Attach(path string) (int, error) {
+	kv.databasesMu.RLock()
+	index, ok := kv.attachedIndex(path)
+	kv.databasesMu.RUnlock()
+	if ok {
+		return index, nil
+	}
+	store, err := kvdb.Open(kv.fs, path)
+	if errors.Is(err, kvdb.ErrNotExist) {
+		store, err = kvdb.Create(kv.fs, path)
+	}
+
+	kv.databasesMu.Lock()
+	defer kv.databasesMu.Unlock()
+	// The path may have been attached by another client while it was being opened
+	if index, ok := kv.attachedIndex(path); ok {
+		if err == nil {
+			store.Close()
+		}
+		return index, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	store.Watch(kv.touchWatchedKey)
+	kv.attached = append(kv.attached, &attachedDatabase{path: path, store: store})
+	slog.Info("datastore attached", "path", path, "db", len(kv.attached))
+	return len(kv.attached), nil
+}
+
+// attachedIndex returns the database number of path, if it's attached. The caller must hold databasesMu
+func (kv *KVStore) attachedIndex(path string) (int, bool) {
+	if path == kv.Path {
+		return 0, true
+	}
+	for i, database := range kv.attached {
+		if !database.detached && database.path == path {
+			return i + 1, true
+		}
+	}
+	return 0, false
+}
+
+// attachPath returns the path of the datastore named by ATTACH, which must be a local path under AttachDir
+func (kv *KVStore) attachPath(name string) (string, error) {
+	if kv.AttachDir == "" {
+		return "", errors.New("ATTACH is disabled, the server has no -attach-dir")
+	}
+	if !filepath.IsLocal(name) {
+		return "", errors.New("the path must be relative to the attach directory, without '..'")
+	}
+	return filepath.Join(kv.AttachDir, name), nil
+}
+
+// Detach closes the attached datastore with the given number. It's marked detached with the lock held, and closed
+// after the lock is released, since Close waits for the running operations of the datastore
+func (kv *KVStore) Detach(index int) error {
+	kv.databasesMu.Lock()
+	if index <= 0 || index > len(kv.attached) || kv.attached[index-1].detached {
+		kv.databasesMu.Unlock()
+		return fmt.Errorf("no attached datastore with number %d", index)
+	}
+	database := kv.attached[index-1]
+	database.detached = true
+	kv.databasesMu.Unlock()
+	slog.Info("datastore detached", "path", database.path, "db", index)
+	return database.store.Close()
+}
+
+// attachedStores returns the datastores that are attached, by database number
+func (kv *KVStore) attachedStores() map[int]*kvdb.DataStore {
+	kv.databasesMu.RLock()
+	defer kv.databasesMu.RUnlock()
+	stores := map[int]*kvdb.DataStore{}
+	for i, database := range kv.attached {
+		if !database.detached {
+			stores[i+1] = database.store
+		}
+	}
+	return stores
+}
+
+// mergeAttached merges the attached datastores
+func (kv *KVStore) mergeAttached() {
+	for index, store := range kv.attachedStores() {
+		if err := store.Merge(); err != nil {
+			slog.Warn("merge of an attached datastore failed", "db", index, "error", err)
+		}
+	}
+}
+
+// syncAttached syncs the attached datastores
+func (kv *KVStore) syncAttached() error {
+	var err error
+	for index, store := range kv.attachedStores() {
+		if syncErr := store.Sync(); syncErr != nil {
+			err = errors.Join(err, fmt.Errorf("db%d: %w", index, syncErr))
+		}
+	}
+	return err
+}
+
+// closeAttached closes the attached datastores
+func (kv *KVStore) closeAttached() error {
+	kv.databasesMu.Lock()
+	defer kv.databasesMu.Unlock()
+	var err error
+	for _, database := range kv.attached {
+		if !database.detached {
+			database.detached = true
+			err = errors.Join(err, database.store.Close())
+		}
+	}
+	return err
+}
+
+// SELECT index
+func handleSelect(args []resp.Value, store *KVStore, client *Client) resp.Value {
+	if len(args) != 1 {
+		return errorValue([]byte("wrong number of arguments for 'SELECT' command"))
+	}
+	index, err := strconv.Atoi(string(args[0].Buffer))
+	if err != nil {
+		return errorValue([]byte("value is not an integer or out of range"))
+	}
+	if index != 0 {
+		store.databasesMu.RLock()
+		exists := index > 0 && index <= len(store.attached) && !store.attached[index-1].detached
+		store.databasesMu.RUnlock()
+		if !exists {
+			return errorValue([]byte("DB index is out of range"))
+		}
+	}
+	client.selectedDB.Store(int64(index))
+	return resp.Value{Type: resp.ValueTypeSimpleString, Buffer: []byte("OK")}
+}
+
+// ATTACH path
+func handleAttach(args []resp.Value, store *KVStore, client *Client) resp.Value {
+	if len(args) != 1 {
+		return errorValue([]byte("wrong number of arguments for 'ATTACH' command"))
+	}
+	path, err := store.attachPath(string(args[0].Buffer))
+	if err != nil {
+		return errorValue([]byte(err.Error()))
+	}
+	index, err := store.Attach(path)
+	if err != nil {
+		return errorValue(fmt.Appendf(nil, "could not attach the datastore: %s", err))
+	}
+	return resp.Value{Type: resp.ValueTypeInteger, Integer: int64(index)}
+}
+
+// DETACH index
+func handleDetach(args []resp.Value, store *KVStore, client *Client) resp.Value {
+	if len(args) != 1 {
+		return errorValue([]byte("wrong number of arguments for 'DETACH' command"))
+	}
+	index, err := strconv.Atoi(string(args[0].Buffer))
+	if err != nil {
+		return errorValue([]byte("value is not an integer or out of range"))
+	}
+	if err := store.Detach(index); err != nil {
+		return errorValue([]byte(err.Error()))
+	}
+	return resp.Value{Type: resp.ValueTypeSimpleString, Buffer: []byte("OK")}
+}
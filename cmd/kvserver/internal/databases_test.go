@@ -0,0 +1,156 @@
+package internal
+
+import (
+	"bufio"
+	"net"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ananthvk/kvdb"
+
+	"github.com/ananthvk/kvdb/internal/resp"
+	"github.com/spf13/afero"
+)
+
+func TestAttachedDatabases(t *testing.T) {
+	store := helperMemoryStore(t)
+	store.AttachDir = "attached"
+	address := helperServe(t, store)
+	dial := func() (net.Conn, *bufio.Reader) {
+		conn, err := net.Dial("tcp", address)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { conn.Close() })
+		return conn, bufio.NewReader(conn)
+	}
+	conn, reader := dial()
+	other, otherReader := dial()
+
+	if reply := helperCommand(t, conn, reader, "ATTACH", "second"); reply.Integer != 1 {
+		t.Fatalf("ATTACH: expected 1, got %+v", reply)
+	}
+	if reply := helperCommand(t, conn, reader, "ATTACH", "second"); reply.Integer != 1 {
+		t.Errorf("ATTACH: expected the number of the attached path, got %+v", reply)
+	}
+	if reply := helperCommand(t, conn, reader, "SELECT", "2"); reply.Type != resp.ValueTypeSimpleError {
+		t.Errorf("SELECT: expected an error for a database that's not attached, got %+v", reply)
+	}
+
+	helperCommand(t, conn, reader, "SET", "key", "main")
+	if reply := helperCommand(t, conn, reader, "SELECT", "1"); string(reply.Buffer) != "OK" {
+		t.Fatalf("SELECT: expected OK, got %+v", reply)
+	}
+	if reply := helperCommand(t, conn, reader, "GET", "key"); reply.Type != resp.ValueTypeNull {
+		t.Errorf("GET: expected no key in the attached database, got %+v", reply)
+	}
+	helperCommand(t, conn, reader, "SET", "key", "attached")
+	helperCommand(t, conn, reader, "HSET", "hash", "field", "value")
+	if reply := helperCommand(t, conn, reader, "DBSIZE"); reply.Integer != 2 {
+		t.Errorf("DBSIZE: expected 2, got %+v", reply)
+	}
+	// The selected database is per client
+	if reply := helperCommand(t, other, otherReader, "GET", "key"); string(reply.Buffer) != "main" {
+		t.Errorf("GET: expected the value of database 0, got %+v", reply)
+	}
+	info := string(helperCommand(t, other, otherReader, "INFO", "keyspace").Buffer)
+	if !strings.Contains(info, "db0:keys=1") || !strings.Contains(info, "db1:keys=2") {
+		t.Errorf("INFO: expected the keys of both databases, got:\n%s", info)
+	}
+
+	if reply := helperCommand(t, other, otherReader, "DETACH", "1"); string(reply.Buffer) != "OK" {
+		t.Fatalf("DETACH: expected OK, got %+v", reply)
+	}
+	if reply := helperCommand(t, other, otherReader, "DETACH", "1"); reply.Type != resp.ValueTypeSimpleError {
+		t.Errorf("DETACH: expected an error for a detached database, got %+v", reply)
+	}
+	if reply := helperCommand(t, conn, reader, "GET", "key"); reply.Type != resp.ValueTypeSimpleError {
+		t.Errorf("GET: expected an error in a detached database, got %+v", reply)
+	}
+	helperCommand(t, conn, reader, "SELECT", "0")
+	if reply := helperCommand(t, conn, reader, "GET", "key"); string(reply.Buffer) != "main" {
+		t.Errorf("GET: expected the value of database 0, got %+v", reply)
+	}
+
+	// The data of a detached datastore is kept, attaching it again gives it a new number
+	if reply := helperCommand(t, conn, reader, "ATTACH", "second"); reply.Integer != 2 {
+		t.Fatalf("ATTACH: expected 2, got %+v", reply)
+	}
+	helperCommand(t, conn, reader, "SELECT", "2")
+	if reply := helperCommand(t, conn, reader, "GET", "key"); string(reply.Buffer) != "attached" {
+		t.Errorf("GET: expected the value written before DETACH, got %+v", reply)
+	}
+}
+
+func TestDetachReleasesLock(t *testing.T) {
+	store := helperMemoryStore(t)
+	index, err := store.Attach("attached")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A put that's blocked in a watcher keeps the datastore from closing
+	blocked, release := make(chan struct{}), make(chan struct{})
+	var releaseOnce sync.Once
+	// Cleanups run in reverse order, the put is released before the store is closed if the test fails
+	t.Cleanup(func() { releaseOnce.Do(func() { close(release) }) })
+	store.attachedStores()[index].Watch(func(kvdb.WatchEvent) {
+		close(blocked)
+		<-release
+	})
+	go store.attachedStores()[index].Put([]byte("key"), []byte("value"))
+	<-blocked
+	detached := make(chan error, 1)
+	go func() { detached <- store.Detach(index) }()
+
+	// The other databases stay usable while Close waits
+	listed := make(chan struct{})
+	go func() {
+		for len(store.attachedStores()) != 0 {
+			time.Sleep(10 * time.Millisecond)
+		}
+		close(listed)
+	}()
+	select {
+	case <-listed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the databases to be listed while the detached datastore is closing")
+	}
+	select {
+	case err := <-detached:
+		t.Fatalf("expected DETACH to wait for the put, got %v", err)
+	default:
+	}
+	releaseOnce.Do(func() { close(release) })
+	if err := <-detached; err != nil {
+		t.Errorf("DETACH failed: %v", err)
+	}
+}
+
+func TestAttachPath(t *testing.T) {
+	store := helperMemoryStore(t)
+	if _, err := store.attachPath("second"); err == nil {
+		t.Errorf("expected ATTACH to be disabled without an attach directory")
+	}
+	store.AttachDir = "attached"
+	conn, err := net.Dial("tcp", helperServe(t, store))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+
+	for _, path := range []string{"../second", "/tmp/second", "second/../../x", ""} {
+		if reply := helperCommand(t, conn, reader, "ATTACH", path); reply.Type != resp.ValueTypeSimpleError {
+			t.Errorf("%q: expected a path outside of the attach directory to be rejected, got %+v", path, reply)
+		}
+	}
+	if reply := helperCommand(t, conn, reader, "ATTACH", "nested/second"); reply.Integer != 1 {
+		t.Fatalf("ATTACH: expected 1, got %+v", reply)
+	}
+	if exists, _ := afero.DirExists(store.fs, filepath.Join("attached", "nested", "second")); !exists {
+		t.Errorf("expected the datastore to be created in the attach directory")
+	}
+}
@@ -2,13 +2,122 @@ package internal
 
 import "github.com/ananthvk/kvdb/internal/resp"
 
-type CommandFunc func(args []resp.Value, store *KVStore) resp.Value
+type CommandFunc func(args []resp.Value, store *KVStore, client *Client) resp.Value
+
+// Returned by commands that send their replies themselves (for example, SUBSCRIBE sends one reply per channel)
+const valueTypeNoReply resp.ValueType = -1
 
 var Commands = map[string]CommandFunc{
-	"ECHO": handleEcho,
-	"PING": handlePing,
-	"GET":  handleGet,
-	"SET":  handleSet,
-	"KEYS": handleKeys,
-	"DEL":  handleDel,
+	"ECHO":      handleEcho,
+	"PING":      handlePing,
+	"GET":       handleGet,
+	"SET":       handleSet,
+	"SETNX":     handleSetNX,
+	"KEYS":      handleKeys,
+	"SCAN":      handleScan,
+	"SELECT":    handleSelect,
+	"ATTACH":    handleAttach,
+	"DETACH":    handleDetach,
+	"RANDOMKEY": handleRandomKey,
+	"DBSIZE":    handleDBSize,
+	"DEL":       handleDel,
+	"GETDEL":    handleGetDel,
+	"GETEX":     handleGetEx,
+	"GETSET":    handleGetSet,
+	"AUTH":      handleAuth,
+	"INFO":      handleInfo,
+	"SLOWLOG":   handleSlowLog,
+	"MONITOR":   handleMonitor,
+	"CLIENT":    handleClient,
+	"SAVE":      handleSave,
+	"BGSAVE":    handleBackgroundSave,
+	"LASTSAVE":  handleLastSave,
+
+	"COMPACT": handleCompact,
+
+	"SUBSCRIBE":    handleSubscribe,
+	"PSUBSCRIBE":   handlePSubscribe,
+	"UNSUBSCRIBE":  handleUnsubscribe,
+	"PUNSUBSCRIBE": handlePUnsubscribe,
+	"PUBLISH":      handlePublish,
+
+	"MULTI":   handleMulti,
+	"EXEC":    handleExec,
+	"DISCARD": handleDiscard,
+	"WATCH":   handleWatch,
+	"UNWATCH": handleUnwatch,
+
+	"SYNC":      handleSync,
+	"REPLICAOF": handleReplicaOf,
+	"REPLFILE":  handleReplFile,
+	"STANDBY":   handleStandby,
+
+	"SHUTDOWN": handleShutdown,
+
+	"TYPE": handleType,
+
+	"HSET":    handleHSet,
+	"HGET":    handleHGet,
+	"HDEL":    handleHDel,
+	"HGETALL": handleHGetAll,
+	"HLEN":    handleHLen,
+
+	"LPUSH":  handleLPush,
+	"RPUSH":  handleRPush,
+	"LPOP":   handleLPop,
+	"RPOP":   handleRPop,
+	"LLEN":   handleLLen,
+	"LRANGE": handleLRange,
+
+	"SADD":      handleSAdd,
+	"SREM":      handleSRem,
+	"SMEMBERS":  handleSMembers,
+	"SCARD":     handleSCard,
+	"SISMEMBER": handleSIsMember,
+
+	"JSON.GET": handleJSONGet,
+	"JSON.SET": handleJSONSet,
+	"JSON.DEL": handleJSONDel,
+}
+
+// Commands that are allowed while a client is subscribed to a channel or a pattern
+var subscribedModeCommands = map[string]bool{
+	"SUBSCRIBE":    true,
+	"PSUBSCRIBE":   true,
+	"UNSUBSCRIBE":  true,
+	"PUNSUBSCRIBE": true,
+	"PING":         true,
+	"QUIT":         true,
+}
+
+// Commands that are not run with the shared command lock held. EXEC takes the lock itself, SYNC and COMPACT can run for
+// a long time, and REPLICAOF, STANDBY and SHUTDOWN wait for the replication link or the standby (which take the lock)
+// to stop
+var exclusiveCommands = map[string]bool{
+	"EXEC":      true,
+	"SYNC":      true,
+	"COMPACT":   true,
+	"REPLICAOF": true,
+	"STANDBY":   true,
+	"SHUTDOWN":  true,
+	"SAVE":      true,
+}
+
+// Commands that modify the store, these are rejected on a replica
+var writeCommands = map[string]bool{
+	"SET":      true,
+	"SETNX":    true,
+	"DEL":      true,
+	"GETDEL":   true,
+	"GETSET":   true,
+	"HSET":     true,
+	"HDEL":     true,
+	"LPUSH":    true,
+	"RPUSH":    true,
+	"LPOP":     true,
+	"RPOP":     true,
+	"SADD":     true,
+	"SREM":     true,
+	"JSON.SET": true,
+	"JSON.DEL": true,
 }
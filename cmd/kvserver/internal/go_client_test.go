@@ -0,0 +1,106 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/ananthvk/kvdb/client"
+)
+
+func TestGoClient(t *testing.T) {
+	store := helperMemoryStore(t)
+	store.RequirePass = "secret"
+	c := client.New(helperServe(t, store), &client.Options{Password: "secret", PoolSize: 2})
+	defer c.Close()
+	ctx := context.Background()
+
+	if err := c.Set(ctx, "key1", []byte("value1")); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	if value, err := c.Get(ctx, "key1"); err != nil || string(value) != "value1" {
+		t.Errorf("Get: expected value1, got %q, %v", value, err)
+	}
+	if _, err := c.Get(ctx, "missing"); !errors.Is(err, client.ErrNil) {
+		t.Errorf("Get: expected ErrNil for a missing key, got %v", err)
+	}
+	c.Do(ctx, "LPUSH", "list", "a")
+	var replyErr *client.Error
+	if _, err := c.Get(ctx, "list"); !errors.As(err, &replyErr) || replyErr.Prefix != "WRONGTYPE" {
+		t.Errorf("Get: expected a WRONGTYPE error, got %v", err)
+	}
+	if n, err := c.Del(ctx, "key1", "list", "missing"); err != nil || n != 2 {
+		t.Errorf("Del: expected 2, got %d, %v", n, err)
+	}
+
+	// Concurrent commands share the pool
+	var wg sync.WaitGroup
+	for i := range 20 {
+		wg.Go(func() {
+			if err := c.Set(ctx, "key:"+strconv.Itoa(i), []byte("x")); err != nil {
+				t.Errorf("Set failed: %v", err)
+			}
+		})
+	}
+	wg.Wait()
+	var keys []string
+	if err := c.ScanAll(ctx, 3, func(key string) error { keys = append(keys, key); return nil }); err != nil {
+		t.Fatalf("ScanAll failed: %v", err)
+	}
+	if len(keys) != 20 || !slices.IsSorted(keys) {
+		t.Errorf("ScanAll: expected 20 sorted keys, got %v", keys)
+	}
+
+	p := c.Pipeline()
+	p.Do("SET", "a", 1)
+	p.Do("GET", "a")
+	p.Do("HGET", "a", "field")
+	results, err := p.Exec(ctx)
+	if err != nil || len(results) != 3 {
+		t.Fatalf("Exec: expected 3 results, got %v, %v", results, err)
+	}
+	if results[0].Value != "OK" || results[1].Value != "1" || results[2].Err == nil {
+		t.Errorf("Exec: unexpected results %+v", results)
+	}
+}
+
+func TestGoClientWatch(t *testing.T) {
+	store := helperMemoryStore(t)
+	c := client.New(helperServe(t, store), nil)
+	defer c.Close()
+	ctx := context.Background()
+	c.Set(ctx, "counter", []byte("1"))
+
+	increment := func(modify bool) error {
+		return c.Watch(ctx, func(tx *client.Tx) error {
+			value, err := tx.Get(ctx, "counter")
+			if err != nil {
+				return err
+			}
+			n, _ := strconv.Atoi(string(value))
+			if modify {
+				// Another client modifies the watched key before EXEC
+				c.Set(ctx, "counter", []byte("10"))
+			}
+			p := tx.Pipeline()
+			p.Do("SET", "counter", n+1)
+			_, err = p.Exec(ctx)
+			return err
+		}, "counter")
+	}
+	if err := increment(false); err != nil {
+		t.Fatalf("Watch failed: %v", err)
+	}
+	if value, _ := c.Get(ctx, "counter"); string(value) != "2" {
+		t.Errorf("expected 2, got %s", value)
+	}
+	if err := increment(true); !errors.Is(err, client.ErrTxFailed) {
+		t.Fatalf("expected ErrTxFailed, got %v", err)
+	}
+	if value, _ := c.Get(ctx, "counter"); string(value) != "10" {
+		t.Errorf("expected the value of the other client, got %s", value)
+	}
+}
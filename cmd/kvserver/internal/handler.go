@@ -7,10 +7,15 @@ import (
 	"fmt"
 	"log/slog"
 	"net"
+	"os"
+	"time"
 
 	"github.com/ananthvk/kvdb/internal/resp"
 )
 
+// Size of the read and write buffers allocated for each client connection
+const clientBufferSize = 4096
+
 func sendResponse(value resp.Value, writer *bufio.Writer) error {
 	err := resp.Serialize(value, writer)
 	if err == nil {
@@ -21,51 +26,96 @@ func sendResponse(value resp.Value, writer *bufio.Writer) error {
 	return err
 }
 
-func sendRequestError(message []byte, writer *bufio.Writer) error {
-	return sendResponse(resp.Value{
+func requestError(message []byte) resp.Value {
+	return resp.Value{
 		Type:              resp.ValueTypeSimpleError,
 		SimpleErrorPrefix: []byte("REQUEST_ERR"),
 		Buffer:            message,
-	}, writer)
+	}
 }
 
-func sendError(message []byte, writer *bufio.Writer) error {
-	return sendResponse(resp.Value{
+func errorValue(message []byte) resp.Value {
+	return resp.Value{
 		Type:              resp.ValueTypeSimpleError,
 		SimpleErrorPrefix: []byte("ERR"),
 		Buffer:            message,
-	}, writer)
+	}
+}
+
+// Reject sends an error to a client that could not be accepted because the maximum number of clients has been reached,
+// and closes the connection
+func (kvStore *KVStore) Reject(conn net.Conn) {
+	slog.Warn("client rejected, max number of clients reached", "remote_address", conn.RemoteAddr().String())
+	defer conn.Close()
+	// Do not let a client that is not reading hold on to this goroutine
+	conn.SetWriteDeadline(time.Now().Add(time.Second))
+	sendResponse(errorValue([]byte("max number of clients reached")), bufio.NewWriter(conn))
 }
 
+// Handle processes requests from the connection until the client disconnects. The slot reserved with AcquireClient
+// is released once the connection is closed
 func (kvStore *KVStore) Handle(conn net.Conn) {
 	slog.Info("client connected", "remote_address", conn.RemoteAddr().String())
 	defer func() {
 		slog.Info("client disconnected", "remote_address", conn.RemoteAddr().String())
 	}()
+	defer kvStore.ReleaseClient()
 	defer conn.Close()
 
-	reader := bufio.NewReader(conn)
-	writer := bufio.NewWriter(conn)
+	client := NewClient(conn, kvStore.RequirePass)
+	reader := client.reader
+	kvStore.registerClient(client)
+	defer func() {
+		kvStore.unregisterClient(client)
+		kvStore.removeMonitor(client)
+		kvStore.PubSub.UnsubscribeAll(client)
+		kvStore.unwatchAll(client)
+		kvStore.Replication.removeReplica(client)
+		client.closePushQueue()
+	}()
 
 	// Process requests
 	for {
-		req, err := resp.Deserialize(reader)
+		if err := kvStore.waitForRequest(client, reader); err != nil {
+			if errors.Is(err, os.ErrDeadlineExceeded) {
+				slog.Info("closing idle client", "remote_address", conn.RemoteAddr().String())
+			}
+			break
+		}
+		req, stream, err := resp.DeserializeWithOptions(reader, &resp.Options{MaxStreamSize: kvStore.ProtoMaxBulkLen})
 		if err != nil {
+			if errors.Is(err, os.ErrDeadlineExceeded) {
+				slog.Warn("client read timed out", "remote_address", conn.RemoteAddr().String())
+			}
 			if errors.Is(err, resp.ErrProtocolError) {
-				sendRequestError([]byte(err.Error()), writer)
+				client.Send(requestError([]byte(err.Error())))
 			}
 			break
 		}
+		if stream != nil {
+			result := kvStore.handleStreamedSet(req.Array, stream, client)
+			// The rest of the value has to be read before the next request
+			if err := stream.Close(); err != nil {
+				if errors.Is(err, resp.ErrProtocolError) {
+					client.Send(requestError([]byte(err.Error())))
+				}
+				break
+			}
+			if err := client.Send(result); err != nil {
+				break
+			}
+			continue
+		}
 
 		if req.Type != resp.ValueTypeArray || len(req.Array) == 0 {
-			sendRequestError([]byte("invalid request: request must be an array of bulk strings"), writer)
+			client.Send(requestError([]byte("invalid request: request must be an array of bulk strings")))
 			continue
 		}
 
 		shouldSkip := false
 		for _, value := range req.Array {
 			if value.Type != resp.ValueTypeBulkString {
-				sendRequestError([]byte("invalid request: all array elements must be bulk strings"), writer)
+				client.Send(requestError([]byte("invalid request: all array elements must be bulk strings")))
 				shouldSkip = true
 				break
 			}
@@ -75,14 +125,99 @@ func (kvStore *KVStore) Handle(conn net.Conn) {
 		}
 
 		commandRootName := bytes.ToUpper(req.Array[0].Buffer)
+		if !client.Authenticated && string(commandRootName) != "AUTH" {
+			client.Send(resp.Value{
+				Type:              resp.ValueTypeSimpleError,
+				SimpleErrorPrefix: []byte("NOAUTH"),
+				Buffer:            []byte("Authentication required."),
+			})
+			continue
+		}
+		if writeCommands[string(commandRootName)] {
+			if reason := kvStore.readOnlyReason(); reason != "" {
+				if client.tx != nil {
+					client.tx.aborted = true
+				}
+				client.Send(resp.Value{
+					Type:              resp.ValueTypeSimpleError,
+					SimpleErrorPrefix: []byte("READONLY"),
+					Buffer:            []byte(reason),
+				})
+				continue
+			}
+		}
+		if err := kvStore.ACL.check(client.User, string(commandRootName), req.Array[1:]); err != nil {
+			if client.tx != nil {
+				client.tx.aborted = true
+			}
+			client.Send(err.Value())
+			continue
+		}
 		commandFunc, exists := Commands[string(commandRootName)]
+		if exists {
+			received := time.Now()
+			client.setLastCommand(string(bytes.ToLower(commandRootName)), received)
+			kvStore.feedMonitors(received, client, req.Array[0].Buffer, req.Array[1:])
+		}
+		if client.tx != nil && !transactionControlCommands[string(commandRootName)] {
+			if !exists {
+				commandFunc = nil
+			}
+			client.Send(queueCommand(client, string(req.Array[0].Buffer), string(commandRootName), commandFunc, req.Array[1:]))
+			continue
+		}
 		if !exists {
-			sendError(fmt.Appendf(nil, "%s '%s'", "unknown command", req.Array[0].Buffer), writer)
+			client.Send(errorValue(fmt.Appendf(nil, "%s '%s'", "unknown command", req.Array[0].Buffer)))
+			continue
+		}
+		if client.IsSubscribed() && !subscribedModeCommands[string(commandRootName)] {
+			client.Send(errorValue(fmt.Appendf(nil, "Can't execute '%s': only (P)SUBSCRIBE / (P)UNSUBSCRIBE / PING / QUIT are allowed in this context", bytes.ToLower(req.Array[0].Buffer))))
 			continue
 		}
-		result := commandFunc(req.Array[1:], kvStore)
-		if err := sendResponse(result, writer); err != nil {
+		var result resp.Value
+		var start time.Time
+		var duration time.Duration
+		if exclusiveCommands[string(commandRootName)] {
+			start = time.Now()
+			result = commandFunc(req.Array[1:], kvStore, client)
+			duration = time.Since(start)
+		} else {
+			kvStore.totalCommands.Add(1)
+			kvStore.commandLock.RLock()
+			start = time.Now()
+			result = commandFunc(req.Array[1:], kvStore, client)
+			duration = time.Since(start)
+			kvStore.commandLock.RUnlock()
+		}
+		kvStore.SlowLog.record(start, duration, commandRootName, req.Array[1:], client)
+		kvStore.commandStats.record(commandRootName, duration, result.Type == resp.ValueTypeSimpleError)
+		if result.Type == valueTypeNoReply {
+			// The command has already queued it's replies
+			continue
+		}
+		if err := client.Send(result); err != nil {
 			break
 		}
 	}
 }
+
+// waitForRequest blocks until the first byte of the next request is available (or IdleTimeout elapses), and then
+// sets the deadline for reading the rest of the request to ReadTimeout
+func (kvStore *KVStore) waitForRequest(client *Client, reader *bufio.Reader) error {
+	conn := client.Conn
+	if reader.Buffered() == 0 {
+		// Subscribed clients and replicas are expected to be idle while waiting for messages
+		if kvStore.IdleTimeout > 0 && !client.IsSubscribed() && !client.isReplica {
+			conn.SetReadDeadline(time.Now().Add(kvStore.IdleTimeout))
+		} else {
+			conn.SetReadDeadline(time.Time{})
+		}
+		if _, err := reader.Peek(1); err != nil {
+			return err
+		}
+	}
+	if kvStore.ReadTimeout > 0 {
+		return conn.SetReadDeadline(time.Now().Add(kvStore.ReadTimeout))
+	}
+	return conn.SetReadDeadline(time.Time{})
+}
@@ -0,0 +1,158 @@
+package internal
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ananthvk/kvdb/internal/resp"
+)
+
+// helperServeWithLimit is like helperServe, but accepts clients like the server does, rejecting the ones over
+// MaxClients
+func helperServeWithLimit(t *testing.T, store *KVStore) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("could not listen: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			if !store.AcquireClient() {
+				go store.Reject(conn)
+				continue
+			}
+			go store.Handle(conn)
+		}
+	}()
+	return listener.Addr().String()
+}
+
+// helperExpectClosed checks that the server closes the connection within a few seconds
+func helperExpectClosed(t *testing.T, conn net.Conn, reader *bufio.Reader) {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := reader.ReadByte(); err != io.EOF {
+		t.Errorf("expected the server to close the connection, got %v", err)
+	}
+}
+
+func TestMaxClients(t *testing.T) {
+	store := helperMemoryStore(t)
+	store.SetMaxClients(1)
+	address := helperServeWithLimit(t, store)
+
+	first, firstReader := helperDial(t, address)
+	if reply := helperCommand(t, first, firstReader, "PING"); string(reply.Buffer) != "PONG" {
+		t.Fatalf("expected PONG, got %+v", reply)
+	}
+	// The second client gets an error, and is disconnected
+	second, secondReader := helperDial(t, address)
+	reply, err := resp.Deserialize(secondReader)
+	if err != nil || reply.Type != resp.ValueTypeSimpleError || !strings.Contains(string(reply.Buffer), "max number of clients") {
+		t.Errorf("expected the max number of clients error, got %+v, %v", reply, err)
+	}
+	helperExpectClosed(t, second, secondReader)
+
+	// The slot is freed when the first client disconnects
+	first.Close()
+	waitFor(t, "the first client to be released", func() bool { return store.connectedClients.Load() == 0 })
+	third, thirdReader := helperDial(t, address)
+	if reply := helperCommand(t, third, thirdReader, "PING"); string(reply.Buffer) != "PONG" {
+		t.Errorf("expected PONG once the first client disconnected, got %+v", reply)
+	}
+}
+
+func TestIdleTimeout(t *testing.T) {
+	store := helperMemoryStore(t)
+	store.IdleTimeout = 100 * time.Millisecond
+	address := helperServe(t, store)
+
+	conn, reader := helperDial(t, address)
+	if reply := helperCommand(t, conn, reader, "PING"); string(reply.Buffer) != "PONG" {
+		t.Fatalf("expected PONG, got %+v", reply)
+	}
+	helperExpectClosed(t, conn, reader)
+
+	// A subscribed client waits for messages, it's not idle
+	subscriber, subscriberReader := helperDial(t, address)
+	helperCommand(t, subscriber, subscriberReader, "SUBSCRIBE", "channel")
+	time.Sleep(3 * store.IdleTimeout)
+	publisher, publisherReader := helperDial(t, address)
+	helperCommand(t, publisher, publisherReader, "PUBLISH", "channel", "message")
+	subscriber.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if message, err := resp.Deserialize(subscriberReader); err != nil || len(message.Array) != 3 {
+		t.Errorf("expected the subscriber to get the message, got %+v, %v", message, err)
+	}
+}
+
+func TestReadTimeout(t *testing.T) {
+	store := helperMemoryStore(t)
+	store.ReadTimeout = 100 * time.Millisecond
+	conn, reader := helperDial(t, helperServe(t, store))
+
+	// A client that starts a request and does not finish it is disconnected
+	conn.Write([]byte("*2\r\n$4\r\nECHO\r\n"))
+	helperExpectClosed(t, conn, reader)
+}
+
+func TestAuth(t *testing.T) {
+	store := helperMemoryStore(t)
+	store.RequirePass = "secret"
+	store.Store.Put([]byte("key"), []byte("value"))
+	conn, reader := helperDial(t, helperServe(t, store))
+
+	// Commands are rejected until the client authenticates
+	for _, args := range [][]string{{"GET", "key"}, {"SET", "key", "other"}, {"PING"}} {
+		if reply := helperCommand(t, conn, reader, args...); string(reply.SimpleErrorPrefix) != "NOAUTH" {
+			t.Errorf("%s: expected NOAUTH, got %+v", args[0], reply)
+		}
+	}
+	if value, _ := store.Store.Get([]byte("key")); string(value) != "value" {
+		t.Errorf("expected the SET before AUTH not to run, got %q", value)
+	}
+
+	for _, args := range [][]string{{"AUTH", "wrong"}, {"AUTH", "default", "wrong"}, {"AUTH", "nobody", "secret"}} {
+		if reply := helperCommand(t, conn, reader, args...); string(reply.SimpleErrorPrefix) != "WRONGPASS" {
+			t.Errorf("%v: expected WRONGPASS, got %+v", args, reply)
+		}
+	}
+	if reply := helperCommand(t, conn, reader, "GET", "key"); string(reply.SimpleErrorPrefix) != "NOAUTH" {
+		t.Errorf("expected NOAUTH after a failed AUTH, got %+v", reply)
+	}
+
+	if reply := helperCommand(t, conn, reader, "AUTH", "secret"); string(reply.Buffer) != "OK" {
+		t.Fatalf("expected AUTH to succeed, got %+v", reply)
+	}
+	if reply := helperCommand(t, conn, reader, "GET", "key"); string(reply.Buffer) != "value" {
+		t.Errorf("expected the value after AUTH, got %+v", reply)
+	}
+
+	// Authentication is per connection, AUTH default <password> works too
+	other, otherReader := helperDial(t, helperServe(t, store))
+	if reply := helperCommand(t, other, otherReader, "GET", "key"); string(reply.SimpleErrorPrefix) != "NOAUTH" {
+		t.Errorf("expected NOAUTH on a new connection, got %+v", reply)
+	}
+	if reply := helperCommand(t, other, otherReader, "AUTH", "default", "secret"); string(reply.Buffer) != "OK" {
+		t.Errorf("expected AUTH default to succeed, got %+v", reply)
+	}
+}
+
+func TestAuthWithoutPassword(t *testing.T) {
+	store := helperMemoryStore(t)
+	conn, reader := helperDial(t, helperServe(t, store))
+	if reply := helperCommand(t, conn, reader, "PING"); string(reply.Buffer) != "PONG" {
+		t.Errorf("expected commands to run without AUTH, got %+v", reply)
+	}
+	if reply := helperCommand(t, conn, reader, "AUTH", "secret"); reply.Type != resp.ValueTypeSimpleError {
+		t.Errorf("expected AUTH to fail without a password, got %+v", reply)
+	}
+}
@@ -0,0 +1,108 @@
+package internal
+
+import (
+	"errors"
+	"sort"
+
+	"github.com/ananthvk/kvdb"
+	"github.com/ananthvk/kvdb/internal/resp"
+)
+
+// Hash commands (HSET, HGET, HDEL, HGETALL, HLEN), on the hashes of the store (see DataStore.Hash). Like in Redis, a
+// hash that does not exist is empty, a hash is deleted with it's last field, and the commands fail with WRONGTYPE on a
+// key that holds a value of another type
+
+// structuredError returns the reply of an error of a method of a structured type
+func structuredError(err error) resp.Value {
+	if errors.Is(err, kvdb.ErrWrongType) {
+		return wrongTypeValue()
+	}
+	return resp.Value{
+		Type:              resp.ValueTypeSimpleError,
+		SimpleErrorPrefix: []byte("INTERNAL_ERR"),
+		Buffer:            []byte(err.Error()),
+	}
+}
+
+// HSET key field value [field value ...] returns the number of fields that were added
+func handleHSet(args []resp.Value, store *KVStore, client *Client) resp.Value {
+	if len(args) < 3 || len(args)%2 != 1 {
+		return errorValue([]byte("wrong number of arguments for 'HSET' command"))
+	}
+	fields := make(map[string][]byte, len(args)/2)
+	for i := 1; i < len(args); i += 2 {
+		fields[string(args[i].Buffer)] = args[i+1].Buffer
+	}
+	added, err := store.db(client).Hash(args[0].Buffer).SetFields(fields)
+	if err != nil {
+		return structuredError(err)
+	}
+	return resp.Value{Type: resp.ValueTypeInteger, Integer: int64(added)}
+}
+
+// HGET key field returns the value of the field, or Null if the hash does not have the field
+func handleHGet(args []resp.Value, store *KVStore, client *Client) resp.Value {
+	if len(args) != 2 {
+		return errorValue([]byte("wrong number of arguments for 'HGET' command"))
+	}
+	value, err := store.db(client).Hash(args[0].Buffer).Get(args[1].Buffer)
+	if errors.Is(err, kvdb.ErrKeyNotFound) {
+		return resp.Value{Type: resp.ValueTypeNull}
+	}
+	if err != nil {
+		return structuredError(err)
+	}
+	return resp.Value{Type: resp.ValueTypeBulkString, Buffer: value}
+}
+
+// HDEL key field [field ...] returns the number of fields that were removed
+func handleHDel(args []resp.Value, store *KVStore, client *Client) resp.Value {
+	if len(args) < 2 {
+		return errorValue([]byte("wrong number of arguments for 'HDEL' command"))
+	}
+	fields := make([][]byte, 0, len(args)-1)
+	for _, arg := range args[1:] {
+		fields = append(fields, arg.Buffer)
+	}
+	removed, err := store.db(client).Hash(args[0].Buffer).Delete(fields...)
+	if err != nil {
+		return structuredError(err)
+	}
+	return resp.Value{Type: resp.ValueTypeInteger, Integer: int64(removed)}
+}
+
+// HGETALL key returns the fields and their values, one after the other, in ascending field order
+func handleHGetAll(args []resp.Value, store *KVStore, client *Client) resp.Value {
+	if len(args) != 1 {
+		return errorValue([]byte("wrong number of arguments for 'HGETALL' command"))
+	}
+	fields, err := store.db(client).Hash(args[0].Buffer).GetAll()
+	if err != nil {
+		return structuredError(err)
+	}
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	values := make([]resp.Value, 0, 2*len(names))
+	for _, name := range names {
+		values = append(values,
+			resp.Value{Type: resp.ValueTypeBulkString, Buffer: []byte(name)},
+			resp.Value{Type: resp.ValueTypeBulkString, Buffer: fields[name]},
+		)
+	}
+	return resp.Value{Type: resp.ValueTypeArray, Array: values}
+}
+
+// HLEN key returns the number of fields of the hash
+func handleHLen(args []resp.Value, store *KVStore, client *Client) resp.Value {
+	if len(args) != 1 {
+		return errorValue([]byte("wrong number of arguments for 'HLEN' command"))
+	}
+	n, err := store.db(client).Hash(args[0].Buffer).Len()
+	if err != nil {
+		return structuredError(err)
+	}
+	return resp.Value{Type: resp.ValueTypeInteger, Integer: int64(n)}
+}
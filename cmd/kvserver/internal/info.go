@@ -0,0 +1,171 @@
+package internal
+
+import (
+	"bytes"
+	"fmt"
+	"maps"
+	"os"
+	"runtime"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/ananthvk/kvdb/internal/resp"
+)
+
+// infoSection writes a single section of the INFO reply
+type infoSection struct {
+	name  string
+	write func(buf *bytes.Buffer, store *KVStore) error
+}
+
+var infoSections = []infoSection{
+	{"server", writeInfoServer},
+	{"clients", writeInfoClients},
+	{"memory", writeInfoMemory},
+	{"persistence", writeInfoPersistence},
+	{"stats", writeInfoStats},
+	{"replication", writeInfoReplication},
+	{"keyspace", writeInfoKeyspace},
+	{"commandstats", writeInfoCommandStats},
+	{"latencystats", writeInfoLatencyStats},
+}
+
+func writeInfoServer(buf *bytes.Buffer, store *KVStore) error {
+	uptime := time.Since(store.StartTime)
+	fmt.Fprintf(buf, "go_version:%s\r\n", runtime.Version())
+	fmt.Fprintf(buf, "os:%s %s\r\n", runtime.GOOS, runtime.GOARCH)
+	fmt.Fprintf(buf, "process_id:%d\r\n", os.Getpid())
+	fmt.Fprintf(buf, "uptime_in_seconds:%d\r\n", int64(uptime.Seconds()))
+	fmt.Fprintf(buf, "uptime_in_days:%d\r\n", int64(uptime.Hours()/24))
+	return nil
+}
+
+func writeInfoClients(buf *bytes.Buffer, store *KVStore) error {
+	fmt.Fprintf(buf, "connected_clients:%d\r\n", store.ConnectedClients())
+	fmt.Fprintf(buf, "maxclients:%d\r\n", store.MaxClients())
+	return nil
+}
+
+func writeInfoMemory(buf *bytes.Buffer, store *KVStore) error {
+	stats, err := store.Store.Stats()
+	if err != nil {
+		return err
+	}
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	fmt.Fprintf(buf, "used_memory:%d\r\n", m.HeapAlloc)
+	fmt.Fprintf(buf, "used_memory_sys:%d\r\n", m.Sys)
+	fmt.Fprintf(buf, "used_memory_keydir:%d\r\n", stats.KeydirMemoryBytes)
+	fmt.Fprintf(buf, "used_memory_clients:%d\r\n", store.ClientMemoryUsage())
+	fmt.Fprintf(buf, "used_memory_cache:%d\r\n", stats.CacheMemoryBytes)
+	fmt.Fprintf(buf, "reader_cache_entries:%d\r\n", stats.OpenReaders)
+	fmt.Fprintf(buf, "open_files:%d\r\n", stats.OpenFiles)
+	fmt.Fprintf(buf, "max_open_files:%d\r\n", stats.MaxOpenFiles)
+	fmt.Fprintf(buf, "heap_objects:%d\r\n", m.HeapObjects)
+	fmt.Fprintf(buf, "heap_idle:%d\r\n", m.HeapIdle)
+	fmt.Fprintf(buf, "heap_released:%d\r\n", m.HeapReleased)
+	fmt.Fprintf(buf, "stack_inuse:%d\r\n", m.StackInuse)
+	fmt.Fprintf(buf, "gc_cycles:%d\r\n", m.NumGC)
+	fmt.Fprintf(buf, "gc_pause_total_ns:%d\r\n", m.PauseTotalNs)
+	fmt.Fprintf(buf, "goroutines:%d\r\n", runtime.NumGoroutine())
+	return nil
+}
+
+func writeInfoPersistence(buf *bytes.Buffer, store *KVStore) error {
+	stats, err := store.Store.Stats()
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(buf, "datastore_path:%s\r\n", stats.Path)
+	fmt.Fprintf(buf, "datastore_version:%s\r\n", stats.Version)
+	fmt.Fprintf(buf, "data_files:%d\r\n", stats.DataFiles)
+	fmt.Fprintf(buf, "data_file_bytes:%d\r\n", stats.DataFileBytes)
+	fmt.Fprintf(buf, "reclaimable_bytes:%d\r\n", stats.ReclaimableBytes)
+	fmt.Fprintf(buf, "active_file_id:%d\r\n", stats.ActiveFileId)
+	fmt.Fprintf(buf, "merge_in_progress:%d\r\n", boolToInt(stats.MergeInProgress))
+	fmt.Fprintf(buf, "total_merges:%d\r\n", stats.Merges)
+	fmt.Fprintf(buf, "failed_merges:%d\r\n", stats.FailedMerges)
+	lastMergeTime := int64(-1)
+	if !stats.LastMergeTime.IsZero() {
+		lastMergeTime = stats.LastMergeTime.Unix()
+	}
+	fmt.Fprintf(buf, "last_merge_time:%d\r\n", lastMergeTime)
+	fmt.Fprintf(buf, "last_merge_duration_ms:%d\r\n", stats.LastMergeDuration.Milliseconds())
+	lastMergeStatus := "ok"
+	if stats.LastMergeError != nil {
+		lastMergeStatus = "err"
+	}
+	fmt.Fprintf(buf, "last_merge_status:%s\r\n", lastMergeStatus)
+	fmt.Fprintf(buf, "deferred_merges:%d\r\n", store.DeferredMerges())
+	lastSave, lastSaveErr := store.LastSave()
+	fmt.Fprintf(buf, "bgsave_in_progress:%d\r\n", boolToInt(store.backgroundSaveInProgress()))
+	fmt.Fprintf(buf, "last_save_time:%d\r\n", lastSave.Unix())
+	lastSaveStatus := "ok"
+	if lastSaveErr != nil {
+		lastSaveStatus = "err"
+	}
+	fmt.Fprintf(buf, "last_save_status:%s\r\n", lastSaveStatus)
+	return nil
+}
+
+func writeInfoStats(buf *bytes.Buffer, store *KVStore) error {
+	stats, err := store.Store.Stats()
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(buf, "total_connections_received:%d\r\n", store.totalConnections.Load())
+	fmt.Fprintf(buf, "rejected_connections:%d\r\n", store.rejectedConnections.Load())
+	fmt.Fprintf(buf, "total_commands_processed:%d\r\n", store.totalCommands.Load())
+	fmt.Fprintf(buf, "total_gets:%d\r\n", stats.Gets)
+	fmt.Fprintf(buf, "total_puts:%d\r\n", stats.Puts)
+	fmt.Fprintf(buf, "total_deletes:%d\r\n", stats.Deletes)
+	return nil
+}
+
+func writeInfoKeyspace(buf *bytes.Buffer, store *KVStore) error {
+	fmt.Fprintf(buf, "db0:keys=%d\r\n", store.Store.Size())
+	attached := store.attachedStores()
+	for _, index := range slices.Sorted(maps.Keys(attached)) {
+		fmt.Fprintf(buf, "db%d:keys=%d\r\n", index, attached[index].Size())
+	}
+	return nil
+}
+
+func boolToInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// INFO [section ...]
+func handleInfo(args []resp.Value, store *KVStore, client *Client) resp.Value {
+	wanted := map[string]bool{}
+	for _, arg := range args {
+		wanted[strings.ToLower(string(arg.Buffer))] = true
+	}
+	all := len(wanted) == 0 || wanted["all"] || wanted["default"] || wanted["everything"]
+
+	var buf bytes.Buffer
+	for _, section := range infoSections {
+		if !all && !wanted[section.name] {
+			continue
+		}
+		if buf.Len() > 0 {
+			buf.WriteString("\r\n")
+		}
+		fmt.Fprintf(&buf, "# %s\r\n", strings.ToUpper(section.name[:1])+section.name[1:])
+		if err := section.write(&buf, store); err != nil {
+			return resp.Value{
+				Type:              resp.ValueTypeSimpleError,
+				SimpleErrorPrefix: []byte("INTERNAL_ERR"),
+				Buffer:            []byte(err.Error()),
+			}
+		}
+	}
+	return resp.Value{
+		Type:   resp.ValueTypeBulkString,
+		Buffer: buf.Bytes(),
+	}
+}
@@ -0,0 +1,222 @@
+package internal
+
+import (
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/ananthvk/kvdb"
+	"github.com/ananthvk/kvdb/internal/resp"
+)
+
+// RedisJSON compatible commands (JSON.GET, JSON.SET, JSON.DEL). Paths can either be JSONPath style (`$`, `$.a.b[0]`), or
+// legacy style (`.`, `.a.b[0]`, `a.b`). Only plain member and index selectors are supported, wildcards, recursive
+// descent and filters are not. As in RedisJSON, JSONPath results are wrapped in an array, while legacy paths return the value itself
+
+var errUnsupportedPath = errors.New("unsupported or invalid JSON path")
+
+// parseJSONPath converts a RedisJSON path into a JSON pointer. isLegacy is true if the path is not a JSONPath (`$`) path
+func parseJSONPath(path string) (pointer string, isLegacy bool, err error) {
+	rest, found := strings.CutPrefix(path, "$")
+	if !found {
+		isLegacy = true
+		if path != "" && path[0] != '.' && path[0] != '[' {
+			rest = "." + path
+		}
+		if path == "." {
+			rest = ""
+		}
+	}
+
+	var builder strings.Builder
+	for len(rest) > 0 {
+		var token string
+		switch rest[0] {
+		case '.':
+			rest = rest[1:]
+			end := strings.IndexAny(rest, ".[")
+			if end == -1 {
+				end = len(rest)
+			}
+			token, rest = rest[:end], rest[end:]
+		case '[':
+			end := strings.IndexByte(rest, ']')
+			if end == -1 {
+				return "", false, errUnsupportedPath
+			}
+			token, rest = rest[1:end], rest[end+1:]
+			if len(token) >= 2 && (token[0] == '\'' || token[0] == '"') && token[len(token)-1] == token[0] {
+				token = token[1 : len(token)-1]
+			} else if _, err := strconv.Atoi(token); err != nil {
+				return "", false, errUnsupportedPath
+			}
+		default:
+			return "", false, errUnsupportedPath
+		}
+		if token == "" || token == "*" {
+			return "", false, errUnsupportedPath
+		}
+		builder.WriteByte('/')
+		builder.WriteString(strings.ReplaceAll(strings.ReplaceAll(token, "~", "~0"), "/", "~1"))
+	}
+	return builder.String(), isLegacy, nil
+}
+
+func jsonPathError(path []byte) resp.Value {
+	return resp.Value{
+		Type:              resp.ValueTypeSimpleError,
+		SimpleErrorPrefix: []byte("ERR"),
+		Buffer:            append([]byte("unsupported or invalid JSON path: "), path...),
+	}
+}
+
+// JSON.GET key [path ...]
+func handleJSONGet(args []resp.Value, store *KVStore, client *Client) resp.Value {
+	if len(args) == 0 {
+		return resp.Value{
+			Type:              resp.ValueTypeSimpleError,
+			SimpleErrorPrefix: []byte("ERR"),
+			Buffer:            []byte("wrong number of arguments for 'JSON.GET' command"),
+		}
+	}
+	key := args[0].Buffer
+	paths := args[1:]
+	if len(paths) == 0 {
+		paths = []resp.Value{{Type: resp.ValueTypeBulkString, Buffer: []byte(".")}}
+	}
+
+	results := make(map[string]json.RawMessage, len(paths))
+	for _, path := range paths {
+		pointer, isLegacy, err := parseJSONPath(string(path.Buffer))
+		if err != nil {
+			return jsonPathError(path.Buffer)
+		}
+		var value json.RawMessage
+		err = store.db(client).GetJSONPath(key, pointer, &value)
+		if err != nil {
+			if errors.Is(err, kvdb.ErrKeyNotFound) {
+				return resp.Value{Type: resp.ValueTypeNull}
+			}
+			if !errors.Is(err, kvdb.ErrJSONPathNotFound) {
+				return resp.Value{
+					Type:              resp.ValueTypeSimpleError,
+					SimpleErrorPrefix: []byte("INTERNAL_ERR"),
+					Buffer:            []byte(err.Error()),
+				}
+			}
+			if isLegacy {
+				return resp.Value{
+					Type:              resp.ValueTypeSimpleError,
+					SimpleErrorPrefix: []byte("ERR"),
+					Buffer:            append([]byte("Path does not exist: "), path.Buffer...),
+				}
+			}
+			// JSONPath queries return an empty list of matches
+			value = json.RawMessage("[]")
+		} else if !isLegacy {
+			value = append(append(json.RawMessage("["), value...), ']')
+		}
+		results[string(path.Buffer)] = value
+	}
+
+	var reply []byte
+	if len(paths) == 1 {
+		reply = results[string(paths[0].Buffer)]
+	} else {
+		// With multiple paths, the reply is an object keyed by the path
+		reply, _ = json.Marshal(results)
+	}
+	return resp.Value{
+		Type:   resp.ValueTypeBulkString,
+		Buffer: reply,
+	}
+}
+
+// JSON.SET key path value
+func handleJSONSet(args []resp.Value, store *KVStore, client *Client) resp.Value {
+	if len(args) != 3 {
+		return resp.Value{
+			Type:              resp.ValueTypeSimpleError,
+			SimpleErrorPrefix: []byte("ERR"),
+			Buffer:            []byte("wrong number of arguments for 'JSON.SET' command"),
+		}
+	}
+	pointer, _, err := parseJSONPath(string(args[1].Buffer))
+	if err != nil {
+		return jsonPathError(args[1].Buffer)
+	}
+	if !json.Valid(args[2].Buffer) {
+		return resp.Value{
+			Type:              resp.ValueTypeSimpleError,
+			SimpleErrorPrefix: []byte("ERR"),
+			Buffer:            []byte("invalid JSON value"),
+		}
+	}
+
+	if err := store.db(client).PatchJSON(args[0].Buffer, pointer, json.RawMessage(args[2].Buffer)); err != nil {
+		if errors.Is(err, kvdb.ErrKeyNotFound) {
+			return resp.Value{
+				Type:              resp.ValueTypeSimpleError,
+				SimpleErrorPrefix: []byte("ERR"),
+				Buffer:            []byte("new objects must be created at the root"),
+			}
+		}
+		if errors.Is(err, kvdb.ErrJSONPathNotFound) {
+			return resp.Value{Type: resp.ValueTypeNull}
+		}
+		return resp.Value{
+			Type:              resp.ValueTypeSimpleError,
+			SimpleErrorPrefix: []byte("INTERNAL_ERR"),
+			Buffer:            []byte(err.Error()),
+		}
+	}
+	return resp.Value{
+		Type:   resp.ValueTypeSimpleString,
+		Buffer: []byte{'O', 'K'},
+	}
+}
+
+// JSON.DEL key [path]
+func handleJSONDel(args []resp.Value, store *KVStore, client *Client) resp.Value {
+	if len(args) != 1 && len(args) != 2 {
+		return resp.Value{
+			Type:              resp.ValueTypeSimpleError,
+			SimpleErrorPrefix: []byte("ERR"),
+			Buffer:            []byte("wrong number of arguments for 'JSON.DEL' command"),
+		}
+	}
+	pointer := ""
+	if len(args) == 2 {
+		var err error
+		if pointer, _, err = parseJSONPath(string(args[1].Buffer)); err != nil {
+			return jsonPathError(args[1].Buffer)
+		}
+	}
+
+	var err error
+	deleted := true
+	if pointer == "" {
+		deleted, err = store.db(client).DeleteWithExists(args[0].Buffer)
+	} else {
+		err = store.db(client).PatchJSON(args[0].Buffer, pointer, nil)
+		if errors.Is(err, kvdb.ErrKeyNotFound) || errors.Is(err, kvdb.ErrJSONPathNotFound) {
+			deleted, err = false, nil
+		}
+	}
+	if err != nil {
+		return resp.Value{
+			Type:              resp.ValueTypeSimpleError,
+			SimpleErrorPrefix: []byte("INTERNAL_ERR"),
+			Buffer:            []byte(err.Error()),
+		}
+	}
+	var count int64
+	if deleted {
+		count = 1
+	}
+	return resp.Value{
+		Type:    resp.ValueTypeInteger,
+		Integer: count,
+	}
+}
@@ -1,7 +1,11 @@
 package internal
 
 import (
+	"context"
+	"errors"
 	"log/slog"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/ananthvk/kvdb"
@@ -11,8 +15,8 @@ import (
 // Sync every 30s
 const syncInterval = time.Second * 30
 
-// Merge every 1min (TODO: make it configurable later)
-const mergeInterval = time.Minute * 2
+// Merge every 2min by default, see SetMergeInterval
+const defaultMergeInterval = time.Minute * 2
 
 // A wrapper around store, that also implements background compaction
 // and periodic Sync
@@ -20,23 +24,137 @@ const mergeInterval = time.Minute * 2
 type KVStore struct {
 	Path  string
 	Store *kvdb.DataStore
+	// fs is the filesystem of the store
+	fs afero.Fs
+	// RequirePass is the password clients must send with AUTH, authentication is disabled if it's empty
+	RequirePass string
+	// ACL limits the commands, keys and value sizes of each user, every user can do everything if it's nil. See acl.go
+	ACL *ACL
+	// maxClients is the maximum number of simultaneously connected clients, 0 means no limit. See SetMaxClients
+	maxClients atomic.Int64
+	// IdleTimeout is the maximum time to wait for the next request from a client, 0 means no timeout
+	IdleTimeout time.Duration
+	// ReadTimeout is the maximum time to read a request once it's first byte has been received, 0 means no timeout
+	ReadTimeout time.Duration
+	// ProtoMaxBulkLen is the largest value SET key value can write, values larger than the bulk string limit of the
+	// protocol (1 MiB) are streamed into the store. 0 disables streaming, see stream.go
+	ProtoMaxBulkLen int64
+	// PrimaryAuth is the password sent with AUTH when connecting to a primary as a replica
+	PrimaryAuth string
+	// KeysTimeout and CompactTimeout are the maximum times KEYS and COMPACT can run for, 0 means no timeout. See
+	// timeouts.go
+	KeysTimeout    time.Duration
+	CompactTimeout time.Duration
+	// BackupDir is the directory where SAVE, BGSAVE and SHUTDOWN SAVE write a snapshot of the store, no snapshot is
+	// written if it's empty
+	BackupDir string
+	// AttachDir is the directory under which ATTACH opens datastores, ATTACH is disabled if it's empty. See databases.go
+	AttachDir string
+	// StandbyInterval is the time between two refreshes of a standby, 30 seconds if it's 0. See standby.go
+	StandbyInterval time.Duration
+	// SlowLog records the commands that take longer than it's threshold, see slowlog.go
+	SlowLog *SlowLog
+
+	// StartTime is the time at which the store was opened
+	StartTime time.Time
+	PubSub    *PubSub
+	// Replication holds the records streamed to replicas of this server
+	Replication *ReplicationBacklog
+
+	// replica is non nil if this server is a replica of another server
+	replicaMu sync.Mutex
+	replica   *replicaLink
+	// standby is non nil if this server is a standby of the snapshots in a shared directory, see standby.go
+	standbyMu sync.Mutex
+	standby   *standbyLink
+
+	// Every command is run with commandLock held for reading, EXEC holds it for writing so that a transaction is not
+	// interleaved with commands from other clients
+	commandLock sync.RWMutex
+	// Clients that have WATCHed a key, indexed by the key
+	watchedKeysMu sync.Mutex
+	watchedKeys   map[string]map[*Client]bool
+
+	// Clients that sent MONITOR, see monitor.go. monitorCount is the number of monitors, so that commands are only
+	// formatted when there is one
+	monitorsMu   sync.Mutex
+	monitors     map[*Client]bool
+	monitorCount atomic.Int64
+	// Calls and latencies of every command, see command_stats.go
+	commandStats commandStats
+
+	// Connected clients, used to report their memory usage and by CLIENT. lastClientID is the ID of the last client
+	// that was registered
+	clientsMu    sync.Mutex
+	clients      map[*Client]bool
+	lastClientID atomic.Int64
+
+	connectedClients    atomic.Int64
+	totalConnections    atomic.Uint64
+	rejectedConnections atomic.Uint64
+	totalCommands       atomic.Uint64
+
+	// Set while COMPACT or the background merge is running
+	compacting atomic.Bool
+	// Time between two background merges (a time.Duration), mergeIntervalChanged wakes up the background merge when
+	// it's changed
+	mergeInterval        atomic.Int64
+	mergeIntervalChanged chan struct{}
+	// When the background merge is allowed to run, see merge_schedule.go
+	mergeSchedule mergeSchedule
+
+	// Datastores attached with ATTACH, the one with number n is at n-1 (the number of Store is 0). See databases.go
+	databasesMu sync.RWMutex
+	attached    []*attachedDatabase
+
+	// Status of SAVE and BGSAVE, see save.go
+	saves saveState
+
+	// Closed once the server has been shut down, see shutdown.go
+	shutdownMu sync.Mutex
+	done       chan struct{}
 }
 
 func NewKVStore(datastorePath string) *KVStore {
-	var fs afero.Fs
+	return NewKVStoreWithOptions(datastorePath, nil)
+}
+
+// NewKVStoreWithOptions is like NewKVStore, but opens (or creates) the datastore with the given options, opts can be nil
+func NewKVStoreWithOptions(datastorePath string, opts *kvdb.Options) *KVStore {
 	if datastorePath == ":memory" {
-		fs = afero.NewMemMapFs()
-		datastorePath = "in-memory-" + time.Now().Format(time.RFC3339) + "-db"
-	} else {
-		fs = afero.NewOsFs()
+		store, err := kvdb.CreateInMemory(opts)
+		if err != nil {
+			slog.Error("create failed", "error", err)
+			return nil
+		}
+		slog.Info("created in-memory datastore")
+		return newKVStoreFor(store, datastorePath)
 	}
+	return newKVStore(afero.NewOsFs(), datastorePath, opts)
+}
 
+// newKVStore opens (or creates) the datastore at the path in the given filesystem
+func newKVStore(fs afero.Fs, datastorePath string, opts *kvdb.Options) *KVStore {
 	start := time.Now()
-	store, err := kvdb.Open(fs, datastorePath)
+	// Log the progress of opening a large datastore, at most once a second
+	openOpts := kvdb.Options{}
+	if opts != nil {
+		openOpts = *opts
+	}
+	lastLog := start
+	openOpts.OpenProgress = func(p kvdb.OpenProgress) {
+		if time.Since(lastLog) < time.Second {
+			return
+		}
+		lastLog = time.Now()
+		slog.Info("opening datastore", "path", datastorePath, "loaded_files", p.LoadedFiles, "total_files", p.TotalFiles,
+			"records", p.Records)
+	}
+	store, err := kvdb.OpenWithContext(context.Background(), fs, datastorePath, &openOpts)
 	if err != nil {
 		slog.Error("open failed", "error", err)
 		// Try creating it
-		store, err = kvdb.Create(fs, datastorePath)
+		store, err = kvdb.CreateWithOptions(fs, datastorePath, opts)
 		if err != nil {
 			slog.Error("create failed", "error", err)
 			return nil
@@ -45,10 +163,90 @@ func NewKVStore(datastorePath string) *KVStore {
 	}
 	openDuration := time.Since(start)
 	slog.Info("opened datastore", "path", datastorePath, "took", openDuration)
-	return &KVStore{
-		Path:  datastorePath,
-		Store: store,
+	return newKVStoreFor(store, datastorePath)
+}
+
+// newKVStoreFor returns a server state for the opened datastore
+func newKVStoreFor(store *kvdb.DataStore, datastorePath string) *KVStore {
+	kv := &KVStore{
+		Path:        datastorePath,
+		Store:       store,
+		fs:          store.Fs(),
+		StartTime:   time.Now(),
+		PubSub:      NewPubSub(),
+		Replication: NewReplicationBacklog(),
+		SlowLog:     NewSlowLog(defaultSlowLogThreshold, defaultSlowLogMaxLen),
+		watchedKeys: map[string]map[*Client]bool{},
+		clients:     map[*Client]bool{},
+		monitors:    map[*Client]bool{},
+		done:        make(chan struct{}),
+
+		mergeIntervalChanged: make(chan struct{}, 1),
 	}
+	kv.mergeInterval.Store(int64(defaultMergeInterval))
+	kv.commandStats = newCommandStats()
+	store.Watch(kv.touchWatchedKey)
+	store.Watch(kv.Replication.append)
+	store.WatchMerges(kv.Replication.appendMerge)
+	return kv
+}
+
+// SetMaxClients changes the maximum number of simultaneously connected clients, 0 means no limit. Clients that are
+// already connected are not disconnected if there are more
+func (kv *KVStore) SetMaxClients(maxClients int) {
+	kv.maxClients.Store(int64(maxClients))
+}
+
+// MaxClients returns the maximum number of simultaneously connected clients, 0 means no limit
+func (kv *KVStore) MaxClients() int {
+	return int(kv.maxClients.Load())
+}
+
+// AcquireClient reserves a slot for a new client connection. It returns false if MaxClients clients are already connected,
+// in that case the connection should be rejected. Each successful call must be paired with ReleaseClient
+func (kv *KVStore) AcquireClient() bool {
+	count := kv.connectedClients.Add(1)
+	if maxClients := kv.maxClients.Load(); maxClients > 0 && count > maxClients {
+		kv.connectedClients.Add(-1)
+		kv.rejectedConnections.Add(1)
+		return false
+	}
+	kv.totalConnections.Add(1)
+	return true
+}
+
+// ReleaseClient frees the slot reserved by AcquireClient
+func (kv *KVStore) ReleaseClient() {
+	kv.connectedClients.Add(-1)
+}
+
+func (kv *KVStore) registerClient(client *Client) {
+	client.ID = kv.lastClientID.Add(1)
+	kv.clientsMu.Lock()
+	defer kv.clientsMu.Unlock()
+	kv.clients[client] = true
+}
+
+func (kv *KVStore) unregisterClient(client *Client) {
+	kv.clientsMu.Lock()
+	defer kv.clientsMu.Unlock()
+	delete(kv.clients, client)
+}
+
+// ClientMemoryUsage returns the memory used by the buffers and queued values of all connected clients, in bytes
+func (kv *KVStore) ClientMemoryUsage() int64 {
+	kv.clientsMu.Lock()
+	defer kv.clientsMu.Unlock()
+	var total int64
+	for client := range kv.clients {
+		total += client.MemoryUsage()
+	}
+	return total
+}
+
+// ConnectedClients returns the number of currently connected clients
+func (kv *KVStore) ConnectedClients() int64 {
+	return kv.connectedClients.Load()
 }
 
 func (kv *KVStore) StartBackgroundSync() {
@@ -64,23 +262,68 @@ func (kv *KVStore) StartBackgroundSync() {
 	}()
 }
 
+// SetMergeInterval changes the time between two background merges, 0 disables them. The next merge runs interval
+// after the change
+func (kv *KVStore) SetMergeInterval(interval time.Duration) {
+	kv.mergeInterval.Store(int64(interval))
+	select {
+	case kv.mergeIntervalChanged <- struct{}{}:
+	default:
+	}
+}
+
+// MergeInterval returns the time between two background merges, 0 if they are disabled
+func (kv *KVStore) MergeInterval() time.Duration {
+	return time.Duration(kv.mergeInterval.Load())
+}
+
 func (kv *KVStore) StartBackgroundMerge() {
-	// TODO: Add context, cancellation, channels to close background goroutine
 	go func() {
-		ticker := time.NewTicker(mergeInterval)
+		ticker := time.NewTicker(time.Hour)
 		defer ticker.Stop()
-		for range ticker.C {
+		resetTicker := func() {
+			if interval := kv.MergeInterval(); interval > 0 {
+				ticker.Reset(interval)
+			} else {
+				ticker.Stop()
+			}
+		}
+		resetTicker()
+		for {
+			select {
+			case <-kv.done:
+				return
+			case <-kv.mergeIntervalChanged:
+				resetTicker()
+				continue
+			case <-ticker.C:
+			}
+			if !kv.shouldMerge(time.Now()) {
+				if interval := kv.MergeInterval(); interval > 0 {
+					ticker.Reset(min(interval, mergeRetryInterval))
+				}
+				continue
+			}
+			resetTicker()
 			slog.Info("background merge started")
-			err := kv.Store.Merge()
+			started, err := kv.tryCompact(context.Background())
+			if !started {
+				slog.Info("background merge skipped, a compaction is already running")
+				continue
+			}
 			slog.Info("merging finished", "err", err)
+			kv.mergeAttached()
 		}
 	}()
 }
 
 func (kv *KVStore) Close() error {
+	kv.ReplicaOf("")
+	kv.StandbyOf("")
+	err := kv.closeAttached()
 	if kv.Store != nil {
 		slog.Info("closing store", "path", kv.Path)
-		return kv.Store.Close()
+		err = errors.Join(err, kv.Store.Close())
 	}
-	return nil
+	return err
 }
@@ -0,0 +1,145 @@
+package internal
+
+import (
+	"strconv"
+
+	"github.com/ananthvk/kvdb/internal/resp"
+)
+
+// List commands (LPUSH, RPUSH, LPOP, RPOP, LLEN, LRANGE), on the lists of the store (see DataStore.List). Like in
+// Redis, a list that does not exist is empty, a list is deleted with it's last value, and the commands fail with
+// WRONGTYPE on a key that holds a value of another type
+
+// bulkArray returns the values as an array of bulk strings
+func bulkArray(values [][]byte) resp.Value {
+	array := make([]resp.Value, 0, len(values))
+	for _, value := range values {
+		array = append(array, resp.Value{Type: resp.ValueTypeBulkString, Buffer: value})
+	}
+	return resp.Value{Type: resp.ValueTypeArray, Array: array}
+}
+
+// buffers returns the buffers of the arguments
+func buffers(args []resp.Value) [][]byte {
+	bufs := make([][]byte, 0, len(args))
+	for _, arg := range args {
+		bufs = append(bufs, arg.Buffer)
+	}
+	return bufs
+}
+
+// integerArg parses an integer argument of a command
+func integerArg(arg resp.Value) (int, resp.Value, bool) {
+	n, err := strconv.Atoi(string(arg.Buffer))
+	if err != nil {
+		return 0, errorValue([]byte("value is not an integer or out of range")), false
+	}
+	return n, resp.Value{}, true
+}
+
+// LPUSH key value [value ...] returns the length of the list after the push
+func handleLPush(args []resp.Value, store *KVStore, client *Client) resp.Value {
+	return handlePush("LPUSH", args, store, client)
+}
+
+// RPUSH key value [value ...] returns the length of the list after the push
+func handleRPush(args []resp.Value, store *KVStore, client *Client) resp.Value {
+	return handlePush("RPUSH", args, store, client)
+}
+
+func handlePush(command string, args []resp.Value, store *KVStore, client *Client) resp.Value {
+	if len(args) < 2 {
+		return errorValue([]byte("wrong number of arguments for '" + command + "' command"))
+	}
+	list := store.db(client).List(args[0].Buffer)
+	push := list.PushBack
+	if command == "LPUSH" {
+		push = list.PushFront
+	}
+	n, err := push(buffers(args[1:])...)
+	if err != nil {
+		return structuredError(err)
+	}
+	return resp.Value{Type: resp.ValueTypeInteger, Integer: int64(n)}
+}
+
+// LPOP key [count] returns the first value of the list (Null if the list is empty), or with a count, an array of up to
+// count values (Null if the list is empty)
+func handleLPop(args []resp.Value, store *KVStore, client *Client) resp.Value {
+	return handlePop("LPOP", args, store, client)
+}
+
+// RPOP key [count] is like LPOP, from the end of the list
+func handleRPop(args []resp.Value, store *KVStore, client *Client) resp.Value {
+	return handlePop("RPOP", args, store, client)
+}
+
+func handlePop(command string, args []resp.Value, store *KVStore, client *Client) resp.Value {
+	if len(args) != 1 && len(args) != 2 {
+		return errorValue([]byte("wrong number of arguments for '" + command + "' command"))
+	}
+	count := 1
+	if len(args) == 2 {
+		var reply resp.Value
+		var ok bool
+		if count, reply, ok = integerArg(args[1]); !ok {
+			return reply
+		}
+		if count < 0 {
+			return errorValue([]byte("value is out of range, must be positive"))
+		}
+	}
+	list := store.db(client).List(args[0].Buffer)
+	pop := list.PopBack
+	if command == "LPOP" {
+		pop = list.PopFront
+	}
+	values, err := pop(count)
+	if err != nil {
+		return structuredError(err)
+	}
+	if len(args) == 2 {
+		if values == nil {
+			// The list does not exist (a count of 0 on an existing list returns an empty array)
+			return resp.Value{Type: resp.ValueTypeNull}
+		}
+		return bulkArray(values)
+	}
+	if len(values) == 0 {
+		return resp.Value{Type: resp.ValueTypeNull}
+	}
+	return resp.Value{Type: resp.ValueTypeBulkString, Buffer: values[0]}
+}
+
+// LLEN key returns the length of the list
+func handleLLen(args []resp.Value, store *KVStore, client *Client) resp.Value {
+	if len(args) != 1 {
+		return errorValue([]byte("wrong number of arguments for 'LLEN' command"))
+	}
+	n, err := store.db(client).List(args[0].Buffer).Len()
+	if err != nil {
+		return structuredError(err)
+	}
+	return resp.Value{Type: resp.ValueTypeInteger, Integer: int64(n)}
+}
+
+// LRANGE key start stop returns the values from start to stop (both included), negative indexes are from the end of the
+// list
+func handleLRange(args []resp.Value, store *KVStore, client *Client) resp.Value {
+	if len(args) != 3 {
+		return errorValue([]byte("wrong number of arguments for 'LRANGE' command"))
+	}
+	start, reply, ok := integerArg(args[1])
+	if !ok {
+		return reply
+	}
+	stop, reply, ok := integerArg(args[2])
+	if !ok {
+		return reply
+	}
+	values, err := store.db(client).List(args[0].Buffer).Range(start, stop)
+	if err != nil {
+		return structuredError(err)
+	}
+	return bulkArray(values)
+}
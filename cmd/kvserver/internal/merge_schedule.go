@@ -0,0 +1,154 @@
+package internal
+
+import (
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+Merge schedule
+
+The background merge runs every MergeInterval, which can be at the busiest time of the day. Two settings keep it away
+from peak traffic:
+
+  - MergeWindow (-merge-window 02:00-05:00) only lets the background merge start between the two times of the day, in
+    the local time zone of the server. A window whose end is before it's start wraps around midnight (22:00-04:00)
+  - MergeMaxWriteRate (-merge-max-write-rate) defers the background merge while the store is written (SET and DEL) more
+    than this many times per second, measured since the last time the merge was due
+
+A merge that's deferred is tried again after mergeRetryInterval (or MergeInterval if it's shorter), until it runs, so a
+merge that's due outside the window runs soon after the window opens. A merge that has started is not stopped when the
+window closes. COMPACT is not affected, and INFO persistence reports the number of deferred merges. Both settings can
+be changed by reloading the config file (see config.go)
+*/
+
+// mergeRetryInterval is the time after which a deferred background merge is tried again
+const mergeRetryInterval = time.Minute
+
+// MergeWindow is a time of the day between Start and End (durations since midnight), the zero value is the whole day
+type MergeWindow struct {
+	Start, End time.Duration
+}
+
+// ParseMergeWindow parses a window written as HH:MM-HH:MM, an empty string is the whole day
+func ParseMergeWindow(value string) (MergeWindow, error) {
+	if value == "" {
+		return MergeWindow{}, nil
+	}
+	start, end, ok := strings.Cut(value, "-")
+	if !ok {
+		return MergeWindow{}, fmt.Errorf("invalid merge window '%s', expected HH:MM-HH:MM", value)
+	}
+	var window MergeWindow
+	var err error
+	if window.Start, err = parseTimeOfDay(start); err != nil {
+		return MergeWindow{}, err
+	}
+	if window.End, err = parseTimeOfDay(end); err != nil {
+		return MergeWindow{}, err
+	}
+	if window.Start == window.End {
+		return MergeWindow{}, fmt.Errorf("invalid merge window '%s', the start and end are the same", value)
+	}
+	return window, nil
+}
+
+func parseTimeOfDay(value string) (time.Duration, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(value))
+	if err != nil {
+		return 0, fmt.Errorf("invalid time of the day '%s', expected HH:MM", value)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// Contains returns true if the time of the day of t is in the window
+func (w MergeWindow) Contains(t time.Time) bool {
+	if w == (MergeWindow{}) {
+		return true
+	}
+	hour, minute, second := t.Clock()
+	now := time.Duration(hour)*time.Hour + time.Duration(minute)*time.Minute + time.Duration(second)*time.Second
+	if w.Start < w.End {
+		return now >= w.Start && now < w.End
+	}
+	return now >= w.Start || now < w.End
+}
+
+func (w MergeWindow) String() string {
+	if w == (MergeWindow{}) {
+		return ""
+	}
+	format := func(d time.Duration) string {
+		return fmt.Sprintf("%02d:%02d", int(d/time.Hour), int(d%time.Hour/time.Minute))
+	}
+	return format(w.Start) + "-" + format(w.End)
+}
+
+// mergeSchedule is the merge window and write rate limit of the background merge, with the number of writes the last
+// time the merge was due (to measure the write rate)
+type mergeSchedule struct {
+	mu           sync.Mutex
+	window       MergeWindow
+	maxWriteRate float64
+	lastWrites   uint64
+	lastSample   time.Time
+	deferred     uint64
+}
+
+// SetMergeWindow sets the time of the day during which the background merge can start
+func (kv *KVStore) SetMergeWindow(window MergeWindow) {
+	kv.mergeSchedule.mu.Lock()
+	defer kv.mergeSchedule.mu.Unlock()
+	kv.mergeSchedule.window = window
+}
+
+// SetMergeMaxWriteRate sets the number of writes per second above which the background merge is deferred, 0 for no
+// limit
+func (kv *KVStore) SetMergeMaxWriteRate(rate float64) {
+	kv.mergeSchedule.mu.Lock()
+	defer kv.mergeSchedule.mu.Unlock()
+	kv.mergeSchedule.maxWriteRate = rate
+}
+
+// DeferredMerges returns the number of times the background merge was deferred
+func (kv *KVStore) DeferredMerges() uint64 {
+	kv.mergeSchedule.mu.Lock()
+	defer kv.mergeSchedule.mu.Unlock()
+	return kv.mergeSchedule.deferred
+}
+
+// shouldMerge is called when the background merge is due at now, it returns false (and logs why) if it's deferred
+func (kv *KVStore) shouldMerge(now time.Time) bool {
+	var writes uint64
+	if stats, err := kv.Store.Stats(); err == nil {
+		writes = stats.Puts + stats.Deletes
+	}
+	schedule := &kv.mergeSchedule
+	schedule.mu.Lock()
+	defer schedule.mu.Unlock()
+	// The counts of the datastore start when it's opened, with the server
+	if schedule.lastSample.IsZero() {
+		schedule.lastSample = kv.StartTime
+	}
+	var rate float64
+	if elapsed := now.Sub(schedule.lastSample).Seconds(); elapsed > 0 {
+		rate = float64(writes-schedule.lastWrites) / elapsed
+	}
+	schedule.lastWrites, schedule.lastSample = writes, now
+
+	if !schedule.window.Contains(now) {
+		schedule.deferred++
+		slog.Info("background merge deferred, outside of the merge window", "window", schedule.window)
+		return false
+	}
+	if schedule.maxWriteRate > 0 && rate > schedule.maxWriteRate {
+		schedule.deferred++
+		slog.Info("background merge deferred, the write rate is too high", "writes_per_second", rate,
+			"max", schedule.maxWriteRate)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,71 @@
+package internal
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestMergeWindow(t *testing.T) {
+	day := time.Date(2024, 1, 1, 0, 0, 0, 0, time.Local)
+	tests := []struct {
+		window string
+		// Times of the day in the window, and outside of it
+		in, out []time.Duration
+	}{
+		{"", []time.Duration{0, 12 * time.Hour}, nil},
+		{"02:00-05:00", []time.Duration{2 * time.Hour, 4*time.Hour + 59*time.Minute}, []time.Duration{time.Hour, 5 * time.Hour}},
+		{"22:00-04:30", []time.Duration{23 * time.Hour, time.Hour}, []time.Duration{12 * time.Hour, 4*time.Hour + 30*time.Minute}},
+	}
+	for _, tt := range tests {
+		window, err := ParseMergeWindow(tt.window)
+		if err != nil {
+			t.Fatalf("ParseMergeWindow(%q) failed: %v", tt.window, err)
+		}
+		if window.String() != tt.window {
+			t.Errorf("expected %q, got %q", tt.window, window.String())
+		}
+		for _, d := range tt.in {
+			if !window.Contains(day.Add(d)) {
+				t.Errorf("%q: expected %s to be in the window", tt.window, d)
+			}
+		}
+		for _, d := range tt.out {
+			if window.Contains(day.Add(d)) {
+				t.Errorf("%q: expected %s to be outside of the window", tt.window, d)
+			}
+		}
+	}
+	for _, value := range []string{"02:00", "2am-5am", "25:00-03:00", "03:00-03:00"} {
+		if _, err := ParseMergeWindow(value); err == nil {
+			t.Errorf("ParseMergeWindow(%q): expected an error", value)
+		}
+	}
+}
+
+func TestShouldMerge(t *testing.T) {
+	store := helperMemoryStore(t)
+	now := store.StartTime.Add(time.Second)
+	window, _ := ParseMergeWindow(fmt.Sprintf("%02d:00-%02d:00", (now.Hour()+1)%24, (now.Hour()+2)%24))
+	store.SetMergeWindow(window)
+	if store.shouldMerge(now) {
+		t.Errorf("expected the merge to be deferred outside of the window")
+	}
+	store.SetMergeWindow(MergeWindow{})
+
+	store.SetMergeMaxWriteRate(10)
+	for i := range 100 {
+		store.Store.Put(fmt.Appendf(nil, "key%d", i), []byte("value"))
+	}
+	now = now.Add(time.Second)
+	if store.shouldMerge(now) {
+		t.Errorf("expected the merge to be deferred while the write rate is above the limit")
+	}
+	now = now.Add(time.Second)
+	if !store.shouldMerge(now) {
+		t.Errorf("expected the merge to run once the writes stopped")
+	}
+	if n := store.DeferredMerges(); n != 2 {
+		t.Errorf("expected 2 deferred merges, got %d", n)
+	}
+}
@@ -0,0 +1,154 @@
+package internal
+
+import (
+	"errors"
+	"io"
+	"log/slog"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/ananthvk/kvdb"
+	"github.com/ananthvk/kvdb/internal/resp"
+	"github.com/spf13/afero"
+)
+
+/*
+Replication of merges by file shipping
+
+When the primary merges it's datastore, the sealed files written by the merge are announced to the replicas with a
+backlog entry
+
+	MERGE <repl offset> <file id> ...
+
+A replica downloads each file with REPLFILE <file id> <offset> (on a separate connection, in chunks of at most
+replFileChunkSize bytes, an empty chunk marks the end of the file), and adopts it with AdoptOptions.OnlyMatching. The
+replica writes every record with the timestamp of the primary's record, so a record in the merged file with the same
+timestamp as the replica's current value is the same version of the value: the key is moved to the adopted file, and the
+replica's own copy is reclaimed by it's next merge without being rewritten. Keys that were written again or deleted are
+not changed, so a file can be adopted at any time after it's announced, and the download does not hold up the stream of
+records. If a file can't be downloaded (for example, because it was merged again on the primary), it's skipped, the
+replica already has all of it's data from the records
+*/
+
+// Maximum number of bytes of a file sent in reply to a REPLFILE command
+const replFileChunkSize = 512 * 1024
+
+// appendMerge adds a MERGE entry for the files written by a merge to the backlog, and makes them available to
+// REPLFILE. It's called by the store after every merge
+func (b *ReplicationBacklog) appendMerge(event kvdb.MergeEvent) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, id := range event.RemovedFileIds {
+		delete(b.files, id)
+	}
+	b.offset++
+	fields := [][]byte{[]byte("MERGE"), strconv.AppendInt(nil, b.offset, 10)}
+	for _, path := range event.Files {
+		id, err := strconv.Atoi(strings.TrimSuffix(filepath.Base(path), filepath.Ext(path)))
+		if err != nil {
+			slog.Warn("not shipping merged file", "path", path, "error", err)
+			continue
+		}
+		b.files[id] = path
+		fields = append(fields, strconv.AppendInt(nil, int64(id), 10))
+	}
+	entry := bulkStringArray(fields...)
+	b.entries[b.offset%replBacklogSize] = entry
+	for client := range b.replicas {
+		client.tryPush(entry)
+	}
+}
+
+// filePath returns the path of a file written by a merge, false is returned if the file is not known or was merged again
+func (b *ReplicationBacklog) filePath(id int) (string, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	path, ok := b.files[id]
+	return path, ok
+}
+
+func handleReplFile(args []resp.Value, store *KVStore, client *Client) resp.Value {
+	if len(args) != 2 {
+		return errorValue([]byte("wrong number of arguments for 'REPLFILE' command"))
+	}
+	id, err1 := strconv.Atoi(string(args[0].Buffer))
+	offset, err2 := strconv.ParseInt(string(args[1].Buffer), 10, 64)
+	if err1 != nil || err2 != nil || offset < 0 {
+		return errorValue([]byte("value is not an integer or out of range"))
+	}
+	path, ok := store.Replication.filePath(id)
+	if !ok {
+		return errorValue([]byte("no such merged file"))
+	}
+	file, err := store.fs.Open(path)
+	if err != nil {
+		return resp.Value{
+			Type:              resp.ValueTypeSimpleError,
+			SimpleErrorPrefix: []byte("INTERNAL_ERR"),
+			Buffer:            []byte(err.Error()),
+		}
+	}
+	defer file.Close()
+	chunk := make([]byte, replFileChunkSize)
+	n, err := file.ReadAt(chunk, offset)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return resp.Value{
+			Type:              resp.ValueTypeSimpleError,
+			SimpleErrorPrefix: []byte("INTERNAL_ERR"),
+			Buffer:            []byte(err.Error()),
+		}
+	}
+	return resp.Value{Type: resp.ValueTypeBulkString, Buffer: chunk[:n]}
+}
+
+// installMergedFiles downloads the files announced by a MERGE entry from the primary, and adopts them. Files are
+// installed one MERGE entry at a time, in the order in which the entries were received
+func (kv *KVStore) installMergedFiles(link *replicaLink, ids []int) {
+	link.installMu.Lock()
+	defer link.installMu.Unlock()
+	for _, id := range ids {
+		if err := kv.installMergedFile(link, id); err != nil {
+			slog.Warn("could not install merged file from primary", "primary", link.primaryAddr, "file_id", id, "error", err)
+			continue
+		}
+		link.mu.Lock()
+		link.installedFiles++
+		link.mu.Unlock()
+	}
+}
+
+func (kv *KVStore) installMergedFile(link *replicaLink, id int) error {
+	conn, err := kv.dialPrimary(link.ctx, link.primaryAddr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	temp, err := afero.TempFile(kv.fs, "", "kvdb-replfile-")
+	if err != nil {
+		return err
+	}
+	defer kv.fs.Remove(temp.Name())
+	var offset int64
+	for {
+		reply, err := conn.command("REPLFILE", strconv.Itoa(id), strconv.FormatInt(offset, 10))
+		if err != nil {
+			temp.Close()
+			return err
+		}
+		if len(reply.Buffer) == 0 {
+			break
+		}
+		if _, err := temp.Write(reply.Buffer); err != nil {
+			temp.Close()
+			return err
+		}
+		offset += int64(len(reply.Buffer))
+	}
+	if err := temp.Close(); err != nil {
+		return err
+	}
+	// The file is validated before it's adopted, so a file that changed while it was downloaded is rejected
+	return kv.Store.AdoptFileWithOptions(temp.Name(), &kvdb.AdoptOptions{OnlyMatching: true})
+}
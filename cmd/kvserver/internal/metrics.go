@@ -0,0 +1,105 @@
+package internal
+
+import (
+	"bytes"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"runtime"
+)
+
+/*
+Metrics endpoint
+
+With -metrics-addr, the server also listens for HTTP on that address and serves the memory usage and the main
+statistics of INFO at /metrics, in the Prometheus text format, so that they can be scraped and graphed for capacity
+planning instead of polling INFO. The calls and latencies of every command are labelled with the command, see
+command_stats.go
+*/
+
+type metric struct {
+	name  string
+	help  string
+	kind  string
+	value float64
+}
+
+// writeMetrics writes the current metrics of the store in the Prometheus text format
+func (kv *KVStore) writeMetrics(buf *bytes.Buffer) error {
+	stats, err := kv.Store.Stats()
+	if err != nil {
+		return err
+	}
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	_, offset, _ := kv.Replication.Info()
+	replicas := kv.Replication.Replicas()
+	var maxLagRecords, maxLagBytes int64
+	for _, replica := range replicas {
+		maxLagRecords = max(maxLagRecords, replica.LagRecords)
+		maxLagBytes = max(maxLagBytes, replica.LagBytes)
+	}
+	metrics := []metric{
+		{"kvdb_keys", "Number of keys in the datastore", "gauge", float64(stats.Keys)},
+		{"kvdb_data_files", "Number of data files", "gauge", float64(stats.DataFiles)},
+		{"kvdb_data_file_bytes", "Total size of the data files", "gauge", float64(stats.DataFileBytes)},
+		{"kvdb_reclaimable_bytes", "Bytes of stale records and tombstones that a merge would free", "gauge", float64(stats.ReclaimableBytes)},
+		{"kvdb_memory_keydir_bytes", "Estimated memory used by the keydir", "gauge", float64(stats.KeydirMemoryBytes)},
+		{"kvdb_memory_cache_bytes", "Estimated memory used by the reader cache and the sorted key snapshot", "gauge", float64(stats.CacheMemoryBytes)},
+		{"kvdb_memory_clients_bytes", "Memory used by client buffers and queued replies", "gauge", float64(kv.ClientMemoryUsage())},
+		{"kvdb_reader_cache_entries", "Number of open readers in the reader cache", "gauge", float64(stats.OpenReaders)},
+		{"kvdb_open_files", "Number of files the datastore has open", "gauge", float64(stats.OpenFiles)},
+		{"kvdb_reader_evictions_total", "Number of readers closed to stay within the open file budget", "counter", float64(stats.ReaderEvictions)},
+		{"kvdb_connected_clients", "Number of connected clients", "gauge", float64(kv.ConnectedClients())},
+		{"kvdb_commands_processed_total", "Number of commands processed", "counter", float64(kv.totalCommands.Load())},
+		{"kvdb_merges_total", "Number of merges since the server started", "counter", float64(stats.Merges)},
+		{"kvdb_connected_replicas", "Number of replicas connected to this server", "gauge", float64(len(replicas))},
+		{"kvdb_replication_offset", "Replication offset of this server", "gauge", float64(offset)},
+		{"kvdb_replication_max_lag_records", "Most entries queued for a connected replica", "gauge", float64(maxLagRecords)},
+		{"kvdb_replication_max_lag_bytes", "Most bytes queued for a connected replica", "gauge", float64(maxLagBytes)},
+		{"go_memstats_heap_alloc_bytes", "Bytes of allocated heap objects", "gauge", float64(m.HeapAlloc)},
+		{"go_memstats_sys_bytes", "Bytes of memory obtained from the OS", "gauge", float64(m.Sys)},
+		{"go_memstats_stack_inuse_bytes", "Bytes in stack spans", "gauge", float64(m.StackInuse)},
+		{"go_gc_cycles_total", "Number of completed GC cycles", "counter", float64(m.NumGC)},
+		{"go_goroutines", "Number of goroutines", "gauge", float64(runtime.NumGoroutine())},
+	}
+	if appliedOffset, lag, ok := kv.replicaProgress(); ok {
+		metrics = append(metrics,
+			metric{"kvdb_replication_applied_offset", "Offset of the primary applied by this replica", "gauge", float64(appliedOffset)},
+			metric{"kvdb_replication_lag_seconds", "Age of the newest state of the primary applied by this replica", "gauge", lag.Seconds()},
+		)
+	}
+	for _, metric := range metrics {
+		fmt.Fprintf(buf, "# HELP %s %s\n", metric.name, metric.help)
+		fmt.Fprintf(buf, "# TYPE %s %s\n", metric.name, metric.kind)
+		fmt.Fprintf(buf, "%s %g\n", metric.name, metric.value)
+	}
+	writeCommandMetrics(buf, kv)
+	return nil
+}
+
+// MetricsHandler returns the handler that serves /metrics
+func (kv *KVStore) MetricsHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /metrics", func(w http.ResponseWriter, r *http.Request) {
+		var buf bytes.Buffer
+		if err := kv.writeMetrics(&buf); err != nil {
+			slog.Error("could not collect metrics", "error", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		w.Write(buf.Bytes())
+	})
+	return mux
+}
+
+// StartMetricsServer serves the metrics endpoint on addr in the background
+func (kv *KVStore) StartMetricsServer(addr string) {
+	go func() {
+		slog.Info("metrics endpoint listening", "address", addr)
+		if err := http.ListenAndServe(addr, kv.MetricsHandler()); err != nil {
+			slog.Error("metrics endpoint failed", "error", err)
+		}
+	}()
+}
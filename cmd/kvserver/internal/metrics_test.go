@@ -0,0 +1,65 @@
+package internal
+
+import (
+	"io"
+	"net"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/ananthvk/kvdb/internal/resp"
+)
+
+func helperMemoryStore(t *testing.T) *KVStore {
+	t.Helper()
+	store := NewKVStore(":memory")
+	if store == nil {
+		t.Fatalf("could not create in-memory store")
+	}
+	t.Cleanup(func() { store.Close() })
+	return store
+}
+
+func TestMetricsEndpoint(t *testing.T) {
+	store := helperMemoryStore(t)
+	store.Store.Put([]byte("key1"), []byte("value1"))
+
+	server := httptest.NewServer(store.MetricsHandler())
+	defer server.Close()
+	res, err := server.Client().Get(server.URL + "/metrics")
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	defer res.Body.Close()
+	body, _ := io.ReadAll(res.Body)
+	for _, want := range []string{"# TYPE kvdb_keys gauge\nkvdb_keys 1\n", "kvdb_memory_keydir_bytes ", "kvdb_memory_cache_bytes ", "kvdb_memory_clients_bytes 0\n"} {
+		if !strings.Contains(string(body), want) {
+			t.Errorf("expected the metrics to contain %q, got:\n%s", want, body)
+		}
+	}
+}
+
+func TestClientMemoryUsage(t *testing.T) {
+	store := helperMemoryStore(t)
+	conn, peer := net.Pipe()
+	defer conn.Close()
+	defer peer.Close()
+
+	client := NewClient(conn, "")
+	store.registerClient(client)
+	if usage := store.ClientMemoryUsage(); usage != 2*clientBufferSize {
+		t.Errorf("expected the read and write buffers to be counted, got %d", usage)
+	}
+
+	// Queued transaction arguments are counted until the transaction ends
+	handleMulti(nil, store, client)
+	queueCommand(client, "SET", "SET", handleSet, []resp.Value{{Buffer: []byte("key")}, {Buffer: []byte("value")}})
+	if usage := store.ClientMemoryUsage(); usage != 2*clientBufferSize+8 {
+		t.Errorf("expected the queued arguments to be counted, got %d", usage)
+	}
+	handleDiscard(nil, store, client)
+	store.unregisterClient(client)
+	if usage := store.ClientMemoryUsage(); usage != 0 {
+		t.Errorf("expected no usage after the client is removed, got %d", usage)
+	}
+}
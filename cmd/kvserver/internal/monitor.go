@@ -0,0 +1,79 @@
+package internal
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ananthvk/kvdb/internal/resp"
+)
+
+/*
+MONITOR
+
+A client that sends MONITOR gets every command that's run by the other clients, as a simple string in the format of
+Redis:
+
+	+1700000000.123456 [0 127.0.0.1:53412] "SET" "key" "value"
+
+i.e. the time at which the command was received, the address of the client, and the quoted command and arguments. Only
+the commands that are run (or queued in a transaction) are sent, not the ones rejected before that (unknown commands,
+NOAUTH, NOPERM, READONLY), and the arguments of AUTH are not sent.
+
+The lines are sent through the push queue of the monitoring client (like published messages, see pubsub.go), so a
+monitor that does not read fast enough is disconnected instead of slowing down the other clients. A monitoring client
+can still run commands, it does not get it's own commands
+*/
+
+// handleMonitor starts sending the commands of the other clients to the client
+func handleMonitor(args []resp.Value, store *KVStore, client *Client) resp.Value {
+	if len(args) != 0 {
+		return errorValue([]byte("wrong number of arguments for 'MONITOR' command"))
+	}
+	store.monitorsMu.Lock()
+	defer store.monitorsMu.Unlock()
+	if !store.monitors[client] {
+		client.startPushQueue()
+		store.monitors[client] = true
+		store.monitorCount.Add(1)
+	}
+	return resp.Value{Type: resp.ValueTypeSimpleString, Buffer: []byte("OK")}
+}
+
+// removeMonitor stops sending commands to the client, if it's a monitor
+func (kv *KVStore) removeMonitor(client *Client) {
+	kv.monitorsMu.Lock()
+	defer kv.monitorsMu.Unlock()
+	if kv.monitors[client] {
+		delete(kv.monitors, client)
+		kv.monitorCount.Add(-1)
+	}
+}
+
+// feedMonitors sends the command (name and arguments) received at the given time from the client to the monitors
+func (kv *KVStore) feedMonitors(received time.Time, client *Client, name []byte, args []resp.Value) {
+	if kv.monitorCount.Load() == 0 {
+		return
+	}
+	var line strings.Builder
+	fmt.Fprintf(&line, "%d.%06d [0 %s]", received.Unix(), received.Nanosecond()/1000, client.Conn.RemoteAddr())
+	line.WriteString(" " + strconv.Quote(string(name)))
+	if bytes.EqualFold(name, []byte("AUTH")) {
+		line.WriteString(` "(redacted)"`)
+	} else {
+		for _, arg := range args {
+			line.WriteString(" " + strconv.Quote(string(arg.Buffer)))
+		}
+	}
+	value := resp.Value{Type: resp.ValueTypeSimpleString, Buffer: []byte(line.String())}
+
+	kv.monitorsMu.Lock()
+	defer kv.monitorsMu.Unlock()
+	for monitor := range kv.monitors {
+		if monitor != client {
+			monitor.tryPush(value)
+		}
+	}
+}
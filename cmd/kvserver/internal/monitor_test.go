@@ -0,0 +1,55 @@
+package internal
+
+import (
+	"bufio"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/ananthvk/kvdb/internal/resp"
+)
+
+func TestMonitor(t *testing.T) {
+	store := helperMemoryStore(t)
+	address := helperServe(t, store)
+	monitorConn, err := net.Dial("tcp", address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer monitorConn.Close()
+	monitorReader := bufio.NewReader(monitorConn)
+	if reply := helperCommand(t, monitorConn, monitorReader, "MONITOR"); string(reply.Buffer) != "OK" {
+		t.Fatalf("MONITOR: expected OK, got %+v", reply)
+	}
+
+	conn, err := net.Dial("tcp", address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	helperCommand(t, conn, reader, "SET", "key", "a \"quoted\"\r\nvalue")
+	helperCommand(t, conn, reader, "NOSUCHCOMMAND")
+	helperCommand(t, conn, reader, "AUTH", "secret")
+	helperCommand(t, conn, reader, "get", "key")
+
+	for _, want := range []string{`"SET" "key" "a \"quoted\"\r\nvalue"`, `"AUTH" "(redacted)"`, `"get" "key"`} {
+		line, err := resp.Deserialize(monitorReader)
+		if err != nil {
+			t.Fatalf("could not read from the monitor: %v", err)
+		}
+		if line.Type != resp.ValueTypeSimpleString || !strings.HasSuffix(string(line.Buffer), "] "+want) {
+			t.Errorf("expected a line ending with %s, got %+v", want, line)
+		}
+		if !strings.Contains(string(line.Buffer), conn.LocalAddr().String()) {
+			t.Errorf("expected the address of the client in %s", line.Buffer)
+		}
+	}
+
+	// A monitor still runs it's own commands, which are not sent to it
+	if reply := helperCommand(t, monitorConn, monitorReader, "PING"); string(reply.Buffer) != "PONG" {
+		t.Errorf("PING: expected PONG from the monitor, got %+v", reply)
+	}
+	monitorConn.Close()
+	waitFor(t, "the monitor to be removed", func() bool { return store.monitorCount.Load() == 0 })
+}
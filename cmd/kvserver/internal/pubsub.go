@@ -0,0 +1,248 @@
+package internal
+
+import (
+	"sync"
+
+	"github.com/ananthvk/kvdb"
+	"github.com/ananthvk/kvdb/internal/resp"
+)
+
+// Keyspace notification classes, set with -notify-keyspace-events (a subset of the flags supported by Redis)
+const (
+	notifyKeyspace = 1 << iota // K: publish to __keyspace@0__:<key>, the message is the event name
+	notifyKeyevent             // E: publish to __keyevent@0__:<event>, the message is the key
+	notifyGeneric              // g: generic commands (del)
+	notifyString               // $: string commands (set)
+)
+
+// PubSub keeps track of the channels and patterns that clients are subscribed to, and delivers published messages
+type PubSub struct {
+	mu       sync.RWMutex
+	channels map[string]map[*Client]bool
+	patterns map[string]map[*Client]bool
+}
+
+func NewPubSub() *PubSub {
+	return &PubSub{
+		channels: map[string]map[*Client]bool{},
+		patterns: map[string]map[*Client]bool{},
+	}
+}
+
+func subscriptionReply(kind string, name []byte, count int) resp.Value {
+	nameValue := resp.Value{Type: resp.ValueTypeNull}
+	if name != nil {
+		nameValue = resp.Value{Type: resp.ValueTypeBulkString, Buffer: name}
+	}
+	return resp.Value{
+		Type: resp.ValueTypeArray,
+		Array: []resp.Value{
+			{Type: resp.ValueTypeBulkString, Buffer: []byte(kind)},
+			nameValue,
+			{Type: resp.ValueTypeInteger, Integer: int64(count)},
+		},
+	}
+}
+
+// subscribe adds the client to the channel (or pattern), and queues the confirmation. It must be called from the
+// client's goroutine
+func (p *PubSub) subscribe(client *Client, name string, isPattern bool) {
+	client.startPushQueue()
+	p.mu.Lock()
+	subscriptions, clientSubscriptions, kind := p.channels, &client.channels, "subscribe"
+	if isPattern {
+		subscriptions, clientSubscriptions, kind = p.patterns, &client.patterns, "psubscribe"
+	}
+	if subscriptions[name] == nil {
+		subscriptions[name] = map[*Client]bool{}
+	}
+	subscriptions[name][client] = true
+	if *clientSubscriptions == nil {
+		*clientSubscriptions = map[string]bool{}
+	}
+	(*clientSubscriptions)[name] = true
+	count := len(client.channels) + len(client.patterns)
+	// Queue the confirmation while holding the lock, so that it's delivered before any message on the channel
+	client.tryPush(subscriptionReply(kind, []byte(name), count))
+	p.mu.Unlock()
+}
+
+// unsubscribe removes the client from the given channels (or patterns), or from all of them if names is empty.
+// It must be called from the client's goroutine
+func (p *PubSub) unsubscribe(client *Client, names []string, isPattern bool, sendReply bool) {
+	var replies []resp.Value
+	defer func() {
+		// Replies are sent after releasing the lock, since sending can block on a slow client
+		for _, reply := range replies {
+			client.Send(reply)
+		}
+	}()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	subscriptions, clientSubscriptions, kind := p.channels, client.channels, "unsubscribe"
+	if isPattern {
+		subscriptions, clientSubscriptions, kind = p.patterns, client.patterns, "punsubscribe"
+	}
+	if len(names) == 0 {
+		for name := range clientSubscriptions {
+			names = append(names, name)
+		}
+		if len(names) == 0 && sendReply {
+			replies = append(replies, subscriptionReply(kind, nil, len(client.channels)+len(client.patterns)))
+			return
+		}
+	}
+	for _, name := range names {
+		delete(clientSubscriptions, name)
+		if clients := subscriptions[name]; clients != nil {
+			delete(clients, client)
+			if len(clients) == 0 {
+				delete(subscriptions, name)
+			}
+		}
+		if sendReply {
+			replies = append(replies, subscriptionReply(kind, []byte(name), len(client.channels)+len(client.patterns)))
+		}
+	}
+}
+
+// UnsubscribeAll removes all subscriptions of the client, it's called when the client disconnects
+func (p *PubSub) UnsubscribeAll(client *Client) {
+	p.unsubscribe(client, nil, false, false)
+	p.unsubscribe(client, nil, true, false)
+}
+
+// Publish sends the message to all clients subscribed to the channel, or to a pattern matching the channel.
+// It returns the number of clients that received the message. It never blocks on a client
+func (p *PubSub) Publish(channel []byte, message []byte) int {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	receivers := 0
+	for client := range p.channels[string(channel)] {
+		client.tryPush(resp.Value{
+			Type: resp.ValueTypeArray,
+			Array: []resp.Value{
+				{Type: resp.ValueTypeBulkString, Buffer: []byte("message")},
+				{Type: resp.ValueTypeBulkString, Buffer: channel},
+				{Type: resp.ValueTypeBulkString, Buffer: message},
+			},
+		})
+		receivers++
+	}
+	for pattern, clients := range p.patterns {
+		if !matchGlob([]byte(pattern), channel) {
+			continue
+		}
+		for client := range clients {
+			client.tryPush(resp.Value{
+				Type: resp.ValueTypeArray,
+				Array: []resp.Value{
+					{Type: resp.ValueTypeBulkString, Buffer: []byte("pmessage")},
+					{Type: resp.ValueTypeBulkString, Buffer: []byte(pattern)},
+					{Type: resp.ValueTypeBulkString, Buffer: channel},
+					{Type: resp.ValueTypeBulkString, Buffer: message},
+				},
+			})
+			receivers++
+		}
+	}
+	return receivers
+}
+
+// ParseKeyspaceEvents parses the value of -notify-keyspace-events, it returns false if the value contains an unknown flag
+func ParseKeyspaceEvents(value string) (int, bool) {
+	flags := 0
+	for _, c := range value {
+		switch c {
+		case 'K':
+			flags |= notifyKeyspace
+		case 'E':
+			flags |= notifyKeyevent
+		case 'g':
+			flags |= notifyGeneric
+		case '$':
+			flags |= notifyString
+		case 'A':
+			flags |= notifyGeneric | notifyString
+		default:
+			return 0, false
+		}
+	}
+	return flags, true
+}
+
+// EnableKeyspaceNotifications publishes keyspace events for writes to the store, flags are parsed by ParseKeyspaceEvents.
+// Notifications are not enabled if neither K nor E is present
+func (kv *KVStore) EnableKeyspaceNotifications(flags int) {
+	if flags&(notifyKeyspace|notifyKeyevent) == 0 {
+		return
+	}
+	kv.Store.Watch(func(event kvdb.WatchEvent) {
+		var name string
+		switch {
+		case event.Type == kvdb.WriteTypePut && flags&notifyString != 0:
+			name = "set"
+		case event.Type == kvdb.WriteTypeDelete && flags&notifyGeneric != 0:
+			name = "del"
+		default:
+			return
+		}
+		if flags&notifyKeyspace != 0 {
+			kv.PubSub.Publish(append([]byte("__keyspace@0__:"), event.Key...), []byte(name))
+		}
+		if flags&notifyKeyevent != 0 {
+			// The key is only valid during the callback, and the message may be written later
+			kv.PubSub.Publish([]byte("__keyevent@0__:"+name), append([]byte(nil), event.Key...))
+		}
+	})
+}
+
+func handleSubscribe(args []resp.Value, store *KVStore, client *Client) resp.Value {
+	return subscribeCommand(args, store, client, false)
+}
+
+func handlePSubscribe(args []resp.Value, store *KVStore, client *Client) resp.Value {
+	return subscribeCommand(args, store, client, true)
+}
+
+func subscribeCommand(args []resp.Value, store *KVStore, client *Client, isPattern bool) resp.Value {
+	if len(args) == 0 {
+		name := "SUBSCRIBE"
+		if isPattern {
+			name = "PSUBSCRIBE"
+		}
+		return errorValue([]byte("wrong number of arguments for '" + name + "' command"))
+	}
+	for _, arg := range args {
+		store.PubSub.subscribe(client, string(arg.Buffer), isPattern)
+	}
+	return resp.Value{Type: valueTypeNoReply}
+}
+
+func handleUnsubscribe(args []resp.Value, store *KVStore, client *Client) resp.Value {
+	return unsubscribeCommand(args, store, client, false)
+}
+
+func handlePUnsubscribe(args []resp.Value, store *KVStore, client *Client) resp.Value {
+	return unsubscribeCommand(args, store, client, true)
+}
+
+func unsubscribeCommand(args []resp.Value, store *KVStore, client *Client, isPattern bool) resp.Value {
+	names := make([]string, len(args))
+	for i, arg := range args {
+		names[i] = string(arg.Buffer)
+	}
+	store.PubSub.unsubscribe(client, names, isPattern, true)
+	return resp.Value{Type: valueTypeNoReply}
+}
+
+// PUBLISH channel message
+func handlePublish(args []resp.Value, store *KVStore, client *Client) resp.Value {
+	if len(args) != 2 {
+		return errorValue([]byte("wrong number of arguments for 'PUBLISH' command"))
+	}
+	return resp.Value{
+		Type:    resp.ValueTypeInteger,
+		Integer: int64(store.PubSub.Publish(args[0].Buffer, args[1].Buffer)),
+	}
+}
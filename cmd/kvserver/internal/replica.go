@@ -0,0 +1,421 @@
+package internal
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ananthvk/kvdb"
+	"github.com/ananthvk/kvdb/internal/resp"
+)
+
+// Time to wait before reconnecting to the primary after the link breaks
+const replicaReconnectInterval = time.Second
+
+// replicaLink is the connection of a replica to it's primary. It's run in a goroutine that reconnects (continuing from
+// the last applied record if possible) until it's stopped
+type replicaLink struct {
+	primaryAddr string
+	ctx         context.Context
+	cancel      context.CancelFunc
+	done        chan struct{}
+	linkUp      atomic.Bool
+
+	// Progress of the replica, protected by mu
+	mu             sync.Mutex
+	primaryId      string
+	primaryOffset  int64
+	lastFileId     int
+	lastFileOffset int64
+	syncedKeys     uint64
+	installedFiles uint64
+	// Newest time of the primary that was applied (from a heartbeat or a record), and the time the last entry was
+	// received, see replication_lag.go
+	primaryTime time.Time
+	lastIO      time.Time
+
+	// Held while files merged on the primary are installed, see merge_shipping.go
+	installMu sync.Mutex
+}
+
+// IsReplica returns true if the server is replicating from a primary
+func (kv *KVStore) IsReplica() bool {
+	kv.replicaMu.Lock()
+	defer kv.replicaMu.Unlock()
+	return kv.replica != nil
+}
+
+// ReplicaOf makes the server a replica of the primary at address (host:port). If the server is already a replica, the
+// current link is stopped first. An empty address stops replication, and the server continues as a primary with it's
+// current data
+func (kv *KVStore) ReplicaOf(address string) {
+	kv.replicaMu.Lock()
+	defer kv.replicaMu.Unlock()
+	if kv.replica != nil {
+		kv.replica.cancel()
+		<-kv.replica.done
+		kv.replica = nil
+		slog.Info("replication stopped")
+	}
+	if address == "" {
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	link := &replicaLink{
+		primaryAddr:   address,
+		ctx:           ctx,
+		cancel:        cancel,
+		done:          make(chan struct{}),
+		primaryId:     "?",
+		primaryOffset: -1,
+	}
+	kv.replica = link
+	go func() {
+		defer close(link.done)
+		for {
+			err := kv.runReplicaLink(ctx, link)
+			link.linkUp.Store(false)
+			if ctx.Err() != nil {
+				return
+			}
+			slog.Warn("replication link broken", "primary", link.primaryAddr, "error", err)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(replicaReconnectInterval):
+			}
+		}
+	}()
+}
+
+// primaryConn is a connection to the primary, on which commands are sent by the replica
+type primaryConn struct {
+	net.Conn
+	reader *bufio.Reader
+	writer *bufio.Writer
+}
+
+// dialPrimary connects to the primary, and authenticates if PrimaryAuth is set. The connection is closed when ctx is
+// cancelled
+func (kv *KVStore) dialPrimary(ctx context.Context, address string) (*primaryConn, error) {
+	dialer := net.Dialer{Timeout: 5 * time.Second}
+	conn, err := dialer.DialContext(ctx, "tcp", address)
+	if err != nil {
+		return nil, err
+	}
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	c := &primaryConn{
+		Conn:   &stopOnClose{Conn: conn, stop: stop},
+		reader: bufio.NewReaderSize(conn, clientBufferSize),
+		writer: bufio.NewWriterSize(conn, clientBufferSize),
+	}
+	if kv.PrimaryAuth != "" {
+		if _, err := c.command("AUTH", kv.PrimaryAuth); err != nil {
+			c.Close()
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// stopOnClose unregisters the context callback that closes the connection, when it's closed
+type stopOnClose struct {
+	net.Conn
+	stop func() bool
+}
+
+func (c *stopOnClose) Close() error {
+	c.stop()
+	return c.Conn.Close()
+}
+
+// command sends a command to the primary and returns it's reply, an error reply is returned as an error
+func (c *primaryConn) command(args ...string) (resp.Value, error) {
+	fields := make([][]byte, len(args))
+	for i, arg := range args {
+		fields[i] = []byte(arg)
+	}
+	if err := sendResponse(bulkStringArray(fields...), c.writer); err != nil {
+		return resp.Value{}, err
+	}
+	reply, err := resp.Deserialize(c.reader)
+	if err != nil {
+		return resp.Value{}, err
+	}
+	if reply.Type == resp.ValueTypeSimpleError {
+		return resp.Value{}, fmt.Errorf("%s %s", reply.SimpleErrorPrefix, reply.Buffer)
+	}
+	return reply, nil
+}
+
+// runReplicaLink connects to the primary, syncs with it and applies records until the connection fails or ctx is cancelled
+func (kv *KVStore) runReplicaLink(ctx context.Context, link *replicaLink) error {
+	conn, err := kv.dialPrimary(ctx, link.primaryAddr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	reader := conn.reader
+
+	link.mu.Lock()
+	primaryId, primaryOffset := link.primaryId, link.primaryOffset
+	link.mu.Unlock()
+	reply, err := conn.command("SYNC", primaryId, strconv.FormatInt(primaryOffset, 10))
+	if err != nil {
+		return err
+	}
+	fields := strings.Fields(string(reply.Buffer))
+	switch {
+	case len(fields) == 2 && fields[0] == "CONTINUE":
+		slog.Info("replication continuing", "primary", link.primaryAddr, "offset", primaryOffset)
+	case len(fields) == 3 && fields[0] == "FULLSYNC":
+		offset, err := strconv.ParseInt(fields[2], 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid FULLSYNC reply: %q", reply.Buffer)
+		}
+		slog.Info("replication full sync started", "primary", link.primaryAddr)
+		count, err := kv.applySnapshot(reader)
+		if err != nil {
+			return err
+		}
+		link.mu.Lock()
+		link.primaryId, link.primaryOffset = fields[1], offset
+		link.syncedKeys = count
+		link.mu.Unlock()
+		slog.Info("replication full sync finished", "primary", link.primaryAddr, "keys", count)
+	default:
+		return fmt.Errorf("unexpected SYNC reply: %q", reply.Buffer)
+	}
+	link.mu.Lock()
+	link.lastIO = time.Now()
+	link.mu.Unlock()
+	link.linkUp.Store(true)
+
+	for {
+		value, err := resp.Deserialize(reader)
+		if err != nil {
+			return err
+		}
+		if err := kv.applyEntry(link, value); err != nil {
+			return err
+		}
+	}
+}
+
+// applySnapshot reads the SNAPSHOT entries sent during a full sync, and replaces the contents of the store with them
+func (kv *KVStore) applySnapshot(reader *bufio.Reader) (uint64, error) {
+	received := map[string]bool{}
+	for {
+		value, err := resp.Deserialize(reader)
+		if err != nil {
+			return 0, err
+		}
+		fields, err := bulkStrings(value)
+		if err != nil {
+			return 0, err
+		}
+		if len(fields) == 2 && string(fields[0]) == "SNAPSHOT.END" {
+			break
+		}
+		if len(fields) != 3 && len(fields) != 4 || string(fields[0]) != "SNAPSHOT" {
+			return 0, fmt.Errorf("unexpected entry during full sync: %q", fields[0])
+		}
+		opts := &kvdb.PutOptions{}
+		if len(fields) == 4 {
+			if opts.ValueType, err = parseValueType(fields[3]); err != nil {
+				return 0, err
+			}
+		}
+		kv.commandLock.RLock()
+		err = kv.Store.PutWithOptions(fields[1], fields[2], opts)
+		kv.commandLock.RUnlock()
+		if err != nil {
+			return 0, err
+		}
+		received[string(fields[1])] = true
+	}
+
+	// Remove the keys that are not present on the primary
+	keys, err := kv.Store.ListKeys()
+	if err != nil {
+		return 0, err
+	}
+	for _, key := range keys {
+		if received[key] {
+			continue
+		}
+		kv.commandLock.RLock()
+		err = kv.Store.Delete([]byte(key))
+		kv.commandLock.RUnlock()
+		if err != nil {
+			return 0, err
+		}
+	}
+	return uint64(len(received)), nil
+}
+
+// applyEntry applies a RECORD or MERGE entry streamed by the primary
+func (kv *KVStore) applyEntry(link *replicaLink, value resp.Value) error {
+	fields, err := bulkStrings(value)
+	if err != nil {
+		return err
+	}
+	if len(fields) >= 2 && string(fields[0]) == "MERGE" {
+		return kv.applyMerge(link, fields)
+	}
+	if string(fields[0]) == "REPLPING" {
+		return link.applyHeartbeat(fields)
+	}
+	if len(fields) < 7 || string(fields[0]) != "RECORD" {
+		return errors.New("invalid record from primary")
+	}
+	offset, err1 := strconv.ParseInt(string(fields[1]), 10, 64)
+	fileId, err2 := strconv.Atoi(string(fields[2]))
+	fileOffset, err3 := strconv.ParseInt(string(fields[3]), 10, 64)
+	timestamp, err4 := strconv.ParseInt(string(fields[4]), 10, 64)
+	if err := errors.Join(err1, err2, err3, err4); err != nil {
+		return fmt.Errorf("invalid record from primary: %w", err)
+	}
+
+	// Hold the command lock, so that records are not applied in the middle of a transaction. Records keep the
+	// primary's timestamp, so that files merged on the primary can be adopted
+	ts := time.UnixMicro(timestamp)
+	kv.commandLock.RLock()
+	switch {
+	case string(fields[5]) == "SET" && len(fields) == 8:
+		err = kv.Store.PutWithTimestamp(fields[6], fields[7], ts)
+	case string(fields[5]) == "SET" && len(fields) == 9:
+		var t kvdb.ValueType
+		if t, err = parseValueType(fields[8]); err == nil {
+			err = kv.Store.PutWithOptions(fields[6], fields[7], &kvdb.PutOptions{ValueType: t, Timestamp: ts})
+		}
+	case string(fields[5]) == "DEL" && len(fields) == 7:
+		err = kv.Store.DeleteWithTimestamp(fields[6], ts)
+	default:
+		err = fmt.Errorf("invalid record type %q from primary", fields[5])
+	}
+	kv.commandLock.RUnlock()
+	if err != nil {
+		return err
+	}
+
+	link.mu.Lock()
+	link.primaryOffset = offset
+	link.lastFileId = fileId
+	link.lastFileOffset = fileOffset
+	link.primaryTime = latest(link.primaryTime, ts)
+	link.lastIO = time.Now()
+	link.mu.Unlock()
+	return nil
+}
+
+// applyMerge starts installing the files announced by a MERGE entry, in the background
+func (kv *KVStore) applyMerge(link *replicaLink, fields [][]byte) error {
+	offset, err := strconv.ParseInt(string(fields[1]), 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid merge from primary: %w", err)
+	}
+	ids := make([]int, 0, len(fields)-2)
+	for _, field := range fields[2:] {
+		id, err := strconv.Atoi(string(field))
+		if err != nil {
+			return fmt.Errorf("invalid merge from primary: %w", err)
+		}
+		ids = append(ids, id)
+	}
+	go kv.installMergedFiles(link, ids)
+
+	link.mu.Lock()
+	link.primaryOffset = offset
+	link.lastIO = time.Now()
+	link.mu.Unlock()
+	return nil
+}
+
+func bulkStrings(value resp.Value) ([][]byte, error) {
+	if value.Type != resp.ValueTypeArray || len(value.Array) == 0 {
+		return nil, errors.New("expected an array of bulk strings from primary")
+	}
+	fields := make([][]byte, len(value.Array))
+	for i, v := range value.Array {
+		if v.Type != resp.ValueTypeBulkString {
+			return nil, errors.New("expected an array of bulk strings from primary")
+		}
+		fields[i] = v.Buffer
+	}
+	return fields, nil
+}
+
+func handleReplicaOf(args []resp.Value, store *KVStore, client *Client) resp.Value {
+	if len(args) != 2 {
+		return errorValue([]byte("wrong number of arguments for 'REPLICAOF' command"))
+	}
+	host, port := string(args[0].Buffer), string(args[1].Buffer)
+	if strings.EqualFold(host, "NO") && strings.EqualFold(port, "ONE") {
+		store.ReplicaOf("")
+	} else {
+		if _, err := strconv.ParseUint(port, 10, 16); err != nil {
+			return errorValue([]byte("Invalid master port"))
+		}
+		store.StandbyOf("")
+		store.ReplicaOf(net.JoinHostPort(host, port))
+	}
+	return resp.Value{
+		Type:   resp.ValueTypeSimpleString,
+		Buffer: []byte{'O', 'K'},
+	}
+}
+
+// writeInfoReplication writes the replication section of INFO
+func writeInfoReplication(buf *bytes.Buffer, store *KVStore) error {
+	id, offset, replicas := store.Replication.Info()
+	store.replicaMu.Lock()
+	link := store.replica
+	store.replicaMu.Unlock()
+	store.standbyMu.Lock()
+	standby := store.standby
+	store.standbyMu.Unlock()
+	if standby != nil {
+		writeInfoStandby(buf, standby)
+	} else if link == nil {
+		fmt.Fprintf(buf, "role:primary\r\n")
+	} else {
+		link.mu.Lock()
+		fmt.Fprintf(buf, "role:replica\r\n")
+		fmt.Fprintf(buf, "primary_address:%s\r\n", link.primaryAddr)
+		if link.linkUp.Load() {
+			fmt.Fprintf(buf, "primary_link_status:up\r\n")
+		} else {
+			fmt.Fprintf(buf, "primary_link_status:down\r\n")
+		}
+		fmt.Fprintf(buf, "primary_repl_id:%s\r\n", link.primaryId)
+		fmt.Fprintf(buf, "primary_repl_offset:%d\r\n", link.primaryOffset)
+		fmt.Fprintf(buf, "primary_last_file_id:%d\r\n", link.lastFileId)
+		fmt.Fprintf(buf, "primary_last_file_offset:%d\r\n", link.lastFileOffset)
+		lastIO := int64(-1)
+		if !link.lastIO.IsZero() {
+			lastIO = int64(time.Since(link.lastIO).Seconds())
+		}
+		fmt.Fprintf(buf, "primary_last_io_seconds_ago:%d\r\n", lastIO)
+		fmt.Fprintf(buf, "replication_lag_ms:%d\r\n", link.lag(time.Now()).Milliseconds())
+		fmt.Fprintf(buf, "full_sync_keys:%d\r\n", link.syncedKeys)
+		fmt.Fprintf(buf, "installed_merge_files:%d\r\n", link.installedFiles)
+		link.mu.Unlock()
+	}
+	fmt.Fprintf(buf, "connected_replicas:%d\r\n", replicas)
+	for i, replica := range store.Replication.Replicas() {
+		fmt.Fprintf(buf, "replica%d:addr=%s,offset=%d,lag_records=%d,lag_bytes=%d\r\n", i, replica.Address, replica.Offset, replica.LagRecords, replica.LagBytes)
+	}
+	fmt.Fprintf(buf, "repl_id:%s\r\n", id)
+	fmt.Fprintf(buf, "repl_offset:%d\r\n", offset)
+	return nil
+}
@@ -0,0 +1,267 @@
+package internal
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"sync"
+
+	"github.com/ananthvk/kvdb"
+	"github.com/ananthvk/kvdb/internal/resp"
+)
+
+/*
+Primary / replica replication
+
+Every write applied to the store (reported by the store's Watch API) is assigned an increasing replication offset, and
+is appended to an in-memory backlog (a ring buffer of the last replBacklogSize records). The records are streamed to
+all connected replicas as
+
+	RECORD <repl offset> <file id> <file offset> <timestamp> SET <key> <value> [<type>]
+	RECORD <repl offset> <file id> <file offset> <timestamp> DEL <key>
+
+where file id and file offset are the position of the record in the primary's data files, and timestamp is the record's
+timestamp in microseconds since the Unix epoch, which the replica keeps. The type (hash, list or set) is only sent for
+structured values, whose value is their encoding, see kvdb.GetWithType. Merges are replicated by shipping the merged
+files, see merge_shipping.go.
+
+A replica connects to the primary like a normal client and issues SYNC <replication id> <offset>, where the id and
+offset are the last ones it has seen (? and -1 if it has never synced). If the id matches, and all records after offset
+are still in the backlog, the primary replies with +CONTINUE <id> and sends the missing records (incremental catch-up).
+Otherwise the primary replies with +FULLSYNC <id> <offset> and sends every key in the store as
+
+	SNAPSHOT <key> <value> [<type>]
+
+followed by SNAPSHOT.END <number of keys>, and the records written after offset. Keys are read while writes continue,
+so a snapshot entry may already contain a value that is written again by a later record, replaying the records in order
+makes the replica converge to the primary's state. After this, the connection only carries RECORD (and MERGE) entries.
+*/
+
+// Number of records kept in the backlog for replicas that reconnect
+const replBacklogSize = 16384
+
+// ReplicationBacklog holds the records that are streamed to replicas, and the replicas that are connected
+type ReplicationBacklog struct {
+	mu sync.Mutex
+	// Random id of this replication stream, offsets are only meaningful for the same id
+	id string
+	// Offset of the last record, the first record has offset 1
+	offset   int64
+	entries  []resp.Value
+	replicas map[*Client]bool
+	// Paths of the files written by merges that can be downloaded by replicas, indexed by their id
+	files map[int]string
+}
+
+// NewReplicationBacklog returns a backlog with a new random replication id
+func NewReplicationBacklog() *ReplicationBacklog {
+	id := make([]byte, 20)
+	rand.Read(id)
+	return &ReplicationBacklog{
+		id:       hex.EncodeToString(id),
+		entries:  make([]resp.Value, replBacklogSize),
+		replicas: map[*Client]bool{},
+		files:    map[int]string{},
+	}
+}
+
+// Info returns the replication id, the current offset and the number of connected replicas
+func (b *ReplicationBacklog) Info() (string, int64, int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.id, b.offset, len(b.replicas)
+}
+
+// append adds the write to the backlog and sends it to all replicas, it's called by the store for every write
+func (b *ReplicationBacklog) append(event kvdb.WatchEvent) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.offset++
+	entry := recordValue(b.offset, event)
+	b.entries[b.offset%replBacklogSize] = entry
+	for client := range b.replicas {
+		client.tryPush(entry)
+	}
+}
+
+// hasEntriesAfter returns true if all the records after offset are still in the backlog. The caller must hold the lock
+func (b *ReplicationBacklog) hasEntriesAfter(offset int64) bool {
+	return offset >= 0 && offset <= b.offset && b.offset-offset <= replBacklogSize
+}
+
+// entriesAfter returns a copy of the records after offset, false is returned if some of them are no longer in the
+// backlog. The caller must hold the lock
+func (b *ReplicationBacklog) entriesAfter(offset int64) ([]resp.Value, bool) {
+	if !b.hasEntriesAfter(offset) {
+		return nil, false
+	}
+	entries := make([]resp.Value, 0, b.offset-offset)
+	for i := offset + 1; i <= b.offset; i++ {
+		entries = append(entries, b.entries[i%replBacklogSize])
+	}
+	return entries, true
+}
+
+// isReplica returns true if records are streamed to the client
+func (b *ReplicationBacklog) isReplica(client *Client) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.replicas[client]
+}
+
+// removeReplica stops streaming records to the client
+func (b *ReplicationBacklog) removeReplica(client *Client) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.replicas, client)
+}
+
+func recordValue(offset int64, event kvdb.WatchEvent) resp.Value {
+	fields := [][]byte{
+		[]byte("RECORD"),
+		strconv.AppendInt(nil, offset, 10),
+		strconv.AppendInt(nil, int64(event.FileId), 10),
+		strconv.AppendInt(nil, event.Offset, 10),
+		strconv.AppendInt(nil, event.Timestamp.UnixMicro(), 10),
+	}
+	// Key and value are only valid during the watch call
+	if event.Type == kvdb.WriteTypePut {
+		fields = append(fields, []byte("SET"), bytes.Clone(event.Key), bytes.Clone(event.Value))
+		if event.ValueType != kvdb.ValueTypeString {
+			fields = append(fields, []byte(event.ValueType.String()))
+		}
+	} else {
+		fields = append(fields, []byte("DEL"), bytes.Clone(event.Key))
+	}
+	return bulkStringArray(fields...)
+}
+
+// parseValueType returns the type of a value with the given name, as sent after structured values
+func parseValueType(name []byte) (kvdb.ValueType, error) {
+	for _, t := range []kvdb.ValueType{kvdb.ValueTypeHash, kvdb.ValueTypeList, kvdb.ValueTypeSet} {
+		if string(name) == t.String() {
+			return t, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown value type %q", name)
+}
+
+func bulkStringArray(fields ...[]byte) resp.Value {
+	array := make([]resp.Value, len(fields))
+	for i, field := range fields {
+		array[i] = resp.Value{Type: resp.ValueTypeBulkString, Buffer: field}
+	}
+	return resp.Value{Type: resp.ValueTypeArray, Array: array}
+}
+
+func handleSync(args []resp.Value, store *KVStore, client *Client) resp.Value {
+	if len(args) != 2 {
+		return errorValue([]byte("wrong number of arguments for 'SYNC' command"))
+	}
+	offset, err := strconv.ParseInt(string(args[1].Buffer), 10, 64)
+	if err != nil {
+		return errorValue([]byte("value is not an integer or out of range"))
+	}
+	if client.isReplica {
+		return errorValue([]byte("SYNC already in progress"))
+	}
+	// From here on, the connection only carries replication data. Records are queued, so that they are sent in order
+	// with the snapshot
+	client.isReplica = true
+	client.startPushQueue()
+
+	b := store.Replication
+	b.mu.Lock()
+	canContinue := string(args[0].Buffer) == b.id && b.hasEntriesAfter(offset)
+	start := b.offset
+	b.mu.Unlock()
+
+	if canContinue {
+		slog.Info("replica continuing", "remote_address", client.Conn.RemoteAddr().String(), "offset", offset, "records", start-offset)
+		client.Send(resp.Value{Type: resp.ValueTypeSimpleString, Buffer: []byte("CONTINUE " + b.id)})
+		if !b.startStreaming(client, offset) {
+			slog.Error("replica catch-up failed", "remote_address", client.Conn.RemoteAddr().String())
+			client.Conn.Close()
+		}
+		return resp.Value{Type: valueTypeNoReply}
+	}
+
+	slog.Info("replica full sync started", "remote_address", client.Conn.RemoteAddr().String(), "offset", start)
+	client.Send(resp.Value{Type: resp.ValueTypeSimpleString, Buffer: fmt.Appendf(nil, "FULLSYNC %s %d", b.id, start)})
+	count, err := sendSnapshot(store.Store, client)
+	if err != nil {
+		slog.Error("replica full sync failed", "remote_address", client.Conn.RemoteAddr().String(), "error", err)
+		client.Conn.Close()
+		return resp.Value{Type: valueTypeNoReply}
+	}
+	client.Send(bulkStringArray([]byte("SNAPSHOT.END"), strconv.AppendInt(nil, int64(count), 10)))
+
+	if !b.startStreaming(client, start) {
+		// Too many writes happened during the snapshot, the replica has to retry
+		slog.Error("replica full sync failed, backlog overflowed", "remote_address", client.Conn.RemoteAddr().String())
+		client.Conn.Close()
+		return resp.Value{Type: valueTypeNoReply}
+	}
+	slog.Info("replica full sync finished", "remote_address", client.Conn.RemoteAddr().String(), "keys", count)
+	return resp.Value{Type: valueTypeNoReply}
+}
+
+// startStreaming sends the records after offset to the replica, and registers it to receive new records. Records are
+// sent without holding the lock, since Send blocks while the client's queue is full, and append is called with the
+// store's write lock held. So the records are sent in rounds, until no record was appended since the last round, and
+// the replica is registered in the same critical section as that check, so that no record is missed. Returns false if
+// the records the replica needs are no longer in the backlog, or could not be sent
+func (b *ReplicationBacklog) startStreaming(client *Client, offset int64) bool {
+	for {
+		b.mu.Lock()
+		entries, ok := b.entriesAfter(offset)
+		if ok && len(entries) == 0 {
+			b.replicas[client] = true
+		}
+		b.mu.Unlock()
+		if !ok {
+			return false
+		}
+		if len(entries) == 0 {
+			return true
+		}
+		for _, entry := range entries {
+			if err := client.Send(entry); err != nil {
+				return false
+			}
+		}
+		offset += int64(len(entries))
+	}
+}
+
+// sendSnapshot sends every key in the store to the replica, and returns the number of keys sent
+func sendSnapshot(store *kvdb.DataStore, client *Client) (int, error) {
+	keys, err := store.ListKeys()
+	if err != nil {
+		return 0, err
+	}
+	count := 0
+	for _, key := range keys {
+		value, t, err := store.GetWithType([]byte(key))
+		if err != nil {
+			if errors.Is(err, kvdb.ErrKeyNotFound) {
+				// Deleted after listing, the delete will be sent as a record
+				continue
+			}
+			return count, err
+		}
+		fields := [][]byte{[]byte("SNAPSHOT"), []byte(key), value}
+		if t != kvdb.ValueTypeString {
+			fields = append(fields, []byte(t.String()))
+		}
+		if err := client.Send(bulkStringArray(fields...)); err != nil {
+			return count, err
+		}
+		count++
+	}
+	return count, nil
+}
@@ -0,0 +1,131 @@
+package internal
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+)
+
+/*
+Replication lag
+
+The primary sends
+
+	REPLPING <repl offset> <timestamp>
+
+to every replica once every replHeartbeatInterval, where timestamp is the primary's clock in microseconds since the Unix
+epoch. It's sent in the same stream as the records (but is not part of the backlog, and does not take an offset), so
+when a replica reads it, it has applied every record up to the offset. The replica measures it's lag in time as the
+age of the newest state of the primary it has applied, i.e. the time since the timestamp of the last heartbeat (or of
+the last record, if it's newer). The clocks of the primary and the replica must roughly agree for it to be meaningful.
+
+The lag in records and bytes is measured on the primary, as the entries (and their bytes) that are queued for a
+replica but have not been written to it's connection yet. Bytes in the socket buffers are not counted
+*/
+
+// How often the primary sends a heartbeat to it's replicas
+const replHeartbeatInterval = time.Second
+
+// ReplicaLag is the lag of a replica connected to this server, as seen by the primary
+type ReplicaLag struct {
+	Address string
+	// Offset of the last entry written to the replica's connection
+	Offset int64
+	// Entries (and their bytes) queued for the replica that have not been written to it's connection yet
+	LagRecords int64
+	LagBytes   int64
+}
+
+// Replicas returns the lag of every connected replica
+func (b *ReplicationBacklog) Replicas() []ReplicaLag {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	lags := make([]ReplicaLag, 0, len(b.replicas))
+	for client := range b.replicas {
+		queued := int64(len(client.pushQueue))
+		lags = append(lags, ReplicaLag{
+			Address:    client.Conn.RemoteAddr().String(),
+			Offset:     b.offset - queued,
+			LagRecords: queued,
+			LagBytes:   client.queuedBytes.Load(),
+		})
+	}
+	return lags
+}
+
+// heartbeat sends a REPLPING with the current offset and time to every replica
+func (b *ReplicationBacklog) heartbeat(now time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if len(b.replicas) == 0 {
+		return
+	}
+	entry := bulkStringArray([]byte("REPLPING"), strconv.AppendInt(nil, b.offset, 10), strconv.AppendInt(nil, now.UnixMicro(), 10))
+	for client := range b.replicas {
+		client.tryPush(entry)
+	}
+}
+
+// StartReplicationHeartbeat sends heartbeats to the replicas of this server in the background, until it's shut down
+func (kv *KVStore) StartReplicationHeartbeat() {
+	go func() {
+		ticker := time.NewTicker(replHeartbeatInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-kv.done:
+				return
+			case now := <-ticker.C:
+				kv.Replication.heartbeat(now)
+			}
+		}
+	}()
+}
+
+// applyHeartbeat records the time of a REPLPING sent by the primary
+func (link *replicaLink) applyHeartbeat(fields [][]byte) error {
+	if len(fields) != 3 {
+		return fmt.Errorf("invalid heartbeat from primary")
+	}
+	offset, err1 := strconv.ParseInt(string(fields[1]), 10, 64)
+	timestamp, err2 := strconv.ParseInt(string(fields[2]), 10, 64)
+	if err1 != nil || err2 != nil {
+		return fmt.Errorf("invalid heartbeat from primary")
+	}
+	link.mu.Lock()
+	defer link.mu.Unlock()
+	link.primaryOffset = max(link.primaryOffset, offset)
+	link.primaryTime = latest(link.primaryTime, time.UnixMicro(timestamp))
+	link.lastIO = time.Now()
+	return nil
+}
+
+// lag returns the age of the newest state of the primary that was applied, 0 if nothing was received yet. The caller
+// must hold the lock
+func (link *replicaLink) lag(now time.Time) time.Duration {
+	if link.primaryTime.IsZero() {
+		return 0
+	}
+	return max(now.Sub(link.primaryTime), 0)
+}
+
+// replicaProgress returns the offset of the primary that was applied by this replica, and it's lag. ok is false if the
+// server is not a replica
+func (kv *KVStore) replicaProgress() (offset int64, lag time.Duration, ok bool) {
+	kv.replicaMu.Lock()
+	link := kv.replica
+	kv.replicaMu.Unlock()
+	if link == nil {
+		return 0, 0, false
+	}
+	link.mu.Lock()
+	defer link.mu.Unlock()
+	return link.primaryOffset, link.lag(time.Now()), true
+}
+
+func latest(a, b time.Time) time.Time {
+	if a.After(b) {
+		return a
+	}
+	return b
+}
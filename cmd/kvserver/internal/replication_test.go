@@ -0,0 +1,197 @@
+package internal
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ananthvk/kvdb"
+	"github.com/ananthvk/kvdb/internal/resp"
+)
+
+func TestStartStreamingDoesNotBlockWrites(t *testing.T) {
+	b := NewReplicationBacklog()
+	for range 2 * pushQueueSize {
+		b.append(kvdb.WatchEvent{Type: kvdb.WriteTypePut, Key: []byte("key"), Value: []byte("value")})
+	}
+
+	// Nothing reads from the replica's connection, so sending the backlog blocks once the queue is full
+	conn, peer := net.Pipe()
+	client := NewClient(conn, "")
+	client.startPushQueue()
+	streaming := make(chan bool)
+	go func() { streaming <- b.startStreaming(client, 0) }()
+
+	appended := make(chan struct{})
+	go func() {
+		b.append(kvdb.WatchEvent{Type: kvdb.WriteTypeDelete, Key: []byte("key")})
+		close(appended)
+	}()
+	select {
+	case <-appended:
+	case <-time.After(time.Second):
+		t.Fatalf("append blocked while a replica was catching up")
+	}
+
+	// Once the replica goes away, the remaining records fail to be written and streaming finishes
+	peer.Close()
+	select {
+	case <-streaming:
+	case <-time.After(time.Second):
+		t.Fatalf("streaming did not finish after the replica disconnected")
+	}
+	client.closePushQueue()
+}
+
+func TestStartStreamingRegistersReplica(t *testing.T) {
+	b := NewReplicationBacklog()
+	b.append(kvdb.WatchEvent{Type: kvdb.WriteTypePut, Key: []byte("key"), Value: []byte("value")})
+
+	conn, peer := net.Pipe()
+	defer peer.Close()
+	client := NewClient(conn, "")
+	client.startPushQueue()
+	defer client.closePushQueue()
+	defer conn.Close()
+
+	if !b.startStreaming(client, 0) {
+		t.Fatalf("expected streaming to start")
+	}
+	if _, _, replicas := b.Info(); replicas != 1 {
+		t.Errorf("expected the replica to be registered, got %d replicas", replicas)
+	}
+	if b.startStreaming(client, 5) {
+		t.Errorf("expected an offset after the backlog to be rejected")
+	}
+}
+
+// helperServe serves the store on a local port, and returns it's address
+func helperServe(t *testing.T, store *KVStore) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("could not listen: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go store.Handle(conn)
+		}
+	}()
+	return listener.Addr().String()
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestReplicaInstallsMergedFiles(t *testing.T) {
+	primary := helperMemoryStore(t)
+	address := helperServe(t, primary)
+	replica := helperMemoryStore(t)
+	replica.ReplicaOf(address)
+	waitFor(t, "the replication link", func() bool { return replica.replica.linkUp.Load() })
+
+	primary.Store.Put([]byte("key1"), []byte("value1"))
+	primary.Store.Put([]byte("key2"), []byte("value2"))
+	primary.Store.Delete([]byte("key2"))
+	waitFor(t, "the records to be applied", func() bool {
+		_, offset, _ := primary.Replication.Info()
+		replica.replica.mu.Lock()
+		defer replica.replica.mu.Unlock()
+		return replica.replica.primaryOffset == offset
+	})
+
+	// Ship the primary's data file as if it was written by a merge
+	path := filepath.Join(primary.Path, "data", "0000000001.dat")
+	primary.Replication.appendMerge(kvdb.MergeEvent{Files: []string{path}})
+	waitFor(t, "the merged file to be installed", func() bool {
+		replica.replica.mu.Lock()
+		defer replica.replica.mu.Unlock()
+		return replica.replica.installedFiles == 1
+	})
+
+	if val, err := replica.Store.Get([]byte("key1")); err != nil || string(val) != "value1" {
+		t.Errorf("expected value1, got %s (err: %v)", val, err)
+	}
+	if _, err := replica.Store.Get([]byte("key2")); !errors.Is(err, kvdb.ErrKeyNotFound) {
+		t.Errorf("expected key2 to stay deleted, got %v", err)
+	}
+	// Adopted files are rotated past, so the replica now has the adopted file and a new active file
+	stats, _ := replica.Store.Stats()
+	if stats.DataFiles < 2 {
+		t.Errorf("expected the merged file to be adopted, got %+v", stats)
+	}
+}
+
+func TestReplFileUnknownFile(t *testing.T) {
+	store := helperMemoryStore(t)
+	reply := handleReplFile([]resp.Value{{Buffer: []byte("1")}, {Buffer: []byte("0")}}, store, nil)
+	if reply.Type != resp.ValueTypeSimpleError {
+		t.Errorf("expected an error for a file that was not merged, got %+v", reply)
+	}
+}
+
+func TestReplicationLag(t *testing.T) {
+	primary := helperMemoryStore(t)
+	address := helperServe(t, primary)
+	replica := helperMemoryStore(t)
+	replica.ReplicaOf(address)
+	waitFor(t, "the replication link", func() bool { return replica.replica.linkUp.Load() })
+
+	// The lag is the age of the newest record or heartbeat that was applied
+	primary.Store.PutWithTimestamp([]byte("key"), []byte("value"), time.Now().Add(-time.Minute))
+	waitFor(t, "the record to be applied", func() bool {
+		applied, _, _ := replica.replicaProgress()
+		return applied == 1
+	})
+	if _, lag, _ := replica.replicaProgress(); lag < time.Minute {
+		t.Errorf("expected a lag of at least a minute, got %v", lag)
+	}
+	primary.Replication.heartbeat(time.Now())
+	waitFor(t, "the heartbeat", func() bool {
+		_, lag, _ := replica.replicaProgress()
+		return lag < time.Minute
+	})
+
+	info := string(handleInfo([]resp.Value{{Type: resp.ValueTypeBulkString, Buffer: []byte("replication")}}, replica, nil).Buffer)
+	for _, expected := range []string{"role:replica", "primary_repl_offset:1", "replication_lag_ms:", "primary_last_io_seconds_ago:0"} {
+		if !strings.Contains(info, expected) {
+			t.Errorf("expected %q in INFO replication, got %q", expected, info)
+		}
+	}
+	info = string(handleInfo([]resp.Value{{Type: resp.ValueTypeBulkString, Buffer: []byte("replication")}}, primary, nil).Buffer)
+	if !strings.Contains(info, "replica0:addr=") || !strings.Contains(info, ",offset=1,") {
+		t.Errorf("expected the replica in INFO replication, got %q", info)
+	}
+
+	var buf bytes.Buffer
+	if err := replica.writeMetrics(&buf); err != nil {
+		t.Fatalf("could not collect metrics: %v", err)
+	}
+	for _, expected := range []string{"kvdb_replication_applied_offset 1\n", "kvdb_replication_lag_seconds "} {
+		if !strings.Contains(buf.String(), expected) {
+			t.Errorf("expected %q in the metrics, got %q", expected, buf.String())
+		}
+	}
+	buf.Reset()
+	primary.writeMetrics(&buf)
+	if !strings.Contains(buf.String(), "kvdb_connected_replicas 1\n") {
+		t.Errorf("expected a connected replica in the metrics, got %q", buf.String())
+	}
+}
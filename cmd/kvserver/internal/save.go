@@ -0,0 +1,142 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/ananthvk/kvdb/internal/resp"
+)
+
+/*
+SAVE, BGSAVE and LASTSAVE
+
+Writes are appended to the active data file, and synced to disk every syncInterval (see StartBackgroundSync), so a crash
+of the machine can lose the last writes. The save commands let operators decide when the store is made durable:
+
+  - SAVE syncs the store (and the attached datastores, see databases.go), and writes a snapshot to a new directory in
+    BackupDir if it's set. Commands are not run while the store is saved, so the snapshot has the effect of every
+    command that was acknowledged before SAVE
+  - BGSAVE does the same in the background and replies right away. Commands keep running, except for the exclusive ones
+    (like EXEC and COMPACT), so a snapshot never has half of a transaction. Only one BGSAVE runs at a time
+  - LASTSAVE returns the unix time of the last successful save (the time the server started if there was none)
+
+The snapshots are named save-<time> and bgsave-<time>, they are not removed by the server, and standbys load them like
+the ones written by -backup-interval (see standby.go). INFO persistence reports whether a BGSAVE is running and the
+status of the last save. SHUTDOWN SAVE saves the store the same way before the server exits
+*/
+
+// saveState is the status of the saves of the server
+type saveState struct {
+	mu         sync.Mutex
+	inProgress bool
+	// lastSave is the time of the last successful save, lastErr is the error of the last save (nil if it succeeded)
+	lastSave time.Time
+	lastErr  error
+}
+
+// persist syncs the store, and writes a snapshot named after prefix to BackupDir if it's set. It returns the path of the
+// snapshot, or "" if none was written
+func (kv *KVStore) persist(prefix string) (string, error) {
+	path, err := kv.syncAndBackup(prefix)
+	kv.saves.mu.Lock()
+	defer kv.saves.mu.Unlock()
+	kv.saves.lastErr = err
+	if err == nil {
+		kv.saves.lastSave = time.Now()
+	}
+	return path, err
+}
+
+func (kv *KVStore) syncAndBackup(prefix string) (string, error) {
+	if err := errors.Join(kv.Store.Sync(), kv.syncAttached()); err != nil {
+		return "", fmt.Errorf("sync failed: %w", err)
+	}
+	if kv.BackupDir == "" {
+		return "", nil
+	}
+	path, err := kv.writeBackup(prefix)
+	if err != nil {
+		return "", fmt.Errorf("backup failed: %w", err)
+	}
+	slog.Info("backup written", "path", path)
+	return path, nil
+}
+
+// save saves the store while no commands are running
+func (kv *KVStore) save(prefix string) error {
+	kv.commandLock.Lock()
+	defer kv.commandLock.Unlock()
+	_, err := kv.persist(prefix)
+	return err
+}
+
+// BackgroundSave starts saving the store in the background, it returns false if a background save is already running
+func (kv *KVStore) BackgroundSave() bool {
+	kv.saves.mu.Lock()
+	defer kv.saves.mu.Unlock()
+	if kv.saves.inProgress {
+		return false
+	}
+	kv.saves.inProgress = true
+	go func() {
+		slog.Info("background save started")
+		// Exclusive commands do not run during the save, so the snapshot never has half of a transaction
+		kv.commandLock.RLock()
+		_, err := kv.persist("bgsave")
+		kv.commandLock.RUnlock()
+		slog.Info("background save finished", "err", err)
+		kv.saves.mu.Lock()
+		kv.saves.inProgress = false
+		kv.saves.mu.Unlock()
+	}()
+	return true
+}
+
+// LastSave returns the time of the last successful save, and the error of the last save (nil if it succeeded). The
+// time is the time the server started if the store was never saved
+func (kv *KVStore) LastSave() (time.Time, error) {
+	kv.saves.mu.Lock()
+	defer kv.saves.mu.Unlock()
+	if kv.saves.lastSave.IsZero() {
+		return kv.StartTime, kv.saves.lastErr
+	}
+	return kv.saves.lastSave, kv.saves.lastErr
+}
+
+// backgroundSaveInProgress returns true while a BGSAVE is running
+func (kv *KVStore) backgroundSaveInProgress() bool {
+	kv.saves.mu.Lock()
+	defer kv.saves.mu.Unlock()
+	return kv.saves.inProgress
+}
+
+func handleSave(args []resp.Value, store *KVStore, client *Client) resp.Value {
+	if len(args) != 0 {
+		return errorValue([]byte("wrong number of arguments for 'SAVE' command"))
+	}
+	if err := store.save("save"); err != nil {
+		return errorValue([]byte(err.Error()))
+	}
+	return resp.Value{Type: resp.ValueTypeSimpleString, Buffer: []byte("OK")}
+}
+
+func handleBackgroundSave(args []resp.Value, store *KVStore, client *Client) resp.Value {
+	if len(args) != 0 {
+		return errorValue([]byte("wrong number of arguments for 'BGSAVE' command"))
+	}
+	if !store.BackgroundSave() {
+		return errorValue([]byte("Background save already in progress"))
+	}
+	return resp.Value{Type: resp.ValueTypeSimpleString, Buffer: []byte("Background saving started")}
+}
+
+func handleLastSave(args []resp.Value, store *KVStore, client *Client) resp.Value {
+	if len(args) != 0 {
+		return errorValue([]byte("wrong number of arguments for 'LASTSAVE' command"))
+	}
+	lastSave, _ := store.LastSave()
+	return resp.Value{Type: resp.ValueTypeInteger, Integer: lastSave.Unix()}
+}
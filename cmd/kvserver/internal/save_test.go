@@ -0,0 +1,80 @@
+package internal
+
+import (
+	"bufio"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/ananthvk/kvdb/internal/resp"
+	"github.com/spf13/afero"
+)
+
+func TestSaveCommands(t *testing.T) {
+	store := helperMemoryStore(t)
+	store.BackupDir = "backups"
+	address := helperServe(t, store)
+	conn, err := net.Dial("tcp", address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+
+	if reply := helperCommand(t, conn, reader, "LASTSAVE"); reply.Integer != store.StartTime.Unix() {
+		t.Errorf("LASTSAVE: expected the start time before any save, got %+v", reply)
+	}
+	helperCommand(t, conn, reader, "SET", "key1", "value1")
+	if reply := helperCommand(t, conn, reader, "SAVE"); string(reply.Buffer) != "OK" {
+		t.Fatalf("SAVE: expected OK, got %+v", reply)
+	}
+	if reply := helperCommand(t, conn, reader, "BGSAVE"); string(reply.Buffer) != "Background saving started" {
+		t.Fatalf("BGSAVE: expected the save to start, got %+v", reply)
+	}
+	waitFor(t, "the background save", func() bool { return !store.backgroundSaveInProgress() })
+
+	backups, err := afero.ReadDir(store.fs, "backups")
+	if err != nil || len(backups) != 2 {
+		t.Fatalf("expected 2 backups, got %v, %v", backups, err)
+	}
+	for i, prefix := range []string{"bgsave-", "save-"} {
+		if !strings.HasPrefix(backups[i].Name(), prefix) {
+			t.Errorf("expected a backup named %s..., got %s", prefix, backups[i].Name())
+		}
+	}
+
+	lastSave, err := store.LastSave()
+	if err != nil || lastSave.Before(store.StartTime) {
+		t.Errorf("expected a successful save, got %v, %v", lastSave, err)
+	}
+	if reply := helperCommand(t, conn, reader, "LASTSAVE"); reply.Integer != lastSave.Unix() {
+		t.Errorf("LASTSAVE: expected %d, got %+v", lastSave.Unix(), reply)
+	}
+	info := helperCommand(t, conn, reader, "INFO", "persistence")
+	for _, want := range []string{"bgsave_in_progress:0", "last_save_status:ok", "last_save_time:" + strconv.FormatInt(lastSave.Unix(), 10)} {
+		if !strings.Contains(string(info.Buffer), want) {
+			t.Errorf("INFO: expected %s in:\n%s", want, info.Buffer)
+		}
+	}
+
+	if reply := helperCommand(t, conn, reader, "SAVE", "now"); reply.Type != resp.ValueTypeSimpleError {
+		t.Errorf("SAVE now: expected an error, got %+v", reply)
+	}
+}
+
+func TestSaveFailure(t *testing.T) {
+	store := newKVStore(failingMkdirFs{Fs: afero.NewMemMapFs(), dir: "backups"}, "test.db", nil)
+	if store == nil {
+		t.Fatalf("could not create store")
+	}
+	t.Cleanup(func() { store.Close() })
+	store.BackupDir = "backups"
+	if reply := handleSave(nil, store, nil); reply.Type != resp.ValueTypeSimpleError {
+		t.Fatalf("SAVE: expected an error, got %+v", reply)
+	}
+	lastSave, err := store.LastSave()
+	if err == nil || !lastSave.Equal(store.StartTime) {
+		t.Errorf("expected the failed save to be reported, got %v, %v", lastSave, err)
+	}
+}
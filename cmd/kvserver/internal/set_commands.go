@@ -0,0 +1,72 @@
+package internal
+
+import (
+	"github.com/ananthvk/kvdb/internal/resp"
+)
+
+// Set commands (SADD, SREM, SMEMBERS, SCARD, SISMEMBER), on the sets of the store (see DataStore.Set). Like in Redis, a
+// set that does not exist is empty, a set is deleted with it's last member, and the commands fail with WRONGTYPE on a
+// key that holds a value of another type
+
+// SADD key member [member ...] returns the number of members that were added
+func handleSAdd(args []resp.Value, store *KVStore, client *Client) resp.Value {
+	if len(args) < 2 {
+		return errorValue([]byte("wrong number of arguments for 'SADD' command"))
+	}
+	added, err := store.db(client).Set(args[0].Buffer).Add(buffers(args[1:])...)
+	if err != nil {
+		return structuredError(err)
+	}
+	return resp.Value{Type: resp.ValueTypeInteger, Integer: int64(added)}
+}
+
+// SREM key member [member ...] returns the number of members that were removed
+func handleSRem(args []resp.Value, store *KVStore, client *Client) resp.Value {
+	if len(args) < 2 {
+		return errorValue([]byte("wrong number of arguments for 'SREM' command"))
+	}
+	removed, err := store.db(client).Set(args[0].Buffer).Remove(buffers(args[1:])...)
+	if err != nil {
+		return structuredError(err)
+	}
+	return resp.Value{Type: resp.ValueTypeInteger, Integer: int64(removed)}
+}
+
+// SMEMBERS key returns the members of the set, in ascending order
+func handleSMembers(args []resp.Value, store *KVStore, client *Client) resp.Value {
+	if len(args) != 1 {
+		return errorValue([]byte("wrong number of arguments for 'SMEMBERS' command"))
+	}
+	members, err := store.db(client).Set(args[0].Buffer).Members()
+	if err != nil {
+		return structuredError(err)
+	}
+	return bulkArray(members)
+}
+
+// SCARD key returns the number of members of the set
+func handleSCard(args []resp.Value, store *KVStore, client *Client) resp.Value {
+	if len(args) != 1 {
+		return errorValue([]byte("wrong number of arguments for 'SCARD' command"))
+	}
+	n, err := store.db(client).Set(args[0].Buffer).Len()
+	if err != nil {
+		return structuredError(err)
+	}
+	return resp.Value{Type: resp.ValueTypeInteger, Integer: int64(n)}
+}
+
+// SISMEMBER key member returns 1 if the member is in the set, 0 otherwise
+func handleSIsMember(args []resp.Value, store *KVStore, client *Client) resp.Value {
+	if len(args) != 2 {
+		return errorValue([]byte("wrong number of arguments for 'SISMEMBER' command"))
+	}
+	ok, err := store.db(client).Set(args[0].Buffer).Contains(args[1].Buffer)
+	if err != nil {
+		return structuredError(err)
+	}
+	if ok {
+		return resp.Value{Type: resp.ValueTypeInteger, Integer: 1}
+	}
+	return resp.Value{Type: resp.ValueTypeInteger, Integer: 0}
+}
@@ -0,0 +1,108 @@
+package internal
+
+import (
+	"bytes"
+	"fmt"
+	"log/slog"
+
+	"github.com/ananthvk/kvdb/internal/resp"
+)
+
+/*
+SHUTDOWN [NOSAVE | SAVE]
+
+SHUTDOWN stops the server gracefully:
+
+ 1. With SAVE, the commands that are running finish, and the store is synced. If BackupDir is set, a snapshot of the
+    store is written to a new directory in it. If either fails, the shutdown is aborted, and the client gets the error
+ 2. The replication link to the primary (if this server is a replica) or the standby refreshes are stopped
+ 3. The datastore is closed, which waits for running operations and syncs the active data file. With NOSAVE the
+    datastore is not closed, so the writes since the last sync are only in the operating system's buffers, the same
+    as when the process is killed
+ 4. Done is closed, the server stops accepting connections and exits
+
+Like Redis, the client does not get a reply when the shutdown succeeds, the connection is closed when the process exits
+*/
+
+// ShutdownMode selects what SHUTDOWN saves before the server exits
+type ShutdownMode int
+
+const (
+	// ShutdownDefault closes the datastore, which syncs it
+	ShutdownDefault ShutdownMode = iota
+	// ShutdownSave also checks that the store can be synced, and writes a backup to BackupDir if it's set
+	ShutdownSave
+	// ShutdownNoSave exits without syncing or closing the datastore
+	ShutdownNoSave
+)
+
+func (m ShutdownMode) String() string {
+	switch m {
+	case ShutdownSave:
+		return "save"
+	case ShutdownNoSave:
+		return "nosave"
+	}
+	return "default"
+}
+
+// Done returns a channel that is closed once the server has been shut down with SHUTDOWN
+func (kv *KVStore) Done() <-chan struct{} {
+	return kv.done
+}
+
+// Shutdown runs the shutdown sequence, see SHUTDOWN. It returns an error (and the server keeps running) if the store
+// could not be saved with ShutdownSave
+func (kv *KVStore) Shutdown(mode ShutdownMode) error {
+	kv.shutdownMu.Lock()
+	defer kv.shutdownMu.Unlock()
+	select {
+	case <-kv.done:
+		return nil
+	default:
+	}
+	slog.Info("shutdown started", "mode", mode)
+
+	if mode == ShutdownSave {
+		if err := kv.save("shutdown"); err != nil {
+			slog.Error("shutdown aborted, the store could not be saved", "error", err)
+			return err
+		}
+	}
+	kv.ReplicaOf("")
+	kv.StandbyOf("")
+	if mode != ShutdownNoSave && kv.Store != nil {
+		slog.Info("closing store", "path", kv.Path)
+		if err := kv.closeAttached(); err != nil {
+			slog.Error("close of an attached datastore failed", "error", err)
+		}
+		if err := kv.Store.Close(); err != nil {
+			// The store rejects every operation once it's closing, so the server can't keep running
+			slog.Error("close failed", "error", err)
+		}
+	}
+	close(kv.done)
+	slog.Info("shutdown finished")
+	return nil
+}
+
+func handleShutdown(args []resp.Value, store *KVStore, client *Client) resp.Value {
+	mode := ShutdownDefault
+	if len(args) > 1 {
+		return errorValue([]byte("syntax error"))
+	}
+	if len(args) == 1 {
+		switch string(bytes.ToUpper(args[0].Buffer)) {
+		case "SAVE":
+			mode = ShutdownSave
+		case "NOSAVE":
+			mode = ShutdownNoSave
+		default:
+			return errorValue([]byte("syntax error"))
+		}
+	}
+	if err := store.Shutdown(mode); err != nil {
+		return errorValue(fmt.Appendf(nil, "Errors trying to SHUTDOWN: %s", err))
+	}
+	return resp.Value{Type: valueTypeNoReply}
+}
@@ -0,0 +1,126 @@
+package internal
+
+import (
+	"errors"
+	"net"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/ananthvk/kvdb"
+	"github.com/ananthvk/kvdb/internal/resp"
+	"github.com/spf13/afero"
+)
+
+func helperShutdown(t *testing.T, store *KVStore, args ...string) resp.Value {
+	t.Helper()
+	conn, peer := net.Pipe()
+	t.Cleanup(func() {
+		conn.Close()
+		peer.Close()
+	})
+	values := make([]resp.Value, len(args))
+	for i, arg := range args {
+		values[i] = resp.Value{Type: resp.ValueTypeBulkString, Buffer: []byte(arg)}
+	}
+	return handleShutdown(values, store, NewClient(conn, ""))
+}
+
+func isDone(store *KVStore) bool {
+	select {
+	case <-store.Done():
+		return true
+	default:
+		return false
+	}
+}
+
+func TestShutdownSyntax(t *testing.T) {
+	store := helperMemoryStore(t)
+	for _, args := range [][]string{{"NOW"}, {"SAVE", "NOSAVE"}} {
+		if reply := helperShutdown(t, store, args...); reply.Type != resp.ValueTypeSimpleError {
+			t.Errorf("%v: expected an error, got %+v", args, reply)
+		}
+	}
+	if isDone(store) {
+		t.Errorf("expected the server to keep running after a syntax error")
+	}
+}
+
+func TestShutdownSave(t *testing.T) {
+	store := helperMemoryStore(t)
+	store.BackupDir = "backups"
+	store.Store.Put([]byte("key1"), []byte("value1"))
+
+	if reply := helperShutdown(t, store, "save"); reply.Type != valueTypeNoReply {
+		t.Fatalf("expected no reply, got %+v", reply)
+	}
+	if !isDone(store) {
+		t.Fatalf("expected the server to be shut down")
+	}
+	if _, err := store.Store.Get([]byte("key1")); !errors.Is(err, kvdb.ErrClosed) {
+		t.Errorf("expected the store to be closed, got %v", err)
+	}
+
+	backups, err := afero.ReadDir(store.fs, "backups")
+	if err != nil || len(backups) != 1 {
+		t.Fatalf("expected a backup, got %v, %v", backups, err)
+	}
+	manifest, err := afero.ReadFile(store.fs, "backups/"+backups[0].Name()+"/MANIFEST")
+	if err != nil {
+		t.Fatalf("failed to read the backup manifest: %v", err)
+	}
+	if !strings.Contains(string(manifest), "key_count=1") {
+		t.Errorf("expected a backup with one key, got:\n%s", manifest)
+	}
+
+	// Later shutdowns do nothing
+	if reply := helperShutdown(t, store); reply.Type != valueTypeNoReply {
+		t.Errorf("expected no reply, got %+v", reply)
+	}
+}
+
+// failingMkdirFs fails to create directories inside dir
+type failingMkdirFs struct {
+	afero.Fs
+	dir string
+}
+
+func (fs failingMkdirFs) MkdirAll(path string, perm os.FileMode) error {
+	if strings.HasPrefix(path, fs.dir) {
+		return errors.New("mkdir failed")
+	}
+	return fs.Fs.MkdirAll(path, perm)
+}
+
+func TestShutdownSaveFailureAborts(t *testing.T) {
+	store := newKVStore(failingMkdirFs{Fs: afero.NewMemMapFs(), dir: "backups"}, "test.db", nil)
+	if store == nil {
+		t.Fatalf("could not create store")
+	}
+	t.Cleanup(func() { store.Close() })
+	store.BackupDir = "backups"
+	if reply := helperShutdown(t, store, "SAVE"); reply.Type != resp.ValueTypeSimpleError {
+		t.Fatalf("expected an error, got %+v", reply)
+	}
+	if isDone(store) {
+		t.Fatalf("expected the server to keep running")
+	}
+	if err := store.Store.Put([]byte("key1"), []byte("value1")); err != nil {
+		t.Errorf("expected the store to be usable, got %v", err)
+	}
+}
+
+func TestShutdownNoSave(t *testing.T) {
+	store := helperMemoryStore(t)
+	if reply := helperShutdown(t, store, "NOSAVE"); reply.Type != valueTypeNoReply {
+		t.Fatalf("expected no reply, got %+v", reply)
+	}
+	if !isDone(store) {
+		t.Fatalf("expected the server to be shut down")
+	}
+	// The store is left open, the process exits without syncing it
+	if err := store.Store.Put([]byte("key1"), []byte("value1")); err != nil {
+		t.Errorf("expected the store to be left open, got %v", err)
+	}
+}
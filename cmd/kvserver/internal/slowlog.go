@@ -0,0 +1,212 @@
+package internal
+
+import (
+	"bytes"
+	"fmt"
+	"slices"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ananthvk/kvdb/internal/resp"
+)
+
+/*
+Slow log
+
+Commands that take longer than a threshold (-slowlog-log-slower-than) to run are recorded in the slow log, a ring buffer
+of the last -slowlog-max-len entries. The time is the time the command function ran for, without the time spent
+reading the request, waiting for the command lock or sending the reply, so the log shows the commands that are slow
+themselves, like a COMPACT, or a GET of a large value. A transaction is logged as the EXEC that ran it.
+
+An entry has an id (incremented for every entry, also across RESET), the time at which the command started, how long it
+ran for, the command and it's arguments, and the address and user of the client. Arguments are truncated to
+slowLogMaxArgs arguments of slowLogMaxArgLen bytes, so that a SET of a large value does not keep it in memory, and the
+arguments of AUTH are not recorded.
+
+  - SLOWLOG GET [count] returns the last count entries (10 by default, all of them with -1), the newest first
+  - SLOWLOG LEN returns the number of entries
+  - SLOWLOG RESET removes every entry
+
+The replies have the same layout as Redis: every entry is an array of the id, the start time (a unix timestamp, in
+seconds), the duration (in microseconds), the arguments, the client's address and it's user
+*/
+
+const (
+	defaultSlowLogThreshold = 10 * time.Millisecond
+	defaultSlowLogMaxLen    = 128
+
+	// Arguments of an entry after the first slowLogMaxArgs are replaced by a count of the remaining arguments, and
+	// arguments longer than slowLogMaxArgLen are truncated
+	slowLogMaxArgs   = 32
+	slowLogMaxArgLen = 128
+)
+
+// SlowLogEntry is a command that took longer than the slow log threshold
+type SlowLogEntry struct {
+	ID       int64
+	Start    time.Time
+	Duration time.Duration
+	Args     []string
+	Client   string
+	User     string
+}
+
+// SlowLog keeps the last commands that were slower than a threshold, see slowlog.go
+type SlowLog struct {
+	// threshold is the minimum duration (a time.Duration) of a logged command, every command is logged if it's 0, and
+	// none if it's negative
+	threshold atomic.Int64
+
+	mu sync.Mutex
+	// entries is a ring buffer of at most maxLen entries, next is the position of the next entry once it's full
+	entries []SlowLogEntry
+	next    int
+	maxLen  int
+	nextID  int64
+}
+
+// NewSlowLog returns a slow log of the last maxLen commands that ran for at least threshold, a negative threshold
+// disables the log
+func NewSlowLog(threshold time.Duration, maxLen int) *SlowLog {
+	s := &SlowLog{maxLen: max(maxLen, 0)}
+	s.threshold.Store(int64(threshold))
+	return s
+}
+
+// SetThreshold changes the minimum duration of the commands that are logged, a negative threshold disables the log
+func (s *SlowLog) SetThreshold(threshold time.Duration) {
+	s.threshold.Store(int64(threshold))
+}
+
+// SetMaxLen changes the maximum number of entries, the oldest entries are removed if there are more
+func (s *SlowLog) SetMaxLen(maxLen int) {
+	maxLen = max(maxLen, 0)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// The entries are reordered from the oldest, so that the ring buffer starts at 0
+	entries := slices.Concat(s.entries[s.next:], s.entries[:s.next])
+	s.entries = entries[max(len(entries)-maxLen, 0):]
+	s.next = 0
+	s.maxLen = maxLen
+}
+
+// record logs the command (name and arguments) if it ran for at least the threshold
+func (s *SlowLog) record(start time.Time, duration time.Duration, name []byte, args []resp.Value, client *Client) {
+	if s == nil {
+		return
+	}
+	if threshold := time.Duration(s.threshold.Load()); threshold < 0 || duration < threshold {
+		return
+	}
+	entry := SlowLogEntry{Start: start, Duration: duration, Args: slowLogArgs(name, args)}
+	if client != nil {
+		entry.Client = client.Conn.RemoteAddr().String()
+		entry.User = client.User
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.maxLen == 0 {
+		return
+	}
+	entry.ID = s.nextID
+	s.nextID++
+	if len(s.entries) < s.maxLen {
+		s.entries = append(s.entries, entry)
+		return
+	}
+	s.entries[s.next] = entry
+	s.next = (s.next + 1) % s.maxLen
+}
+
+// slowLogArgs returns the truncated arguments of a command, see slowlog.go
+func slowLogArgs(name []byte, args []resp.Value) []string {
+	logged := []string{string(name)}
+	if bytes.EqualFold(name, []byte("AUTH")) {
+		return append(logged, "(redacted)")
+	}
+	for i, arg := range args {
+		if len(logged) == slowLogMaxArgs-1 && len(args)-i > 1 {
+			return append(logged, fmt.Sprintf("... (%d more arguments)", len(args)-i))
+		}
+		if len(arg.Buffer) > slowLogMaxArgLen {
+			more := len(arg.Buffer) - slowLogMaxArgLen
+			logged = append(logged, fmt.Sprintf("%s... (%d more bytes)", arg.Buffer[:slowLogMaxArgLen], more))
+			continue
+		}
+		logged = append(logged, string(arg.Buffer))
+	}
+	return logged
+}
+
+// Entries returns the last count entries (all of them if count is negative), the newest first
+func (s *SlowLog) Entries(count int) []SlowLogEntry {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if count < 0 || count > len(s.entries) {
+		count = len(s.entries)
+	}
+	entries := make([]SlowLogEntry, 0, count)
+	for i := range count {
+		// The newest entry is right before next
+		entries = append(entries, s.entries[(s.next-1-i+2*len(s.entries))%len(s.entries)])
+	}
+	return entries
+}
+
+// Len returns the number of entries
+func (s *SlowLog) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.entries)
+}
+
+// Reset removes every entry
+func (s *SlowLog) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.entries = nil
+	s.next = 0
+}
+
+// SLOWLOG GET [count] | LEN | RESET
+func handleSlowLog(args []resp.Value, store *KVStore, client *Client) resp.Value {
+	if len(args) == 0 {
+		return errorValue([]byte("wrong number of arguments for 'SLOWLOG' command"))
+	}
+	subcommand := string(bytes.ToUpper(args[0].Buffer))
+	switch {
+	case subcommand == "GET" && len(args) <= 2:
+		count := 10
+		if len(args) == 2 {
+			var err error
+			if count, err = strconv.Atoi(string(args[1].Buffer)); err != nil || count < -1 {
+				return errorValue([]byte("count should be greater than or equal to -1"))
+			}
+		}
+		entries := store.SlowLog.Entries(count)
+		values := make([]resp.Value, 0, len(entries))
+		for _, entry := range entries {
+			arguments := make([]resp.Value, 0, len(entry.Args))
+			for _, arg := range entry.Args {
+				arguments = append(arguments, resp.Value{Type: resp.ValueTypeBulkString, Buffer: []byte(arg)})
+			}
+			values = append(values, resp.Value{Type: resp.ValueTypeArray, Array: []resp.Value{
+				{Type: resp.ValueTypeInteger, Integer: entry.ID},
+				{Type: resp.ValueTypeInteger, Integer: entry.Start.Unix()},
+				{Type: resp.ValueTypeInteger, Integer: entry.Duration.Microseconds()},
+				{Type: resp.ValueTypeArray, Array: arguments},
+				{Type: resp.ValueTypeBulkString, Buffer: []byte(entry.Client)},
+				{Type: resp.ValueTypeBulkString, Buffer: []byte(entry.User)},
+			}})
+		}
+		return resp.Value{Type: resp.ValueTypeArray, Array: values}
+	case subcommand == "LEN" && len(args) == 1:
+		return resp.Value{Type: resp.ValueTypeInteger, Integer: int64(store.SlowLog.Len())}
+	case subcommand == "RESET" && len(args) == 1:
+		store.SlowLog.Reset()
+		return resp.Value{Type: resp.ValueTypeSimpleString, Buffer: []byte("OK")}
+	}
+	return errorValue([]byte("unknown subcommand or wrong number of arguments for 'SLOWLOG' command"))
+}
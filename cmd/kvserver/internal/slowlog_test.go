@@ -0,0 +1,106 @@
+package internal
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ananthvk/kvdb/internal/resp"
+)
+
+func TestSlowLog(t *testing.T) {
+	log := NewSlowLog(time.Millisecond, 3)
+	start := time.Now()
+	log.record(start, time.Microsecond, []byte("GET"), bulkArgs("fast"), nil)
+	for i, key := range []string{"a", "b", "c", "d"} {
+		log.record(start, time.Duration(i+1)*time.Millisecond, []byte("GET"), bulkArgs(key), nil)
+	}
+	entries := log.Entries(-1)
+	if len(entries) != 3 {
+		t.Fatalf("expected the last 3 entries, got %+v", entries)
+	}
+	// Newest first, the fast command and the oldest slow one are not kept
+	for i, key := range []string{"d", "c", "b"} {
+		if entries[i].Args[1] != key || entries[i].ID != int64(3-i) {
+			t.Errorf("entry %d: expected GET %s with id %d, got %+v", i, key, 3-i, entries[i])
+		}
+	}
+	if entries := log.Entries(1); len(entries) != 1 || entries[0].Args[1] != "d" {
+		t.Errorf("expected the newest entry, got %+v", entries)
+	}
+	log.Reset()
+	if log.Len() != 0 {
+		t.Errorf("expected no entries after a reset, got %d", log.Len())
+	}
+	log.record(start, time.Second, []byte("GET"), bulkArgs("e"), nil)
+	if entries := log.Entries(-1); len(entries) != 1 || entries[0].ID != 4 {
+		t.Errorf("expected the ids to continue after a reset, got %+v", entries)
+	}
+}
+
+func TestSlowLogSettings(t *testing.T) {
+	log := NewSlowLog(time.Millisecond, 3)
+	start := time.Now()
+	for _, key := range []string{"a", "b", "c", "d"} {
+		log.record(start, time.Second, []byte("GET"), bulkArgs(key), nil)
+	}
+	// The ring buffer is full and wraps around, shrinking it keeps the newest entries
+	log.SetMaxLen(2)
+	log.record(start, time.Second, []byte("GET"), bulkArgs("e"), nil)
+	entries := log.Entries(-1)
+	if len(entries) != 2 || entries[0].Args[1] != "e" || entries[1].Args[1] != "d" {
+		t.Errorf("expected the entries of e and d, got %+v", entries)
+	}
+	log.SetMaxLen(4)
+	log.record(start, time.Second, []byte("GET"), bulkArgs("f"), nil)
+	if entries := log.Entries(-1); len(entries) != 3 || entries[0].Args[1] != "f" || entries[2].Args[1] != "d" {
+		t.Errorf("expected the entries of f, e and d, got %+v", entries)
+	}
+
+	log.SetThreshold(-1)
+	log.record(start, time.Hour, []byte("GET"), bulkArgs("g"), nil)
+	if log.Len() != 3 {
+		t.Errorf("expected nothing to be logged once the log is disabled, got %d entries", log.Len())
+	}
+}
+
+func TestSlowLogArgs(t *testing.T) {
+	args := make([]string, 40)
+	for i := range args {
+		args[i] = "arg"
+	}
+	args[0] = strings.Repeat("x", slowLogMaxArgLen+10)
+	logged := slowLogArgs([]byte("DEL"), bulkArgs(args...))
+	if len(logged) != slowLogMaxArgs || logged[len(logged)-1] != "... (10 more arguments)" {
+		t.Errorf("expected the arguments to be truncated, got %v", logged)
+	}
+	if logged[1] != strings.Repeat("x", slowLogMaxArgLen)+"... (10 more bytes)" {
+		t.Errorf("expected the long argument to be truncated, got %s", logged[1])
+	}
+	logged = slowLogArgs([]byte("AUTH"), bulkArgs("user", "secret"))
+	if strings.Contains(strings.Join(logged, " "), "secret") {
+		t.Errorf("expected the password not to be logged, got %v", logged)
+	}
+}
+
+func TestSlowLogCommand(t *testing.T) {
+	store := helperMemoryStore(t)
+	store.SlowLog = NewSlowLog(0, 10)
+	store.SlowLog.record(time.Now(), time.Millisecond, []byte("SET"), bulkArgs("key", "value"), nil)
+	reply := handleSlowLog(bulkArgs("GET"), store, nil)
+	if len(reply.Array) != 1 || len(reply.Array[0].Array) != 6 || reply.Array[0].Array[2].Integer != 1000 {
+		t.Fatalf("SLOWLOG GET: expected an entry of 1000us, got %+v", reply)
+	}
+	if args := reply.Array[0].Array[3].Array; len(args) != 3 || string(args[2].Buffer) != "value" {
+		t.Errorf("SLOWLOG GET: expected the arguments of the command, got %+v", args)
+	}
+	if reply := handleSlowLog(bulkArgs("LEN"), store, nil); reply.Integer != 1 {
+		t.Errorf("SLOWLOG LEN: expected 1, got %+v", reply)
+	}
+	if reply := handleSlowLog(bulkArgs("RESET"), store, nil); reply.Type != resp.ValueTypeSimpleString {
+		t.Errorf("SLOWLOG RESET: expected OK, got %+v", reply)
+	}
+	if reply := handleSlowLog(bulkArgs("GET", "-2"), store, nil); reply.Type != resp.ValueTypeSimpleError {
+		t.Errorf("SLOWLOG GET: expected an error for a count below -1, got %+v", reply)
+	}
+}
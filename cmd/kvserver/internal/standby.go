@@ -0,0 +1,365 @@
+package internal
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ananthvk/kvdb"
+	"github.com/ananthvk/kvdb/internal/resp"
+	"github.com/spf13/afero"
+)
+
+/*
+Warm standby
+
+A standby is a server that serves a copy of the data of another server, taken from the snapshots that the other server
+writes to a shared directory, and that can take over once the other server is gone. It's a cruder failover than
+replication (the copy is as old as the latest snapshot), but the two servers only have to share the directory, not a
+network link. The primary writes a snapshot to a new directory in BackupDir periodically (-backup-interval, see
+StartBackgroundBackup), and the standby is started with the same directory (-standby-of, or STANDBY <dir>).
+
+Every StandbyInterval, the standby looks for the latest complete snapshot in the directory: the subdirectory with a
+MANIFEST with the newest created time. If it's newer than the snapshot that was loaded last, the contents of the store
+are replaced with it, keys whose value changed are written and keys that are not in the snapshot are deleted. Like on a
+replica, writes from clients are rejected with READONLY while the server is a standby.
+
+STANDBY PROMOTE turns the standby into a primary: it takes the promotion lock (a PROMOTED file created in the shared
+directory, with the host and pid of the server), stops refreshing, loads the latest snapshot one last time, and accepts
+writes. The lock keeps two standbys of the same directory from both being promoted, it has to be removed by hand before
+another standby of the directory can be promoted. A standby does not write background backups until it's promoted, so
+a promoted standby with the same BackupDir takes over writing the snapshots for the next standby
+*/
+
+const (
+	defaultStandbyInterval = 30 * time.Second
+	// Number of snapshots written by StartBackgroundBackup that are kept in BackupDir, the older ones are removed
+	backupsKept = 2
+	// Name of the promotion lock in the shared directory
+	standbyLockName = "PROMOTED"
+)
+
+// standbyLink is the state of a standby, it's refreshed in a goroutine until it's stopped
+type standbyLink struct {
+	dir    string
+	cancel context.CancelFunc
+	done   chan struct{}
+
+	// Snapshot that was loaded last, protected by mu
+	mu              sync.Mutex
+	snapshot        string
+	snapshotCreated time.Time
+	loadedAt        time.Time
+	loads           uint64
+	lastErr         error
+}
+
+// IsStandby returns true if the server is a standby, see standby.go
+func (kv *KVStore) IsStandby() bool {
+	kv.standbyMu.Lock()
+	defer kv.standbyMu.Unlock()
+	return kv.standby != nil
+}
+
+// readOnlyReason returns why writes from clients are rejected, or an empty string if they are allowed
+func (kv *KVStore) readOnlyReason() string {
+	if kv.IsReplica() {
+		return "You can't write against a read only replica."
+	}
+	if kv.IsStandby() {
+		return "You can't write against a read only standby."
+	}
+	return ""
+}
+
+// StandbyOf makes the server a standby of the snapshots written to dir. If the server is already a standby, the current
+// one is stopped first. The latest snapshot is loaded right away, and then every StandbyInterval. An empty dir stops
+// the standby without taking the promotion lock, and the server continues as a primary with it's current data
+func (kv *KVStore) StandbyOf(dir string) {
+	kv.standbyMu.Lock()
+	defer kv.standbyMu.Unlock()
+	kv.stopStandby()
+	if dir == "" {
+		return
+	}
+	interval := kv.StandbyInterval
+	if interval <= 0 {
+		interval = defaultStandbyInterval
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	link := &standbyLink{dir: dir, cancel: cancel, done: make(chan struct{})}
+	kv.standby = link
+	slog.Info("standby started", "dir", dir, "interval", interval)
+	go func() {
+		defer close(link.done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			kv.refreshStandby(link)
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// stopStandby stops refreshing the standby, the caller must hold standbyMu
+func (kv *KVStore) stopStandby() {
+	if kv.standby == nil {
+		return
+	}
+	kv.standby.cancel()
+	<-kv.standby.done
+	kv.standby = nil
+	slog.Info("standby stopped")
+}
+
+// Promote turns the standby into a primary that accepts writes, see standby.go. It returns an error (and the server
+// stays a standby) if the server is not a standby, or if the promotion lock is held
+func (kv *KVStore) Promote() error {
+	kv.standbyMu.Lock()
+	defer kv.standbyMu.Unlock()
+	link := kv.standby
+	if link == nil {
+		return errors.New("not a standby")
+	}
+	if err := kv.acquireStandbyLock(link.dir); err != nil {
+		return err
+	}
+	link.cancel()
+	<-link.done
+	// The primary may have written a snapshot since the last refresh. If it can't be loaded, the standby is still
+	// promoted with the data it has
+	kv.refreshStandby(link)
+	kv.standby = nil
+	slog.Info("standby promoted", "dir", link.dir)
+	return nil
+}
+
+// acquireStandbyLock creates the promotion lock in dir, it fails if the file already exists
+func (kv *KVStore) acquireStandbyLock(dir string) error {
+	path := filepath.Join(dir, standbyLockName)
+	file, err := kv.fs.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0666)
+	if errors.Is(err, os.ErrExist) {
+		owner, _ := afero.ReadFile(kv.fs, path)
+		return fmt.Errorf("the standby directory was already promoted (%s)", strings.Join(strings.Fields(string(owner)), " "))
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	host, _ := os.Hostname()
+	fmt.Fprintf(file, "host=%s\npid=%d\npromoted=%s\n", host, os.Getpid(), time.Now().UTC().Format(time.RFC3339))
+	return file.Sync()
+}
+
+// refreshStandby loads the latest snapshot in the directory of the standby, if it's newer than the one that was loaded
+func (kv *KVStore) refreshStandby(link *standbyLink) {
+	path, manifest, err := latestSnapshot(kv.fs, link.dir)
+	if err == nil && path == "" {
+		err = errors.New("no complete snapshot")
+	}
+	link.mu.Lock()
+	current := link.snapshotCreated
+	link.mu.Unlock()
+	if err == nil && !manifest.Created.After(current) {
+		return
+	}
+	var keys int
+	if err == nil {
+		start := time.Now()
+		keys, err = kv.loadSnapshot(path)
+		if err == nil {
+			slog.Info("standby loaded snapshot", "path", path, "keys", keys, "took", time.Since(start))
+		}
+	}
+	if err != nil {
+		slog.Warn("standby refresh failed", "dir", link.dir, "error", err)
+	}
+
+	link.mu.Lock()
+	defer link.mu.Unlock()
+	link.lastErr = err
+	if err == nil {
+		link.snapshot = path
+		link.snapshotCreated = manifest.Created
+		link.loadedAt = time.Now()
+		link.loads++
+	}
+}
+
+// latestSnapshot returns the path and manifest of the complete snapshot in dir with the newest created time, or an
+// empty path if there is none. Directories that are not complete snapshots are skipped
+func latestSnapshot(fs afero.Fs, dir string) (string, *kvdb.SnapshotManifest, error) {
+	entries, err := afero.ReadDir(fs, dir)
+	if err != nil {
+		return "", nil, err
+	}
+	var latestPath string
+	var latest *kvdb.SnapshotManifest
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		path := filepath.Join(dir, entry.Name())
+		manifest, err := kvdb.ReadSnapshotManifest(fs, path)
+		if err != nil {
+			continue
+		}
+		if latest == nil || manifest.Created.After(latest.Created) {
+			latestPath, latest = path, manifest
+		}
+	}
+	return latestPath, latest, nil
+}
+
+// loadSnapshot replaces the contents of the store with the snapshot at path, only the keys that changed are written.
+// Returns the number of keys in the snapshot
+func (kv *KVStore) loadSnapshot(path string) (int, error) {
+	received := map[string]bool{}
+	err := kvdb.ScanSnapshotDir(kv.fs, path, kv.Store.MaxKeySize(), kv.Store.MaxValueSize(), func(key, value []byte) error {
+		received[string(key)] = true
+		kv.commandLock.RLock()
+		defer kv.commandLock.RUnlock()
+		current, err := kv.Store.Get(key)
+		if err == nil && bytes.Equal(current, value) {
+			return nil
+		}
+		if err != nil && !errors.Is(err, kvdb.ErrKeyNotFound) {
+			return err
+		}
+		return kv.Store.Put(key, value)
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	// Remove the keys that are not in the snapshot
+	keys, err := kv.Store.ListKeys()
+	if err != nil {
+		return 0, err
+	}
+	for _, key := range keys {
+		if received[key] {
+			continue
+		}
+		kv.commandLock.RLock()
+		err = kv.Store.Delete([]byte(key))
+		kv.commandLock.RUnlock()
+		if err != nil {
+			return 0, err
+		}
+	}
+	return len(received), nil
+}
+
+// StartBackgroundBackup writes a snapshot of the store to a new directory in BackupDir every interval (except while the
+// server is a standby), for standbys to load. Only the latest backupsKept of these snapshots are kept
+func (kv *KVStore) StartBackgroundBackup(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-kv.done:
+				return
+			case <-ticker.C:
+			}
+			if kv.IsStandby() {
+				continue
+			}
+			// Transactions are not interleaved with the export, so the snapshot never has half of a transaction
+			kv.commandLock.RLock()
+			path, err := kv.writeBackup("backup")
+			kv.commandLock.RUnlock()
+			if err != nil {
+				slog.Warn("background backup failed", "error", err)
+				continue
+			}
+			slog.Info("background backup written", "path", path)
+			kv.pruneBackups("backup")
+		}
+	}()
+}
+
+// writeBackup writes a snapshot of the store to a new directory in BackupDir, named after the prefix and the current
+// time, and returns it's path
+func (kv *KVStore) writeBackup(prefix string) (string, error) {
+	path := filepath.Join(kv.BackupDir, prefix+"-"+time.Now().Format("20060102-150405.000000"))
+	return path, kv.Store.ExportSnapshotDir(path)
+}
+
+// pruneBackups removes all but the latest backupsKept backups in BackupDir with the given prefix. The names sort in the
+// order in which the backups were written
+func (kv *KVStore) pruneBackups(prefix string) {
+	entries, err := afero.ReadDir(kv.fs, kv.BackupDir)
+	if err != nil {
+		slog.Warn("could not list backups", "dir", kv.BackupDir, "error", err)
+		return
+	}
+	var names []string
+	for _, entry := range entries {
+		if entry.IsDir() && strings.HasPrefix(entry.Name(), prefix+"-") {
+			names = append(names, entry.Name())
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names[:max(len(names)-backupsKept, 0)] {
+		if err := kv.fs.RemoveAll(filepath.Join(kv.BackupDir, name)); err != nil {
+			slog.Warn("could not remove backup", "path", name, "error", err)
+		}
+	}
+}
+
+// handleStandby implements STANDBY <dir> and STANDBY PROMOTE, see standby.go
+func handleStandby(args []resp.Value, store *KVStore, client *Client) resp.Value {
+	if len(args) != 1 {
+		return errorValue([]byte("wrong number of arguments for 'STANDBY' command"))
+	}
+	arg := string(args[0].Buffer)
+	if strings.EqualFold(arg, "PROMOTE") {
+		if err := store.Promote(); err != nil {
+			return errorValue(fmt.Appendf(nil, "promotion failed: %s", err))
+		}
+	} else {
+		store.ReplicaOf("")
+		store.StandbyOf(arg)
+	}
+	return resp.Value{
+		Type:   resp.ValueTypeSimpleString,
+		Buffer: []byte{'O', 'K'},
+	}
+}
+
+// writeInfoStandby writes the standby fields of the replication section of INFO
+func writeInfoStandby(buf *bytes.Buffer, link *standbyLink) {
+	link.mu.Lock()
+	defer link.mu.Unlock()
+	fmt.Fprintf(buf, "role:standby\r\n")
+	fmt.Fprintf(buf, "standby_dir:%s\r\n", link.dir)
+	fmt.Fprintf(buf, "standby_snapshot:%s\r\n", link.snapshot)
+	created := int64(-1)
+	if !link.snapshotCreated.IsZero() {
+		created = link.snapshotCreated.Unix()
+	}
+	fmt.Fprintf(buf, "standby_snapshot_created:%d\r\n", created)
+	lastLoad := int64(-1)
+	if !link.loadedAt.IsZero() {
+		lastLoad = int64(time.Since(link.loadedAt).Seconds())
+	}
+	fmt.Fprintf(buf, "standby_last_load_seconds_ago:%d\r\n", lastLoad)
+	fmt.Fprintf(buf, "standby_loads:%d\r\n", link.loads)
+	if link.lastErr != nil {
+		fmt.Fprintf(buf, "standby_last_error:%s\r\n", strings.ReplaceAll(link.lastErr.Error(), "\r\n", " "))
+	}
+}
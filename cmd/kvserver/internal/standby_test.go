@@ -0,0 +1,126 @@
+package internal
+
+import (
+	"bytes"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ananthvk/kvdb"
+	"github.com/spf13/afero"
+)
+
+// helperWaitFor fails the test if cond does not become true within a few seconds
+func helperWaitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func helperStandbyStore(t *testing.T, fs afero.Fs, path string) *KVStore {
+	t.Helper()
+	store := newKVStore(fs, path, nil)
+	if store == nil {
+		t.Fatalf("could not create store %s", path)
+	}
+	t.Cleanup(func() { store.Close() })
+	return store
+}
+
+func TestStandby(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	primary := helperStandbyStore(t, fs, "primary.db")
+	primary.BackupDir = "backups"
+	primary.Store.Put([]byte("key1"), []byte("value1"))
+	primary.Store.Put([]byte("key2"), []byte("value2"))
+	if _, err := primary.writeBackup("backup"); err != nil {
+		t.Fatalf("backup failed: %v", err)
+	}
+
+	standby := helperStandbyStore(t, fs, "standby.db")
+	standby.StandbyInterval = 10 * time.Millisecond
+	standby.Store.Put([]byte("stale"), []byte("value"))
+	standby.StandbyOf("backups")
+	helperWaitFor(t, "the first snapshot", func() bool {
+		value, err := standby.Store.Get([]byte("key2"))
+		return err == nil && string(value) == "value2"
+	})
+	if standby.Store.Size() != 2 {
+		t.Errorf("expected the keys that are not in the snapshot to be deleted, got %d keys", standby.Store.Size())
+	}
+	if reason := standby.readOnlyReason(); !strings.Contains(reason, "standby") {
+		t.Errorf("expected writes to be rejected on a standby, got %q", reason)
+	}
+
+	primary.Store.Put([]byte("key1"), []byte("updated"))
+	primary.Store.Delete([]byte("key2"))
+	if _, err := primary.writeBackup("backup"); err != nil {
+		t.Fatalf("backup failed: %v", err)
+	}
+	helperWaitFor(t, "the second snapshot", func() bool {
+		value, _ := standby.Store.Get([]byte("key1"))
+		return string(value) == "updated"
+	})
+	if _, err := standby.Store.Get([]byte("key2")); err != kvdb.ErrKeyNotFound {
+		t.Errorf("expected key2 to be deleted, got %v", err)
+	}
+	var info bytes.Buffer
+	writeInfoReplication(&info, standby)
+	if !strings.Contains(info.String(), "role:standby") || !strings.Contains(info.String(), "standby_loads:2") {
+		t.Errorf("unexpected INFO replication section:\n%s", info.String())
+	}
+
+	// The primary is gone, the latest snapshot is loaded before the standby takes over
+	primary.Store.Put([]byte("key3"), []byte("value3"))
+	if _, err := primary.writeBackup("backup"); err != nil {
+		t.Fatalf("backup failed: %v", err)
+	}
+	standby.StandbyInterval = time.Hour
+	if err := standby.Promote(); err != nil {
+		t.Fatalf("promotion failed: %v", err)
+	}
+	if standby.IsStandby() || standby.readOnlyReason() != "" {
+		t.Errorf("expected the promoted standby to accept writes")
+	}
+	if _, err := standby.Store.Get([]byte("key3")); err != nil {
+		t.Errorf("expected the latest snapshot to be loaded when promoting, got %v", err)
+	}
+	if exists, _ := afero.Exists(fs, filepath.Join("backups", standbyLockName)); !exists {
+		t.Errorf("expected the promotion lock to be created")
+	}
+	if err := standby.Promote(); err == nil {
+		t.Errorf("expected promoting a primary to fail")
+	}
+
+	// Another standby of the same directory can't be promoted
+	other := helperStandbyStore(t, fs, "other.db")
+	other.StandbyOf("backups")
+	if err := other.Promote(); err == nil || !other.IsStandby() {
+		t.Errorf("expected the second promotion to fail, got %v", err)
+	}
+}
+
+func TestPruneBackups(t *testing.T) {
+	store := helperMemoryStore(t)
+	store.BackupDir = "backups"
+	store.Store.Put([]byte("key1"), []byte("value1"))
+	if _, err := store.writeBackup("shutdown"); err != nil {
+		t.Fatalf("backup failed: %v", err)
+	}
+	for range backupsKept + 2 {
+		if _, err := store.writeBackup("backup"); err != nil {
+			t.Fatalf("backup failed: %v", err)
+		}
+	}
+	store.pruneBackups("backup")
+	entries, _ := afero.ReadDir(store.fs, "backups")
+	if len(entries) != backupsKept+1 {
+		t.Errorf("expected %d backups and the shutdown backup to be kept, got %d", backupsKept, len(entries))
+	}
+}
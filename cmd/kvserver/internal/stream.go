@@ -0,0 +1,95 @@
+package internal
+
+import (
+	"bytes"
+	"fmt"
+	"time"
+
+	"github.com/ananthvk/kvdb/internal/resp"
+)
+
+/*
+Streaming large values
+
+Requests are read into memory whole, so a bulk string can't be larger than 1 MiB. With ProtoMaxBulkLen
+(-proto-max-bulk-len) set, the value of SET key value can be larger (up to ProtoMaxBulkLen bytes): the value is the
+last argument of the command, so it's not read with the rest of the request (see internal/resp/stream.go), and is
+passed to DataStore.PutReader, which copies it into a temporary file as it arrives, without holding it in memory. The
+datastore has to accept values that large, so -proto-max-bulk-len is also the value size limit of a datastore created
+by the server.
+
+Only SET key value (without options, which come after the value) is streamed. Other commands whose last argument is
+larger than 1 MiB fail, and the argument is skipped. A larger argument that's not the last one is a protocol error, as
+without streaming. A streamed SET can't be queued in a transaction, and it aborts it.
+The slow log and MONITOR show the size of the value instead of the value. Watchers of the datastore (replication and
+keyspace notifications) still get the value, which PutReader reads back from the data file.
+
+The datastore is only locked once the whole value has arrived, so a client that sends a value slowly does not hold up
+the other clients
+*/
+
+// handleStreamedSet runs a command whose last argument is streamed, args has the other arguments. Only SET key value is
+// allowed. The caller closes the stream, which skips the part of the value that was not read
+func (kvStore *KVStore) handleStreamedSet(args []resp.Value, stream *resp.BulkStream, client *Client) resp.Value {
+	for _, arg := range args {
+		if arg.Type != resp.ValueTypeBulkString {
+			return requestError([]byte("invalid request: all array elements must be bulk strings"))
+		}
+	}
+	if len(args) == 0 {
+		return requestError([]byte("invalid request: request must be an array of bulk strings"))
+	}
+	name := bytes.ToUpper(args[0].Buffer)
+	if !client.Authenticated {
+		return resp.Value{
+			Type:              resp.ValueTypeSimpleError,
+			SimpleErrorPrefix: []byte("NOAUTH"),
+			Buffer:            []byte("Authentication required."),
+		}
+	}
+	if string(name) != "SET" || len(args) != 2 {
+		return errorValue(fmt.Appendf(nil, "argument of %d bytes is too large, only SET key value can have values larger than 1 MiB", stream.Size()))
+	}
+	if client.tx != nil {
+		client.tx.aborted = true
+		return errorValue([]byte("values larger than 1 MiB can't be written in a transaction"))
+	}
+	if client.IsSubscribed() {
+		return errorValue([]byte("Can't execute 'set': only (P)SUBSCRIBE / (P)UNSUBSCRIBE / PING / QUIT are allowed in this context"))
+	}
+	if reason := kvStore.readOnlyReason(); reason != "" {
+		return resp.Value{Type: resp.ValueTypeSimpleError, SimpleErrorPrefix: []byte("READONLY"), Buffer: []byte(reason)}
+	}
+	key := args[1].Buffer
+	if err := kvStore.ACL.check(client.User, "SET", args[1:]); err != nil {
+		return err.Value()
+	}
+	if err := kvStore.ACL.checkValueSize(client.User, "SET", key, stream.Size()); err != nil {
+		return err.Value()
+	}
+
+	// The value is not in memory, the slow log and the monitors get it's size instead
+	logged := []resp.Value{args[1], {Type: resp.ValueTypeBulkString, Buffer: fmt.Appendf(nil, "(%d bytes)", stream.Size())}}
+	received := time.Now()
+	client.setLastCommand("set", received)
+	kvStore.feedMonitors(received, client, args[0].Buffer, logged)
+
+	kvStore.totalCommands.Add(1)
+	kvStore.commandLock.RLock()
+	start := time.Now()
+	err := kvStore.db(client).PutReader(key, stream, stream.Size())
+	duration := time.Since(start)
+	kvStore.commandLock.RUnlock()
+
+	result := resp.Value{Type: resp.ValueTypeSimpleString, Buffer: []byte{'O', 'K'}}
+	if err != nil {
+		result = resp.Value{
+			Type:              resp.ValueTypeSimpleError,
+			SimpleErrorPrefix: []byte("INTERNAL_ERR"),
+			Buffer:            []byte(err.Error()),
+		}
+	}
+	kvStore.SlowLog.record(start, duration, name, logged, client)
+	kvStore.commandStats.record(name, duration, result.Type == resp.ValueTypeSimpleError)
+	return result
+}
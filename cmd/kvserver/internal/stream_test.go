@@ -0,0 +1,67 @@
+package internal
+
+import (
+	"bufio"
+	"bytes"
+	"net"
+	"testing"
+
+	"github.com/ananthvk/kvdb"
+	"github.com/ananthvk/kvdb/internal/resp"
+)
+
+func TestStreamedSet(t *testing.T) {
+	store := NewKVStoreWithOptions(":memory", &kvdb.Options{MaxValueSize: 8 << 20})
+	if store == nil {
+		t.Fatalf("could not create in-memory store")
+	}
+	t.Cleanup(func() { store.Close() })
+	store.ProtoMaxBulkLen = 4 << 20
+	conn, err := net.Dial("tcp", helperServe(t, store))
+	if err != nil {
+		t.Fatalf("could not connect: %v", err)
+	}
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+
+	large := string(bytes.Repeat([]byte("0123456789"), 300*1024))
+	if reply := helperCommand(t, conn, reader, "SET", "large", large); string(reply.Buffer) != "OK" {
+		t.Fatalf("expected OK, got %+v", reply)
+	}
+	if value, err := store.Store.Get([]byte("large")); err != nil || string(value) != large {
+		t.Errorf("expected the large value to be written, got %d bytes, %v", len(value), err)
+	}
+
+	// Other commands fail, and the connection can still be used
+	for _, args := range [][]string{{"SETNX", "large", large}, {"RPUSH", "list", large}} {
+		if reply := helperCommand(t, conn, reader, args...); reply.Type != resp.ValueTypeSimpleError {
+			t.Errorf("%s: expected an error, got %+v", args[0], reply)
+		}
+	}
+	if reply := helperCommand(t, conn, reader, "PING"); string(reply.Buffer) != "PONG" {
+		t.Errorf("expected PONG, got %+v", reply)
+	}
+	helperCommand(t, conn, reader, "MULTI")
+	helperCommand(t, conn, reader, "SET", "large", large)
+	if reply := helperCommand(t, conn, reader, "EXEC"); string(reply.SimpleErrorPrefix) != "EXECABORT" {
+		t.Errorf("expected a streamed SET to abort the transaction, got %+v", reply)
+	}
+
+	// A large value that's not the last argument, or that's over the limit, is a protocol error
+	for _, args := range [][]string{{"SET", "large", large, "NX"}, {"SET", "large", large + large}} {
+		conn, err := net.Dial("tcp", helperServe(t, store))
+		if err != nil {
+			t.Fatalf("could not connect: %v", err)
+		}
+		defer conn.Close()
+		// The server closes the connection while the command is being sent
+		values := make([]resp.Value, len(args))
+		for i, arg := range args {
+			values[i] = resp.Value{Type: resp.ValueTypeBulkString, Buffer: []byte(arg)}
+		}
+		go sendResponse(resp.Value{Type: resp.ValueTypeArray, Array: values}, bufio.NewWriter(conn))
+		if reply, err := resp.Deserialize(bufio.NewReader(conn)); err != nil || string(reply.SimpleErrorPrefix) != "REQUEST_ERR" {
+			t.Errorf("expected REQUEST_ERR, got %+v, %v", reply, err)
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/ananthvk/kvdb/internal/resp"
+)
+
+/*
+Command timeouts
+
+KEYS copies every key while writes to the store wait, and COMPACT merges the whole store, so on a large store either can
+hold the connection (and for KEYS, every writer) for a long time. They are run with a context that has the timeout set
+in KeysTimeout and CompactTimeout (0 disables the timeout), and the store stops the operation when the context is done.
+The client then gets a TIMEOUT error, nothing is left half done: KEYS returns no keys, and a cancelled merge removes the
+files it wrote.
+
+Only one compaction runs at a time (COMPACT or the background merge), a COMPACT that is sent while one is running is
+rejected with a BUSY error instead of waiting for it
+*/
+
+// commandContext returns the context used to run a command with the given timeout, 0 means no timeout
+func commandContext(timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return context.WithCancel(context.Background())
+	}
+	return context.WithTimeout(context.Background(), timeout)
+}
+
+// cancellableCommandError returns the error reply of a command that was run with commandContext
+func cancellableCommandError(command string, timeout time.Duration, err error) resp.Value {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return resp.Value{
+			Type:              resp.ValueTypeSimpleError,
+			SimpleErrorPrefix: []byte("TIMEOUT"),
+			Buffer:            fmt.Appendf(nil, "'%s' command did not finish within %s", command, timeout),
+		}
+	}
+	return resp.Value{
+		Type:              resp.ValueTypeSimpleError,
+		SimpleErrorPrefix: []byte("INTERNAL_ERR"),
+		Buffer:            []byte(err.Error()),
+	}
+}
+
+// tryCompact merges the store unless a compaction is already running, in which case it returns false
+func (kv *KVStore) tryCompact(ctx context.Context) (bool, error) {
+	if !kv.compacting.CompareAndSwap(false, true) {
+		return false, nil
+	}
+	defer kv.compacting.Store(false)
+	return true, kv.Store.MergeContext(ctx)
+}
+
+// COMPACT merges the immutable data files of the store, it replies with OK once the merge is done
+func handleCompact(args []resp.Value, store *KVStore, client *Client) resp.Value {
+	if len(args) != 0 {
+		return errorValue([]byte("wrong number of arguments for 'COMPACT' command"))
+	}
+	ctx, cancel := commandContext(store.CompactTimeout)
+	defer cancel()
+	started, err := store.tryCompact(ctx)
+	if !started {
+		return resp.Value{
+			Type:              resp.ValueTypeSimpleError,
+			SimpleErrorPrefix: []byte("BUSY"),
+			Buffer:            []byte("a compaction is already running"),
+		}
+	}
+	if err != nil {
+		return cancellableCommandError("COMPACT", store.CompactTimeout, err)
+	}
+	return resp.Value{
+		Type:   resp.ValueTypeSimpleString,
+		Buffer: []byte{'O', 'K'},
+	}
+}
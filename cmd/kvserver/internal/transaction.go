@@ -0,0 +1,213 @@
+package internal
+
+import (
+	"time"
+
+	"github.com/ananthvk/kvdb"
+	"github.com/ananthvk/kvdb/internal/resp"
+)
+
+/*
+MULTI / EXEC transactions
+
+After MULTI, commands from the client are queued (and the client receives +QUEUED) instead of being executed. On EXEC,
+all queued commands are executed while holding the command lock exclusively, so no other client's command can run in
+between. If a command could not be queued (unknown command, or not allowed in a transaction), EXEC aborts the whole
+transaction with an EXECABORT error.
+
+WATCH marks keys as watched by the client. Every write to the store is reported by the store's Watch API, and if a
+watched key was modified before EXEC, the transaction is not executed and EXEC returns a null reply. EXEC, DISCARD and
+UNWATCH clear the watched keys
+*/
+
+// Commands that are not queued inside a transaction
+var transactionControlCommands = map[string]bool{
+	"EXEC":    true,
+	"DISCARD": true,
+	"MULTI":   true,
+	"WATCH":   true,
+	"UNWATCH": true,
+}
+
+// Commands that cannot be used inside a transaction. Commands in exclusiveCommands are not allowed either, since EXEC
+// runs the queued commands with the command lock held
+var notAllowedInTransaction = map[string]bool{
+	"SUBSCRIBE":    true,
+	"PSUBSCRIBE":   true,
+	"UNSUBSCRIBE":  true,
+	"PUNSUBSCRIBE": true,
+	"AUTH":         true,
+	"MONITOR":      true,
+}
+
+type queuedCommand struct {
+	// name is the upper case name of the command
+	name string
+	fn   CommandFunc
+	args []resp.Value
+}
+
+// transaction is the state of a client between MULTI and EXEC / DISCARD
+type transaction struct {
+	commands []queuedCommand
+	// Set if a command could not be queued, EXEC will then fail
+	aborted bool
+	// Total size of the arguments of the queued commands
+	bytes int64
+}
+
+// endTransaction clears the client's transaction, and returns it
+func (client *Client) endTransaction() *transaction {
+	tx := client.tx
+	client.tx = nil
+	client.queuedBytes.Add(-tx.bytes)
+	return tx
+}
+
+// queueCommand adds the command to the client's transaction, and returns the reply to be sent to the client
+func queueCommand(client *Client, name, upperName string, fn CommandFunc, args []resp.Value) resp.Value {
+	if fn == nil || notAllowedInTransaction[upperName] || exclusiveCommands[upperName] {
+		client.tx.aborted = true
+		if fn == nil {
+			return errorValue([]byte("unknown command '" + name + "'"))
+		}
+		return errorValue([]byte("Command not allowed inside a transaction"))
+	}
+	client.tx.commands = append(client.tx.commands, queuedCommand{name: upperName, fn: fn, args: args})
+	for _, arg := range args {
+		client.tx.bytes += valueSize(arg)
+		client.queuedBytes.Add(valueSize(arg))
+	}
+	return resp.Value{
+		Type:   resp.ValueTypeSimpleString,
+		Buffer: []byte("QUEUED"),
+	}
+}
+
+// touchWatchedKey marks all clients watching the modified key as dirty, it's called by the store for every write
+func (kv *KVStore) touchWatchedKey(event kvdb.WatchEvent) {
+	kv.watchedKeysMu.Lock()
+	defer kv.watchedKeysMu.Unlock()
+	for client := range kv.watchedKeys[string(event.Key)] {
+		client.txDirty.Store(true)
+	}
+}
+
+// unwatchAll removes all the keys watched by the client
+func (kv *KVStore) unwatchAll(client *Client) {
+	kv.watchedKeysMu.Lock()
+	defer kv.watchedKeysMu.Unlock()
+	for key := range client.watchedKeys {
+		clients := kv.watchedKeys[key]
+		delete(clients, client)
+		if len(clients) == 0 {
+			delete(kv.watchedKeys, key)
+		}
+	}
+	client.watchedKeys = nil
+	client.txDirty.Store(false)
+}
+
+func handleMulti(args []resp.Value, store *KVStore, client *Client) resp.Value {
+	if len(args) != 0 {
+		return errorValue([]byte("wrong number of arguments for 'MULTI' command"))
+	}
+	if client.tx != nil {
+		return errorValue([]byte("MULTI calls can not be nested"))
+	}
+	client.tx = &transaction{}
+	return resp.Value{
+		Type:   resp.ValueTypeSimpleString,
+		Buffer: []byte{'O', 'K'},
+	}
+}
+
+func handleExec(args []resp.Value, store *KVStore, client *Client) resp.Value {
+	if len(args) != 0 {
+		return errorValue([]byte("wrong number of arguments for 'EXEC' command"))
+	}
+	if client.tx == nil {
+		return errorValue([]byte("EXEC without MULTI"))
+	}
+	tx := client.endTransaction()
+	defer store.unwatchAll(client)
+
+	if tx.aborted {
+		return resp.Value{
+			Type:              resp.ValueTypeSimpleError,
+			SimpleErrorPrefix: []byte("EXECABORT"),
+			Buffer:            []byte("Transaction discarded because of previous errors."),
+		}
+	}
+
+	store.totalCommands.Add(1)
+	store.commandLock.Lock()
+	defer store.commandLock.Unlock()
+	// Checked with the lock held, so that no other command can modify the watched keys until the transaction completes
+	if client.txDirty.Load() {
+		return resp.Value{Type: resp.ValueTypeNull}
+	}
+	results := make([]resp.Value, len(tx.commands))
+	for i, cmd := range tx.commands {
+		store.totalCommands.Add(1)
+		start := time.Now()
+		results[i] = cmd.fn(cmd.args, store, client)
+		store.commandStats.record([]byte(cmd.name), time.Since(start), results[i].Type == resp.ValueTypeSimpleError)
+	}
+	return resp.Value{
+		Type:  resp.ValueTypeArray,
+		Array: results,
+	}
+}
+
+func handleDiscard(args []resp.Value, store *KVStore, client *Client) resp.Value {
+	if len(args) != 0 {
+		return errorValue([]byte("wrong number of arguments for 'DISCARD' command"))
+	}
+	if client.tx == nil {
+		return errorValue([]byte("DISCARD without MULTI"))
+	}
+	client.endTransaction()
+	store.unwatchAll(client)
+	return resp.Value{
+		Type:   resp.ValueTypeSimpleString,
+		Buffer: []byte{'O', 'K'},
+	}
+}
+
+func handleWatch(args []resp.Value, store *KVStore, client *Client) resp.Value {
+	if len(args) == 0 {
+		return errorValue([]byte("wrong number of arguments for 'WATCH' command"))
+	}
+	if client.tx != nil {
+		return errorValue([]byte("WATCH inside MULTI is not allowed"))
+	}
+	store.watchedKeysMu.Lock()
+	defer store.watchedKeysMu.Unlock()
+	if client.watchedKeys == nil {
+		client.watchedKeys = map[string]bool{}
+	}
+	for _, arg := range args {
+		key := string(arg.Buffer)
+		client.watchedKeys[key] = true
+		if store.watchedKeys[key] == nil {
+			store.watchedKeys[key] = map[*Client]bool{}
+		}
+		store.watchedKeys[key][client] = true
+	}
+	return resp.Value{
+		Type:   resp.ValueTypeSimpleString,
+		Buffer: []byte{'O', 'K'},
+	}
+}
+
+func handleUnwatch(args []resp.Value, store *KVStore, client *Client) resp.Value {
+	if len(args) != 0 {
+		return errorValue([]byte("wrong number of arguments for 'UNWATCH' command"))
+	}
+	store.unwatchAll(client)
+	return resp.Value{
+		Type:   resp.ValueTypeSimpleString,
+		Buffer: []byte{'O', 'K'},
+	}
+}
@@ -0,0 +1,166 @@
+package internal
+
+import (
+	"bufio"
+	"net"
+	"testing"
+
+	"github.com/ananthvk/kvdb/internal/resp"
+)
+
+func TestExclusiveCommandsNotAllowedInTransaction(t *testing.T) {
+	store := helperMemoryStore(t)
+	conn, peer := net.Pipe()
+	defer conn.Close()
+	defer peer.Close()
+	client := NewClient(conn, "")
+
+	for name := range exclusiveCommands {
+		if transactionControlCommands[name] {
+			continue
+		}
+		handleMulti(nil, store, client)
+		reply := queueCommand(client, name, name, Commands[name], nil)
+		if reply.Type != resp.ValueTypeSimpleError {
+			t.Errorf("%s: expected an error when queued, got %+v", name, reply)
+		}
+		reply = handleExec(nil, store, client)
+		if reply.Type != resp.ValueTypeSimpleError || string(reply.SimpleErrorPrefix) != "EXECABORT" {
+			t.Errorf("%s: expected EXEC to abort, got %+v", name, reply)
+		}
+	}
+}
+
+// helperDial opens a connection to the server at address, it's closed when the test ends
+func helperDial(t *testing.T, address string) (net.Conn, *bufio.Reader) {
+	t.Helper()
+	conn, err := net.Dial("tcp", address)
+	if err != nil {
+		t.Fatalf("could not connect: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn, bufio.NewReader(conn)
+}
+
+func TestExecRunsQueuedCommandsInOrder(t *testing.T) {
+	store := helperMemoryStore(t)
+	conn, reader := helperDial(t, helperServe(t, store))
+
+	helperCommand(t, conn, reader, "MULTI")
+	for _, args := range [][]string{{"SET", "key", "1"}, {"GET", "key"}, {"SET", "key", "2"}, {"GET", "key"}} {
+		if reply := helperCommand(t, conn, reader, args...); string(reply.Buffer) != "QUEUED" {
+			t.Fatalf("%v: expected QUEUED, got %+v", args, reply)
+		}
+	}
+	// The queued commands have not run yet
+	if _, err := store.Store.Get([]byte("key")); err == nil {
+		t.Errorf("expected the queued SET not to run before EXEC")
+	}
+	reply := helperCommand(t, conn, reader, "EXEC")
+	if reply.Type != resp.ValueTypeArray || len(reply.Array) != 4 {
+		t.Fatalf("expected the replies of the 4 commands, got %+v", reply)
+	}
+	for i, expected := range []string{"OK", "1", "OK", "2"} {
+		if string(reply.Array[i].Buffer) != expected {
+			t.Errorf("reply %d: expected %s, got %+v", i, expected, reply.Array[i])
+		}
+	}
+	if reply := helperCommand(t, conn, reader, "EXEC"); reply.Type != resp.ValueTypeSimpleError {
+		t.Errorf("expected EXEC without MULTI to fail, got %+v", reply)
+	}
+}
+
+func TestExecAbortsAfterQueueError(t *testing.T) {
+	store := helperMemoryStore(t)
+	conn, reader := helperDial(t, helperServe(t, store))
+
+	helperCommand(t, conn, reader, "MULTI")
+	helperCommand(t, conn, reader, "SET", "key", "value")
+	if reply := helperCommand(t, conn, reader, "NOSUCHCOMMAND"); reply.Type != resp.ValueTypeSimpleError {
+		t.Errorf("expected an error for an unknown command, got %+v", reply)
+	}
+	if reply := helperCommand(t, conn, reader, "EXEC"); string(reply.SimpleErrorPrefix) != "EXECABORT" {
+		t.Errorf("expected EXECABORT, got %+v", reply)
+	}
+	if _, err := store.Store.Get([]byte("key")); err == nil {
+		t.Errorf("expected the aborted transaction not to write the key")
+	}
+}
+
+func TestWatch(t *testing.T) {
+	store := helperMemoryStore(t)
+	address := helperServe(t, store)
+	conn, reader := helperDial(t, address)
+	other, otherReader := helperDial(t, address)
+
+	// A watched key modified by another client makes EXEC return a null reply without running the commands
+	helperCommand(t, conn, reader, "WATCH", "key")
+	helperCommand(t, other, otherReader, "SET", "key", "other")
+	helperCommand(t, conn, reader, "MULTI")
+	helperCommand(t, conn, reader, "SET", "key", "mine")
+	if reply := helperCommand(t, conn, reader, "EXEC"); reply.Type != resp.ValueTypeNull {
+		t.Errorf("expected a null reply, got %+v", reply)
+	}
+	if value, _ := store.Store.Get([]byte("key")); string(value) != "other" {
+		t.Errorf("expected the value of the other client, got %q", value)
+	}
+
+	// EXEC clears the watched keys
+	helperCommand(t, other, otherReader, "SET", "key", "other")
+	helperCommand(t, conn, reader, "MULTI")
+	helperCommand(t, conn, reader, "SET", "key", "mine")
+	if reply := helperCommand(t, conn, reader, "EXEC"); reply.Type != resp.ValueTypeArray {
+		t.Errorf("expected the transaction to run after EXEC cleared the watched keys, got %+v", reply)
+	}
+
+	// A watched key that's not modified does not stop the transaction
+	helperCommand(t, conn, reader, "WATCH", "key")
+	helperCommand(t, other, otherReader, "SET", "unwatched", "other")
+	helperCommand(t, conn, reader, "MULTI")
+	helperCommand(t, conn, reader, "SET", "key", "mine")
+	if reply := helperCommand(t, conn, reader, "EXEC"); reply.Type != resp.ValueTypeArray {
+		t.Errorf("expected the transaction to run, got %+v", reply)
+	}
+}
+
+func TestUnwatchAndDiscard(t *testing.T) {
+	store := helperMemoryStore(t)
+	address := helperServe(t, store)
+	conn, reader := helperDial(t, address)
+	other, otherReader := helperDial(t, address)
+
+	// UNWATCH forgets the watched keys
+	helperCommand(t, conn, reader, "WATCH", "key")
+	if reply := helperCommand(t, conn, reader, "UNWATCH"); string(reply.Buffer) != "OK" {
+		t.Fatalf("expected OK, got %+v", reply)
+	}
+	helperCommand(t, other, otherReader, "SET", "key", "other")
+	helperCommand(t, conn, reader, "MULTI")
+	helperCommand(t, conn, reader, "SET", "key", "mine")
+	if reply := helperCommand(t, conn, reader, "EXEC"); reply.Type != resp.ValueTypeArray {
+		t.Errorf("expected the transaction to run after UNWATCH, got %+v", reply)
+	}
+
+	// DISCARD drops the queued commands and forgets the watched keys
+	helperCommand(t, conn, reader, "WATCH", "key")
+	helperCommand(t, conn, reader, "MULTI")
+	helperCommand(t, conn, reader, "SET", "key", "discarded")
+	if reply := helperCommand(t, conn, reader, "DISCARD"); string(reply.Buffer) != "OK" {
+		t.Fatalf("expected OK, got %+v", reply)
+	}
+	if value, _ := store.Store.Get([]byte("key")); string(value) != "mine" {
+		t.Errorf("expected the discarded SET not to run, got %q", value)
+	}
+	if reply := helperCommand(t, conn, reader, "EXEC"); reply.Type != resp.ValueTypeSimpleError {
+		t.Errorf("expected EXEC after DISCARD to fail, got %+v", reply)
+	}
+	helperCommand(t, other, otherReader, "SET", "key", "other")
+	helperCommand(t, conn, reader, "MULTI")
+	helperCommand(t, conn, reader, "SET", "key", "mine")
+	if reply := helperCommand(t, conn, reader, "EXEC"); reply.Type != resp.ValueTypeArray {
+		t.Errorf("expected the transaction to run after DISCARD, got %+v", reply)
+	}
+	if reply := helperCommand(t, conn, reader, "DISCARD"); reply.Type != resp.ValueTypeSimpleError {
+		t.Errorf("expected DISCARD without MULTI to fail, got %+v", reply)
+	}
+}
@@ -0,0 +1,43 @@
+package internal
+
+import (
+	"errors"
+
+	"github.com/ananthvk/kvdb"
+	"github.com/ananthvk/kvdb/internal/resp"
+)
+
+// wrongTypeValue is the reply of a command on a key that holds a value of another type
+func wrongTypeValue() resp.Value {
+	return resp.Value{
+		Type:              resp.ValueTypeSimpleError,
+		SimpleErrorPrefix: []byte("WRONGTYPE"),
+		Buffer:            []byte("Operation against a key holding the wrong kind of value"),
+	}
+}
+
+// checkStringKey returns a WRONGTYPE reply if the key holds a structured value (a hash, list or set), for the string
+// commands that read the value before replacing or deleting it. A structured value written between the check and the
+// command is not detected
+func checkStringKey(store *KVStore, client *Client, key []byte) (resp.Value, bool) {
+	t, err := store.db(client).Type(key)
+	if err == nil && t != kvdb.ValueTypeString {
+		return wrongTypeValue(), false
+	}
+	return resp.Value{}, true
+}
+
+// TYPE key returns the type of the value of the key (string, hash, list or set), or none if the key does not exist
+func handleType(args []resp.Value, store *KVStore, client *Client) resp.Value {
+	if len(args) != 1 {
+		return errorValue([]byte("wrong number of arguments for 'TYPE' command"))
+	}
+	t, err := store.db(client).Type(args[0].Buffer)
+	if errors.Is(err, kvdb.ErrKeyNotFound) {
+		return resp.Value{Type: resp.ValueTypeSimpleString, Buffer: []byte("none")}
+	}
+	if err != nil {
+		return structuredError(err)
+	}
+	return resp.Value{Type: resp.ValueTypeSimpleString, Buffer: []byte(t.String())}
+}
@@ -7,7 +7,11 @@ import (
 	"log/slog"
 	"net"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
+	"github.com/ananthvk/kvdb"
 	"github.com/ananthvk/kvdb/cmd/kvserver/internal"
 )
 
@@ -15,11 +19,71 @@ func main() {
 	portPtr := flag.Uint("port", 6379, "specify the port on which to listen")
 	hostPtr := flag.String("host", "0.0.0.0", "specify the bind address")
 	dbPtr := flag.String("db", "", "specify the datastore directory path")
+	requirePassPtr := flag.String("requirepass", "", "require clients to issue AUTH <password> before processing any other commands")
+	maxClientsPtr := flag.Int("maxclients", 10000, "maximum number of connected clients, 0 for no limit")
+	idleTimeoutPtr := flag.Duration("idle-timeout", 0, "close the connection after a client is idle for this duration (e.g. 5m), 0 to disable")
+	notifyKeyspaceEventsPtr := flag.String("notify-keyspace-events", "", "keyspace events to publish, K (keyspace), E (keyevent), g (del), $ (set), A (alias for g$)")
+	protoMaxBulkLenPtr := flag.Int64("proto-max-bulk-len", 0, "largest value SET key value can write, values larger than 1 MiB are streamed into the datastore, also the value size limit of a new datastore, 0 limits values to 1 MiB")
+	readTimeoutPtr := flag.Duration("read-timeout", 30*time.Second, "maximum time to receive a complete request from a client, 0 to disable")
+	replicaOfPtr := flag.String("replicaof", "", "start as a replica of the primary at host:port")
+	metricsAddrPtr := flag.String("metrics-addr", "", "serve metrics in the Prometheus text format at http://<addr>/metrics, disabled if empty")
+	keysTimeoutPtr := flag.Duration("keys-timeout", 5*time.Second, "maximum time KEYS can run for before it fails with a TIMEOUT error, 0 to disable")
+	compactTimeoutPtr := flag.Duration("compact-timeout", time.Minute, "maximum time COMPACT can run for before the merge is cancelled, 0 to disable")
+	primaryAuthPtr := flag.String("primaryauth", "", "password used to authenticate with the primary when running as a replica")
+	aclFilePtr := flag.String("acl-file", "", "JSON file with the users, and the keys and value sizes each user is allowed, see README")
+	backupDirPtr := flag.String("backup-dir", "", "directory where SAVE, BGSAVE, SHUTDOWN SAVE and -backup-interval write snapshots of the datastore, disabled if empty")
+	backupIntervalPtr := flag.Duration("backup-interval", 0, "write a snapshot of the datastore to -backup-dir at this interval (e.g. 1m), for standbys to load, 0 to disable")
+	standbyOfPtr := flag.String("standby-of", "", "start as a standby that loads the latest snapshot written to this directory by a primary's -backup-interval")
+	standbyIntervalPtr := flag.Duration("standby-interval", 30*time.Second, "time between checks for a newer snapshot when running as a standby")
+	groupCommitPtr := flag.Bool("group-commit", false, "write the SETs of concurrent clients in batches with a single writer, see Options.GroupCommit")
+	groupCommitSyncPtr := flag.Bool("group-commit-sync", false, "with -group-commit, sync every batch before replying, so that every acknowledged SET is durable")
+	maxOpenFilesPtr := flag.Int("max-open-files", 0, "maximum number of files the datastore keeps open (at least 5), readers of data files are closed to stay within it, 0 for no limit")
+	slowlogThresholdPtr := flag.Duration("slowlog-log-slower-than", 10*time.Millisecond, "record the commands that run for at least this duration in the slow log (see SLOWLOG), 0 records every command, a negative duration disables it")
+	slowlogMaxLenPtr := flag.Int("slowlog-max-len", 128, "maximum number of commands kept in the slow log")
+	mergeIntervalPtr := flag.Duration("merge-interval", 2*time.Minute, "time between two background merges of the datastore, 0 to disable")
+	mergeWindowPtr := flag.String("merge-window", "", "only start background merges between these times of the day (e.g. 02:00-05:00, local time), empty for any time")
+	mergeMaxWriteRatePtr := flag.Float64("merge-max-write-rate", 0, "defer background merges while the datastore is written more than this many times per second, 0 for no limit")
+	attachDirPtr := flag.String("attach-dir", "", "directory under which ATTACH opens (or creates) datastores, ATTACH is disabled if empty")
+	var attachPaths []string
+	flag.Func("attach", "attach the datastore at this path (created if it does not exist) as the next database, see SELECT, can be repeated", func(path string) error {
+		attachPaths = append(attachPaths, path)
+		return nil
+	})
+	var logLevel slog.Level
+	flag.TextVar(&logLevel, "log-level", slog.LevelInfo, "minimum level of the logged messages (debug, info, warn or error)")
+	configPtr := flag.String("config", "", "JSON file with the values of these flags (the command line takes precedence), reloaded on SIGHUP, see README")
 	flag.Parse()
+	var config *internal.ConfigFile
+	if *configPtr != "" {
+		var err error
+		if config, err = internal.LoadConfigFile(flag.CommandLine, *configPtr); err != nil {
+			slog.Error("could not load the config file", "path", *configPtr, "error", err)
+			return
+		}
+	}
+	slog.SetLogLoggerLevel(logLevel)
 	if *dbPtr == "" {
 		slog.Error("database directory path is required")
 		return
 	}
+	mergeWindow, err := internal.ParseMergeWindow(*mergeWindowPtr)
+	if err != nil {
+		slog.Error("invalid value for -merge-window", "error", err)
+		return
+	}
+	keyspaceEvents, ok := internal.ParseKeyspaceEvents(*notifyKeyspaceEventsPtr)
+	if !ok {
+		slog.Error("invalid value for -notify-keyspace-events", "value", *notifyKeyspaceEventsPtr)
+		return
+	}
+	var acl *internal.ACL
+	if *aclFilePtr != "" {
+		var err error
+		if acl, err = internal.LoadACL(*aclFilePtr); err != nil {
+			slog.Error("could not load the ACL file", "path", *aclFilePtr, "error", err)
+			return
+		}
+	}
 	address := fmt.Sprintf("%s:%d", *hostPtr, *portPtr)
 
 	ctx := context.Background()
@@ -29,22 +93,86 @@ func main() {
 		slog.Error("listen failed", "error", err)
 		return
 	}
-	store := internal.NewKVStore(*dbPtr)
-	store.StartBackgroundSync()
-	store.StartBackgroundMerge()
+	store := internal.NewKVStoreWithOptions(*dbPtr, &kvdb.Options{GroupCommit: *groupCommitPtr, GroupCommitSync: *groupCommitSyncPtr, MaxOpenFiles: *maxOpenFilesPtr, MaxValueSize: int(*protoMaxBulkLenPtr)})
 	if store == nil {
 		slog.Error("datastore could not be openend, exiting")
 		os.Exit(1)
 	}
-	defer store.Close()
+	for _, path := range attachPaths {
+		if _, err := store.Attach(path); err != nil {
+			slog.Error("could not attach the datastore", "path", path, "error", err)
+			store.Close()
+			os.Exit(1)
+		}
+	}
+	store.RequirePass = *requirePassPtr
+	store.ACL = acl
+	store.SetMaxClients(*maxClientsPtr)
+	store.IdleTimeout = *idleTimeoutPtr
+	store.ReadTimeout = *readTimeoutPtr
+	store.ProtoMaxBulkLen = *protoMaxBulkLenPtr
+	store.PrimaryAuth = *primaryAuthPtr
+	store.KeysTimeout = *keysTimeoutPtr
+	store.CompactTimeout = *compactTimeoutPtr
+	store.BackupDir = *backupDirPtr
+	store.AttachDir = *attachDirPtr
+	store.StandbyInterval = *standbyIntervalPtr
+	store.SlowLog = internal.NewSlowLog(*slowlogThresholdPtr, *slowlogMaxLenPtr)
+	store.SetMergeInterval(*mergeIntervalPtr)
+	store.SetMergeWindow(mergeWindow)
+	store.SetMergeMaxWriteRate(*mergeMaxWriteRatePtr)
+	store.EnableKeyspaceNotifications(keyspaceEvents)
+	if *replicaOfPtr != "" {
+		store.ReplicaOf(*replicaOfPtr)
+	}
+	if *standbyOfPtr != "" {
+		store.StandbyOf(*standbyOfPtr)
+	}
+	if *metricsAddrPtr != "" {
+		store.StartMetricsServer(*metricsAddrPtr)
+	}
+	store.StartBackgroundSync()
+	store.StartReplicationHeartbeat()
+	store.StartBackgroundMerge()
+	if *backupIntervalPtr > 0 && *backupDirPtr != "" {
+		store.StartBackgroundBackup(*backupIntervalPtr)
+	}
+	if config != nil {
+		// Only the settings that can change while the server is running are applied, see config.go
+		hangups := make(chan os.Signal, 1)
+		signal.Notify(hangups, syscall.SIGHUP)
+		go func() {
+			for range hangups {
+				if err := config.Reload(store); err != nil {
+					slog.Error("config reload failed", "path", config.Path, "error", err)
+					continue
+				}
+				slog.Info("config reloaded", "path", config.Path)
+			}
+		}()
+	}
 	slog.Info("server listening", "address", listener.Addr().String(), "datastore", store.Path)
-	defer listener.Close()
+	// SHUTDOWN closes the store (unless NOSAVE is given), the server stops accepting connections once it's done
+	go func() {
+		<-store.Done()
+		listener.Close()
+	}()
 	for {
 		conn, err := listener.Accept()
 		if err != nil {
+			select {
+			case <-store.Done():
+				slog.Info("server shut down")
+				return
+			default:
+			}
 			slog.Warn("accept failed", "error", err)
 			continue
 		}
+		if !store.AcquireClient() {
+			go store.Reject(conn)
+			continue
+		}
 		go store.Handle(conn)
 	}
 }
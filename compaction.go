@@ -0,0 +1,235 @@
+package kvdb
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+/*
+Compaction coordinator
+
+Every datastore merges independently, so a process that runs many datastores (one per tenant, for example) would
+merge all of them at the same time if they were all merged on a timer, and saturate the disk. A CompactionCoordinator
+owns the merges of the datastores registered with it: at most MaxConcurrentMerges merges run at a time across all of
+them, and on every round the datastores are merged in order of fragmentation (the fraction of the merged files that a
+merge would reclaim), so the datastores that gain the most are merged first. Datastores whose merge would reclaim
+less than the thresholds are skipped.
+
+Merges that are requested directly (for example by an admin endpoint) should go through Merge on the coordinator, so
+that they count towards the same limit
+*/
+
+const (
+	defaultCompactionInterval = time.Minute
+	defaultMaxConcurrentMerge = 1
+)
+
+// CompactionOptions configures a CompactionCoordinator
+type CompactionOptions struct {
+	// Maximum number of merges running at the same time across all registered datastores, defaults to 1
+	MaxConcurrentMerges int
+	// Time between compaction rounds, defaults to one minute
+	Interval time.Duration
+	// A datastore is only merged if the merge would reclaim at least MinReclaimedBytes, and at least MinFragmentation
+	// (between 0 and 1) of the size of the merged files. The merge of a datastore whose ReclaimableBytes are below
+	// MinReclaimedBytes is not estimated
+	MinReclaimedBytes int64
+	MinFragmentation  float64
+}
+
+// CompactionResult describes a merge run by a compaction round
+type CompactionResult struct {
+	Name     string
+	Estimate MergeEstimate
+	Duration time.Duration
+	Err      error
+}
+
+// Fragmentation returns the fraction of the merged files that the merge was estimated to reclaim
+func (r CompactionResult) Fragmentation() float64 {
+	return fragmentation(r.Estimate)
+}
+
+func fragmentation(estimate MergeEstimate) float64 {
+	if estimate.InputBytes == 0 {
+		return 0
+	}
+	return float64(estimate.ReclaimedBytes) / float64(estimate.InputBytes)
+}
+
+// CompactionCoordinator schedules the merges of several datastores, see NewCompactionCoordinator
+type CompactionCoordinator struct {
+	options CompactionOptions
+	// A token is taken from slots for every running merge
+	slots chan struct{}
+
+	mu     sync.Mutex
+	stores map[*DataStore]string
+	// Datastores that are being merged, a datastore is never queued twice
+	merging map[*DataStore]bool
+
+	stop    chan struct{}
+	stopped chan struct{}
+}
+
+// NewCompactionCoordinator returns a coordinator with the given options (the defaults are used if opts is nil). Register
+// the datastores, and call Start to merge them in the background, or RunOnce to run a single round
+func NewCompactionCoordinator(opts *CompactionOptions) *CompactionCoordinator {
+	var options CompactionOptions
+	if opts != nil {
+		options = *opts
+	}
+	if options.MaxConcurrentMerges <= 0 {
+		options.MaxConcurrentMerges = defaultMaxConcurrentMerge
+	}
+	if options.Interval <= 0 {
+		options.Interval = defaultCompactionInterval
+	}
+	return &CompactionCoordinator{
+		options: options,
+		slots:   make(chan struct{}, options.MaxConcurrentMerges),
+		stores:  map[*DataStore]string{},
+		merging: map[*DataStore]bool{},
+	}
+}
+
+// Register adds the datastore to the coordinator, name identifies it in the results. The returned function removes it,
+// it should be called when the datastore is closed. Closing a datastore cancels it's running merge, and merges of a
+// closed datastore fail with ErrClosed
+func (c *CompactionCoordinator) Register(name string, store *DataStore) (unregister func()) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.stores[store] = name
+	return func() {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		delete(c.stores, store)
+	}
+}
+
+// Merge merges the datastore once a merge slot is free, so that merges requested outside of the compaction rounds
+// count towards MaxConcurrentMerges. The datastore does not have to be registered
+func (c *CompactionCoordinator) Merge(store *DataStore) error {
+	c.slots <- struct{}{}
+	defer func() { <-c.slots }()
+	return store.Merge()
+}
+
+// RunOnce runs a compaction round: every registered datastore that is above the thresholds (and is not being merged) is
+// merged, in decreasing order of fragmentation, with at most MaxConcurrentMerges merges at a time. It returns after all
+// the merges have finished, with one result per merged datastore in the order in which they were started. Datastores
+// that are unregistered during the round are not merged
+func (c *CompactionCoordinator) RunOnce() []CompactionResult {
+	type candidate struct {
+		store    *DataStore
+		name     string
+		estimate MergeEstimate
+	}
+	c.mu.Lock()
+	stores := make(map[*DataStore]string, len(c.stores))
+	for store, name := range c.stores {
+		if !c.merging[store] {
+			stores[store] = name
+		}
+	}
+	c.mu.Unlock()
+
+	var candidates []candidate
+	for store, name := range stores {
+		// ReclaimableBytes is tracked as keys are written, EstimateMerge scans the keydir
+		if c.options.MinReclaimedBytes > 0 && store.ReclaimableBytes() < c.options.MinReclaimedBytes {
+			continue
+		}
+		estimate, err := store.EstimateMerge()
+		if err != nil || len(estimate.Files) == 0 {
+			continue
+		}
+		if estimate.ReclaimedBytes < c.options.MinReclaimedBytes || fragmentation(estimate) < c.options.MinFragmentation {
+			continue
+		}
+		candidates = append(candidates, candidate{store: store, name: name, estimate: estimate})
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		fi, fj := fragmentation(candidates[i].estimate), fragmentation(candidates[j].estimate)
+		if fi != fj {
+			return fi > fj
+		}
+		return candidates[i].estimate.ReclaimedBytes > candidates[j].estimate.ReclaimedBytes
+	})
+
+	results := make([]CompactionResult, len(candidates))
+	started := make([]bool, len(candidates))
+	var wg sync.WaitGroup
+	for i, cand := range candidates {
+		c.mu.Lock()
+		_, registered := c.stores[cand.store]
+		if !registered || c.merging[cand.store] {
+			c.mu.Unlock()
+			continue
+		}
+		started[i] = true
+		c.merging[cand.store] = true
+		c.mu.Unlock()
+
+		// Wait for a free slot before starting the next merge, so that merges start in priority order
+		c.slots <- struct{}{}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			start := time.Now()
+			err := cand.store.Merge()
+			<-c.slots
+			c.mu.Lock()
+			delete(c.merging, cand.store)
+			c.mu.Unlock()
+			results[i] = CompactionResult{Name: cand.name, Estimate: cand.estimate, Duration: time.Since(start), Err: err}
+		}()
+	}
+	wg.Wait()
+
+	merged := results[:0]
+	for i, result := range results {
+		if started[i] {
+			merged = append(merged, result)
+		}
+	}
+	return merged
+}
+
+// Start runs a compaction round every Interval in the background, until Stop is called
+func (c *CompactionCoordinator) Start() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.stop != nil {
+		return
+	}
+	c.stop = make(chan struct{})
+	c.stopped = make(chan struct{})
+	go func(stop, stopped chan struct{}) {
+		defer close(stopped)
+		ticker := time.NewTicker(c.options.Interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				c.RunOnce()
+			}
+		}
+	}(c.stop, c.stopped)
+}
+
+// Stop stops the background rounds started by Start, and waits for the current round to finish
+func (c *CompactionCoordinator) Stop() {
+	c.mu.Lock()
+	stop, stopped := c.stop, c.stopped
+	c.stop, c.stopped = nil, nil
+	c.mu.Unlock()
+	if stop == nil {
+		return
+	}
+	close(stop)
+	<-stopped
+}
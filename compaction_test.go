@@ -0,0 +1,119 @@
+package kvdb
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/spf13/afero"
+)
+
+// helperFragmentedStore creates a datastore whose immutable file has the given number of overwrites of a single key
+// next to ten keys that are written once, and an active file
+func helperFragmentedStore(t *testing.T, fs afero.Fs, path string, overwrites int) *DataStore {
+	t.Helper()
+	store := helperCreateMultipleDataFiles(t, fs, path)
+	for i := range 10 {
+		store.Put(fmt.Appendf(nil, "key%d", i), []byte("value"))
+	}
+	for range overwrites {
+		store.Put([]byte("counter"), []byte("value"))
+	}
+	store.Close()
+	store, err := Open(fs, path)
+	if err != nil {
+		t.Fatalf("failed to reopen store: %v", err)
+	}
+	store.Put([]byte("active"), []byte("value"))
+	return store
+}
+
+func TestCompactionRoundOrder(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	low := helperFragmentedStore(t, fs, "test_compaction_low.db", 5)
+	defer low.Close()
+	high := helperFragmentedStore(t, fs, "test_compaction_high.db", 50)
+	defer high.Close()
+	clean := helperFragmentedStore(t, fs, "test_compaction_clean.db", 0)
+	defer clean.Close()
+
+	coordinator := NewCompactionCoordinator(&CompactionOptions{MinFragmentation: 0.1})
+	coordinator.Register("low", low)
+	coordinator.Register("high", high)
+	unregister := coordinator.Register("clean", clean)
+	defer unregister()
+
+	results := coordinator.RunOnce()
+	if len(results) != 2 {
+		t.Fatalf("expected 2 merges, got %+v", results)
+	}
+	if results[0].Name != "high" || results[1].Name != "low" {
+		t.Errorf("expected the most fragmented store to be merged first, got %s, %s", results[0].Name, results[1].Name)
+	}
+	for _, result := range results {
+		if result.Err != nil || result.Fragmentation() < 0.1 {
+			t.Errorf("unexpected result %+v", result)
+		}
+	}
+	if estimate, _ := high.EstimateMerge(); estimate.ReclaimedBytes != 0 {
+		t.Errorf("expected nothing left to reclaim after the merge, got %+v", estimate)
+	}
+
+	// Nothing is above the threshold any more
+	if results := coordinator.RunOnce(); len(results) != 0 {
+		t.Errorf("expected no merges, got %+v", results)
+	}
+}
+
+func TestCompactionLimitsConcurrentMerges(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	store := helperFragmentedStore(t, fs, "test_compaction_limit.db", 5)
+	defer store.Close()
+	coordinator := NewCompactionCoordinator(nil)
+	coordinator.Register("store", store)
+
+	// Take the only slot, as if another merge was running
+	coordinator.slots <- struct{}{}
+	done := make(chan []CompactionResult)
+	go func() {
+		done <- coordinator.RunOnce()
+	}()
+	select {
+	case <-done:
+		t.Fatalf("expected the round to wait for a free slot")
+	case <-time.After(50 * time.Millisecond):
+	}
+	<-coordinator.slots
+	select {
+	case results := <-done:
+		if len(results) != 1 || results[0].Err != nil {
+			t.Errorf("unexpected results %+v", results)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("round did not finish after the slot was freed")
+	}
+}
+
+func TestCompactionStartStop(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	store := helperFragmentedStore(t, fs, "test_compaction_start.db", 5)
+	defer store.Close()
+	coordinator := NewCompactionCoordinator(&CompactionOptions{Interval: 10 * time.Millisecond})
+	coordinator.Register("store", store)
+	merged := make(chan struct{}, 1)
+	store.WatchMerges(func(event MergeEvent) {
+		select {
+		case merged <- struct{}{}:
+		default:
+		}
+	})
+
+	coordinator.Start()
+	select {
+	case <-merged:
+	case <-time.After(5 * time.Second):
+		t.Errorf("expected a background merge")
+	}
+	coordinator.Stop()
+	coordinator.Stop()
+}
@@ -0,0 +1,177 @@
+package kvdb
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/ananthvk/kvdb/internal/keydir"
+)
+
+/*
+Content-addressed storage
+
+PutContent stores a value under the SHA-256 hash of it's contents, and returns the hash, GetContent reads it back. A
+value that is already stored is not written again, instead the number of references to it is incremented, and
+ReleaseContent decrements it. Once a value has no references, it's removed by the next merge of the data file that
+holds it.
+
+The value is stored as a write-once key (see PutOptions.WriteOnce) made of contentKeyPrefix and the hash, and it's
+reference count as a normal key made of contentRefsPrefix and the hash, with the count as an 8 byte big-endian integer.
+The count is deleted when it reaches zero, so a value without a count key is garbage. Both prefixes start with a zero
+byte, keys that start with them are reserved, and should not be written with Put.
+
+A merge does not copy the values without references, and removes them from the keydir when it's committed, unless
+PutContent stored the value again in the meantime. Since a write-once key can't be overwritten, PutContent writes a
+value without references again (as a new record) instead of reusing the record that the merge is about to drop
+*/
+
+const (
+	contentKeyPrefix  = "\x00cas/"
+	contentRefsPrefix = "\x00casrefs/"
+)
+
+// ContentHashSize is the size of the hashes returned by PutContent
+const ContentHashSize = sha256.Size
+
+// ErrInvalidContentHash is returned by the content helpers if the hash is not ContentHashSize bytes long
+var ErrInvalidContentHash = errors.New("invalid content hash")
+
+func contentKey(hash []byte) []byte {
+	return append([]byte(contentKeyPrefix), hash...)
+}
+
+func contentRefsKey(hash []byte) []byte {
+	return append([]byte(contentRefsPrefix), hash...)
+}
+
+// isContentKey returns true if the key is the key of a value stored by PutContent
+func isContentKey(key []byte) bool {
+	return bytes.HasPrefix(key, []byte(contentKeyPrefix))
+}
+
+// PutContent stores the value under it's SHA-256 hash, and returns the hash. If the value is already stored, it's not
+// written again, and it's reference count is incremented
+func (dataStore *DataStore) PutContent(value []byte) ([]byte, error) {
+	if err := dataStore.gate.enter(); err != nil {
+		return nil, err
+	}
+	defer dataStore.gate.exit()
+	sum := sha256.Sum256(value)
+	hash := sum[:]
+	dataStore.lockForWrite("datastore.put_content")
+	defer dataStore.mu.Unlock()
+
+	refs, err := dataStore.contentRefs(hash)
+	if err != nil {
+		return nil, err
+	}
+	key := contentKey(hash)
+	existing, exists := dataStore.keydir.GetKeydirRecord(key)
+	if !exists || refs == 0 {
+		// A value without references may be dropped by a running merge, so it's written again
+		if exists {
+			dataStore.keydir.DeleteRecordWithExists(key)
+		}
+		if err := dataStore.putRecord(key, value, dataStore.nextTimestamp(key), true, ValueTypeString); err != nil {
+			if exists {
+				dataStore.keydir.Add(key, existing)
+			}
+			return nil, err
+		}
+	}
+	if err := dataStore.put(contentRefsKey(hash), binary.BigEndian.AppendUint64(nil, refs+1)); err != nil {
+		return nil, err
+	}
+	return hash, nil
+}
+
+// GetContent returns the value stored by PutContent with the given hash. If there is no such value, or it has no
+// references, ErrKeyNotFound is returned
+func (dataStore *DataStore) GetContent(hash []byte) ([]byte, error) {
+	if len(hash) != ContentHashSize {
+		return nil, fmt.Errorf("%w: %d bytes", ErrInvalidContentHash, len(hash))
+	}
+	if err := dataStore.gate.enter(); err != nil {
+		return nil, err
+	}
+	defer dataStore.gate.exit()
+	dataStore.lockProfiler.Lock(dataStore.mu.RLocker(), "datastore.get_content")
+	defer dataStore.mu.RUnlock()
+	if _, ok := dataStore.keydir.GetKeydirRecord(contentRefsKey(hash)); !ok {
+		return nil, ErrKeyNotFound
+	}
+	dataStore.counters.gets.Add(1)
+	return dataStore.get(contentKey(hash))
+}
+
+// ReleaseContent removes a reference to the value with the given hash, and returns the number of references that are
+// left. The value is removed by a merge once it has no references. If the value has no references, ErrKeyNotFound is
+// returned
+func (dataStore *DataStore) ReleaseContent(hash []byte) (uint64, error) {
+	if len(hash) != ContentHashSize {
+		return 0, fmt.Errorf("%w: %d bytes", ErrInvalidContentHash, len(hash))
+	}
+	if err := dataStore.gate.enter(); err != nil {
+		return 0, err
+	}
+	defer dataStore.gate.exit()
+	dataStore.lockForWrite("datastore.release_content")
+	defer dataStore.mu.Unlock()
+
+	refs, err := dataStore.contentRefs(hash)
+	if err != nil {
+		return 0, err
+	}
+	if refs == 0 {
+		return 0, ErrKeyNotFound
+	}
+	refs--
+	if refs == 0 {
+		_, err = dataStore.deleteKey(contentRefsKey(hash))
+	} else {
+		err = dataStore.put(contentRefsKey(hash), binary.BigEndian.AppendUint64(nil, refs))
+	}
+	if err != nil {
+		return 0, err
+	}
+	return refs, nil
+}
+
+// contentRefs returns the reference count of the value with the given hash, the caller must hold the lock
+func (dataStore *DataStore) contentRefs(hash []byte) (uint64, error) {
+	value, err := dataStore.get(contentRefsKey(hash))
+	if errors.Is(err, ErrKeyNotFound) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	if len(value) != 8 {
+		return 0, fmt.Errorf("invalid reference count of content %x", hash)
+	}
+	return binary.BigEndian.Uint64(value), nil
+}
+
+// isGarbageContent returns true if the key is the key of a value stored by PutContent that has no references, the
+// caller must hold the lock
+func (dataStore *DataStore) isGarbageContent(key []byte) bool {
+	if !isContentKey(key) {
+		return false
+	}
+	_, referenced := dataStore.keydir.GetKeydirRecord(contentRefsKey(key[len(contentKeyPrefix):]))
+	return !referenced
+}
+
+// dropGarbageContent removes the values without references that were not copied by a merge from the keydir, unless
+// they were written again. The caller must hold the write lock
+func (dataStore *DataStore) dropGarbageContent(dropped map[string]keydir.KeydirRecord) {
+	for key, dropped := range dropped {
+		current, ok := dataStore.keydir.GetKeydirRecord([]byte(key))
+		if ok && current.FileId == dropped.FileId && current.ValuePos == dropped.ValuePos && dataStore.isGarbageContent([]byte(key)) {
+			dataStore.keydir.DeleteRecordWithExists([]byte(key))
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package kvdb
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"testing"
+
+	"github.com/spf13/afero"
+)
+
+func TestContentDeduplication(t *testing.T) {
+	store, err := Create(afero.NewMemMapFs(), "test_content.db")
+	if err != nil {
+		t.Fatalf("could not create datastore: %v", err)
+	}
+	defer store.Close()
+
+	blob := bytes.Repeat([]byte("blob"), 100)
+	hash, err := store.PutContent(blob)
+	if err != nil {
+		t.Fatalf("put content failed: %v", err)
+	}
+	if expected := sha256.Sum256(blob); !bytes.Equal(hash, expected[:]) {
+		t.Errorf("expected the hash to be the SHA-256 of the value, got %x", hash)
+	}
+	stats, _ := store.Stats()
+	bytesAfterFirst := stats.DataFileBytes
+	if _, err := store.PutContent(blob); err != nil {
+		t.Fatalf("put content failed: %v", err)
+	}
+	stats, _ = store.Stats()
+	if stats.DataFileBytes-bytesAfterFirst >= int64(len(blob)) {
+		t.Errorf("expected the value not to be written again, %d bytes were written", stats.DataFileBytes-bytesAfterFirst)
+	}
+	if got, err := store.GetContent(hash); err != nil || !bytes.Equal(got, blob) {
+		t.Errorf("expected the value, got %d bytes, %v", len(got), err)
+	}
+	if err := store.Put(contentKey(hash), []byte("other")); !errors.Is(err, ErrImmutableKey) {
+		t.Errorf("expected the value to be write-once, got %v", err)
+	}
+
+	if refs, err := store.ReleaseContent(hash); err != nil || refs != 1 {
+		t.Errorf("expected 1 reference left, got %d, %v", refs, err)
+	}
+	if refs, err := store.ReleaseContent(hash); err != nil || refs != 0 {
+		t.Errorf("expected no references left, got %d, %v", refs, err)
+	}
+	if _, err := store.ReleaseContent(hash); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("expected ErrKeyNotFound when releasing a value without references, got %v", err)
+	}
+	if _, err := store.GetContent(hash); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("expected ErrKeyNotFound for a value without references, got %v", err)
+	}
+	if _, err := store.GetContent([]byte("short")); !errors.Is(err, ErrInvalidContentHash) {
+		t.Errorf("expected ErrInvalidContentHash, got %v", err)
+	}
+
+	// A value without references can be stored again before it's merged
+	if _, err := store.PutContent(blob); err != nil {
+		t.Fatalf("put content failed: %v", err)
+	}
+	if got, err := store.GetContent(hash); err != nil || !bytes.Equal(got, blob) {
+		t.Errorf("expected the value, got %d bytes, %v", len(got), err)
+	}
+}
+
+func TestContentWithoutReferencesIsMerged(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	path := "test_content_merge.db"
+	store, err := Create(fs, path)
+	if err != nil {
+		t.Fatalf("could not create datastore: %v", err)
+	}
+	kept, _ := store.PutContent([]byte("kept"))
+	released, _ := store.PutContent([]byte("released"))
+	store.ReleaseContent(released)
+	store.Close()
+
+	store, err = Open(fs, path)
+	if err != nil {
+		t.Fatalf("could not open datastore: %v", err)
+	}
+	// The first write after opening starts a new active file, so the old one is merged
+	store.Put([]byte("key"), []byte("value"))
+	if err := store.Merge(); err != nil {
+		t.Fatalf("merge failed: %v", err)
+	}
+	if _, ok := store.keydir.GetKeydirRecord(contentKey(released)); ok {
+		t.Errorf("expected the value without references to be removed by the merge")
+	}
+	if got, err := store.GetContent(kept); err != nil || string(got) != "kept" {
+		t.Errorf("expected the referenced value to be kept, got %q, %v", got, err)
+	}
+	store.Close()
+
+	store, err = Open(fs, path)
+	if err != nil {
+		t.Fatalf("could not open datastore: %v", err)
+	}
+	defer store.Close()
+	if _, ok := store.keydir.GetKeydirRecord(contentKey(released)); ok {
+		t.Errorf("expected the value without references to be gone after a restart")
+	}
+	if got, err := store.GetContent(kept); err != nil || string(got) != "kept" {
+		t.Errorf("expected the referenced value after a restart, got %q, %v", got, err)
+	}
+}
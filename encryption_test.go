@@ -0,0 +1,167 @@
+package kvdb
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/spf13/afero"
+)
+
+var (
+	encryptionKey    = bytes.Repeat([]byte{0x11}, 32)
+	newEncryptionKey = bytes.Repeat([]byte{0x22}, 32)
+)
+
+// containsInDataDir returns true if a data or hint file of the datastore contains s
+func containsInDataDir(t *testing.T, fs afero.Fs, path, s string) bool {
+	t.Helper()
+	found := false
+	afero.Walk(fs, path, func(p string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() || !(strings.HasSuffix(p, ".dat") || strings.HasSuffix(p, ".hint")) {
+			return err
+		}
+		data, err := afero.ReadFile(fs, p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		found = found || bytes.Contains(data, []byte(s))
+		return nil
+	})
+	return found
+}
+
+// checkEncryptedValues checks that the datastore has key0..key9 with value0..value9
+func checkEncryptedValues(t *testing.T, store *DataStore) {
+	t.Helper()
+	for i := range 10 {
+		if value, err := store.Get(fmt.Appendf(nil, "key%d", i)); err != nil || string(value) != fmt.Sprintf("value%d", i) {
+			t.Errorf("key%d: expected value%d, got %q, %v", i, i, value, err)
+		}
+	}
+}
+
+func TestEncryption(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	opts := &Options{EncryptionKey: encryptionKey}
+	store, err := CreateWithOptions(fs, "encrypted.db", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range 10 {
+		store.Put(fmt.Appendf(nil, "key%d", i), fmt.Appendf(nil, "value%d", i))
+	}
+	store.Close()
+
+	// Merge the file, so that it gets a hint file
+	store, err = OpenWithOptions(fs, "encrypted.db", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Merge(); err != nil {
+		t.Fatalf("merge failed: %v", err)
+	}
+	checkEncryptedValues(t, store)
+	store.Close()
+	if containsInDataDir(t, fs, "encrypted.db", "key3") || containsInDataDir(t, fs, "encrypted.db", "value3") {
+		t.Error("found a key or value in the clear")
+	}
+
+	if _, err := Open(fs, "encrypted.db"); !errors.Is(err, ErrNoEncryptionKey) {
+		t.Errorf("expected ErrNoEncryptionKey without the key, got %v", err)
+	}
+	if _, err := OpenWithOptions(fs, "encrypted.db", &Options{EncryptionKey: newEncryptionKey}); !errors.Is(err, ErrDecrypt) {
+		t.Errorf("expected ErrDecrypt with another key, got %v", err)
+	}
+
+	store, err = OpenWithOptions(fs, "encrypted.db", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	if report := store.OpenReport(); len(report.IgnoredHintFiles) != 0 || len(report.InvalidDataFiles) != 0 {
+		t.Errorf("expected the encrypted hint file to be used, got %+v", report)
+	}
+	checkEncryptedValues(t, store)
+	if result, err := store.Verify(); err != nil || !result.OK() {
+		t.Errorf("expected the encrypted datastore to verify, got %+v, %v", result, err)
+	}
+}
+
+func TestEncryptionKeyRotation(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	store, err := CreateWithOptions(fs, "rotate.db", &Options{EncryptionKey: encryptionKey})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range 10 {
+		store.Put(fmt.Appendf(nil, "key%d", i), fmt.Appendf(nil, "value%d", i))
+	}
+	store.Close()
+
+	// The files written with the old key are rewritten with the new key by a merge, once the write seals the file that
+	// was active
+	rotated := &Options{EncryptionKey: newEncryptionKey, PreviousEncryptionKeys: [][]byte{encryptionKey}}
+	store, err = OpenWithOptions(fs, "rotate.db", rotated)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkEncryptedValues(t, store)
+	store.Put([]byte("rotated"), []byte("yes"))
+	if err := store.Merge(); err != nil {
+		t.Fatalf("merge failed: %v", err)
+	}
+	store.Close()
+
+	store, err = OpenWithOptions(fs, "rotate.db", &Options{EncryptionKey: newEncryptionKey})
+	if err != nil {
+		t.Fatalf("expected the datastore to open with only the new key, got %v", err)
+	}
+	checkEncryptedValues(t, store)
+	store.Close()
+
+	// With only previous keys, a merge decrypts the datastore
+	store, err = OpenWithOptions(fs, "rotate.db", &Options{PreviousEncryptionKeys: [][]byte{newEncryptionKey}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.Put([]byte("decrypted"), []byte("yes"))
+	store.Merge()
+	store.Close()
+	store, err = Open(fs, "rotate.db")
+	if err != nil {
+		t.Fatalf("expected the decrypted datastore to open without keys, got %v", err)
+	}
+	defer store.Close()
+	checkEncryptedValues(t, store)
+}
+
+func TestEncryptionKeyFile(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	afero.WriteFile(fs, "kvdb.key", []byte(hex.EncodeToString(encryptionKey)+"\n"), 0600)
+	store, err := CreateWithOptions(fs, "keyfile.db", &Options{EncryptionKeyFile: "kvdb.key"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.Put([]byte("key0"), []byte("value0"))
+	store.Close()
+
+	// The key in the file is the same as the raw key
+	store, err = OpenWithOptions(fs, "keyfile.db", &Options{EncryptionKey: encryptionKey})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	if value, err := store.Get([]byte("key0")); err != nil || string(value) != "value0" {
+		t.Errorf("expected value0, got %q, %v", value, err)
+	}
+
+	afero.WriteFile(fs, "short.key", []byte("short"), 0600)
+	if _, err := CreateWithOptions(fs, "other.db", &Options{EncryptionKeyFile: "short.key"}); err == nil {
+		t.Error("expected an error for a key of an invalid size")
+	}
+}
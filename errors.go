@@ -1,8 +1,108 @@
 package kvdb
 
-import "errors"
+import (
+	"errors"
+
+	"github.com/ananthvk/kvdb/internal/filemanager"
+	"github.com/ananthvk/kvdb/internal/jsonpointer"
+	"github.com/ananthvk/kvdb/internal/kverrors"
+	"github.com/ananthvk/kvdb/internal/record"
+)
+
+/*
+Error kinds
+
+The errors below are sentinel errors, and are checked with errors.Is. Some of them also belong to a kind, so that
+callers can check for a whole group of errors without listing them:
+
+  - ErrCorrupt: a file does not have the expected contents (ErrInvalidDataFile, a record or hint whose checksum does not
+    match, a data file with an invalid header, ...)
+  - ErrTooLarge: ErrKeyTooLarge and ErrValueTooLarge
+  - ErrReadOnly: the key can't be changed (ErrImmutableKey)
+  - ErrLocked: the key is held by another owner (ErrLeaseHeld)
+
+ErrStoreClosed is the same error as ErrClosed.
+
+Errors from reading a data file (a Get of a damaged record, a data file that can't be read while the datastore is
+opened, ...) are wrapped in a *FileError, which has the path of the file, and the offset of the record. errors.As finds
+it, and errors.Is still matches the error it wraps:
+
+	var fileErr *kvdb.FileError
+	if errors.Is(err, kvdb.ErrCorrupt) && errors.As(err, &fileErr) {
+		log.Printf("damaged record at offset %d of %s", fileErr.Offset, fileErr.Path)
+	}
+*/
+
+// FileError is an error that happened while reading a file, with the path of the file, and the offset of the record
+// (-1 if the error is not about a record), see errors.go
+type FileError = kverrors.FileError
 
 var (
+	// Error kinds, see errors.go
+	ErrCorrupt  = kverrors.ErrCorrupt
+	ErrTooLarge = kverrors.ErrTooLarge
+	ErrReadOnly = kverrors.ErrReadOnly
+	ErrLocked   = kverrors.ErrLocked
+
 	ErrKeyNotFound = errors.New("key not found")
 	ErrNotExist    = errors.New("datastore does not exist")
+
+	// Returned by operations on a datastore that has been closed (or is being closed), see Close
+	ErrClosed = errors.New("datastore is closed")
+	// ErrStoreClosed is ErrClosed
+	ErrStoreClosed = ErrClosed
+
+	// Returned by writes of keys or values larger than the limits of the datastore, see Options.MaxKeySize
+	ErrKeyTooLarge   = record.ErrKeyTooLarge
+	ErrValueTooLarge = record.ErrValueTooLarge
+
+	// Returned by writes with a nil key (an empty, non nil key is valid)
+	ErrNilKey = errors.New("key is nil")
+
+	// Returned by AdoptFile if the file is not a valid data file
+	ErrInvalidDataFile = kverrors.New(ErrCorrupt, "invalid data file")
+
+	// Returned when a write is rejected by a WriteInterceptor
+	ErrWriteRejected = errors.New("write rejected")
+
+	// Wrapped by the *StallError returned by writes while the datastore is stalled, see Options.RejectStalledWrites
+	ErrWriteStalled = errors.New("write stalled")
+
+	// Wrapped by the *MemoryLimitError returned by writes that would take the keydir over Options.KeydirMemoryLimit
+	ErrMemoryLimit = errors.New("keydir memory limit reached")
+
+	// Returned by GetReader if the value reader would take the datastore over Options.MaxOpenFiles
+	ErrOpenFileLimit = filemanager.ErrOpenFileLimit
+
+	// Returned when a data file id is used by more than one file, see FileIdAllocator
+	ErrFileIdCollision = filemanager.ErrFileIdCollision
+
+	// Returned by LogTailer.Next if the position it's reading from no longer exists, because the data file was merged
+	ErrLogTruncated = errors.New("log position no longer exists")
+
+	// Returned by writes and deletes of a write-once key, see PutOptions.WriteOnce
+	ErrImmutableKey = kverrors.New(ErrReadOnly, "key is write-once")
+
+	// Returned by PutVersion if the key is not at the expected version, i.e. another writer got in first
+	ErrVersionMismatch = errors.New("version mismatch")
+
+	// Returned by AcquireLease if another owner has a lease on the key that has not expired
+	ErrLeaseHeld = kverrors.New(ErrLocked, "lease is held by another owner")
+	// Returned by Lease.Renew and Lease.Release if the lease has expired, or the key has a newer lease
+	ErrLeaseLost = errors.New("lease is no longer held")
+	// Returned by the lease helpers if the value of the key is not a lease
+	ErrNotLease = errors.New("value is not a lease")
+
+	// Wrapped by the *WrongTypeError returned by the methods of a structured type (like Hash) when the key holds a value
+	// of another type, see types.go
+	ErrWrongType = errors.New("key holds a value of another type")
+
+	// Returned by OpenWithOptions if the datastore has encrypted data files, and no encryption key was given, and by reads
+	// of encrypted records whose key is not in the options, see Options.EncryptionKey
+	ErrNoEncryptionKey = record.ErrNoKey
+	ErrDecrypt         = record.ErrDecrypt
+
+	// Returned by the JSON helpers
+	ErrInvalidJSONPath  = jsonpointer.ErrInvalidPointer
+	ErrJSONPathNotFound = jsonpointer.ErrPathNotFound
 )
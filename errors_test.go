@@ -0,0 +1,93 @@
+package kvdb
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ananthvk/kvdb/internal/utils"
+	"github.com/spf13/afero"
+)
+
+func TestErrorKinds(t *testing.T) {
+	kinds := []struct {
+		err, kind error
+	}{
+		{ErrKeyTooLarge, ErrTooLarge},
+		{ErrValueTooLarge, ErrTooLarge},
+		{ErrInvalidDataFile, ErrCorrupt},
+		{ErrImmutableKey, ErrReadOnly},
+		{ErrLeaseHeld, ErrLocked},
+		{ErrStoreClosed, ErrClosed},
+	}
+	for _, k := range kinds {
+		if !errors.Is(k.err, k.kind) {
+			t.Errorf("expected %q to be a %q error", k.err, k.kind)
+		}
+	}
+	if errors.Is(ErrKeyTooLarge, ErrValueTooLarge) || errors.Is(ErrTooLarge, ErrKeyTooLarge) {
+		t.Error("expected errors of the same kind to stay distinct")
+	}
+	if ErrKeyTooLarge.Error() != "key too large" {
+		t.Errorf("expected the message not to change, got %q", ErrKeyTooLarge)
+	}
+
+	store, err := Create(afero.NewMemMapFs(), "error_kinds.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.PutWithOptions([]byte("once"), []byte("v"), &PutOptions{WriteOnce: true})
+	if err := store.Put([]byte("once"), []byte("w")); !errors.Is(err, ErrReadOnly) {
+		t.Errorf("expected ErrReadOnly for a write-once key, got %v", err)
+	}
+	if _, err := store.AcquireLease([]byte("lock"), time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.AcquireLease([]byte("lock"), time.Minute); !errors.Is(err, ErrLocked) {
+		t.Errorf("expected ErrLocked for a held lease, got %v", err)
+	}
+	store.Close()
+	if err := store.Put([]byte("a"), []byte("b")); !errors.Is(err, ErrStoreClosed) {
+		t.Errorf("expected ErrStoreClosed after Close, got %v", err)
+	}
+}
+
+func TestCorruptRecordFileError(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	path := "file_error.db"
+	store, err := Create(fs, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	store.Put([]byte("a"), []byte("value a"))
+	store.Put([]byte("b"), []byte("value b"))
+	rec, _ := store.keydir.GetKeydirRecord([]byte("b"))
+	size, _ := store.fileManager.DataFileSize(rec.FileId)
+	// The last byte of the file is part of the checksum of b
+	helperCorruptByte(t, fs, path, rec.FileId, size-1)
+
+	// Reads from the log verify the checksums
+	tailer, err := store.TailLog(0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if rec, err := tailer.Next(ctx); err != nil || string(rec.Key) != "a" {
+		t.Fatalf("expected a to be readable, got %q, %v", rec.Key, err)
+	}
+	_, err = tailer.Next(ctx)
+	if !errors.Is(err, ErrCorrupt) {
+		t.Fatalf("expected ErrCorrupt, got %v", err)
+	}
+	var fileErr *FileError
+	if !errors.As(err, &fileErr) {
+		t.Fatalf("expected a *FileError, got %T", err)
+	}
+	if fileErr.Offset != rec.ValuePos || fileErr.Path != filepath.Join(path, "data", utils.GetDataFileName(rec.FileId)) {
+		t.Errorf("expected the path and offset of b, got %s at %d", fileErr.Path, fileErr.Offset)
+	}
+}
@@ -0,0 +1,25 @@
+package kvdb
+
+import "github.com/ananthvk/kvdb/internal/filemanager"
+
+// FileIdAllocator gives out the ids of new data files: the file that writes go to, the files written by a merge, and
+// the files added by AdoptFile. It lets writers that share a datastore directory (such as striped writers or
+// replication) divide the ids between them, instead of each counting up from the largest id.
+//
+// Records are replayed in the order of the ids of their files, so every allocated id must be larger than every id
+// claimed or allocated before. The datastore checks this, and that no file has an allocated id, and fails the write,
+// merge or adoption with ErrFileIdCollision otherwise, so a file is never overwritten. The allocator is only called by
+// one datastore, with it's lock held
+type FileIdAllocator interface {
+	// Claim is called when the datastore is opened, in increasing order, with the id of every data file (and of every
+	// hint file without a data file). If it returns an error, for example because the id belongs to another writer,
+	// the datastore is not opened, and the error is returned wrapped in ErrFileIdCollision
+	Claim(id int) error
+	// Allocate reserves n consecutive ids, and returns the first one
+	Allocate(n int) int
+}
+
+// NewCounterAllocator returns the default FileIdAllocator, a counter that starts after the largest claimed id
+func NewCounterAllocator() FileIdAllocator {
+	return filemanager.NewCounterAllocator()
+}
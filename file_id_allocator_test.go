@@ -0,0 +1,86 @@
+package kvdb
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/spf13/afero"
+)
+
+// rangeAllocator gives out ids from [start, end), files with ids outside the range belong to another writer
+type rangeAllocator struct {
+	start, end, next int
+}
+
+func (a *rangeAllocator) Claim(id int) error {
+	if id < a.start || id >= a.end {
+		return fmt.Errorf("id %d is outside of [%d, %d)", id, a.start, a.end)
+	}
+	a.next = max(a.next, id+1)
+	return nil
+}
+
+func (a *rangeAllocator) Allocate(n int) int {
+	id := max(a.next, a.start)
+	a.next = id + n
+	return id
+}
+
+func TestFileIdAllocator(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	path := "test_file_id_allocator.db"
+	options := func() *Options {
+		return &Options{FileIdAllocator: &rangeAllocator{start: 100, end: 200}}
+	}
+	store, err := CreateWithOptions(fs, path, options())
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	expected := map[string]string{}
+	for i := range 20 {
+		key, value := fmt.Sprintf("key%d", i), fmt.Sprintf("value%d", i)
+		store.Put([]byte(key), []byte(value))
+		expected[key] = value
+	}
+	store.Close()
+
+	store, err = OpenWithOptions(fs, path, options())
+	if err != nil {
+		t.Fatalf("failed to reopen store: %v", err)
+	}
+	store.Put([]byte("key0"), []byte("updated"))
+	expected["key0"] = "updated"
+	if err := store.Merge(); err != nil {
+		t.Fatalf("merge failed: %v", err)
+	}
+	ids, err := store.fileManager.DataFileIds()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range ids {
+		if id < 100 || id >= 200 {
+			t.Errorf("expected ids in [100, 200), got %v", ids)
+		}
+	}
+	helperCheckValues(t, store, expected)
+	store.Close()
+
+	// A file of another writer is found when the datastore is opened
+	if err := afero.WriteFile(fs, path+"/data/0000000250.dat", nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := OpenWithOptions(fs, path, options()); !errors.Is(err, ErrFileIdCollision) {
+		t.Errorf("expected ErrFileIdCollision, got %v", err)
+	}
+	// The default counter continues after the largest id
+	store, err = Open(fs, path)
+	if err != nil {
+		t.Fatalf("failed to reopen store: %v", err)
+	}
+	defer store.Close()
+	store.Put([]byte("key1"), []byte("updated"))
+	if id := store.fileManager.GetActiveFileId(); id != 251 {
+		t.Errorf("expected active file 251, got %d", id)
+	}
+}
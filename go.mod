@@ -5,6 +5,7 @@ go 1.25.5
 require (
 	github.com/google/uuid v1.6.0
 	github.com/spf13/afero v1.15.0
+	golang.org/x/sys v0.33.0
 )
 
 require golang.org/x/text v0.28.0 // indirect
@@ -0,0 +1,163 @@
+package kvdb
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"github.com/ananthvk/kvdb/internal/record"
+)
+
+/*
+Group commit
+
+Every Put takes the write lock for the whole append, so when many goroutines write at the same time, most of them are
+waiting for the lock, and every Put that must be durable pays for it's own Sync. With Options.GroupCommit, Put does not
+write the record itself, it queues the pair for a single writer goroutine, and waits. The writer takes the pairs that are
+waiting (up to Options.GroupCommitMaxBatch of them), and under one acquisition of the write lock, encodes their records
+into one buffer and writes it with a single write (one per data file, if the batch fills the active file). Then it
+updates the keydir for every record, syncs the active file once for the whole batch if Options.GroupCommitSync is set,
+and wakes up their Puts.
+
+Each pair is checked as if by Put (interceptors, watchers and the stall thresholds see every pair), and each Put gets
+the error of it's own pair, so a pair that is rejected is left out of the write and does not fail the rest of the
+batch. The checks of the pairs run before any of them is written, so the memory limit sees the keydir as it was before
+the batch. If the write fails, the Puts whose records were not written get it's error. If the sync fails, every Put of
+the batch that was written returns the error of the sync. Only Put is queued, the other writes (PutBatch, Delete, the
+conditional writes) take the write lock directly, they are not reordered with the Puts that were queued before them
+since the writer holds the lock while it writes a batch.
+
+The writer is started when the datastore is opened, and stopped by Close once the operations in flight are done, so
+that no Put is left in the queue
+*/
+
+const defaultGroupCommitMaxBatch = 256
+
+type groupCommitRequest struct {
+	key   []byte
+	value []byte
+	// Receives the result of the put, it's buffered so that the writer never blocks on it
+	done chan error
+}
+
+type groupCommitter struct {
+	requests chan *groupCommitRequest
+	maxBatch int
+	sync     bool
+	stop     chan struct{}
+	stopOnce sync.Once
+	stopped  chan struct{}
+	// Number of batches written, and the number of puts in them
+	batches atomic.Uint64
+	puts    atomic.Uint64
+}
+
+// setupGroupCommit starts the group commit writer if Options.GroupCommit is set
+func (dataStore *DataStore) setupGroupCommit() {
+	if !dataStore.options.GroupCommit {
+		return
+	}
+	maxBatch := dataStore.options.GroupCommitMaxBatch
+	if maxBatch <= 0 {
+		maxBatch = defaultGroupCommitMaxBatch
+	}
+	committer := &groupCommitter{
+		requests: make(chan *groupCommitRequest, maxBatch),
+		maxBatch: maxBatch,
+		sync:     dataStore.options.GroupCommitSync,
+		stop:     make(chan struct{}),
+		stopped:  make(chan struct{}),
+	}
+	dataStore.committer = committer
+	go dataStore.runGroupCommit(committer)
+}
+
+// put queues the pair for the group commit writer, and waits until it's written. The caller must have entered the
+// close gate, so that Close does not stop the writer before the pair is written
+func (c *groupCommitter) put(key []byte, value []byte) error {
+	req := &groupCommitRequest{key: key, value: value, done: make(chan error, 1)}
+	c.requests <- req
+	return <-req.done
+}
+
+// close stops the writer, the queue must be empty, i.e. there must be no Put in flight. It can be called more than once
+func (c *groupCommitter) close() {
+	c.stopOnce.Do(func() { close(c.stop) })
+	<-c.stopped
+}
+
+// runGroupCommit is the group commit writer, it writes the queued pairs in batches until the committer is closed
+func (dataStore *DataStore) runGroupCommit(c *groupCommitter) {
+	defer close(c.stopped)
+	batch := make([]*groupCommitRequest, 0, c.maxBatch)
+	for {
+		select {
+		case req := <-c.requests:
+			batch = append(batch[:0], req)
+		drain:
+			for len(batch) < c.maxBatch {
+				select {
+				case req := <-c.requests:
+					batch = append(batch, req)
+				default:
+					break drain
+				}
+			}
+			dataStore.commitGroup(c, batch)
+		case <-c.stop:
+			return
+		}
+	}
+}
+
+// commitGroup writes the records of the batch with one write, under one acquisition of the write lock, syncs them if
+// needed, and sends every request it's result
+func (dataStore *DataStore) commitGroup(c *groupCommitter, batch []*groupCommitRequest) {
+	errs := make([]error, len(batch))
+	dataStore.lockForWrite("datastore.group_commit")
+	// The pairs that can be written, with their index in the batch and their records
+	pending := make([]int, 0, len(batch))
+	reqs := make([]*WriteRequest, 0, len(batch))
+	records := make([]record.Record, 0, len(batch))
+	for i, r := range batch {
+		ts := dataStore.nextTimestamp(r.key)
+		req, err := dataStore.preparePut(r.key, r.value)
+		if err != nil {
+			errs[i] = err
+			continue
+		}
+		header := dataStore.putHeader(ts, false, ValueTypeString)
+		pending = append(pending, i)
+		reqs = append(reqs, req)
+		records = append(records, record.Record{Header: header, Key: req.Key, Value: req.Value})
+	}
+	written := false
+	if len(records) > 0 {
+		fileIds, offsets, err := dataStore.fileManager.WriteRecords(records)
+		// The records before a failure are written, the keydir is updated in the order of the batch
+		for j, i := range pending {
+			if j < len(offsets) {
+				dataStore.putWritten(reqs[j], records[j].Header, fileIds[j], offsets[j])
+				written = true
+			} else {
+				errs[i] = err
+			}
+			dataStore.runAfterInterceptors(reqs[j], errs[i])
+		}
+	}
+	if c.sync && written {
+		if err := dataStore.fileManager.Sync(); err != nil {
+			for i := range errs {
+				if errs[i] == nil {
+					errs[i] = err
+				}
+			}
+		}
+		dataStore.updateStall()
+	}
+	dataStore.mu.Unlock()
+	c.batches.Add(1)
+	c.puts.Add(uint64(len(batch)))
+	for i, req := range batch {
+		req.done <- errs[i]
+	}
+}
@@ -0,0 +1,139 @@
+package kvdb
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/spf13/afero"
+)
+
+func TestGroupCommitConcurrentPuts(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	path := "test_group_commit.db"
+	store, err := CreateWithOptions(fs, path, &Options{GroupCommit: true, GroupCommitMaxBatch: 16, GroupCommitSync: true})
+	if err != nil {
+		t.Fatalf("could not create datastore: %v", err)
+	}
+
+	const writers, putsPerWriter = 32, 50
+	var wg sync.WaitGroup
+	for w := range writers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range putsPerWriter {
+				if err := store.Put(fmt.Appendf(nil, "key-%d-%d", w, i), fmt.Appendf(nil, "value-%d", i)); err != nil {
+					t.Errorf("put failed: %v", err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	stats, _ := store.Stats()
+	if stats.Keys != writers*putsPerWriter || stats.GroupCommitPuts != writers*putsPerWriter {
+		t.Errorf("expected %d keys written by the group commit writer, got %+v", writers*putsPerWriter, stats)
+	}
+	if stats.GroupCommits == 0 || stats.GroupCommits > stats.GroupCommitPuts || stats.UnsyncedBytes != 0 {
+		t.Errorf("unexpected group commit stats: %+v", stats)
+	}
+	// A failed pair does not fail the rest of the batch
+	if err := store.Put(nil, []byte("value")); !errors.Is(err, ErrNilKey) {
+		t.Errorf("expected ErrNilKey, got %v", err)
+	}
+	if err := store.Close(); err != nil {
+		t.Fatalf("close failed: %v", err)
+	}
+	if err := store.Put([]byte("key"), []byte("value")); !errors.Is(err, ErrClosed) {
+		t.Errorf("expected ErrClosed after Close, got %v", err)
+	}
+
+	store, err = Open(fs, path)
+	if err != nil {
+		t.Fatalf("could not open datastore: %v", err)
+	}
+	defer store.Close()
+	for w := range writers {
+		for i := range putsPerWriter {
+			value, err := store.Get(fmt.Appendf(nil, "key-%d-%d", w, i))
+			if err != nil || string(value) != fmt.Sprintf("value-%d", i) {
+				t.Fatalf("expected key-%d-%d=value-%d, got %q, %v", w, i, i, value, err)
+			}
+		}
+	}
+}
+
+// appendCountingFs counts the writes to the files opened for appending, which are the writes of records
+type appendCountingFs struct {
+	afero.Fs
+	writes atomic.Int64
+}
+
+type appendCountingFile struct {
+	afero.File
+	fs *appendCountingFs
+}
+
+func (fs *appendCountingFs) OpenFile(name string, flag int, perm os.FileMode) (afero.File, error) {
+	file, err := fs.Fs.OpenFile(name, flag, perm)
+	if err != nil || flag&os.O_APPEND == 0 {
+		return file, err
+	}
+	return &appendCountingFile{File: file, fs: fs}, nil
+}
+
+func (f *appendCountingFile) Write(p []byte) (int, error) {
+	f.fs.writes.Add(1)
+	return f.File.Write(p)
+}
+
+func TestGroupCommitSingleWrite(t *testing.T) {
+	fs := &appendCountingFs{Fs: afero.NewMemMapFs()}
+	store, err := CreateWithOptions(fs, "test_group_commit_write.db", &Options{MaxRecordsPerFile: 4})
+	if err != nil {
+		t.Fatalf("could not create datastore: %v", err)
+	}
+	defer store.Close()
+	var events []string
+	cancel := store.Watch(func(event WatchEvent) { events = append(events, string(event.Key)) })
+	defer cancel()
+
+	// A batch of 9 pairs and one that's rejected, the records go in 3 files of at most 4 records
+	var batch []*groupCommitRequest
+	for i := range 10 {
+		key := fmt.Appendf(nil, "key-%d", i)
+		if i == 5 {
+			key = nil
+		}
+		value := fmt.Appendf(nil, "value-%d", i)
+		batch = append(batch, &groupCommitRequest{key: key, value: value, done: make(chan error, 1)})
+	}
+	fs.writes.Store(0)
+	store.commitGroup(&groupCommitter{}, batch)
+	if writes := fs.writes.Load(); writes != 3 {
+		t.Errorf("expected one write per data file, got %d", writes)
+	}
+	for i, req := range batch {
+		err := <-req.done
+		if i == 5 {
+			if !errors.Is(err, ErrNilKey) {
+				t.Errorf("expected ErrNilKey for the rejected pair, got %v", err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("pair %d: %v", i, err)
+		}
+		if value, err := store.Get(req.key); err != nil || string(value) != string(req.value) {
+			t.Errorf("pair %d: expected %q, got %q, %v", i, req.value, value, err)
+		}
+	}
+	if len(events) != 9 || events[0] != "key-0" || events[8] != "key-9" {
+		t.Errorf("expected a watch event for every written pair in order, got %v", events)
+	}
+}
@@ -0,0 +1,164 @@
+package kvdb
+
+import (
+	"encoding/binary"
+	"slices"
+)
+
+// Hash is a map of fields to values stored as the value of a single key, see types.go. It's a handle, nothing is read
+// until a method is called, and the hash exists as long as it has a field
+type Hash struct {
+	dataStore *DataStore
+	key       []byte
+}
+
+// Hash returns the hash stored at the key. The methods of the hash fail with a *WrongTypeError if the key holds a value
+// of another type
+func (dataStore *DataStore) Hash(key []byte) *Hash {
+	return &Hash{dataStore: dataStore, key: key}
+}
+
+// Key returns the key of the hash
+func (h *Hash) Key() []byte {
+	return h.key
+}
+
+// Set sets the value of the field, and returns true if the field is new
+func (h *Hash) Set(field []byte, value []byte) (bool, error) {
+	added, err := h.SetFields(map[string][]byte{string(field): value})
+	return added == 1, err
+}
+
+// SetFields sets the value of every field of fields with a single write, and returns the number of fields that are new
+func (h *Hash) SetFields(fields map[string][]byte) (int, error) {
+	added := 0
+	err := h.dataStore.updateStructured(h.key, ValueTypeHash, "datastore.hash_set", func(encoded []byte, exists bool) ([]byte, error) {
+		current, err := decodeHash(encoded)
+		if err != nil {
+			return nil, err
+		}
+		for field, value := range fields {
+			if _, ok := current[field]; !ok {
+				added++
+			}
+			current[field] = value
+		}
+		return encodeHash(current), nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return added, nil
+}
+
+// Get returns the value of the field. If the hash does not exist, or does not have the field, `ErrKeyNotFound` is
+// returned
+func (h *Hash) Get(field []byte) ([]byte, error) {
+	fields, err := h.GetAll()
+	if err != nil {
+		return nil, err
+	}
+	value, ok := fields[string(field)]
+	if !ok {
+		return nil, ErrKeyNotFound
+	}
+	return value, nil
+}
+
+// GetAll returns every field of the hash with it's value, the map is empty if the hash does not exist
+func (h *Hash) GetAll() (map[string][]byte, error) {
+	encoded, _, err := h.dataStore.readStructured(h.key, ValueTypeHash)
+	if err != nil {
+		return nil, err
+	}
+	return decodeHash(encoded)
+}
+
+// Len returns the number of fields of the hash, 0 if the hash does not exist
+func (h *Hash) Len() (int, error) {
+	encoded, _, err := h.dataStore.readStructured(h.key, ValueTypeHash)
+	if err != nil || len(encoded) == 0 {
+		return 0, err
+	}
+	n, m := binary.Uvarint(encoded)
+	if m <= 0 {
+		return 0, errInvalidStructured
+	}
+	return int(n), nil
+}
+
+// Delete removes the fields from the hash, and returns the number of fields that were removed. The hash is deleted when
+// it's last field is removed
+func (h *Hash) Delete(fields ...[]byte) (int, error) {
+	removed := 0
+	err := h.dataStore.updateStructured(h.key, ValueTypeHash, "datastore.hash_delete", func(encoded []byte, exists bool) ([]byte, error) {
+		current, err := decodeHash(encoded)
+		if err != nil {
+			return nil, err
+		}
+		for _, field := range fields {
+			if _, ok := current[string(field)]; ok {
+				delete(current, string(field))
+				removed++
+			}
+		}
+		if !exists {
+			return nil, nil
+		}
+		return encodeHash(current), nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return removed, nil
+}
+
+// encodeHash returns the encoding of the fields (see types.go), nil if there are none
+func encodeHash(fields map[string][]byte) []byte {
+	if len(fields) == 0 {
+		return nil
+	}
+	names := make([]string, 0, len(fields))
+	size := binary.MaxVarintLen64
+	for name, value := range fields {
+		names = append(names, name)
+		size += 2*binary.MaxVarintLen32 + len(name) + len(value)
+	}
+	slices.Sort(names)
+	encoded := make([]byte, 0, size)
+	encoded = binary.AppendUvarint(encoded, uint64(len(fields)))
+	for _, name := range names {
+		encoded = appendBytes(encoded, []byte(name))
+		encoded = appendBytes(encoded, fields[name])
+	}
+	return encoded
+}
+
+// decodeHash decodes the encoding of a hash, an empty encoding is an empty hash
+func decodeHash(encoded []byte) (map[string][]byte, error) {
+	if len(encoded) == 0 {
+		return map[string][]byte{}, nil
+	}
+	n, m := binary.Uvarint(encoded)
+	// Every field takes at least 2 bytes
+	if m <= 0 || n > uint64(len(encoded)/2) {
+		return nil, errInvalidStructured
+	}
+	encoded = encoded[m:]
+	fields := make(map[string][]byte, n)
+	for range n {
+		var field, value []byte
+		var ok bool
+		if field, encoded, ok = cutBytes(encoded); !ok {
+			return nil, errInvalidStructured
+		}
+		if value, encoded, ok = cutBytes(encoded); !ok {
+			return nil, errInvalidStructured
+		}
+		fields[string(field)] = value
+	}
+	if len(encoded) != 0 {
+		return nil, errInvalidStructured
+	}
+	return fields, nil
+}
@@ -0,0 +1,111 @@
+package kvdb
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/spf13/afero"
+)
+
+func TestHash(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	path := "test_hash.db"
+	store := helperCreateMultipleDataFiles(t, fs, path)
+
+	hash := store.Hash([]byte("user:1"))
+	if n, err := hash.Len(); err != nil || n != 0 {
+		t.Errorf("expected an empty hash, got %d (err: %v)", n, err)
+	}
+	if added, err := hash.SetFields(map[string][]byte{"name": []byte("alice"), "email": []byte("a@example.com")}); err != nil || added != 2 {
+		t.Fatalf("expected 2 new fields, got %d (err: %v)", added, err)
+	}
+	if added, err := hash.Set([]byte("name"), []byte("bob")); err != nil || added {
+		t.Errorf("expected the field to be updated, got %v (err: %v)", added, err)
+	}
+	if value, err := hash.Get([]byte("name")); err != nil || string(value) != "bob" {
+		t.Errorf("expected bob, got %q (err: %v)", value, err)
+	}
+	if _, err := hash.Get([]byte("missing")); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("expected ErrKeyNotFound, got %v", err)
+	}
+	store.Close()
+
+	// The hash survives a reopen, and a merge
+	store, err := Open(fs, path)
+	if err != nil {
+		t.Fatalf("failed to reopen store: %v", err)
+	}
+	defer store.Close()
+	if err := store.Merge(); err != nil {
+		t.Fatalf("merge failed: %v", err)
+	}
+	hash = store.Hash([]byte("user:1"))
+	fields, err := hash.GetAll()
+	if err != nil || len(fields) != 2 || string(fields["name"]) != "bob" || string(fields["email"]) != "a@example.com" {
+		t.Errorf("unexpected fields %q (err: %v)", fields, err)
+	}
+	if removed, err := hash.Delete([]byte("name"), []byte("missing")); err != nil || removed != 1 {
+		t.Errorf("expected 1 field to be removed, got %d (err: %v)", removed, err)
+	}
+	if removed, err := hash.Delete([]byte("email")); err != nil || removed != 1 {
+		t.Errorf("expected 1 field to be removed, got %d (err: %v)", removed, err)
+	}
+	if _, err := store.Get([]byte("user:1")); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("expected the empty hash to be deleted, got %v", err)
+	}
+}
+
+func TestHashWrongType(t *testing.T) {
+	store := helperCreateMultipleDataFiles(t, afero.NewMemMapFs(), "test_hash_wrong_type.db")
+	defer store.Close()
+	store.Put([]byte("string"), []byte("value"))
+	hash := store.Hash([]byte("string"))
+	_, err := hash.Set([]byte("field"), []byte("value"))
+	var wrongType *WrongTypeError
+	if !errors.Is(err, ErrWrongType) || !errors.As(err, &wrongType) || wrongType.Type != ValueTypeString {
+		t.Errorf("expected a *WrongTypeError for a string, got %v", err)
+	}
+	if _, err := hash.GetAll(); !errors.Is(err, ErrWrongType) {
+		t.Errorf("expected ErrWrongType, got %v", err)
+	}
+	if value, _ := store.Get([]byte("string")); string(value) != "value" {
+		t.Errorf("expected the string to be unchanged, got %q", value)
+	}
+}
+
+func TestHashConcurrentSet(t *testing.T) {
+	store := helperCreateMultipleDataFiles(t, afero.NewMemMapFs(), "test_hash_concurrent.db")
+	defer store.Close()
+	hash := store.Hash([]byte("hash"))
+	var wg sync.WaitGroup
+	for w := range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range 50 {
+				if _, err := hash.Set(fmt.Appendf(nil, "%d-%d", w, i), []byte("value")); err != nil {
+					t.Errorf("set failed: %v", err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if n, err := hash.Len(); err != nil || n != 8*50 {
+		t.Errorf("expected no update to be lost, got %d fields (err: %v)", n, err)
+	}
+}
+
+func TestDecodeHashInvalid(t *testing.T) {
+	valid := encodeHash(map[string][]byte{"a": []byte("1"), "b": []byte("2")})
+	for i := range len(valid) - 1 {
+		if _, err := decodeHash(valid[:i+1]); err == nil {
+			t.Errorf("expected a truncated encoding of %d bytes to be rejected", i+1)
+		}
+	}
+	if _, err := decodeHash(append(valid, 0)); err == nil {
+		t.Errorf("expected trailing bytes to be rejected")
+	}
+}
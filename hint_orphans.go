@@ -0,0 +1,124 @@
+package kvdb
+
+import (
+	"fmt"
+	"log/slog"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/ananthvk/kvdb/internal/filemanager"
+	"github.com/ananthvk/kvdb/internal/utils"
+	"github.com/spf13/afero"
+)
+
+/*
+Hint directory orphans
+
+A hint file (or bloom filter) in the hint directory belongs to the data file with the same id. It's an orphan if that
+data file does not exist, which happens when the data file was removed but the hint file was not (the removal of the
+hint files of merged files is not checked), or when files are copied around by hand. A hint file that was ignored
+when the datastore was opened, because it did not match it's data file, is treated as an orphan too, since it no
+longer describes the file with it's id and is checked (and rejected) again on every open.
+
+Data files without a hint file are not orphans, only merges (and snapshots) write hint files, and the files written by
+Put only have one once GenerateHints has written it. They are scanned when the datastore is opened.
+
+Orphans are looked for when the datastore is opened (after an interrupted merge has been recovered), and after every
+merge. Options.HintOrphans decides what is done with them: HintOrphansRemove (the default) deletes them, and
+HintOrphansKeep leaves them in place. Either way, they are listed in OpenReport.OrphanHintFiles (for the ones found
+when opening), and counted in Stats.OrphanHintFiles
+*/
+
+// HintOrphanPolicy is what is done with orphaned hint files, see Options.HintOrphans
+type HintOrphanPolicy int
+
+const (
+	// HintOrphansRemove deletes orphaned hint files
+	HintOrphansRemove HintOrphanPolicy = iota + 1
+	// HintOrphansKeep only reports orphaned hint files
+	HintOrphansKeep
+)
+
+// checkHintOrphanPolicy returns an error if the policy for orphaned hint files is not known
+func (opts *Options) checkHintOrphanPolicy() error {
+	switch opts.HintOrphans {
+	case 0, HintOrphansRemove, HintOrphansKeep:
+		return nil
+	}
+	return fmt.Errorf("unknown hint orphan policy %d", opts.HintOrphans)
+}
+
+// findHintOrphans returns the names of the files in the hint directory that belong to a data file that does not
+// exist. Files that are not hint files or bloom filters (like the temporary files of a running merge) are skipped
+func findHintOrphans(fs afero.Fs, layout filemanager.Layout) ([]string, error) {
+	entries, err := afero.ReadDir(fs, layout.HintDir)
+	if err != nil {
+		return nil, err
+	}
+	var orphans []string
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		name := entry.Name()
+		ext := filepath.Ext(name)
+		if ext != ".hint" && ext != ".bloom" {
+			continue
+		}
+		id, err := strconv.Atoi(strings.TrimSuffix(name, ext))
+		if err != nil || id < 0 {
+			continue
+		}
+		exists, err := afero.Exists(fs, layout.DataFilePath(fs, id))
+		if err != nil {
+			return nil, err
+		}
+		if !exists {
+			orphans = append(orphans, name)
+		}
+	}
+	return orphans, nil
+}
+
+// handleHintOrphans removes the orphaned hint files (unless the policy is HintOrphansKeep), and counts them
+func (dataStore *DataStore) handleHintOrphans(orphans []string) {
+	if len(orphans) == 0 {
+		return
+	}
+	dataStore.counters.orphanHintFiles.Add(uint64(len(orphans)))
+	if dataStore.options.HintOrphans == HintOrphansKeep {
+		slog.Warn("orphaned hint files", "path", dataStore.path, "files", orphans)
+		return
+	}
+	for _, name := range orphans {
+		if err := dataStore.fs.Remove(dataStore.fileManager.Layout().HintPath(name)); err != nil {
+			slog.Warn("could not remove orphaned hint file", "path", dataStore.path, "file", name, "error", err)
+		}
+	}
+}
+
+// openHintOrphans finds the orphaned hint files when the datastore is opened, including the hint files that were
+// ignored because they did not match their data file, and handles them
+func (dataStore *DataStore) openHintOrphans() error {
+	orphans, err := findHintOrphans(dataStore.fs, dataStore.fileManager.Layout())
+	if err != nil {
+		return err
+	}
+	for _, id := range dataStore.fileManager.LoadReport().IgnoredHintFiles {
+		orphans = append(orphans, utils.GetHintFileName(id))
+	}
+	dataStore.openOrphanHintFiles = orphans
+	dataStore.handleHintOrphans(orphans)
+	return nil
+}
+
+// mergeHintOrphans finds and handles the orphaned hint files after a merge, the caller must hold the merge lock
+func (dataStore *DataStore) mergeHintOrphans() {
+	orphans, err := findHintOrphans(dataStore.fs, dataStore.fileManager.Layout())
+	if err != nil {
+		slog.Warn("could not look for orphaned hint files", "path", dataStore.path, "error", err)
+		return
+	}
+	dataStore.handleHintOrphans(orphans)
+}
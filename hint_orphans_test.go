@@ -0,0 +1,91 @@
+package kvdb
+
+import (
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/spf13/afero"
+)
+
+func TestHintOrphansAreRemoved(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	path := "test_hint_orphans.db"
+	store, err := Create(fs, path)
+	if err != nil {
+		t.Fatalf("could not create datastore: %v", err)
+	}
+	store.Put([]byte("key"), []byte("value"))
+	store.Close()
+
+	hintDir := filepath.Join(path, "hint")
+	orphans := []string{"0000000042.hint", "0000000042.bloom"}
+	for _, name := range orphans {
+		afero.WriteFile(fs, filepath.Join(hintDir, name), []byte("orphan"), 0644)
+	}
+	// Not a hint file, it's left alone
+	afero.WriteFile(fs, filepath.Join(hintDir, "notes.txt"), []byte("notes"), 0644)
+
+	store, err = Open(fs, path)
+	if err != nil {
+		t.Fatalf("could not open datastore: %v", err)
+	}
+	report := store.OpenReport()
+	slices.Sort(report.OrphanHintFiles)
+	if !slices.Equal(report.OrphanHintFiles, []string{"0000000042.bloom", "0000000042.hint"}) {
+		t.Errorf("expected the orphans in the report, got %v", report.OrphanHintFiles)
+	}
+	if stats, _ := store.Stats(); stats.OrphanHintFiles != 2 {
+		t.Errorf("expected 2 orphans in the stats, got %d", stats.OrphanHintFiles)
+	}
+	for _, name := range orphans {
+		if exists, _ := afero.Exists(fs, filepath.Join(hintDir, name)); exists {
+			t.Errorf("expected %s to be removed", name)
+		}
+	}
+	if exists, _ := afero.Exists(fs, filepath.Join(hintDir, "notes.txt")); !exists {
+		t.Errorf("expected the unknown file to be kept")
+	}
+
+	// Orphans left behind by a merge are removed after it
+	store.Put([]byte("key"), []byte("value2"))
+	afero.WriteFile(fs, filepath.Join(hintDir, "0000000099.hint"), []byte("orphan"), 0644)
+	if err := store.Merge(); err != nil {
+		t.Fatalf("merge failed: %v", err)
+	}
+	if exists, _ := afero.Exists(fs, filepath.Join(hintDir, "0000000099.hint")); exists {
+		t.Errorf("expected the orphan to be removed after the merge")
+	}
+	if stats, _ := store.Stats(); stats.OrphanHintFiles != 3 {
+		t.Errorf("expected 3 orphans in the stats, got %d", stats.OrphanHintFiles)
+	}
+	store.Close()
+}
+
+func TestHintOrphansAreKept(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	path := "test_hint_orphans_keep.db"
+	store, err := Create(fs, path)
+	if err != nil {
+		t.Fatalf("could not create datastore: %v", err)
+	}
+	store.Close()
+	orphan := filepath.Join(path, "hint", "0000000042.hint")
+	afero.WriteFile(fs, orphan, []byte("orphan"), 0644)
+
+	store, err = OpenWithOptions(fs, path, &Options{HintOrphans: HintOrphansKeep})
+	if err != nil {
+		t.Fatalf("could not open datastore: %v", err)
+	}
+	defer store.Close()
+	if report := store.OpenReport(); !slices.Equal(report.OrphanHintFiles, []string{"0000000042.hint"}) {
+		t.Errorf("expected the orphan in the report, got %v", report.OrphanHintFiles)
+	}
+	if exists, _ := afero.Exists(fs, orphan); !exists {
+		t.Errorf("expected the orphan to be kept")
+	}
+
+	if _, err := OpenWithOptions(fs, path, &Options{HintOrphans: 99}); err == nil {
+		t.Errorf("expected an unknown policy to be rejected")
+	}
+}
@@ -0,0 +1,89 @@
+package kvdb
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+)
+
+/*
+Hint files for sealed data files
+
+Merges write a hint file for every data file they write, so that the keydir is built from the hints when the datastore
+is opened, without reading the values. The data files written by Put and Delete are scanned instead, until they are
+merged. GenerateHints writes a hint file for every sealed data file that does not have one, so that they are not
+scanned either. With Options.HintsOnRotate, it runs in the background every time the active file is sealed.
+
+The data file is not changed. It's hint file has a hint for every record of the file, in order, tombstones included, so
+that replaying the hints gives the same keydir as scanning the file (overwritten records are replayed too, since the
+key and timestamp ranges of the file are built from them). The header of the hint file marks it as the hint file of a
+log file (hintfile.FlagLogFile), so the data file is still part of the log for TailLog, is not reported as merged by
+FileStats, and Repair drops the hint file instead of rebuilding it. Hint files are checked against their data file
+before they are used (see internal/filemanager/hint_check.go), like the hint files written by merges.
+
+GenerateHints holds the merge lock, so it waits for a running merge (or snapshot), and does not write a hint file for
+a data file that a merge is about to remove. A data file that can't be read completely does not get a hint file
+*/
+
+// GenerateHints writes a hint file for every sealed data file that does not have one, and returns the ids of the data
+// files that got one, see hints.go. The files that could not be read are skipped, and returned in the error
+func (dataStore *DataStore) GenerateHints() ([]int, error) {
+	if err := dataStore.gate.enter(); err != nil {
+		return nil, err
+	}
+	defer dataStore.gate.exit()
+	dataStore.mergeLock.Lock()
+	defer dataStore.mergeLock.Unlock()
+
+	immutableFiles, err := dataStore.fileManager.GetImmutableFiles()
+	if err != nil {
+		return nil, err
+	}
+	var written []int
+	var errs []error
+	for _, id := range immutableFiles {
+		if dataStore.fileManager.HasHintFile(id) {
+			continue
+		}
+		if err := dataStore.fileManager.WriteHintFile(id); err != nil {
+			errs = append(errs, fmt.Errorf("data file %d: %w", id, err))
+			continue
+		}
+		written = append(written, id)
+	}
+	return written, errors.Join(errs...)
+}
+
+// setupHintsOnRotate generates the hint files in the background every time the active file is sealed, if
+// Options.HintsOnRotate is set, until the datastore is closed
+func (dataStore *DataStore) setupHintsOnRotate() {
+	if !dataStore.options.HintsOnRotate || dataStore.inMemory {
+		return
+	}
+	dataStore.hintsPending = make(chan struct{}, 1)
+	done := dataStore.gate.ctx.Done()
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			case <-dataStore.hintsPending:
+			}
+			if _, err := dataStore.GenerateHints(); err != nil && !errors.Is(err, ErrClosed) {
+				slog.Warn("could not write the hint files of sealed data files", "path", dataStore.path, "error", err)
+			}
+		}
+	}()
+}
+
+// markHintsPending makes the background writer of Options.HintsOnRotate generate the hint files, without waiting for
+// it. It does nothing if the option is not set
+func (dataStore *DataStore) markHintsPending() {
+	if dataStore.hintsPending == nil {
+		return
+	}
+	select {
+	case dataStore.hintsPending <- struct{}{}:
+	default:
+	}
+}
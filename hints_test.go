@@ -0,0 +1,111 @@
+package kvdb
+
+import (
+	"errors"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/ananthvk/kvdb/internal/utils"
+	"github.com/spf13/afero"
+)
+
+// checkGeneratedHintsStore checks the keys written by TestGenerateHints
+func checkGeneratedHintsStore(t *testing.T, store *DataStore) {
+	t.Helper()
+	if _, err := store.Get([]byte("a")); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("a: expected the key to be deleted, got %v", err)
+	}
+	for key, want := range map[string]string{"b": "new", "c": "old", "once": "v"} {
+		if value, err := store.Get([]byte(key)); err != nil || string(value) != want {
+			t.Errorf("%s: expected %s, got %q, %v", key, want, value, err)
+		}
+	}
+	if err := store.Delete([]byte("once")); !errors.Is(err, ErrImmutableKey) {
+		t.Errorf("expected the write-once key to stay write-once, got %v", err)
+	}
+}
+
+func TestGenerateHints(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	store, err := CreateWithOptions(fs, "generate_hints.db", &Options{MaxRecordsPerFile: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Data file 1 has a and b, data file 2 deletes a, data file 3 overwrites b
+	store.Put([]byte("a"), []byte("old"))
+	store.Put([]byte("b"), []byte("old"))
+	store.Delete([]byte("a"))
+	store.Put([]byte("c"), []byte("old"))
+	store.Put([]byte("b"), []byte("new"))
+	store.PutWithOptions([]byte("once"), []byte("v"), &PutOptions{WriteOnce: true})
+	store.Put([]byte("d"), []byte("active"))
+
+	ids, err := store.GenerateHints()
+	if err != nil || !slices.Equal(ids, []int{1, 2, 3}) {
+		t.Fatalf("expected hint files for data files 1 to 3, got %v, %v", ids, err)
+	}
+	if ids, err := store.GenerateHints(); err != nil || len(ids) != 0 {
+		t.Errorf("expected no more hint files to write, got %v, %v", ids, err)
+	}
+	stats, _ := store.FileStats()
+	for _, stat := range stats {
+		if stat.Merged {
+			t.Errorf("file %d: expected a file with a generated hint file not to be reported as merged", stat.Id)
+		}
+	}
+	store.Close()
+
+	store, err = Open(fs, "generate_hints.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	if report := store.OpenReport(); len(report.IgnoredHintFiles) != 0 || len(report.InvalidDataFiles) != 0 {
+		t.Errorf("expected the generated hint files to be used, got %+v", report)
+	}
+	checkGeneratedHintsStore(t, store)
+
+	// The files are still part of the log
+	tailer, _ := store.TailLog(0, 0)
+	var log []string
+	for range 7 {
+		rec := helperNextLogRecord(t, tailer)
+		log = append(log, rec.Type.String()+" "+string(rec.Key))
+	}
+	if log[2] != "DELETE a" || log[6] != "PUT d" {
+		t.Errorf("unexpected log %v", log)
+	}
+
+	if err := store.Merge(); err != nil {
+		t.Fatalf("merge failed: %v", err)
+	}
+	checkGeneratedHintsStore(t, store)
+}
+
+func TestHintsOnRotate(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	store, err := CreateWithOptions(fs, "hints_on_rotate.db", &Options{MaxRecordsPerFile: 1, HintsOnRotate: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	store.Put([]byte("a"), []byte("1"))
+	store.Put([]byte("b"), []byte("2"))
+
+	hintPath := filepath.Join("hints_on_rotate.db", "hint", utils.GetHintFileName(1))
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if exists, _ := afero.Exists(fs, hintPath); exists {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("expected the sealed data file to get a hint file")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if exists, _ := afero.Exists(fs, filepath.Join("hints_on_rotate.db", "hint", utils.GetHintFileName(2))); exists {
+		t.Error("expected the active data file not to get a hint file")
+	}
+}
@@ -0,0 +1,102 @@
+package kvdb
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+type WriteType int
+
+const (
+	WriteTypePut WriteType = iota
+	WriteTypeDelete
+)
+
+func (t WriteType) String() string {
+	switch t {
+	case WriteTypePut:
+		return "PUT"
+	case WriteTypeDelete:
+		return "DELETE"
+	}
+	return fmt.Sprintf("WriteType(%d)", int(t))
+}
+
+// WriteRequest describes a single write to the datastore. Value is always nil for deletes
+type WriteRequest struct {
+	Type  WriteType
+	Key   []byte
+	Value []byte
+}
+
+// WriteInterceptor hooks into every Put and Delete (including writes made by the JSON helpers), but not into the
+// records rewritten by Merge. Interceptors run inside the write lock, so they see writes in the order in which they
+// are applied, but they also block all other writers and must be fast.
+//
+// Before is called before the record is written. It may modify the Key and Value of the request (for example to stamp
+// audit information into the value), and the modified request is what gets written. If Before returns an error, the
+// write is rejected, nothing is written, the remaining interceptors are not run, and the error is returned to the caller
+// wrapped in ErrWriteRejected.
+//
+// After is called once the write has been attempted (but not for rejected writes), err is the result of the write.
+// After cannot fail the write, since the record has already been written. Either function can be nil
+type WriteInterceptor struct {
+	Name   string
+	Before func(req *WriteRequest) error
+	After  func(req *WriteRequest, err error)
+}
+
+// InterceptorStats holds counters about the interceptors run by the datastore
+type InterceptorStats struct {
+	// Calls is the number of Before and After functions that have been called
+	Calls uint64
+	// Rejected is the number of writes rejected by a Before function
+	Rejected uint64
+	// TotalTime is the total time spent in the Before and After functions
+	TotalTime time.Duration
+}
+
+type interceptorCounters struct {
+	calls     atomic.Uint64
+	rejected  atomic.Uint64
+	totalTime atomic.Int64
+}
+
+// InterceptorStats returns the latency and rejection counters of the configured interceptors
+func (dataStore *DataStore) InterceptorStats() InterceptorStats {
+	return InterceptorStats{
+		Calls:     dataStore.interceptorStats.calls.Load(),
+		Rejected:  dataStore.interceptorStats.rejected.Load(),
+		TotalTime: time.Duration(dataStore.interceptorStats.totalTime.Load()),
+	}
+}
+
+func (dataStore *DataStore) runBeforeInterceptors(req *WriteRequest) error {
+	for _, interceptor := range dataStore.options.Interceptors {
+		if interceptor.Before == nil {
+			continue
+		}
+		start := time.Now()
+		err := interceptor.Before(req)
+		dataStore.interceptorStats.totalTime.Add(int64(time.Since(start)))
+		dataStore.interceptorStats.calls.Add(1)
+		if err != nil {
+			dataStore.interceptorStats.rejected.Add(1)
+			return fmt.Errorf("%w by interceptor %q: %w", ErrWriteRejected, interceptor.Name, err)
+		}
+	}
+	return nil
+}
+
+func (dataStore *DataStore) runAfterInterceptors(req *WriteRequest, err error) {
+	for _, interceptor := range dataStore.options.Interceptors {
+		if interceptor.After == nil {
+			continue
+		}
+		start := time.Now()
+		interceptor.After(req, err)
+		dataStore.interceptorStats.totalTime.Add(int64(time.Since(start)))
+		dataStore.interceptorStats.calls.Add(1)
+	}
+}
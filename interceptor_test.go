@@ -0,0 +1,81 @@
+package kvdb
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/spf13/afero"
+)
+
+func TestWriteInterceptors(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	errReadOnlyPrefix := errors.New("keys with prefix ro: are read only")
+
+	var audit []string
+	opts := &Options{
+		Interceptors: []WriteInterceptor{
+			{
+				Name: "validate",
+				Before: func(req *WriteRequest) error {
+					if len(req.Key) > 3 && string(req.Key[:3]) == "ro:" {
+						return errReadOnlyPrefix
+					}
+					return nil
+				},
+			},
+			{
+				Name: "stamp",
+				Before: func(req *WriteRequest) error {
+					if req.Type == WriteTypePut {
+						req.Value = append([]byte("v1|"), req.Value...)
+					}
+					return nil
+				},
+				After: func(req *WriteRequest, err error) {
+					if err == nil {
+						audit = append(audit, req.Type.String()+" "+string(req.Key))
+					}
+				},
+			},
+		},
+	}
+	store, err := CreateWithOptions(fs, "test_interceptors.db", opts)
+	if err != nil {
+		t.Fatalf("error creating datastore: %v", err)
+	}
+	defer store.Close()
+
+	if err := store.Put([]byte("key1"), []byte("value1")); err != nil {
+		t.Fatalf("put failed: %v", err)
+	}
+	val, err := store.Get([]byte("key1"))
+	if err != nil || string(val) != "v1|value1" {
+		t.Errorf("expected stamped value v1|value1, got %s (err: %v)", val, err)
+	}
+
+	err = store.Put([]byte("ro:key"), []byte("value"))
+	if !errors.Is(err, ErrWriteRejected) || !errors.Is(err, errReadOnlyPrefix) {
+		t.Errorf("expected rejected write, got %v", err)
+	}
+	if _, err := store.Get([]byte("ro:key")); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("rejected write should not be stored, got %v", err)
+	}
+
+	existed, err := store.DeleteWithExists([]byte("key1"))
+	if err != nil || !existed {
+		t.Errorf("expected key1 to be deleted, got existed=%v err=%v", existed, err)
+	}
+
+	if len(audit) != 2 || audit[0] != "PUT key1" || audit[1] != "DELETE key1" {
+		t.Errorf("unexpected audit log %v", audit)
+	}
+
+	stats := store.InterceptorStats()
+	if stats.Rejected != 1 {
+		t.Errorf("expected 1 rejected write, got %d", stats.Rejected)
+	}
+	// 3 writes went through validate, 2 through stamp (Before & After)
+	if stats.Calls != 7 {
+		t.Errorf("expected 7 interceptor calls, got %d", stats.Calls)
+	}
+}
@@ -0,0 +1,142 @@
+package bloom
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"math"
+	"os"
+
+	"github.com/ananthvk/kvdb/internal/xxhash"
+	"github.com/spf13/afero"
+)
+
+/*
+Bloom filters are written by merges (when enabled) for every merged data file, in the hint directory as
+0000000001.bloom. A tool that reads the data files directly can skip a file whose filter does not contain a key, since
+the file can't have a record of the key. The datastore does not read them, the keydir already knows where every key is.
+
+File layout (little endian):
+
+	magic          [8]byte  "KVBLOOM1"
+	data file size uint64   size of the data file the filter describes, a filter for a file of another size is stale
+	hashes         uint32   number of bits set per key
+	words          uint32   number of 64 bit words of the bit array
+	bits           [words]uint64
+	checksum       uint32   CRC32 (IEEE) of everything before it
+
+The bit positions of a key are h1 + i*h2 (mod the number of bits) for i in [0, hashes), where h1 and h2 are the low
+and high halves of the xxHash64 of the key
+*/
+
+var magic = [8]byte{'K', 'V', 'B', 'L', 'O', 'O', 'M', '1'}
+
+const headerSize = 8 + 8 + 4 + 4
+
+// Largest number of words accepted when a file is read, 512 MB of bits
+const maxWords = 1 << 26
+
+var ErrInvalidFile = errors.New("invalid bloom filter file")
+
+// Filter is a bloom filter of keys, it's not safe for concurrent use while keys are added
+type Filter struct {
+	bits   []uint64
+	hashes uint32
+}
+
+// New returns a filter sized for n keys with the given false positive rate
+func New(n int, falsePositiveRate float64) *Filter {
+	n = max(n, 1)
+	bits := math.Ceil(-float64(n) * math.Log(falsePositiveRate) / (math.Ln2 * math.Ln2))
+	words := max(int(math.Ceil(bits/64)), 1)
+	hashes := uint32(math.Round(float64(words*64) / float64(n) * math.Ln2))
+	return &Filter{bits: make([]uint64, words), hashes: min(max(hashes, 1), 30)}
+}
+
+// Hash returns the hash of a key used by the filter, keys can be added by their hash with AddHash
+func Hash(key []byte) uint64 {
+	return xxhash.Sum64(key)
+}
+
+// Add adds a key to the filter
+func (f *Filter) Add(key []byte) {
+	f.AddHash(Hash(key))
+}
+
+// AddHash adds a key to the filter by it's hash
+func (f *Filter) AddHash(hash uint64) {
+	n := uint64(len(f.bits)) * 64
+	h1, h2 := hash&math.MaxUint32, hash>>32
+	for i := range uint64(f.hashes) {
+		bit := (h1 + i*h2) % n
+		f.bits[bit/64] |= 1 << (bit % 64)
+	}
+}
+
+// MayContain returns false if the key was never added to the filter, true means that it was probably added
+func (f *Filter) MayContain(key []byte) bool {
+	n := uint64(len(f.bits)) * 64
+	hash := Hash(key)
+	h1, h2 := hash&math.MaxUint32, hash>>32
+	for i := range uint64(f.hashes) {
+		bit := (h1 + i*h2) % n
+		if f.bits[bit/64]&(1<<(bit%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// WriteFile writes the filter of a data file of the given size to path, and syncs it
+func WriteFile(fs afero.Fs, path string, f *Filter, dataFileSize int64) error {
+	buf := make([]byte, headerSize+len(f.bits)*8+4)
+	copy(buf, magic[:])
+	binary.LittleEndian.PutUint64(buf[8:], uint64(dataFileSize))
+	binary.LittleEndian.PutUint32(buf[16:], f.hashes)
+	binary.LittleEndian.PutUint32(buf[20:], uint32(len(f.bits)))
+	for i, word := range f.bits {
+		binary.LittleEndian.PutUint64(buf[headerSize+i*8:], word)
+	}
+	binary.LittleEndian.PutUint32(buf[len(buf)-4:], crc32.ChecksumIEEE(buf[:len(buf)-4]))
+
+	file, err := fs.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0666)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(buf); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// ReadFile reads the filter at path, and returns it with the size of the data file it describes. An error wrapping
+// ErrInvalidFile is returned if the file is damaged
+func ReadFile(fs afero.Fs, path string) (*Filter, int64, error) {
+	buf, err := afero.ReadFile(fs, path)
+	if err != nil {
+		return nil, 0, err
+	}
+	if len(buf) < headerSize+4 || !bytes.Equal(buf[:8], magic[:]) {
+		return nil, 0, fmt.Errorf("%w: bad header", ErrInvalidFile)
+	}
+	hashes := binary.LittleEndian.Uint32(buf[16:])
+	words := binary.LittleEndian.Uint32(buf[20:])
+	if hashes == 0 || words == 0 || words > maxWords || len(buf) != headerSize+int(words)*8+4 {
+		return nil, 0, fmt.Errorf("%w: %d hashes and %d words in %d bytes", ErrInvalidFile, hashes, words, len(buf))
+	}
+	if crc32.ChecksumIEEE(buf[:len(buf)-4]) != binary.LittleEndian.Uint32(buf[len(buf)-4:]) {
+		return nil, 0, fmt.Errorf("%w: checksum mismatch", ErrInvalidFile)
+	}
+	f := &Filter{bits: make([]uint64, words), hashes: hashes}
+	for i := range f.bits {
+		f.bits[i] = binary.LittleEndian.Uint64(buf[headerSize+i*8:])
+	}
+	return f, int64(binary.LittleEndian.Uint64(buf[8:])), nil
+}
@@ -0,0 +1,70 @@
+package bloom
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/spf13/afero"
+)
+
+func TestFilter(t *testing.T) {
+	const n = 10000
+	f := New(n, 0.01)
+	for i := range n {
+		f.Add([]byte(fmt.Sprintf("key%d", i)))
+	}
+	for i := range n {
+		if !f.MayContain([]byte(fmt.Sprintf("key%d", i))) {
+			t.Fatalf("expected key%d to be in the filter", i)
+		}
+	}
+	falsePositives := 0
+	for i := range n {
+		if f.MayContain([]byte(fmt.Sprintf("other%d", i))) {
+			falsePositives++
+		}
+	}
+	if rate := float64(falsePositives) / n; rate > 0.02 {
+		t.Errorf("expected a false positive rate of about 1%%, got %.2f%%", rate*100)
+	}
+
+	// An empty filter contains nothing
+	if New(0, 0.01).MayContain([]byte("key")) {
+		t.Errorf("expected an empty filter not to contain a key")
+	}
+}
+
+func TestFile(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	f := New(100, 0.01)
+	for i := range 100 {
+		f.AddHash(Hash([]byte(fmt.Sprintf("key%d", i))))
+	}
+	if err := WriteFile(fs, "1.bloom", f, 12345); err != nil {
+		t.Fatalf("write failed: %v", err)
+	}
+	read, size, err := ReadFile(fs, "1.bloom")
+	if err != nil || size != 12345 {
+		t.Fatalf("expected a filter of a 12345 byte file, got %d, %v", size, err)
+	}
+	for i := range 100 {
+		if !read.MayContain([]byte(fmt.Sprintf("key%d", i))) {
+			t.Errorf("expected key%d to be in the filter", i)
+		}
+	}
+
+	data, _ := afero.ReadFile(fs, "1.bloom")
+	for name, damaged := range map[string][]byte{
+		"truncated":   data[:len(data)-1],
+		"empty":       {},
+		"flipped bit": append(append([]byte{}, data[:30]...), append([]byte{data[30] ^ 1}, data[31:]...)...),
+		"wrong magic": append([]byte("XXXXXXXX"), data[8:]...),
+		"extra":       append(append([]byte{}, data...), 0),
+	} {
+		afero.WriteFile(fs, "damaged.bloom", damaged, 0644)
+		if _, _, err := ReadFile(fs, "damaged.bloom"); !errors.Is(err, ErrInvalidFile) {
+			t.Errorf("%s: expected ErrInvalidFile, got %v", name, err)
+		}
+	}
+}
@@ -8,19 +8,117 @@ import (
 	"os"
 	"time"
 
+	"github.com/ananthvk/kvdb/internal/kverrors"
 	"github.com/spf13/afero"
 )
 
 const fileHeaderVersionMajor = 2
-const fileHeaderVersionMinor = 0
+const fileHeaderVersionMinor = 21
 const fileHeaderVersionPatch = 0
 
+// Offset of the minor version in the header
+const versionMinorOffset = 9
+
+// Record formats. The lowest bit of the minor version of a data file header is the format of it's records, files with
+// v1 records have it unset, and files with v2 records have it set. Older readers reject files with a newer minor
+// version, so they never misread v2 records.
+//
+// v2 records have the value type of the record after the record type, files with such records also have bit 4 of the
+// minor version set. Files with v2 records written before the value type was added have it unset, they are read as
+// RecordFormatV2NoValueType, but never written
+const (
+	RecordFormatV1            = 1
+	RecordFormatV2            = 2
+	RecordFormatV2NoValueType = 3
+)
+
+// Bit of the minor version that's set for files with v2 records that have the value type
+const valueTypeShift = 4
+
+// Checksum algorithms of the records. The algorithm is stored in the next two bits of the minor version, files written
+// before the algorithm could be chosen have 0 (CRC32 IEEE). xxHash64 checksums are truncated to their low 32 bits, so
+// that records have the same layout with every algorithm
+const (
+	ChecksumCRC32    = 0
+	ChecksumCRC32C   = 1
+	ChecksumXXHash64 = 2
+)
+
+// Ciphers of the records. The cipher is stored in bit 3 of the minor version, it's not counted when the minor version
+// is compared with fileHeaderVersionMinor, readers without encryption support reject encrypted files since their minor
+// version is newer. See internal/record/cipher.go for the layout of encrypted records
+const (
+	CipherNone   = 0
+	CipherAESGCM = 1
+)
+
+// Bit of the minor version that's set for encrypted files
+const cipherShift = 3
+
+// Encoding is the format of the records of a data file, the algorithm of their checksums, and their cipher
+type Encoding struct {
+	RecordFormat int
+	Checksum     int
+	Cipher       int
+}
+
+// DefaultEncoding is the encoding of files written by WriteFileHeader, every version of kvdb reads it
+var DefaultEncoding = Encoding{RecordFormat: RecordFormatV1, Checksum: ChecksumCRC32}
+
+func (e Encoding) validate() error {
+	// RecordFormatV2NoValueType is only read, files are never written with it
+	if e.RecordFormat != RecordFormatV1 && e.RecordFormat != RecordFormatV2 {
+		return fmt.Errorf("unknown record format %d", e.RecordFormat)
+	}
+	if e.Checksum < ChecksumCRC32 || e.Checksum > ChecksumXXHash64 {
+		return fmt.Errorf("unknown checksum algorithm %d", e.Checksum)
+	}
+	if e.Cipher != CipherNone && e.Cipher != CipherAESGCM {
+		return fmt.Errorf("unknown cipher %d", e.Cipher)
+	}
+	return nil
+}
+
+// minorVersion returns the minor version of a header for the encoding
+func (e Encoding) minorVersion() byte {
+	minor := byte(e.Checksum)<<1 | byte(e.Cipher)<<cipherShift
+	switch e.RecordFormat {
+	case RecordFormatV2:
+		minor |= 1 | 1<<valueTypeShift
+	case RecordFormatV2NoValueType:
+		minor |= 1
+	}
+	return minor
+}
+
+// encodingOf returns the encoding given by a minor version, which must be supported (see isMinorSupported)
+func encodingOf(minor byte) Encoding {
+	format := RecordFormatV1
+	if minor&1 == 1 {
+		format = RecordFormatV2
+		if minor&(1<<valueTypeShift) == 0 {
+			format = RecordFormatV2NoValueType
+		}
+	}
+	return Encoding{
+		RecordFormat: format,
+		Checksum:     int(minor>>1) & 3,
+		Cipher:       int(minor>>cipherShift) & 1,
+	}
+}
+
+// isMinorSupported returns true if a file with the given minor version can be read, i.e. if it's not newer than
+// fileHeaderVersionMinor once the cipher bit is cleared, and if the value type bit is only set for v2 records
+func isMinorSupported(minor byte) bool {
+	return minor&^(1<<cipherShift) <= fileHeaderVersionMinor && (minor&1 == 1 || minor&(1<<valueTypeShift) == 0)
+}
+
 var fileHeaderMagicBytes = [...]byte{0x00, 0x6B, 0x76, 0x64, 0x62, 0x44, 0x41, 0x54}
 
 const FileHeaderSize = 19 // In bytes
 
 var (
-	ErrNotDataFile                  = errors.New("not a kvdb data file")
+	ErrNotDataFile                  = kverrors.New(kverrors.ErrCorrupt, "not a kvdb data file")
 	ErrDataFileVersionNotCompatible = errors.New("datafile not supported by reader")
 )
 
@@ -31,16 +129,55 @@ type FileHeader struct {
 	Timestamp    time.Time
 }
 
-// NewFileHeader creates a new file header
+// MagicBytes returns the bytes every data file starts with
+func MagicBytes() []byte {
+	return fileHeaderMagicBytes[:]
+}
+
+// Version returns the newest version of the data file format read and written by this package, in major.minor.patch
+// form
+func Version() string {
+	return fmt.Sprintf("%d.%d.%d", fileHeaderVersionMajor, fileHeaderVersionMinor, fileHeaderVersionPatch)
+}
+
+// NewFileHeader creates a new file header, for a file with the default encoding
 func NewFileHeader(ts time.Time) *FileHeader {
+	return NewFileHeaderWithEncoding(ts, DefaultEncoding)
+}
+
+// NewFileHeaderWithEncoding creates a new file header, for a file with the given encoding
+func NewFileHeaderWithEncoding(ts time.Time, encoding Encoding) *FileHeader {
 	return &FileHeader{
 		VersionMajor: fileHeaderVersionMajor,
-		VersionMinor: fileHeaderVersionMinor,
+		VersionMinor: encoding.minorVersion(),
 		VersionPatch: fileHeaderVersionPatch,
 		Timestamp:    ts,
 	}
 }
 
+// Encoding returns the format of the records in the file, the algorithm of their checksums, and their cipher
+func (h *FileHeader) Encoding() Encoding {
+	return encodingOf(h.VersionMinor)
+}
+
+// ReadEncoding returns the encoding of a data file, from the minor version in it's header. Only the minor version is
+// read, the rest of the header is checked by ReadFileHeader. Files shorter than the header (such as a new file, before
+// the header is written) have the default encoding
+func ReadEncoding(file io.ReaderAt) (Encoding, error) {
+	var buf [1]byte
+	if _, err := file.ReadAt(buf[:], versionMinorOffset); err != nil {
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return DefaultEncoding, nil
+		}
+		return Encoding{}, err
+	}
+	if !isMinorSupported(buf[0]) {
+		return Encoding{}, fmt.Errorf("%w - data file has minor version %d, reader has minor version %d",
+			ErrDataFileVersionNotCompatible, buf[0], fileHeaderVersionMinor)
+	}
+	return encodingOf(buf[0]), nil
+}
+
 func isFileVersionCompatible(fileMajor, fileMinor, filePatch byte) error {
 	// Major version mismatch - incompatible
 	if fileMajor != fileHeaderVersionMajor {
@@ -52,7 +189,7 @@ func isFileVersionCompatible(fileMajor, fileMinor, filePatch byte) error {
 		)
 	}
 	// File is newer (minor) than reader - incompatible
-	if fileMinor > fileHeaderVersionMinor {
+	if !isMinorSupported(fileMinor) {
 		return fmt.Errorf(
 			"%w - file was created by newer version (%d.%d.%d) of the application",
 			ErrDataFileVersionNotCompatible,
@@ -103,8 +240,16 @@ func ReadFileHeader(fs afero.Fs, path string) (*FileHeader, error) {
 
 // WriteFileHeader writes the data file header to the file at the given path. Note: It's assumed that the file pointer is at position 0 so that the header
 // can be written first. It also calls `file.Sync()` after writing the header to ensure that the header was written completely.
-// If the file already exists, it results in an error
+// If the file already exists, it results in an error. The header is for a file with the default encoding
 func WriteFileHeader(fs afero.Fs, path string, ts time.Time) error {
+	return WriteFileHeaderWithEncoding(fs, path, ts, DefaultEncoding)
+}
+
+// WriteFileHeaderWithEncoding is like WriteFileHeader, but the header is for a file with the given encoding
+func WriteFileHeaderWithEncoding(fs afero.Fs, path string, ts time.Time, encoding Encoding) error {
+	if err := encoding.validate(); err != nil {
+		return err
+	}
 	file, err := fs.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_EXCL, os.ModePerm)
 	if err != nil {
 		return err
@@ -117,7 +262,7 @@ func WriteFileHeader(fs afero.Fs, path string, ts time.Time) error {
 	copy(buf[:], fileHeaderMagicBytes[:])
 
 	buf[8] = fileHeaderVersionMajor
-	buf[9] = fileHeaderVersionMinor
+	buf[versionMinorOffset] = encoding.minorVersion()
 	buf[10] = fileHeaderVersionPatch
 
 	binary.LittleEndian.PutUint64(buf[11:], uint64(ts.UnixMicro()))
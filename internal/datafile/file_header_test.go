@@ -3,6 +3,7 @@ package datafile
 import (
 	"encoding/binary"
 	"errors"
+	"fmt"
 	"testing"
 	"time"
 
@@ -122,3 +123,74 @@ func TestReadFileHeader_IncompatibleVersion(t *testing.T) {
 		t.Fatalf("expected ErrDataFileVersionNotCompatible error due to incompatible version, got error %v", err)
 	}
 }
+
+func TestEncoding(t *testing.T) {
+	testFS := afero.NewMemMapFs()
+	ts := time.Now()
+	for _, recordFormat := range []int{RecordFormatV1, RecordFormatV2} {
+		for _, checksum := range []int{ChecksumCRC32, ChecksumCRC32C, ChecksumXXHash64} {
+			for _, cipher := range []int{CipherNone, CipherAESGCM} {
+				encoding := Encoding{RecordFormat: recordFormat, Checksum: checksum, Cipher: cipher}
+				path := fmt.Sprintf("%d-%d-%d.dat", recordFormat, checksum, cipher)
+				if err := WriteFileHeaderWithEncoding(testFS, path, ts, encoding); err != nil {
+					t.Fatalf("failed to write header: %v", err)
+				}
+				header, err := ReadFileHeader(testFS, path)
+				if err != nil {
+					t.Fatalf("failed to read header: %v", err)
+				}
+				if header.Encoding() != encoding {
+					t.Errorf("expected encoding %+v, got %+v", encoding, header.Encoding())
+				}
+				file, err := testFS.Open(path)
+				if err != nil {
+					t.Fatalf("failed to open file: %v", err)
+				}
+				got, err := ReadEncoding(file)
+				file.Close()
+				if err != nil || got != encoding {
+					t.Errorf("expected encoding %+v, got %+v, %v", encoding, got, err)
+				}
+			}
+		}
+	}
+
+	// Files written before the checksum could be chosen have CRC32 checksums, and their v2 records have no value type
+	for minor, recordFormat := range []int{RecordFormatV1, RecordFormatV2NoValueType} {
+		header := &FileHeader{VersionMinor: byte(minor)}
+		if expected := (Encoding{RecordFormat: recordFormat, Checksum: ChecksumCRC32}); header.Encoding() != expected {
+			t.Errorf("minor version %d: expected encoding %+v, got %+v", minor, expected, header.Encoding())
+		}
+	}
+
+	for _, encoding := range []Encoding{{RecordFormat: 3}, {RecordFormat: RecordFormatV1, Checksum: 3},
+		{RecordFormat: RecordFormatV1, Cipher: 2}} {
+		if err := WriteFileHeaderWithEncoding(testFS, "invalid.dat", ts, encoding); err == nil {
+			t.Errorf("expected an error for the unknown encoding %+v", encoding)
+		}
+	}
+
+	// A newer minor version is rejected, with or without the cipher bit, and so is the value type bit without v2
+	// records. A file without a header has the default encoding
+	for _, minor := range []byte{fileHeaderVersionMinor + 1, (fileHeaderVersionMinor + 1) | 1<<cipherShift, 1 << valueTypeShift} {
+		if err := afero.WriteFile(testFS, "newer.dat", []byte{9: minor, 18: 0}, 0666); err != nil {
+			t.Fatalf("failed to write file: %v", err)
+		}
+		file, err := testFS.Open("newer.dat")
+		if err != nil {
+			t.Fatalf("failed to open file: %v", err)
+		}
+		if _, err := ReadEncoding(file); !errors.Is(err, ErrDataFileVersionNotCompatible) {
+			t.Errorf("minor version %d: expected ErrDataFileVersionNotCompatible, got %v", minor, err)
+		}
+		file.Close()
+	}
+	empty, err := testFS.Create("empty.dat")
+	if err != nil {
+		t.Fatalf("failed to create file: %v", err)
+	}
+	defer empty.Close()
+	if got, err := ReadEncoding(empty); err != nil || got != DefaultEncoding {
+		t.Errorf("expected the default encoding for an empty file, got %+v, %v", got, err)
+	}
+}
@@ -0,0 +1,56 @@
+package datafile
+
+import (
+	"bytes"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/spf13/afero"
+)
+
+// FuzzReadFileHeader checks that ReadFileHeader does not panic on any file, and that a header it accepts has the magic
+// bytes and a compatible version, and is written back unchanged
+func FuzzReadFileHeader(f *testing.F) {
+	fs := afero.NewMemMapFs()
+	if err := WriteFileHeader(fs, "seed.dat", time.UnixMicro(1700000000000000)); err != nil {
+		f.Fatal(err)
+	}
+	seed, err := afero.ReadFile(fs, "seed.dat")
+	if err != nil {
+		f.Fatal(err)
+	}
+	f.Add(seed)
+	f.Add(seed[:FileHeaderSize-1])
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		fs := afero.NewMemMapFs()
+		if err := afero.WriteFile(fs, "fuzz.dat", data, os.ModePerm); err != nil {
+			t.Fatal(err)
+		}
+		header, err := ReadFileHeader(fs, "fuzz.dat")
+		if err != nil {
+			return
+		}
+		if !bytes.Equal(data[:len(fileHeaderMagicBytes)], fileHeaderMagicBytes[:]) {
+			t.Fatalf("accepted a header without the magic bytes: %x", data[:FileHeaderSize])
+		}
+		if header.VersionMajor != fileHeaderVersionMajor || !isMinorSupported(header.VersionMinor) {
+			t.Fatalf("accepted an incompatible version %d.%d.%d", header.VersionMajor, header.VersionMinor, header.VersionPatch)
+		}
+		// Only the current patch version can be written, so the round trip is only checked for it
+		if header.VersionPatch != fileHeaderVersionPatch {
+			return
+		}
+		if err := WriteFileHeaderWithEncoding(fs, "again.dat", header.Timestamp, header.Encoding()); err != nil {
+			t.Fatal(err)
+		}
+		again, err := afero.ReadFile(fs, "again.dat")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(again, data[:FileHeaderSize]) {
+			t.Fatalf("header %x was written back as %x", data[:FileHeaderSize], again)
+		}
+	})
+}
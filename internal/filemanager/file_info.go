@@ -0,0 +1,60 @@
+package filemanager
+
+import (
+	"time"
+
+	"github.com/ananthvk/kvdb/internal/datafile"
+	"github.com/ananthvk/kvdb/internal/record"
+)
+
+// FileHeaderInfo is what the header of a data file says about it, it does not change once the file is created
+type FileHeaderInfo struct {
+	Created  time.Time
+	Format   record.Format
+	Checksum record.Checksum
+}
+
+// HeaderInfo returns the header of the data file, it's read once, without opening a reader of the file
+func (f *FileManager) HeaderInfo(fileId int) (FileHeaderInfo, error) {
+	f.mu.RLock()
+	info, ok := f.headerInfos[fileId]
+	f.mu.RUnlock()
+	if ok {
+		return info, nil
+	}
+	header, err := datafile.ReadFileHeader(f.fs, f.DataFilePath(fileId))
+	if err != nil {
+		return FileHeaderInfo{}, err
+	}
+	encoding := header.Encoding()
+	info = FileHeaderInfo{
+		Created:  header.Timestamp,
+		Format:   record.Format(encoding.RecordFormat),
+		Checksum: record.Checksum(encoding.Checksum),
+	}
+	f.mu.Lock()
+	f.headerInfos[fileId] = info
+	f.mu.Unlock()
+	return info, nil
+}
+
+// RestoreFileInfo sets the header and the key and timestamp ranges of a data file (which were recorded before the
+// datastore was closed) if they are not known yet, so that they don't have to be read from the file
+func (f *FileManager) RestoreFileInfo(fileId int, header FileHeaderInfo, meta *FileMeta) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, ok := f.headerInfos[fileId]; !ok {
+		f.headerInfos[fileId] = header
+	}
+	if _, ok := f.fileMetas[fileId]; !ok && meta != nil {
+		f.fileMetas[fileId] = meta
+	}
+}
+
+// SetOnRotate sets a function that's called with the id of the active data file when it's sealed and writes move on to
+// a new file. It's called while the file manager's lock is held, so it must not block, or call the file manager
+func (f *FileManager) SetOnRotate(fn func(sealedId int)) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.onRotate = fn
+}
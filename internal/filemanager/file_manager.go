@@ -1,163 +1,671 @@
 package filemanager
 
 import (
+	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"io"
+	"log/slog"
+	"os"
 	"path/filepath"
 	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
+	"unsafe"
 
 	"github.com/ananthvk/kvdb/internal/datafile"
-	"github.com/ananthvk/kvdb/internal/hintfile"
 	"github.com/ananthvk/kvdb/internal/keydir"
+	"github.com/ananthvk/kvdb/internal/kverrors"
+	"github.com/ananthvk/kvdb/internal/lockprof"
 	"github.com/ananthvk/kvdb/internal/record"
 	"github.com/ananthvk/kvdb/internal/utils"
 	"github.com/spf13/afero"
 )
 
 const mergePrefix = "merge"
+const adoptPrefix = "adopt"
 
 type FileManager struct {
-	mu                 sync.RWMutex
-	fs                 afero.Fs
-	dataStoreRootPath  string
-	readers            map[int]*record.Reader
-	rotateWriter       *RotateWriter
-	activeDataFile     int
-	nextDataFileNumber int
+	mu             sync.RWMutex
+	fs             *CountingFs
+	layout         Layout
+	readers        map[int]*record.Reader
+	rotateWriter   *RotateWriter
+	activeDataFile int
+	loadReport     LoadReport
+	lockProfiler   *lockprof.Profiler
+	limits         record.Limits
+	// When every cached reader was last used, by the ticks of useClock, so that the least recently used readers can be
+	// closed to stay within the open file budget (maxOpenFiles, 0 if there is none), see open_files.go
+	readerUse       map[int]*atomic.Uint64
+	useClock        atomic.Uint64
+	maxOpenFiles    int
+	openFileReserve int
+	readerEvictions atomic.Uint64
+	// Bytes written to the active file since the last Sync (or rotation, which syncs the previous file)
+	unsyncedBytes int64
+	// Bytes of records of the active file that are in the write buffer and not in the file yet, so that Flush can return
+	// without the lock when there is nothing to flush, and the records that are (partly) in the buffer, which reads look
+	// up before the file, see write_tail.go
+	bufferedBytes atomic.Int64
+	tail          *writeTail
+	// Size of every data file by id, and their total. They are kept up to date as files are written, adopted, and
+	// replaced by merges, so that DataFileStats does not have to list the data directory
+	dataFileSizes map[int]int64
+	dataFileBytes int64
+	// Key and timestamp ranges of the data files, see file_meta.go
+	fileMetas map[int]*FileMeta
+	// Headers of the data files that were read (or restored), and the function called when the active file is sealed,
+	// see file_info.go
+	headerInfos map[int]FileHeaderInfo
+	onRotate    func(sealedId int)
+	// Gives out the ids of new data files, maxFileId is the largest id that was claimed or allocated
+	allocator IdAllocator
+	maxFileId int
+	// Readers of immutable data files are memory mapped if mmapReads is set
+	mmapReads bool
+	// Cache of recently read values, nil if it's disabled
+	valueCache *valueCache
+	// Keys of encrypted data files, nil if the datastore is not encrypted, see internal/record/cipher.go
+	keyring *record.Keyring
 }
 
+// LoadReport lists the problems found in the data directory while opening the file manager and reading the keydir
+type LoadReport struct {
+	// Names of files in the data directory that are not data files, these are ignored
+	UnknownFiles []string
+	// Ids of data files that could not be read completely
+	InvalidDataFiles []int
+	// Ids of data files whose hint file was ignored because it could not be read, or did not match the data file
+	IgnoredHintFiles []int
+}
+
+// parseDataFileId returns the id of the data file with the given name. Only names generated by GetDataFileName are
+// data files, anything else (editor backups, core dumps, temporary merge files) is not
+func parseDataFileId(name string) (int, bool) {
+	idPart, ok := strings.CutSuffix(name, ".dat")
+	if !ok {
+		return 0, false
+	}
+	id, err := strconv.ParseInt(idPart, 10, 32)
+	if err != nil || id < 0 || utils.GetDataFileName(int(id)) != name {
+		return 0, false
+	}
+	return int(id), true
+}
+
+// NewFileManager creates a file manager for the datastore at path, new data files get ids from a counter that starts
+// after the largest id in the datastore
 func NewFileManager(fs afero.Fs, path string, maxDatafileSize int) (*FileManager, error) {
-	// In ${root}/data directory, find the file with the numerical maximum value, and open it for writing
+	return NewFileManagerWithAllocator(fs, path, maxDatafileSize, NewCounterAllocator())
+}
+
+// NewFileManagerWithAllocator is like NewFileManager, but new data files get ids from the given allocator. The ids of
+// the files in the datastore are claimed from the allocator, and an error wrapping ErrFileIdCollision is returned if it
+// rejects one
+func NewFileManagerWithAllocator(fs afero.Fs, path string, maxDatafileSize int, allocator IdAllocator) (*FileManager, error) {
+	return NewFileManagerWithLayout(fs, DefaultLayout(path), maxDatafileSize, allocator)
+}
+
+// NewFileManagerWithLayout is like NewFileManagerWithAllocator, for a datastore whose files are in the directories of
+// the given layout
+func NewFileManagerWithLayout(fs afero.Fs, layout Layout, maxDatafileSize int, allocator IdAllocator) (*FileManager, error) {
+	// In the data directories, find the file with the numerical maximum value, and open it for writing
 	// If the file is not a data file, it'll be skipped
-	dataDirPath := filepath.Join(path, "data")
-	entries, err := afero.ReadDir(fs, dataDirPath)
-	if err != nil {
-		return nil, err
-	}
 	maxDatafileNumber := 0
-	for _, entry := range entries {
-		if !entry.IsDir() {
-			filename := entry.Name()
-			extension := filepath.Ext(filename)
-			nameWithoutExt := strings.TrimSuffix(filename, extension)
-			i, err := strconv.ParseInt(nameWithoutExt, 10, 32)
-			if err != nil {
-				continue
-			}
+	var unknownFiles []string
+	dataFileSizes := map[int]int64{}
+	var dataFileBytes int64
+	for _, dataDirPath := range layout.DataDirs() {
+		entries, err := afero.ReadDir(fs, dataDirPath)
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			if !entry.IsDir() {
+				i, ok := parseDataFileId(entry.Name())
+				if !ok {
+					// Not a data file, it must not influence file id allocation
+					slog.Warn("skipping unknown file in data directory", "path", filepath.Join(dataDirPath, entry.Name()), "size", entry.Size())
+					unknownFiles = append(unknownFiles, entry.Name())
+					continue
+				}
 
-			// Note: This may or may not be the latest active file
-			// but it doesn't matter in this case (except the case of crash recover)
-			maxDatafileNumber = max(maxDatafileNumber, int(i))
+				// Note: This may or may not be the latest active file
+				// but it doesn't matter in this case (except the case of crash recover)
+				maxDatafileNumber = max(maxDatafileNumber, i)
+				dataFileSizes[i] = entry.Size()
+				dataFileBytes += entry.Size()
+			}
 		}
 	}
 
+	maxFileId, err := claimIds(fs, layout.HintDir, allocator, dataFileSizes)
+	if err != nil {
+		return nil, err
+	}
+
 	// TODO: Implement crash recovery & check to see if it has exceeded max size
+	// Files are counted by the file system of the datastore if it's a CountingFs, so that the files it opens count too
+	counting, ok := fs.(*CountingFs)
+	if !ok {
+		counting = NewCountingFs(fs)
+	}
 	fileManager := &FileManager{
-		fs:                 fs,
-		dataStoreRootPath:  path,
-		readers:            map[int]*record.Reader{},
-		activeDataFile:     maxDatafileNumber,
-		nextDataFileNumber: maxDatafileNumber + 1,
+		fs:             counting,
+		layout:         layout,
+		readers:        map[int]*record.Reader{},
+		readerUse:      map[int]*atomic.Uint64{},
+		activeDataFile: maxDatafileNumber,
+		loadReport:     LoadReport{UnknownFiles: unknownFiles},
+		limits:         record.DefaultLimits,
+		dataFileSizes:  dataFileSizes,
+		dataFileBytes:  dataFileBytes,
+		fileMetas:      map[int]*FileMeta{},
+		headerInfos:    map[int]FileHeaderInfo{},
+		allocator:      allocator,
+		maxFileId:      maxFileId,
+		tail:           newWriteTail(),
 	}
 
-	fileManager.rotateWriter = NewRotateWriter(fs, maxDatafileSize, false, func() string {
-		dataFileName := utils.GetDataFileName(fileManager.nextDataFileNumber)
+	fileManager.rotateWriter = NewRotateWriter(counting, maxDatafileSize, false, func() (string, error) {
 		// Note: Because of this, each time a restart happens, a new file will be created
 		// And all previous files will be treated as immutable
 		// This is safer for crash recovery, but it's not efficient since a new file is created on every restart
 		// TODO: Fix this later
-		fileManager.activeDataFile = fileManager.nextDataFileNumber
-		fileManager.nextDataFileNumber++
-		return filepath.Join(dataDirPath, dataFileName)
+		id, err := fileManager.allocateIds(1)
+		if err != nil {
+			return "", err
+		}
+		if sealed := fileManager.activeDataFile; fileManager.onRotate != nil && fileManager.dataFileSizes[sealed] > 0 {
+			fileManager.onRotate(sealed)
+		}
+		fileManager.activeDataFile = id
+		return filepath.Join(layout.ActiveDir, utils.GetDataFileName(id)), nil
 	})
 
 	return fileManager, nil
 }
 
+// SetLockProfiler sets the profiler used to sample wait times of the file manager's lock, it must be called before the
+// file manager is used concurrently
+func (f *FileManager) SetLockProfiler(p *lockprof.Profiler) {
+	f.lockProfiler = p
+}
+
+// SetLimits sets the largest key and value sizes of the datastore, they are used by every reader, scanner and writer
+// of the file manager. It must be called before the keydir is read
+func (f *FileManager) SetLimits(limits record.Limits) {
+	f.limits = limits
+	f.rotateWriter.SetLimits(limits)
+}
+
+// SetKeyring sets the keys encrypted data files are read with, and makes new data files (including the files written
+// by merge writers) encrypted if the keyring has a current key. It must be called before the keydir is read
+func (f *FileManager) SetKeyring(keyring *record.Keyring) {
+	f.keyring = keyring
+	f.rotateWriter.SetKeyring(keyring)
+}
+
+// Keyring returns the keys of encrypted data files, nil if none were set
+func (f *FileManager) Keyring() *record.Keyring {
+	return f.keyring
+}
+
+// SetRecordFormat sets the format of the records of new data files, including the files written by merge writers. It
+// must be called before the first write
+func (f *FileManager) SetRecordFormat(format record.Format) {
+	f.rotateWriter.SetFormat(format)
+}
+
+// RecordFormat returns the format of the records of new data files
+func (f *FileManager) RecordFormat() record.Format {
+	return f.rotateWriter.format
+}
+
+// SetChecksum sets the algorithm of the checksums of the records of new data files, including the files written by
+// merge writers. It must be called before the first write
+func (f *FileManager) SetChecksum(checksum record.Checksum) {
+	f.rotateWriter.SetChecksum(checksum)
+}
+
+// Checksum returns the algorithm of the checksums of the records of new data files
+func (f *FileManager) Checksum() record.Checksum {
+	return f.rotateWriter.checksum
+}
+
+// SetPreallocate makes new data files (including the files written by merge writers) reserve their maximum size on
+// disk when they are created, see internal/vfs/vfs.go. It must be called before the first write
+func (f *FileManager) SetPreallocate(preallocate bool) {
+	f.rotateWriter.SetPreallocate(preallocate)
+}
+
+// SetRotationLimits makes the active file rotate once it has maxRecords records, or on the first write after it's older
+// than maxAge, besides when it's full. 0 disables either limit. It must be called before the first write
+func (f *FileManager) SetRotationLimits(maxRecords int, maxAge time.Duration) {
+	f.rotateWriter.SetRotationLimits(maxRecords, maxAge)
+}
+
+// SetWriteBufferSize makes the active file buffer up to size bytes of records in memory before they are written to the
+// file, 0 disables the buffer. The records that are in the buffer are read from memory, so reads see every write, see
+// write_tail.go. It must be called before the first write
+func (f *FileManager) SetWriteBufferSize(size int) {
+	f.rotateWriter.SetBufferSize(size)
+}
+
+// Flush writes the buffered records of the active file to the file, without syncing it
+func (f *FileManager) Flush() error {
+	if f.bufferedBytes.Load() == 0 {
+		return nil
+	}
+	f.lockProfiler.Lock(&f.mu, "filemanager.flush")
+	defer f.mu.Unlock()
+	return f.flushLocked()
+}
+
+// flushLocked is Flush, the caller must hold the lock
+func (f *FileManager) flushLocked() error {
+	if err := f.rotateWriter.Flush(); err != nil {
+		return err
+	}
+	f.bufferedBytes.Store(0)
+	f.tail.clear()
+	return nil
+}
+
+// SetMmapReads enables memory mapping of the data files that are read, except the active file, which is read with
+// pread since it's still growing. It must be called before the file manager is used concurrently
+func (f *FileManager) SetMmapReads(enabled bool) {
+	f.mmapReads = enabled
+}
+
+// SetValueCacheSize enables the cache of recently read values, with a budget of the given number of bytes. 0 disables
+// the cache. It must be called before the file manager is used concurrently
+func (f *FileManager) SetValueCacheSize(bytes int64) {
+	f.valueCache = nil
+	if bytes > 0 {
+		f.valueCache = newValueCache(bytes)
+	}
+}
+
+// InvalidateValue removes the value of the record at the offset from the value cache, it's called when the key of the
+// record is overwritten or deleted, since the value will not be read again
+func (f *FileManager) InvalidateValue(fileId int, offset int64) {
+	if f.valueCache != nil {
+		f.valueCache.invalidate(fileId, offset)
+	}
+}
+
+// ValueCacheStats returns the counters of the value cache, they are zero if the cache is disabled
+func (f *FileManager) ValueCacheStats() ValueCacheStats {
+	if f.valueCache == nil {
+		return ValueCacheStats{}
+	}
+	return f.valueCache.stats()
+}
+
 // WriteKeyValue Returns fileId, offset (from start of file), error if any
 func (f *FileManager) Write(key []byte, value []byte, isTombstone bool) (int, int64, error) {
-	f.mu.Lock()
+	return f.WriteWithTs(key, value, isTombstone, time.Now())
+}
+
+// WriteWithTs is like Write, but the record gets the given timestamp instead of the current time
+func (f *FileManager) WriteWithTs(key []byte, value []byte, isTombstone bool, ts time.Time) (int, int64, error) {
+	recordType := uint8(record.RecordTypePut)
+	if isTombstone {
+		recordType = record.RecordTypeDelete
+	}
+	return f.WriteRecordWithTs(key, value, recordType, ts)
+}
+
+// WriteRecordWithTs is like WriteWithTs, but writes a record of the given type (one of the record.RecordType constants)
+func (f *FileManager) WriteRecordWithTs(key []byte, value []byte, recordType uint8, ts time.Time) (int, int64, error) {
+	return f.WriteRecordWithHeader(record.Header{Timestamp: ts, RecordType: recordType}, key, value)
+}
+
+// WriteRecordWithHeader is like WriteRecordWithTs, with the timestamp, record type and value type of the header (the
+// sizes of the header are ignored)
+func (f *FileManager) WriteRecordWithHeader(header record.Header, key []byte, value []byte) (int, int64, error) {
+	ts := header.Timestamp
+	f.lockProfiler.Lock(&f.mu, "filemanager.write")
 	defer f.mu.Unlock()
-	_, offset, err := f.rotateWriter.Write(key, value, isTombstone)
+	previousFile := f.activeDataFile
+	bufferedBefore := f.rotateWriter.Buffered()
+	_, offset, err := f.rotateWriter.WriteRecordWithHeader(header, key, value)
+	if err == nil {
+		if f.activeDataFile != previousFile {
+			f.unsyncedBytes = 0
+			bufferedBefore = 0
+		}
+		size := f.rotateWriter.EncodedSize(uint32(len(key)), uint32(len(value)))
+		f.unsyncedBytes += size
+		f.setDataFileSize(f.activeDataFile, offset+size)
+		f.addFileMeta(f.activeDataFile, key, ts)
+		f.trackWrite(offset, size, bufferedBefore, func() record.Record {
+			header.KeySize, header.ValueSize = uint32(len(key)), uint32(len(value))
+			return record.Record{Header: header, Key: key, Value: value, Size: size}
+		})
+	}
 	return f.activeDataFile, offset, err
 }
 
+// WriteRecords writes the records (like WriteRecordWithHeader) with one write per data file they go in, instead of one
+// per record. It returns the file id and offset of the records that were written, which are all of them unless the
+// error is not nil
+func (f *FileManager) WriteRecords(records []record.Record) ([]int, []int64, error) {
+	f.lockProfiler.Lock(&f.mu, "filemanager.write")
+	defer f.mu.Unlock()
+	fileIds := make([]int, 0, len(records))
+	offsets := make([]int64, 0, len(records))
+	for len(records) > 0 {
+		previousFile := f.activeDataFile
+		bufferedBefore := f.rotateWriter.Buffered()
+		n, written, err := f.rotateWriter.WriteRecords(records)
+		if err != nil {
+			return fileIds, offsets, err
+		}
+		if f.activeDataFile != previousFile {
+			f.unsyncedBytes = 0
+			bufferedBefore = 0
+		}
+		var total int64
+		sizes := make([]int64, n)
+		for i := range n {
+			sizes[i] = f.rotateWriter.EncodedSize(records[i].Header.KeySize, records[i].Header.ValueSize)
+			records[i].Size = sizes[i]
+			total += sizes[i]
+			f.addFileMeta(f.activeDataFile, records[i].Key, records[i].Header.Timestamp)
+		}
+		f.unsyncedBytes += total
+		end := written[n-1] + sizes[n-1]
+		f.setDataFileSize(f.activeDataFile, end)
+		f.trackWrites(written, sizes, end, bufferedBefore, records[:n])
+		for _, offset := range written {
+			fileIds = append(fileIds, f.activeDataFile)
+			offsets = append(offsets, offset)
+		}
+		records = records[n:]
+	}
+	return fileIds, offsets, nil
+}
+
+// WriteRecordFromReader is like WriteRecordWithHeader, but the value (of header.ValueSize bytes) is copied from r. The
+// file manager's lock is held until the whole value has been copied
+func (f *FileManager) WriteRecordFromReader(header record.Header, key []byte, r io.Reader) (int, int64, error) {
+	f.lockProfiler.Lock(&f.mu, "filemanager.write")
+	defer f.mu.Unlock()
+	previousFile := f.activeDataFile
+	_, offset, err := f.rotateWriter.WriteRecordFromReader(header, key, r)
+	if err == nil {
+		if f.activeDataFile != previousFile {
+			f.unsyncedBytes = 0
+		}
+		size := f.rotateWriter.EncodedSize(uint32(len(key)), header.ValueSize)
+		f.unsyncedBytes += size
+		f.setDataFileSize(f.activeDataFile, offset+size)
+		f.addFileMeta(f.activeDataFile, key, header.Timestamp)
+		// The value is not in memory to be kept in the tail, see write_tail.go
+		err = f.flushLocked()
+	}
+	return f.activeDataFile, offset, err
+}
+
+// UnsyncedBytes returns the number of bytes written since the last Sync
+func (f *FileManager) UnsyncedBytes() int64 {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return f.unsyncedBytes
+}
+
 // ReadRecordAtStrict reads a record at a specific offset in the data file.
 // It caches the reader in the map for future use.
-func (f *FileManager) ReadRecordAtStrict(fileId int, offset int64) (*record.Record, error) {
-	reader, err := f.GetReader(fileId)
+func (f *FileManager) ReadRecordAtStrict(fileId int, offset int64) (rec *record.Record, err error) {
+	if rec, ok := f.tail.get(fileId, offset); ok {
+		return rec, nil
+	}
+	err = f.retryEvicted(fileId, func() error {
+		reader, err := f.GetReader(fileId)
+		if err != nil {
+			return err
+		}
+		rec, err = reader.ReadRecordAtStrict(offset)
+		return err
+	})
 	if err != nil {
-		return nil, err
+		return nil, kverrors.WrapFile(err, f.DataFilePath(fileId), offset)
 	}
-	return reader.ReadRecordAtStrict(offset)
+	return rec, nil
 }
 
 // ReadValueAt reads the value at a specific offset in the data file.
-// It caches the reader in the map for future use.
+// It caches the reader in the map for future use. If the value cache is enabled, the record is served from it when
+// possible
 func (f *FileManager) ReadValueAt(fileId int, offset int64) (*record.Record, error) {
-	reader, err := f.GetReader(fileId)
+	if rec, ok := f.tail.get(fileId, offset); ok {
+		return rec, nil
+	}
+	if f.valueCache != nil {
+		if rec, ok := f.valueCache.get(fileId, offset); ok {
+			return rec, nil
+		}
+	}
+	var rec *record.Record
+	err := f.retryEvicted(fileId, func() error {
+		reader, err := f.GetReader(fileId)
+		if err != nil {
+			return err
+		}
+		rec, err = reader.ReadValueAt(offset)
+		return err
+	})
+	if err == nil && f.valueCache != nil {
+		f.valueCache.add(fileId, offset, rec)
+	}
 	if err != nil {
-		return nil, err
+		return nil, kverrors.WrapFile(err, f.DataFilePath(fileId), offset)
 	}
-	return reader.ReadValueAt(offset)
+	return rec, nil
 }
 
-// CloseAndDeleteReaders closes and deletes the readers for the given list of IDs.
-func (f *FileManager) CloseAndDeleteReaders(ids []int) {
-	f.mu.Lock()
-	defer f.mu.Unlock()
-	for _, id := range ids {
-		if reader, exists := f.readers[id]; exists {
-			reader.Close()
-			delete(f.readers, id)
+// ReadValueInto is like ReadValueAt, but reads the value into dst (which is grown if it's too small) and returns it. It
+// does not allocate if dst is large enough, and the reader of the file is cached
+func (f *FileManager) ReadValueInto(fileId int, offset int64, dst []byte) ([]byte, error) {
+	if rec, ok := f.tail.get(fileId, offset); ok {
+		return append(dst[:0], rec.Value...), nil
+	}
+	if f.valueCache != nil {
+		if value, ok := f.valueCache.getInto(fileId, offset, dst); ok {
+			return value, nil
 		}
 	}
+	var reader *record.Reader
+	var header record.Header
+	value := dst
+	err := f.retryEvicted(fileId, func() error {
+		var err error
+		if reader, err = f.GetReader(fileId); err != nil {
+			return err
+		}
+		header, value, err = reader.ReadValueInto(offset, dst)
+		return err
+	})
+	if err == nil && f.valueCache != nil {
+		f.valueCache.add(fileId, offset, &record.Record{
+			Header: header,
+			Value:  value,
+			Size:   reader.EncodedSize(header.KeySize, header.ValueSize),
+		})
+	}
+	if err != nil {
+		return value, kverrors.WrapFile(err, f.DataFilePath(fileId), offset)
+	}
+	return value, nil
+}
+
+// OpenValue returns a reader of the value of the record at the given offset, and the size of the value. The data file
+// is opened again for the reader (instead of using the cached reader, which is closed when the file is removed by a
+// merge or evicted from the cache), and is closed when the returned reader is closed
+func (f *FileManager) OpenValue(fileId int, offset int64) (io.ReadCloser, int64, error) {
+	if rec, ok := f.tail.get(fileId, offset); ok {
+		return io.NopCloser(bytes.NewReader(rec.Value)), int64(len(rec.Value)), nil
+	}
+	f.mu.Lock()
+	fits := f.evictReaders(0)
+	f.mu.Unlock()
+	if !fits {
+		return nil, 0, fmt.Errorf("%w: %d files are open", ErrOpenFileLimit, f.OpenFiles())
+	}
+	path := f.DataFilePath(fileId)
+	reader, err := record.NewReader(f.fs, path)
+	if err != nil {
+		return nil, 0, kverrors.WrapFile(err, path, -1)
+	}
+	reader.SetLimits(f.limits)
+	reader.SetKeyring(f.keyring)
+	section, err := reader.ValueReader(offset)
+	if err != nil {
+		reader.Close()
+		return nil, 0, kverrors.WrapFile(err, path, offset)
+	}
+	return &valueReader{SectionReader: section, reader: reader}, section.Size(), nil
+}
+
+// valueReader reads a value from a data file, and closes the file when it's closed. It's also an io.Seeker and an
+// io.ReaderAt
+type valueReader struct {
+	*io.SectionReader
+	reader *record.Reader
+}
+
+func (v *valueReader) Close() error {
+	return v.reader.Close()
+}
+
+// LoadProgress describes how far ReadKeydirContext is
+type LoadProgress struct {
+	// Number of data files, and how many of them have been loaded (from their hint file, or by scanning them)
+	TotalFiles  int
+	LoadedFiles int
+	// Number of the loaded files that were loaded from their hint file
+	HintFiles int
+	// Records (or hints) read so far
+	Records int64
+}
+
+// Records read between checks of the context, and calls of the progress function of ReadKeydirContext
+const loadCheckInterval = 4096
+
+// keydirLoad is the state of ReadKeydirContext
+type keydirLoad struct {
+	ctx      context.Context
+	progress func(LoadProgress)
+	LoadProgress
+}
+
+// recordRead counts a record, and checks the context (and reports the progress) every loadCheckInterval records
+func (l *keydirLoad) recordRead() error {
+	l.Records++
+	if l.Records%loadCheckInterval != 0 {
+		return nil
+	}
+	if l.progress != nil {
+		l.progress(l.LoadProgress)
+	}
+	return l.ctx.Err()
 }
 
+// ReadKeydir builds the keydir from the hint files and the data files
 func (f *FileManager) ReadKeydir() (*keydir.Keydir, error) {
-	dataDirPath := filepath.Join(f.dataStoreRootPath, "data")
+	return f.ReadKeydirContext(context.Background(), nil)
+}
+
+// ReadKeydirContext is like ReadKeydir, but stops and returns the context's error if ctx is done before the keydir is
+// built. progress (if it's not nil) is called every few thousand records, and after every data file
+func (f *FileManager) ReadKeydirContext(ctx context.Context, progress func(LoadProgress)) (*keydir.Keydir, error) {
 	kd := keydir.NewKeydir()
 	ids, err := f.getSortedDataFileIDs()
 	if err != nil {
 		return nil, err
 	}
+	load := &keydirLoad{ctx: ctx, progress: progress, LoadProgress: LoadProgress{TotalFiles: len(ids)}}
+	fileLoaded := func() error {
+		load.LoadedFiles++
+		if progress != nil {
+			progress(load.LoadProgress)
+		}
+		return ctx.Err()
+	}
 	for _, id := range ids {
-		fileName := utils.GetDataFileName(id)
-		datafilePath := filepath.Join(dataDirPath, fileName)
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		datafilePath := f.DataFilePath(id)
 
 		// Check if it's a datafile
-		if _, err := datafile.ReadFileHeader(f.fs, datafilePath); err != nil {
-			fmt.Printf("build keydir, skip %s, error: %s\n", fileName, err)
+		header, err := datafile.ReadFileHeader(f.fs, datafilePath)
+		if err != nil {
+			slog.Warn("build keydir, skipping invalid data file", "path", datafilePath, "error", err)
+			f.loadReport.InvalidDataFiles = append(f.loadReport.InvalidDataFiles, id)
+			if err := fileLoaded(); err != nil {
+				return nil, err
+			}
 			continue
 		}
+		// An encrypted file that can't be decrypted is not damaged, the datastore can't be opened without it's key
+		if header.Encoding().Cipher != datafile.CipherNone && f.keyring == nil {
+			return nil, kverrors.WrapFile(record.ErrNoKey, datafilePath, -1)
+		}
 
-		// Check if there is a hint file
-		hintfilePath := filepath.Join(f.dataStoreRootPath, "hint", utils.GetHintFileName(id))
-		scanner, err := hintfile.NewScanner(f.fs, hintfilePath)
-		if err != nil {
-			// Error while reading hint file / hint file does not exist, create the keydir from scratch
-			err = f.addRecordsToKeydir(kd, id)
-			if err != nil {
-				fmt.Printf("build keydir, %s error: %s\n", fileName, err)
-			}
-		} else {
-			// Use the hintfile to build the keydir
-			for {
-				rec, err := scanner.Scan()
-				if err != nil {
-					if errors.Is(err, io.EOF) {
-						break
-					}
-					// TODO: In case of error, fall back to reading from actual datafile
-					return nil, err
+		// Use the hint file (if it exists, and matches the data file) to build the keydir
+		hints, err := f.readVerifiedHints(id)
+		if err == nil {
+			for _, rec := range hints {
+				f.addFileMeta(id, rec.Key, rec.Timestamp)
+				if rec.Tombstone {
+					kd.DeleteRecordIfNotNewer(rec.Key, rec.Timestamp)
+					continue
 				}
-				kd.AddKeydirRecord(rec.Key, id, rec.ValueSize, rec.ValuePos, rec.Timestamp)
+				kd.Add(rec.Key, keydir.KeydirRecord{
+					FileId:    id,
+					ValueSize: rec.ValueSize,
+					ValuePos:  rec.ValuePos,
+					Timestamp: rec.Timestamp,
+					Immutable: rec.Immutable,
+					ValueType: rec.ValueType,
+				})
+			}
+			load.Records += int64(len(hints))
+			load.HintFiles++
+			if err := fileLoaded(); err != nil {
+				return nil, err
 			}
+			continue
+		}
+		if !errors.Is(err, os.ErrNotExist) {
+			slog.Warn("build keydir, ignoring hint file", "path", datafilePath, "error", err)
+			f.loadReport.IgnoredHintFiles = append(f.loadReport.IgnoredHintFiles, id)
+		}
+		// Create the keydir from scratch
+		err = f.addRecordsToKeydir(kd, id, load)
+		if errors.Is(err, record.ErrDecrypt) {
+			return nil, err
+		}
+		if ctxErr := ctx.Err(); ctxErr != nil && errors.Is(err, ctxErr) {
+			return nil, err
+		}
+		if err != nil {
+			slog.Warn("build keydir, could not read data file completely", "path", datafilePath, "error", err)
+			// The range only covers the records that could be read
+			delete(f.fileMetas, id)
+			delete(f.headerInfos, id)
+			f.loadReport.InvalidDataFiles = append(f.loadReport.InvalidDataFiles, id)
+		}
+		if err := fileLoaded(); err != nil {
+			return nil, err
 		}
 	}
 	return kd, nil
@@ -166,7 +674,13 @@ func (f *FileManager) ReadKeydir() (*keydir.Keydir, error) {
 func (f *FileManager) Sync() error {
 	f.mu.Lock()
 	defer f.mu.Unlock()
-	return f.rotateWriter.Sync()
+	if err := f.rotateWriter.Sync(); err != nil {
+		return err
+	}
+	f.bufferedBytes.Store(0)
+	f.tail.clear()
+	f.unsyncedBytes = 0
+	return nil
 }
 
 func (f *FileManager) Close() error {
@@ -175,31 +689,49 @@ func (f *FileManager) Close() error {
 	if err := f.rotateWriter.Close(); err != nil {
 		return err
 	}
-	for _, reader := range f.readers {
-		reader.Close()
+	f.bufferedBytes.Store(0)
+	f.tail.clear()
+	for id := range f.readers {
+		f.closeReader(id)
 	}
 
 	return nil
 }
 
-func (f *FileManager) addRecordsToKeydir(kd *keydir.Keydir, fileId int) error {
-	scanner, err := record.NewScanner(f.fs, filepath.Join(f.dataStoreRootPath, "data", utils.GetDataFileName(fileId)))
+func (f *FileManager) addRecordsToKeydir(kd *keydir.Keydir, fileId int, load *keydirLoad) error {
+	path := f.DataFilePath(fileId)
+	scanner, err := record.NewScanner(f.fs, path)
 	if err != nil {
-		return err
+		return kverrors.WrapFile(err, path, -1)
 	}
 	defer scanner.Close()
+	scanner.SetLimits(f.limits)
+	scanner.SetKeyring(f.keyring)
+	var nextOffset int64
 	for {
 		rec, offset, err := scanner.Scan()
 		if err != nil {
 			if errors.Is(err, io.EOF) {
 				break
 			}
+			return kverrors.WrapFile(err, path, nextOffset)
+		}
+		nextOffset = offset + rec.Size
+		if err := load.recordRead(); err != nil {
 			return err
 		}
+		f.addFileMeta(fileId, rec.Key, rec.Header.Timestamp)
 		if rec.Header.RecordType == record.RecordTypeDelete {
-			kd.DeleteRecord(rec.Key)
+			kd.DeleteRecordIfNotNewer(rec.Key, rec.Header.Timestamp)
 		} else {
-			kd.AddKeydirRecord(rec.Key, fileId, rec.Header.ValueSize, offset, rec.Header.Timestamp)
+			kd.Add(rec.Key, keydir.KeydirRecord{
+				FileId:    fileId,
+				ValueSize: rec.Header.ValueSize,
+				ValuePos:  offset,
+				Timestamp: rec.Header.Timestamp,
+				Immutable: rec.Header.RecordType == record.RecordTypePutImmutable,
+				ValueType: rec.Header.ValueType,
+			})
 		}
 	}
 	return nil
@@ -208,14 +740,22 @@ func (f *FileManager) addRecordsToKeydir(kd *keydir.Keydir, fileId int) error {
 // Use Double-Checked locking to create / return cached reader
 func (f *FileManager) GetReader(fileId int) (*record.Reader, error) {
 	// Check if reader already exists
-	f.mu.RLock()
+	f.lockProfiler.Lock(f.mu.RLocker(), "filemanager.get_reader")
 	reader, exists := f.readers[fileId]
+	immutable := fileId != f.activeDataFile
+	if exists {
+		f.touchReader(fileId)
+	}
 	f.mu.RUnlock()
 	if exists {
+		if f.mmapReads && immutable {
+			// The reader may have been opened while the file was the active file, only the first call maps it
+			f.mmapReader(reader, fileId)
+		}
 		return reader, nil
 	}
 
-	f.mu.Lock()
+	f.lockProfiler.Lock(&f.mu, "filemanager.open_reader")
 	defer f.mu.Unlock()
 	// Reader does not exist, update cache by creating a reader
 	if reader, exists := f.readers[fileId]; exists {
@@ -223,15 +763,30 @@ func (f *FileManager) GetReader(fileId int) (*record.Reader, error) {
 		return reader, nil
 	}
 
-	dataFileName := utils.GetDataFileName(fileId)
-	reader, err := record.NewReader(f.fs, filepath.Join(f.dataStoreRootPath, "data", dataFileName))
+	// If the other files take more than their reserve, the reader is opened anyway, reads don't fail because of the budget
+	f.evictReaders(f.openFileReserve)
+	reader, err := record.NewReader(f.fs, f.DataFilePath(fileId))
 	if err != nil {
 		return nil, err
 	}
+	reader.SetLimits(f.limits)
+	reader.SetKeyring(f.keyring)
+	if f.mmapReads && fileId != f.activeDataFile {
+		f.mmapReader(reader, fileId)
+	}
 	f.readers[fileId] = reader
+	f.readerUse[fileId] = &atomic.Uint64{}
+	f.touchReader(fileId)
 	return reader, nil
 }
 
+// mmapReader maps the file of the reader, if that fails the reader keeps using pread
+func (f *FileManager) mmapReader(reader *record.Reader, fileId int) {
+	if err := reader.Mmap(); err != nil {
+		slog.Debug("could not map data file, reading it with pread", "id", fileId, "error", err)
+	}
+}
+
 // GetImmutableFiles returns a list of integer Ids for immutable files in
 // the given data store
 func (f *FileManager) GetImmutableFiles() ([]int, error) {
@@ -256,37 +811,237 @@ func (f *FileManager) GetImmutableFiles() ([]int, error) {
 }
 
 func (f *FileManager) getSortedDataFileIDs() ([]int, error) {
-	entries, err := afero.ReadDir(f.fs, filepath.Join(f.dataStoreRootPath, "data"))
-	if err != nil {
-		return nil, err
-	}
-
 	var ids []int
-	for _, entry := range entries {
-		if entry.IsDir() {
-			continue
-		}
-		filename := entry.Name()
-		extension := filepath.Ext(filename)
-		nameWithoutExt := strings.TrimSuffix(filename, extension)
-		fileId, err := strconv.ParseInt(nameWithoutExt, 10, 32)
+	for _, dir := range f.layout.DataDirs() {
+		entries, err := afero.ReadDir(f.fs, dir)
 		if err != nil {
-			continue
+			return nil, err
+		}
+		for _, entry := range entries {
+			if entry.IsDir() {
+				continue
+			}
+			if fileId, ok := parseDataFileId(entry.Name()); ok {
+				ids = append(ids, fileId)
+			}
 		}
-		ids = append(ids, int(fileId))
 	}
 	sort.Ints(ids)
 	return ids, nil
 }
 
-// IncrementNextDataFileNumber increments the next data file number by the specified value.
-// It returns the value of nextDataFileNumber before the increment
-func (f *FileManager) IncrementNextDataFileNumber(n int) int {
+// DataFileIds returns the ids of all data files in the data directory, in increasing order
+func (f *FileManager) DataFileIds() ([]int, error) {
+	return f.getSortedDataFileIDs()
+}
+
+// DataFileSize returns the size in bytes of the data file with the given id
+func (f *FileManager) DataFileSize(fileId int) (int64, error) {
+	info, err := f.fs.Stat(f.DataFilePath(fileId))
+	if err != nil {
+		return 0, err
+	}
+	return info.Size(), nil
+}
+
+// DataFileFormat returns the format of the records of the data file with the given id
+func (f *FileManager) DataFileFormat(fileId int) (record.Format, error) {
+	info, err := f.HeaderInfo(fileId)
+	return info.Format, err
+}
+
+// DataFileChecksum returns the algorithm of the checksums of the records of the data file with the given id
+func (f *FileManager) DataFileChecksum(fileId int) (record.Checksum, error) {
+	info, err := f.HeaderInfo(fileId)
+	return info.Checksum, err
+}
+
+// HasDataFile returns true if the data file with the given id exists
+func (f *FileManager) HasDataFile(fileId int) bool {
+	exists, err := afero.Exists(f.fs, f.DataFilePath(fileId))
+	return err == nil && exists
+}
+
+// HasHintFile returns true if the data file with the given id has a hint file
+func (f *FileManager) HasHintFile(fileId int) bool {
+	exists, err := afero.Exists(f.fs, f.layout.HintPath(utils.GetHintFileName(fileId)))
+	return err == nil && exists
+}
+
+// LoadReport returns the problems found in the data directory while opening the file manager and reading the keydir
+func (f *FileManager) LoadReport() LoadReport {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return f.loadReport
+}
+
+// GetActiveFileId returns the id of the data file that is currently being written to
+func (f *FileManager) GetActiveFileId() int {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return f.activeDataFile
+}
+
+// Estimated memory used by each entry of the reader cache (the reader, the open file and the map entry)
+const readerCacheEntryOverhead = int64(unsafe.Sizeof(record.Reader{})) + int64(unsafe.Sizeof(os.File{})) + 48
+
+// ReaderCacheMemoryUsage returns an estimate of the memory used by the reader cache in bytes. Readers do not buffer
+// data, so this only depends on the number of open readers
+func (f *FileManager) ReaderCacheMemoryUsage() int64 {
+	return int64(f.OpenReaders()) * readerCacheEntryOverhead
+}
+
+// MappedBytes returns the total size of the data files that are memory mapped by the readers in the reader cache
+func (f *FileManager) MappedBytes() int64 {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	var total int64
+	for _, reader := range f.readers {
+		total += reader.MappedBytes()
+	}
+	return total
+}
+
+// OpenReaders returns the number of readers in the reader cache
+func (f *FileManager) OpenReaders() int {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return len(f.readers)
+}
+
+// DataFileStats returns the number of data files, and the total size (in bytes) of all data files in the data directory.
+// They are tracked by the file manager, the data directory is not read
+func (f *FileManager) DataFileStats() (int, int64) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return len(f.dataFileSizes), f.dataFileBytes
+}
+
+// DataFileSizes returns the size in bytes of every data file by id, as tracked by the file manager
+func (f *FileManager) DataFileSizes() map[int]int64 {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	sizes := make(map[int]int64, len(f.dataFileSizes))
+	for id, size := range f.dataFileSizes {
+		sizes[id] = size
+	}
+	return sizes
+}
+
+// setDataFileSize records the size of a data file, the caller must hold the lock
+func (f *FileManager) setDataFileSize(fileId int, size int64) {
+	f.dataFileBytes += size - f.dataFileSizes[fileId]
+	f.dataFileSizes[fileId] = size
+}
+
+// AddDataFile records a data file that was moved into the data directory by a merge, along with the key and timestamp
+// ranges of it's records (if they are known, meta can be nil)
+func (f *FileManager) AddDataFile(fileId int, meta *FileMeta) error {
+	info, err := f.fs.Stat(f.DataFilePath(fileId))
+	if err != nil {
+		return err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.setDataFileSize(fileId, info.Size())
+	if meta != nil {
+		f.fileMetas[fileId] = meta
+	}
+	return nil
+}
+
+// RemoveDataFiles closes the readers of the given data files, and removes the files along with their hint files (and
+// bloom filters). Files that could not be removed are left behind, and are still counted by DataFileStats
+func (f *FileManager) RemoveDataFiles(ids []int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.valueCache != nil {
+		f.valueCache.invalidateFiles(ids)
+	}
+	for _, id := range ids {
+		f.closeReader(id)
+		f.fs.Remove(f.layout.HintPath(utils.GetHintFileName(id)))
+		f.fs.Remove(f.layout.HintPath(utils.GetBloomFileName(id)))
+		if err := f.fs.Remove(f.DataFilePath(id)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		f.dataFileBytes -= f.dataFileSizes[id]
+		delete(f.dataFileSizes, id)
+		delete(f.fileMetas, id)
+	}
+}
+
+// AllocateDataFileIds reserves n consecutive ids for data files that will be moved into the data directory (by a
+// merge), and returns the first one. It returns an error wrapping ErrFileIdCollision if a file has one of the ids
+func (f *FileManager) AllocateDataFileIds(n int) (int, error) {
 	f.mu.Lock()
 	defer f.mu.Unlock()
-	nextDataFileNumber := f.nextDataFileNumber
-	f.nextDataFileNumber += n
-	return nextDataFileNumber
+	return f.allocateIds(n)
+}
+
+// AdoptDataFile copies the data file at srcPath into the data directory, and assigns it the next data file id.
+// The file is first copied to a temporary name (which is removed when the datastore is opened), and then renamed, so
+// a partially copied file is never treated as a data file. The file is not validated.
+//
+// The active file is rotated after the id is reserved, so the adopted file is older than the active file and is
+// treated as immutable (and merged) like any other data file. beforeRename is called with the temporary file name and
+// the id, before the file is renamed; if it returns an error, the temporary file is removed. Returns the id of the new
+// file
+func (f *FileManager) AdoptDataFile(srcPath string, beforeRename func(tempName string, id int) error) (int, error) {
+	src, err := f.fs.Open(srcPath)
+	if err != nil {
+		return 0, err
+	}
+	defer src.Close()
+
+	dataDirPath := f.layout.ActiveDir
+	tempName := fmt.Sprintf("%s-%d", adoptPrefix, time.Now().UnixNano())
+	tempPath := filepath.Join(dataDirPath, tempName)
+	dst, err := f.fs.OpenFile(tempPath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0666)
+	if err != nil {
+		return 0, err
+	}
+	copied, err := io.Copy(dst, src)
+	if err != nil {
+		dst.Close()
+		f.fs.Remove(tempPath)
+		return 0, err
+	}
+	if err := dst.Sync(); err != nil {
+		dst.Close()
+		f.fs.Remove(tempPath)
+		return 0, err
+	}
+	if err := dst.Close(); err != nil {
+		f.fs.Remove(tempPath)
+		return 0, err
+	}
+
+	f.lockProfiler.Lock(&f.mu, "filemanager.adopt")
+	defer f.mu.Unlock()
+	id, err := f.allocateIds(1)
+	if err != nil {
+		f.fs.Remove(tempPath)
+		return 0, err
+	}
+	if err := f.rotateWriter.Rotate(); err != nil {
+		f.fs.Remove(tempPath)
+		return 0, err
+	}
+	f.bufferedBytes.Store(0)
+	f.tail.clear()
+	f.unsyncedBytes = 0
+	f.setDataFileSize(f.activeDataFile, datafile.FileHeaderSize)
+	if err := beforeRename(tempName, id); err != nil {
+		f.fs.Remove(tempPath)
+		return 0, err
+	}
+	if err := f.fs.Rename(tempPath, filepath.Join(dataDirPath, utils.GetDataFileName(id))); err != nil {
+		f.fs.Remove(tempPath)
+		return 0, err
+	}
+	f.setDataFileSize(id, copied)
+	return id, nil
 }
 
 // Note: Does not lock rotateWriter internally and is hence unsafe for concurrent use
@@ -295,15 +1050,47 @@ type MergeWriter struct {
 	directoryPath string
 	rotateWriter  *RotateWriter
 	filePaths     []string
+	// Key and timestamp ranges of the files, by path
+	metas map[string]*FileMeta
 }
 
 // Returns filePath, offset, error (if any)
 func (m *MergeWriter) Write(key []byte, value []byte, isTombstone bool) (string, int64, error) {
-	return m.rotateWriter.Write(key, value, isTombstone)
+	return m.WriteWithTs(key, value, isTombstone, time.Now())
 }
 
 func (m *MergeWriter) WriteWithTs(key []byte, value []byte, isTombstone bool, timestamp time.Time) (string, int64, error) {
-	return m.rotateWriter.WriteWithTs(key, value, isTombstone, timestamp)
+	recordType := uint8(record.RecordTypePut)
+	if isTombstone {
+		recordType = record.RecordTypeDelete
+	}
+	return m.WriteRecordWithTs(key, value, recordType, timestamp)
+}
+
+// WriteRecordWithTs writes a record of the given type, see FileManager.WriteRecordWithTs
+func (m *MergeWriter) WriteRecordWithTs(key []byte, value []byte, recordType uint8, timestamp time.Time) (string, int64, error) {
+	return m.WriteRecordWithHeader(record.Header{Timestamp: timestamp, RecordType: recordType}, key, value)
+}
+
+// WriteRecordWithHeader writes a record with the timestamp, record type and value type of the header, so that a merge
+// keeps the value type of the records it copies
+func (m *MergeWriter) WriteRecordWithHeader(header record.Header, key []byte, value []byte) (string, int64, error) {
+	timestamp := header.Timestamp
+	path, offset, err := m.rotateWriter.WriteRecordWithHeader(header, key, value)
+	if err == nil {
+		meta := m.metas[path]
+		if meta == nil {
+			meta = &FileMeta{}
+			m.metas[path] = meta
+		}
+		meta.add(key, timestamp)
+	}
+	return path, offset, err
+}
+
+// FileMeta returns the key and timestamp ranges of the records written to the file at path, nil if there are none
+func (m *MergeWriter) FileMeta(path string) *FileMeta {
+	return m.metas[path]
 }
 
 func (m *MergeWriter) Sync() error {
@@ -320,14 +1107,20 @@ func (f *FileManager) NewMergeWriter() (*MergeWriter, error) {
 	counter := 0
 	mergeWriter := &MergeWriter{
 		fs:            f.fs,
-		directoryPath: filepath.Join(f.dataStoreRootPath, "data"),
+		directoryPath: f.layout.MergedDir,
+		metas:         map[string]*FileMeta{},
 	}
-	rotateWriter := NewRotateWriter(f.fs, f.rotateWriter.maxDatafileSize, true, func() string {
+	rotateWriter := NewRotateWriter(f.fs, f.rotateWriter.maxDatafileSize, true, func() (string, error) {
 		counter++
 		dataFilePath := filepath.Join(mergeWriter.directoryPath, fmt.Sprintf("%s-%d", mergePrefix, counter))
 		mergeWriter.filePaths = append(mergeWriter.filePaths, dataFilePath)
-		return dataFilePath
+		return dataFilePath, nil
 	})
+	rotateWriter.SetLimits(f.limits)
+	rotateWriter.SetFormat(f.rotateWriter.format)
+	rotateWriter.SetChecksum(f.rotateWriter.checksum)
+	rotateWriter.SetPreallocate(f.rotateWriter.preallocate)
+	rotateWriter.SetKeyring(f.keyring)
 	mergeWriter.rotateWriter = rotateWriter
 	return mergeWriter, nil
 }
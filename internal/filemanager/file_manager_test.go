@@ -5,8 +5,10 @@ import (
 	"strconv"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/ananthvk/kvdb/internal/datafile"
+	"github.com/ananthvk/kvdb/internal/record"
 	"github.com/spf13/afero"
 )
 
@@ -267,3 +269,125 @@ func TestFileManager_Write_LargeNumberOfKeys(t *testing.T) {
 		}
 	}
 }
+
+// helperListDataFiles returns the number and total size of the data files, read from the data directory
+func helperListDataFiles(t *testing.T, m *FileManager) (int, int64) {
+	t.Helper()
+	ids, err := m.getSortedDataFileIDs()
+	if err != nil {
+		t.Fatalf("could not list data files: %v", err)
+	}
+	var total int64
+	for _, id := range ids {
+		size, err := m.DataFileSize(id)
+		if err != nil {
+			t.Fatalf("could not stat data file: %v", err)
+		}
+		total += size
+	}
+	return len(ids), total
+}
+
+func TestFileManager_DataFileStats(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	fs.Mkdir("data", os.ModePerm)
+	fs.Mkdir("hint", os.ModePerm)
+	m, err := NewFileManager(fs, "", 100)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	check := func(step string) {
+		t.Helper()
+		files, bytes := m.DataFileStats()
+		expectedFiles, expectedBytes := helperListDataFiles(t, m)
+		if files != expectedFiles || bytes != expectedBytes {
+			t.Errorf("%s: expected %d files with %d bytes, got %d files with %d bytes", step, expectedFiles, expectedBytes, files, bytes)
+		}
+	}
+
+	check("empty")
+	// Writes rotate to a new file after 100 bytes
+	for i := range 20 {
+		if _, _, err := m.Write([]byte("key"+strconv.Itoa(i)), []byte("value"), false); err != nil {
+			t.Fatalf("write failed: %v", err)
+		}
+	}
+	if files, _ := m.DataFileStats(); files < 3 {
+		t.Fatalf("expected the writes to rotate, got %d files", files)
+	}
+	check("writes")
+
+	m.Sync()
+	src, _ := fs.Open("data/0000000001.dat")
+	afero.WriteReader(fs, "adopt.dat", src)
+	src.Close()
+	if _, err := m.AdoptDataFile("adopt.dat", func(string, int) error { return nil }); err != nil {
+		t.Fatalf("adopt failed: %v", err)
+	}
+	check("adopt")
+
+	m.RemoveDataFiles([]int{1, 2})
+	check("remove")
+
+	// A file moved into the data directory
+	afero.WriteFile(fs, "data/0000000100.dat", make([]byte, 42), 0644)
+	if err := m.AddDataFile(100, nil); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	check("add")
+
+	reopened, err := NewFileManager(fs, "", 100)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if files, bytes := reopened.DataFileStats(); files != len(m.dataFileSizes) || bytes != m.dataFileBytes {
+		t.Errorf("expected %d files with %d bytes after reopening, got %d files with %d bytes", len(m.dataFileSizes), m.dataFileBytes, files, bytes)
+	}
+}
+
+func TestFileManager_FileMeta(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	fs.Mkdir("data", os.ModePerm)
+	m, err := NewFileManager(fs, "", 1024)
+	if err != nil {
+		t.Fatalf("failed to create file manager: %v", err)
+	}
+	base := time.Now().Truncate(time.Second)
+	m.WriteRecordWithTs([]byte("m"), []byte("value"), record.RecordTypePut, base.Add(time.Minute))
+	m.WriteRecordWithTs([]byte("c"), []byte("value"), record.RecordTypePut, base)
+	m.WriteRecordWithTs([]byte("k"), nil, record.RecordTypeDelete, base.Add(2*time.Minute))
+
+	meta, ok := m.FileMeta(m.GetActiveFileId())
+	if !ok {
+		t.Fatalf("expected the ranges of the active file to be known")
+	}
+	if string(meta.MinKey) != "c" || string(meta.MaxKey) != "m" || meta.Records != 3 {
+		t.Errorf("expected keys c to m in 3 records, got %q to %q in %d", meta.MinKey, meta.MaxKey, meta.Records)
+	}
+	if !meta.MinTimestamp.Equal(base) || !meta.MaxTimestamp.Equal(base.Add(2*time.Minute)) {
+		t.Errorf("unexpected timestamp range %v to %v", meta.MinTimestamp, meta.MaxTimestamp)
+	}
+
+	keyTests := []struct {
+		start, end string
+		want       bool
+	}{
+		{"", "", true},
+		{"a", "c", false},
+		{"a", "d", true},
+		{"m", "", true},
+		{"n", "", false},
+		{"d", "e", true},
+	}
+	for _, test := range keyTests {
+		if got := meta.HasKeysIn([]byte(test.start), []byte(test.end)); got != test.want {
+			t.Errorf("HasKeysIn(%q, %q): expected %v, got %v", test.start, test.end, test.want, got)
+		}
+	}
+	if meta.HasTimestampsIn(base.Add(2*time.Minute), time.Time{}) || meta.HasTimestampsIn(time.Time{}, base) {
+		t.Errorf("expected no records outside the timestamp range")
+	}
+	if !meta.HasTimestampsIn(base, base.Add(time.Second)) {
+		t.Errorf("expected a record within the timestamp range")
+	}
+}
@@ -0,0 +1,103 @@
+package filemanager
+
+import (
+	"bytes"
+	"slices"
+	"time"
+)
+
+/*
+Per-file key and timestamp ranges
+
+The file manager keeps the smallest and largest key, and the oldest and newest timestamp, of the records (tombstones
+included) in every data file, so that a scan looking for a range of keys or of timestamps can skip the files that
+can't have a matching record without reading them.
+
+The ranges are not stored on disk: every record of every data file is seen when the keydir is built (from the hint
+file, or by scanning the data file), so they are collected then, at no extra cost. After that, they are updated as
+records are written to the active file, and merges hand over the ranges of the files they wrote. A data file that was
+adopted (or could not be read completely) has no range, and is never skipped
+*/
+
+// FileMeta is the range of the keys and timestamps of the records in a data file
+type FileMeta struct {
+	MinKey       []byte
+	MaxKey       []byte
+	MinTimestamp time.Time
+	MaxTimestamp time.Time
+	Records      int
+}
+
+// add extends the ranges to include a record, the key is copied if it's kept
+func (m *FileMeta) add(key []byte, ts time.Time) {
+	if m.Records == 0 || bytes.Compare(key, m.MinKey) < 0 {
+		m.MinKey = slices.Clone(key)
+	}
+	if m.Records == 0 || bytes.Compare(key, m.MaxKey) > 0 {
+		m.MaxKey = slices.Clone(key)
+	}
+	if m.Records == 0 || ts.Before(m.MinTimestamp) {
+		m.MinTimestamp = ts
+	}
+	if m.Records == 0 || ts.After(m.MaxTimestamp) {
+		m.MaxTimestamp = ts
+	}
+	m.Records++
+}
+
+// HasKeysIn returns false if no record of the file can have a key in [start, end), an empty end has no upper bound
+func (m *FileMeta) HasKeysIn(start, end []byte) bool {
+	if m.Records == 0 {
+		return false
+	}
+	if bytes.Compare(m.MaxKey, start) < 0 {
+		return false
+	}
+	return len(end) == 0 || bytes.Compare(m.MinKey, end) < 0
+}
+
+// HasTimestampsIn returns false if no record of the file can have a timestamp after `after` and before `before`, a
+// zero time has no bound
+func (m *FileMeta) HasTimestampsIn(after, before time.Time) bool {
+	if m.Records == 0 {
+		return false
+	}
+	if !after.IsZero() && !m.MaxTimestamp.After(after) {
+		return false
+	}
+	return before.IsZero() || m.MinTimestamp.Before(before)
+}
+
+// addFileMeta extends the range of the data file to include a record, the caller must hold the lock
+func (f *FileManager) addFileMeta(fileId int, key []byte, ts time.Time) {
+	meta := f.fileMetas[fileId]
+	if meta == nil {
+		meta = &FileMeta{}
+		f.fileMetas[fileId] = meta
+	}
+	meta.add(key, ts)
+}
+
+// FileMeta returns the key and timestamp ranges of the data file, or false if they are not known
+func (f *FileManager) FileMeta(fileId int) (FileMeta, bool) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	meta, ok := f.fileMetas[fileId]
+	if !ok {
+		return FileMeta{}, false
+	}
+	return *meta, true
+}
+
+// MaxTimestamp returns the newest timestamp of the records of the data files whose ranges are known
+func (f *FileManager) MaxTimestamp() time.Time {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	var latest time.Time
+	for _, meta := range f.fileMetas {
+		if meta.MaxTimestamp.After(latest) {
+			latest = meta.MaxTimestamp
+		}
+	}
+	return latest
+}
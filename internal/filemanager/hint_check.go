@@ -0,0 +1,118 @@
+package filemanager
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/ananthvk/kvdb/internal/datafile"
+	"github.com/ananthvk/kvdb/internal/hintfile"
+	"github.com/ananthvk/kvdb/internal/record"
+	"github.com/ananthvk/kvdb/internal/utils"
+)
+
+/*
+Hint files are trusted during startup to avoid scanning the data files, but a hint file can go stale if the data file it
+belongs to is modified or replaced (for example, by copying files around manually). A stale hint silently produces a
+wrong keydir, so before a hint file is used, it's checked against the data file:
+
+1. Every hint must point to a record that lies within the data file
+2. Hint files are only written for files in which every record is live (merge output, snapshots), or with a hint for
+   every record (sealed files written by Put and Delete, see WriteHintFile), so the hints must cover the data file
+   exactly, i.e. the last record described by the hints must end at the end of the data file
+3. A few records are spot checked (the first one, the last one, and the ones at index 2^k-1 in between), their key,
+   sizes, timestamp and type must match the hint
+
+The checks only need the size of the data file and O(log n) reads, so they are much cheaper than scanning the file. If
+any check fails, or a hint can't be read (its checksum does not match, or the hint file is truncated), the keydir is
+built by scanning the data file instead
+*/
+
+var errStaleHint = errors.New("hint file does not match data file")
+
+// readVerifiedHints reads the hint file of the data file with the given id, and verifies it against the data file. The
+// returned hint records own their keys
+func (f *FileManager) readVerifiedHints(fileId int) ([]hintfile.HintRecord, error) {
+	hintfilePath := f.layout.HintPath(utils.GetHintFileName(fileId))
+	scanner, err := hintfile.NewScanner(f.fs, hintfilePath)
+	if err != nil {
+		return nil, err
+	}
+	defer scanner.Close()
+	scanner.SetLimits(f.limits)
+
+	datafilePath := f.DataFilePath(fileId)
+	info, err := f.fs.Stat(datafilePath)
+	if err != nil {
+		return nil, err
+	}
+	dataSize := info.Size() - datafile.FileHeaderSize
+	reader, err := record.NewReader(f.fs, datafilePath)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	reader.SetLimits(f.limits)
+	reader.SetKeyring(f.keyring)
+	// The hint file of an encrypted data file has encrypted keys
+	if reader.Encrypted() {
+		scanner.SetKeyring(f.keyring)
+	}
+
+	var hints []hintfile.HintRecord
+	var lastEnd int64
+	last := -1
+	for {
+		rec, err := scanner.Scan()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			return nil, err
+		}
+		end := rec.ValuePos + reader.EncodedSize(rec.KeySize, rec.ValueSize)
+		if rec.ValuePos < 0 || end > dataSize {
+			return nil, fmt.Errorf("%w: record at offset %d is outside the data file", errStaleHint, rec.ValuePos)
+		}
+		if end > lastEnd {
+			lastEnd = end
+			last = len(hints)
+		}
+		rec.Key = bytes.Clone(rec.Key)
+		hints = append(hints, rec)
+	}
+	if lastEnd != dataSize {
+		return nil, fmt.Errorf("%w: hints cover %d bytes, data file has %d bytes", errStaleHint, lastEnd, dataSize)
+	}
+	if len(hints) == 0 {
+		return hints, nil
+	}
+
+	for i := 1; i <= len(hints); i *= 2 {
+		if err := spotCheckHint(reader, &hints[i-1]); err != nil {
+			return nil, err
+		}
+	}
+	if err := spotCheckHint(reader, &hints[last]); err != nil {
+		return nil, err
+	}
+	return hints, nil
+}
+
+// spotCheckHint reads the record described by the hint, and checks that it matches the hint
+func spotCheckHint(reader *record.Reader, hint *hintfile.HintRecord) error {
+	rec, err := reader.ReadKeyAt(hint.ValuePos)
+	if err != nil {
+		return fmt.Errorf("%w: could not read record at offset %d: %w", errStaleHint, hint.ValuePos, err)
+	}
+	if record.IsPut(rec.Header.RecordType) == hint.Tombstone ||
+		(rec.Header.RecordType == record.RecordTypePutImmutable) != hint.Immutable ||
+		rec.Header.KeySize != hint.KeySize ||
+		rec.Header.ValueSize != hint.ValueSize ||
+		rec.Header.Timestamp.UnixMicro() != hint.Timestamp.UnixMicro() ||
+		!bytes.Equal(rec.Key, hint.Key) {
+		return fmt.Errorf("%w: record at offset %d does not match the hint", errStaleHint, hint.ValuePos)
+	}
+	return nil
+}
@@ -0,0 +1,90 @@
+package filemanager
+
+import (
+	"errors"
+	"io"
+
+	"github.com/ananthvk/kvdb/internal/hintfile"
+	"github.com/ananthvk/kvdb/internal/kverrors"
+	"github.com/ananthvk/kvdb/internal/record"
+	"github.com/ananthvk/kvdb/internal/utils"
+)
+
+// Suffix of the hint file that's being written by WriteHintFile, it's renamed once it's complete. The suffix is not
+// .hint, so a file that was left behind by a crash is not taken for a hint file
+const hintTempSuffix = ".tmp"
+
+// WriteHintFile writes the hint file of a sealed data file that was written by Put and Delete, with a hint for every
+// record of the file, tombstones included (see hintfile.FlagLogFile). The data file must be read completely, a hint
+// file is not written for a damaged file. It must not be called for the active data file
+func (f *FileManager) WriteHintFile(fileId int) (err error) {
+	dataPath := f.DataFilePath(fileId)
+	scanner, err := record.NewScanner(f.fs, dataPath)
+	if err != nil {
+		return kverrors.WrapFile(err, dataPath, -1)
+	}
+	defer scanner.Close()
+	scanner.SetLimits(f.limits)
+	scanner.SetKeyring(f.keyring)
+
+	hintPath := f.layout.HintPath(utils.GetHintFileName(fileId))
+	tempPath := hintPath + hintTempSuffix
+	writer, err := hintfile.NewWriter(f.fs, tempPath)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if writer != nil {
+			writer.Close()
+		}
+		if err != nil {
+			f.fs.Remove(tempPath)
+		}
+	}()
+	writer.SetLimits(f.limits)
+	writer.SetLogFile()
+	// The hint file of an encrypted data file has encrypted keys
+	if scanner.Encrypted() {
+		writer.SetKeyring(f.keyring)
+	}
+	var nextOffset int64
+	for {
+		rec, offset, err := scanner.Scan()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return kverrors.WrapFile(err, dataPath, nextOffset)
+		}
+		nextOffset = offset + rec.Size
+		err = writer.WriteHintRecord(&hintfile.HintRecord{
+			Timestamp: rec.Header.Timestamp,
+			KeySize:   rec.Header.KeySize,
+			ValueSize: rec.Header.ValueSize,
+			ValuePos:  offset,
+			Key:       rec.Key,
+			Immutable: rec.Header.RecordType == record.RecordTypePutImmutable,
+			Tombstone: rec.Header.RecordType == record.RecordTypeDelete,
+			ValueType: rec.Header.ValueType,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	err = writer.Close()
+	writer = nil
+	if err != nil {
+		return err
+	}
+	return f.fs.Rename(tempPath, hintPath)
+}
+
+// IsMergedFile returns true if the data file with the given id was written by a merge (or a snapshot), i.e. if it has
+// a hint file that was not written by WriteHintFile
+func (f *FileManager) IsMergedFile(fileId int) bool {
+	if !f.HasHintFile(fileId) {
+		return false
+	}
+	logFile, err := hintfile.IsLogFile(f.fs, f.layout.HintPath(utils.GetHintFileName(fileId)))
+	return err == nil && !logFile
+}
@@ -0,0 +1,118 @@
+package filemanager
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/ananthvk/kvdb/internal/utils"
+	"github.com/spf13/afero"
+)
+
+// ErrFileIdCollision is returned when a data file id is used by more than one file, either because the allocator gave
+// out an id that's already used, or because it rejected the id of an existing file when the file manager was created
+var ErrFileIdCollision = errors.New("data file id collision")
+
+// IdAllocator allocates the ids of new data files (the active file, merge outputs and adopted files). Records are
+// replayed in file id order, so every allocated id must be larger than the ids that were claimed or allocated before.
+// The file manager calls the allocator with it's lock held, so an allocator that's only used by one file manager
+// doesn't need any locking
+type IdAllocator interface {
+	// Claim is called when the file manager is created, in increasing order, with the id of every data file and hint
+	// file in the datastore. It returns an error if the file can't have the id, for example because the allocator has
+	// given it to another writer
+	Claim(id int) error
+	// Allocate reserves n consecutive ids, and returns the first one
+	Allocate(n int) int
+}
+
+// counterAllocator gives out ids in order, starting after the largest claimed id
+type counterAllocator struct {
+	next int
+}
+
+// NewCounterAllocator returns the default allocator, a counter that starts after the largest id in the datastore
+func NewCounterAllocator() IdAllocator {
+	return &counterAllocator{next: 1}
+}
+
+func (c *counterAllocator) Claim(id int) error {
+	c.next = max(c.next, id+1)
+	return nil
+}
+
+func (c *counterAllocator) Allocate(n int) int {
+	start := c.next
+	c.next += n
+	return start
+}
+
+// parseHintFileId returns the id of the hint file with the given name, like parseDataFileId
+func parseHintFileId(name string) (int, bool) {
+	idPart, ok := strings.CutSuffix(name, ".hint")
+	if !ok {
+		return 0, false
+	}
+	id, err := strconv.ParseInt(idPart, 10, 32)
+	if err != nil || id < 0 || utils.GetHintFileName(int(id)) != name {
+		return 0, false
+	}
+	return int(id), true
+}
+
+// claimIds claims the ids of the data files, and of hint files without a data file, so that a new data file never gets
+// the id of a stale hint file. It returns the largest id
+func claimIds(fs afero.Fs, hintDir string, allocator IdAllocator, dataFileIds map[int]int64) (int, error) {
+	ids := make([]int, 0, len(dataFileIds))
+	for id := range dataFileIds {
+		ids = append(ids, id)
+	}
+	entries, err := afero.ReadDir(fs, hintDir)
+	if err != nil && !errors.Is(err, afero.ErrFileNotFound) {
+		return 0, err
+	}
+	for _, entry := range entries {
+		if id, ok := parseHintFileId(entry.Name()); ok && !entry.IsDir() {
+			if _, exists := dataFileIds[id]; !exists {
+				ids = append(ids, id)
+			}
+		}
+	}
+	sort.Ints(ids)
+
+	maxId := 0
+	for _, id := range ids {
+		if err := allocator.Claim(id); err != nil {
+			return 0, fmt.Errorf("%w: file %d: %w", ErrFileIdCollision, id, err)
+		}
+		maxId = id
+	}
+	return maxId, nil
+}
+
+// allocateIds allocates n ids for new data files, and checks that they are larger than every id used before, and that
+// no file has them. The caller must hold the lock
+func (f *FileManager) allocateIds(n int) (int, error) {
+	start := f.allocator.Allocate(n)
+	if start <= f.maxFileId {
+		return 0, fmt.Errorf("%w: allocated id %d, ids up to %d are in use", ErrFileIdCollision, start, f.maxFileId)
+	}
+	for id := start; id < start+n; id++ {
+		for _, path := range []string{
+			f.DataFilePath(id),
+			f.layout.HintPath(utils.GetHintFileName(id)),
+		} {
+			exists, err := afero.Exists(f.fs, path)
+			if err != nil {
+				return 0, err
+			}
+			if exists {
+				return 0, fmt.Errorf("%w: allocated id %d, but %s exists", ErrFileIdCollision, id, path)
+			}
+		}
+	}
+	f.maxFileId = start + n - 1
+	return start, nil
+}
@@ -0,0 +1,106 @@
+package filemanager
+
+import (
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/spf13/afero"
+)
+
+// fixedAllocator gives out ids from a list, and rejects claims of the ids in rejected
+type fixedAllocator struct {
+	ids      []int
+	claimed  []int
+	rejected map[int]bool
+}
+
+func (a *fixedAllocator) Claim(id int) error {
+	if a.rejected[id] {
+		return errors.New("id belongs to another writer")
+	}
+	a.claimed = append(a.claimed, id)
+	return nil
+}
+
+func (a *fixedAllocator) Allocate(n int) int {
+	id := a.ids[0]
+	a.ids = a.ids[n:]
+	return id
+}
+
+func helperIdTestDir(t *testing.T) afero.Fs {
+	t.Helper()
+	fs := afero.NewMemMapFs()
+	fs.Mkdir("data", os.ModePerm)
+	fs.Mkdir("hint", os.ModePerm)
+	for _, name := range []string{"data/0000000001.dat", "data/0000000003.dat", "hint/0000000003.hint", "hint/0000000005.hint", "hint/notes.txt"} {
+		if err := afero.WriteFile(fs, name, []byte{}, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return fs
+}
+
+func TestIdAllocatorClaims(t *testing.T) {
+	fs := helperIdTestDir(t)
+	allocator := &fixedAllocator{ids: []int{6}}
+	m, err := NewFileManagerWithAllocator(fs, "", 1024, allocator)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	// The hint file without a data file is claimed, so it's id is not reused
+	if len(allocator.claimed) != 3 || allocator.claimed[0] != 1 || allocator.claimed[1] != 3 || allocator.claimed[2] != 5 {
+		t.Errorf("expected ids 1, 3 and 5 to be claimed, got %v", allocator.claimed)
+	}
+	if _, _, err := m.Write([]byte("key"), []byte("value"), false); err != nil {
+		t.Fatalf("write failed: %v", err)
+	}
+	if id := m.GetActiveFileId(); id != 6 {
+		t.Errorf("expected active file 6, got %d", id)
+	}
+
+	// The counter starts after the stale hint file
+	m, err = NewFileManager(helperIdTestDir(t), "", 1024)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if start, err := m.AllocateDataFileIds(2); err != nil || start != 6 {
+		t.Errorf("expected ids from 6, got %d, %v", start, err)
+	}
+}
+
+func TestIdAllocatorCollisions(t *testing.T) {
+	// A file whose id was rejected
+	_, err := NewFileManagerWithAllocator(helperIdTestDir(t), "", 1024, &fixedAllocator{rejected: map[int]bool{3: true}})
+	if !errors.Is(err, ErrFileIdCollision) {
+		t.Errorf("expected ErrFileIdCollision, got %v", err)
+	}
+
+	// Ids that are not larger than the existing ids, or that have a file
+	for _, id := range []int{2, 3} {
+		m, err := NewFileManagerWithAllocator(helperIdTestDir(t), "", 1024, &fixedAllocator{ids: []int{id}})
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if _, _, err := m.Write([]byte("key"), []byte("value"), false); !errors.Is(err, ErrFileIdCollision) {
+			t.Errorf("id %d: expected ErrFileIdCollision, got %v", id, err)
+		}
+	}
+
+	fs := helperIdTestDir(t)
+	// A file that another writer created after the datastore was opened
+	m, err := NewFileManagerWithAllocator(fs, "", 1024, &fixedAllocator{ids: []int{6}})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if err := afero.WriteFile(fs, "data/0000000006.dat", []byte("other"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.AllocateDataFileIds(1); !errors.Is(err, ErrFileIdCollision) {
+		t.Errorf("expected ErrFileIdCollision, got %v", err)
+	}
+	if data, err := afero.ReadFile(fs, "data/0000000006.dat"); err != nil || string(data) != "other" {
+		t.Errorf("expected the file to be left alone, got %q, %v", data, err)
+	}
+}
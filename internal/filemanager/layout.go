@@ -0,0 +1,121 @@
+package filemanager
+
+import (
+	"path/filepath"
+
+	"github.com/ananthvk/kvdb/internal/utils"
+	"github.com/spf13/afero"
+)
+
+/*
+Directory layout
+
+By default, every data file is in ${root}/data, and every hint file and bloom filter is in ${root}/hint. A layout can put
+them in three separate directories, which may be on different disks:
+
+ 1. The active directory has the active data file, and the files sealed by rotations (and adopted files), until they
+    are merged. All writes go here, so it's meant for fast storage
+ 2. The merged directory has the data files written by merges. Merge output files are written to it under their
+    temporary names, so that they can be renamed to their ids without moving them to another file system
+ 3. The hint directory has the hint files and bloom filters of the data files in both directories
+
+A data file is never moved between the active and merged directories, so it's found by id by looking in the merged
+directory first, and then in the active directory. When both are the same directory, the lookup is free
+*/
+
+// Layout is the set of directories the files of a datastore are in, see layout.go
+type Layout struct {
+	ActiveDir string
+	MergedDir string
+	HintDir   string
+}
+
+// DefaultLayout returns the layout of a datastore whose data files are all in ${root}/data, and hint files in
+// ${root}/hint
+func DefaultLayout(root string) Layout {
+	dataDir := filepath.Join(root, "data")
+	return Layout{ActiveDir: dataDir, MergedDir: dataDir, HintDir: filepath.Join(root, "hint")}
+}
+
+// ResolveLayout returns the layout of the datastore at root with the given directories, empty directories are the
+// default ones (the merged directory defaults to the active directory), and relative directories are relative to root
+func ResolveLayout(root string, activeDir string, mergedDir string, hintDir string) Layout {
+	layout := DefaultLayout(root)
+	resolve := func(dir string, def string) string {
+		if dir == "" {
+			return def
+		}
+		if filepath.IsAbs(dir) {
+			return dir
+		}
+		return filepath.Join(root, dir)
+	}
+	layout.ActiveDir = resolve(activeDir, layout.ActiveDir)
+	layout.MergedDir = resolve(mergedDir, layout.ActiveDir)
+	layout.HintDir = resolve(hintDir, layout.HintDir)
+	return layout
+}
+
+// Split returns true if merged data files are in a different directory from the active file
+func (l Layout) Split() bool {
+	return filepath.Clean(l.ActiveDir) != filepath.Clean(l.MergedDir)
+}
+
+// DataDirs returns the directories with data files, the active directory first
+func (l Layout) DataDirs() []string {
+	if l.Split() {
+		return []string{l.ActiveDir, l.MergedDir}
+	}
+	return []string{l.ActiveDir}
+}
+
+// Dirs returns every directory of the layout, without duplicates
+func (l Layout) Dirs() []string {
+	dirs := l.DataDirs()
+	for _, dir := range dirs {
+		if filepath.Clean(dir) == filepath.Clean(l.HintDir) {
+			return dirs
+		}
+	}
+	return append(dirs, l.HintDir)
+}
+
+// HintPath returns the path of the file with the given name in the hint directory
+func (l Layout) HintPath(name string) string {
+	return filepath.Join(l.HintDir, name)
+}
+
+// DataPaths returns the paths a file with the given name can have in the data directories, the merged directory first
+func (l Layout) DataPaths(name string) []string {
+	if l.Split() {
+		return []string{filepath.Join(l.MergedDir, name), filepath.Join(l.ActiveDir, name)}
+	}
+	return []string{filepath.Join(l.ActiveDir, name)}
+}
+
+// DataFilePath returns the path of the data file with the given id. If the file is in neither data directory, the path
+// it would have in the active directory is returned
+func (l Layout) DataFilePath(fs afero.Fs, fileId int) string {
+	return l.FindDataPath(fs, utils.GetDataFileName(fileId))
+}
+
+// FindDataPath is like DataFilePath, for a file with any name
+func (l Layout) FindDataPath(fs afero.Fs, name string) string {
+	paths := l.DataPaths(name)
+	for _, path := range paths[:len(paths)-1] {
+		if exists, err := afero.Exists(fs, path); err == nil && exists {
+			return path
+		}
+	}
+	return paths[len(paths)-1]
+}
+
+// Layout returns the directories of the datastore's files
+func (f *FileManager) Layout() Layout {
+	return f.layout
+}
+
+// DataFilePath returns the path of the data file with the given id, in the directory of the layout it's in
+func (f *FileManager) DataFilePath(fileId int) string {
+	return f.layout.DataFilePath(f.fs, fileId)
+}
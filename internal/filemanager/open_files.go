@@ -0,0 +1,181 @@
+package filemanager
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sync/atomic"
+
+	"github.com/spf13/afero"
+)
+
+/*
+Open file accounting
+
+Every file opened by the datastore goes through a CountingFs, which counts the files that are open: the active data
+file, the cached readers of the other data files, the output of a merge, hint files and bloom filters as they are read
+and written, scanners, and the readers returned by GetReader.
+
+With a budget (SetMaxOpenFiles), the reader cache is the only part that grows with the number of data files, so it's
+the part that is bounded: before a reader is opened, the least recently used readers are closed until the reader and
+reserved files fit in the budget. The reserved files cover everything else the datastore opens at the same time (the
+active file, the data and hint files written by a merge, and a file that is scanned, plus the files that are read ahead
+by a parallel merge), so the total stays within the budget. A reader that is closed while a read is using it fails the read with os.ErrClosed, which is retried
+with a new reader. Value readers (OpenValue) are opened outside of the reserve, if they don't fit in the budget after
+every cached reader is closed, ErrOpenFileLimit is returned
+*/
+
+// Files the datastore can open in addition to the reader cache, see open_files.go
+const reservedOpenFiles = 4
+
+// ErrOpenFileLimit is returned when a file can't be opened without going over the open file budget
+var ErrOpenFileLimit = errors.New("open file limit reached")
+
+// CountingFs is an afero.Fs that counts the files that are open
+type CountingFs struct {
+	afero.Fs
+	open atomic.Int64
+}
+
+// NewCountingFs returns a CountingFs that opens files with fs
+func NewCountingFs(fs afero.Fs) *CountingFs {
+	return &CountingFs{Fs: fs}
+}
+
+// OpenFiles returns the number of files that are open
+func (c *CountingFs) OpenFiles() int64 {
+	return c.open.Load()
+}
+
+func (c *CountingFs) Create(name string) (afero.File, error) {
+	return c.count(c.Fs.Create(name))
+}
+
+func (c *CountingFs) Open(name string) (afero.File, error) {
+	return c.count(c.Fs.Open(name))
+}
+
+func (c *CountingFs) OpenFile(name string, flag int, perm os.FileMode) (afero.File, error) {
+	return c.count(c.Fs.OpenFile(name, flag, perm))
+}
+
+func (c *CountingFs) count(file afero.File, err error) (afero.File, error) {
+	if err != nil {
+		return file, err
+	}
+	c.open.Add(1)
+	counted := &countedFile{File: file, fs: c}
+	// Files backed by a file descriptor can still be memory mapped
+	if _, ok := file.(interface{ Fd() uintptr }); ok {
+		return &countedFdFile{countedFile: counted}, nil
+	}
+	return counted, nil
+}
+
+// countedFile decrements the count of it's file system once it's closed
+type countedFile struct {
+	afero.File
+	fs     *CountingFs
+	closed atomic.Bool
+}
+
+func (f *countedFile) Close() error {
+	if !f.closed.Swap(true) {
+		f.fs.open.Add(-1)
+	}
+	return f.File.Close()
+}
+
+type countedFdFile struct {
+	*countedFile
+}
+
+func (f *countedFdFile) Fd() uintptr {
+	return f.File.(interface{ Fd() uintptr }).Fd()
+}
+
+// MinOpenFiles returns the smallest open file budget, which leaves room for one cached reader, when extra files (more
+// than the ones that are always reserved) can be open at the same time
+func MinOpenFiles(extra int) int {
+	return reservedOpenFiles + extra + 1
+}
+
+// SetMaxOpenFiles sets the open file budget, 0 disables it. extra is the number of files that can be open at the same
+// time in addition to the ones that are always reserved, n must be at least MinOpenFiles(extra)
+func (f *FileManager) SetMaxOpenFiles(n int, extra int) error {
+	if n != 0 && n < MinOpenFiles(extra) {
+		return fmt.Errorf("open file budget of %d is too small, it must be at least %d", n, MinOpenFiles(extra))
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.maxOpenFiles = n
+	f.openFileReserve = reservedOpenFiles + extra
+	f.evictReaders(f.openFileReserve)
+	return nil
+}
+
+// MaxOpenFiles returns the open file budget, 0 if there is none
+func (f *FileManager) MaxOpenFiles() int {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return f.maxOpenFiles
+}
+
+// OpenFiles returns the number of files opened through the file system of the file manager that are open
+func (f *FileManager) OpenFiles() int64 {
+	return f.fs.OpenFiles()
+}
+
+// ReaderEvictions returns the number of cached readers that were closed to stay within the open file budget
+func (f *FileManager) ReaderEvictions() uint64 {
+	return f.readerEvictions.Load()
+}
+
+// touchReader marks the cached reader of the file as used
+func (f *FileManager) touchReader(fileId int) {
+	if use, ok := f.readerUse[fileId]; ok {
+		use.Store(f.useClock.Add(1))
+	}
+}
+
+// evictReaders closes the least recently used cached readers until a file, and headroom more, can be opened within the
+// budget. It returns false if they still don't fit once the cache is empty. The caller must hold the write lock
+func (f *FileManager) evictReaders(headroom int) bool {
+	if f.maxOpenFiles == 0 {
+		return true
+	}
+	for f.fs.OpenFiles()+1+int64(headroom) > int64(f.maxOpenFiles) {
+		victim, oldest, found := 0, uint64(0), false
+		for id, use := range f.readerUse {
+			if last := use.Load(); !found || last < oldest {
+				victim, oldest, found = id, last, true
+			}
+		}
+		if !found {
+			return false
+		}
+		f.closeReader(victim)
+		f.readerEvictions.Add(1)
+	}
+	return true
+}
+
+// closeReader closes the cached reader of the file and removes it from the cache, the caller must hold the write lock
+func (f *FileManager) closeReader(fileId int) {
+	if reader, exists := f.readers[fileId]; exists {
+		reader.Close()
+		delete(f.readers, fileId)
+		delete(f.readerUse, fileId)
+	}
+}
+
+// retryEvicted calls read until it does not fail because the reader it used was closed (evicted from the cache) while
+// it was reading. It gives up if the file itself is gone
+func (f *FileManager) retryEvicted(fileId int, read func() error) error {
+	for {
+		err := read()
+		if !errors.Is(err, os.ErrClosed) || !f.HasDataFile(fileId) {
+			return err
+		}
+	}
+}
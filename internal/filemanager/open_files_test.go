@@ -0,0 +1,79 @@
+package filemanager
+
+import (
+	"os"
+	"strconv"
+	"testing"
+
+	"github.com/ananthvk/kvdb/internal/datafile"
+	"github.com/spf13/afero"
+)
+
+func TestCountingFs(t *testing.T) {
+	fs := NewCountingFs(afero.NewMemMapFs())
+	file, err := fs.Create("a")
+	if err != nil {
+		t.Fatalf("create failed: %v", err)
+	}
+	other, _ := fs.Open("a")
+	if fs.OpenFiles() != 2 {
+		t.Errorf("expected 2 open files, got %d", fs.OpenFiles())
+	}
+	file.Close()
+	file.Close()
+	if fs.OpenFiles() != 1 {
+		t.Errorf("expected a file closed twice to be counted once, got %d open files", fs.OpenFiles())
+	}
+	other.Close()
+	if _, err := fs.Open("missing"); err == nil || fs.OpenFiles() != 0 {
+		t.Errorf("expected no open files, got %d (err: %v)", fs.OpenFiles(), err)
+	}
+}
+
+func TestFileManager_MaxOpenFiles(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	fs.Mkdir("data", os.ModePerm)
+	m, err := NewFileManager(fs, "", 50)
+	if err != nil {
+		t.Fatalf("failed to create file manager: %v", err)
+	}
+	defer m.Close()
+	if err := m.SetMaxOpenFiles(MinOpenFiles(0)-1, 0); err == nil {
+		t.Errorf("expected a budget without room for a reader to be rejected")
+	}
+	if err := m.SetMaxOpenFiles(MinOpenFiles(0)+1, 0); err != nil {
+		t.Fatalf("failed to set the budget: %v", err)
+	}
+
+	type position struct {
+		fileId int
+		offset int64
+	}
+	var positions []position
+	for i := range 20 {
+		fileId, offset, err := m.Write([]byte("key"+strconv.Itoa(i)), []byte("value"+strconv.Itoa(i)), false)
+		if err != nil {
+			t.Fatalf("write failed: %v", err)
+		}
+		positions = append(positions, position{fileId, offset - datafile.FileHeaderSize})
+	}
+	if m.GetActiveFileId() < 5 {
+		t.Fatalf("expected the writes to be spread over several files, got %d", m.GetActiveFileId())
+	}
+
+	for range 2 {
+		for i, pos := range positions {
+			rec, err := m.ReadValueAt(pos.fileId, pos.offset)
+			if err != nil || string(rec.Value) != "value"+strconv.Itoa(i) {
+				t.Fatalf("expected value%d, got %v", i, err)
+			}
+			// The active file and the readers use the budget, the rest is reserved
+			if open := m.OpenFiles(); open > int64(m.MaxOpenFiles()-reservedOpenFiles) {
+				t.Fatalf("expected at most %d open files, got %d", m.MaxOpenFiles()-reservedOpenFiles, open)
+			}
+		}
+	}
+	if m.ReaderEvictions() == 0 {
+		t.Errorf("expected readers to be closed to stay within the budget")
+	}
+}
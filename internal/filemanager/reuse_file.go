@@ -0,0 +1,70 @@
+package filemanager
+
+import (
+	"log/slog"
+	"path/filepath"
+	"slices"
+
+	"github.com/ananthvk/kvdb/internal/datafile"
+	"github.com/ananthvk/kvdb/internal/record"
+)
+
+/*
+Reusing the last data file
+
+Without it, the first write after the datastore is opened goes to a new data file, and the file that was active when
+the datastore was closed is sealed as it is. A datastore that is opened and closed often (a CLI, a test, a short lived
+process) ends up with many small data files, which take a file descriptor each when they are read, and have to be
+merged to be reclaimed.
+
+ReuseLastFile makes writes append to the last data file instead, if it's safe to do so:
+
+  - it's in the active directory (files in MergedDir were written by merges)
+  - it was read completely by ReadKeydir, so it does not end with a partial record left by a crash, which the new
+    records would be appended after
+  - it has no hint file, which would not list the new records
+  - it's smaller than the maximum size of a data file
+  - it's records have the format and checksum of new data files, and are encrypted if (and only if) new data files are
+
+The rotation limits (see RotateWriter.SetRotationLimits) count the records that are already in the file, and it's age
+is from it's creation time.
+
+Otherwise the datastore starts a new file on the first write, as before. The reused file stays the active file, so it's
+not merged until it's sealed by a rotation
+*/
+
+// ReuseLastFile makes the last data file the active file, so that writes are appended to it, see reuse_file.go. It
+// must be called after ReadKeydir, before the first write. It returns true if the file is reused
+func (f *FileManager) ReuseLastFile() (bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	id := f.activeDataFile
+	size, ok := f.dataFileSizes[id]
+	if !ok || f.rotateWriter.writer != nil {
+		return false, nil
+	}
+	path := f.DataFilePath(id)
+	if filepath.Dir(path) != filepath.Clean(f.layout.ActiveDir) || slices.Contains(f.loadReport.InvalidDataFiles, id) ||
+		f.HasHintFile(id) || size >= int64(f.rotateWriter.maxDatafileSize) {
+		return false, nil
+	}
+	header, err := datafile.ReadFileHeader(f.fs, path)
+	if err != nil {
+		return false, nil
+	}
+	encoding := header.Encoding()
+	if record.Format(encoding.RecordFormat) != f.rotateWriter.format ||
+		record.Checksum(encoding.Checksum) != f.rotateWriter.checksum ||
+		(encoding.Cipher != datafile.CipherNone) != f.rotateWriter.Encrypted() {
+		return false, nil
+	}
+	var records int
+	if meta := f.fileMetas[id]; meta != nil {
+		records = meta.Records
+	}
+	if err := f.rotateWriter.Reopen(path, header.Timestamp, records); err != nil {
+		return false, err
+	}
+	slog.Debug("reusing the last data file", "path", path, "size", size)
+	return true, nil
+}
@@ -1,10 +1,14 @@
 package filemanager
 
 import (
+	"errors"
+	"io"
+	"log/slog"
 	"time"
 
 	"github.com/ananthvk/kvdb/internal/datafile"
 	"github.com/ananthvk/kvdb/internal/record"
+	"github.com/ananthvk/kvdb/internal/vfs"
 	"github.com/spf13/afero"
 )
 
@@ -18,10 +22,28 @@ type RotateWriter struct {
 	currentFilePath string
 	shouldRotate    bool
 	isBuffered      bool
+	limits          record.Limits
+	format          record.Format
+	checksum        record.Checksum
+	// Space for maxDatafileSize bytes is reserved for every new file, and the rest is freed when the file is sealed, see
+	// internal/vfs/vfs.go
+	preallocate bool
+	// Records are buffered in memory (bufferSize bytes) before they are written to the file if it's not 0, see
+	// write_buffer.go in the kvdb package
+	bufferSize int
+	// The file is also rotated once it has maxRecords records, or on the first write after it's older than maxAge (0
+	// disables either). records and created are the number of records and the creation time of the current file
+	maxRecords int
+	maxAge     time.Duration
+	records    int
+	created    time.Time
+	// New files are encrypted with the current key of keyring if it has one, see internal/record/cipher.go
+	keyring *record.Keyring
 
 	// Callback function to get the next file path
-	// This function is called when the writer wants to rotate to the next file
-	getNextFilePath func() string
+	// This function is called when the writer wants to rotate to the next file, if it returns an error, the write that
+	// needed the new file fails with it
+	getNextFilePath func() (string, error)
 }
 
 func (r *RotateWriter) Sync() error {
@@ -34,6 +56,7 @@ func (r *RotateWriter) Sync() error {
 func (r *RotateWriter) Close() error {
 	var err error
 	if r.writer != nil {
+		r.releasePreallocated()
 		err = r.writer.Close()
 		r.writer = nil
 	}
@@ -42,12 +65,9 @@ func (r *RotateWriter) Close() error {
 
 // Write Returns file path, offset (from start of file), error if any
 func (r *RotateWriter) Write(key []byte, value []byte, isTombstone bool) (string, int64, error) {
-	if r.shouldRotate || r.writer == nil {
-		if err := r.getNewWriter(); err != nil {
-			return r.currentFilePath, 0, err
-		}
+	if err := r.rotateIfNeeded(); err != nil {
+		return r.currentFilePath, 0, err
 	}
-	r.shouldRotate = false
 	var offset int64
 	var err error
 	if isTombstone {
@@ -58,34 +78,95 @@ func (r *RotateWriter) Write(key []byte, value []byte, isTombstone bool) (string
 	if err != nil {
 		return r.currentFilePath, 0, err
 	}
-	if offset > int64(r.maxDatafileSize) {
-		r.shouldRotate = true
-	}
+	r.written(offset)
 	return r.currentFilePath, offset, nil
 }
 
-// Write Returns file path, offset (from start of file), error if any (with timestamp), Note: Quick hack, I've just copied this function
+// Write Returns file path, offset (from start of file), error if any (with timestamp)
 func (r *RotateWriter) WriteWithTs(key []byte, value []byte, isTombstone bool, ts time.Time) (string, int64, error) {
-	if r.shouldRotate || r.writer == nil {
-		if err := r.getNewWriter(); err != nil {
-			return r.currentFilePath, 0, err
+	recordType := uint8(record.RecordTypePut)
+	if isTombstone {
+		recordType = record.RecordTypeDelete
+	}
+	return r.WriteRecordWithTs(key, value, recordType, ts)
+}
+
+// WriteRecordWithTs is like WriteWithTs, but writes a record of the given type
+func (r *RotateWriter) WriteRecordWithTs(key []byte, value []byte, recordType uint8, ts time.Time) (string, int64, error) {
+	return r.WriteRecordWithHeader(record.Header{Timestamp: ts, RecordType: recordType}, key, value)
+}
+
+// WriteRecordWithHeader is like WriteRecordWithTs, with the timestamp, record type and value type of the header
+func (r *RotateWriter) WriteRecordWithHeader(header record.Header, key []byte, value []byte) (string, int64, error) {
+	if err := r.rotateIfNeeded(); err != nil {
+		return r.currentFilePath, 0, err
+	}
+	offset, err := r.writer.WriteRecordWithHeader(header, key, value)
+	if err != nil {
+		return r.currentFilePath, 0, err
+	}
+	r.written(offset)
+	return r.currentFilePath, offset, nil
+}
+
+// WriteRecords writes the records that go in the current file (after rotating it if needed) with a single write, see
+// record.Writer.WriteRecords. It returns how many records were written, and their offsets, the rest go in the next file
+// and are written by the next call
+func (r *RotateWriter) WriteRecords(records []record.Record) (int, []int64, error) {
+	if err := r.rotateIfNeeded(); err != nil {
+		return 0, nil, err
+	}
+	// The file is full after a record that starts past maxDatafileSize, or after maxRecords records, see written
+	n, pos, count := 0, r.writer.Size(), r.records
+	for n < len(records) {
+		start := pos
+		pos += r.writer.EncodedSize(uint32(len(records[n].Key)), uint32(len(records[n].Value)))
+		n++
+		count++
+		if start > int64(r.maxDatafileSize) || (r.maxRecords > 0 && count >= r.maxRecords) {
+			break
 		}
 	}
-	r.shouldRotate = false
-	var offset int64
-	var err error
-	if isTombstone {
-		offset, err = r.writer.WriteTombstoneWithTs(key, ts)
-	} else {
-		offset, err = r.writer.WriteKeyValueWithTs(key, value, ts)
+	offsets, err := r.writer.WriteRecords(records[:n])
+	if err != nil {
+		return 0, nil, err
+	}
+	for _, offset := range offsets {
+		r.written(offset)
 	}
+	return n, offsets, nil
+}
+
+// WriteRecordFromReader is like WriteRecordWithHeader, but the value (of header.ValueSize bytes) is copied from reader
+func (r *RotateWriter) WriteRecordFromReader(header record.Header, key []byte, reader io.Reader) (string, int64, error) {
+	if err := r.rotateIfNeeded(); err != nil {
+		return r.currentFilePath, 0, err
+	}
+	offset, err := r.writer.WriteRecordFromReader(header, key, reader)
 	if err != nil {
 		return r.currentFilePath, 0, err
 	}
-	if offset > int64(r.maxDatafileSize) {
+	r.written(offset)
+	return r.currentFilePath, offset, nil
+}
+
+// rotateIfNeeded starts a new file if there is none, or if the current file is full or too old
+func (r *RotateWriter) rotateIfNeeded() error {
+	if r.shouldRotate || r.writer == nil || (r.maxAge > 0 && time.Since(r.created) >= r.maxAge) {
+		if err := r.getNewWriter(); err != nil {
+			return err
+		}
+	}
+	r.shouldRotate = false
+	return nil
+}
+
+// written is called after a record is written at offset, the file is rotated before the next write if it's full
+func (r *RotateWriter) written(offset int64) {
+	r.records++
+	if offset > int64(r.maxDatafileSize) || (r.maxRecords > 0 && r.records >= r.maxRecords) {
 		r.shouldRotate = true
 	}
-	return r.currentFilePath, offset, nil
 }
 
 func (r *RotateWriter) getNewWriter() error {
@@ -93,22 +174,57 @@ func (r *RotateWriter) getNewWriter() error {
 		if err := r.writer.Sync(); err != nil {
 			return err
 		}
+		r.releasePreallocated()
 		if err := r.writer.Close(); err != nil {
 			return err
 		}
 		r.writer = nil
 	}
-	r.currentFilePath = r.getNextFilePath()
-	err := datafile.WriteFileHeader(r.fs, r.currentFilePath, time.Now())
+	path, err := r.getNextFilePath()
 	if err != nil {
 		return err
 	}
+	r.currentFilePath = path
+	r.created, r.records = time.Now(), 0
+	encoding := datafile.Encoding{RecordFormat: int(r.format), Checksum: int(r.checksum)}
+	if r.keyring.CanEncrypt() {
+		encoding.Cipher = datafile.CipherAESGCM
+	}
+	if err := datafile.WriteFileHeaderWithEncoding(r.fs, r.currentFilePath, r.created, encoding); err != nil {
+		return err
+	}
+	return r.openWriter()
+}
+
+// Reopen makes the existing data file at path (created at created, with the given number of records) the current file,
+// records are appended to it until the writer rotates. It must be called before the first write
+func (r *RotateWriter) Reopen(path string, created time.Time, records int) error {
+	if r.writer != nil {
+		return errors.New("rotate writer: a file is already open")
+	}
+	r.currentFilePath = path
+	r.created, r.records = created, records
+	if err := r.openWriter(); err != nil {
+		return err
+	}
+	r.shouldRotate = r.writer.Size() > int64(r.maxDatafileSize) || (r.maxRecords > 0 && records >= r.maxRecords)
+	return nil
+}
+
+// openWriter opens the writer of the current file
+func (r *RotateWriter) openWriter() error {
 	if r.isBuffered {
 		writer, err := record.NewBufferedWriter(r.fs, r.currentFilePath)
 		if err != nil {
 			return err
 		}
 		r.writer = writer
+	} else if r.bufferSize > 0 {
+		writer, err := record.NewBufferedWriterSize(r.fs, r.currentFilePath, r.bufferSize)
+		if err != nil {
+			return err
+		}
+		r.writer = writer
 	} else {
 		writer, err := record.NewWriter(r.fs, r.currentFilePath)
 		if err != nil {
@@ -116,16 +232,131 @@ func (r *RotateWriter) getNewWriter() error {
 		}
 		r.writer = writer
 	}
+	r.writer.SetLimits(r.limits)
+	r.writer.SetKeyring(r.keyring)
+	if r.preallocate {
+		if err := vfs.New(r.fs).Preallocate(r.writer.File(), 0, int64(r.maxDatafileSize)); err != nil {
+			slog.Debug("could not preallocate data file", "path", r.currentFilePath, "error", err)
+		}
+	}
 	return nil
 }
 
+// releasePreallocated frees the space that was reserved past the end of the current file, which won't grow anymore, by
+// truncating it to it's size
+func (r *RotateWriter) releasePreallocated() {
+	if !r.preallocate {
+		return
+	}
+	// The buffered records must be in the file, otherwise truncating would extend it
+	if err := r.writer.Flush(); err != nil {
+		return
+	}
+	if err := r.writer.File().Truncate(r.writer.Size()); err != nil {
+		slog.Debug("could not free preallocated space", "path", r.currentFilePath, "error", err)
+	}
+}
+
+// SetPreallocate makes the writer reserve space for maxDatafileSize bytes for every new file, it applies from the next
+// file
+func (r *RotateWriter) SetPreallocate(preallocate bool) {
+	r.preallocate = preallocate
+}
+
+// SetRotationLimits makes the writer also rotate files once they have maxRecords records, or on the first write after
+// they are older than maxAge. 0 disables either limit
+func (r *RotateWriter) SetRotationLimits(maxRecords int, maxAge time.Duration) {
+	r.maxRecords = maxRecords
+	r.maxAge = maxAge
+}
+
+// SetBufferSize makes the writer buffer up to size bytes of records in memory before writing them to the file, 0
+// disables the buffer. It applies from the next file
+func (r *RotateWriter) SetBufferSize(size int) {
+	r.bufferSize = size
+}
+
+// Flush writes the buffered records to the current file, without syncing it
+func (r *RotateWriter) Flush() error {
+	if r.writer != nil {
+		return r.writer.Flush()
+	}
+	return nil
+}
+
+// Buffered returns the number of bytes of records that are buffered, and not in the current file yet
+func (r *RotateWriter) Buffered() int {
+	if r.writer != nil {
+		return r.writer.Buffered()
+	}
+	return 0
+}
+
+// Rotate syncs and closes the current file, and starts a new one, subsequent writes go to the new file
+func (r *RotateWriter) Rotate() error {
+	r.shouldRotate = false
+	return r.getNewWriter()
+}
+
+// SetLimits sets the largest key and value sizes that are written, it applies from the next write
+func (r *RotateWriter) SetLimits(limits record.Limits) {
+	r.limits = limits
+	if r.writer != nil {
+		r.writer.SetLimits(limits)
+	}
+}
+
+// SetFormat sets the format of the records, it applies from the next file
+func (r *RotateWriter) SetFormat(format record.Format) {
+	r.format = format
+}
+
+// Format returns the format of the records of the current file
+func (r *RotateWriter) Format() record.Format {
+	if r.writer != nil {
+		return r.writer.Format()
+	}
+	return r.format
+}
+
+// SetKeyring makes the writer encrypt new files with the current key of keyring (if it has one), it applies from the
+// next file
+func (r *RotateWriter) SetKeyring(keyring *record.Keyring) {
+	r.keyring = keyring
+}
+
+// Encrypted returns true if the records of new files are encrypted
+func (r *RotateWriter) Encrypted() bool {
+	return r.keyring.CanEncrypt()
+}
+
+// EncodedSize returns the number of bytes taken by a record with the given key and value sizes in the current file
+func (r *RotateWriter) EncodedSize(keySize, valueSize uint32) int64 {
+	if r.writer != nil {
+		return r.writer.EncodedSize(keySize, valueSize)
+	}
+	size := r.format.EncodedSize(keySize, valueSize)
+	if r.Encrypted() {
+		size += record.EncryptionOverhead
+	}
+	return size
+}
+
+// SetChecksum sets the algorithm of the checksums of the records, it applies from the next file
+func (r *RotateWriter) SetChecksum(checksum record.Checksum) {
+	r.checksum = checksum
+}
+
 // NewRotateWriter creates a new instance of RotateWriter with the specified parameters.
-func NewRotateWriter(fs afero.Fs, maxDatafileSize int, isBuffered bool, getNextFilePath func() string) *RotateWriter {
+func NewRotateWriter(fs afero.Fs, maxDatafileSize int, isBuffered bool, getNextFilePath func() (string, error)) *RotateWriter {
 	return &RotateWriter{
 		fs:              fs,
 		maxDatafileSize: maxDatafileSize,
 		getNextFilePath: getNextFilePath,
 		shouldRotate:    false,
 		isBuffered:      isBuffered,
+		limits:          record.DefaultLimits,
+		format:          record.FormatV1,
+		checksum:        record.ChecksumCRC32,
 	}
 }
@@ -1,7 +1,14 @@
 package filemanager
 
 import (
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
 	"testing"
+	"time"
+
+	"github.com/ananthvk/kvdb/internal/record"
 
 	"github.com/spf13/afero"
 )
@@ -9,9 +16,9 @@ import (
 func TestRotateWriter_Write(t *testing.T) {
 	fs := afero.NewMemMapFs()
 	fileCounter := 0
-	getNextFilePath := func() string {
+	getNextFilePath := func() (string, error) {
 		fileCounter++
-		return "testfile_" + string(rune(fileCounter)) + ".dat"
+		return "testfile_" + string(rune(fileCounter)) + ".dat", nil
 	}
 	writer := NewRotateWriter(fs, 10, false, getNextFilePath)
 
@@ -46,8 +53,8 @@ func TestRotateWriter_Write(t *testing.T) {
 
 func TestRotateWriter_Close(t *testing.T) {
 	fs := afero.NewMemMapFs()
-	getNextFilePath := func() string {
-		return "testfile.dat"
+	getNextFilePath := func() (string, error) {
+		return "testfile.dat", nil
 	}
 	writer := NewRotateWriter(fs, 10, false, getNextFilePath)
 
@@ -64,8 +71,8 @@ func TestRotateWriter_Close(t *testing.T) {
 
 func TestRotateWriter_Sync(t *testing.T) {
 	fs := afero.NewMemMapFs()
-	getNextFilePath := func() string {
-		return "testfile.dat"
+	getNextFilePath := func() (string, error) {
+		return "testfile.dat", nil
 	}
 	writer := NewRotateWriter(fs, 10, false, getNextFilePath)
 
@@ -82,13 +89,13 @@ func TestRotateWriter_Sync(t *testing.T) {
 
 func TestRotateWriter_GetNewWriter_Error(t *testing.T) {
 	fs := afero.NewMemMapFs()
-	getNextFilePath := func() string {
-		return "testfile.dat"
+	getNextFilePath := func() (string, error) {
+		return "testfile.dat", nil
 	}
 	writer := NewRotateWriter(fs, 10, false, getNextFilePath)
 
-	writer.getNextFilePath = func() string {
-		return ""
+	writer.getNextFilePath = func() (string, error) {
+		return "", nil
 	}
 
 	err := writer.getNewWriter()
@@ -99,9 +106,9 @@ func TestRotateWriter_GetNewWriter_Error(t *testing.T) {
 func TestRotateWriter_MultipleRotations(t *testing.T) {
 	fs := afero.NewMemMapFs()
 	fileCounter := 0
-	getNextFilePath := func() string {
+	getNextFilePath := func() (string, error) {
 		fileCounter++
-		return "testfile_" + string(rune(48+fileCounter)) + ".dat"
+		return "testfile_" + string(rune(48+fileCounter)) + ".dat", nil
 	}
 	writer := NewRotateWriter(fs, 20, false, getNextFilePath)
 
@@ -120,7 +127,7 @@ func TestRotateWriter_MultipleRotations(t *testing.T) {
 
 func TestRotateWriter_TombstoneWriting(t *testing.T) {
 	fs := afero.NewMemMapFs()
-	writer := NewRotateWriter(fs, 100, false, func() string { return "testfile.dat" })
+	writer := NewRotateWriter(fs, 100, false, func() (string, error) { return "testfile.dat", nil })
 
 	filePath, _, err := writer.Write([]byte("key1"), []byte(""), true)
 	if err != nil {
@@ -133,7 +140,7 @@ func TestRotateWriter_TombstoneWriting(t *testing.T) {
 }
 
 func TestRotateWriter_SyncWithoutWriter(t *testing.T) {
-	writer := NewRotateWriter(afero.NewMemMapFs(), 10, false, func() string { return "testfile.dat" })
+	writer := NewRotateWriter(afero.NewMemMapFs(), 10, false, func() (string, error) { return "testfile.dat", nil })
 
 	// Sync without any write should not error
 	err := writer.Sync()
@@ -144,10 +151,84 @@ func TestRotateWriter_SyncWithoutWriter(t *testing.T) {
 
 func TestRotateWriter_EmptyKeyValue(t *testing.T) {
 	fs := afero.NewMemMapFs()
-	writer := NewRotateWriter(fs, 100, false, func() string { return "testfile.dat" })
+	writer := NewRotateWriter(fs, 100, false, func() (string, error) { return "testfile.dat", nil })
 
 	_, _, err := writer.Write([]byte{}, []byte{}, false)
 	if err != nil {
 		t.Fatalf("Write() with empty key/value error = %v", err)
 	}
 }
+
+func TestRotateWriter_Preallocate(t *testing.T) {
+	fs := afero.NewOsFs()
+	dir := t.TempDir()
+	var paths []string
+	writer := NewRotateWriter(fs, 1<<20, true, func() (string, error) {
+		paths = append(paths, filepath.Join(dir, fmt.Sprintf("%d.dat", len(paths)+1)))
+		return paths[len(paths)-1], nil
+	})
+	writer.SetPreallocate(true)
+	for i := range 100 {
+		if _, _, err := writer.Write(fmt.Appendf(nil, "key%d", i), []byte("value"), false); err != nil {
+			t.Fatalf("Write() error = %v", err)
+		}
+	}
+	if err := writer.Rotate(); err != nil {
+		t.Fatalf("Rotate() error = %v", err)
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+
+	// The sealed file has every record, and was not extended when the reserved space was freed
+	scanner, err := record.NewScanner(fs, paths[0])
+	if err != nil {
+		t.Fatalf("NewScanner() error = %v", err)
+	}
+	defer scanner.Close()
+	count := 0
+	for {
+		_, _, err := scanner.Scan()
+		if err != nil {
+			if !errors.Is(err, io.EOF) {
+				t.Errorf("expected the file to end after the last record, got %v", err)
+			}
+			break
+		}
+		count++
+	}
+	if count != 100 {
+		t.Errorf("expected 100 records, got %d", count)
+	}
+}
+
+func TestRotateWriter_RotationLimits(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	var paths []string
+	writer := NewRotateWriter(fs, 1<<20, false, func() (string, error) {
+		paths = append(paths, fmt.Sprintf("%d.dat", len(paths)+1))
+		return paths[len(paths)-1], nil
+	})
+	writer.SetRotationLimits(3, 0)
+	for i := range 7 {
+		if _, _, err := writer.Write(fmt.Appendf(nil, "key%d", i), []byte("value"), false); err != nil {
+			t.Fatalf("Write() error = %v", err)
+		}
+	}
+	if len(paths) != 3 {
+		t.Errorf("expected 3 files of at most 3 records, got %v", paths)
+	}
+
+	// A file that's older than the maximum age is rotated on the next write
+	writer.SetRotationLimits(0, time.Hour)
+	writer.Write([]byte("key"), []byte("value"), false)
+	if len(paths) != 3 {
+		t.Errorf("expected no rotation before the file is too old, got %v", paths)
+	}
+	writer.created = writer.created.Add(-time.Hour)
+	writer.Write([]byte("key"), []byte("value"), false)
+	if len(paths) != 4 {
+		t.Errorf("expected the old file to be rotated, got %v", paths)
+	}
+	writer.Close()
+}
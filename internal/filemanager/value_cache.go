@@ -0,0 +1,146 @@
+package filemanager
+
+import (
+	"container/list"
+	"sync"
+
+	"github.com/ananthvk/kvdb/internal/record"
+)
+
+// Estimated memory used by each entry of the value cache in addition to the value, i.e. the list element, the entry
+// and the map entry
+const valueCacheEntryOverhead = 128
+
+type valueCacheKey struct {
+	fileId int
+	offset int64
+}
+
+// valueCacheEntry is a record read by ReadValueAt, only it's header and value are kept
+type valueCacheEntry struct {
+	key    valueCacheKey
+	record record.Record
+}
+
+// valueCache is an LRU cache of records (with their values) keyed by their location, it's safe for concurrent use. A location is never
+// reused (data files are append only, and file ids are never reused), so an entry is never stale, entries are only
+// removed to free their memory for values that are still live
+type valueCache struct {
+	mu       sync.Mutex
+	capacity int64
+	size     int64
+	entries  map[valueCacheKey]*list.Element
+	lru      *list.List
+	hits     uint64
+	misses   uint64
+}
+
+func newValueCache(capacity int64) *valueCache {
+	return &valueCache{
+		capacity: capacity,
+		entries:  map[valueCacheKey]*list.Element{},
+		lru:      list.New(),
+	}
+}
+
+// get returns a copy of the cached record at the location
+func (c *valueCache) get(fileId int, offset int64) (*record.Record, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	element, ok := c.entries[valueCacheKey{fileId, offset}]
+	if !ok {
+		c.misses++
+		return nil, false
+	}
+	c.hits++
+	c.lru.MoveToFront(element)
+	rec := element.Value.(*valueCacheEntry).record
+	rec.Value = append(make([]byte, 0, len(rec.Value)), rec.Value...)
+	return &rec, true
+}
+
+// getInto is like get, but appends the cached value to dst[:0] instead of copying the record
+func (c *valueCache) getInto(fileId int, offset int64, dst []byte) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	element, ok := c.entries[valueCacheKey{fileId, offset}]
+	if !ok {
+		c.misses++
+		return dst, false
+	}
+	c.hits++
+	c.lru.MoveToFront(element)
+	return append(dst[:0], element.Value.(*valueCacheEntry).record.Value...), true
+}
+
+// add caches a copy of the record, and evicts the least recently used records until the cache is within it's capacity.
+// Values larger than the capacity are not cached
+func (c *valueCache) add(fileId int, offset int64, rec *record.Record) {
+	size := int64(len(rec.Value)) + valueCacheEntryOverhead
+	if size > c.capacity {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	key := valueCacheKey{fileId, offset}
+	if _, ok := c.entries[key]; ok {
+		return
+	}
+	entry := &valueCacheEntry{
+		key:    key,
+		record: record.Record{Header: rec.Header, Value: append(make([]byte, 0, len(rec.Value)), rec.Value...), Size: rec.Size},
+	}
+	c.entries[key] = c.lru.PushFront(entry)
+	c.size += size
+	for c.size > c.capacity {
+		c.removeElement(c.lru.Back())
+	}
+}
+
+// invalidate removes the value at the location
+func (c *valueCache) invalidate(fileId int, offset int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if element, ok := c.entries[valueCacheKey{fileId, offset}]; ok {
+		c.removeElement(element)
+	}
+}
+
+// invalidateFiles removes the values of the given files
+func (c *valueCache) invalidateFiles(ids []int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	removed := make(map[int]bool, len(ids))
+	for _, id := range ids {
+		removed[id] = true
+	}
+	for element := c.lru.Front(); element != nil; {
+		next := element.Next()
+		if removed[element.Value.(*valueCacheEntry).key.fileId] {
+			c.removeElement(element)
+		}
+		element = next
+	}
+}
+
+// removeElement removes an entry, the caller must hold the lock
+func (c *valueCache) removeElement(element *list.Element) {
+	entry := c.lru.Remove(element).(*valueCacheEntry)
+	delete(c.entries, entry.key)
+	c.size -= int64(len(entry.record.Value)) + valueCacheEntryOverhead
+}
+
+// ValueCacheStats are the counters of the value cache
+type ValueCacheStats struct {
+	Hits    uint64
+	Misses  uint64
+	Entries int
+	// Estimated memory used by the cached values, in bytes
+	Bytes int64
+}
+
+func (c *valueCache) stats() ValueCacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return ValueCacheStats{Hits: c.hits, Misses: c.misses, Entries: len(c.entries), Bytes: c.size}
+}
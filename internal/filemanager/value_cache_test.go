@@ -0,0 +1,79 @@
+package filemanager
+
+import (
+	"testing"
+
+	"github.com/ananthvk/kvdb/internal/record"
+)
+
+func helperCacheRecord(value string) *record.Record {
+	return &record.Record{Header: record.Header{ValueSize: uint32(len(value))}, Value: []byte(value)}
+}
+
+func TestValueCacheEviction(t *testing.T) {
+	// Room for three 10 byte values
+	cache := newValueCache(3 * (10 + valueCacheEntryOverhead))
+	for i := range 3 {
+		cache.add(1, int64(i), helperCacheRecord("0123456789"))
+	}
+	// Offset 0 becomes the most recently used, so offset 1 is evicted
+	if _, ok := cache.get(1, 0); !ok {
+		t.Fatalf("expected offset 0 to be cached")
+	}
+	cache.add(2, 0, helperCacheRecord("abcdefghij"))
+	if _, ok := cache.get(1, 1); ok {
+		t.Errorf("expected the least recently used value to be evicted")
+	}
+	for _, key := range []valueCacheKey{{1, 0}, {1, 2}, {2, 0}} {
+		if _, ok := cache.get(key.fileId, key.offset); !ok {
+			t.Errorf("expected %v to be cached", key)
+		}
+	}
+	// Values larger than the budget are not cached
+	cache.add(3, 0, helperCacheRecord(string(make([]byte, 4*(10+valueCacheEntryOverhead)))))
+	if _, ok := cache.get(3, 0); ok {
+		t.Errorf("expected a value larger than the budget not to be cached")
+	}
+	stats := cache.stats()
+	if stats.Hits != 4 || stats.Misses != 2 || stats.Entries != 3 || stats.Bytes != 3*(10+valueCacheEntryOverhead) {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+}
+
+func TestValueCacheCopies(t *testing.T) {
+	cache := newValueCache(1024)
+	rec := helperCacheRecord("value")
+	cache.add(1, 0, rec)
+	rec.Value[0] = 'X'
+	got, ok := cache.get(1, 0)
+	if !ok || string(got.Value) != "value" || got.Header.ValueSize != 5 {
+		t.Fatalf("expected the cached record to be a copy, got %+v, %v", got, ok)
+	}
+	got.Value[0] = 'Y'
+	if got, _ := cache.get(1, 0); string(got.Value) != "value" {
+		t.Errorf("expected the returned record to be a copy, got %q", got.Value)
+	}
+}
+
+func TestValueCacheInvalidate(t *testing.T) {
+	cache := newValueCache(1024)
+	for id := 1; id <= 3; id++ {
+		cache.add(id, 0, helperCacheRecord("a"))
+		cache.add(id, 10, helperCacheRecord("b"))
+	}
+	cache.invalidate(1, 10)
+	cache.invalidateFiles([]int{2, 4})
+	for _, key := range []valueCacheKey{{1, 10}, {2, 0}, {2, 10}} {
+		if _, ok := cache.get(key.fileId, key.offset); ok {
+			t.Errorf("expected %v to be invalidated", key)
+		}
+	}
+	for _, key := range []valueCacheKey{{1, 0}, {3, 0}, {3, 10}} {
+		if _, ok := cache.get(key.fileId, key.offset); !ok {
+			t.Errorf("expected %v to be cached", key)
+		}
+	}
+	if stats := cache.stats(); stats.Entries != 3 || stats.Bytes != 3*(1+valueCacheEntryOverhead) {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+}
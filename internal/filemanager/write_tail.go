@@ -0,0 +1,96 @@
+package filemanager
+
+import (
+	"bytes"
+	"sync"
+
+	"github.com/ananthvk/kvdb/internal/datafile"
+	"github.com/ananthvk/kvdb/internal/record"
+)
+
+/*
+Tail of the write buffer
+
+With a write buffer (SetWriteBufferSize), the records at the end of the active file are in memory until the buffer is
+flushed, but the keydir points to them as soon as they are written. Instead of flushing the buffer every time the active
+file is read, the file manager keeps a copy of every record that is (even partly) in the buffer, keyed by it's offset,
+and the reads by location (ReadValueAt, ReadValueInto, ReadRecordAtStrict and OpenValue) look in it before the file.
+
+The tail is changed with the file manager's lock held, right after the record is written: if the buffer was flushed
+while the record was written, the records before it are in the file and the tail is cleared, and the record is added if
+some of it is still in the buffer. So a record is only removed from the tail once it's completely in the file, and a
+read that does not find it's record in the tail finds it in the file. Records streamed with WriteRecordFromReader are
+not kept (their value is not in memory), the buffer is flushed after them instead.
+
+The tail holds at most the size of the buffer, plus one record that did not fit in it
+*/
+
+type writeTail struct {
+	mu      sync.RWMutex
+	fileId  int
+	records map[int64]*record.Record
+}
+
+func newWriteTail() *writeTail {
+	return &writeTail{records: map[int64]*record.Record{}}
+}
+
+// get returns the record of the file at the offset, if it's in the tail
+func (t *writeTail) get(fileId int, offset int64) (*record.Record, bool) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	if fileId != t.fileId {
+		return nil, false
+	}
+	rec, ok := t.records[offset]
+	return rec, ok
+}
+
+// add adds a copy of the record written at the offset of the file, the records of other files are removed
+func (t *writeTail) add(fileId int, offset int64, rec record.Record) {
+	rec.Key, rec.Value = bytes.Clone(rec.Key), bytes.Clone(rec.Value)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if fileId != t.fileId {
+		clear(t.records)
+		t.fileId = fileId
+	}
+	t.records[offset] = &rec
+}
+
+func (t *writeTail) clear() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	clear(t.records)
+}
+
+// trackWrite updates the tail after a record of size bytes was written at the offset (from the start of the file) of
+// the active file, bufferedBefore is the number of bytes that were buffered before the write (0 if the write started a
+// new file). The caller must hold the lock
+func (f *FileManager) trackWrite(offset int64, size int64, bufferedBefore int, rec func() record.Record) {
+	buffered := f.rotateWriter.Buffered()
+	f.bufferedBytes.Store(int64(buffered))
+	if int64(buffered) < int64(bufferedBefore)+size {
+		// The buffer was flushed during the write, the records before this one are in the file
+		f.tail.clear()
+	}
+	if buffered > 0 {
+		// Records are read by their offset from the first record
+		f.tail.add(f.activeDataFile, offset-datafile.FileHeaderSize, rec())
+	}
+}
+
+// trackWrites is trackWrite for records that were written with one write (by WriteRecords), the file ends at end
+// after them. The records that end in the last bytes of the file, which are still buffered, are added to the tail
+func (f *FileManager) trackWrites(offsets, sizes []int64, end int64, bufferedBefore int, records []record.Record) {
+	buffered := f.rotateWriter.Buffered()
+	f.bufferedBytes.Store(int64(buffered))
+	if int64(buffered) < int64(bufferedBefore)+end-offsets[0] {
+		f.tail.clear()
+	}
+	for i, offset := range offsets {
+		if offset+sizes[i] > end-int64(buffered) {
+			f.tail.add(f.activeDataFile, offset-datafile.FileHeaderSize, records[i])
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package filemanager
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"testing"
+
+	"github.com/ananthvk/kvdb/internal/datafile"
+	"github.com/ananthvk/kvdb/internal/record"
+	"github.com/spf13/afero"
+)
+
+func TestWriteTail_ReadsBufferedRecords(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	fs.Mkdir("data", os.ModePerm)
+	manager, err := NewFileManager(fs, "", 1<<20)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	defer manager.Close()
+	// A small buffer, so that it's flushed in the middle of records
+	manager.SetWriteBufferSize(100)
+
+	type location struct {
+		fileId int
+		offset int64
+	}
+	var locations []location
+	for i := range 200 {
+		// Some values are larger than the buffer
+		value := bytes.Repeat([]byte{byte(i)}, i%150)
+		fileId, offset, err := manager.Write(fmt.Appendf(nil, "key%d", i), value, false)
+		if err != nil {
+			t.Fatalf("write %d failed: %v", i, err)
+		}
+		locations = append(locations, location{fileId, offset - datafile.FileHeaderSize})
+
+		// Every record written so far is readable, from the tail or the file
+		for j := max(0, i-5); j <= i; j++ {
+			want := bytes.Repeat([]byte{byte(j)}, j%150)
+			rec, err := manager.ReadValueAt(locations[j].fileId, locations[j].offset)
+			if err != nil || !bytes.Equal(rec.Value, want) {
+				t.Fatalf("record %d after write %d: expected %d byte value, got %v (err: %v)", j, i, len(want), rec, err)
+			}
+			rec, err = manager.ReadRecordAtStrict(locations[j].fileId, locations[j].offset)
+			if err != nil || string(rec.Key) != fmt.Sprintf("key%d", j) {
+				t.Fatalf("record %d after write %d: expected key%d, got %v (err: %v)", j, i, j, rec, err)
+			}
+		}
+	}
+	if manager.bufferedBytes.Load() == 0 {
+		t.Errorf("expected the last records to be buffered")
+	}
+
+	last := locations[len(locations)-1]
+	reader, size, err := manager.OpenValue(last.fileId, last.offset)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	value, _ := io.ReadAll(reader)
+	reader.Close()
+	if size != int64(len(value)) || !bytes.Equal(value, bytes.Repeat([]byte{199}, 199%150)) {
+		t.Errorf("expected the buffered value from OpenValue, got %d bytes", len(value))
+	}
+
+	if err := manager.Flush(); err != nil {
+		t.Fatalf("flush failed: %v", err)
+	}
+	if _, ok := manager.tail.get(last.fileId, last.offset); ok {
+		t.Errorf("expected the tail to be empty after a flush")
+	}
+	if rec, err := manager.ReadValueAt(last.fileId, last.offset); err != nil || len(rec.Value) != 199%150 {
+		t.Errorf("expected the flushed record to be read from the file, got %v (err: %v)", rec, err)
+	}
+}
+
+func TestWriteTail_WriteRecords(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	fs.Mkdir("data", os.ModePerm)
+	manager, err := NewFileManager(fs, "", 1<<20)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	defer manager.Close()
+	manager.SetWriteBufferSize(100)
+	// Batches are split between the data files
+	manager.SetRotationLimits(7, 0)
+
+	type location struct {
+		fileId int
+		offset int64
+	}
+	var locations []location
+	for batch := range 20 {
+		var records []record.Record
+		for j := range batch % 6 {
+			i := len(locations) + j
+			value := bytes.Repeat([]byte{byte(i)}, i%150)
+			records = append(records, record.Record{Header: record.Header{RecordType: record.RecordTypePut},
+				Key: fmt.Appendf(nil, "key%d", i), Value: value})
+		}
+		fileIds, offsets, err := manager.WriteRecords(records)
+		if err != nil || len(offsets) != len(records) {
+			t.Fatalf("batch %d failed: %v", batch, err)
+		}
+		for j := range offsets {
+			locations = append(locations, location{fileIds[j], offsets[j] - datafile.FileHeaderSize})
+		}
+
+		// Every record written so far is readable, from the tail or the file
+		for i := range locations {
+			want := bytes.Repeat([]byte{byte(i)}, i%150)
+			rec, err := manager.ReadValueAt(locations[i].fileId, locations[i].offset)
+			if err != nil || !bytes.Equal(rec.Value, want) {
+				t.Fatalf("record %d after batch %d: expected %d byte value, got %v (err: %v)", i, batch, len(want), rec, err)
+			}
+		}
+	}
+	for i, loc := range locations {
+		if loc.fileId != locations[0].fileId+i/7 {
+			t.Errorf("record %d: expected file %d, got %d", i, locations[0].fileId+i/7, loc.fileId)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package format
+
+import (
+	"time"
+
+	"github.com/ananthvk/kvdb/internal/datafile"
+	"github.com/ananthvk/kvdb/internal/hintfile"
+	"github.com/ananthvk/kvdb/internal/record"
+	"github.com/spf13/afero"
+)
+
+// sampleTime is the timestamp of every record in the sample files, so that the files are the same every time
+var sampleTime = time.UnixMicro(1700000000000000)
+
+type samplePair struct {
+	key, value []byte
+}
+
+var samplePuts = []samplePair{
+	{[]byte("name"), []byte("kvdb")},
+	{[]byte(""), []byte("")},
+	{[]byte{0x00, 0xff}, []byte{0x01, 0x02, 0x03}},
+	{[]byte("name"), []byte("overwritten")},
+}
+
+// WriteSample writes sample files with the writers of the datastore, as test vectors for other implementations of the
+// format: a data file with puts (including an empty key and value, and binary data) and a tombstone at dataPath, and a
+// data file with only puts at hintDataPath, with it's hint file at hintPath. The data files have records in the given
+// format and with the given checksum algorithm. The files must not exist
+func WriteSample(fs afero.Fs, encoding datafile.Encoding, dataPath, hintDataPath, hintPath string) error {
+	if _, err := writeSampleData(fs, encoding, dataPath, true); err != nil {
+		return err
+	}
+	positions, err := writeSampleData(fs, encoding, hintDataPath, false)
+	if err != nil {
+		return err
+	}
+
+	writer, err := hintfile.NewWriter(fs, hintPath)
+	if err != nil {
+		return err
+	}
+	for i, pair := range samplePuts {
+		err := writer.WriteHintRecord(&hintfile.HintRecord{
+			Timestamp: sampleTime,
+			KeySize:   uint32(len(pair.key)),
+			ValueSize: uint32(len(pair.value)),
+			ValuePos:  positions[i] - datafile.FileHeaderSize,
+			Key:       pair.key,
+		})
+		if err != nil {
+			writer.Close()
+			return err
+		}
+	}
+	return writer.Close()
+}
+
+// writeSampleData writes the sample puts (and a tombstone if withTombstone is true) to a new data file, and returns the
+// offsets of the puts from the start of the file
+func writeSampleData(fs afero.Fs, encoding datafile.Encoding, path string, withTombstone bool) ([]int64, error) {
+	if err := datafile.WriteFileHeaderWithEncoding(fs, path, sampleTime, encoding); err != nil {
+		return nil, err
+	}
+	writer, err := record.NewBufferedWriter(fs, path)
+	if err != nil {
+		return nil, err
+	}
+	var positions []int64
+	for _, pair := range samplePuts {
+		pos, err := writer.WriteKeyValueWithTs(pair.key, pair.value, sampleTime)
+		if err != nil {
+			writer.Close()
+			return nil, err
+		}
+		positions = append(positions, pos)
+	}
+	if withTombstone {
+		if _, err := writer.WriteTombstoneWithTs([]byte("name"), sampleTime); err != nil {
+			writer.Close()
+			return nil, err
+		}
+	}
+	return positions, writer.Close()
+}
@@ -0,0 +1,199 @@
+package format
+
+import (
+	"encoding/hex"
+	"time"
+
+	"github.com/ananthvk/kvdb/internal/constants"
+	"github.com/ananthvk/kvdb/internal/datafile"
+	"github.com/ananthvk/kvdb/internal/hintfile"
+	"github.com/ananthvk/kvdb/internal/record"
+)
+
+/*
+The format package describes the on-disk format of data files and hint files as data, so that it can be published as a
+machine-readable spec (see cmd/kvformat), and so that files can be validated by a decoder that only knows the spec.
+
+The sizes, magic bytes, versions and limits come from the packages that read and write the files. The field offsets
+can't be read from the code, so they are listed here, and the tests write files with the real writers and decode them
+with the spec, which fails if the two go out of sync
+*/
+
+// Field is a fixed size field at a fixed offset, or (if SizeField is set) a variable length field whose size is
+// stored in another field of the same structure
+type Field struct {
+	Name   string `json:"name"`
+	Offset int    `json:"offset"`
+	Size   int    `json:"size,omitempty"`
+	// One of uint8, uint32, uint64, int64, uvarint, bytes. uvarint fields have no fixed size
+	Type string `json:"type"`
+	// Name of the field that contains the size of this field, for variable length fields
+	SizeField   string `json:"size_field,omitempty"`
+	Description string `json:"description"`
+}
+
+// Checksum describes the checksum stored at the end of a record. The algorithm of a data file is given by it's header,
+// see ChecksumAlgorithms
+type Checksum struct {
+	Algorithm string `json:"algorithm"`
+	Size      int    `json:"size"`
+	Covers    string `json:"covers"`
+}
+
+// Layout is a structure that is made of a fixed size header followed by variable length fields. The fields are back to
+// back, in order, so a field that follows a variable length field has the offset of the first variable length field
+type Layout struct {
+	HeaderSize int       `json:"header_size"`
+	Fields     []Field   `json:"fields"`
+	Checksum   *Checksum `json:"checksum,omitempty"`
+}
+
+// Spec is the complete description of the on-disk format
+type Spec struct {
+	// Version of the data file format, in major.minor.patch form
+	Version      string `json:"version"`
+	VersionMajor int    `json:"version_major"`
+	VersionMinor int    `json:"version_minor"`
+	VersionPatch int    `json:"version_patch"`
+	ByteOrder    string `json:"byte_order"`
+	// Hex encoded magic bytes at the start of every data file
+	Magic      string `json:"magic"`
+	FileHeader Layout `json:"file_header"`
+	// Records of data files with bit 0 of the minor version unset (v1 records), and set (v2 records)
+	Record   Layout `json:"record"`
+	RecordV2 Layout `json:"record_v2"`
+	// Hex encoded magic bytes at the start of every hint file, and the header they start
+	HintMagic      string         `json:"hint_magic"`
+	HintVersion    int            `json:"hint_version"`
+	HintFileHeader Layout         `json:"hint_file_header"`
+	HintRecord     Layout         `json:"hint_record"`
+	RecordTypes    map[string]int `json:"record_types"`
+	// Set in the key_size of the hint of a put_immutable record, and of a delete record, the key size is in the other
+	// bits
+	HintImmutableFlag uint32 `json:"hint_immutable_flag"`
+	HintTombstoneFlag uint32 `json:"hint_tombstone_flag"`
+	// Checksum algorithms of the records, by the value of bits 1 and 2 of the minor version
+	ChecksumAlgorithms map[string]int `json:"checksum_algorithms"`
+	// Default size limits, a datastore can set other limits in it's metafile
+	MaxKeySize   int `json:"max_key_size"`
+	MaxValueSize int `json:"max_value_size"`
+	// File names, as fmt format strings of the file id
+	DataFileName string   `json:"data_file_name"`
+	HintFileName string   `json:"hint_file_name"`
+	Notes        []string `json:"notes"`
+}
+
+const valueTypeDescription = "type of the value plus one (1 string, 2 hash, 3 list, 4 set), 0 in records written before the type was recorded, which are strings"
+
+// Current returns the spec of the format written by this version of kvdb
+func Current() *Spec {
+	header := datafile.NewFileHeaderWithEncoding(time.Time{}, datafile.Encoding{
+		RecordFormat: datafile.RecordFormatV2,
+		Checksum:     datafile.ChecksumXXHash64,
+	})
+	return &Spec{
+		Version:      datafile.Version(),
+		VersionMajor: int(header.VersionMajor),
+		VersionMinor: int(header.VersionMinor),
+		VersionPatch: int(header.VersionPatch),
+		ByteOrder:    "little-endian",
+		Magic:        hex.EncodeToString(datafile.MagicBytes()),
+		FileHeader: Layout{
+			HeaderSize: datafile.FileHeaderSize,
+			Fields: []Field{
+				{Name: "magic", Offset: 0, Size: len(datafile.MagicBytes()), Type: "bytes", Description: "magic bytes, see magic"},
+				{Name: "version_major", Offset: 8, Size: 1, Type: "uint8", Description: "readers must reject files with a different major version"},
+				{Name: "version_minor", Offset: 9, Size: 1, Type: "uint8", Description: "readers must reject files with a newer minor version. Bit 0 is 0 for files with v1 records (record), and 1 for files with v2 records (record_v2), bits 1 and 2 are the checksum algorithm of the records (checksum_algorithms), bit 4 is set in files with v2 records, the v2 records of files with bit 4 unset do not have the value_type field"},
+				{Name: "version_patch", Offset: 10, Size: 1, Type: "uint8", Description: "patch version"},
+				{Name: "timestamp", Offset: 11, Size: 8, Type: "uint64", Description: "creation time of the file, microseconds since the unix epoch"},
+			},
+		},
+		Record: Layout{
+			HeaderSize: record.HeaderSize,
+			Fields: []Field{
+				{Name: "timestamp", Offset: 0, Size: 8, Type: "uint64", Description: "time of the write, microseconds since the unix epoch"},
+				{Name: "key_size", Offset: 8, Size: 4, Type: "uint32", Description: "size of key in bytes"},
+				{Name: "value_size", Offset: 12, Size: 4, Type: "uint32", Description: "size of value in bytes, 0 for tombstones"},
+				{Name: "record_type", Offset: 16, Size: 1, Type: "uint8", Description: "see record_types"},
+				{Name: "value_type", Offset: 17, Size: 1, Type: "uint8", Description: valueTypeDescription},
+				{Name: "reserved", Offset: 18, Size: 2, Type: "bytes", Description: "always 0"},
+				{Name: "key", Offset: record.HeaderSize, Type: "bytes", SizeField: "key_size", Description: "the key"},
+				{Name: "value", Offset: record.HeaderSize, Type: "bytes", SizeField: "value_size", Description: "the value, follows the key"},
+			},
+			Checksum: &Checksum{Algorithm: "see checksum_algorithms", Size: 4, Covers: "record header, key and value"},
+		},
+		RecordV2: Layout{
+			HeaderSize: record.HeaderSizeV2,
+			Fields: []Field{
+				{Name: "timestamp", Offset: 0, Size: 8, Type: "uint64", Description: "time of the write, microseconds since the unix epoch"},
+				{Name: "record_type", Offset: 8, Size: 1, Type: "uint8", Description: "see record_types"},
+				{Name: "value_type", Offset: 9, Size: 1, Type: "uint8", Description: valueTypeDescription},
+				{Name: "key_size", Offset: record.HeaderSizeV2, Type: "uvarint", Description: "size of key in bytes, at most 5 bytes long"},
+				{Name: "value_size", Offset: record.HeaderSizeV2, Type: "uvarint", Description: "size of value in bytes, 0 for tombstones, follows the key size"},
+				{Name: "key", Offset: record.HeaderSizeV2, Type: "bytes", SizeField: "key_size", Description: "the key, follows the value size"},
+				{Name: "value", Offset: record.HeaderSizeV2, Type: "bytes", SizeField: "value_size", Description: "the value, follows the key"},
+			},
+			Checksum: &Checksum{Algorithm: "see checksum_algorithms", Size: 4, Covers: "record header (including the sizes), key and value"},
+		},
+		HintMagic:   hex.EncodeToString(hintfile.MagicBytes()),
+		HintVersion: hintfile.HintFileVersion,
+		HintFileHeader: Layout{
+			HeaderSize: hintfile.HintFileHeaderSize,
+			Fields: []Field{
+				{Name: "magic", Offset: 0, Size: len(hintfile.MagicBytes()), Type: "bytes", Description: "magic bytes, see hint_magic"},
+				{Name: "version", Offset: 8, Size: 1, Type: "uint8", Description: "readers must reject files with another version, see hint_version"},
+				{Name: "flags", Offset: 9, Size: 1, Type: "uint8", Description: "bit 0 is set if the keys are encrypted (AES-GCM), bit 1 is set if the data file is a log file (its hints include tombstones), bit 2 is set if the hints have the value_type field, the other bits are 0"},
+			},
+		},
+		HintRecord: Layout{
+			HeaderSize: hintfile.HintRecordHeaderSize,
+			Fields: []Field{
+				{Name: "timestamp", Offset: 0, Size: 8, Type: "uint64", Description: "timestamp of the record in the data file"},
+				{Name: "key_size", Offset: 8, Size: 4, Type: "uint32", Description: "size of key in bytes, with hint_immutable_flag set if the record is a put_immutable, and hint_tombstone_flag set if it's a delete"},
+				{Name: "value_size", Offset: 12, Size: 4, Type: "uint32", Description: "size of the value of the record in the data file"},
+				{Name: "value_pos", Offset: 16, Size: 8, Type: "int64", Description: "offset of the record in the data file, from the end of the file header"},
+				{Name: "value_type", Offset: 24, Size: 1, Type: "uint8", Description: "value_type of the record in the data file, only present if bit 2 of the flags is set"},
+				{Name: "key", Offset: hintfile.HintRecordHeaderSize, Type: "bytes", SizeField: "key_size", Description: "the key"},
+			},
+			Checksum: &Checksum{Algorithm: "crc32c", Size: hintfile.HintChecksumSize, Covers: "hint header and key"},
+		},
+		HintImmutableFlag: hintfile.KeySizeImmutableFlag,
+		HintTombstoneFlag: hintfile.KeySizeTombstoneFlag,
+		RecordTypes: map[string]int{
+			"put":           record.RecordTypePut,
+			"delete":        record.RecordTypeDelete,
+			"put_immutable": record.RecordTypePutImmutable,
+		},
+		ChecksumAlgorithms: map[string]int{
+			"crc32-ieee":     datafile.ChecksumCRC32,
+			"crc32c":         datafile.ChecksumCRC32C,
+			"xxhash64-low32": datafile.ChecksumXXHash64,
+		},
+		MaxKeySize:   constants.MaxKeySize,
+		MaxValueSize: constants.MaxValueSize,
+		DataFileName: "data/%010d.dat",
+		HintFileName: "hint/%010d.hint",
+		Notes: []string{
+			"a data file is the file header followed by records, back to back, with no padding",
+			"a hint file is the hint file header followed by hint records, back to back, one for every record of the data file with the same id. The data file only contains puts (and put_immutables), unless bit 1 of the flags is set, then the hints of its deletes have hint_tombstone_flag set",
+			"hint files written before hint files had a header don't start with hint_magic, they are hint records back to back, without checksums",
+			"the hints of hint files without bit 2 of the flags set (and of hint files without a header) do not have the value_type field, they are 1 byte shorter",
+			"the keys of hint files with bit 0 of the flags set are encrypted, they are not described by this spec",
+			"a put_immutable is the put of a write-once key, no later record of the key replaces or deletes it",
+			"records are replayed in file id order, and in file order within a file, the last record of a key wins",
+			"a data file has records in a single format, given by the minor version of it's header",
+			"bit 3 of the minor version is set in files whose keys and values are encrypted (AES-GCM), their records are not described by this spec, it's not counted when the minor version is compared with version_minor",
+			"checksums are stored little-endian, xxhash64-low32 is the low 32 bits of the xxHash64 (seed 0) of the record",
+		},
+	}
+}
+
+// field returns the field with the given name, it panics if there is none, since the names are fixed by Current
+func (l *Layout) field(name string) Field {
+	for _, f := range l.Fields {
+		if f.Name == name {
+			return f
+		}
+	}
+	panic("format: no field " + name)
+}
@@ -0,0 +1,164 @@
+package format
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"testing"
+
+	"github.com/ananthvk/kvdb/internal/datafile"
+	"github.com/ananthvk/kvdb/internal/hintfile"
+	"github.com/ananthvk/kvdb/internal/record"
+	"github.com/spf13/afero"
+)
+
+// encodings are the record formats and checksum algorithms the sample files are written with
+var encodings = []datafile.Encoding{
+	{RecordFormat: datafile.RecordFormatV1, Checksum: datafile.ChecksumCRC32},
+	{RecordFormat: datafile.RecordFormatV2, Checksum: datafile.ChecksumCRC32},
+	{RecordFormat: datafile.RecordFormatV1, Checksum: datafile.ChecksumCRC32C},
+	{RecordFormat: datafile.RecordFormatV1, Checksum: datafile.ChecksumXXHash64},
+	{RecordFormat: datafile.RecordFormatV2, Checksum: datafile.ChecksumCRC32C},
+	{RecordFormat: datafile.RecordFormatV2, Checksum: datafile.ChecksumXXHash64},
+}
+
+func writeTestSample(t *testing.T, encoding datafile.Encoding) (data, hintData, hint []byte) {
+	t.Helper()
+	fs := afero.NewMemMapFs()
+	if err := WriteSample(fs, encoding, "1.dat", "2.dat", "2.hint"); err != nil {
+		t.Fatalf("failed to write sample: %v", err)
+	}
+	var files [3][]byte
+	for i, name := range []string{"1.dat", "2.dat", "2.hint"} {
+		contents, err := afero.ReadFile(fs, name)
+		if err != nil {
+			t.Fatalf("failed to read %s: %v", name, err)
+		}
+		files[i] = contents
+	}
+	return files[0], files[1], files[2]
+}
+
+func TestSpecMatchesWriters(t *testing.T) {
+	spec := Current()
+	for _, encoding := range encodings {
+		data, hintData, hint := writeTestSample(t, encoding)
+
+		summary, err := spec.ValidateDataFile(data)
+		if err != nil {
+			t.Fatalf("%+v: sample data file does not match the spec: %v", encoding, err)
+		}
+		if summary.Records != len(samplePuts)+1 || summary.Tombstones != 1 {
+			t.Errorf("%+v: unexpected summary %+v", encoding, summary)
+		}
+
+		if _, err := spec.ValidateDataFile(hintData); err != nil {
+			t.Fatalf("%+v: sample data file does not match the spec: %v", encoding, err)
+		}
+		summary, err = spec.ValidateHintFile(hint, hintData)
+		if err != nil {
+			t.Fatalf("%+v: sample hint file does not match the spec: %v", encoding, err)
+		}
+		if summary.Records != len(samplePuts) {
+			t.Errorf("%+v: unexpected summary %+v", encoding, summary)
+		}
+	}
+}
+
+func TestSpecMatchesLogHintFile(t *testing.T) {
+	spec := Current()
+	fs := afero.NewMemMapFs()
+	if err := WriteSample(fs, datafile.DefaultEncoding, "1.dat", "2.dat", "2.hint"); err != nil {
+		t.Fatalf("failed to write sample: %v", err)
+	}
+	// The hints of every record of the data file with the tombstone, like the hint file of a sealed log file
+	scanner, err := record.NewScanner(fs, "1.dat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer scanner.Close()
+	writer, err := hintfile.NewWriter(fs, "1.hint")
+	if err != nil {
+		t.Fatal(err)
+	}
+	writer.SetLogFile()
+	for {
+		rec, offset, err := scanner.Scan()
+		if err != nil {
+			break
+		}
+		writer.WriteHintRecord(&hintfile.HintRecord{
+			Timestamp: rec.Header.Timestamp,
+			KeySize:   rec.Header.KeySize,
+			ValueSize: rec.Header.ValueSize,
+			ValuePos:  offset,
+			Key:       rec.Key,
+			Tombstone: rec.Header.RecordType == record.RecordTypeDelete,
+		})
+	}
+	writer.Close()
+
+	data, _ := afero.ReadFile(fs, "1.dat")
+	hint, _ := afero.ReadFile(fs, "1.hint")
+	summary, err := spec.ValidateHintFile(hint, data)
+	if err != nil {
+		t.Fatalf("log hint file does not match the spec: %v", err)
+	}
+	if summary.Records != len(samplePuts)+1 || summary.Tombstones != 1 {
+		t.Errorf("unexpected summary %+v", summary)
+	}
+}
+
+func TestValidateRejectsDamagedFiles(t *testing.T) {
+	spec := Current()
+	headerSize := spec.FileHeader.HeaderSize
+	for _, encoding := range encodings {
+		data, hintData, hint := writeTestSample(t, encoding)
+		rec := &spec.Record
+		// The first record has a 4 byte key and value, the sizes of a v2 record take a byte each
+		keyOffset := rec.HeaderSize
+		if encoding.RecordFormat == datafile.RecordFormatV2 {
+			rec = &spec.RecordV2
+			keyOffset = rec.HeaderSize + 2
+		}
+
+		tests := []struct {
+			name   string
+			mutate func(data []byte) []byte
+		}{
+			{"magic", func(d []byte) []byte { d[1] = 'x'; return d }},
+			{"major version", func(d []byte) []byte { d[8]++; return d }},
+			{"newer minor version", func(d []byte) []byte { d[9] = byte(spec.VersionMinor + 1); return d }},
+			{"key byte", func(d []byte) []byte { d[headerSize+keyOffset]++; return d }},
+			// The records are checked with another algorithm
+			{"checksum algorithm", func(d []byte) []byte { d[9] ^= 0x2; return d }},
+			{"record type", func(d []byte) []byte { d[headerSize+rec.field("record_type").Offset] = 0x01; return d }},
+			{"truncated", func(d []byte) []byte { return d[:len(d)-1] }},
+		}
+		for _, test := range tests {
+			damaged := test.mutate(append([]byte(nil), data...))
+			if _, err := spec.ValidateDataFile(damaged); !errors.Is(err, ErrInvalidFile) {
+				t.Errorf("%+v: %s: expected ErrInvalidFile, got %v", encoding, test.name, err)
+			}
+		}
+
+		if _, err := spec.ValidateHintFile(hint[:len(hint)-1], nil); !errors.Is(err, ErrInvalidFile) {
+			t.Errorf("%+v: truncated hint: expected ErrInvalidFile, got %v", encoding, err)
+		}
+		// The first hint has a 4 byte key
+		hintHeaderSize, hintSize := spec.HintFileHeader.HeaderSize, spec.HintRecord.HeaderSize+4
+		damaged := append([]byte(nil), hint...)
+		damaged[hintHeaderSize+spec.HintRecord.HeaderSize]++
+		if _, err := spec.ValidateHintFile(damaged, nil); !errors.Is(err, ErrInvalidFile) {
+			t.Errorf("%+v: hint key byte: expected ErrInvalidFile, got %v", encoding, err)
+		}
+		// The first hint points to the first record, make it point to the second one, with a valid checksum
+		damaged = append([]byte(nil), hint...)
+		damaged[hintHeaderSize+16] = byte(record.Format(encoding.RecordFormat).EncodedSize(4, 4))
+		first := damaged[hintHeaderSize:]
+		binary.LittleEndian.PutUint32(first[hintSize:], crc32.Checksum(first[:hintSize], crc32.MakeTable(crc32.Castagnoli)))
+		if _, err := spec.ValidateHintFile(damaged, hintData); !errors.Is(err, ErrInvalidFile) {
+			t.Errorf("%+v: wrong value position: expected ErrInvalidFile, got %v", encoding, err)
+		}
+	}
+}
@@ -0,0 +1,325 @@
+package format
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"strings"
+
+	"github.com/ananthvk/kvdb/internal/xxhash"
+)
+
+var ErrInvalidFile = errors.New("file does not match the format spec")
+
+// Summary describes the records of a file that matches the spec
+type Summary struct {
+	Records    int
+	Tombstones int
+}
+
+// uint reads an unsigned little endian field from buf, which starts at the start of the structure
+func (s *Spec) uint(buf []byte, f Field) uint64 {
+	var b [8]byte
+	copy(b[:], buf[f.Offset:f.Offset+f.Size])
+	return binary.LittleEndian.Uint64(b[:])
+}
+
+func invalid(offset int, format string, args ...any) error {
+	return fmt.Errorf("%w: offset %d: %s", ErrInvalidFile, offset, fmt.Sprintf(format, args...))
+}
+
+// ValidateDataFile checks that data, the contents of a data file, matches the spec. Only the spec is used to decode the
+// file, not the readers of the datastore. Offsets in errors are from the start of the file
+func (s *Spec) ValidateDataFile(data []byte) (Summary, error) {
+	var summary Summary
+	header := &s.FileHeader
+	if len(data) < header.HeaderSize {
+		return summary, invalid(0, "file is %d bytes, shorter than the %d byte file header", len(data), header.HeaderSize)
+	}
+	magic, err := hex.DecodeString(s.Magic)
+	if err != nil {
+		return summary, err
+	}
+	magicField := header.field("magic")
+	if !bytes.Equal(data[magicField.Offset:magicField.Offset+magicField.Size], magic) {
+		return summary, invalid(magicField.Offset, "magic bytes do not match")
+	}
+	major, minor := s.uint(data, header.field("version_major")), s.uint(data, header.field("version_minor"))
+	if int(major) != s.VersionMajor || int(minor&^minorEncrypted) > s.VersionMinor {
+		return summary, invalid(header.field("version_major").Offset, "version %d.%d is not compatible with %s", major, minor, s.Version)
+	}
+	if minor&minorEncrypted != 0 {
+		return summary, invalid(header.field("version_minor").Offset, "records are encrypted, they are not described by the spec")
+	}
+
+	rec := s.recordLayout(minor)
+	checksum, err := s.checksumOf(minor)
+	if err != nil {
+		return summary, invalid(header.field("version_minor").Offset, "%s", err)
+	}
+	for offset := header.HeaderSize; offset < len(data); {
+		buf := data[offset:]
+		decoded, err := s.decodeRecord(buf, rec)
+		if err != nil {
+			return summary, invalid(offset, "%s", err)
+		}
+		recordType, vsize := decoded.fields["record_type"], decoded.fields["value_size"]
+		switch int(recordType) {
+		case s.RecordTypes["put"], s.RecordTypes["put_immutable"]:
+		case s.RecordTypes["delete"]:
+			if vsize != 0 {
+				return summary, invalid(offset, "tombstone with value size %d", vsize)
+			}
+			summary.Tombstones++
+		default:
+			return summary, invalid(offset, "unknown record type 0x%02x", recordType)
+		}
+		// v2 records have no reserved bytes
+		if reserved, ok := decoded.fields["reserved"]; ok && reserved != 0 {
+			return summary, invalid(offset, "reserved bytes are not 0")
+		}
+		size := decoded.size
+		if len(buf) < size+rec.Checksum.Size {
+			return summary, invalid(offset, "truncated record, %d bytes left, record is %d bytes", len(buf), size+rec.Checksum.Size)
+		}
+		stored := binary.LittleEndian.Uint32(buf[size:])
+		if computed := checksum(buf[:size]); stored != computed {
+			return summary, invalid(offset, "checksum is %08x, expected %08x", stored, computed)
+		}
+		summary.Records++
+		offset += size + rec.Checksum.Size
+	}
+	return summary, nil
+}
+
+// Bits of the minor version that are set in encrypted files, and in files whose v2 records have the value_type field
+const (
+	minorEncrypted = 0x8
+	minorValueType = 0x10
+)
+
+// recordLayout returns the layout of the records of a data file with the given minor version
+func (s *Spec) recordLayout(minor uint64) *Layout {
+	if minor&1 == 1 {
+		if minor&minorValueType == 0 {
+			return s.RecordV2.without("value_type")
+		}
+		return &s.RecordV2
+	}
+	return &s.Record
+}
+
+// without returns a copy of the layout without the fixed size field with the given name, the fields are decoded in
+// order, so the offsets of the fields after it do not have to be changed
+func (l *Layout) without(name string) *Layout {
+	removed := l.field(name)
+	layout := &Layout{HeaderSize: l.HeaderSize - removed.Size, Checksum: l.Checksum}
+	for _, f := range l.Fields {
+		if f.Name != name {
+			layout.Fields = append(layout.Fields, f)
+		}
+	}
+	return layout
+}
+
+var castagnoliTable = crc32.MakeTable(crc32.Castagnoli)
+
+// checksumOf returns the function that computes the checksums of the records of a data file with the given minor
+// version
+func (s *Spec) checksumOf(minor uint64) (func([]byte) uint32, error) {
+	for name, value := range s.ChecksumAlgorithms {
+		if uint64(value) != (minor>>1)&3 {
+			continue
+		}
+		switch name {
+		case "crc32-ieee":
+			return crc32.ChecksumIEEE, nil
+		case "crc32c":
+			return func(b []byte) uint32 { return crc32.Checksum(b, castagnoliTable) }, nil
+		case "xxhash64-low32":
+			return func(b []byte) uint32 { return uint32(xxhash.Sum64(b)) }, nil
+		}
+		return nil, fmt.Errorf("checksum algorithm %s is not supported by the validator", name)
+	}
+	return nil, fmt.Errorf("unknown checksum algorithm %d", (minor>>1)&3)
+}
+
+// decodedRecord is a record decoded with the fields of a layout
+type decodedRecord struct {
+	// Values of the integer fields
+	fields map[string]uint64
+	key    []byte
+	// Size of the record, without the checksum
+	size int
+}
+
+// decodeRecord decodes the record at the start of buf, by reading the fields of the layout in order. The key and
+// value sizes are checked against the limits before the key and value are read
+func (s *Spec) decodeRecord(buf []byte, rec *Layout) (decodedRecord, error) {
+	decoded := decodedRecord{fields: map[string]uint64{}}
+	limits := map[string]int{"key_size": s.MaxKeySize, "value_size": s.MaxValueSize}
+	pos := 0
+	for _, f := range rec.Fields {
+		switch {
+		case f.Type == "uvarint":
+			v, n := binary.Uvarint(buf[pos:])
+			if n == 0 {
+				return decoded, errors.New("truncated record header")
+			}
+			if n < 0 || n > binary.MaxVarintLen32 {
+				return decoded, fmt.Errorf("%s is longer than %d bytes", f.Name, binary.MaxVarintLen32)
+			}
+			decoded.fields[f.Name] = v
+			pos += n
+		case f.SizeField != "":
+			size := decoded.fields[f.SizeField]
+			if size > uint64(len(buf)-pos) {
+				return decoded, fmt.Errorf("truncated record, %d bytes left, %s is %d bytes", len(buf), f.Name, size)
+			}
+			if f.Name == "key" {
+				decoded.key = buf[pos : pos+int(size)]
+			}
+			pos += int(size)
+		default:
+			if len(buf) < pos+f.Size {
+				return decoded, errors.New("truncated record header")
+			}
+			decoded.fields[f.Name] = s.uint(buf[pos:], Field{Size: f.Size})
+			pos += f.Size
+		}
+		if limit, ok := limits[f.Name]; ok && decoded.fields[f.Name] > uint64(limit) {
+			return decoded, fmt.Errorf("%s %d is larger than %d", strings.ReplaceAll(f.Name, "_", " "), decoded.fields[f.Name], limit)
+		}
+	}
+	decoded.size = pos
+	return decoded, nil
+}
+
+// ValidateHintFile checks that data, the contents of a hint file, matches the spec. If dataFile (the contents of the
+// data file with the same id) is not nil, every hint must also point to a put record with the same key and sizes. Hint
+// files without a header are validated as hint records without checksums
+func (s *Spec) ValidateHintFile(data []byte, dataFile []byte) (Summary, error) {
+	var summary Summary
+	start, checksumSize, flags, err := s.hintFileHeader(data)
+	if err != nil {
+		return summary, err
+	}
+	logFile := flags&hintFlagLogFile != 0
+	hint := &s.HintRecord
+	if flags&hintFlagValueTypes == 0 {
+		hint = hint.without("value_type")
+	}
+	keySize, valueSize := hint.field("key_size"), hint.field("value_size")
+	valuePos, timestamp := hint.field("value_pos"), hint.field("timestamp")
+	for offset := start; offset < len(data); {
+		buf := data[offset:]
+		if len(buf) < hint.HeaderSize {
+			return summary, invalid(offset, "truncated hint header")
+		}
+		ksize, vsize := s.uint(buf, keySize), s.uint(buf, valueSize)
+		immutable, tombstone := ksize&uint64(s.HintImmutableFlag) != 0, ksize&uint64(s.HintTombstoneFlag) != 0
+		ksize &^= uint64(s.HintImmutableFlag | s.HintTombstoneFlag)
+		if ksize > uint64(s.MaxKeySize) {
+			return summary, invalid(offset, "key size %d is larger than %d", ksize, s.MaxKeySize)
+		}
+		if vsize > uint64(s.MaxValueSize) {
+			return summary, invalid(offset, "value size %d is larger than %d", vsize, s.MaxValueSize)
+		}
+		size := hint.HeaderSize + int(ksize)
+		if len(buf) < size+checksumSize {
+			return summary, invalid(offset, "truncated hint, %d bytes left, hint is %d bytes", len(buf), size+checksumSize)
+		}
+		if checksumSize > 0 {
+			stored := binary.LittleEndian.Uint32(buf[size:])
+			if computed := crc32.Checksum(buf[:size], castagnoliTable); stored != computed {
+				return summary, invalid(offset, "checksum is %08x, expected %08x", stored, computed)
+			}
+		}
+		if tombstone && (!logFile || immutable || vsize != 0) {
+			return summary, invalid(offset, "unexpected tombstone hint")
+		}
+		pos := int64(s.uint(buf, valuePos))
+		if pos < 0 {
+			return summary, invalid(offset, "negative value position %d", pos)
+		}
+		if dataFile != nil {
+			if err := s.checkHintTarget(dataFile, pos, buf[hint.HeaderSize:size], vsize, s.uint(buf, timestamp), immutable, tombstone); err != nil {
+				return summary, invalid(offset, "%s", err)
+			}
+		}
+		summary.Records++
+		if tombstone {
+			summary.Tombstones++
+		}
+		offset += size + checksumSize
+	}
+	return summary, nil
+}
+
+// Bits of the flags of a hint file header that are set for log files, and for hints with the value_type field, see the
+// flags field of HintFileHeader
+const (
+	hintFlagLogFile    = 0x2
+	hintFlagValueTypes = 0x4
+)
+
+// hintFileHeader checks the header of a hint file, and returns the offset of the first hint, the size of the checksums
+// of the hints, and the flags. The offset, size and flags are 0 for hint files without a header
+func (s *Spec) hintFileHeader(data []byte) (int, int, uint64, error) {
+	magic, err := hex.DecodeString(s.HintMagic)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	header := &s.HintFileHeader
+	magicField := header.field("magic")
+	if !bytes.HasPrefix(data, magic) {
+		return 0, 0, 0, nil
+	}
+	if len(data) < header.HeaderSize {
+		return 0, 0, 0, invalid(0, "file is %d bytes, shorter than the %d byte hint file header", len(data), header.HeaderSize)
+	}
+	if version := s.uint(data, header.field("version")); int(version) != s.HintVersion {
+		return 0, 0, 0, invalid(magicField.Size, "hint file version %d is not compatible with %d", version, s.HintVersion)
+	}
+	flags := s.uint(data, header.field("flags"))
+	if flags&^(hintFlagLogFile|hintFlagValueTypes) != 0 {
+		return 0, 0, 0, invalid(header.field("flags").Offset, "flags 0x%02x are not supported by the validator", flags)
+	}
+	return header.HeaderSize, s.HintRecord.Checksum.Size, flags, nil
+}
+
+// checkHintTarget checks that the record at pos in the data file matches the hint
+func (s *Spec) checkHintTarget(dataFile []byte, pos int64, key []byte, vsize, ts uint64, immutable, tombstone bool) error {
+	header := &s.FileHeader
+	if len(dataFile) < header.HeaderSize {
+		return fmt.Errorf("data file is shorter than the file header")
+	}
+	rec := s.recordLayout(s.uint(dataFile, header.field("version_minor")))
+	start := int64(header.HeaderSize) + pos
+	if start >= int64(len(dataFile)) {
+		return fmt.Errorf("value position %d is past the end of the data file", pos)
+	}
+	decoded, err := s.decodeRecord(dataFile[start:], rec)
+	if err != nil {
+		return fmt.Errorf("value position %d is not a valid record: %w", pos, err)
+	}
+	expected := "put"
+	if immutable {
+		expected = "put_immutable"
+	} else if tombstone {
+		expected = "delete"
+	}
+	if int(decoded.fields["record_type"]) != s.RecordTypes[expected] {
+		return fmt.Errorf("value position %d is not a %s record", pos, expected)
+	}
+	if decoded.fields["timestamp"] != ts || decoded.fields["value_size"] != vsize {
+		return fmt.Errorf("timestamp or value size does not match the record at %d", pos)
+	}
+	if !bytes.Equal(decoded.key, key) {
+		return fmt.Errorf("key does not match the record at %d", pos)
+	}
+	return nil
+}
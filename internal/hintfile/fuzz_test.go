@@ -0,0 +1,60 @@
+package hintfile
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/spf13/afero"
+)
+
+// FuzzHintScanner checks that the hint scanner does not panic on any hint file, and that every hint it returns is
+// within the limits a hint file can describe
+func FuzzHintScanner(f *testing.F) {
+	fs := afero.NewMemMapFs()
+	writer, err := NewWriter(fs, "seed.hint")
+	if err != nil {
+		f.Fatal(err)
+	}
+	writer.WriteHintRecord(&HintRecord{Timestamp: time.UnixMicro(1), KeySize: 4, ValueSize: 4, ValuePos: 0, Key: []byte("name")})
+	writer.WriteHintRecord(&HintRecord{Timestamp: time.UnixMicro(2), KeySize: 0, ValueSize: 0, ValuePos: 32, Key: []byte("")})
+	writer.Close()
+	seed, err := afero.ReadFile(fs, "seed.hint")
+	if err != nil {
+		f.Fatal(err)
+	}
+	f.Add(seed)
+	f.Add(seed[:len(seed)-1])
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		fs := afero.NewMemMapFs()
+		if err := afero.WriteFile(fs, "fuzz.hint", data, os.ModePerm); err != nil {
+			t.Fatal(err)
+		}
+		scanner, err := NewScanner(fs, "fuzz.hint")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer scanner.Close()
+		read, checksumSize := HintFileHeaderSize, HintChecksumSize
+		if scanner.Legacy() {
+			read, checksumSize = 0, 0
+		}
+		for {
+			hint, err := scanner.Scan()
+			if err != nil {
+				return
+			}
+			if len(hint.Key) != int(hint.KeySize) {
+				t.Fatalf("key size %d does not match the header %d", len(hint.Key), hint.KeySize)
+			}
+			if hint.ValuePos < 0 {
+				t.Fatalf("negative value position %d", hint.ValuePos)
+			}
+			read += scanner.headerSize + len(hint.Key) + checksumSize
+			if read > len(data) {
+				t.Fatalf("read %d bytes from a %d byte file", read, len(data))
+			}
+		}
+	})
+}
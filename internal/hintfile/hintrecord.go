@@ -1,8 +1,76 @@
 package hintfile
 
-import "time"
+import (
+	"errors"
+	"hash/crc32"
+	"io"
+	"os"
+	"time"
 
-const HintRecordHeaderSize = 24 // 24 bytes
+	"github.com/spf13/afero"
+)
+
+// Size of the hint header, and of the header of hints written before hints had the value type (see FlagValueTypes)
+const (
+	HintRecordHeaderSize            = 25
+	HintRecordHeaderSizeNoValueType = 24
+)
+
+// The highest bit of the key size of a hint is set if the record is a put of a write-once key (a
+// record.RecordTypePutImmutable record), the next bit is set if the record is a tombstone. The key size is in the
+// other 30 bits
+const (
+	KeySizeImmutableFlag = 1 << 31
+	KeySizeTombstoneFlag = 1 << 30
+)
+
+// A hint file starts with a header of HintFileHeaderSize bytes: the magic bytes, the version and the flags. Every hint
+// is followed by a CRC32-C checksum of HintChecksumSize bytes, that covers the hint header and the key
+const (
+	HintFileHeaderSize = 10
+	HintChecksumSize   = 4
+	HintFileVersion    = 1
+)
+
+// Flags of the hint file header
+const (
+	// Set if the keys of the hints are encrypted, see Writer.SetKeyring
+	FlagEncryptedKeys = 1 << 0
+	// Set if the data file was written by Put and Delete (not by a merge), see Writer.SetLogFile
+	FlagLogFile = 1 << 1
+	// Set if the hint header ends with the value type of the record, it's set for every hint file written since hints
+	// had the value type. Older readers reject the files, since they do not know the flag
+	FlagValueTypes = 1 << 2
+)
+
+var hintFileMagicBytes = [...]byte{0x00, 0x6B, 0x76, 0x64, 0x62, 0x48, 0x4E, 0x54}
+
+var ErrHintFileVersionNotCompatible = errors.New("hint file not supported by reader")
+
+var castagnoliTable = crc32.MakeTable(crc32.Castagnoli)
+
+// MagicBytes returns the bytes every hint file (except the ones written before hint files had a header) starts with
+func MagicBytes() []byte {
+	return hintFileMagicBytes[:]
+}
+
+// IsLogFile returns true if the header of the hint file at path has FlagLogFile. Hint files without a header were
+// only written for merged files
+func IsLogFile(fs afero.Fs, path string) (bool, error) {
+	file, err := fs.OpenFile(path, os.O_RDONLY, 0666)
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+	var header [HintFileHeaderSize]byte
+	if _, err := io.ReadFull(file, header[:]); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return false, nil
+		}
+		return false, err
+	}
+	return [8]byte(header[:8]) == hintFileMagicBytes && header[9]&FlagLogFile != 0, nil
+}
 
 type HintRecord struct {
 	Timestamp time.Time
@@ -10,4 +78,10 @@ type HintRecord struct {
 	ValueSize uint32
 	ValuePos  int64
 	Key       []byte
+	// Immutable is true if the record is a put of a write-once key
+	Immutable bool
+	// Tombstone is true if the record is a delete, only the hints of log files have tombstones
+	Tombstone bool
+	// ValueType is the value type of the record header, 0x0 for hints written before hints had it
+	ValueType uint8
 }
@@ -2,75 +2,181 @@ package hintfile
 
 import (
 	"bufio"
+	"bytes"
 	"encoding/binary"
 	"fmt"
+	"hash/crc32"
 	"io"
 	"os"
 	"time"
 
-	"github.com/ananthvk/kvdb/internal/constants"
+	"github.com/ananthvk/kvdb/internal/kverrors"
 	"github.com/ananthvk/kvdb/internal/record"
 	"github.com/spf13/afero"
 )
 
 const readerBufferSize = 4 * 1000 * 1000 // 4 MB
 
+var ErrInvalidValuePos = kverrors.New(kverrors.ErrCorrupt, "hint record has a negative value position")
+
 type Scanner struct {
 	file         afero.File
 	reader       *bufio.Reader
 	sharedBuffer []byte // Buffer to hold hint record header + key
+	headerSize   int
+	limits       record.Limits
+	// The keys are decrypted with keyring into plainBuffer if it's set, see Writer.SetKeyring
+	keyring     *record.Keyring
+	plainBuffer []byte
+	// legacy is true for hint files written before hint files had a header, their hints have no checksum
+	legacy bool
+	flags  byte
 }
 
+// NewScanner opens the hint file at path and reads it's header. A file that does not start with the magic bytes is
+// read as a hint file without a header
 func NewScanner(fs afero.Fs, path string) (*Scanner, error) {
 	file, err := fs.OpenFile(path, os.O_RDONLY, 0666)
 	if err != nil {
 		return nil, err
 	}
-	reader := bufio.NewReaderSize(file, readerBufferSize)
+	scanner := &Scanner{
+		file:         file,
+		reader:       bufio.NewReaderSize(file, readerBufferSize),
+		sharedBuffer: make([]byte, HintRecordHeaderSize),
+		headerSize:   HintRecordHeaderSizeNoValueType,
+		limits:       record.DefaultLimits,
+	}
+	if err := scanner.readHeader(); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return scanner, nil
+}
 
-	// Maximum size of a record (with a little bit extra for safety)
-	const maxRecordSize = HintRecordHeaderSize + constants.MaxKeySize + 32
+// readHeader reads the hint file header, if the file has one
+func (scanner *Scanner) readHeader() error {
+	magic, err := scanner.reader.Peek(len(hintFileMagicBytes))
+	if err != nil && err != io.EOF {
+		return err
+	}
+	if !bytes.Equal(magic, hintFileMagicBytes[:]) {
+		scanner.legacy = true
+		return nil
+	}
+	var header [HintFileHeaderSize]byte
+	if _, err := io.ReadFull(scanner.reader, header[:]); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return fmt.Errorf("could not read hint file header: %w", err)
+	}
+	if header[8] != HintFileVersion || header[9]&^(FlagEncryptedKeys|FlagLogFile|FlagValueTypes) != 0 {
+		return fmt.Errorf("%w: version %d, flags 0x%02x", ErrHintFileVersionNotCompatible, header[8], header[9])
+	}
+	scanner.flags = header[9]
+	if scanner.flags&FlagValueTypes != 0 {
+		scanner.headerSize = HintRecordHeaderSize
+	}
+	return nil
+}
 
-	return &Scanner{
-		file:         file,
-		reader:       reader,
-		sharedBuffer: make([]byte, maxRecordSize),
-	}, nil
+// Legacy returns true if the hint file has no header, its hints have no checksums
+func (scanner *Scanner) Legacy() bool {
+	return scanner.legacy
+}
+
+// LogFile returns true if the hint file has FlagLogFile, i.e. if it's data file was written by Put and Delete
+func (scanner *Scanner) LogFile() bool {
+	return scanner.flags&FlagLogFile != 0
+}
+
+// encrypted returns true if the keys of the hints are encrypted
+func (scanner *Scanner) encrypted() bool {
+	if scanner.legacy {
+		return scanner.keyring != nil
+	}
+	return scanner.flags&FlagEncryptedKeys != 0
+}
+
+// SetLimits sets the largest key and value sizes accepted in a hint record
+func (scanner *Scanner) SetLimits(limits record.Limits) {
+	scanner.limits = limits
+}
+
+// SetKeyring sets the keyring the keys of the hints are decrypted with, if the file header says they are encrypted.
+// The keys of hint files without a header are decrypted if a keyring is set, so for them it must be set if (and only
+// if) the hint file was written with a keyring
+func (scanner *Scanner) SetKeyring(keyring *record.Keyring) {
+	scanner.keyring = keyring
 }
 
 // Returns the next hint record in the file
 func (scanner *Scanner) Scan() (HintRecord, error) {
-	n, err := io.ReadFull(scanner.reader, scanner.sharedBuffer[0:HintRecordHeaderSize])
+	n, err := io.ReadFull(scanner.reader, scanner.sharedBuffer[0:scanner.headerSize])
 	if err != nil {
 		return HintRecord{}, err
 	}
-	if n != HintRecordHeaderSize {
-		return HintRecord{}, fmt.Errorf("expected to read %d bytes, got %d", HintRecordHeaderSize, n)
+	if n != scanner.headerSize {
+		return HintRecord{}, fmt.Errorf("expected to read %d bytes, got %d", scanner.headerSize, n)
 	}
 
 	// Process the hintRecord
 	hintRecord := HintRecord{}
 	hintRecord.Timestamp = time.UnixMicro(int64(binary.LittleEndian.Uint64(scanner.sharedBuffer[0:])))
 	hintRecord.KeySize = binary.LittleEndian.Uint32(scanner.sharedBuffer[8:])
+	hintRecord.Immutable = hintRecord.KeySize&KeySizeImmutableFlag != 0
+	hintRecord.Tombstone = hintRecord.KeySize&KeySizeTombstoneFlag != 0
+	hintRecord.KeySize &^= KeySizeImmutableFlag | KeySizeTombstoneFlag
 	hintRecord.ValueSize = binary.LittleEndian.Uint32(scanner.sharedBuffer[12:])
 	hintRecord.ValuePos = int64(binary.LittleEndian.Uint64(scanner.sharedBuffer[16:]))
+	if scanner.headerSize == HintRecordHeaderSize {
+		hintRecord.ValueType = scanner.sharedBuffer[24]
+	}
 
 	// Check if key / value size are within the set maximum values
 	// This is to detect corruption to header (i.e. if the size gets corrupted and it becomes a very huge value)
-	if hintRecord.KeySize > constants.MaxKeySize {
-		return HintRecord{}, record.ErrKeyTooLarge
+	if err := scanner.limits.Check(hintRecord.KeySize, hintRecord.ValueSize); err != nil {
+		return HintRecord{}, err
 	}
-	if hintRecord.ValueSize > constants.MaxValueSize {
-		return HintRecord{}, record.ErrValueTooLarge
+	if hintRecord.ValuePos < 0 {
+		return HintRecord{}, ErrInvalidValuePos
 	}
 
-	keyStart := int(HintRecordHeaderSize)
+	keyStart := scanner.headerSize
 	keyEnd := keyStart + int(hintRecord.KeySize)
+	encrypted := scanner.encrypted()
+	if encrypted {
+		keyEnd += record.EncryptionOverhead
+	}
+	end := keyEnd
+	if !scanner.legacy {
+		end += HintChecksumSize
+	}
+	if len(scanner.sharedBuffer) < end {
+		scanner.sharedBuffer = append(scanner.sharedBuffer, make([]byte, end-len(scanner.sharedBuffer))...)
+	}
 	hintRecord.Key = scanner.sharedBuffer[keyStart:keyEnd]
 
-	if _, err = io.ReadFull(scanner.reader, hintRecord.Key); err != nil {
+	if _, err = io.ReadFull(scanner.reader, scanner.sharedBuffer[keyStart:end]); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
 		return HintRecord{}, err
 	}
+	if !scanner.legacy {
+		stored := binary.LittleEndian.Uint32(scanner.sharedBuffer[keyEnd:])
+		if crc32.Checksum(scanner.sharedBuffer[:keyEnd], castagnoliTable) != stored {
+			return HintRecord{}, record.ErrCrcChecksumMismatch
+		}
+	}
+	if encrypted {
+		scanner.plainBuffer, err = scanner.keyring.Open(scanner.plainBuffer[:0], hintRecord.Key, scanner.sharedBuffer[:keyStart])
+		if err != nil {
+			return HintRecord{}, err
+		}
+		hintRecord.Key = scanner.plainBuffer
+	}
 
 	return hintRecord, nil
 }
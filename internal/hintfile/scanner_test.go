@@ -0,0 +1,184 @@
+package hintfile
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/ananthvk/kvdb/internal/record"
+	"github.com/spf13/afero"
+)
+
+var testHints = []HintRecord{
+	{Timestamp: time.UnixMicro(1), KeySize: 4, ValueSize: 4, ValuePos: 0, Key: []byte("name")},
+	{Timestamp: time.UnixMicro(2), KeySize: 0, ValueSize: 0, ValuePos: 32, Key: []byte(""), Immutable: true},
+}
+
+// writeTestHints writes testHints to path, and returns the contents of the file
+func writeTestHints(t *testing.T, fs afero.Fs, path string) []byte {
+	t.Helper()
+	writer, err := NewWriter(fs, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range testHints {
+		if err := writer.WriteHintRecord(&testHints[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	data, err := afero.ReadFile(fs, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// scanAll returns the hints of the hint file with the given contents, and the error that stopped the scan (nil at the
+// end of the file)
+func scanAll(t *testing.T, data []byte) ([]HintRecord, error) {
+	t.Helper()
+	fs := afero.NewMemMapFs()
+	afero.WriteFile(fs, "test.hint", data, os.ModePerm)
+	scanner, err := NewScanner(fs, "test.hint")
+	if err != nil {
+		return nil, err
+	}
+	defer scanner.Close()
+	var hints []HintRecord
+	for {
+		hint, err := scanner.Scan()
+		if err == io.EOF {
+			return hints, nil
+		}
+		if err != nil {
+			return hints, err
+		}
+		hint.Key = append([]byte(nil), hint.Key...)
+		hints = append(hints, hint)
+	}
+}
+
+func checkTestHints(t *testing.T, hints []HintRecord) {
+	t.Helper()
+	if len(hints) != len(testHints) {
+		t.Fatalf("expected %d hints, got %d", len(testHints), len(hints))
+	}
+	for i, hint := range hints {
+		want := testHints[i]
+		if string(hint.Key) != string(want.Key) || hint.ValuePos != want.ValuePos || hint.ValueSize != want.ValueSize ||
+			hint.Immutable != want.Immutable || !hint.Timestamp.Equal(want.Timestamp) || hint.ValueType != want.ValueType {
+			t.Errorf("hint %d: expected %+v, got %+v", i, want, hint)
+		}
+	}
+}
+
+func TestScannerChecksums(t *testing.T) {
+	data := writeTestHints(t, afero.NewMemMapFs(), "test.hint")
+	hints, err := scanAll(t, data)
+	if err != nil {
+		t.Fatalf("scan failed: %v", err)
+	}
+	checkTestHints(t, hints)
+
+	// A key byte of the first hint
+	damaged := append([]byte(nil), data...)
+	damaged[HintFileHeaderSize+HintRecordHeaderSize]++
+	if _, err := scanAll(t, damaged); !errors.Is(err, record.ErrCrcChecksumMismatch) {
+		t.Errorf("expected ErrCrcChecksumMismatch for a damaged key, got %v", err)
+	}
+	// A hint without it's checksum, or a part of the checksum, is not the end of the file
+	for _, size := range []int{len(data) - HintChecksumSize, len(data) - 1} {
+		if _, err := scanAll(t, data[:size]); !errors.Is(err, io.ErrUnexpectedEOF) {
+			t.Errorf("%d bytes: expected io.ErrUnexpectedEOF for a truncated hint, got %v", size, err)
+		}
+	}
+
+	damaged = append([]byte(nil), data...)
+	damaged[8] = HintFileVersion + 1
+	if _, err := scanAll(t, damaged); !errors.Is(err, ErrHintFileVersionNotCompatible) {
+		t.Errorf("expected ErrHintFileVersionNotCompatible, got %v", err)
+	}
+	if _, err := scanAll(t, data[:HintFileHeaderSize-1]); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("expected io.ErrUnexpectedEOF for a truncated header, got %v", err)
+	}
+}
+
+func TestScannerLegacyHintFile(t *testing.T) {
+	// Hint files written before the header have the hints without checksums
+	var data []byte
+	for _, hint := range testHints {
+		keySize := hint.KeySize
+		if hint.Immutable {
+			keySize |= KeySizeImmutableFlag
+		}
+		data = binary.LittleEndian.AppendUint64(data, uint64(hint.Timestamp.UnixMicro()))
+		data = binary.LittleEndian.AppendUint32(data, keySize)
+		data = binary.LittleEndian.AppendUint32(data, hint.ValueSize)
+		data = binary.LittleEndian.AppendUint64(data, uint64(hint.ValuePos))
+		data = append(data, hint.Key...)
+	}
+	hints, err := scanAll(t, data)
+	if err != nil {
+		t.Fatalf("scan failed: %v", err)
+	}
+	checkTestHints(t, hints)
+}
+
+func TestScannerValueTypes(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	writer, err := NewWriter(fs, "test.hint")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for valueType := range uint8(4) {
+		hint := &HintRecord{Timestamp: time.UnixMicro(1), KeySize: 1, ValueSize: 1, Key: []byte{'a' + valueType}, ValueType: valueType}
+		if err := writer.WriteHintRecord(hint); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	data, err := afero.ReadFile(fs, "test.hint")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data[9]&FlagValueTypes == 0 {
+		t.Errorf("expected FlagValueTypes to be set")
+	}
+	hints, err := scanAll(t, data)
+	if err != nil || len(hints) != 4 {
+		t.Fatalf("expected 4 hints, got %d, %v", len(hints), err)
+	}
+	for i, hint := range hints {
+		if hint.ValueType != uint8(i) || hint.Key[0] != 'a'+uint8(i) {
+			t.Errorf("hint %d: unexpected hint %+v", i, hint)
+		}
+	}
+
+	// A hint file written before hints had the value type, it's hints end at the value pos
+	data = append(MagicBytes(), HintFileVersion, 0)
+	hint := binary.LittleEndian.AppendUint64(nil, 5)
+	hint = binary.LittleEndian.AppendUint32(hint, 3)
+	hint = binary.LittleEndian.AppendUint32(hint, 7)
+	hint = binary.LittleEndian.AppendUint64(hint, 19)
+	hint = append(hint, "key"...)
+	data = append(data, hint...)
+	data = binary.LittleEndian.AppendUint32(data, crc32.Checksum(hint, castagnoliTable))
+
+	hints, err = scanAll(t, data)
+	if err != nil {
+		t.Fatalf("scan failed: %v", err)
+	}
+	if len(hints) != 1 || string(hints[0].Key) != "key" || hints[0].ValueSize != 7 || hints[0].ValuePos != 19 ||
+		hints[0].ValueType != 0 {
+		t.Errorf("unexpected hints %+v", hints)
+	}
+}
@@ -3,9 +3,9 @@ package hintfile
 import (
 	"bufio"
 	"encoding/binary"
+	"hash/crc32"
 	"os"
 
-	"github.com/ananthvk/kvdb/internal/constants"
 	"github.com/ananthvk/kvdb/internal/record"
 	"github.com/spf13/afero"
 )
@@ -21,11 +21,23 @@ Then, during the rename phase, when `merge-1` is renamed to `000....X.dat`, also
 
 During startup, before loading a data file, check if a corresponding hint file exists in the `hints/` directory, if it exists, directly read from it to update keydir
 
-Note: this implementation does not detect corruption due to disk issues, i.e. if the hints file gets corrupted due to the drive, or some other external program, it cannot detect it
-*/
+A hint file starts with a header (magic bytes, version and flags), and every hint is followed by a CRC32-C checksum of
+the hint, so a hint file that was corrupted by the drive or some other program is detected when it's read. The
+datastore then builds the keydir of the data file by scanning it, as if there was no hint file:
+
+	header: magic (8) | version (1) | flags (1)
+	hint:   timestamp (8) | key size (4) | value size (4) | value pos (8) | value type (1) | key | checksum (4)
+
+The value type is the one of the record header, so that the keydir has the types of the keys without reading their
+values. Hint files written before it was added do not have FlagValueTypes, their hints end at the value pos
+
+Merges write hint files for the data files they write, which only have live puts. Sealed data files written by Put and
+Delete get a hint file too (see DataStore.GenerateHints), it has FlagLogFile set, and a hint for every record of the
+data file in order, tombstones included (their key size has KeySizeTombstoneFlag), so that reading the hints gives the
+same keydir as scanning the data file
 
-// Note: Hint file do not have any header, and are just raw records written to a file
-// TODO: Later implement a header for hinit file too
+Hint files written before the header existed start with a hint, they are still read (without checksums), see Scanner
+*/
 
 const writerBufferSize = 4 * 1000 * 1000 // 4 MB
 
@@ -33,55 +45,135 @@ type Writer struct {
 	file   afero.File
 	writer *bufio.Writer
 	buf    [HintRecordHeaderSize]byte
+	limits record.Limits
+	// The keys are encrypted with the current key of keyring if it's set, see SetKeyring
+	keyring *record.Keyring
+	sealed  []byte
+	// The header is written before the first hint, once the keyring is known
+	headerWritten bool
+	logFile       bool
 }
 
+// NewWriter creates the hint file at path, an existing file is truncated
 func NewWriter(fs afero.Fs, path string) (*Writer, error) {
-	file, err := fs.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0666)
+	file, err := fs.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0666)
 	if err != nil {
 		return nil, err
 	}
-	// Write magic bytes
 
 	return &Writer{
 		file:   file,
 		writer: bufio.NewWriterSize(file, writerBufferSize),
+		limits: record.DefaultLimits,
 	}, nil
 }
 
+// SetLimits sets the largest key and value sizes of the hints that are written
+func (w *Writer) SetLimits(limits record.Limits) {
+	w.limits = limits
+}
+
+// SetKeyring makes the writer encrypt the keys of the hints with the current key of keyring, if it has one. It's set
+// for the hint files of encrypted data files, so that keys are not in the clear in either. An encrypted key is stored
+// as the nonce, followed by it's ciphertext and tag (record.EncryptionOverhead bytes more than the key, the key size
+// of the hint is the size of the plaintext key), and the hint header is authenticated along with it. It must be called
+// before the first hint is written, the flags of the file header say whether the keys are encrypted
+func (w *Writer) SetKeyring(keyring *record.Keyring) {
+	if keyring.CanEncrypt() {
+		w.keyring = keyring
+	}
+}
+
+// SetLogFile marks the hint file as the hint file of a data file that was written by Put and Delete, whose hints are
+// every record of the file in order, tombstones included (see FlagLogFile). It must be called before the first hint
+// is written
+func (w *Writer) SetLogFile() {
+	w.logFile = true
+}
+
+// writeHeader writes the hint file header if it was not written yet
+func (w *Writer) writeHeader() error {
+	if w.headerWritten {
+		return nil
+	}
+	var header [HintFileHeaderSize]byte
+	copy(header[:], hintFileMagicBytes[:])
+	header[8] = HintFileVersion
+	header[9] = FlagValueTypes
+	if w.keyring != nil {
+		header[9] |= FlagEncryptedKeys
+	}
+	if w.logFile {
+		header[9] |= FlagLogFile
+	}
+	if _, err := w.writer.Write(header[:]); err != nil {
+		return err
+	}
+	w.headerWritten = true
+	return nil
+}
+
 // WriteHintRecord writes the hint to the given file
 func (w *Writer) WriteHintRecord(h *HintRecord) error {
-	if int(h.KeySize) > constants.MaxKeySize {
+	if err := w.writeHeader(); err != nil {
+		return err
+	}
+	if err := w.limits.Check(h.KeySize, h.ValueSize); err != nil {
+		return err
+	}
+	if h.KeySize&(KeySizeImmutableFlag|KeySizeTombstoneFlag) != 0 {
 		return record.ErrKeyTooLarge
 	}
-	if int(h.ValueSize) > constants.MaxValueSize {
-		return record.ErrValueTooLarge
+	keySize := h.KeySize
+	if h.Immutable {
+		keySize |= KeySizeImmutableFlag
+	}
+	if h.Tombstone {
+		keySize |= KeySizeTombstoneFlag
 	}
 
 	binary.LittleEndian.PutUint64(w.buf[0:], uint64(h.Timestamp.UnixMicro()))
-	binary.LittleEndian.PutUint32(w.buf[8:], h.KeySize)
+	binary.LittleEndian.PutUint32(w.buf[8:], keySize)
 	binary.LittleEndian.PutUint32(w.buf[12:], h.ValueSize)
 	binary.LittleEndian.PutUint64(w.buf[16:], uint64(h.ValuePos))
+	w.buf[24] = h.ValueType
 
 	// Write the hint header
 	if _, err := w.writer.Write(w.buf[:]); err != nil {
 		return err
 	}
 
+	key := h.Key
+	if w.keyring != nil {
+		w.sealed = w.keyring.Seal(w.sealed[:0], h.Key, w.buf[:])
+		key = w.sealed
+	}
 	// Write the hint value
-	if _, err := w.writer.Write(h.Key); err != nil {
+	if _, err := w.writer.Write(key); err != nil {
 		return err
 	}
-	return nil
+
+	var checksum [HintChecksumSize]byte
+	binary.LittleEndian.PutUint32(checksum[:], crc32.Update(crc32.Checksum(w.buf[:], castagnoliTable), castagnoliTable, key))
+	_, err := w.writer.Write(checksum[:])
+	return err
 }
 
 // Sync flushes any buffered data to the underlying file. It calls sync() on the file
 func (w *Writer) Sync() error {
+	if err := w.writeHeader(); err != nil {
+		return err
+	}
 	w.writer.Flush()
 	return w.file.Sync()
 }
 
 // Close closes the underlying file, it also writes any pending changes and syncs the changes to the disk
 func (w *Writer) Close() error {
+	if err := w.writeHeader(); err != nil {
+		w.file.Close()
+		return err
+	}
 	w.writer.Flush()
 	w.writer = nil
 	if err := w.file.Sync(); err != nil {
@@ -0,0 +1,156 @@
+package jsonpointer
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+/*
+jsonpointer implements a subset of RFC 6901 (JSON Pointer) over documents decoded with encoding/json into `any`,
+i.e. documents made up of map[string]any, []any and scalar values.
+
+A pointer is either the empty string (the whole document), or a sequence of `/` prefixed reference tokens, for example
+`/users/0/name`. Within a token, `~1` is decoded as `/` and `~0` is decoded as `~`. When setting a value in an array, the
+special token `-` refers to the position after the last element (i.e. an append)
+*/
+
+var (
+	ErrInvalidPointer = errors.New("invalid json pointer")
+	ErrPathNotFound   = errors.New("json path not found")
+)
+
+// Parse splits the pointer into a list of unescaped reference tokens
+func Parse(pointer string) ([]string, error) {
+	if pointer == "" {
+		return nil, nil
+	}
+	if pointer[0] != '/' {
+		return nil, ErrInvalidPointer
+	}
+	tokens := strings.Split(pointer[1:], "/")
+	for i, token := range tokens {
+		tokens[i] = strings.ReplaceAll(strings.ReplaceAll(token, "~1", "/"), "~0", "~")
+	}
+	return tokens, nil
+}
+
+// Get returns the value that the pointer refers to in the document
+func Get(doc any, pointer string) (any, error) {
+	tokens, err := Parse(pointer)
+	if err != nil {
+		return nil, err
+	}
+	current := doc
+	for _, token := range tokens {
+		current, err = child(current, token)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return current, nil
+}
+
+// Set sets the value that the pointer refers to, and returns the updated document. All parents of the target
+// must already exist. Setting the empty pointer replaces the whole document
+func Set(doc any, pointer string, value any) (any, error) {
+	tokens, err := Parse(pointer)
+	if err != nil {
+		return nil, err
+	}
+	if len(tokens) == 0 {
+		return value, nil
+	}
+	parent := doc
+	for _, token := range tokens[:len(tokens)-1] {
+		parent, err = child(parent, token)
+		if err != nil {
+			return nil, err
+		}
+	}
+	last := tokens[len(tokens)-1]
+	switch p := parent.(type) {
+	case map[string]any:
+		p[last] = value
+		return doc, nil
+	case []any:
+		if last == "-" {
+			// Appending may reallocate the slice, so the new slice has to be written back into the grandparent
+			return Set(doc, pointer[:strings.LastIndexByte(pointer, '/')], append(p, value))
+		}
+		idx, err := arrayIndex(p, last)
+		if err != nil {
+			return nil, err
+		}
+		p[idx] = value
+		return doc, nil
+	}
+	return nil, ErrPathNotFound
+}
+
+// Delete removes the value that the pointer refers to, and returns the updated document. It returns ErrPathNotFound
+// if there is no value at the pointer. Deleting the empty pointer results in a nil document
+func Delete(doc any, pointer string) (any, error) {
+	tokens, err := Parse(pointer)
+	if err != nil {
+		return nil, err
+	}
+	if len(tokens) == 0 {
+		return nil, nil
+	}
+	parent := doc
+	for _, token := range tokens[:len(tokens)-1] {
+		parent, err = child(parent, token)
+		if err != nil {
+			return nil, err
+		}
+	}
+	last := tokens[len(tokens)-1]
+	switch p := parent.(type) {
+	case map[string]any:
+		if _, ok := p[last]; !ok {
+			return nil, ErrPathNotFound
+		}
+		delete(p, last)
+		return doc, nil
+	case []any:
+		idx, err := arrayIndex(p, last)
+		if err != nil {
+			return nil, err
+		}
+		removed := append(p[:idx:idx], p[idx+1:]...)
+		return Set(doc, pointer[:strings.LastIndexByte(pointer, '/')], removed)
+	}
+	return nil, ErrPathNotFound
+}
+
+// child returns the member / element referred to by token in the given container
+func child(container any, token string) (any, error) {
+	switch c := container.(type) {
+	case map[string]any:
+		value, ok := c[token]
+		if !ok {
+			return nil, ErrPathNotFound
+		}
+		return value, nil
+	case []any:
+		idx, err := arrayIndex(c, token)
+		if err != nil {
+			return nil, err
+		}
+		return c[idx], nil
+	}
+	return nil, ErrPathNotFound
+}
+
+func arrayIndex(array []any, token string) (int, error) {
+	// RFC 6901 does not allow leading zeros or signs in array indices
+	if token == "" || (len(token) > 1 && token[0] == '0') || token[0] == '+' || token[0] == '-' {
+		return 0, ErrPathNotFound
+	}
+	idx, err := strconv.Atoi(token)
+	if err != nil || idx >= len(array) {
+		return 0, ErrPathNotFound
+	}
+	return idx, nil
+}
@@ -0,0 +1,140 @@
+package jsonpointer
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+func decode(t *testing.T, s string) any {
+	t.Helper()
+	var doc any
+	if err := json.Unmarshal([]byte(s), &doc); err != nil {
+		t.Fatalf("invalid test document: %v", err)
+	}
+	return doc
+}
+
+func encode(t *testing.T, doc any) string {
+	t.Helper()
+	data, err := json.Marshal(doc)
+	if err != nil {
+		t.Fatalf("could not encode document: %v", err)
+	}
+	return string(data)
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		pointer  string
+		expected []string
+		err      error
+	}{
+		{"", nil, nil},
+		{"/", []string{""}, nil},
+		{"/a/b", []string{"a", "b"}, nil},
+		{"/a~1b/c~0d", []string{"a/b", "c~d"}, nil},
+		{"/~01", []string{"~1"}, nil},
+		{"a/b", nil, ErrInvalidPointer},
+	}
+	for _, tt := range tests {
+		tokens, err := Parse(tt.pointer)
+		if !errors.Is(err, tt.err) {
+			t.Errorf("Parse(%q): expected error %v, got %v", tt.pointer, tt.err, err)
+			continue
+		}
+		if len(tokens) != len(tt.expected) {
+			t.Errorf("Parse(%q): expected %v, got %v", tt.pointer, tt.expected, tokens)
+			continue
+		}
+		for i := range tokens {
+			if tokens[i] != tt.expected[i] {
+				t.Errorf("Parse(%q): expected %v, got %v", tt.pointer, tt.expected, tokens)
+			}
+		}
+	}
+}
+
+func TestGet(t *testing.T) {
+	doc := decode(t, `{"a":{"b":[10,20,{"c":"d"}]},"x/y":1}`)
+	tests := []struct {
+		pointer  string
+		expected string
+		err      error
+	}{
+		{"", `{"a":{"b":[10,20,{"c":"d"}]},"x/y":1}`, nil},
+		{"/a/b/1", `20`, nil},
+		{"/a/b/2/c", `"d"`, nil},
+		{"/x~1y", `1`, nil},
+		{"/a/b/3", "", ErrPathNotFound},
+		{"/a/b/01", "", ErrPathNotFound},
+		{"/a/b/-", "", ErrPathNotFound},
+		{"/missing", "", ErrPathNotFound},
+		{"/a/b/0/c", "", ErrPathNotFound},
+	}
+	for _, tt := range tests {
+		value, err := Get(doc, tt.pointer)
+		if !errors.Is(err, tt.err) {
+			t.Errorf("Get(%q): expected error %v, got %v", tt.pointer, tt.err, err)
+			continue
+		}
+		if err == nil && encode(t, value) != tt.expected {
+			t.Errorf("Get(%q): expected %s, got %s", tt.pointer, tt.expected, encode(t, value))
+		}
+	}
+}
+
+func TestSet(t *testing.T) {
+	tests := []struct {
+		doc      string
+		pointer  string
+		value    any
+		expected string
+		err      error
+	}{
+		{`{"a":1}`, "/a", 2.0, `{"a":2}`, nil},
+		{`{"a":1}`, "/b", "x", `{"a":1,"b":"x"}`, nil},
+		{`{"a":[1,2]}`, "/a/0", 5.0, `{"a":[5,2]}`, nil},
+		{`{"a":[1,2]}`, "/a/-", 3.0, `{"a":[1,2,3]}`, nil},
+		{`[1]`, "/-", 2.0, `[1,2]`, nil},
+		{`{"a":1}`, "", "root", `"root"`, nil},
+		{`{"a":1}`, "/b/c", 1.0, "", ErrPathNotFound},
+		{`{"a":[1]}`, "/a/5", 1.0, "", ErrPathNotFound},
+	}
+	for _, tt := range tests {
+		doc, err := Set(decode(t, tt.doc), tt.pointer, tt.value)
+		if !errors.Is(err, tt.err) {
+			t.Errorf("Set(%s, %q): expected error %v, got %v", tt.doc, tt.pointer, tt.err, err)
+			continue
+		}
+		if err == nil && encode(t, doc) != tt.expected {
+			t.Errorf("Set(%s, %q): expected %s, got %s", tt.doc, tt.pointer, tt.expected, encode(t, doc))
+		}
+	}
+}
+
+func TestDelete(t *testing.T) {
+	tests := []struct {
+		doc      string
+		pointer  string
+		expected string
+		err      error
+	}{
+		{`{"a":1,"b":2}`, "/a", `{"b":2}`, nil},
+		{`{"a":[1,2,3]}`, "/a/1", `{"a":[1,3]}`, nil},
+		{`{"a":{"b":{"c":1}}}`, "/a/b/c", `{"a":{"b":{}}}`, nil},
+		{`{"a":1}`, "", `null`, nil},
+		{`{"a":1}`, "/b", "", ErrPathNotFound},
+		{`{"a":[1]}`, "/a/1", "", ErrPathNotFound},
+	}
+	for _, tt := range tests {
+		doc, err := Delete(decode(t, tt.doc), tt.pointer)
+		if !errors.Is(err, tt.err) {
+			t.Errorf("Delete(%s, %q): expected error %v, got %v", tt.doc, tt.pointer, tt.err, err)
+			continue
+		}
+		if err == nil && encode(t, doc) != tt.expected {
+			t.Errorf("Delete(%s, %q): expected %s, got %s", tt.doc, tt.pointer, tt.expected, encode(t, doc))
+		}
+	}
+}
@@ -1,10 +1,17 @@
 package keydir
 
-import "time"
+import (
+	"time"
+	"unsafe"
+)
 
 type KeydirRecord struct {
 	FileId    int
 	ValueSize uint32
+	// Immutable is true for write-once keys, which can't be overwritten or deleted
+	Immutable bool
+	// ValueType is the value type of the record header, 0x0 if it's not known (the record was loaded from a hint file)
+	ValueType uint8
 	// ValuePos is the offset to the start of the record (and not to the start of the value)
 	ValuePos  int64
 	Timestamp time.Time
@@ -12,8 +19,22 @@ type KeydirRecord struct {
 
 type Keydir struct {
 	mp map[string]KeydirRecord
+	// Total size of all keys in bytes, used to estimate the memory usage
+	keyBytes int64
+	// Incremented every time a key is added or removed (but not when an existing key is updated)
+	generation uint64
+	// Called with the previous record of a key when it's replaced or deleted, may be nil
+	onRemove func(KeydirRecord)
+	// Bytes taken by the records that the keydir points to in every data file, by file id. Only tracked once
+	// TrackLiveBytes has been called, recordSize returns the size of a record in it's data file
+	liveBytes  map[int]int64
+	recordSize func(fileId int, keySize int, valueSize uint32) int64
 }
 
+// Estimated memory used by each entry in addition to the key bytes, i.e. the string header, the record and
+// the overhead of the map (hash bucket slot, tophash & load factor)
+const entryOverhead = int64(unsafe.Sizeof("")) + int64(unsafe.Sizeof(KeydirRecord{})) + 24
+
 // NewKeydir initializes a new Keydir
 func NewKeydir() *Keydir {
 	return &Keydir{
@@ -21,21 +42,44 @@ func NewKeydir() *Keydir {
 	}
 }
 
+// SetOnRemove sets a function that's called with the previous record of a key every time the key is replaced with a
+// newer record, or deleted. It's called synchronously, while the caller of the keydir holds it's lock
+func (k *Keydir) SetOnRemove(fn func(KeydirRecord)) {
+	k.onRemove = fn
+}
+
 // AddKeydirRecord adds a new KeydirRecord. If the timestamp is before the timestamp of an existing key, the update is ignored
 func (k *Keydir) AddKeydirRecord(key []byte, fileId int, valueSize uint32, valuePos int64, timestamp time.Time) {
+	k.Add(key, KeydirRecord{
+		FileId:    fileId,
+		ValueSize: valueSize,
+		ValuePos:  valuePos,
+		Timestamp: timestamp,
+	})
+}
+
+// Add is like AddKeydirRecord, but takes the whole record. A write-once key is only replaced by a record with the same
+// timestamp, i.e. the same write moved to another file by a merge
+func (k *Keydir) Add(key []byte, record KeydirRecord) {
 	// Ignore stale updates
 	keyStr := string(key)
 	if existing, ok := k.mp[keyStr]; ok {
-		if timestamp.Before(existing.Timestamp) {
+		if record.Timestamp.Before(existing.Timestamp) {
 			return
 		}
+		if existing.Immutable && !record.Timestamp.Equal(existing.Timestamp) {
+			return
+		}
+		if k.onRemove != nil {
+			k.onRemove(existing)
+		}
+		k.addLiveBytes(existing.FileId, len(key), existing.ValueSize, -1)
+	} else {
+		k.keyBytes += int64(len(key))
+		k.generation++
 	}
-	k.mp[keyStr] = KeydirRecord{
-		FileId:    fileId,
-		ValueSize: valueSize,
-		ValuePos:  valuePos,
-		Timestamp: timestamp,
-	}
+	k.mp[keyStr] = record
+	k.addLiveBytes(record.FileId, len(key), record.ValueSize, 1)
 }
 
 // GetKeydirRecord retrieves a KeydirRecord by key
@@ -45,12 +89,20 @@ func (k *Keydir) GetKeydirRecord(key []byte) (KeydirRecord, bool) {
 }
 
 func (k *Keydir) DeleteRecord(key []byte) {
-	delete(k.mp, string(key))
+	k.DeleteRecordWithExists(key)
 }
 
 // Returns true if the key existed before deletion
 func (k *Keydir) DeleteRecordWithExists(key []byte) bool {
-	_, ok := k.mp[string(key)]
+	existing, ok := k.mp[string(key)]
+	if ok {
+		k.keyBytes -= int64(len(key))
+		k.generation++
+		if k.onRemove != nil {
+			k.onRemove(existing)
+		}
+		k.addLiveBytes(existing.FileId, len(key), existing.ValueSize, -1)
+	}
 	delete(k.mp, string(key))
 	return ok
 }
@@ -64,6 +116,89 @@ func (k *Keydir) GetAllKeys() []string {
 	return keys
 }
 
+// Range calls fn for every record in the Keydir, in no particular order, until fn returns false. The Keydir must not be
+// modified by fn
+func (k *Keydir) Range(fn func(key string, record KeydirRecord) bool) {
+	for key, record := range k.mp {
+		if !fn(key, record) {
+			return
+		}
+	}
+}
+
+// RandomKey returns a key of the Keydir, or false if it's empty. It takes the first key of an iteration of the map, which
+// starts at a random position, so it does not copy the keys, but keys are not picked with exactly the same probability
+func (k *Keydir) RandomKey() (string, bool) {
+	for key := range k.mp {
+		return key, true
+	}
+	return "", false
+}
+
+// Generation returns a counter that changes whenever the set of keys changes, it can be used to tell if a copy of the
+// keys is still current
+func (k *Keydir) Generation() uint64 {
+	return k.generation
+}
+
 func (k *Keydir) Size() int {
 	return len(k.mp)
 }
+
+// DeleteRecordIfNotNewer deletes the key, unless the existing record is newer than the given timestamp
+// (i.e. a tombstone older than the current value is ignored). Write-once keys are never deleted. Returns true if the key
+// was deleted
+func (k *Keydir) DeleteRecordIfNotNewer(key []byte, timestamp time.Time) bool {
+	existing, ok := k.mp[string(key)]
+	if !ok || existing.Immutable || existing.Timestamp.After(timestamp) {
+		return false
+	}
+	return k.DeleteRecordWithExists(key)
+}
+
+// EntryMemoryUsage returns an estimate of the memory used by an entry with a key of keySize bytes
+func EntryMemoryUsage(keySize int) int64 {
+	return int64(keySize) + entryOverhead
+}
+
+// MemoryUsage returns an estimate of the memory used by the Keydir in bytes
+func (k *Keydir) MemoryUsage() int64 {
+	return k.keyBytes + int64(len(k.mp))*entryOverhead
+}
+
+// TrackLiveBytes starts tracking the bytes taken by the records that the keydir points to in every data file, recordSize
+// returns the size of a record with the given key and value sizes in the data file. The records of the existing keys
+// are counted right away, and the counts are then kept up to date as keys are added, replaced and deleted
+func (k *Keydir) TrackLiveBytes(recordSize func(fileId int, keySize int, valueSize uint32) int64) {
+	k.recordSize = recordSize
+	k.liveBytes = map[int]int64{}
+	for key, record := range k.mp {
+		k.addLiveBytes(record.FileId, len(key), record.ValueSize, 1)
+	}
+}
+
+// LiveBytes returns the bytes taken by the records that the keydir points to in every data file, by file id. Files
+// without live records are left out. It returns nil unless TrackLiveBytes was called
+func (k *Keydir) LiveBytes() map[int]int64 {
+	if k.liveBytes == nil {
+		return nil
+	}
+	live := make(map[int]int64, len(k.liveBytes))
+	for id, bytes := range k.liveBytes {
+		live[id] = bytes
+	}
+	return live
+}
+
+// addLiveBytes adds (sign 1) or removes (sign -1) a record from the live bytes of it's file
+func (k *Keydir) addLiveBytes(fileId int, keySize int, valueSize uint32, sign int64) {
+	if k.recordSize == nil {
+		return
+	}
+	bytes := k.liveBytes[fileId] + sign*k.recordSize(fileId, keySize, valueSize)
+	if bytes == 0 {
+		delete(k.liveBytes, fileId)
+		return
+	}
+	k.liveBytes[fileId] = bytes
+}
@@ -0,0 +1,171 @@
+package keydir
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMemoryUsage(t *testing.T) {
+	kd := NewKeydir()
+	if kd.MemoryUsage() != 0 {
+		t.Fatalf("expected empty keydir to use 0 bytes, got %d", kd.MemoryUsage())
+	}
+
+	now := time.Now()
+	kd.AddKeydirRecord([]byte("key1"), 1, 10, 0, now)
+	kd.AddKeydirRecord([]byte("key22"), 1, 10, 50, now)
+	expected := int64(len("key1")+len("key22")) + 2*entryOverhead
+	if kd.MemoryUsage() != expected {
+		t.Errorf("expected %d bytes, got %d", expected, kd.MemoryUsage())
+	}
+
+	// Updating an existing key does not change the usage
+	kd.AddKeydirRecord([]byte("key1"), 2, 20, 0, now.Add(time.Second))
+	if kd.MemoryUsage() != expected {
+		t.Errorf("expected %d bytes after update, got %d", expected, kd.MemoryUsage())
+	}
+
+	kd.DeleteRecord([]byte("key22"))
+	kd.DeleteRecord([]byte("missing"))
+	expected = int64(len("key1")) + entryOverhead
+	if kd.MemoryUsage() != expected {
+		t.Errorf("expected %d bytes after delete, got %d", expected, kd.MemoryUsage())
+	}
+}
+
+func TestGeneration(t *testing.T) {
+	kd := NewKeydir()
+	now := time.Now()
+	kd.AddKeydirRecord([]byte("a"), 1, 1, 0, now)
+	gen := kd.Generation()
+	if gen == 0 {
+		t.Fatalf("expected generation to change when a key is added")
+	}
+	kd.AddKeydirRecord([]byte("a"), 1, 2, 10, now.Add(time.Second))
+	if kd.Generation() != gen {
+		t.Errorf("expected generation to stay the same when a key is updated")
+	}
+	kd.DeleteRecord([]byte("missing"))
+	if kd.Generation() != gen {
+		t.Errorf("expected generation to stay the same when a missing key is deleted")
+	}
+	kd.DeleteRecord([]byte("a"))
+	if kd.Generation() == gen {
+		t.Errorf("expected generation to change when a key is deleted")
+	}
+}
+
+func TestRange(t *testing.T) {
+	kd := NewKeydir()
+	now := time.Now()
+	kd.AddKeydirRecord([]byte("a"), 1, 1, 0, now)
+	kd.AddKeydirRecord([]byte("b"), 2, 1, 0, now)
+	kd.AddKeydirRecord([]byte("c"), 3, 1, 0, now)
+
+	seen := map[string]int{}
+	kd.Range(func(key string, record KeydirRecord) bool {
+		seen[key] = record.FileId
+		return true
+	})
+	if len(seen) != 3 || seen["a"] != 1 || seen["b"] != 2 || seen["c"] != 3 {
+		t.Errorf("expected every record to be visited, got %v", seen)
+	}
+
+	calls := 0
+	kd.Range(func(string, KeydirRecord) bool {
+		calls++
+		return false
+	})
+	if calls != 1 {
+		t.Errorf("expected Range to stop when fn returns false, got %d calls", calls)
+	}
+}
+
+func TestRandomKey(t *testing.T) {
+	kd := NewKeydir()
+	if _, ok := kd.RandomKey(); ok {
+		t.Errorf("expected no key in an empty keydir")
+	}
+	now := time.Now()
+	for _, key := range []string{"a", "b", "c"} {
+		kd.AddKeydirRecord([]byte(key), 1, 1, 0, now)
+	}
+	// Every key is eventually returned
+	seen := map[string]bool{}
+	for i := 0; i < 1000 && len(seen) < 3; i++ {
+		key, ok := kd.RandomKey()
+		if !ok {
+			t.Fatalf("expected a key")
+		}
+		seen[key] = true
+	}
+	if len(seen) != 3 {
+		t.Errorf("expected every key to be returned, got %v", seen)
+	}
+}
+
+func TestOnRemove(t *testing.T) {
+	kd := NewKeydir()
+	var removed []KeydirRecord
+	kd.SetOnRemove(func(record KeydirRecord) {
+		removed = append(removed, record)
+	})
+	now := time.Now()
+	kd.AddKeydirRecord([]byte("a"), 1, 1, 0, now)
+	if len(removed) != 0 {
+		t.Fatalf("expected no call when a key is added, got %v", removed)
+	}
+	kd.AddKeydirRecord([]byte("a"), 2, 1, 10, now.Add(time.Second))
+	// A stale update is ignored, the record is not replaced
+	kd.AddKeydirRecord([]byte("a"), 3, 1, 20, now)
+	kd.DeleteRecord([]byte("missing"))
+	kd.DeleteRecord([]byte("a"))
+	if len(removed) != 2 || removed[0].FileId != 1 || removed[0].ValuePos != 0 || removed[1].FileId != 2 || removed[1].ValuePos != 10 {
+		t.Errorf("expected the records of files 1 and 2 to be removed, got %v", removed)
+	}
+}
+
+func TestImmutable(t *testing.T) {
+	kd := NewKeydir()
+	now := time.Now()
+	kd.Add([]byte("a"), KeydirRecord{FileId: 1, Timestamp: now, Immutable: true})
+	kd.AddKeydirRecord([]byte("a"), 2, 1, 0, now.Add(time.Second))
+	if kd.DeleteRecordIfNotNewer([]byte("a"), now.Add(time.Second)) {
+		t.Errorf("expected a write-once key not to be deleted")
+	}
+	if rec, _ := kd.GetKeydirRecord([]byte("a")); rec.FileId != 1 {
+		t.Errorf("expected a write-once key not to be replaced by a newer record, got %+v", rec)
+	}
+	// A merge moves the same record to another file
+	kd.Add([]byte("a"), KeydirRecord{FileId: 3, Timestamp: now, Immutable: true})
+	if rec, _ := kd.GetKeydirRecord([]byte("a")); rec.FileId != 3 || !rec.Immutable {
+		t.Errorf("expected the record to be moved to file 3, got %+v", rec)
+	}
+}
+
+func TestLiveBytes(t *testing.T) {
+	kd := NewKeydir()
+	now := time.Now()
+	kd.AddKeydirRecord([]byte("a"), 1, 10, 0, now)
+	if kd.LiveBytes() != nil {
+		t.Fatalf("expected live bytes not to be tracked")
+	}
+	// Every record takes the key and value sizes, plus 1 byte per file id
+	kd.TrackLiveBytes(func(fileId int, keySize int, valueSize uint32) int64 {
+		return int64(keySize) + int64(valueSize) + int64(fileId)
+	})
+	if live := kd.LiveBytes(); len(live) != 1 || live[1] != 12 {
+		t.Fatalf("expected the existing key to be counted, got %v", live)
+	}
+	kd.AddKeydirRecord([]byte("b"), 1, 5, 20, now)
+	kd.AddKeydirRecord([]byte("a"), 2, 3, 0, now.Add(time.Second))
+	// A stale update is not counted
+	kd.AddKeydirRecord([]byte("b"), 2, 100, 10, now.Add(-time.Second))
+	if live := kd.LiveBytes(); len(live) != 2 || live[1] != 7 || live[2] != 6 {
+		t.Errorf("expected 7 bytes in file 1 and 6 in file 2, got %v", live)
+	}
+	kd.DeleteRecord([]byte("b"))
+	if live := kd.LiveBytes(); len(live) != 1 || live[2] != 6 {
+		t.Errorf("expected file 1 to have no live bytes, got %v", live)
+	}
+}
@@ -0,0 +1,84 @@
+package kverrors
+
+import (
+	"errors"
+	"fmt"
+)
+
+/*
+kverrors has the error kinds that are shared by the internal packages and re-exported by kvdb.
+
+An error kind (like ErrCorrupt) groups the errors of several packages, so that callers can check for the kind with
+errors.Is instead of listing every error. The sentinel errors of the packages are made with New, which returns an
+error that has it's own message and identity, and unwraps to it's kind:
+
+	var ErrCrcChecksumMismatch = kverrors.New(kverrors.ErrCorrupt, "crc checksum does not match stored value")
+
+	errors.Is(err, ErrCrcChecksumMismatch) // the exact error
+	errors.Is(err, kverrors.ErrCorrupt)    // any corruption
+
+Errors from reading a file are wrapped in a *FileError, which adds the path of the file, and the offset that was being
+read, without hiding the error from errors.Is and errors.As
+*/
+
+var (
+	// ErrCorrupt is the kind of the errors returned when a file does not have the expected contents, for example a
+	// record whose checksum does not match, or a data file with an invalid header
+	ErrCorrupt = errors.New("data is corrupt")
+	// ErrTooLarge is the kind of the errors returned for keys or values that are larger than the limits
+	ErrTooLarge = errors.New("too large")
+	// ErrReadOnly is the kind of the errors returned by writes of something that can't be changed
+	ErrReadOnly = errors.New("read only")
+	// ErrLocked is the kind of the errors returned when something is held by another owner
+	ErrLocked = errors.New("locked")
+)
+
+// kindError is a sentinel error of a kind, see New
+type kindError struct {
+	msg  string
+	kind error
+}
+
+func (e *kindError) Error() string {
+	return e.msg
+}
+
+func (e *kindError) Unwrap() error {
+	return e.kind
+}
+
+// New returns a sentinel error with the given message, that matches kind with errors.Is
+func New(kind error, msg string) error {
+	return &kindError{msg: msg, kind: kind}
+}
+
+// FileError is an error that happened while reading (or writing) a file, with the path of the file and the offset. The
+// offset is -1 if the error is not about a position in the file
+type FileError struct {
+	Path   string
+	Offset int64
+	Err    error
+}
+
+func (e *FileError) Error() string {
+	if e.Offset < 0 {
+		return fmt.Sprintf("%s: %v", e.Path, e.Err)
+	}
+	return fmt.Sprintf("%s: offset %d: %v", e.Path, e.Offset, e.Err)
+}
+
+func (e *FileError) Unwrap() error {
+	return e.Err
+}
+
+// WrapFile wraps err in a *FileError with the given path and offset. It returns nil if err is nil, and err itself if
+// it's already a *FileError, so that errors are not wrapped twice
+func WrapFile(err error, path string, offset int64) error {
+	if err == nil {
+		return nil
+	}
+	if _, ok := err.(*FileError); ok {
+		return err
+	}
+	return &FileError{Path: path, Offset: offset, Err: err}
+}
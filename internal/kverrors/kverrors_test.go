@@ -0,0 +1,33 @@
+package kverrors
+
+import (
+	"errors"
+	"io"
+	"testing"
+)
+
+func TestNew(t *testing.T) {
+	err := New(ErrCorrupt, "bad record")
+	if err.Error() != "bad record" || !errors.Is(err, ErrCorrupt) || errors.Is(err, ErrTooLarge) {
+		t.Errorf("unexpected error %q", err)
+	}
+	if errors.Is(err, New(ErrCorrupt, "bad record")) {
+		t.Error("expected errors with the same message to be distinct")
+	}
+}
+
+func TestWrapFile(t *testing.T) {
+	if WrapFile(nil, "1.dat", 0) != nil {
+		t.Error("expected nil to stay nil")
+	}
+	err := WrapFile(io.ErrUnexpectedEOF, "1.dat", 42)
+	if err.Error() != "1.dat: offset 42: unexpected EOF" || !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("unexpected error %q", err)
+	}
+	if again := WrapFile(err, "2.dat", 7); again != err {
+		t.Errorf("expected a *FileError not to be wrapped again, got %q", again)
+	}
+	if err := WrapFile(io.EOF, "1.dat", -1); err.Error() != "1.dat: EOF" {
+		t.Errorf("unexpected error %q", err)
+	}
+}
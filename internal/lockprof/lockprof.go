@@ -0,0 +1,91 @@
+package lockprof
+
+import (
+	"context"
+	"runtime/pprof"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// LabelKey is the pprof label set on a goroutine while a sampled lock acquisition is waiting, the value is the site
+const LabelKey = "kvdb_lock"
+
+// Profiler samples the time spent waiting to acquire locks, grouped by the site (a short name of the code path) that
+// acquired the lock. Only one in every rate acquisitions is timed, so that the overhead stays small.
+// A nil *Profiler is valid, it acquires the lock without profiling, so callers don't have to check if profiling is enabled
+type Profiler struct {
+	rate     uint64
+	acquired atomic.Uint64
+
+	mu    sync.Mutex
+	sites map[string]*Site
+}
+
+// Site is the lock wait time sampled at a single site
+type Site struct {
+	Name string
+	// Number of sampled acquisitions
+	Samples uint64
+	// Total and maximum time spent waiting for the lock in the sampled acquisitions
+	TotalWait time.Duration
+	MaxWait   time.Duration
+}
+
+// New returns a profiler that samples one in every rate acquisitions, nil is returned if rate is not positive
+func New(rate int) *Profiler {
+	if rate <= 0 {
+		return nil
+	}
+	return &Profiler{rate: uint64(rate), sites: map[string]*Site{}}
+}
+
+// Lock acquires the lock, and records the wait time if the acquisition is sampled. Use RWMutex.RLocker() to profile
+// read locks
+func (p *Profiler) Lock(l sync.Locker, site string) {
+	if p == nil || p.acquired.Add(1)%p.rate != 0 {
+		l.Lock()
+		return
+	}
+	start := time.Now()
+	// The label makes goroutines blocked on the lock (and the CPU time spent spinning) attributable to the site in
+	// goroutine and CPU profiles
+	pprof.Do(context.Background(), pprof.Labels(LabelKey, site), func(context.Context) {
+		l.Lock()
+	})
+	p.record(site, time.Since(start))
+}
+
+func (p *Profiler) record(site string, wait time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	s, ok := p.sites[site]
+	if !ok {
+		s = &Site{Name: site}
+		p.sites[site] = s
+	}
+	s.Samples++
+	s.TotalWait += wait
+	s.MaxWait = max(s.MaxWait, wait)
+}
+
+// Sites returns the sampled sites, the ones with the most total wait time first. nil is returned for a nil profiler
+func (p *Profiler) Sites() []Site {
+	if p == nil {
+		return nil
+	}
+	p.mu.Lock()
+	sites := make([]Site, 0, len(p.sites))
+	for _, s := range p.sites {
+		sites = append(sites, *s)
+	}
+	p.mu.Unlock()
+	sort.Slice(sites, func(i, j int) bool {
+		if sites[i].TotalWait != sites[j].TotalWait {
+			return sites[i].TotalWait > sites[j].TotalWait
+		}
+		return sites[i].Name < sites[j].Name
+	})
+	return sites
+}
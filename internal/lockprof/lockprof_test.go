@@ -0,0 +1,66 @@
+package lockprof
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestNilProfilerLocks(t *testing.T) {
+	var p *Profiler
+	var mu sync.Mutex
+	p.Lock(&mu, "site")
+	if mu.TryLock() {
+		t.Fatalf("expected the lock to be held")
+	}
+	mu.Unlock()
+	if sites := p.Sites(); sites != nil {
+		t.Errorf("expected no sites, got %v", sites)
+	}
+	if New(0) != nil {
+		t.Errorf("expected a nil profiler for rate 0")
+	}
+}
+
+func TestProfilerSamplesWaits(t *testing.T) {
+	p := New(1)
+	var mu sync.RWMutex
+
+	p.Lock(mu.RLocker(), "read")
+	mu.RUnlock()
+
+	mu.Lock()
+	done := make(chan struct{})
+	go func() {
+		p.Lock(&mu, "write")
+		mu.Unlock()
+		close(done)
+	}()
+	time.Sleep(20 * time.Millisecond)
+	mu.Unlock()
+	<-done
+
+	sites := p.Sites()
+	if len(sites) != 2 {
+		t.Fatalf("expected 2 sites, got %v", sites)
+	}
+	if sites[0].Name != "write" || sites[0].Samples != 1 || sites[0].MaxWait < 10*time.Millisecond {
+		t.Errorf("unexpected write site: %+v", sites[0])
+	}
+	if sites[1].Name != "read" || sites[1].Samples != 1 {
+		t.Errorf("unexpected read site: %+v", sites[1])
+	}
+}
+
+func TestProfilerSampleRate(t *testing.T) {
+	p := New(4)
+	var mu sync.Mutex
+	for range 10 {
+		p.Lock(&mu, "site")
+		mu.Unlock()
+	}
+	sites := p.Sites()
+	if len(sites) != 1 || sites[0].Samples != 2 {
+		t.Errorf("expected 2 samples, got %v", sites)
+	}
+}
@@ -15,6 +15,14 @@ type MetaData struct {
 	Version         string
 	Created         string
 	MaxDatafileSize int
+	// Largest key and value sizes of the datastore, 0 if the metafile does not set them (the default limits are used)
+	MaxKeySize   int
+	MaxValueSize int
+	// Directories of the active data files, merged data files and hint files, empty for the default directories
+	// (${root}/data and ${root}/hint). Relative directories are relative to the datastore directory
+	ActiveDir string
+	MergedDir string
+	HintDir   string
 }
 
 const identifierFileName = "kvdb_store.meta"
@@ -80,6 +88,16 @@ func ReadMetaFile(fs afero.Fs, path string) (*MetaData, error) {
 			metaData.Created = value
 		case "max_datafile_size":
 			fmt.Sscanf(value, "%d", &metaData.MaxDatafileSize)
+		case "max_key_size":
+			fmt.Sscanf(value, "%d", &metaData.MaxKeySize)
+		case "max_value_size":
+			fmt.Sscanf(value, "%d", &metaData.MaxValueSize)
+		case "active_dir":
+			metaData.ActiveDir = value
+		case "merged_dir":
+			metaData.MergedDir = value
+		case "hint_dir":
+			metaData.HintDir = value
 		}
 	}
 
@@ -121,6 +139,28 @@ func WriteMetaFile(fs afero.Fs, path string, metaData *MetaData) error {
 	if err != nil {
 		return err
 	}
+	if metaData.MaxKeySize != 0 {
+		_, err = fmt.Fprintf(writer, "max_key_size=%d\n", metaData.MaxKeySize)
+		if err != nil {
+			return err
+		}
+	}
+	if metaData.MaxValueSize != 0 {
+		_, err = fmt.Fprintf(writer, "max_value_size=%d\n", metaData.MaxValueSize)
+		if err != nil {
+			return err
+		}
+	}
+	for _, dir := range []struct{ key, value string }{
+		{"active_dir", metaData.ActiveDir}, {"merged_dir", metaData.MergedDir}, {"hint_dir", metaData.HintDir},
+	} {
+		if dir.value != "" {
+			_, err = fmt.Fprintf(writer, "%s=%s\n", dir.key, dir.value)
+			if err != nil {
+				return err
+			}
+		}
+	}
 	return nil
 }
 
@@ -65,6 +65,9 @@ func TestReadMetaFile(t *testing.T) {
 	if metaData.Type != "example" || metaData.Version != "1.0" || metaData.Created != "2023-01-01" {
 		t.Errorf("Expected valid metadata, got %+v", metaData)
 	}
+	if metaData.MaxKeySize != 1024 || metaData.MaxValueSize != 2048 || metaData.MaxDatafileSize != 1048576 {
+		t.Errorf("Expected limits to be read, got %+v", metaData)
+	}
 }
 func TestWriteMetaFile(t *testing.T) {
 	fs := afero.NewMemMapFs()
@@ -92,3 +95,25 @@ func TestWriteMetaFile(t *testing.T) {
 	}
 
 }
+
+func TestWriteMetaFileWithLimits(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	metaData := &MetaData{
+		Type:            "example",
+		Version:         "1.0",
+		Created:         "2023-01-01",
+		MaxDatafileSize: 1048576,
+		MaxKeySize:      1024,
+		MaxValueSize:    2048,
+	}
+	if err := WriteMetaFile(fs, "/datastore", metaData); err != nil {
+		t.Fatalf("Expected nil, got error: %v", err)
+	}
+	read, err := ReadMetaFile(fs, "/datastore")
+	if err != nil {
+		t.Fatalf("Expected nil, got error: %v", err)
+	}
+	if *read != *metaData {
+		t.Errorf("Expected %+v, got %+v", metaData, read)
+	}
+}
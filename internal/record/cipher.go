@@ -0,0 +1,119 @@
+package record
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"slices"
+)
+
+/*
+Encryption at rest
+
+The records of a data file whose header has the AES-GCM cipher (see datafile.CipherAESGCM) are encrypted. The key and
+the value of a record are replaced by a random 12 byte nonce, followed by the AES-GCM ciphertext of the key and the
+value (sealed together, as one message) and it's 16 byte tag:
+
+	header | nonce | ciphertext of key and value | tag | checksum
+
+The header stays in the clear, and the sizes in it are the sizes of the plaintext key and value, so an encrypted record
+is EncryptionOverhead bytes larger than the same record in the clear. The header is the additional data of the message,
+so a record whose header was changed can't be decrypted. The checksum covers the header and everything after it, so
+torn writes and corruption are found (and handled) the same way as before, without the keys.
+
+The keys are held by a Keyring. New records are encrypted with the current key, and when a record is read, the current
+key is tried first, then the previous keys. A key can be rotated by making it a previous key: files written before the
+rotation stay readable, and a merge rewrites their live records with the new key (the file that was active when the
+key was rotated is merged once it's sealed, records appended to it after the rotation use the new key). A keyring with
+only previous keys decrypts, but does not encrypt, so a merge with it rewrites the records in the clear.
+
+Encrypted records are decrypted whole, so a value of an encrypted file is always read into memory, even through
+ValueReader, and WriteRecordFromReader reads the value into memory before it's written
+*/
+
+const nonceSize = 12
+
+// EncryptionOverhead is the number of bytes an encrypted record takes in addition to the same record in the clear, for
+// the nonce and the tag
+const EncryptionOverhead = nonceSize + 16
+
+var (
+	// ErrNoKey is returned when an encrypted data file is read without a keyring, or written without a current key
+	ErrNoKey = errors.New("data file is encrypted, but no encryption key was given")
+	// ErrDecrypt is returned when a record whose checksum is valid can't be decrypted with any of the keys of the
+	// keyring, usually because the key it was encrypted with is not in the keyring
+	ErrDecrypt = errors.New("record could not be decrypted with any of the encryption keys")
+)
+
+// Keyring holds the AES keys records are encrypted and decrypted with, see cipher.go. It's safe for concurrent use
+type Keyring struct {
+	// current is nil if records are not encrypted, keys has the current key first
+	current cipher.AEAD
+	keys    []cipher.AEAD
+}
+
+// NewKeyring returns a keyring that encrypts with current, and decrypts with current and the previous keys, in order.
+// Keys must be 16, 24 or 32 bytes long (AES-128, AES-192 or AES-256). current can be nil, then records are only
+// decrypted
+func NewKeyring(current []byte, previous ...[]byte) (*Keyring, error) {
+	k := &Keyring{}
+	for i, key := range append([][]byte{current}, previous...) {
+		if i == 0 && current == nil {
+			continue
+		}
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, fmt.Errorf("encryption key %d: %w", i, err)
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+		if i == 0 {
+			k.current = aead
+		}
+		k.keys = append(k.keys, aead)
+	}
+	if len(k.keys) == 0 {
+		return nil, errors.New("no encryption keys")
+	}
+	return k, nil
+}
+
+// CanEncrypt returns true if the keyring has a current key, a nil keyring can't encrypt
+func (k *Keyring) CanEncrypt() bool {
+	return k != nil && k.current != nil
+}
+
+// Seal appends the nonce, and the ciphertext of plaintext with it's tag, to dst and returns it. additional is
+// authenticated but not encrypted. It must only be called if CanEncrypt is true
+func (k *Keyring) Seal(dst, plaintext, additional []byte) []byte {
+	dst = slices.Grow(dst, nonceSize+len(plaintext)+k.current.Overhead())
+	start := len(dst)
+	dst = dst[:start+nonceSize]
+	nonce := dst[start:]
+	// crypto/rand.Read never returns an error
+	rand.Read(nonce)
+	return k.current.Seal(dst, nonce, plaintext, additional)
+}
+
+// Open decrypts sealed (a nonce followed by the ciphertext and it's tag, as written by Seal) with the first key that
+// authenticates it, and appends the plaintext to dst, which must not overlap sealed. It returns ErrDecrypt if no key
+// does
+func (k *Keyring) Open(dst, sealed, additional []byte) ([]byte, error) {
+	if k == nil {
+		return dst, ErrNoKey
+	}
+	if len(sealed) < EncryptionOverhead {
+		return dst, ErrDecrypt
+	}
+	nonce, ciphertext := sealed[:nonceSize], sealed[nonceSize:]
+	for _, aead := range k.keys {
+		if plaintext, err := aead.Open(dst, nonce, ciphertext, additional); err == nil {
+			return plaintext, nil
+		}
+	}
+	return dst, ErrDecrypt
+}
@@ -0,0 +1,216 @@
+package record
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ananthvk/kvdb/internal/datafile"
+	"github.com/spf13/afero"
+)
+
+var (
+	testKey      = bytes.Repeat([]byte{1}, 32)
+	testOldKey   = bytes.Repeat([]byte{2}, 16)
+	testWrongKey = bytes.Repeat([]byte{3}, 32)
+)
+
+// writeEncryptedTestFile writes an encrypted data file with the given records, and returns their offsets
+func writeEncryptedTestFile(t *testing.T, fs afero.Fs, path string, format Format, keyring *Keyring, pairs []kv) []int64 {
+	t.Helper()
+	encoding := datafile.Encoding{RecordFormat: int(format), Checksum: datafile.ChecksumCRC32C, Cipher: datafile.CipherAESGCM}
+	if err := datafile.WriteFileHeaderWithEncoding(fs, path, time.UnixMicro(1), encoding); err != nil {
+		t.Fatalf("failed to write header: %v", err)
+	}
+	writer, err := NewWriter(fs, path)
+	if err != nil {
+		t.Fatalf("failed to create writer: %v", err)
+	}
+	defer writer.Close()
+	if !writer.Encrypted() {
+		t.Fatal("expected the writer of an encrypted file to encrypt")
+	}
+	if _, err := writer.WriteKeyValue([]byte("key"), []byte("value")); !errors.Is(err, ErrNoKey) {
+		t.Fatalf("expected ErrNoKey without a keyring, got %v", err)
+	}
+	writer.SetKeyring(keyring)
+	var offsets []int64
+	for i, pair := range pairs {
+		var offset int64
+		if pair.value == nil {
+			offset, err = writer.WriteTombstoneWithTs(pair.key, time.UnixMicro(int64(i)))
+		} else {
+			offset, err = writer.WriteKeyValueWithTs(pair.key, pair.value, time.UnixMicro(int64(i)))
+		}
+		if err != nil {
+			t.Fatalf("failed to write record: %v", err)
+		}
+		offsets = append(offsets, offset-datafile.FileHeaderSize)
+	}
+	return offsets
+}
+
+func TestEncryptedRoundTrip(t *testing.T) {
+	keyring, err := NewKeyring(testKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pairs := []kv{
+		{key: []byte("a"), value: []byte("1")},
+		{key: []byte(""), value: []byte("")},
+		{key: []byte("deleted"), value: nil},
+		{key: bytes.Repeat([]byte("k"), 200), value: bytes.Repeat([]byte("v"), 70000)},
+	}
+	for _, format := range []Format{FormatV1, FormatV2} {
+		fs := afero.NewMemMapFs()
+		offsets := writeEncryptedTestFile(t, fs, "enc.dat", format, keyring, pairs)
+
+		// The keys and values are not in the file in the clear
+		data, _ := afero.ReadFile(fs, "enc.dat")
+		var size int64 = datafile.FileHeaderSize
+		for _, pair := range pairs {
+			size += format.EncodedSize(uint32(len(pair.key)), uint32(len(pair.value))) + EncryptionOverhead
+		}
+		if int64(len(data)) != size {
+			t.Errorf("format %d: expected a file of %d bytes, got %d", format, size, len(data))
+		}
+		if bytes.Contains(data, []byte("deleted")) || bytes.Contains(data, bytes.Repeat([]byte("v"), 100)) {
+			t.Errorf("format %d: found a key or value in the clear", format)
+		}
+
+		reader, err := NewReader(fs, "enc.dat")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := reader.ReadRecordAt(offsets[0]); !errors.Is(err, ErrNoKey) {
+			t.Errorf("format %d: expected ErrNoKey without a keyring, got %v", format, err)
+		}
+		reader.SetKeyring(keyring)
+		for i, pair := range pairs {
+			rec, err := reader.ReadRecordAtStrict(offsets[i])
+			if err != nil || !bytes.Equal(rec.Key, pair.key) || !bytes.Equal(rec.Value, pair.value) {
+				t.Fatalf("format %d: record %d: got %+v, %v", format, i, rec, err)
+			}
+			if rec.Size != reader.EncodedSize(uint32(len(pair.key)), uint32(len(pair.value))) {
+				t.Errorf("format %d: record %d: unexpected size %d", format, i, rec.Size)
+			}
+			_, value, err := reader.ReadValueInto(offsets[i], nil)
+			if err != nil || !bytes.Equal(value, pair.value) {
+				t.Errorf("format %d: record %d: ReadValueInto got %q, %v", format, i, value, err)
+			}
+			section, err := reader.ValueReader(offsets[i])
+			if err != nil {
+				t.Fatal(err)
+			}
+			if value, _ := io.ReadAll(section); !bytes.Equal(value, pair.value) {
+				t.Errorf("format %d: record %d: ValueReader got %q", format, i, value)
+			}
+		}
+		reader.Close()
+
+		scanner, err := NewScanner(fs, "enc.dat")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, _, err := scanner.Scan(); !errors.Is(err, ErrNoKey) {
+			t.Errorf("format %d: expected ErrNoKey without a keyring, got %v", format, err)
+		}
+		scanner.SetKeyring(keyring)
+		for i, pair := range pairs {
+			rec, offset, err := scanner.Scan()
+			if err != nil || offset != offsets[i] || !bytes.Equal(rec.Key, pair.key) || !bytes.Equal(rec.Value, pair.value) {
+				t.Fatalf("format %d: scanned record %d: got %+v at %d, %v", format, i, rec, offset, err)
+			}
+		}
+		if _, _, err := scanner.Scan(); err != io.EOF {
+			t.Errorf("format %d: expected io.EOF, got %v", format, err)
+		}
+		scanner.Close()
+	}
+}
+
+func TestEncryptedKeyRotation(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	oldKeyring, _ := NewKeyring(testOldKey)
+	offsets := writeEncryptedTestFile(t, fs, "old.dat", FormatV2, oldKeyring, []kv{{key: []byte("k"), value: []byte("v")}})
+
+	for _, tc := range []struct {
+		name    string
+		current []byte
+		prev    [][]byte
+		err     error
+	}{
+		{"rotated", testKey, [][]byte{testOldKey}, nil},
+		{"decrypt only", nil, [][]byte{testOldKey}, nil},
+		{"wrong key", testWrongKey, nil, ErrDecrypt},
+	} {
+		keyring, err := NewKeyring(tc.current, tc.prev...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		reader, _ := NewReader(fs, "old.dat")
+		reader.SetKeyring(keyring)
+		rec, err := reader.ReadRecordAtStrict(offsets[0])
+		if !errors.Is(err, tc.err) || (err == nil && string(rec.Value) != "v") {
+			t.Errorf("%s: expected %v, got %+v, %v", tc.name, tc.err, rec, err)
+		}
+		reader.Close()
+	}
+
+	// A keyring without a current key can't encrypt
+	keyring, _ := NewKeyring(nil, testOldKey)
+	if keyring.CanEncrypt() {
+		t.Error("expected a keyring without a current key not to encrypt")
+	}
+	if _, err := NewKeyring(nil); err == nil {
+		t.Error("expected an error for a keyring without keys")
+	}
+	if _, err := NewKeyring([]byte("short")); err == nil {
+		t.Error("expected an error for a key of an invalid size")
+	}
+}
+
+func TestEncryptedTamperedHeader(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	keyring, _ := NewKeyring(testKey)
+	offsets := writeEncryptedTestFile(t, fs, "tampered.dat", FormatV1, keyring, []kv{{key: []byte("k"), value: []byte("v")}})
+	data, _ := afero.ReadFile(fs, "tampered.dat")
+	// Change the value type, which is not covered by the sizes
+	data[datafile.FileHeaderSize+17] = 0x7
+	afero.WriteFile(fs, "tampered.dat", data, 0666)
+	reader, _ := NewReader(fs, "tampered.dat")
+	defer reader.Close()
+	reader.SetKeyring(keyring)
+	if _, err := reader.ReadRecordAt(offsets[0]); !errors.Is(err, ErrDecrypt) {
+		t.Errorf("expected ErrDecrypt for a changed header, got %v", err)
+	}
+}
+
+func TestEncryptedWriteRecordFromReader(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	keyring, _ := NewKeyring(testKey)
+	writeEncryptedTestFile(t, fs, "stream.dat", FormatV2, keyring, nil)
+	writer, err := NewWriter(fs, "stream.dat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	writer.SetKeyring(keyring)
+	if _, err := writer.WriteRecordFromReader(Header{Timestamp: time.Now(), RecordType: RecordTypePut, ValueSize: 10}, []byte("k"), strings.NewReader("short")); err != io.ErrUnexpectedEOF {
+		t.Errorf("expected io.ErrUnexpectedEOF, got %v", err)
+	}
+	offset, err := writer.WriteRecordFromReader(Header{Timestamp: time.Now(), RecordType: RecordTypePut, ValueSize: 8}, []byte("k"), strings.NewReader("streamed value"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	writer.Close()
+	reader, _ := NewReader(fs, "stream.dat")
+	defer reader.Close()
+	reader.SetKeyring(keyring)
+	rec, err := reader.ReadRecordAtStrict(offset - datafile.FileHeaderSize)
+	if err != nil || string(rec.Value) != "streamed" {
+		t.Errorf("expected the first 8 bytes of the value, got %+v, %v", rec, err)
+	}
+}
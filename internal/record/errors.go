@@ -1,9 +1,11 @@
 package record
 
-import "errors"
+import "github.com/ananthvk/kvdb/internal/kverrors"
 
-var ErrCrcChecksumMismatch = errors.New("crc checksum does not match stored value")
+var ErrCrcChecksumMismatch = kverrors.New(kverrors.ErrCorrupt, "crc checksum does not match stored value")
 
-var ErrKeyTooLarge = errors.New("key too large")
+var ErrKeyTooLarge = kverrors.New(kverrors.ErrTooLarge, "key too large")
 
-var ErrValueTooLarge = errors.New("value too large")
+var ErrValueTooLarge = kverrors.New(kverrors.ErrTooLarge, "value too large")
+
+var errShortHeader = kverrors.New(kverrors.ErrCorrupt, "record header is truncated")
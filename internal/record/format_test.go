@@ -0,0 +1,304 @@
+package record
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ananthvk/kvdb/internal/datafile"
+	"github.com/spf13/afero"
+)
+
+// writeFormatTestFile writes a data file with records in the given format and with the given checksum algorithm, and
+// returns the offsets of the records
+func writeFormatTestFile(t *testing.T, fs afero.Fs, path string, format Format, checksum Checksum, pairs []kv) []int64 {
+	t.Helper()
+	encoding := datafile.Encoding{RecordFormat: int(format), Checksum: int(checksum)}
+	if err := datafile.WriteFileHeaderWithEncoding(fs, path, time.UnixMicro(1), encoding); err != nil {
+		t.Fatalf("failed to write header: %v", err)
+	}
+	writer, err := NewWriter(fs, path)
+	if err != nil {
+		t.Fatalf("failed to create writer: %v", err)
+	}
+	defer writer.Close()
+	if writer.Format() != format || writer.Checksum() != checksum {
+		t.Fatalf("expected writer format %d with %s, got %d with %s", format, checksum, writer.Format(), writer.Checksum())
+	}
+	var offsets []int64
+	for i, pair := range pairs {
+		var offset int64
+		if pair.value == nil {
+			offset, err = writer.WriteTombstoneWithTs(pair.key, time.UnixMicro(int64(i)))
+		} else {
+			offset, err = writer.WriteKeyValueWithTs(pair.key, pair.value, time.UnixMicro(int64(i)))
+		}
+		if err != nil {
+			t.Fatalf("failed to write record: %v", err)
+		}
+		offsets = append(offsets, offset-datafile.FileHeaderSize)
+	}
+	return offsets
+}
+
+func TestFormatV2RoundTrip(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	pairs := []kv{
+		{key: []byte("a"), value: []byte("1")},
+		{key: []byte(""), value: []byte("")},
+		{key: []byte("deleted"), value: nil},
+		// Sizes that take more than one byte as a uvarint
+		{key: bytes.Repeat([]byte("k"), 200), value: bytes.Repeat([]byte("v"), 70000)},
+		{key: []byte("last"), value: []byte("value")},
+	}
+	offsets := writeFormatTestFile(t, fs, "v2.dat", FormatV2, ChecksumCRC32, pairs)
+
+	reader, err := NewReader(fs, "v2.dat")
+	if err != nil {
+		t.Fatalf("failed to create reader: %v", err)
+	}
+	defer reader.Close()
+	scanner, err := NewScanner(fs, "v2.dat")
+	if err != nil {
+		t.Fatalf("failed to create scanner: %v", err)
+	}
+	defer scanner.Close()
+	if reader.Format() != FormatV2 || scanner.Format() != FormatV2 {
+		t.Fatalf("expected v2 format, got %d and %d", reader.Format(), scanner.Format())
+	}
+
+	for i, pair := range pairs {
+		rec, err := reader.ReadRecordAtStrict(offsets[i])
+		if err != nil {
+			t.Fatalf("record %d: failed to read: %v", i, err)
+		}
+		if !bytes.Equal(rec.Key, pair.key) || !bytes.Equal(rec.Value, pair.value) || rec.Header.Timestamp.UnixMicro() != int64(i) {
+			t.Errorf("record %d: unexpected record %+v", i, rec.Header)
+		}
+		if value, err := reader.ReadValueAt(offsets[i]); err != nil || !bytes.Equal(value.Value, pair.value) {
+			t.Errorf("record %d: unexpected value, %v", i, err)
+		}
+
+		scanned, offset, err := scanner.Scan()
+		if err != nil {
+			t.Fatalf("record %d: failed to scan: %v", i, err)
+		}
+		if offset != offsets[i] || !bytes.Equal(scanned.Key, pair.key) || !bytes.Equal(scanned.Value, pair.value) {
+			t.Errorf("record %d: unexpected scanned record at %d", i, offset)
+		}
+		if scanned.Size != FormatV2.EncodedSize(uint32(len(pair.key)), uint32(len(pair.value))) {
+			t.Errorf("record %d: unexpected size %d", i, scanned.Size)
+		}
+	}
+	if _, _, err := scanner.Scan(); !errors.Is(err, io.EOF) {
+		t.Errorf("expected io.EOF, got %v", err)
+	}
+
+	// The last record of the file is shorter than the largest v2 header
+	info, err := fs.Stat("v2.dat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size := datafile.FileHeaderSize + offsets[len(offsets)-1] + FormatV2.EncodedSize(4, 5); info.Size() != size {
+		t.Errorf("expected file size %d, got %d", size, info.Size())
+	}
+}
+
+func TestFormatV2IsSmaller(t *testing.T) {
+	if v1, v2 := FormatV1.EncodedSize(4, 8), FormatV2.EncodedSize(4, 8); v2 != v1-8 {
+		t.Errorf("expected v2 records of small keys and values to be 8 bytes smaller, got %d and %d", v1, v2)
+	}
+	if v1, v2 := FormatV1.EncodedSize(1000, 1000000), FormatV2.EncodedSize(1000, 1000000); v2 > v1 {
+		t.Errorf("expected v2 records to be at most as large as v1 records, got %d and %d", v1, v2)
+	}
+}
+
+func TestFormatV2Truncated(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	writeFormatTestFile(t, fs, "v2.dat", FormatV2, ChecksumCRC32, []kv{{key: []byte("key"), value: []byte(strings.Repeat("v", 300))}})
+	data, err := afero.ReadFile(fs, "v2.dat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Cut the file inside the varint header, and inside the value
+	for _, size := range []int{datafile.FileHeaderSize + 5, datafile.FileHeaderSize + 10, len(data) - 10} {
+		if err := afero.WriteFile(fs, "truncated.dat", data[:size], os.ModePerm); err != nil {
+			t.Fatal(err)
+		}
+		scanner, err := NewScanner(fs, "truncated.dat")
+		if err != nil {
+			t.Fatalf("failed to create scanner: %v", err)
+		}
+		if _, _, err := scanner.Scan(); !errors.Is(err, io.ErrUnexpectedEOF) {
+			t.Errorf("size %d: expected io.ErrUnexpectedEOF, got %v", size, err)
+		}
+		scanner.Close()
+
+		reader, err := NewReader(fs, "truncated.dat")
+		if err != nil {
+			t.Fatalf("failed to create reader: %v", err)
+		}
+		if _, err := reader.ReadRecordAtStrict(0); err == nil {
+			t.Errorf("size %d: expected an error reading a truncated record", size)
+		}
+		reader.Close()
+	}
+
+	// A varint that doesn't end within 5 bytes is a corrupted size
+	corrupted := append([]byte(nil), data...)
+	copy(corrupted[datafile.FileHeaderSize+HeaderSizeV2:], []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff})
+	if err := afero.WriteFile(fs, "corrupted.dat", corrupted, os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	scanner, err := NewScanner(fs, "corrupted.dat")
+	if err != nil {
+		t.Fatalf("failed to create scanner: %v", err)
+	}
+	defer scanner.Close()
+	if _, _, err := scanner.Scan(); !errors.Is(err, ErrKeyTooLarge) {
+		t.Errorf("expected ErrKeyTooLarge, got %v", err)
+	}
+}
+
+func TestChecksums(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	pairs := []kv{
+		{key: []byte("key"), value: []byte("value")},
+		{key: []byte("deleted"), value: nil},
+		// Longer than an xxHash64 stripe
+		{key: bytes.Repeat([]byte("k"), 40), value: bytes.Repeat([]byte("v"), 1000)},
+	}
+	checksums := []Checksum{ChecksumCRC32, ChecksumCRC32C, ChecksumXXHash64}
+	var files [][]byte
+	for _, checksum := range checksums {
+		path := checksum.String() + ".dat"
+		offsets := writeFormatTestFile(t, fs, path, FormatV1, checksum, pairs)
+
+		reader, err := NewReader(fs, path)
+		if err != nil {
+			t.Fatalf("failed to create reader: %v", err)
+		}
+		scanner, err := NewScanner(fs, path)
+		if err != nil {
+			t.Fatalf("failed to create scanner: %v", err)
+		}
+		if reader.Checksum() != checksum || scanner.Checksum() != checksum {
+			t.Errorf("expected %s, got %s and %s", checksum, reader.Checksum(), scanner.Checksum())
+		}
+		for i, pair := range pairs {
+			if rec, err := reader.ReadRecordAtStrict(offsets[i]); err != nil || !bytes.Equal(rec.Value, pair.value) {
+				t.Errorf("%s: record %d: unexpected record, %v", checksum, i, err)
+			}
+			if rec, _, err := scanner.Scan(); err != nil || !bytes.Equal(rec.Key, pair.key) {
+				t.Errorf("%s: record %d: unexpected scanned record, %v", checksum, i, err)
+			}
+		}
+		reader.Close()
+		scanner.Close()
+
+		data, err := afero.ReadFile(fs, path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		files = append(files, data)
+
+		// A changed byte in the value of the first record is detected
+		data[datafile.FileHeaderSize+recordHeaderSize+3] ^= 0x1
+		if err := afero.WriteFile(fs, "corrupted.dat", data, os.ModePerm); err != nil {
+			t.Fatal(err)
+		}
+		reader, err = NewReader(fs, "corrupted.dat")
+		if err != nil {
+			t.Fatalf("failed to create reader: %v", err)
+		}
+		if _, err := reader.ReadRecordAtStrict(0); !errors.Is(err, ErrCrcChecksumMismatch) {
+			t.Errorf("%s: expected ErrCrcChecksumMismatch, got %v", checksum, err)
+		}
+		reader.Close()
+		data[datafile.FileHeaderSize+recordHeaderSize+3] ^= 0x1
+	}
+
+	// The files only differ in the header and the checksums
+	if len(files[0]) != len(files[1]) || len(files[0]) != len(files[2]) {
+		t.Fatalf("expected files of the same size, got %d, %d and %d", len(files[0]), len(files[1]), len(files[2]))
+	}
+	if bytes.Equal(files[0][datafile.FileHeaderSize:], files[1][datafile.FileHeaderSize:]) ||
+		bytes.Equal(files[1][datafile.FileHeaderSize:], files[2][datafile.FileHeaderSize:]) {
+		t.Errorf("expected different checksums with each algorithm")
+	}
+}
+
+func TestFormatV2ValueType(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	writeFormatTestFile(t, fs, "v2.dat", FormatV2, ChecksumCRC32, nil)
+	writer, err := NewWriter(fs, "v2.dat")
+	if err != nil {
+		t.Fatalf("failed to create writer: %v", err)
+	}
+	offset, err := writer.WriteRecordWithHeader(Header{Timestamp: time.UnixMicro(1), RecordType: RecordTypePut, ValueType: 3}, []byte("key"), []byte("value"))
+	if err != nil {
+		t.Fatalf("failed to write record: %v", err)
+	}
+	writer.Close()
+
+	reader, err := NewReader(fs, "v2.dat")
+	if err != nil {
+		t.Fatalf("failed to create reader: %v", err)
+	}
+	defer reader.Close()
+	if rec, err := reader.ReadRecordAtStrict(offset - datafile.FileHeaderSize); err != nil || rec.Header.ValueType != 3 {
+		t.Errorf("expected value type 3, got %+v, %v", rec.Header, err)
+	}
+	scanner, err := NewScanner(fs, "v2.dat")
+	if err != nil {
+		t.Fatalf("failed to create scanner: %v", err)
+	}
+	defer scanner.Close()
+	if rec, _, err := scanner.Scan(); err != nil || rec.Header.ValueType != 3 || string(rec.Value) != "value" {
+		t.Errorf("expected value type 3, got %+v, %v", rec.Header, err)
+	}
+}
+
+func TestFormatV2NoValueType(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	// Files with v2 records written before they had the value type have the value type bit of the minor version unset,
+	// the writer appends records in the format of the file
+	writeFormatTestFile(t, fs, "v2.dat", FormatV2, ChecksumCRC32C, nil)
+	data, err := afero.ReadFile(fs, "v2.dat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[9] &^= 1 << 4
+	if err := afero.WriteFile(fs, "old.dat", data, os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	writer, err := NewWriter(fs, "old.dat")
+	if err != nil {
+		t.Fatalf("failed to create writer: %v", err)
+	}
+	if writer.Format() != FormatV2NoValueType || writer.Checksum() != ChecksumCRC32C {
+		t.Fatalf("expected v2 records without value type, got %d with %s", writer.Format(), writer.Checksum())
+	}
+	offset, err := writer.WriteRecordWithHeader(Header{Timestamp: time.UnixMicro(1), RecordType: RecordTypePut, ValueType: 3}, []byte("key"), []byte("value"))
+	if err != nil {
+		t.Fatalf("failed to write record: %v", err)
+	}
+	writer.Close()
+	if size := FormatV2NoValueType.EncodedSize(3, 5); size != FormatV2.EncodedSize(3, 5)-1 {
+		t.Errorf("expected records without value type to be 1 byte smaller, got %d", size)
+	}
+
+	reader, err := NewReader(fs, "old.dat")
+	if err != nil {
+		t.Fatalf("failed to create reader: %v", err)
+	}
+	defer reader.Close()
+	rec, err := reader.ReadRecordAtStrict(offset - datafile.FileHeaderSize)
+	if err != nil || rec.Header.ValueType != 0 || string(rec.Key) != "key" || string(rec.Value) != "value" {
+		t.Errorf("unexpected record %+v, %v", rec.Header, err)
+	}
+}
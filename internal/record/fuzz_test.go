@@ -0,0 +1,76 @@
+package record
+
+import (
+	"errors"
+	"io"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/ananthvk/kvdb/internal/datafile"
+	"github.com/spf13/afero"
+)
+
+// FuzzScanner checks that the scanner does not panic on any data file, and that every record it returns is consistent
+// with it's header. The input is the whole file, including the file header
+func FuzzScanner(f *testing.F) {
+	for _, encoding := range []datafile.Encoding{
+		{RecordFormat: datafile.RecordFormatV1, Checksum: datafile.ChecksumCRC32},
+		{RecordFormat: datafile.RecordFormatV2, Checksum: datafile.ChecksumCRC32},
+		{RecordFormat: datafile.RecordFormatV1, Checksum: datafile.ChecksumCRC32C},
+		{RecordFormat: datafile.RecordFormatV2, Checksum: datafile.ChecksumXXHash64},
+	} {
+		format := Format(encoding.RecordFormat)
+		fs := afero.NewMemMapFs()
+		if err := datafile.WriteFileHeaderWithEncoding(fs, "seed.dat", time.UnixMicro(1), encoding); err != nil {
+			f.Fatal(err)
+		}
+		writer, err := NewWriter(fs, "seed.dat")
+		if err != nil {
+			f.Fatal(err)
+		}
+		writer.WriteKeyValueWithTs([]byte("name"), []byte("kvdb"), time.UnixMicro(2))
+		writer.WriteKeyValueWithTs([]byte(""), []byte(""), time.UnixMicro(3))
+		writer.WriteTombstoneWithTs([]byte("name"), time.UnixMicro(4))
+		writer.Close()
+		seed, err := afero.ReadFile(fs, "seed.dat")
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(seed)
+		f.Add(seed[:len(seed)-1])
+		f.Add(seed[:datafile.FileHeaderSize+format.headerSize(4, 4)])
+	}
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		fs := afero.NewMemMapFs()
+		if err := afero.WriteFile(fs, "fuzz.dat", data, os.ModePerm); err != nil {
+			t.Fatal(err)
+		}
+		scanner, err := NewScanner(fs, "fuzz.dat")
+		if err != nil {
+			return
+		}
+		defer scanner.Close()
+		var expectedOffset int64
+		for {
+			rec, offset, err := scanner.Scan()
+			if err != nil {
+				if errors.Is(err, io.EOF) && datafile.FileHeaderSize+expectedOffset != int64(len(data)) {
+					t.Fatalf("io.EOF at offset %d, but the file has %d bytes", expectedOffset, len(data))
+				}
+				return
+			}
+			if offset != expectedOffset {
+				t.Fatalf("record at offset %d, expected %d", offset, expectedOffset)
+			}
+			if len(rec.Key) != int(rec.Header.KeySize) || len(rec.Value) != int(rec.Header.ValueSize) {
+				t.Fatalf("key and value sizes %d, %d do not match the header %+v", len(rec.Key), len(rec.Value), rec.Header)
+			}
+			if rec.Size != scanner.Format().EncodedSize(rec.Header.KeySize, rec.Header.ValueSize) {
+				t.Fatalf("record size %d does not match the header %+v", rec.Size, rec.Header)
+			}
+			expectedOffset += rec.Size
+		}
+	})
+}
@@ -0,0 +1,53 @@
+package record
+
+import (
+	"errors"
+	"os"
+)
+
+// errMmapUnsupported is returned by Mmap when the file can't be memory mapped, either because the platform doesn't
+// support it, or because the file is not an OS file (for example a file of an in-memory file system)
+var errMmapUnsupported = errors.New("memory mapping is not supported for this file")
+
+// fdFile is implemented by files backed by an OS file descriptor, like *os.File
+type fdFile interface {
+	Fd() uintptr
+	Stat() (os.FileInfo, error)
+}
+
+// Mmap maps the file into memory, after which reads are served from the mapping instead of with pread. The file must
+// not be written to after it's mapped, since the mapping does not grow with the file, so it's only called for
+// immutable data files. It's safe to call while the reader is used concurrently. Only the first call tries to map the
+// file, if it fails (or the file can't be mapped) the reader keeps using pread, and later calls return nil
+func (r *Reader) Mmap() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.mmapTried || r.closed {
+		return nil
+	}
+	r.mmapTried = true
+	file, ok := r.file.(fdFile)
+	if !ok {
+		return errMmapUnsupported
+	}
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	if info.Size() == 0 {
+		return nil
+	}
+	data, err := mmap(file.Fd(), info.Size())
+	if err != nil {
+		return err
+	}
+	r.data = data
+	return nil
+}
+
+// MappedBytes returns the size of the mapping of the file, 0 if it's not mapped
+func (r *Reader) MappedBytes() int64 {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return int64(len(r.data))
+}
@@ -0,0 +1,12 @@
+//go:build !unix
+
+package record
+
+// mmap is not supported on this platform, readers always use pread
+func mmap(fd uintptr, size int64) ([]byte, error) {
+	return nil, errMmapUnsupported
+}
+
+func munmap(data []byte) error {
+	return nil
+}
@@ -0,0 +1,82 @@
+package record
+
+import (
+	"errors"
+	"runtime"
+	"testing"
+
+	"github.com/spf13/afero"
+)
+
+// helperCheckReads checks that every record of testData is read by every read method of the reader
+func helperCheckReads(t *testing.T, reader *Reader) {
+	t.Helper()
+	var offset int64
+	for i, expected := range testData {
+		rec, err := reader.ReadRecordAtStrict(offset)
+		if err != nil {
+			t.Fatalf("error reading record %d: %v", i, err)
+		}
+		if string(rec.Key) != string(expected.key) || string(rec.Value) != string(expected.value) {
+			t.Errorf("record %d: expected %q=%q, got %q=%q", i, expected.key, expected.value, rec.Key, rec.Value)
+		}
+		valueRec, err := reader.ReadValueAt(offset)
+		if err != nil || string(valueRec.Value) != string(expected.value) {
+			t.Errorf("record %d: expected value %q, got %q, %v", i, expected.value, valueRec.Value, err)
+		}
+		keyRec, err := reader.ReadKeyAt(offset)
+		if err != nil || string(keyRec.Key) != string(expected.key) {
+			t.Errorf("record %d: expected key %q, got %q, %v", i, expected.key, keyRec.Key, err)
+		}
+		offset += rec.Size
+	}
+	if _, err := reader.ReadValueAt(offset); err == nil {
+		t.Errorf("expected an error reading past the last record")
+	}
+}
+
+func TestReaderMmap(t *testing.T) {
+	t.Chdir(t.TempDir())
+	fs := afero.NewOsFs()
+	reader, err := NewReader(fs, createTestFile(t, fs, testData))
+	if err != nil {
+		t.Fatalf("error creating reader: %v", err)
+	}
+	helperCheckReads(t, reader)
+	err = reader.Mmap()
+	if runtime.GOOS == "windows" || runtime.GOOS == "plan9" || runtime.GOOS == "js" || runtime.GOOS == "wasip1" {
+		if !errors.Is(err, errMmapUnsupported) || reader.MappedBytes() != 0 {
+			t.Errorf("expected errMmapUnsupported, got %v", err)
+		}
+	} else if err != nil || reader.MappedBytes() == 0 {
+		t.Fatalf("expected the file to be mapped, got %d bytes, %v", reader.MappedBytes(), err)
+	}
+	helperCheckReads(t, reader)
+	if err := reader.Mmap(); err != nil {
+		t.Errorf("expected the second call to do nothing, got %v", err)
+	}
+
+	if err := reader.Close(); err != nil {
+		t.Fatalf("error closing reader: %v", err)
+	}
+	if reader.MappedBytes() != 0 {
+		t.Errorf("expected the file to be unmapped")
+	}
+	if _, err := reader.ReadValueAt(0); err == nil {
+		t.Errorf("expected an error reading from a closed reader")
+	}
+}
+
+func TestReaderMmapUnsupported(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	reader, err := NewReader(fs, createTestFile(t, fs, testData))
+	if err != nil {
+		t.Fatalf("error creating reader: %v", err)
+	}
+	defer reader.Close()
+	if err := reader.Mmap(); !errors.Is(err, errMmapUnsupported) {
+		t.Errorf("expected errMmapUnsupported, got %v", err)
+	}
+	// The reader falls back to pread
+	helperCheckReads(t, reader)
+}
@@ -0,0 +1,17 @@
+//go:build unix
+
+package record
+
+import "syscall"
+
+// mmap maps size bytes of the file read only
+func mmap(fd uintptr, size int64) ([]byte, error) {
+	if int64(int(size)) != size {
+		return nil, errMmapUnsupported
+	}
+	return syscall.Mmap(int(fd), 0, int(size), syscall.PROT_READ, syscall.MAP_SHARED)
+}
+
+func munmap(data []byte) error {
+	return syscall.Munmap(data)
+}
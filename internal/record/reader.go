@@ -1,55 +1,118 @@
 package record
 
 import (
+	"bytes"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"hash"
-	"hash/crc32"
+	"io"
 	"os"
-	"time"
+	"slices"
+	"sync"
 
-	"github.com/ananthvk/kvdb/internal/constants"
 	"github.com/ananthvk/kvdb/internal/datafile"
 	"github.com/spf13/afero"
 )
 
 // Reader is responsible for reading log records from a file. This implementation uses ReadAt (that uses pread internally on supported files)
-// and hence is safe to access concurrently
+// and hence is safe to access concurrently. Once Mmap is called, reads are served from a memory mapping of the file instead
 type Reader struct {
-	fs   afero.Fs
-	file afero.File
+	fs       afero.Fs
+	file     afero.File
+	limits   Limits
+	format   Format
+	checksum Checksum
+	// Records are decrypted with keyring if encrypted is set (by the file header), see cipher.go
+	encrypted bool
+	keyring   *Keyring
+	// mu guards the mapping, reads hold it for reading so that Close does not unmap it while it's being copied from
+	mu        sync.RWMutex
+	data      []byte
+	mmapTried bool
+	closed    bool
 }
 
 // NewReader creates a new Record Reader that opens a file at the specified path for reading log records.
-// It starts reading from the 19th byte in the file (To skip the header)
+// It starts reading from the 19th byte in the file (To skip the header). The format of the records is read from the
+// file header, along with the algorithm of their checksums
 func NewReader(fs afero.Fs, path string) (*Reader, error) {
 	file, err := fs.OpenFile(path, os.O_RDONLY, 0666)
 	if err != nil {
 		return nil, err
 	}
+	format, checksum, encrypted, err := readEncoding(file)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
 	return &Reader{
-		fs:   fs,
-		file: file,
+		fs:        fs,
+		file:      file,
+		limits:    DefaultLimits,
+		format:    format,
+		checksum:  checksum,
+		encrypted: encrypted,
 	}, nil
 }
 
+// Format returns the format of the records of the file
+func (r *Reader) Format() Format {
+	return r.format
+}
+
+// Checksum returns the algorithm of the checksums of the records of the file
+func (r *Reader) Checksum() Checksum {
+	return r.checksum
+}
+
+// Encrypted returns true if the records of the file are encrypted
+func (r *Reader) Encrypted() bool {
+	return r.encrypted
+}
+
+// EncodedSize returns the number of bytes taken by a record with the given key and value sizes in the file
+func (r *Reader) EncodedSize(keySize, valueSize uint32) int64 {
+	return r.format.encodedSize(keySize, valueSize, r.encrypted)
+}
+
+// SetKeyring sets the keys the records are decrypted with, reads of an encrypted file fail with ErrNoKey without it. It
+// must be called before the reader is used concurrently
+func (r *Reader) SetKeyring(keyring *Keyring) {
+	r.keyring = keyring
+}
+
+// SetLimits sets the largest key and value sizes accepted in a record header, it must be called before the reader is
+// used concurrently
+func (r *Reader) SetLimits(limits Limits) {
+	r.limits = limits
+}
+
 // ReadValueAt reads a record at the given offset (from the start of the first record).
 // It only reads and populates the value in the returned record. Key is left empty.
 func (r *Reader) ReadValueAt(offset int64) (*Record, error) {
+	if r.encrypted {
+		record, err := r.readSealed(nil, offset)
+		if err != nil {
+			return nil, err
+		}
+		record.Key = nil
+		return record, nil
+	}
 	currentOffset := offset + datafile.FileHeaderSize
-	header, err := r.readHeader(nil, currentOffset)
+	header, headerSize, err := r.readHeader(nil, currentOffset)
 	if err != nil {
 		return nil, err
 	}
-	currentOffset += recordHeaderSize
+	currentOffset += int64(headerSize)
 	record := &Record{
-		Header: *header,
+		Header: header,
 		Value:  make([]byte, header.ValueSize),
-		Size:   int64(recordHeaderSize + header.KeySize + header.ValueSize + 4),
+		Size:   r.format.EncodedSize(header.KeySize, header.ValueSize),
 	}
 	// Skip over the key
 	currentOffset += int64(header.KeySize)
-	n, err := r.file.ReadAt(record.Value, currentOffset)
+	n, err := r.readAt(record.Value, currentOffset)
 	if err != nil {
 		return nil, err
 	}
@@ -59,21 +122,87 @@ func (r *Reader) ReadValueAt(offset int64) (*Record, error) {
 	return record, nil
 }
 
+// ReadValueInto is like ReadValueAt, but reads the value into dst, which is grown if it's too small, and returns the
+// header of the record with the value. It does not allocate if dst is large enough
+func (r *Reader) ReadValueInto(offset int64, dst []byte) (Header, []byte, error) {
+	if r.encrypted {
+		record, err := r.readSealed(nil, offset)
+		if err != nil {
+			return Header{}, dst[:0], err
+		}
+		return record.Header, append(dst[:0], record.Value...), nil
+	}
+	currentOffset := offset + datafile.FileHeaderSize
+	header, headerSize, err := r.readHeader(nil, currentOffset)
+	if err != nil {
+		return Header{}, dst, err
+	}
+	// Skip over the header and the key
+	currentOffset += int64(headerSize) + int64(header.KeySize)
+	dst = slices.Grow(dst[:0], int(header.ValueSize))[:header.ValueSize]
+	n, err := r.readAt(dst, currentOffset)
+	if err != nil {
+		return Header{}, dst[:0], err
+	}
+	if n != int(header.ValueSize) {
+		return Header{}, dst[:0], fmt.Errorf("expected to read %d bytes for value, got %d", header.ValueSize, n)
+	}
+	return header, dst, nil
+}
+
+// ValueReader returns a reader of the value of the record at the given offset (from the start of the first record),
+// without reading the value. The reader reads from the file of r, so it fails once r is closed. The value of an
+// encrypted record is read and decrypted first, and the reader reads from memory
+func (r *Reader) ValueReader(offset int64) (*io.SectionReader, error) {
+	if r.encrypted {
+		record, err := r.readSealed(nil, offset)
+		if err != nil {
+			return nil, err
+		}
+		return io.NewSectionReader(bytes.NewReader(record.Value), 0, int64(len(record.Value))), nil
+	}
+	currentOffset := offset + datafile.FileHeaderSize
+	header, headerSize, err := r.readHeader(nil, currentOffset)
+	if err != nil {
+		return nil, err
+	}
+	// Skip over the header and the key
+	currentOffset += int64(headerSize) + int64(header.KeySize)
+	return io.NewSectionReader(readerAt{r}, currentOffset, int64(header.ValueSize)), nil
+}
+
+// readerAt is an io.ReaderAt over the file (or mapping) of a reader
+type readerAt struct {
+	r *Reader
+}
+
+func (ra readerAt) ReadAt(buf []byte, offset int64) (int, error) {
+	return ra.r.readAt(buf, offset)
+}
+
 // ReadKeyAt reads a record at the given offset (from the start of the first record).
 // It only reads and populates the key in the returned record. Value is left empty.
 func (r *Reader) ReadKeyAt(offset int64) (*Record, error) {
+	if r.encrypted {
+		record, err := r.readSealed(nil, offset)
+		if err != nil {
+			return nil, err
+		}
+		record.Value = nil
+		return record, nil
+	}
 	currentOffset := offset + datafile.FileHeaderSize
-	header, err := r.readHeader(nil, currentOffset)
+	header, headerSize, err := r.readHeader(nil, currentOffset)
 	if err != nil {
 		return nil, err
 	}
-	currentOffset += recordHeaderSize
+	currentOffset += int64(headerSize)
 	record := &Record{
-		Header: *header,
+		Header: header,
 		Key:    make([]byte, header.KeySize),
-		Size:   int64(recordHeaderSize + header.KeySize + header.ValueSize + 4),
+		Size:   r.format.EncodedSize(header.KeySize, header.ValueSize),
 	}
-	n, err := r.file.ReadAt(record.Key, currentOffset)
+	n, err := r.readAt(record.Key, currentOffset)
 	if err != nil {
 		return nil, err
 	}
@@ -86,20 +215,23 @@ func (r *Reader) ReadKeyAt(offset int64) (*Record, error) {
 // ReadRecordAt reads a record at the given offset (from the start of the first record).
 // It reads both the key and value from the file, and both the Key and Value in the returned record are valid.
 func (r *Reader) ReadRecordAt(offset int64) (*Record, error) {
+	if r.encrypted {
+		return r.readSealed(nil, offset)
+	}
 	currentOffset := offset + datafile.FileHeaderSize
-	header, err := r.readHeader(nil, currentOffset)
+	header, headerSize, err := r.readHeader(nil, currentOffset)
 	if err != nil {
 		return nil, err
 	}
-	currentOffset += recordHeaderSize
+	currentOffset += int64(headerSize)
 	record := &Record{
-		Header: *header,
+		Header: header,
 		Key:    make([]byte, header.KeySize),
 		Value:  make([]byte, header.ValueSize),
-		Size:   int64(recordHeaderSize + header.KeySize + header.ValueSize + 4),
+		Size:   r.format.EncodedSize(header.KeySize, header.ValueSize),
 	}
 
-	n, err := r.file.ReadAt(record.Key, currentOffset)
+	n, err := r.readAt(record.Key, currentOffset)
 	if err != nil {
 		return nil, err
 	}
@@ -107,7 +239,7 @@ func (r *Reader) ReadRecordAt(offset int64) (*Record, error) {
 		return nil, fmt.Errorf("expected to read %d bytes for key, got %d", header.KeySize, n)
 	}
 	currentOffset += int64(header.KeySize)
-	n, err = r.file.ReadAt(record.Value, currentOffset)
+	n, err = r.readAt(record.Value, currentOffset)
 	if err != nil {
 		return nil, err
 	}
@@ -119,25 +251,28 @@ func (r *Reader) ReadRecordAt(offset int64) (*Record, error) {
 
 // ReadRecordAtStrict reads a record at the given offset (from the start of the first record).
 // It reads both the key and value from the file, and both the Key and Value in the returned record are valid.
-// It also verifies if the record is valid by computing the CRC checksum
+// It also verifies if the record is valid by computing it's checksum
 func (r *Reader) ReadRecordAtStrict(offset int64) (*Record, error) {
+	if r.encrypted {
+		return r.readSealed(r.checksum.newHash(), offset)
+	}
 	currentOffset := offset + datafile.FileHeaderSize
 
-	h := crc32.NewIEEE()
-	header, err := r.readHeader(h, currentOffset)
+	h := r.checksum.newHash()
+	header, headerSize, err := r.readHeader(h, currentOffset)
 	if err != nil {
 		return nil, err
 	}
-	currentOffset += recordHeaderSize
+	currentOffset += int64(headerSize)
 
 	record := &Record{
-		Header: *header,
+		Header: header,
 		Key:    make([]byte, header.KeySize),
 		Value:  make([]byte, header.ValueSize),
-		Size:   int64(recordHeaderSize + header.KeySize + header.ValueSize + 4),
+		Size:   r.format.EncodedSize(header.KeySize, header.ValueSize),
 	}
 
-	n, err := r.file.ReadAt(record.Key, currentOffset)
+	n, err := r.readAt(record.Key, currentOffset)
 	if err != nil {
 		return nil, err
 	}
@@ -147,7 +282,7 @@ func (r *Reader) ReadRecordAtStrict(offset int64) (*Record, error) {
 	currentOffset += int64(header.KeySize)
 	h.Write(record.Key)
 
-	n, err = r.file.ReadAt(record.Value, currentOffset)
+	n, err = r.readAt(record.Value, currentOffset)
 	if err != nil {
 		return nil, err
 	}
@@ -160,7 +295,7 @@ func (r *Reader) ReadRecordAtStrict(offset int64) (*Record, error) {
 	crc := h.Sum32()
 
 	var buf [4]byte
-	if _, err := r.file.ReadAt(buf[0:4], currentOffset); err != nil {
+	if _, err := r.readAt(buf[0:4], currentOffset); err != nil {
 		return nil, err
 	}
 
@@ -172,41 +307,124 @@ func (r *Reader) ReadRecordAtStrict(offset int64) (*Record, error) {
 	return record, nil
 }
 
-// Close closes the underlying file
+// readSealed reads the encrypted record at the given offset (from the start of the first record), and decrypts it's
+// key and value. If h is not nil, the checksum of the record is verified with it
+func (r *Reader) readSealed(h hash.Hash32, offset int64) (*Record, error) {
+	if r.keyring == nil {
+		return nil, ErrNoKey
+	}
+	currentOffset := offset + datafile.FileHeaderSize
+	header, headerSize, err := r.readHeader(h, currentOffset)
+	if err != nil {
+		return nil, err
+	}
+	currentOffset += int64(headerSize)
+	sealedSize := int(header.KeySize) + int(header.ValueSize) + EncryptionOverhead
+	buf := make([]byte, sealedSize, sealedSize+4)
+	if h != nil {
+		buf = buf[:sealedSize+4]
+	}
+	n, err := r.readAt(buf, currentOffset)
+	if n != len(buf) {
+		if err == nil {
+			err = fmt.Errorf("expected to read %d bytes for the encrypted key and value, got %d", len(buf), n)
+		}
+		return nil, err
+	}
+	sealed := buf[:sealedSize]
+	if h != nil {
+		h.Write(sealed)
+		if binary.LittleEndian.Uint32(buf[sealedSize:]) != h.Sum32() {
+			return nil, ErrCrcChecksumMismatch
+		}
+	}
+	// The header is the additional data of the message, it's encoded again instead of being kept from the read
+	var additional [recordHeaderSize]byte
+	m := r.format.encodeHeader(additional[:], &header)
+	plain, err := r.keyring.Open(make([]byte, 0, header.KeySize+header.ValueSize), sealed, additional[:m])
+	if err != nil {
+		return nil, err
+	}
+	return &Record{
+		Header: header,
+		Key:    plain[:header.KeySize:header.KeySize],
+		Value:  plain[header.KeySize:],
+		Size:   r.EncodedSize(header.KeySize, header.ValueSize),
+	}, nil
+}
+
+// Close unmaps the file (if it was mapped), and closes the underlying file
 func (r *Reader) Close() error {
-	return r.file.Close()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		return os.ErrClosed
+	}
+	r.closed = true
+	var err error
+	if r.data != nil {
+		err = munmap(r.data)
+		r.data = nil
+	}
+	return errors.Join(err, r.file.Close())
 }
 
-// readHeader reads a record header from the given offset
-func (r *Reader) readHeader(h hash.Hash32, offset int64) (*Header, error) {
-	var headerBuf [recordHeaderSize]byte
-	n, err := r.file.ReadAt(headerBuf[:], offset)
-	if err != nil {
-		return nil, err
+// readAt reads len(buf) bytes from the offset, from the mapping if the file is mapped. It behaves like io.ReaderAt, and
+// returns os.ErrClosed once the reader is closed, whatever the file system
+func (r *Reader) readAt(buf []byte, offset int64) (int, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if r.closed {
+		return 0, os.ErrClosed
+	}
+	if r.data == nil {
+		return r.file.ReadAt(buf, offset)
+	}
+	if offset < 0 {
+		return 0, fmt.Errorf("negative offset %d", offset)
 	}
-	if n != recordHeaderSize {
-		return nil, fmt.Errorf("expected to read %d bytes, got %d", recordHeaderSize, n)
+	if offset >= int64(len(r.data)) {
+		return 0, io.EOF
 	}
+	n := copy(buf, r.data[offset:])
+	if n < len(buf) {
+		return n, io.EOF
+	}
+	return n, nil
+}
 
-	// Decode header data from the buffer
-	header := &Header{}
-	header.Timestamp = time.UnixMicro(int64(binary.LittleEndian.Uint64(headerBuf[0:])))
-	header.KeySize = binary.LittleEndian.Uint32(headerBuf[8:])
-	header.ValueSize = binary.LittleEndian.Uint32(headerBuf[12:])
-	header.RecordType = headerBuf[16]
-	header.ValueType = headerBuf[17]
+// Buffers for record headers, a buffer passed to ReadAt escapes to the heap, so they are pooled instead of being
+// allocated on every read
+var headerBufPool = sync.Pool{
+	New: func() any { return new([recordHeaderSize]byte) },
+}
 
-	// Check if key / value size are within the set maximum values
-	if header.KeySize > constants.MaxKeySize {
-		return nil, ErrKeyTooLarge
+// readHeader reads a record header from the given offset, and returns it with it's size
+func (r *Reader) readHeader(h hash.Hash32, offset int64) (Header, int, error) {
+	headerBuf := headerBufPool.Get().(*[recordHeaderSize]byte)
+	defer headerBufPool.Put(headerBuf)
+	buf := headerBuf[:r.format.maxHeaderSize()]
+	// v2 headers are shorter than the buffer, so the read can end at the end of the file after the header
+	n, readErr := r.readAt(buf, offset)
+	header, size, err := r.format.decodeHeader(buf[:n])
+	if errors.Is(err, errShortHeader) {
+		if readErr != nil {
+			return Header{}, 0, readErr
+		}
+		return Header{}, 0, fmt.Errorf("expected to read %d bytes, got %d", len(buf), n)
 	}
-	if header.ValueSize > constants.MaxValueSize {
-		return nil, ErrValueTooLarge
+	if err != nil {
+		return Header{}, 0, err
+	}
+
+	// Check if key / value size are within the set maximum values
+	if err := r.limits.Check(header.KeySize, header.ValueSize); err != nil {
+		return Header{}, 0, err
 	}
 
 	if h != nil {
-		h.Write(headerBuf[:])
+		h.Write(buf[:size])
 	}
 
-	return header, nil
+	return header, size, nil
 }
@@ -1,20 +1,246 @@
 package record
 
-import "time"
+import (
+	"encoding/binary"
+	"hash"
+	"hash/crc32"
+	"io"
+	"math"
+	"time"
+
+	"github.com/ananthvk/kvdb/internal/constants"
+	"github.com/ananthvk/kvdb/internal/datafile"
+	"github.com/ananthvk/kvdb/internal/xxhash"
+)
 
 const (
 	recordHeaderSize = 20
-	recordTypePut    = 0x50
+	RecordTypePut    = 0x50
 	RecordTypeDelete = 0x44
+	// A put of a write-once key, the key can't be overwritten or deleted once it has been written
+	RecordTypePutImmutable = 0x49
+)
+
+// IsPut returns true if the record type is a put (of a normal or a write-once key)
+func IsPut(recordType uint8) bool {
+	return recordType == RecordTypePut || recordType == RecordTypePutImmutable
+}
+
+// HeaderSize is the size of the v1 record header (timestamp, key size, value size, record type, value type and two
+// reserved bytes) in bytes
+const HeaderSize = recordHeaderSize
+
+// HeaderSizeV2 is the size of the fixed part of the v2 record header (timestamp, record type and value type), it's
+// followed by the key size and value size as uvarints. The header is 12 bytes for keys and values shorter than 128
+// bytes, instead of 20
+const HeaderSizeV2 = 10
+
+// Size of the fixed part of the header of v2 records written before they had the value type
+const headerSizeV2NoValueType = HeaderSizeV2 - 1
+
+// Format is the encoding of the records of a data file. Readers, scanners and writers find the format of a file from the
+// minor version in it's header, see datafile.ReadEncoding
+type Format int
+
+const (
+	FormatV1 Format = datafile.RecordFormatV1
+	FormatV2 Format = datafile.RecordFormatV2
+	// FormatV2NoValueType is the format of files with v2 records written before v2 records had the value type
+	FormatV2NoValueType Format = datafile.RecordFormatV2NoValueType
+)
+
+// fixedHeaderSize returns the size of the fixed part of the v2 record header, 0 for other formats
+func (f Format) fixedHeaderSize() int {
+	switch f {
+	case FormatV2:
+		return HeaderSizeV2
+	case FormatV2NoValueType:
+		return headerSizeV2NoValueType
+	}
+	return 0
+}
+
+// Checksum is the algorithm of the checksums at the end of the records of a data file, it's read from the file header
+// along with the format. The checksum is 4 bytes with every algorithm
+type Checksum int
+
+const (
+	// ChecksumCRC32 is CRC32 with the IEEE polynomial, used by every file written before the algorithm could be chosen
+	ChecksumCRC32 Checksum = datafile.ChecksumCRC32
+	// ChecksumCRC32C is CRC32 with the Castagnoli polynomial, which has hardware support on most CPUs
+	ChecksumCRC32C Checksum = datafile.ChecksumCRC32C
+	// ChecksumXXHash64 is the low 32 bits of the xxHash64 of the record
+	ChecksumXXHash64 Checksum = datafile.ChecksumXXHash64
 )
 
+var castagnoliTable = crc32.MakeTable(crc32.Castagnoli)
+
+// newHash returns a hash that computes the checksum of a record
+func (c Checksum) newHash() hash.Hash32 {
+	switch c {
+	case ChecksumCRC32C:
+		return crc32.New(castagnoliTable)
+	case ChecksumXXHash64:
+		return xxhash.New()
+	}
+	return crc32.NewIEEE()
+}
+
+func (c Checksum) String() string {
+	switch c {
+	case ChecksumCRC32:
+		return "crc32"
+	case ChecksumCRC32C:
+		return "crc32c"
+	case ChecksumXXHash64:
+		return "xxhash64"
+	}
+	return "unknown"
+}
+
+// readEncoding returns the format and the checksum algorithm of the records of a data file, and whether they are
+// encrypted
+func readEncoding(file io.ReaderAt) (Format, Checksum, bool, error) {
+	encoding, err := datafile.ReadEncoding(file)
+	if err != nil {
+		return 0, 0, false, err
+	}
+	return Format(encoding.RecordFormat), Checksum(encoding.Checksum), encoding.Cipher != datafile.CipherNone, nil
+}
+
+// headerSize returns the size of the header of a record with the given key and value sizes
+func (f Format) headerSize(keySize, valueSize uint32) int {
+	if fixed := f.fixedHeaderSize(); fixed != 0 {
+		return fixed + uvarintSize(keySize) + uvarintSize(valueSize)
+	}
+	return recordHeaderSize
+}
+
+// maxHeaderSize returns the size of the largest record header
+func (f Format) maxHeaderSize() int {
+	if fixed := f.fixedHeaderSize(); fixed != 0 {
+		return fixed + 2*binary.MaxVarintLen32
+	}
+	return recordHeaderSize
+}
+
+// EncodedSize returns the number of bytes taken by a record with the given key and value sizes in a data file
+func (f Format) EncodedSize(keySize, valueSize uint32) int64 {
+	return int64(f.headerSize(keySize, valueSize)) + int64(keySize) + int64(valueSize) + 4
+}
+
+// encodedSize is like EncodedSize, for a record that's encrypted if encrypted is true
+func (f Format) encodedSize(keySize, valueSize uint32, encrypted bool) int64 {
+	if encrypted {
+		return f.EncodedSize(keySize, valueSize) + EncryptionOverhead
+	}
+	return f.EncodedSize(keySize, valueSize)
+}
+
+// encodeHeader writes the record header to buf, which must be at least maxHeaderSize bytes, and returns it's size
+func (f Format) encodeHeader(buf []byte, h *Header) int {
+	binary.LittleEndian.PutUint64(buf[0:], uint64(h.Timestamp.UnixMicro())) // Unix timestamp (in microseconds)
+	if fixed := f.fixedHeaderSize(); fixed != 0 {
+		buf[8] = h.RecordType
+		if f == FormatV2 {
+			buf[9] = h.ValueType
+		}
+		n := fixed
+		n += binary.PutUvarint(buf[n:], uint64(h.KeySize))
+		n += binary.PutUvarint(buf[n:], uint64(h.ValueSize))
+		return n
+	}
+	binary.LittleEndian.PutUint32(buf[8:], h.KeySize)    // Length of key
+	binary.LittleEndian.PutUint32(buf[12:], h.ValueSize) // Length of value
+	buf[16] = h.RecordType                               // Type of record, 0x50 for PUT, 0x49 for write-once PUT, and 0x44 for DELETE
+	buf[17] = h.ValueType                                // Encoding of the value, 0x0 if it's not known
+	buf[18] = 0x0                                        // Reserved
+	buf[19] = 0x0                                        // Reserved
+	return recordHeaderSize
+}
+
+// decodeHeader decodes the record header at the start of buf, and returns it's size. It returns errShortHeader if buf
+// ends before the header does
+func (f Format) decodeHeader(buf []byte) (Header, int, error) {
+	var header Header
+	if fixed := f.fixedHeaderSize(); fixed != 0 {
+		if len(buf) < fixed {
+			return header, 0, errShortHeader
+		}
+		header.Timestamp = time.UnixMicro(int64(binary.LittleEndian.Uint64(buf[0:])))
+		header.RecordType = buf[8]
+		if f == FormatV2 {
+			header.ValueType = buf[9]
+		}
+		n := fixed
+		// A size that doesn't fit in 32 bits is corruption, the same as a size above the limits. The sizes are decoded
+		// into an array instead of through pointers to the header, so that the header does not escape to the heap
+		var sizes [2]uint32
+		for i, errTooLarge := range [2]error{ErrKeyTooLarge, ErrValueTooLarge} {
+			encoded := buf[n:min(len(buf), n+binary.MaxVarintLen32)]
+			v, m := binary.Uvarint(encoded)
+			if m == 0 && len(encoded) < binary.MaxVarintLen32 {
+				return header, 0, errShortHeader
+			}
+			if m <= 0 || v > math.MaxUint32 {
+				return header, 0, errTooLarge
+			}
+			sizes[i] = uint32(v)
+			n += m
+		}
+		header.KeySize, header.ValueSize = sizes[0], sizes[1]
+		return header, n, nil
+	}
+	if len(buf) < recordHeaderSize {
+		return header, 0, errShortHeader
+	}
+	header.Timestamp = time.UnixMicro(int64(binary.LittleEndian.Uint64(buf[0:])))
+	header.KeySize = binary.LittleEndian.Uint32(buf[8:])
+	header.ValueSize = binary.LittleEndian.Uint32(buf[12:])
+	header.RecordType = buf[16]
+	header.ValueType = buf[17]
+	return header, recordHeaderSize, nil
+}
+
+// uvarintSize returns the number of bytes of v as a uvarint
+func uvarintSize(v uint32) int {
+	n := 1
+	for v >= 0x80 {
+		v >>= 7
+		n++
+	}
+	return n
+}
+
+// Limits are the largest key and value sizes (in bytes) that are written, or accepted when a record is read. Sizes
+// above the limits in a record that's read are treated as corruption of the header
+type Limits struct {
+	MaxKeySize   int
+	MaxValueSize int
+}
+
+// DefaultLimits are used by writers, readers and scanners unless SetLimits is called
+var DefaultLimits = Limits{MaxKeySize: constants.MaxKeySize, MaxValueSize: constants.MaxValueSize}
+
+// Check returns ErrKeyTooLarge or ErrValueTooLarge if a size is above the limits
+func (l Limits) Check(keySize, valueSize uint32) error {
+	if int64(keySize) > int64(l.MaxKeySize) {
+		return ErrKeyTooLarge
+	}
+	if int64(valueSize) > int64(l.MaxValueSize) {
+		return ErrValueTooLarge
+	}
+	return nil
+}
+
 // Header contains metadata information about a log record
 //
 // Timestamp represents the time when the record was created or last modified.
 // KeySize specifies the size in bytes of the record's key.
 // ValueSize specifies the size in bytes of the record's value.
 // RecordType indicates the type of operation (e.g., insert, update, delete).
-// ValueType indicates the data type of the value (e.g., string, integer, blob). Currently it's set to 0x0
+// ValueType indicates the encoding of the value (e.g., string, hash, list), it's set by the datastore. It's 0x0 for
+// records written before the datastore set it (and for FormatV2NoValueType records, which do not store it)
 type Header struct {
 	Timestamp  time.Time
 	KeySize    uint32
@@ -34,7 +260,7 @@ type Record struct {
 }
 
 // newRecord returns a Record given the key, value and record type. The time of creation, key size and value size are set when this
-// function is called
+// function is called. Size is the size of a v1 record
 func newRecord(key []byte, value []byte, recordType uint8) *Record {
 	return &Record{
 		Header: Header{
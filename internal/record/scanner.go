@@ -3,20 +3,23 @@ package record
 import (
 	"bufio"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"hash"
-	"hash/crc32"
 	"io"
 	"os"
-	"time"
 
-	"github.com/ananthvk/kvdb/internal/constants"
 	"github.com/ananthvk/kvdb/internal/datafile"
+	"github.com/ananthvk/kvdb/internal/vfs"
 	"github.com/spf13/afero"
 )
 
 const readerBufferSize = 4 * 1000 * 1000 // 4 MB
 
+// The shared buffer grows by at most scanChunkSize bytes at a time while a key or value is read, so that a corrupted
+// size in a record header can't allocate much more memory than the data that's actually in the file
+const scanChunkSize = 64 * 1024
+
 // Scanner sequentially reads records from the given file. It internally uses
 // a buffered reader to improve performance. This is not meant to be used in Get operation, and is
 // intended to be used for Merge (or other sequential scans of the datafile)
@@ -29,36 +32,81 @@ type Scanner struct {
 	headerBuf    [recordHeaderSize]byte
 	crcHash      hash.Hash32
 	sharedBuffer []byte
+	limits       Limits
+	format       Format
+	checksum     Checksum
+	// Records are decrypted with keyring into plainBuffer if encrypted is set (by the file header), see cipher.go
+	encrypted   bool
+	keyring     *Keyring
+	plainBuffer []byte
 }
 
+// NewScanner creates a scanner that reads the records of the data file at the given path, in the format and with the
+// checksum algorithm given by it's header
 func NewScanner(fs afero.Fs, path string) (*Scanner, error) {
 	file, err := fs.OpenFile(path, os.O_RDONLY, 0666)
 	if err != nil {
 		return nil, err
 	}
+	format, checksum, encrypted, err := readEncoding(file)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	// The file is read from start to end, a larger read ahead helps, but is only a hint
+	vfs.New(fs).Advise(file, 0, 0, vfs.AdviceSequential)
 	reader := bufio.NewReaderSize(file, readerBufferSize)
 	// Skip the file header
 	_, err = reader.Discard(datafile.FileHeaderSize)
 	if err != nil {
+		file.Close()
 		return nil, err
 	}
 
-	// Max key size + Max value size + 4 bytes (padding) + a few bytes extra for safety
-	const maxRecordSize = constants.MaxKeySize + constants.MaxValueSize + 128
-
 	return &Scanner{
-		fs:           fs,
-		file:         file,
-		reader:       reader,
-		crcHash:      crc32.NewIEEE(),
-		sharedBuffer: make([]byte, maxRecordSize),
+		fs:        fs,
+		file:      file,
+		reader:    reader,
+		crcHash:   checksum.newHash(),
+		limits:    DefaultLimits,
+		format:    format,
+		checksum:  checksum,
+		encrypted: encrypted,
 	}, nil
 }
 
+// Format returns the format of the records of the file
+func (scanner *Scanner) Format() Format {
+	return scanner.format
+}
+
+// Checksum returns the algorithm of the checksums of the records of the file
+func (scanner *Scanner) Checksum() Checksum {
+	return scanner.checksum
+}
+
+// Encrypted returns true if the records of the file are encrypted
+func (scanner *Scanner) Encrypted() bool {
+	return scanner.encrypted
+}
+
+// SetKeyring sets the keys the records are decrypted with, Scan fails with ErrNoKey on an encrypted file without it
+func (scanner *Scanner) SetKeyring(keyring *Keyring) {
+	scanner.keyring = keyring
+}
+
+// SetLimits sets the largest key and value sizes accepted in a record header
+func (scanner *Scanner) SetLimits(limits Limits) {
+	scanner.limits = limits
+}
+
 // Scan returns the next record, the offset for the start of the record (from the first record)
 // Note: They Key & Value inside record are backed by a shared buffer, and hence it'll be overwritten the next time
 // Scan is called. If you need the record key / value later, make a copy
 func (scanner *Scanner) Scan() (Record, int64, error) {
+	if scanner.encrypted && scanner.keyring == nil {
+		return Record{}, 0, ErrNoKey
+	}
 	scanner.crcHash.Reset()
 	recordOffset := scanner.offset
 	header, err := scanner.readHeader(scanner.crcHash)
@@ -66,70 +114,104 @@ func (scanner *Scanner) Scan() (Record, int64, error) {
 		return Record{}, 0, err
 	}
 
-	keyStart := 0
-	keyEnd := keyStart + int(header.KeySize)
-
-	valStart := keyEnd
-	valEnd := valStart + int(header.ValueSize)
-
-	record := Record{
-		Header: header,
-		Key:    scanner.sharedBuffer[keyStart:keyEnd],
-		Value:  scanner.sharedBuffer[valStart:valEnd],
-		Size:   int64(recordHeaderSize + header.KeySize + header.ValueSize + 4),
+	keyEnd := int(header.KeySize)
+	valEnd := keyEnd + int(header.ValueSize)
+	payloadEnd := valEnd
+	if scanner.encrypted {
+		payloadEnd += EncryptionOverhead
 	}
-
-	if _, err = io.ReadFull(scanner.reader, record.Key); err != nil {
+	if err := scanner.readShared(payloadEnd); err != nil {
 		return Record{}, 0, err
 	}
-	scanner.crcHash.Write(record.Key)
-	if _, err = io.ReadFull(scanner.reader, record.Value); err != nil {
-		return Record{}, 0, err
+
+	record := Record{
+		Header: header,
+		Key:    scanner.sharedBuffer[:keyEnd],
+		Value:  scanner.sharedBuffer[keyEnd:valEnd],
+		Size:   scanner.format.encodedSize(header.KeySize, header.ValueSize, scanner.encrypted),
 	}
-	scanner.crcHash.Write(record.Value)
+	scanner.crcHash.Write(scanner.sharedBuffer[:payloadEnd])
 
 	// Check CRC
 	crc := scanner.crcHash.Sum32()
 	if _, err := io.ReadFull(scanner.reader, scanner.headerBuf[0:4]); err != nil {
+		if errors.Is(err, io.EOF) {
+			return Record{}, 0, io.ErrUnexpectedEOF
+		}
 		return Record{}, 0, err
 	}
 	fileCrc := binary.LittleEndian.Uint32(scanner.headerBuf[0:4])
 	if fileCrc != crc {
 		return Record{}, 0, ErrCrcChecksumMismatch
 	}
+	if scanner.encrypted {
+		// The record is complete, so a record that can't be decrypted is not a torn write
+		var additional [recordHeaderSize]byte
+		n := scanner.format.encodeHeader(additional[:], &header)
+		scanner.plainBuffer, err = scanner.keyring.Open(scanner.plainBuffer[:0], scanner.sharedBuffer[:payloadEnd], additional[:n])
+		if err != nil {
+			return Record{}, 0, err
+		}
+		record.Key = scanner.plainBuffer[:keyEnd]
+		record.Value = scanner.plainBuffer[keyEnd:valEnd]
+	}
 	scanner.offset += record.Size
 	return record, recordOffset, nil
 }
 
+// readShared reads the next n bytes into the start of the shared buffer, growing it as the data is read
+func (scanner *Scanner) readShared(n int) error {
+	for pos := 0; pos < n; {
+		next := min(n, pos+scanChunkSize)
+		if len(scanner.sharedBuffer) < next {
+			scanner.sharedBuffer = append(scanner.sharedBuffer, make([]byte, next-len(scanner.sharedBuffer))...)
+		}
+		if _, err := io.ReadFull(scanner.reader, scanner.sharedBuffer[pos:next]); err != nil {
+			if errors.Is(err, io.EOF) {
+				// The record header was read, so the record is truncated, same as when only a part of the key or
+				// value is there
+				return io.ErrUnexpectedEOF
+			}
+			return err
+		}
+		pos = next
+	}
+	return nil
+}
+
 // readHeader reads a record header at the current position
 func (scanner *Scanner) readHeader(h hash.Hash32) (Header, error) {
-	n, err := io.ReadFull(scanner.reader, scanner.headerBuf[:])
+	// The size of a v2 header is only known once it's decoded, so the largest header is peeked, and only the bytes of
+	// the header are consumed
+	buf, peekErr := scanner.reader.Peek(scanner.format.maxHeaderSize())
+	if len(buf) == 0 && peekErr != nil {
+		return Header{}, peekErr
+	}
+	header, size, err := scanner.format.decodeHeader(buf)
+	if errors.Is(err, errShortHeader) {
+		if errors.Is(peekErr, io.EOF) {
+			return Header{}, io.ErrUnexpectedEOF
+		}
+		if peekErr != nil {
+			return Header{}, peekErr
+		}
+		return Header{}, fmt.Errorf("expected to read %d bytes, got %d", scanner.format.maxHeaderSize(), len(buf))
+	}
 	if err != nil {
 		return Header{}, err
 	}
-	if n != recordHeaderSize {
-		return Header{}, fmt.Errorf("expected to read %d bytes, got %d", recordHeaderSize, n)
-	}
-
-	// Decode header data from the buffer
-	header := Header{}
-	header.Timestamp = time.UnixMicro(int64(binary.LittleEndian.Uint64(scanner.headerBuf[0:])))
-	header.KeySize = binary.LittleEndian.Uint32(scanner.headerBuf[8:])
-	header.ValueSize = binary.LittleEndian.Uint32(scanner.headerBuf[12:])
-	header.RecordType = scanner.headerBuf[16]
-	header.ValueType = scanner.headerBuf[17]
 
 	// Check if key / value size are within the set maximum values
 	// This is to detect corruption to header (i.e. if the size gets corrupted and it becomes a very huge value)
-	if header.KeySize > constants.MaxKeySize {
-		return Header{}, ErrKeyTooLarge
-	}
-	if header.ValueSize > constants.MaxValueSize {
-		return Header{}, ErrValueTooLarge
+	if err := scanner.limits.Check(header.KeySize, header.ValueSize); err != nil {
+		return Header{}, err
 	}
 
 	if h != nil {
-		h.Write(scanner.headerBuf[:])
+		h.Write(buf[:size])
+	}
+	if _, err := scanner.reader.Discard(size); err != nil {
+		return Header{}, err
 	}
 
 	return header, nil
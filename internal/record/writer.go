@@ -3,12 +3,11 @@ package record
 import (
 	"bufio"
 	"encoding/binary"
-	"hash/crc32"
+	"errors"
 	"io"
 	"os"
 	"time"
 
-	"github.com/ananthvk/kvdb/internal/constants"
 	"github.com/spf13/afero"
 )
 
@@ -19,17 +18,45 @@ const writerBufferSize = 4 * 1000 * 1000 // 4MB buffer size
 type Writer struct {
 	fs   afero.Fs
 	file afero.File
-	// Internal buffer used to temporarily hold record header
+	// Internal buffer used to temporarily hold record header, v1 headers are the largest
 	buf        [recordHeaderSize]byte
 	currentPos int64
+	limits     Limits
+	format     Format
+	checksum   Checksum
+	// The records are encrypted with the current key of keyring if encrypted is set (by the file header), see
+	// cipher.go. plain and sealed are reused for the key and value before and after they are encrypted
+	encrypted bool
+	keyring   *Keyring
+	plain     []byte
+	sealed    []byte
+	// Reused for the records of WriteRecords, which are encoded before they are written
+	batch []byte
 
 	// Used during merge operation to reduce the number of syscalls
 	bufferedWriter *bufio.Writer
 }
 
-// NewWriter creates a new Record Writer that opens a file at the specified path for appending logs
+// openForAppend opens the file at the given path for appending records, and returns the format and checksum algorithm
+// of it's records, and whether they are encrypted
+func openForAppend(fs afero.Fs, path string) (afero.File, Format, Checksum, bool, error) {
+	// The file is also opened for reading, to read the encoding from it's header
+	file, err := fs.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_RDWR, 0666)
+	if err != nil {
+		return nil, 0, 0, false, err
+	}
+	format, checksum, encrypted, err := readEncoding(file)
+	if err != nil {
+		file.Close()
+		return nil, 0, 0, false, err
+	}
+	return file, format, checksum, encrypted, nil
+}
+
+// NewWriter creates a new Record Writer that opens a file at the specified path for appending logs. Records are
+// written in the format given by the file header, a file without a header gets v1 records
 func NewWriter(fs afero.Fs, path string) (*Writer, error) {
-	file, err := fs.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0666)
+	file, format, checksum, encrypted, err := openForAppend(fs, path)
 	if err != nil {
 		return nil, err
 	}
@@ -46,13 +73,22 @@ func NewWriter(fs afero.Fs, path string) (*Writer, error) {
 		file:           file,
 		bufferedWriter: nil,
 		currentPos:     pos,
+		limits:         DefaultLimits,
+		format:         format,
+		checksum:       checksum,
+		encrypted:      encrypted,
 	}, nil
 }
 
 // NewBufferedWriter creates a new Buffered Record Writer that opens a file at the specified path for appending logs
 // Note: It uses a bufio.Writer internally, it's good for merging records, but remember to Sync() otherwise data will get lost
 func NewBufferedWriter(fs afero.Fs, path string) (*Writer, error) {
-	file, err := fs.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0666)
+	return NewBufferedWriterSize(fs, path, writerBufferSize)
+}
+
+// NewBufferedWriterSize is like NewBufferedWriter, with a buffer of size bytes
+func NewBufferedWriterSize(fs afero.Fs, path string, size int) (*Writer, error) {
+	file, format, checksum, encrypted, err := openForAppend(fs, path)
 	if err != nil {
 		return nil, err
 	}
@@ -66,18 +102,64 @@ func NewBufferedWriter(fs afero.Fs, path string) (*Writer, error) {
 	return &Writer{
 		fs:             fs,
 		file:           file,
-		bufferedWriter: bufio.NewWriterSize(file, writerBufferSize),
+		bufferedWriter: bufio.NewWriterSize(file, size),
 		currentPos:     stat.Size(),
+		limits:         DefaultLimits,
+		format:         format,
+		checksum:       checksum,
+		encrypted:      encrypted,
 	}, nil
 }
 
+// SetLimits sets the largest key and value sizes that are written, larger records are rejected with ErrKeyTooLarge or
+// ErrValueTooLarge
+func (w *Writer) SetLimits(limits Limits) {
+	w.limits = limits
+}
+
+// SetKeyring sets the keys the records are encrypted with, if the file is encrypted. Writes to an encrypted file fail
+// with ErrNoKey if the keyring can't encrypt
+func (w *Writer) SetKeyring(keyring *Keyring) {
+	w.keyring = keyring
+}
+
+// Encrypted returns true if the records written by the writer are encrypted
+func (w *Writer) Encrypted() bool {
+	return w.encrypted
+}
+
+// EncodedSize returns the number of bytes taken by a record with the given key and value sizes in the file
+func (w *Writer) EncodedSize(keySize, valueSize uint32) int64 {
+	return w.format.encodedSize(keySize, valueSize, w.encrypted)
+}
+
+// Format returns the format of the records written by the writer
+func (w *Writer) Format() Format {
+	return w.format
+}
+
+// Checksum returns the algorithm of the checksums of the records written by the writer
+func (w *Writer) Checksum() Checksum {
+	return w.checksum
+}
+
+// File returns the file the records are written to
+func (w *Writer) File() afero.File {
+	return w.file
+}
+
+// Size returns the size of the file, including the records that are still buffered
+func (w *Writer) Size() int64 {
+	return w.currentPos
+}
+
 // writeRecord writes the key-value record to the file. It writes the record header, followed by the key & value, then the CRC checksum
 func (w *Writer) writeRecord(r *Record) error {
-	if int(r.Header.KeySize) > constants.MaxKeySize {
-		return ErrKeyTooLarge
+	if err := w.limits.Check(r.Header.KeySize, r.Header.ValueSize); err != nil {
+		return err
 	}
-	if int(r.Header.ValueSize) > constants.MaxValueSize {
-		return ErrKeyTooLarge
+	if w.encrypted && !w.keyring.CanEncrypt() {
+		return ErrNoKey
 	}
 	var currentWriter io.Writer
 
@@ -87,38 +169,41 @@ func (w *Writer) writeRecord(r *Record) error {
 		currentWriter = w.bufferedWriter
 	}
 
-	h := crc32.NewIEEE()
-	// Set header fields
-	binary.LittleEndian.PutUint64(w.buf[0:], uint64(r.Header.Timestamp.UnixMicro())) // Unix timestamp (in microseconds)
-	binary.LittleEndian.PutUint32(w.buf[8:], r.Header.KeySize)                       // Length of key
-	binary.LittleEndian.PutUint32(w.buf[12:], r.Header.ValueSize)                    // Length of value
-	w.buf[16] = r.Header.RecordType                                                  // Type of record, 0x50 for PUT, and 0x44 for DELETE
-	w.buf[17] = r.Header.ValueType                                                   // Currently value type is unused
-	w.buf[18] = 0x0                                                                  // Reserved
-	w.buf[19] = 0x0                                                                  // Reserved
+	h := w.checksum.newHash()
+	n := w.format.encodeHeader(w.buf[:], &r.Header)
 
 	// Update CRC with header info
-	h.Write(w.buf[:])
-	if _, err := currentWriter.Write(w.buf[:]); err != nil {
+	h.Write(w.buf[:n])
+	if _, err := currentWriter.Write(w.buf[:n]); err != nil {
 		return err
 	}
 
-	// Update CRC with key & value
-	h.Write(r.Key)
-	if _, err := currentWriter.Write(r.Key); err != nil {
-		return err
-	}
-	h.Write(r.Value)
-	if _, err := currentWriter.Write(r.Value); err != nil {
-		return err
+	if w.encrypted {
+		// The header is authenticated along with the key and value
+		w.plain = append(append(w.plain[:0], r.Key...), r.Value...)
+		w.sealed = w.keyring.Seal(w.sealed[:0], w.plain, w.buf[:n])
+		h.Write(w.sealed)
+		if _, err := currentWriter.Write(w.sealed); err != nil {
+			return err
+		}
+	} else {
+		// Update CRC with key & value
+		h.Write(r.Key)
+		if _, err := currentWriter.Write(r.Key); err != nil {
+			return err
+		}
+		h.Write(r.Value)
+		if _, err := currentWriter.Write(r.Value); err != nil {
+			return err
+		}
 	}
 
-	// Write the CRC of the record at the end
+	// Write the checksum of the record at the end
 	crc := h.Sum32()
 	if err := binary.Write(currentWriter, binary.LittleEndian, crc); err != nil {
 		return err
 	}
-	w.currentPos += int64(r.Size)
+	w.currentPos += w.EncodedSize(r.Header.KeySize, r.Header.ValueSize)
 	return nil
 }
 
@@ -127,7 +212,7 @@ func (w *Writer) writeRecord(r *Record) error {
 // This function returns the offset of the record in the file, measured from the start of the file
 func (w *Writer) WriteKeyValue(key []byte, value []byte) (int64, error) {
 	start := w.currentPos
-	rec := newRecord(key, value, recordTypePut)
+	rec := newRecord(key, value, RecordTypePut)
 	return start, w.writeRecord(rec)
 }
 
@@ -142,7 +227,7 @@ func (w *Writer) WriteTombstone(key []byte) (int64, error) {
 
 func (w *Writer) WriteKeyValueWithTs(key []byte, value []byte, ts time.Time) (int64, error) {
 	start := w.currentPos
-	rec := newRecord(key, value, recordTypePut)
+	rec := newRecord(key, value, RecordTypePut)
 	rec.Header.Timestamp = ts
 	return start, w.writeRecord(rec)
 }
@@ -154,10 +239,165 @@ func (w *Writer) WriteTombstoneWithTs(key []byte, ts time.Time) (int64, error) {
 	return start, w.writeRecord(rec)
 }
 
+// WriteRecordWithTs writes a record of the given type (one of the RecordType constants) with the given timestamp
+func (w *Writer) WriteRecordWithTs(key []byte, value []byte, recordType uint8, ts time.Time) (int64, error) {
+	return w.WriteRecordWithHeader(Header{Timestamp: ts, RecordType: recordType}, key, value)
+}
+
+// WriteRecordWithHeader writes a record with the timestamp, record type and value type of the header, the key and value
+// sizes of the header are ignored
+func (w *Writer) WriteRecordWithHeader(header Header, key []byte, value []byte) (int64, error) {
+	start := w.currentPos
+	header.KeySize, header.ValueSize = uint32(len(key)), uint32(len(value))
+	rec := &Record{Header: header, Key: key, Value: value}
+	return start, w.writeRecord(rec)
+}
+
+// WriteRecords writes the records (with the timestamp, record type and value type of their headers, like
+// WriteRecordWithHeader) with a single write: they are encoded into one buffer first. It returns the offset of every
+// record. If a record is too large, nothing is written, and if the write fails, none of the records are written
+func (w *Writer) WriteRecords(records []Record) ([]int64, error) {
+	for i := range records {
+		r := &records[i]
+		r.Header.KeySize, r.Header.ValueSize = uint32(len(r.Key)), uint32(len(r.Value))
+		if err := w.limits.Check(r.Header.KeySize, r.Header.ValueSize); err != nil {
+			return nil, err
+		}
+	}
+	if w.encrypted && !w.keyring.CanEncrypt() {
+		return nil, ErrNoKey
+	}
+	offsets := make([]int64, len(records))
+	pos := w.currentPos
+	w.batch = w.batch[:0]
+	for i := range records {
+		offsets[i] = pos
+		w.batch = w.appendRecord(w.batch, &records[i])
+		pos += w.EncodedSize(records[i].Header.KeySize, records[i].Header.ValueSize)
+	}
+	var currentWriter io.Writer = w.file
+	if w.bufferedWriter != nil {
+		currentWriter = w.bufferedWriter
+	}
+	if _, err := currentWriter.Write(w.batch); err != nil {
+		return nil, err
+	}
+	w.currentPos = pos
+	return offsets, nil
+}
+
+// appendRecord appends the encoding of the record to dst, as written by writeRecord, and returns it. The sizes of the
+// header must be set
+func (w *Writer) appendRecord(dst []byte, r *Record) []byte {
+	start := len(dst)
+	n := w.format.encodeHeader(w.buf[:], &r.Header)
+	dst = append(dst, w.buf[:n]...)
+	if w.encrypted {
+		w.plain = append(append(w.plain[:0], r.Key...), r.Value...)
+		dst = w.keyring.Seal(dst, w.plain, w.buf[:n])
+	} else {
+		dst = append(append(dst, r.Key...), r.Value...)
+	}
+	h := w.checksum.newHash()
+	h.Write(dst[start:])
+	return binary.LittleEndian.AppendUint32(dst, h.Sum32())
+}
+
+// WriteRecordFromReader is like WriteRecordWithHeader, but the value is copied from r, which must have at least
+// header.ValueSize bytes, so that a large value is not held in memory. If r has fewer bytes, or fails, the part of the
+// record that was written is truncated from the file, and the error is returned (io.ErrUnexpectedEOF if r ended early).
+// Bytes of r after the first header.ValueSize are not read. The value of an encrypted record is read into memory, since
+// it's sealed whole
+func (w *Writer) WriteRecordFromReader(header Header, key []byte, r io.Reader) (int64, error) {
+	valueSize := header.ValueSize
+	if err := w.limits.Check(uint32(len(key)), valueSize); err != nil {
+		return 0, err
+	}
+	if w.encrypted {
+		value := make([]byte, valueSize)
+		if _, err := io.ReadFull(r, value); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return 0, err
+		}
+		return w.WriteRecordWithHeader(header, key, value)
+	}
+	start := w.currentPos
+	var currentWriter io.Writer = w.file
+	if w.bufferedWriter != nil {
+		// The buffer must only hold this record, so that it can be discarded if the record is not complete
+		if err := w.bufferedWriter.Flush(); err != nil {
+			return 0, err
+		}
+		currentWriter = w.bufferedWriter
+	}
+	header.KeySize = uint32(len(key))
+	h := w.checksum.newHash()
+	n := w.format.encodeHeader(w.buf[:], &header)
+	h.Write(w.buf[:n])
+	h.Write(key)
+	err := writeAll(currentWriter, w.buf[:n], key)
+	if err == nil {
+		var copied int64
+		copied, err = io.CopyN(io.MultiWriter(currentWriter, h), r, int64(valueSize))
+		if err == io.EOF && copied < int64(valueSize) {
+			err = io.ErrUnexpectedEOF
+		}
+	}
+	if err == nil {
+		err = binary.Write(currentWriter, binary.LittleEndian, h.Sum32())
+	}
+	if err != nil {
+		// Remove the partial record, so that the next record is written right after the previous one
+		if w.bufferedWriter != nil {
+			w.bufferedWriter.Reset(w.file)
+		}
+		// Files that don't honour O_APPEND after a truncate (like afero.MemMapFs) write at their offset
+		if truncErr := w.file.Truncate(start); truncErr != nil {
+			return 0, errors.Join(err, truncErr)
+		}
+		if _, seekErr := w.file.Seek(start, io.SeekStart); seekErr != nil {
+			return 0, errors.Join(err, seekErr)
+		}
+		return 0, err
+	}
+	w.currentPos += w.format.EncodedSize(header.KeySize, header.ValueSize)
+	return start, nil
+}
+
+// writeAll writes the buffers to the writer, one after the other
+func writeAll(writer io.Writer, bufs ...[]byte) error {
+	for _, buf := range bufs {
+		if _, err := writer.Write(buf); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Buffered returns the number of bytes that are buffered, and not in the file yet
+func (w *Writer) Buffered() int {
+	if w.bufferedWriter != nil {
+		return w.bufferedWriter.Buffered()
+	}
+	return 0
+}
+
+// Flush writes the buffered records to the file, without syncing it
+func (w *Writer) Flush() error {
+	if w.bufferedWriter != nil {
+		return w.bufferedWriter.Flush()
+	}
+	return nil
+}
+
 // Sync flushes any buffered data to the underlying file. It calls sync() on the file
 func (w *Writer) Sync() error {
 	if w.bufferedWriter != nil {
-		w.bufferedWriter.Flush()
+		if err := w.bufferedWriter.Flush(); err != nil {
+			return err
+		}
 	}
 	return w.file.Sync()
 }
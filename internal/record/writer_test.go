@@ -1,8 +1,12 @@
 package record
 
 import (
+	"bytes"
+	"errors"
 	"io"
+	"slices"
 	"testing"
+	"time"
 
 	"github.com/spf13/afero"
 )
@@ -86,3 +90,53 @@ func TestWriteTombstone(t *testing.T) {
 		t.Errorf("expected data length of %d, got %d", expectedLength, len(data))
 	}
 }
+
+func TestWriteRecords(t *testing.T) {
+	testFS := afero.NewMemMapFs()
+	single, err := NewWriter(testFS, "single.dat")
+	if err != nil {
+		t.Fatalf("failed to open file: %v", err)
+	}
+	batch, err := NewWriter(testFS, "batch.dat")
+	if err != nil {
+		t.Fatalf("failed to open file: %v", err)
+	}
+	first := Header{Timestamp: time.UnixMicro(100), RecordType: RecordTypePut}
+	single.WriteRecordWithHeader(first, []byte("first"), []byte("value"))
+	batch.WriteRecordWithHeader(first, []byte("first"), []byte("value"))
+
+	var records []Record
+	var expectedOffsets []int64
+	for i := range 10 {
+		header := Header{Timestamp: time.UnixMicro(int64(i)), RecordType: RecordTypePut, ValueType: uint8(i % 3)}
+		key, value := []byte{'k', byte(i)}, bytes.Repeat([]byte{byte(i)}, i*7)
+		offset, err := single.WriteRecordWithHeader(header, key, value)
+		if err != nil {
+			t.Fatalf("write failed: %v", err)
+		}
+		expectedOffsets = append(expectedOffsets, offset)
+		records = append(records, Record{Header: header, Key: key, Value: value})
+	}
+	offsets, err := batch.WriteRecords(records)
+	if err != nil {
+		t.Fatalf("write records failed: %v", err)
+	}
+	if !slices.Equal(offsets, expectedOffsets) || batch.Size() != single.Size() {
+		t.Errorf("expected the offsets %v and size %d, got %v and %d", expectedOffsets, single.Size(), offsets, batch.Size())
+	}
+
+	// Nothing is written if a record is too large
+	batch.SetLimits(Limits{MaxKeySize: 10, MaxValueSize: 10})
+	tooLarge := []Record{{Key: []byte("a")}, {Key: []byte("b"), Value: make([]byte, 11)}}
+	if _, err := batch.WriteRecords(tooLarge); !errors.Is(err, ErrValueTooLarge) {
+		t.Errorf("expected ErrValueTooLarge, got %v", err)
+	}
+	single.Close()
+	batch.Close()
+
+	expected, _ := afero.ReadFile(testFS, "single.dat")
+	data, _ := afero.ReadFile(testFS, "batch.dat")
+	if !bytes.Equal(data, expected) {
+		t.Errorf("expected the records to be encoded like single writes")
+	}
+}
@@ -2,7 +2,9 @@ package resp
 
 import (
 	"bufio"
+	"errors"
 	"io"
+	"math"
 	"slices"
 )
 
@@ -13,7 +15,7 @@ import (
 // has been processed.
 func DeserializeSimpleString(r *bufio.Reader) (Value, error) {
 	// Read upto \r
-	simpleString, err := r.ReadBytes('\r')
+	simpleString, err := readLine(r)
 	if err != nil {
 		return Value{}, err
 	}
@@ -68,28 +70,47 @@ func DeserializeError(r *bufio.Reader) (Value, error) {
 // DeserializeBulkString should be called after '$' byte has been processed.
 // It can process any kind of binary strings
 func DeserializeBulkString(r *bufio.Reader) (Value, error) {
+	var size messageSize
+	return deserializeBulkString(r, &size)
+}
+
+// deserializeBulkString deserializes a bulk string, and counts it's length towards the size of the message
+func deserializeBulkString(r *bufio.Reader, size *messageSize) (Value, error) {
+	length, err := readBulkStringLength(r)
+	if err != nil {
+		return Value{}, err
+	}
+	return readBulkString(r, length, size)
+}
+
+// readBulkStringLength reads the length of a bulk string, -1 for a null bulk string
+func readBulkStringLength(r *bufio.Reader) (int64, error) {
 	value, err := DeserializeInteger(r)
 	if err != nil {
-		return value, err
+		return 0, err
 	}
-	length := value.Integer
+	if value.Integer < -1 {
+		return 0, ErrProtocolError
+	}
+	return value.Integer, nil
+}
+
+// readBulkString reads the data of a bulk string of the given length, after it's length has been read
+func readBulkString(r *bufio.Reader, length int64, size *messageSize) (Value, error) {
 	// Handle null bulk string
 	if length == -1 {
 		return Value{}, nil
 	}
-
-	if length < 0 {
-		return Value{}, ErrProtocolError
-	}
-
 	if length > maxBulkStringSize {
 		return Value{}, ErrTooLarge
 	}
+	if err := size.add(length); err != nil {
+		return Value{}, err
+	}
 
 	// Read the data
 	data := make([]byte, length)
-	_, err = io.ReadFull(r, data)
-	if err != nil {
+	if _, err := io.ReadFull(r, data); err != nil {
 		return Value{}, err
 	}
 
@@ -107,29 +128,34 @@ func DeserializeBulkString(r *bufio.Reader) (Value, error) {
 // DeserializeArray deserializes an arbitrary array from the reader. Each element is parsed as a RESP value
 // It should be called after '*' has been processed
 func DeserializeArray(r *bufio.Reader) (Value, error) {
-	value, err := DeserializeInteger(r)
+	var size messageSize
+	return deserializeArray(r, 1, &size)
+}
+
+// deserializeArray deserializes an array that is nested depth levels deep. The elements are counted towards the size
+// of the message before they are read, so that a message can't grow past maxMessageSize one nested array at a time
+func deserializeArray(r *bufio.Reader, depth int, size *messageSize) (Value, error) {
+	if depth > maxArrayDepth {
+		return Value{}, ErrNestingTooDeep
+	}
+	length, err := readArrayLength(r, size)
 	if err != nil {
-		return value, err
+		return Value{}, err
 	}
-	length := value.Integer
-
 	// Handle null array
 	if length == -1 {
 		return Value{}, nil
 	}
-	if length < 0 {
-		return Value{}, ErrProtocolError
-	}
 
-	values := make([]Value, length)
+	values := make([]Value, 0, min(length, arrayPreallocLength))
 
 	// Read the values
-	for i := range values {
-		value, err := Deserialize(r)
+	for range length {
+		value, err := deserialize(r, depth, size)
 		if err != nil {
 			return Value{}, err
 		}
-		values[i] = value
+		values = append(values, value)
 	}
 
 	return Value{
@@ -138,9 +164,50 @@ func DeserializeArray(r *bufio.Reader) (Value, error) {
 	}, nil
 }
 
+// readArrayLength reads the length of an array, -1 for a null array, and counts it's elements towards the size of the
+// message
+func readArrayLength(r *bufio.Reader, size *messageSize) (int64, error) {
+	value, err := DeserializeInteger(r)
+	if err != nil {
+		return 0, err
+	}
+	length := value.Integer
+	if length == -1 {
+		return -1, nil
+	}
+	if length < 0 {
+		return 0, ErrProtocolError
+	}
+	if length > maxArrayLength {
+		return 0, ErrArrayTooLarge
+	}
+	if err := size.add(length * valueOverhead); err != nil {
+		return 0, err
+	}
+	return length, nil
+}
+
 // Deserialize is a high level function that reads the first byte to determine the type of value.
 // It then calls the appropriate function to deserialize the value
 func Deserialize(r *bufio.Reader) (Value, error) {
+	var size messageSize
+	return deserialize(r, 0, &size)
+}
+
+// messageSize is the memory taken so far by the value being deserialized, see maxMessageSize
+type messageSize int64
+
+// add counts n more bytes, it returns ErrMessageTooLarge if the message is now larger than maxMessageSize
+func (s *messageSize) add(n int64) error {
+	*s += messageSize(n)
+	if *s > maxMessageSize {
+		return ErrMessageTooLarge
+	}
+	return nil
+}
+
+// deserialize reads a value that is inside depth levels of arrays
+func deserialize(r *bufio.Reader, depth int, size *messageSize) (Value, error) {
 	valueTypeByte, err := r.ReadByte()
 	if err != nil {
 		return Value{}, err
@@ -153,9 +220,9 @@ func Deserialize(r *bufio.Reader) (Value, error) {
 	case ':':
 		return DeserializeInteger(r)
 	case '$':
-		return DeserializeBulkString(r)
+		return deserializeBulkString(r, size)
 	case '*':
-		return DeserializeArray(r)
+		return deserializeArray(r, depth+1, size)
 	case '_':
 		return DeserializeNull(r)
 	}
@@ -174,7 +241,7 @@ func DeserializeNull(r *bufio.Reader) (Value, error) {
 // DeserializeInteger deserializes a signed 64-bit signed integer. It should be called after ':' has been processed
 func DeserializeInteger(r *bufio.Reader) (Value, error) {
 	// Read the length integer
-	lengthBytes, err := r.ReadBytes('\r')
+	lengthBytes, err := readLine(r)
 	if err != nil {
 		return Value{}, err
 	}
@@ -190,7 +257,7 @@ func DeserializeInteger(r *bufio.Reader) (Value, error) {
 	numDigits := 0
 
 	// Parse the length (excluding the trailing \r)
-	var length int64 = 0
+	var length uint64 = 0
 	isNegative := false
 	for i, b := range lengthBytes[:len(lengthBytes)-1] {
 		if i == 0 && b == '-' {
@@ -205,17 +272,43 @@ func DeserializeInteger(r *bufio.Reader) (Value, error) {
 			return Value{}, ErrProtocolError
 		}
 		numDigits += 1
-		length = length*10 + int64(b-'0')
-	}
-	if isNegative {
-		length = -length
+		// Values up to -math.MinInt64 are accepted here, the sign is checked below
+		if length > (math.MaxInt64+1-uint64(b-'0'))/10 {
+			return Value{}, ErrIntegerOverflow
+		}
+		length = length*10 + uint64(b-'0')
 	}
 
 	// There should be atleast a single digit
 	if numDigits == 0 {
 		return Value{}, ErrProtocolError
 	}
-	return Value{Type: ValueTypeInteger, Integer: length}, nil
+	if isNegative {
+		return Value{Type: ValueTypeInteger, Integer: int64(-length)}, nil
+	}
+	if length > math.MaxInt64 {
+		return Value{}, ErrIntegerOverflow
+	}
+	return Value{Type: ValueTypeInteger, Integer: int64(length)}, nil
+}
+
+// readLine reads upto and including the next \r, like ReadBytes, but returns ErrLineTooLong if the line is longer than
+// maxLineSize, so that a peer that never sends \r can't make the reader buffer unbounded data
+func readLine(r *bufio.Reader) ([]byte, error) {
+	var line []byte
+	for {
+		chunk, err := r.ReadSlice('\r')
+		if len(line)+len(chunk) > maxLineSize {
+			return nil, ErrLineTooLong
+		}
+		line = append(line, chunk...)
+		if err == nil {
+			return line, nil
+		}
+		if !errors.Is(err, bufio.ErrBufferFull) {
+			return line, err
+		}
+	}
 }
 
 func checkCLRF(r *bufio.Reader) error {
@@ -1112,6 +1112,31 @@ func TestDeserializeMalformed(t *testing.T) {
 			input:   "*abc\r\n",
 			wantErr: ErrProtocolError,
 		},
+		{
+			name:    "array too large",
+			input:   "*9223372036854775807\r\n",
+			wantErr: ErrArrayTooLarge,
+		},
+		{
+			name:    "arrays nested too deeply",
+			input:   strings.Repeat("*1\r\n", 64) + "_\r\n",
+			wantErr: ErrNestingTooDeep,
+		},
+		{
+			name:    "nested arrays too large",
+			input:   strings.Repeat("*1048576\r\n", 4),
+			wantErr: ErrMessageTooLarge,
+		},
+		{
+			name:    "integer overflow",
+			input:   ":9223372036854775808\r\n",
+			wantErr: ErrIntegerOverflow,
+		},
+		{
+			name:    "line too long",
+			input:   "+" + strings.Repeat("a", 100*1024) + "\r\n",
+			wantErr: ErrLineTooLong,
+		},
 		{
 			name:    "array with invalid element",
 			input:   "*2\r\n:1\r\n%invalid\r\n",
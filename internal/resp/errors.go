@@ -9,6 +9,16 @@ var ErrProtocolError = errors.New("protocol error")
 
 var ErrTooLarge = fmt.Errorf("%w: bulk string length too large", ErrProtocolError)
 
+var ErrArrayTooLarge = fmt.Errorf("%w: array length too large", ErrProtocolError)
+
+var ErrMessageTooLarge = fmt.Errorf("%w: message too large", ErrProtocolError)
+
+var ErrNestingTooDeep = fmt.Errorf("%w: arrays nested too deeply", ErrProtocolError)
+
+var ErrLineTooLong = fmt.Errorf("%w: line too long", ErrProtocolError)
+
+var ErrIntegerOverflow = fmt.Errorf("%w: integer out of range", ErrProtocolError)
+
 var ErrUnknownValueType = fmt.Errorf("%w: unknown value type", ErrProtocolError)
 
 var ErrInvalidType = fmt.Errorf("%w: invalid value of Type during serialization", ErrProtocolError)
@@ -0,0 +1,46 @@
+package resp
+
+import (
+	"bufio"
+	"bytes"
+	"testing"
+)
+
+// FuzzDeserialize checks that Deserialize does not panic or allocate unbounded memory on any input, and that every
+// value it returns is serialized back to bytes that deserialize to the same value
+func FuzzDeserialize(f *testing.F) {
+	for _, seed := range []string{
+		"*3\r\n$3\r\nSET\r\n$4\r\nname\r\n$4\r\nkvdb\r\n",
+		"*2\r\n$3\r\nGET\r\n$-1\r\n",
+		"+OK\r\n",
+		"-ERR unknown command\r\n",
+		":-9223372036854775808\r\n",
+		"_\r\n",
+		"*1\r\n*1\r\n*0\r\n",
+		"*1048576\r\n*1048576\r\n*1048576\r\n",
+	} {
+		f.Add([]byte(seed))
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		reader := bufio.NewReader(bytes.NewReader(data))
+		for {
+			value, err := Deserialize(reader)
+			if err != nil {
+				return
+			}
+			var buf bytes.Buffer
+			writer := bufio.NewWriter(&buf)
+			if err := Serialize(value, writer); err != nil {
+				t.Fatalf("could not serialize deserialized value %+v: %v", value, err)
+			}
+			writer.Flush()
+			again, err := Deserialize(bufio.NewReader(&buf))
+			if err != nil {
+				t.Fatalf("could not deserialize %q: %v", buf.Bytes(), err)
+			}
+			if !compareValues(value, again) {
+				t.Fatalf("round trip changed the value\nbefore: %+v\nafter: %+v", value, again)
+			}
+		}
+	})
+}
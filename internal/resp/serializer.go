@@ -37,8 +37,9 @@ func SerializeSimpleError(prefix []byte, content []byte, w *bufio.Writer) error
 	if _, err := w.Write(prefix); err != nil {
 		return err
 	}
-	if len(prefix) > 0 {
-		// Add a space separator
+	if len(prefix) > 0 || len(content) > 0 {
+		// Add a space separator, it's also needed without a prefix, otherwise the first word of content would be read
+		// back as the prefix
 		if _, err := w.Write([]byte{' '}); err != nil {
 			return err
 		}
@@ -0,0 +1,138 @@
+package resp
+
+import (
+	"bufio"
+	"errors"
+	"io"
+)
+
+/*
+Streaming bulk strings
+
+Deserialize reads a whole value into memory, so bulk strings are limited to maxBulkStringSize. DeserializeWithOptions
+can also accept larger bulk strings (up to Options.MaxStreamSize bytes), without reading them: if the last element of a
+top level array is a bulk string larger than maxBulkStringSize, the array is returned without it, along with a
+*BulkStream that reads it from the reader. This lets a server copy a large value (like the value of SET key value) to
+where it's stored as it arrives, instead of holding it in memory. Large bulk strings anywhere else (in a nested array,
+or before the last element) are rejected with ErrTooLarge, as by Deserialize.
+
+The caller must Close the stream before the next value is read from the reader, Close skips the part of the bulk string
+that was not read, and checks the \r\n after it. Streamed bulk strings are not counted towards maxMessageSize
+*/
+
+// Options changes the limits of DeserializeWithOptions
+type Options struct {
+	// MaxStreamSize is the largest bulk string that's returned as a *BulkStream, see stream.go. 0 disables streaming
+	MaxStreamSize int64
+}
+
+// BulkStream reads a bulk string from the reader it was deserialized from, see stream.go
+type BulkStream struct {
+	r         *bufio.Reader
+	size      int64
+	remaining int64
+	closed    bool
+	closeErr  error
+}
+
+// Size returns the length of the bulk string
+func (s *BulkStream) Size() int64 {
+	return s.size
+}
+
+// Read reads the next bytes of the bulk string, it returns io.EOF once the whole string has been read, and
+// io.ErrUnexpectedEOF if the reader ends before it
+func (s *BulkStream) Read(p []byte) (int, error) {
+	if s.closed {
+		return 0, errors.New("resp: read of a closed bulk stream")
+	}
+	if s.remaining == 0 {
+		return 0, io.EOF
+	}
+	if int64(len(p)) > s.remaining {
+		p = p[:s.remaining]
+	}
+	n, err := s.r.Read(p)
+	s.remaining -= int64(n)
+	if errors.Is(err, io.EOF) {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+// Close skips the rest of the bulk string, and reads the \r\n after it. It can be called more than once
+func (s *BulkStream) Close() error {
+	if s.closed {
+		return s.closeErr
+	}
+	s.closed = true
+	if _, err := io.CopyN(io.Discard, s.r, s.remaining); err != nil {
+		if errors.Is(err, io.EOF) {
+			err = io.ErrUnexpectedEOF
+		}
+		s.closeErr = err
+		return err
+	}
+	s.remaining = 0
+	s.closeErr = checkCLRF(s.r)
+	return s.closeErr
+}
+
+// DeserializeWithOptions is like Deserialize, but the last element of a top level array can be a bulk string larger
+// than maxBulkStringSize (up to opts.MaxStreamSize bytes), which is returned as a *BulkStream instead of being read,
+// see stream.go. The returned stream is nil if there is no such element
+func DeserializeWithOptions(r *bufio.Reader, opts *Options) (Value, *BulkStream, error) {
+	if opts == nil || opts.MaxStreamSize <= maxBulkStringSize {
+		value, err := Deserialize(r)
+		return value, nil, err
+	}
+	valueTypeByte, err := r.Peek(1)
+	if err != nil {
+		return Value{}, nil, err
+	}
+	if valueTypeByte[0] != '*' {
+		value, err := Deserialize(r)
+		return value, nil, err
+	}
+	r.ReadByte()
+
+	var size messageSize
+	length, err := readArrayLength(r, &size)
+	if err != nil || length == -1 {
+		return Value{}, nil, err
+	}
+	values := make([]Value, 0, min(length, arrayPreallocLength))
+	for range length - 1 {
+		value, err := deserialize(r, 1, &size)
+		if err != nil {
+			return Value{}, nil, err
+		}
+		values = append(values, value)
+	}
+	if length == 0 {
+		return Value{Type: ValueTypeArray, Array: values}, nil, nil
+	}
+
+	// The last element is streamed if it's a large bulk string
+	if valueTypeByte, err := r.Peek(1); err != nil || valueTypeByte[0] != '$' {
+		value, err := deserialize(r, 1, &size)
+		if err != nil {
+			return Value{}, nil, err
+		}
+		return Value{Type: ValueTypeArray, Array: append(values, value)}, nil, nil
+	}
+	r.ReadByte()
+	bulkLength, err := readBulkStringLength(r)
+	if err != nil {
+		return Value{}, nil, err
+	}
+	if bulkLength > maxBulkStringSize && bulkLength <= opts.MaxStreamSize {
+		stream := &BulkStream{r: r, size: bulkLength, remaining: bulkLength}
+		return Value{Type: ValueTypeArray, Array: values}, stream, nil
+	}
+	value, err := readBulkString(r, bulkLength, &size)
+	if err != nil {
+		return Value{}, nil, err
+	}
+	return Value{Type: ValueTypeArray, Array: append(values, value)}, nil, nil
+}
@@ -0,0 +1,97 @@
+package resp
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// setCommand returns a SET command with a value of n bytes, followed by a PING
+func setCommand(n int) string {
+	return "*3\r\n$3\r\nSET\r\n$3\r\nkey\r\n$" + strconv.Itoa(n) + "\r\n" + strings.Repeat("v", n) + "\r\n*1\r\n$4\r\nPING\r\n"
+}
+
+// checkPing checks that the next value of the reader is the PING sent after a command
+func checkPing(t *testing.T, r *bufio.Reader) {
+	t.Helper()
+	value, err := Deserialize(r)
+	if err != nil || len(value.Array) != 1 || string(value.Array[0].Buffer) != "PING" {
+		t.Errorf("expected the next command to be PING, got %+v, %v", value, err)
+	}
+}
+
+func TestDeserializeWithOptions(t *testing.T) {
+	opts := &Options{MaxStreamSize: 4 * maxBulkStringSize}
+	size := 2 * maxBulkStringSize
+	r := bufio.NewReader(strings.NewReader(setCommand(size)))
+	value, stream, err := DeserializeWithOptions(r, opts)
+	if err != nil || stream == nil {
+		t.Fatalf("expected the value to be streamed, got %v, %v", stream, err)
+	}
+	if len(value.Array) != 2 || string(value.Array[1].Buffer) != "key" || stream.Size() != int64(size) {
+		t.Errorf("unexpected command %+v with a stream of %d bytes", value, stream.Size())
+	}
+	data, err := io.ReadAll(stream)
+	if err != nil || !bytes.Equal(data, bytes.Repeat([]byte("v"), size)) {
+		t.Errorf("expected to read the value, got %d bytes, %v", len(data), err)
+	}
+	if err := stream.Close(); err != nil {
+		t.Errorf("close failed: %v", err)
+	}
+	checkPing(t, r)
+
+	// A small value is read as usual
+	r = bufio.NewReader(strings.NewReader(setCommand(10)))
+	value, stream, err = DeserializeWithOptions(r, opts)
+	if err != nil || stream != nil || len(value.Array) != 3 || len(value.Array[2].Buffer) != 10 {
+		t.Errorf("expected the small value to be read, got %+v, %v, %v", value, stream, err)
+	}
+	checkPing(t, r)
+
+	// Close skips the part of the value that was not read
+	r = bufio.NewReader(strings.NewReader(setCommand(size)))
+	_, stream, _ = DeserializeWithOptions(r, opts)
+	io.ReadFull(stream, make([]byte, 100))
+	if err := stream.Close(); err != nil {
+		t.Errorf("close failed: %v", err)
+	}
+	checkPing(t, r)
+}
+
+func TestDeserializeWithOptionsLimits(t *testing.T) {
+	opts := &Options{MaxStreamSize: 2 * maxBulkStringSize}
+	tests := []struct {
+		name  string
+		input string
+		opts  *Options
+	}{
+		{"without streaming", setCommand(maxBulkStringSize + 1), nil},
+		{"larger than the limit", setCommand(2*maxBulkStringSize + 1), opts},
+		{"not the last element", "*2\r\n$" + strconv.Itoa(maxBulkStringSize+1) + "\r\n", opts},
+		{"nested array", "*1\r\n*1\r\n$" + strconv.Itoa(maxBulkStringSize+1) + "\r\n", opts},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, stream, err := DeserializeWithOptions(bufio.NewReader(strings.NewReader(tt.input)), tt.opts)
+			if !errors.Is(err, ErrTooLarge) || stream != nil {
+				t.Errorf("expected ErrTooLarge, got %v, %v", stream, err)
+			}
+		})
+	}
+
+	// The stream ends before the value, or the value is not followed by \r\n
+	truncated := setCommand(maxBulkStringSize + 1)[:1000]
+	_, stream, _ := DeserializeWithOptions(bufio.NewReader(strings.NewReader(truncated)), opts)
+	if _, err := io.ReadAll(stream); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("expected io.ErrUnexpectedEOF, got %v", err)
+	}
+	unterminated := strings.Replace(setCommand(maxBulkStringSize+1), "v\r\n*1", "vxx*1", 1)
+	_, stream, _ = DeserializeWithOptions(bufio.NewReader(strings.NewReader(unterminated)), opts)
+	if err := stream.Close(); !errors.Is(err, ErrProtocolError) {
+		t.Errorf("expected ErrProtocolError, got %v", err)
+	}
+}
@@ -1,9 +1,30 @@
 package resp
 
+import "unsafe"
+
 type ValueType int
 
 const maxBulkStringSize = 1024 * 1024 // 1 MiB
 
+// Limits that protect the deserializer from malformed or malicious input. A simple string, error or integer line can't
+// be longer than maxLineSize, an array can't have more than maxArrayLength elements, and arrays can't be nested more than
+// maxArrayDepth levels deep (to bound the recursion). A value, with all of it's nested arrays and strings, can't take
+// more than maxMessageSize bytes of memory once it's deserialized, since the other limits alone allow arrays of arrays
+// of millions of elements
+const (
+	maxLineSize    = 64 * 1024
+	maxArrayLength = 1024 * 1024
+	maxArrayDepth  = 32
+	maxMessageSize = 256 * 1024 * 1024
+)
+
+// Memory taken by an element of an array, in addition to it's contents, counted towards maxMessageSize
+const valueOverhead = int64(unsafe.Sizeof(Value{}))
+
+// Number of elements preallocated for an array, the rest are allocated as they are read, so that a large length in the
+// header does not allocate memory before the elements arrive
+const arrayPreallocLength = 64
+
 const (
 	ValueTypeNull ValueType = iota
 	ValueTypeSimpleString
@@ -13,3 +13,7 @@ func GetDataFileName(identifier int) string {
 func GetHintFileName(identifier int) string {
 	return fmt.Sprintf("%010d.hint", identifier)
 }
+
+func GetBloomFileName(identifier int) string {
+	return fmt.Sprintf("%010d.bloom", identifier)
+}
@@ -0,0 +1,96 @@
+package vfs
+
+import (
+	"errors"
+
+	"github.com/spf13/afero"
+)
+
+/*
+File system operations that afero does not have
+
+The datastore reads and writes through afero, so that it can run on an in-memory file system in tests. afero only has
+the portable subset of file operations, the ones below are added by FS, on top of any afero.Fs:
+
+ 1. Preallocate reserves disk space for a file without changing it's size (fallocate with FALLOC_FL_KEEP_SIZE), so that
+    a data file that is appended to for a long time is laid out in few extents instead of being fragmented by the other
+    files written at the same time. The space that is still reserved past the end of the file is freed by truncating
+    the file to it's size (punching a hole past the end does not free it on ext4), once it's known that it won't grow
+ 2. Advise tells the kernel how a file will be read (posix_fadvise), merges and scans read every data file from start to
+    end, so they ask for a larger read ahead
+ 3. SyncDir syncs a directory, so that the files created, renamed or removed in it survive a crash
+
+The operations are only hints, except SyncDir: they need a file backed by a file descriptor (afero.OsFs, and the files
+of wrappers that keep the Fd method), and are implemented on Linux. Everywhere else they return errors.ErrUnsupported,
+which the callers ignore. O_DIRECT is not used, records are not aligned to blocks, and reads rely on the page cache
+*/
+
+// Advice is how a file is going to be read, see FS.Advise
+type Advice int
+
+const (
+	AdviceNormal Advice = iota
+	// The file is read from start to end
+	AdviceSequential
+	// The cached pages of the file won't be needed again
+	AdviceDontNeed
+)
+
+// FS is an afero.Fs with file operations that afero does not have, see vfs.go
+type FS interface {
+	afero.Fs
+	// Preallocate reserves space for the bytes in [offset, offset+length) of the file, without changing it's size
+	Preallocate(file afero.File, offset int64, length int64) error
+	// Advise declares how the bytes in [offset, offset+length) of the file are going to be read, a length of 0 is up
+	// to the end of the file
+	Advise(file afero.File, offset int64, length int64, advice Advice) error
+	// SyncDir syncs the directory at path
+	SyncDir(path string) error
+}
+
+// New returns fs as an FS. If fs is not already an FS, the operations are implemented with the file descriptors of
+// it's files
+func New(fs afero.Fs) FS {
+	if vfs, ok := fs.(FS); ok {
+		return vfs
+	}
+	return &aferoFS{Fs: fs}
+}
+
+// aferoFS adds the operations of FS to an afero.Fs
+type aferoFS struct {
+	afero.Fs
+}
+
+// fdOf returns the file descriptor of the file, if it has one
+func fdOf(file afero.File) (uintptr, error) {
+	if f, ok := file.(interface{ Fd() uintptr }); ok {
+		return f.Fd(), nil
+	}
+	return 0, errors.ErrUnsupported
+}
+
+func (a *aferoFS) Preallocate(file afero.File, offset int64, length int64) error {
+	fd, err := fdOf(file)
+	if err != nil {
+		return err
+	}
+	return preallocate(fd, offset, length)
+}
+
+func (a *aferoFS) Advise(file afero.File, offset int64, length int64, advice Advice) error {
+	fd, err := fdOf(file)
+	if err != nil {
+		return err
+	}
+	return advise(fd, offset, length, advice)
+}
+
+func (a *aferoFS) SyncDir(path string) error {
+	dir, err := a.Open(path)
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+	return dir.Sync()
+}
@@ -0,0 +1,19 @@
+//go:build linux
+
+package vfs
+
+import "golang.org/x/sys/unix"
+
+func preallocate(fd uintptr, offset int64, length int64) error {
+	return unix.Fallocate(int(fd), unix.FALLOC_FL_KEEP_SIZE, offset, length)
+}
+
+func advise(fd uintptr, offset int64, length int64, advice Advice) error {
+	switch advice {
+	case AdviceSequential:
+		return unix.Fadvise(int(fd), offset, length, unix.FADV_SEQUENTIAL)
+	case AdviceDontNeed:
+		return unix.Fadvise(int(fd), offset, length, unix.FADV_DONTNEED)
+	}
+	return unix.Fadvise(int(fd), offset, length, unix.FADV_NORMAL)
+}
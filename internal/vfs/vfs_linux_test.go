@@ -0,0 +1,46 @@
+//go:build linux
+
+package vfs
+
+import (
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/spf13/afero"
+)
+
+func TestPreallocate(t *testing.T) {
+	fs := New(afero.NewOsFs())
+	path := filepath.Join(t.TempDir(), "1.dat")
+	file, err := fs.Create(path)
+	if err != nil {
+		t.Fatalf("could not create file: %v", err)
+	}
+	defer file.Close()
+	file.Write([]byte("header"))
+
+	const size = 1 << 20
+	if err := fs.Preallocate(file, 0, size); err != nil {
+		t.Skipf("file system does not support preallocation: %v", err)
+	}
+	info, _ := file.Stat()
+	if info.Size() != 6 {
+		t.Errorf("expected the size to stay 6, got %d", info.Size())
+	}
+	if blocks := info.Sys().(*syscall.Stat_t).Blocks * 512; blocks < size {
+		t.Errorf("expected at least %d bytes to be allocated, got %d", size, blocks)
+	}
+
+	// Truncating the file to it's size frees the space past the end
+	if err := file.Truncate(info.Size()); err != nil {
+		t.Fatalf("truncate failed: %v", err)
+	}
+	info, _ = file.Stat()
+	if blocks := info.Sys().(*syscall.Stat_t).Blocks * 512; blocks >= size {
+		t.Errorf("expected the reserved space to be freed, %d bytes are allocated", blocks)
+	}
+	if err := fs.Advise(file, 0, 0, AdviceSequential); err != nil {
+		t.Errorf("advise failed: %v", err)
+	}
+}
@@ -0,0 +1,13 @@
+//go:build !linux
+
+package vfs
+
+import "errors"
+
+func preallocate(fd uintptr, offset int64, length int64) error {
+	return errors.ErrUnsupported
+}
+
+func advise(fd uintptr, offset int64, length int64, advice Advice) error {
+	return errors.ErrUnsupported
+}
@@ -0,0 +1,29 @@
+package vfs
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/spf13/afero"
+)
+
+func TestUnsupportedFile(t *testing.T) {
+	fs := New(afero.NewMemMapFs())
+	file, err := fs.Create("1.dat")
+	if err != nil {
+		t.Fatalf("could not create file: %v", err)
+	}
+	defer file.Close()
+	if err := fs.Preallocate(file, 0, 1024); !errors.Is(err, errors.ErrUnsupported) {
+		t.Errorf("expected ErrUnsupported, got %v", err)
+	}
+	if err := fs.Advise(file, 0, 0, AdviceSequential); !errors.Is(err, errors.ErrUnsupported) {
+		t.Errorf("expected ErrUnsupported, got %v", err)
+	}
+	if err := fs.SyncDir("/"); err != nil {
+		t.Errorf("expected SyncDir to work on any file system, got %v", err)
+	}
+	if New(fs) != fs {
+		t.Errorf("expected New to return an FS as it is")
+	}
+}
@@ -0,0 +1,163 @@
+package xxhash
+
+import (
+	"encoding/binary"
+	"hash"
+	"math/bits"
+)
+
+// Implementation of the 64 bit xxHash (XXH64) algorithm with a seed of 0, see
+// https://github.com/Cyan4973/xxHash/blob/dev/doc/xxhash_spec.md
+
+const (
+	prime1 uint64 = 11400714785074694791
+	prime2 uint64 = 14029467366897019727
+	prime3 uint64 = 1609587929392839161
+	prime4 uint64 = 9650029242287828579
+	prime5 uint64 = 2870177450012600261
+)
+
+const (
+	stripeSize = 32
+	// Size is the size of the checksum in bytes
+	Size = 8
+	// BlockSize is the size of the stripes the input is processed in
+	BlockSize = stripeSize
+)
+
+// Digest computes the XXH64 checksum of the data written to it. It implements hash.Hash64, and hash.Hash32 with the
+// low 32 bits of the checksum
+type Digest struct {
+	v1, v2, v3, v4 uint64
+	total          uint64
+	mem            [stripeSize]byte
+	n              int // Number of bytes in mem
+}
+
+var _ hash.Hash64 = (*Digest)(nil)
+var _ hash.Hash32 = (*Digest)(nil)
+
+// New returns a new Digest
+func New() *Digest {
+	d := &Digest{}
+	d.Reset()
+	return d
+}
+
+// Reset resets the digest to it's initial state
+func (d *Digest) Reset() {
+	// The initial values wrap around, which constant expressions don't allow
+	d.v1 = prime1
+	d.v1 += prime2
+	d.v2 = prime2
+	d.v3 = 0
+	d.v4 = 0
+	d.v4 -= prime1
+	d.total = 0
+	d.n = 0
+}
+
+// Size returns the number of bytes returned by Sum
+func (d *Digest) Size() int { return Size }
+
+// BlockSize returns the size of the stripes the input is processed in
+func (d *Digest) BlockSize() int { return BlockSize }
+
+// Write adds the bytes to the checksum, it never returns an error
+func (d *Digest) Write(b []byte) (int, error) {
+	n := len(b)
+	d.total += uint64(n)
+
+	// Complete the stripe in mem first
+	if d.n > 0 {
+		copied := copy(d.mem[d.n:], b)
+		d.n += copied
+		b = b[copied:]
+		if d.n < stripeSize {
+			return n, nil
+		}
+		d.stripe(d.mem[:])
+		d.n = 0
+	}
+	for len(b) >= stripeSize {
+		d.stripe(b[:stripeSize])
+		b = b[stripeSize:]
+	}
+	d.n = copy(d.mem[:], b)
+	return n, nil
+}
+
+func (d *Digest) stripe(b []byte) {
+	d.v1 = round(d.v1, binary.LittleEndian.Uint64(b[0:]))
+	d.v2 = round(d.v2, binary.LittleEndian.Uint64(b[8:]))
+	d.v3 = round(d.v3, binary.LittleEndian.Uint64(b[16:]))
+	d.v4 = round(d.v4, binary.LittleEndian.Uint64(b[24:]))
+}
+
+// Sum64 returns the checksum of the data written so far
+func (d *Digest) Sum64() uint64 {
+	var h uint64
+	if d.total >= stripeSize {
+		h = bits.RotateLeft64(d.v1, 1) + bits.RotateLeft64(d.v2, 7) + bits.RotateLeft64(d.v3, 12) +
+			bits.RotateLeft64(d.v4, 18)
+		h = mergeRound(h, d.v1)
+		h = mergeRound(h, d.v2)
+		h = mergeRound(h, d.v3)
+		h = mergeRound(h, d.v4)
+	} else {
+		h = d.v3 + prime5
+	}
+	h += d.total
+
+	b := d.mem[:d.n]
+	for ; len(b) >= 8; b = b[8:] {
+		h ^= round(0, binary.LittleEndian.Uint64(b))
+		h = bits.RotateLeft64(h, 27)*prime1 + prime4
+	}
+	if len(b) >= 4 {
+		h ^= uint64(binary.LittleEndian.Uint32(b)) * prime1
+		h = bits.RotateLeft64(h, 23)*prime2 + prime3
+		b = b[4:]
+	}
+	for _, c := range b {
+		h ^= uint64(c) * prime5
+		h = bits.RotateLeft64(h, 11) * prime1
+	}
+
+	h ^= h >> 33
+	h *= prime2
+	h ^= h >> 29
+	h *= prime3
+	h ^= h >> 32
+	return h
+}
+
+// Sum32 returns the low 32 bits of the checksum
+func (d *Digest) Sum32() uint32 {
+	return uint32(d.Sum64())
+}
+
+// Sum appends the checksum to b in big endian order
+func (d *Digest) Sum(b []byte) []byte {
+	return binary.BigEndian.AppendUint64(b, d.Sum64())
+}
+
+// Sum64 returns the XXH64 checksum of the data
+func Sum64(b []byte) uint64 {
+	var d Digest
+	d.Reset()
+	d.Write(b)
+	return d.Sum64()
+}
+
+func round(acc, input uint64) uint64 {
+	acc += input * prime2
+	acc = bits.RotateLeft64(acc, 31)
+	return acc * prime1
+}
+
+func mergeRound(acc, val uint64) uint64 {
+	val = round(0, val)
+	acc ^= val
+	return acc*prime1 + prime4
+}
@@ -0,0 +1,47 @@
+package xxhash
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSum64(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected uint64
+	}{
+		{"", 0xef46db3751d8e999},
+		{"a", 0xd24ec4f1a98c6e5b},
+		{"abc", 0x44bc2cf5ad770999},
+		{"Call me Ishmael. Some years ago--never mind how long precisely-", 0x02a2e85470d6fd96},
+	}
+	for _, test := range tests {
+		if got := Sum64([]byte(test.input)); got != test.expected {
+			t.Errorf("%q: expected %#x, got %#x", test.input, test.expected, got)
+		}
+	}
+}
+
+func TestDigestWriteInParts(t *testing.T) {
+	input := []byte(strings.Repeat("0123456789abcdef", 20) + "tail")
+	expected := Sum64(input)
+	// Every split point, so that stripes are completed from the buffered bytes and from the input
+	for i := range input {
+		d := New()
+		d.Write(input[:i])
+		d.Write(input[i:])
+		if got := d.Sum64(); got != expected {
+			t.Fatalf("split at %d: expected %#x, got %#x", i, expected, got)
+		}
+		if got := d.Sum32(); got != uint32(expected) {
+			t.Fatalf("split at %d: expected low bits %#x, got %#x", i, uint32(expected), got)
+		}
+	}
+
+	d := New()
+	d.Write(input)
+	d.Reset()
+	if got := d.Sum64(); got != Sum64(nil) {
+		t.Errorf("expected the checksum of no data after Reset, got %#x", got)
+	}
+}
@@ -0,0 +1,135 @@
+package kvdb
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+
+	"github.com/ananthvk/kvdb/internal/jsonpointer"
+)
+
+// Returned when a stored JSON document has data after the first value
+var errTrailingJSON = errors.New("invalid JSON: unexpected data after the value")
+
+// PutJSON encodes v as JSON and stores it as the value of the key
+func (dataStore *DataStore) PutJSON(key []byte, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return dataStore.Put(key, data)
+}
+
+// GetJSON reads the value of the key and decodes it as JSON into out. If the key does not exist, `ErrKeyNotFound` is returned
+func (dataStore *DataStore) GetJSON(key []byte, out any) error {
+	data, err := dataStore.Get(key)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, out)
+}
+
+// PatchJSON updates a single field of the JSON document stored at key, without the caller having to read, modify and write
+// back the whole document. path is a JSON pointer (RFC 6901) such as `/profile/email` or `/tags/-` (to append to an array).
+// Like a JSON merge patch (RFC 7386), a nil value removes the field. An empty path replaces (or removes) the whole document.
+//
+// The read, modify and write happen while holding the write lock, so concurrent patches to the same key do not lose updates.
+// If the key does not exist, and the path is not empty, `ErrKeyNotFound` is returned. If a parent of the field does not
+// exist, `ErrJSONPathNotFound` is returned
+func (dataStore *DataStore) PatchJSON(key []byte, path string, value any) error {
+	if err := dataStore.gate.enter(); err != nil {
+		return err
+	}
+	defer dataStore.gate.exit()
+	if _, err := jsonpointer.Parse(path); err != nil {
+		return err
+	}
+
+	// Round trip the value through encoding/json so that structs, typed maps etc can be stored into the decoded document
+	var patch any
+	if value != nil {
+		data, err := json.Marshal(value)
+		if err != nil {
+			return err
+		}
+		if patch, err = decodeJSON(data); err != nil {
+			return err
+		}
+	}
+
+	dataStore.lockForWrite("datastore.patch_json")
+	defer dataStore.mu.Unlock()
+
+	if path == "" {
+		if value == nil {
+			_, err := dataStore.deleteKey(key)
+			return err
+		}
+		data, err := json.Marshal(patch)
+		if err != nil {
+			return err
+		}
+		return dataStore.put(key, data)
+	}
+
+	data, err := dataStore.get(key)
+	if err != nil {
+		return err
+	}
+	doc, err := decodeJSON(data)
+	if err != nil {
+		return err
+	}
+	if value == nil {
+		doc, err = jsonpointer.Delete(doc, path)
+	} else {
+		doc, err = jsonpointer.Set(doc, path, patch)
+	}
+	if err != nil {
+		return err
+	}
+	data, err = json.Marshal(doc)
+	if err != nil {
+		return err
+	}
+	return dataStore.put(key, data)
+}
+
+// decodeJSON decodes the data into a generic document. Numbers are kept as json.Number so that large integers
+// survive a decode / encode round trip without losing precision
+func decodeJSON(data []byte) (any, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var doc any
+	if err := decoder.Decode(&doc); err != nil {
+		return nil, err
+	}
+	// The data must hold exactly one value, anything after it is an error (and not silently dropped)
+	if err := decoder.Decode(&struct{}{}); err != io.EOF {
+		return nil, errTrailingJSON
+	}
+	return doc, nil
+}
+
+// GetJSONPath reads the JSON document stored at key, and decodes the value at path (a JSON pointer, see PatchJSON) into out.
+// If the key does not exist, `ErrKeyNotFound` is returned, and if there is no value at path, `ErrJSONPathNotFound` is returned
+func (dataStore *DataStore) GetJSONPath(key []byte, path string, out any) error {
+	data, err := dataStore.Get(key)
+	if err != nil {
+		return err
+	}
+	doc, err := decodeJSON(data)
+	if err != nil {
+		return err
+	}
+	value, err := jsonpointer.Get(doc, path)
+	if err != nil {
+		return err
+	}
+	data, err = json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, out)
+}
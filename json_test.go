@@ -0,0 +1,111 @@
+package kvdb
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/spf13/afero"
+)
+
+type testProfile struct {
+	Name  string   `json:"name"`
+	Email string   `json:"email"`
+	Tags  []string `json:"tags"`
+}
+
+func TestPutGetJSON(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	store := helperCreateMultipleDataFiles(t, fs, "test_json.db")
+	defer store.Close()
+
+	in := testProfile{Name: "alice", Email: "alice@example.com", Tags: []string{"a", "b"}}
+	if err := store.PutJSON([]byte("user:1"), in); err != nil {
+		t.Fatalf("PutJSON failed: %v", err)
+	}
+
+	var out testProfile
+	if err := store.GetJSON([]byte("user:1"), &out); err != nil {
+		t.Fatalf("GetJSON failed: %v", err)
+	}
+	if out.Name != in.Name || out.Email != in.Email || len(out.Tags) != 2 {
+		t.Errorf("expected %+v, got %+v", in, out)
+	}
+
+	if err := store.GetJSON([]byte("missing"), &out); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("expected ErrKeyNotFound, got %v", err)
+	}
+}
+
+func TestPatchJSON(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	store := helperCreateMultipleDataFiles(t, fs, "test_json_patch.db")
+	defer store.Close()
+
+	key := []byte("user:1")
+	if err := store.PutJSON(key, testProfile{Name: "alice", Email: "alice@example.com", Tags: []string{"a"}}); err != nil {
+		t.Fatalf("PutJSON failed: %v", err)
+	}
+
+	if err := store.PatchJSON(key, "/email", "alice@example.org"); err != nil {
+		t.Fatalf("PatchJSON failed: %v", err)
+	}
+	if err := store.PatchJSON(key, "/tags/-", "b"); err != nil {
+		t.Fatalf("PatchJSON append failed: %v", err)
+	}
+	if err := store.PatchJSON(key, "/name", nil); err != nil {
+		t.Fatalf("PatchJSON delete failed: %v", err)
+	}
+
+	val, err := store.Get(key)
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	expected := `{"email":"alice@example.org","tags":["a","b"]}`
+	if string(val) != expected {
+		t.Errorf("expected %s, got %s", expected, val)
+	}
+
+	if err := store.PatchJSON(key, "/profile/age", 20); !errors.Is(err, ErrJSONPathNotFound) {
+		t.Errorf("expected ErrJSONPathNotFound, got %v", err)
+	}
+	if err := store.PatchJSON(key, "email", 20); !errors.Is(err, ErrInvalidJSONPath) {
+		t.Errorf("expected ErrInvalidJSONPath, got %v", err)
+	}
+	if err := store.PatchJSON([]byte("missing"), "/email", "x"); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("expected ErrKeyNotFound, got %v", err)
+	}
+
+	// An empty path replaces the whole document, and creates the key if it does not exist
+	if err := store.PatchJSON([]byte("counter"), "", map[string]int{"n": 1}); err != nil {
+		t.Fatalf("PatchJSON root failed: %v", err)
+	}
+	val, _ = store.Get([]byte("counter"))
+	if string(val) != `{"n":1}` {
+		t.Errorf("expected {\"n\":1}, got %s", val)
+	}
+}
+
+func TestJSONRejectsTrailingData(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	store := helperCreateMultipleDataFiles(t, fs, "test_json_trailing.db")
+	defer store.Close()
+
+	key := []byte("doc")
+	store.Put(key, []byte(`{"a":1} garbage`))
+	var out any
+	if err := store.GetJSONPath(key, "/a", &out); err == nil {
+		t.Errorf("expected an error for trailing data, got %v", out)
+	}
+	if err := store.PatchJSON(key, "/a", 2); err == nil {
+		t.Errorf("expected an error for trailing data")
+	}
+	if val, _ := store.Get(key); string(val) != `{"a":1} garbage` {
+		t.Errorf("expected the value to be unchanged, got %s", val)
+	}
+
+	// Whitespace after the value is allowed
+	store.Put(key, []byte("{\"a\":1}\n"))
+	if err := store.GetJSONPath(key, "/a", &out); err != nil {
+		t.Errorf("expected trailing whitespace to be accepted, got %v", err)
+	}
+}
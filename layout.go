@@ -0,0 +1,54 @@
+package kvdb
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/ananthvk/kvdb/internal/filemanager"
+	"github.com/ananthvk/kvdb/internal/metafile"
+	"github.com/spf13/afero"
+)
+
+// layoutOf returns the directories of the files of the datastore at path, as stored in it's metafile. The merged
+// directory defaults to the active directory, so that setting only Options.ActiveDir moves every data file
+func layoutOf(path string, metainfo *metafile.MetaData) filemanager.Layout {
+	return filemanager.ResolveLayout(path, metainfo.ActiveDir, metainfo.MergedDir, metainfo.HintDir)
+}
+
+// readLayout reads the directories of the files of the datastore at path from it's metafile
+func readLayout(fs afero.Fs, path string) (filemanager.Layout, error) {
+	metainfo, err := metafile.ReadMetaFile(fs, path)
+	if err != nil {
+		return filemanager.Layout{}, err
+	}
+	return layoutOf(path, metainfo), nil
+}
+
+// createLayout creates the directories of a new datastore. Directories outside the datastore directory may already
+// exist, but they must be empty, so that two datastores never share a directory
+func createLayout(fs afero.Fs, layout filemanager.Layout) error {
+	for _, dir := range layout.Dirs() {
+		if valid, reason, err := metafile.IsValidPath(fs, dir); err != nil {
+			return err
+		} else if !valid {
+			return fmt.Errorf("%s: %w", dir, errors.New(reason))
+		}
+	}
+	for _, dir := range layout.Dirs() {
+		if err := fs.MkdirAll(dir, os.ModePerm); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DataDirs returns the directory of the active data file, of the merged data files, and of the hint files, see
+// Options.ActiveDir
+func (dataStore *DataStore) DataDirs() (active string, merged string, hint string) {
+	if !dataStore.opened() {
+		return "", "", ""
+	}
+	layout := dataStore.fileManager.Layout()
+	return layout.ActiveDir, layout.MergedDir, layout.HintDir
+}
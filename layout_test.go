@@ -0,0 +1,96 @@
+package kvdb
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/ananthvk/kvdb/internal/metafile"
+	"github.com/ananthvk/kvdb/internal/utils"
+	"github.com/spf13/afero"
+)
+
+func TestSplitLayout(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	path := "test_split_layout.db"
+	opts := &Options{ActiveDir: "/fast/active", MergedDir: "/capacity/merged", HintDir: "hints"}
+	store, err := CreateWithOptions(fs, path, opts)
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	store.Put([]byte("key1"), []byte("value1"))
+	store.Put([]byte("key2"), []byte("value2"))
+	store.Close()
+
+	// The directories are read from the metafile
+	store, err = Open(fs, path)
+	if err != nil {
+		t.Fatalf("failed to reopen store: %v", err)
+	}
+	store.Delete([]byte("key2"))
+	store.Put([]byte("key3"), []byte("value3"))
+	store.Close()
+	store, err = Open(fs, path)
+	if err != nil {
+		t.Fatalf("failed to reopen store: %v", err)
+	}
+	active, merged, hint := store.DataDirs()
+	if active != "/fast/active" || merged != "/capacity/merged" || hint != filepath.Join(path, "hints") {
+		t.Errorf("unexpected directories %s, %s, %s", active, merged, hint)
+	}
+	store.Put([]byte("key4"), []byte("value4"))
+	for _, id := range []int{1, 2, 3} {
+		if exists, _ := afero.Exists(fs, filepath.Join("/fast/active", utils.GetDataFileName(id))); !exists {
+			t.Errorf("expected data file %d in the active directory", id)
+		}
+	}
+	if err := store.Merge(); err != nil {
+		t.Fatalf("merge failed: %v", err)
+	}
+	store.Close()
+
+	if exists, _ := afero.Exists(fs, filepath.Join("/capacity/merged", utils.GetDataFileName(4))); !exists {
+		t.Errorf("expected the merge output in the merged directory")
+	}
+	if exists, _ := afero.Exists(fs, filepath.Join(path, "hints", utils.GetHintFileName(4))); !exists {
+		t.Errorf("expected the hint file of the merge output in the hint directory")
+	}
+	for _, id := range []int{1, 2} {
+		if exists, _ := afero.Exists(fs, filepath.Join("/fast/active", utils.GetDataFileName(id))); exists {
+			t.Errorf("expected merged data file %d to be removed", id)
+		}
+	}
+	if exists, _ := afero.Exists(fs, filepath.Join(path, "data")); exists {
+		t.Errorf("expected no data directory in the datastore directory")
+	}
+
+	store, err = Open(fs, path)
+	if err != nil {
+		t.Fatalf("failed to reopen store: %v", err)
+	}
+	defer store.Close()
+	for _, key := range []string{"key1", "key3", "key4"} {
+		val, err := store.Get([]byte(key))
+		if err != nil || string(val) != "value"+key[3:] {
+			t.Errorf("%s: expected value%s, got %s (err: %v)", key, key[3:], val, err)
+		}
+	}
+	if _, err := store.Get([]byte("key2")); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("expected deleted key2 to stay deleted, got %v", err)
+	}
+	if report, err := store.Verify(); err != nil || !report.OK() {
+		t.Errorf("expected verify to pass, got %+v (err: %v)", report, err)
+	}
+}
+
+func TestCreateFailsWithNonEmptyDirectory(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	path := "test_layout_not_empty.db"
+	afero.WriteFile(fs, "/fast/active/other", []byte("other"), 0644)
+	if _, err := CreateWithOptions(fs, path, &Options{ActiveDir: "/fast/active"}); err == nil {
+		t.Fatalf("expected create to fail with a non empty active directory")
+	}
+	if exists, _ := metafile.IsDatastore(fs, path); exists {
+		t.Errorf("expected no datastore to be created")
+	}
+}
@@ -0,0 +1,142 @@
+package kvdb
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+/*
+Leases
+
+A lease gives one owner a key for a limited time, which can be used as a lock between the processes (or goroutines) that
+share a datastore. AcquireLease succeeds if the key has no lease, or if the previous lease has expired or was released,
+and the owner has to Renew the lease before it expires to keep it.
+
+Every lease gets a fencing token, which is larger than the token of every earlier lease on the key. A lease can expire
+while it's owner still thinks it has it (for example, after a long GC pause), so the owner should send the token with
+every write it makes to the resource the lease protects, and the resource should reject writes with a token smaller than
+the largest one it has seen. Without the tokens, a lease alone can't keep two owners from writing at the same time.
+
+The lease is stored as the (JSON) value of the key, and is read and updated while holding the write lock, so that two
+owners can't acquire it at the same time. Released leases are kept (with their token), so that the tokens keep
+increasing. Deleting the key of a lease restarts the tokens from 1, so lease keys should not be deleted while any
+resource still checks their tokens. Expiry is checked with the clock of the process, so the processes sharing a
+datastore must have clocks that roughly agree
+*/
+
+// Lease is a lease on a key, see AcquireLease
+type Lease struct {
+	Key []byte
+	// Token is the fencing token of the lease, it's larger than the token of every earlier lease on the key
+	Token uint64
+	// Expires is the time at which the lease expires, unless it's renewed
+	Expires time.Time
+
+	dataStore *DataStore
+}
+
+// leaseValue is the value stored for a lease. A released lease has a zero Expires
+type leaseValue struct {
+	Token   uint64    `json:"token"`
+	Expires time.Time `json:"expires"`
+}
+
+// Time used to check and set the expiry of leases, replaced by tests
+var leaseNow = time.Now
+
+// AcquireLease acquires a lease on the key for ttl. If another owner holds a lease on the key that has not expired,
+// ErrLeaseHeld is returned
+func (dataStore *DataStore) AcquireLease(key []byte, ttl time.Duration) (*Lease, error) {
+	if ttl <= 0 {
+		return nil, fmt.Errorf("invalid lease duration %s", ttl)
+	}
+	var lease *Lease
+	err := dataStore.updateLease(key, "datastore.lease_acquire", func(current *leaseValue, now time.Time) (*leaseValue, error) {
+		next := &leaseValue{Token: 1, Expires: now.Add(ttl)}
+		if current != nil {
+			if now.Before(current.Expires) {
+				return nil, ErrLeaseHeld
+			}
+			next.Token = current.Token + 1
+		}
+		lease = &Lease{Key: bytes.Clone(key), Token: next.Token, Expires: next.Expires, dataStore: dataStore}
+		return next, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return lease, nil
+}
+
+// Renew extends the lease to ttl from now. If the lease has expired (or was released), ErrLeaseLost is returned, and
+// the owner must stop using the resource the lease protects
+func (lease *Lease) Renew(ttl time.Duration) error {
+	if ttl <= 0 {
+		return fmt.Errorf("invalid lease duration %s", ttl)
+	}
+	var expires time.Time
+	err := lease.dataStore.updateLease(lease.Key, "datastore.lease_renew", func(current *leaseValue, now time.Time) (*leaseValue, error) {
+		if current == nil || current.Token != lease.Token || !now.Before(current.Expires) {
+			return nil, ErrLeaseLost
+		}
+		expires = now.Add(ttl)
+		return &leaseValue{Token: lease.Token, Expires: expires}, nil
+	})
+	if err != nil {
+		return err
+	}
+	lease.Expires = expires
+	return nil
+}
+
+// Release releases the lease, so that the key can be acquired by another owner before the lease expires. If the key
+// has a newer lease, ErrLeaseLost is returned. Releasing a lease that has expired (but was not acquired by another
+// owner) is not an error
+func (lease *Lease) Release() error {
+	err := lease.dataStore.updateLease(lease.Key, "datastore.lease_release", func(current *leaseValue, now time.Time) (*leaseValue, error) {
+		if current == nil || current.Token != lease.Token {
+			return nil, ErrLeaseLost
+		}
+		return &leaseValue{Token: lease.Token}, nil
+	})
+	if err != nil {
+		return err
+	}
+	lease.Expires = time.Time{}
+	return nil
+}
+
+// updateLease reads the lease on the key (nil if the key does not exist), and stores the lease returned by update,
+// while holding the write lock
+func (dataStore *DataStore) updateLease(key []byte, site string, update func(current *leaseValue, now time.Time) (*leaseValue, error)) error {
+	if err := dataStore.gate.enter(); err != nil {
+		return err
+	}
+	defer dataStore.gate.exit()
+	dataStore.lockForWrite(site)
+	defer dataStore.mu.Unlock()
+
+	var current *leaseValue
+	data, err := dataStore.get(key)
+	if err == nil {
+		dataStore.counters.gets.Add(1)
+		current = &leaseValue{}
+		if err := json.Unmarshal(data, current); err != nil || current.Token == 0 {
+			return ErrNotLease
+		}
+	} else if !errors.Is(err, ErrKeyNotFound) {
+		return err
+	}
+	next, err := update(current, leaseNow())
+	if err != nil {
+		return err
+	}
+	data, err = json.Marshal(next)
+	if err != nil {
+		return err
+	}
+	return dataStore.put(key, data)
+}
@@ -0,0 +1,152 @@
+package kvdb
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/spf13/afero"
+)
+
+// helperLeaseClock replaces the clock of the leases with one that only moves when the returned function is called
+func helperLeaseClock(t *testing.T) func(time.Duration) {
+	t.Helper()
+	var mu sync.Mutex
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	leaseNow = func() time.Time {
+		mu.Lock()
+		defer mu.Unlock()
+		return now
+	}
+	t.Cleanup(func() { leaseNow = time.Now })
+	return func(d time.Duration) {
+		mu.Lock()
+		defer mu.Unlock()
+		now = now.Add(d)
+	}
+}
+
+func TestLease(t *testing.T) {
+	advance := helperLeaseClock(t)
+	store, err := Create(afero.NewMemMapFs(), "test_lease.db")
+	if err != nil {
+		t.Fatalf("could not create datastore: %v", err)
+	}
+	defer store.Close()
+	key := []byte("lock")
+
+	first, err := store.AcquireLease(key, 10*time.Second)
+	if err != nil || first.Token != 1 {
+		t.Fatalf("expected the first lease to have token 1, got %+v, %v", first, err)
+	}
+	if _, err := store.AcquireLease(key, 10*time.Second); !errors.Is(err, ErrLeaseHeld) {
+		t.Errorf("expected ErrLeaseHeld, got %v", err)
+	}
+
+	advance(5 * time.Second)
+	if err := first.Renew(10 * time.Second); err != nil {
+		t.Fatalf("renew failed: %v", err)
+	}
+	advance(9 * time.Second)
+	if _, err := store.AcquireLease(key, 10*time.Second); !errors.Is(err, ErrLeaseHeld) {
+		t.Errorf("expected the renewed lease to be held, got %v", err)
+	}
+
+	// Once the lease expires, another owner gets it with a larger token, and the first owner can't renew or release it
+	advance(2 * time.Second)
+	second, err := store.AcquireLease(key, 10*time.Second)
+	if err != nil || second.Token != 2 {
+		t.Fatalf("expected the second lease to have token 2, got %+v, %v", second, err)
+	}
+	if err := first.Renew(10 * time.Second); !errors.Is(err, ErrLeaseLost) {
+		t.Errorf("expected ErrLeaseLost when renewing an expired lease, got %v", err)
+	}
+	if err := first.Release(); !errors.Is(err, ErrLeaseLost) {
+		t.Errorf("expected ErrLeaseLost when releasing a lease that was taken, got %v", err)
+	}
+
+	// A released lease can be acquired right away, and the token keeps increasing
+	if err := second.Release(); err != nil {
+		t.Fatalf("release failed: %v", err)
+	}
+	if err := second.Renew(time.Second); !errors.Is(err, ErrLeaseLost) {
+		t.Errorf("expected ErrLeaseLost when renewing a released lease, got %v", err)
+	}
+	third, err := store.AcquireLease(key, 10*time.Second)
+	if err != nil || third.Token != 3 {
+		t.Fatalf("expected the third lease to have token 3, got %+v, %v", third, err)
+	}
+}
+
+func TestLeaseSurvivesReopen(t *testing.T) {
+	advance := helperLeaseClock(t)
+	fs := afero.NewMemMapFs()
+	path := "test_lease_reopen.db"
+	store, err := Create(fs, path)
+	if err != nil {
+		t.Fatalf("could not create datastore: %v", err)
+	}
+	lease, err := store.AcquireLease([]byte("lock"), time.Minute)
+	if err != nil {
+		t.Fatalf("acquire failed: %v", err)
+	}
+	lease.Release()
+	store.Close()
+
+	store, err = Open(fs, path)
+	if err != nil {
+		t.Fatalf("could not open datastore: %v", err)
+	}
+	defer store.Close()
+	advance(time.Second)
+	lease, err = store.AcquireLease([]byte("lock"), time.Minute)
+	if err != nil || lease.Token != 2 {
+		t.Errorf("expected the token to continue after a reopen, got %+v, %v", lease, err)
+	}
+}
+
+func TestLeaseConcurrentAcquire(t *testing.T) {
+	helperLeaseClock(t)
+	store, err := Create(afero.NewMemMapFs(), "test_lease_concurrent.db")
+	if err != nil {
+		t.Fatalf("could not create datastore: %v", err)
+	}
+	defer store.Close()
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	acquired := 0
+	for range 16 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := store.AcquireLease([]byte("lock"), time.Minute); err == nil {
+				mu.Lock()
+				acquired++
+				mu.Unlock()
+			} else if !errors.Is(err, ErrLeaseHeld) {
+				t.Errorf("unexpected error: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+	if acquired != 1 {
+		t.Errorf("expected exactly one owner to acquire the lease, got %d", acquired)
+	}
+}
+
+func TestLeaseNotALease(t *testing.T) {
+	store, err := Create(afero.NewMemMapFs(), "test_lease_invalid.db")
+	if err != nil {
+		t.Fatalf("could not create datastore: %v", err)
+	}
+	defer store.Close()
+	store.Put([]byte("key"), []byte("value"))
+	if _, err := store.AcquireLease([]byte("key"), time.Minute); !errors.Is(err, ErrNotLease) {
+		t.Errorf("expected ErrNotLease, got %v", err)
+	}
+	if _, err := store.AcquireLease([]byte("lock"), 0); err == nil {
+		t.Errorf("expected an error for a zero duration")
+	}
+}
@@ -0,0 +1,185 @@
+package kvdb
+
+import (
+	"encoding/binary"
+)
+
+// List is a list of values stored as the value of a single key, see types.go. It's a handle, nothing is read until a
+// method is called, and the list exists as long as it has an element
+type List struct {
+	dataStore *DataStore
+	key       []byte
+}
+
+// List returns the list stored at the key. The methods of the list fail with a *WrongTypeError if the key holds a value
+// of another type
+func (dataStore *DataStore) List(key []byte) *List {
+	return &List{dataStore: dataStore, key: key}
+}
+
+// Key returns the key of the list
+func (l *List) Key() []byte {
+	return l.key
+}
+
+// PushFront inserts the values at the head of the list one after the other (so the last value becomes the head, like
+// LPUSH), and returns the length of the list
+func (l *List) PushFront(values ...[]byte) (int, error) {
+	return l.push(values, true)
+}
+
+// PushBack appends the values at the tail of the list, and returns the length of the list
+func (l *List) PushBack(values ...[]byte) (int, error) {
+	return l.push(values, false)
+}
+
+func (l *List) push(values [][]byte, front bool) (int, error) {
+	length := 0
+	err := l.dataStore.updateStructured(l.key, ValueTypeList, "datastore.list_push", func(encoded []byte, _ bool) ([]byte, error) {
+		elements, err := decodeList(encoded)
+		if err != nil {
+			return nil, err
+		}
+		if front {
+			pushed := make([][]byte, 0, len(values)+len(elements))
+			for i := len(values) - 1; i >= 0; i-- {
+				pushed = append(pushed, values[i])
+			}
+			elements = append(pushed, elements...)
+		} else {
+			elements = append(elements, values...)
+		}
+		length = len(elements)
+		return encodeList(elements), nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return length, nil
+}
+
+// PopFront removes up to count values from the head of the list, and returns them. The result is nil if the list does
+// not exist. The list is deleted when it's last value is removed
+func (l *List) PopFront(count int) ([][]byte, error) {
+	return l.pop(count, true)
+}
+
+// PopBack removes up to count values from the tail of the list, and returns them, the last value first
+func (l *List) PopBack(count int) ([][]byte, error) {
+	return l.pop(count, false)
+}
+
+func (l *List) pop(count int, front bool) ([][]byte, error) {
+	if count <= 0 {
+		// Nothing is removed, but the type of the key is still checked
+		_, _, err := l.dataStore.readStructured(l.key, ValueTypeList)
+		return [][]byte{}, err
+	}
+	var popped [][]byte
+	err := l.dataStore.updateStructured(l.key, ValueTypeList, "datastore.list_pop", func(encoded []byte, _ bool) ([]byte, error) {
+		elements, err := decodeList(encoded)
+		if err != nil {
+			return nil, err
+		}
+		if len(elements) == 0 {
+			// The list does not exist
+			return nil, nil
+		}
+		count = min(count, len(elements))
+		if front {
+			popped, elements = elements[:count], elements[count:]
+		} else {
+			popped = make([][]byte, 0, count)
+			for i := len(elements) - 1; i >= len(elements)-count; i-- {
+				popped = append(popped, elements[i])
+			}
+			elements = elements[:len(elements)-count]
+		}
+		return encodeList(elements), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return popped, nil
+}
+
+// Len returns the number of values of the list, 0 if the list does not exist
+func (l *List) Len() (int, error) {
+	encoded, _, err := l.dataStore.readStructured(l.key, ValueTypeList)
+	if err != nil || len(encoded) == 0 {
+		return 0, err
+	}
+	n, m := binary.Uvarint(encoded)
+	if m <= 0 {
+		return 0, errInvalidStructured
+	}
+	return int(n), nil
+}
+
+// Range returns the values from index start to index stop (both inclusive), like LRANGE. Negative indexes are from the
+// end of the list (-1 is the last value), and indexes out of the list are clamped to it
+func (l *List) Range(start, stop int) ([][]byte, error) {
+	encoded, _, err := l.dataStore.readStructured(l.key, ValueTypeList)
+	if err != nil {
+		return nil, err
+	}
+	elements, err := decodeList(encoded)
+	if err != nil {
+		return nil, err
+	}
+	n := len(elements)
+	if start < 0 {
+		start = max(n+start, 0)
+	}
+	if stop < 0 {
+		stop = n + stop
+	}
+	stop = min(stop, n-1)
+	if start > stop {
+		return [][]byte{}, nil
+	}
+	return elements[start : stop+1], nil
+}
+
+// encodeList returns the encoding of the elements (see types.go), nil if there are none
+func encodeList(elements [][]byte) []byte {
+	if len(elements) == 0 {
+		return nil
+	}
+	size := binary.MaxVarintLen64
+	for _, element := range elements {
+		size += binary.MaxVarintLen32 + len(element)
+	}
+	encoded := make([]byte, 0, size)
+	encoded = binary.AppendUvarint(encoded, uint64(len(elements)))
+	for _, element := range elements {
+		encoded = appendBytes(encoded, element)
+	}
+	return encoded
+}
+
+// decodeList decodes the encoding of a list, an empty encoding is an empty list
+func decodeList(encoded []byte) ([][]byte, error) {
+	if len(encoded) == 0 {
+		return nil, nil
+	}
+	n, m := binary.Uvarint(encoded)
+	// Every element takes at least a byte
+	if m <= 0 || n > uint64(len(encoded)) {
+		return nil, errInvalidStructured
+	}
+	encoded = encoded[m:]
+	elements := make([][]byte, 0, n)
+	for range n {
+		var element []byte
+		var ok bool
+		if element, encoded, ok = cutBytes(encoded); !ok {
+			return nil, errInvalidStructured
+		}
+		elements = append(elements, element)
+	}
+	if len(encoded) != 0 {
+		return nil, errInvalidStructured
+	}
+	return elements, nil
+}
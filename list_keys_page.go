@@ -0,0 +1,77 @@
+package kvdb
+
+import (
+	"errors"
+	"sort"
+	"unsafe"
+)
+
+// Returned by ListKeysPage if the limit is not positive
+var errInvalidPageLimit = errors.New("page limit must be positive")
+
+// keySnapshot is a sorted copy of the keys, it's reused by ListKeysPage until the set of keys changes
+type keySnapshot struct {
+	generation uint64
+	keys       []string
+}
+
+// memoryUsage returns the memory used by the snapshot, the strings are shared with the keydir and are not counted
+func (s *keySnapshot) memoryUsage() int64 {
+	return int64(cap(s.keys)) * int64(unsafe.Sizeof(""))
+}
+
+// ListKeysPage returns up to limit keys in sorted (byte) order starting at cursor, and the cursor of the next page. An
+// empty cursor starts from the first key, and an empty next cursor is returned after the last page. Cursors should be
+// treated as opaque, the next cursor is the smallest string that sorts after the last key of the page. Pages are stable,
+// i.e. a key that exists for the whole iteration is returned exactly once, even if other keys are added or deleted in
+// between.
+//
+// The keydir is a hash map, so the keys are sorted into a snapshot that is shared by the following calls until a key is
+// added or removed. Paginating through a store that is not being written to sorts the keys only once
+func (dataStore *DataStore) ListKeysPage(cursor string, limit int) ([]string, string, error) {
+	if err := dataStore.gate.enter(); err != nil {
+		return nil, "", err
+	}
+	defer dataStore.gate.exit()
+	if limit <= 0 {
+		return nil, "", errInvalidPageLimit
+	}
+	keys := dataStore.sortedKeys()
+
+	start := sort.SearchStrings(keys, cursor)
+	end := min(start+limit, len(keys))
+	page := make([]string, end-start)
+	copy(page, keys[start:end])
+
+	next := ""
+	if end < len(keys) {
+		next = page[len(page)-1] + "\x00"
+	}
+	return page, next, nil
+}
+
+// sortedKeys returns the current keys in sorted order, the returned slice must not be modified. The keys are copied with
+// the lock held, and sorted after it's released, so that writes are not blocked while a large keydir is sorted
+func (dataStore *DataStore) sortedKeys() []string {
+	dataStore.mu.RLock()
+	generation := dataStore.keydir.Generation()
+	if snapshot := dataStore.keySnapshot.Load(); snapshot != nil && snapshot.generation == generation {
+		dataStore.mu.RUnlock()
+		return snapshot.keys
+	}
+	keys := dataStore.keydir.GetAllKeys()
+	dataStore.mu.RUnlock()
+
+	sort.Strings(keys)
+	// The generation only increases, a snapshot of a newer generation stored by another call in the meantime is kept
+	for {
+		current := dataStore.keySnapshot.Load()
+		if current != nil && current.generation >= generation {
+			break
+		}
+		if dataStore.keySnapshot.CompareAndSwap(current, &keySnapshot{generation: generation, keys: keys}) {
+			break
+		}
+	}
+	return keys
+}
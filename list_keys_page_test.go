@@ -0,0 +1,68 @@
+package kvdb
+
+import (
+	"fmt"
+	"slices"
+	"testing"
+
+	"github.com/spf13/afero"
+)
+
+func TestListKeysPage(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	store := helperCreateMultipleDataFiles(t, fs, "test_list_keys_page.db")
+	defer store.Close()
+
+	var expected []string
+	for i := range 25 {
+		key := fmt.Sprintf("key%02d", i)
+		store.Put([]byte(key), []byte("value"))
+		expected = append(expected, key)
+	}
+	// Empty keys sort first, and must not end the iteration
+	store.Put([]byte(""), []byte("value"))
+	expected = append([]string{""}, expected...)
+
+	var got []string
+	cursor := ""
+	pages := 0
+	for {
+		page, next, err := store.ListKeysPage(cursor, 1)
+		if err != nil {
+			t.Fatalf("ListKeysPage failed: %v", err)
+		}
+		got = append(got, page...)
+		pages++
+		if next == "" {
+			break
+		}
+		cursor = next
+	}
+	if !slices.Equal(got, expected) || pages != len(expected) {
+		t.Errorf("expected %v in %d pages, got %v in %d pages", expected, len(expected), got, pages)
+	}
+
+	page, next, err := store.ListKeysPage("", 10)
+	if err != nil || len(page) != 10 || page[9] != "key08" {
+		t.Fatalf("unexpected first page %v, err %v", page, err)
+	}
+	if _, _, err := store.ListKeysPage("", 0); err == nil {
+		t.Errorf("expected an error for limit 0")
+	}
+
+	// Keys added or deleted between pages do not affect the keys that exist for the whole iteration
+	store.Delete([]byte("key05"))
+	store.Put([]byte("key085"), []byte("value"))
+	store.Put([]byte("key10a"), []byte("value"))
+	page, next, err = store.ListKeysPage(next, 3)
+	if err != nil {
+		t.Fatalf("ListKeysPage failed: %v", err)
+	}
+	if !slices.Equal(page, []string{"key085", "key09", "key10"}) || next == "" {
+		t.Errorf("unexpected second page %v (next %q)", page, next)
+	}
+	page, next, err = store.ListKeysPage(next, 100)
+	if err != nil || len(page) != 15 || page[0] != "key10a" || next != "" {
+		t.Errorf("unexpected last page %v (next %q), err %v", page, next, err)
+	}
+}
@@ -0,0 +1,121 @@
+package kvdb
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"testing"
+
+	"github.com/spf13/afero"
+)
+
+// strs returns the values as strings, for comparisons
+func strs(values [][]byte) []string {
+	s := make([]string, len(values))
+	for i, value := range values {
+		s[i] = string(value)
+	}
+	return s
+}
+
+func TestList(t *testing.T) {
+	store := helperCreateMultipleDataFiles(t, afero.NewMemMapFs(), "test_list.db")
+	defer store.Close()
+	list := store.List([]byte("list"))
+
+	if n, err := list.PushFront([]byte("b"), []byte("a")); err != nil || n != 2 {
+		t.Fatalf("expected 2 values, got %d (err: %v)", n, err)
+	}
+	if n, err := list.PushBack([]byte("c"), []byte("d")); err != nil || n != 4 {
+		t.Fatalf("expected 4 values, got %d (err: %v)", n, err)
+	}
+	tests := []struct {
+		start, stop int
+		want        []string
+	}{
+		{0, -1, []string{"a", "b", "c", "d"}},
+		{1, 2, []string{"b", "c"}},
+		{-2, 100, []string{"c", "d"}},
+		{-100, 0, []string{"a"}},
+		{3, 1, []string{}},
+		{5, 10, []string{}},
+	}
+	for _, test := range tests {
+		values, err := list.Range(test.start, test.stop)
+		if err != nil || !slices.Equal(strs(values), test.want) {
+			t.Errorf("range %d %d: expected %v, got %v (err: %v)", test.start, test.stop, test.want, strs(values), err)
+		}
+	}
+
+	if values, err := list.PopFront(1); err != nil || !slices.Equal(strs(values), []string{"a"}) {
+		t.Errorf("expected a, got %v (err: %v)", strs(values), err)
+	}
+	if values, err := list.PopBack(2); err != nil || !slices.Equal(strs(values), []string{"d", "c"}) {
+		t.Errorf("expected d and c, got %v (err: %v)", strs(values), err)
+	}
+	if n, err := list.Len(); err != nil || n != 1 {
+		t.Errorf("expected 1 value, got %d (err: %v)", n, err)
+	}
+	if values, err := list.PopBack(10); err != nil || !slices.Equal(strs(values), []string{"b"}) {
+		t.Errorf("expected b, got %v (err: %v)", strs(values), err)
+	}
+	if _, err := store.Get([]byte("list")); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("expected the empty list to be deleted, got %v", err)
+	}
+	if values, err := list.PopFront(1); err != nil || len(values) != 0 {
+		t.Errorf("expected nothing from a list that does not exist, got %v (err: %v)", strs(values), err)
+	}
+
+	store.Hash([]byte("hash")).Set([]byte("field"), []byte("value"))
+	if _, err := store.List([]byte("hash")).PushBack([]byte("a")); !errors.Is(err, ErrWrongType) {
+		t.Errorf("expected ErrWrongType on a hash, got %v", err)
+	}
+	if _, err := store.List([]byte("hash")).PopFront(0); !errors.Is(err, ErrWrongType) {
+		t.Errorf("expected ErrWrongType on a hash, got %v", err)
+	}
+}
+
+func TestListConcurrentPushPop(t *testing.T) {
+	store := helperCreateMultipleDataFiles(t, afero.NewMemMapFs(), "test_list_concurrent.db")
+	defer store.Close()
+	list := store.List([]byte("queue"))
+	var wg sync.WaitGroup
+	for w := range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range 50 {
+				list.PushBack(fmt.Appendf(nil, "%d-%d", w, i))
+			}
+		}()
+	}
+	wg.Wait()
+	// Every value is popped exactly once
+	var mu sync.Mutex
+	seen := map[string]bool{}
+	for range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				values, err := list.PopFront(3)
+				if err != nil || len(values) == 0 {
+					return
+				}
+				mu.Lock()
+				for _, value := range values {
+					if seen[string(value)] {
+						t.Errorf("%s was popped twice", value)
+					}
+					seen[string(value)] = true
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	if len(seen) != 4*50 {
+		t.Errorf("expected %d values, got %d", 4*50, len(seen))
+	}
+}
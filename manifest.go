@@ -0,0 +1,206 @@
+package kvdb
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/ananthvk/kvdb/internal/filemanager"
+	"github.com/ananthvk/kvdb/internal/record"
+	"github.com/spf13/afero"
+)
+
+/*
+Manifest
+
+kvdb_manifest.json in the datastore directory describes every data file: it's id and size, when it was created, the
+format and checksum of it's records (from the file header), the range of it's keys and timestamps, the number of
+records, and the bytes of the records the keydir points to (the live bytes). It's written in the background when the
+active file is sealed and after merges and adoptions, and when the datastore is closed, to a temporary file that is
+renamed, like the stats file.
+
+When the datastore is opened, the entries of the files whose size has not changed are used instead of reading the file
+headers, and for the ranges of the files that were not read while building the keydir. DataFileInfos returns the same
+information for the current files, for tools and for policies that pick the files to merge.
+
+The manifest is only a cache, the data files are always the source of truth: the data directory is still listed when
+the datastore is opened, entries of files that are gone or have another size are ignored, and a manifest that can't be
+read is ignored altogether. So it can be deleted at any time, and is rebuilt when the datastore is closed
+*/
+
+const manifestFileName = "kvdb_manifest.json"
+
+// Version of the manifest, manifests with another version are ignored
+const manifestVersion = 1
+
+// DataFileInfo describes a data file, see DataFileInfos
+type DataFileInfo struct {
+	Id   int   `json:"id"`
+	Size int64 `json:"size"`
+	// Time at which the file was created, from it's header
+	Created time.Time `json:"created"`
+	// Format and checksum algorithm of the records
+	Format   RecordFormat `json:"format"`
+	Checksum Checksum     `json:"checksum"`
+	// Range of the keys and timestamps of the records (tombstones included), and the number of records. They are zero
+	// if they are not known, for a file that could not be read completely
+	MinKey       []byte    `json:"min_key,omitempty"`
+	MaxKey       []byte    `json:"max_key,omitempty"`
+	MinTimestamp time.Time `json:"min_timestamp"`
+	MaxTimestamp time.Time `json:"max_timestamp"`
+	Records      int       `json:"records"`
+	// Bytes taken by the records of the keys whose current value is in the file
+	LiveBytes int64 `json:"live_bytes"`
+	// Active is true for the file that is being written to
+	Active bool `json:"active"`
+}
+
+type manifestFile struct {
+	Version int            `json:"version"`
+	Written time.Time      `json:"written"`
+	Files   []DataFileInfo `json:"files"`
+}
+
+// DataFileInfos returns the information of the manifest for every data file, in increasing order of id
+func (dataStore *DataStore) DataFileInfos() ([]DataFileInfo, error) {
+	if err := dataStore.gate.enter(); err != nil {
+		return nil, err
+	}
+	defer dataStore.gate.exit()
+	return dataStore.dataFileInfos(), nil
+}
+
+func (dataStore *DataStore) dataFileInfos() []DataFileInfo {
+	fm := dataStore.fileManager
+	dataStore.mu.RLock()
+	sizes := fm.DataFileSizes()
+	liveBytes := dataStore.keydir.LiveBytes()
+	active := fm.GetActiveFileId()
+	dataStore.mu.RUnlock()
+
+	infos := make([]DataFileInfo, 0, len(sizes))
+	for id, size := range sizes {
+		info := DataFileInfo{Id: id, Size: size, LiveBytes: liveBytes[id], Active: id == active}
+		// A file whose header can't be read is still listed
+		if header, err := fm.HeaderInfo(id); err == nil {
+			info.Created, info.Format, info.Checksum = header.Created, RecordFormat(header.Format), checksumOf(header.Checksum)
+		}
+		if meta, ok := fm.FileMeta(id); ok {
+			info.MinKey, info.MaxKey, info.Records = meta.MinKey, meta.MaxKey, meta.Records
+			info.MinTimestamp, info.MaxTimestamp = meta.MinTimestamp, meta.MaxTimestamp
+		}
+		infos = append(infos, info)
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Id < infos[j].Id })
+	return infos
+}
+
+// checksumOf returns the Checksum of a checksum algorithm of the record package
+func checksumOf(checksum record.Checksum) Checksum {
+	switch checksum {
+	case record.ChecksumCRC32C:
+		return ChecksumCRC32C
+	case record.ChecksumXXHash64:
+		return ChecksumXXHash64
+	}
+	return ChecksumCRC32
+}
+
+// writeManifest writes the manifest of the current data files, in-memory datastores don't have one
+func (dataStore *DataStore) writeManifest() error {
+	if dataStore.inMemory {
+		return nil
+	}
+	data, err := json.Marshal(manifestFile{Version: manifestVersion, Written: time.Now(), Files: dataStore.dataFileInfos()})
+	if err != nil {
+		return err
+	}
+	dataStore.manifestMu.Lock()
+	defer dataStore.manifestMu.Unlock()
+	return writeFileAtomic(dataStore.fs, filepath.Join(dataStore.path, manifestFileName), data)
+}
+
+// readManifest reads the manifest of the datastore at path, it returns nil if there is no manifest, or if it can't be
+// read
+func readManifest(fs afero.Fs, path string) *manifestFile {
+	data, err := afero.ReadFile(fs, filepath.Join(path, manifestFileName))
+	if err != nil {
+		return nil
+	}
+	var manifest manifestFile
+	if err := json.Unmarshal(data, &manifest); err != nil || manifest.Version != manifestVersion {
+		return nil
+	}
+	return &manifest
+}
+
+// restoreManifest hands the entries of the manifest whose file has the same size over to the file manager
+func restoreManifest(fs afero.Fs, path string, fm *filemanager.FileManager) {
+	manifest := readManifest(fs, path)
+	if manifest == nil {
+		return
+	}
+	sizes := fm.DataFileSizes()
+	for _, info := range manifest.Files {
+		if size, ok := sizes[info.Id]; !ok || size != info.Size {
+			continue
+		}
+		opts := &Options{RecordFormat: info.Format, Checksum: info.Checksum}
+		format, err := opts.recordFormat()
+		if err != nil {
+			continue
+		}
+		checksum, err := opts.checksum()
+		if err != nil {
+			continue
+		}
+		header := filemanager.FileHeaderInfo{Created: info.Created, Format: format, Checksum: checksum}
+		var meta *filemanager.FileMeta
+		if info.Records > 0 {
+			meta = &filemanager.FileMeta{
+				MinKey:       info.MinKey,
+				MaxKey:       info.MaxKey,
+				MinTimestamp: info.MinTimestamp,
+				MaxTimestamp: info.MaxTimestamp,
+				Records:      info.Records,
+			}
+		}
+		fm.RestoreFileInfo(info.Id, header, meta)
+	}
+}
+
+// setupManifest writes the manifest in the background every time the active file is sealed
+func (dataStore *DataStore) setupManifest() {
+	dataStore.manifestDirty = make(chan struct{}, 1)
+	dataStore.manifestStopped = make(chan struct{})
+	if dataStore.inMemory {
+		close(dataStore.manifestStopped)
+		return
+	}
+	dataStore.fileManager.SetOnRotate(func(int) {
+		dataStore.markManifestDirty()
+		dataStore.markHintsPending()
+	})
+	done := dataStore.gate.ctx.Done()
+	go func() {
+		defer close(dataStore.manifestStopped)
+		for {
+			select {
+			case <-done:
+				return
+			case <-dataStore.manifestDirty:
+				// A failed write is retried on the next change, and by Close
+				dataStore.writeManifest()
+			}
+		}
+	}()
+}
+
+// markManifestDirty makes the background writer write the manifest, without waiting for it
+func (dataStore *DataStore) markManifestDirty() {
+	select {
+	case dataStore.manifestDirty <- struct{}{}:
+	default:
+	}
+}
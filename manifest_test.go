@@ -0,0 +1,68 @@
+package kvdb
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/spf13/afero"
+)
+
+func TestManifest(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	store := helperCreateMultipleDataFiles(t, fs, "test_manifest.db")
+	store.Put([]byte("b"), []byte("value"))
+	store.Put([]byte("d"), []byte("value"))
+	store.Delete([]byte("b"))
+	store.Close()
+
+	manifest := readManifest(fs, "test_manifest.db")
+	if manifest == nil || len(manifest.Files) != 1 {
+		t.Fatalf("expected a manifest with one data file, got %+v", manifest)
+	}
+	info := manifest.Files[0]
+	if string(info.MinKey) != "b" || string(info.MaxKey) != "d" || info.Records != 3 || info.Format != RecordFormatV1 || info.Checksum != ChecksumCRC32 {
+		t.Errorf("unexpected manifest entry %+v", info)
+	}
+	if info.Created.IsZero() || info.LiveBytes == 0 || info.LiveBytes >= info.Size {
+		t.Errorf("expected the creation time and the live bytes of the file, got %+v", info)
+	}
+
+	// The entries of files that did not change are used when the datastore is opened
+	created := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	manifest.Files[0].Created = created
+	manifest.Files = append(manifest.Files, DataFileInfo{Id: 5, Size: 100, Created: created})
+	data, _ := json.Marshal(manifest)
+	afero.WriteFile(fs, filepath.Join("test_manifest.db", manifestFileName), data, 0666)
+
+	store, err := Open(fs, "test_manifest.db")
+	if err != nil {
+		t.Fatalf("failed to reopen store: %v", err)
+	}
+	store.Put([]byte("e"), []byte("value"))
+	infos, err := store.DataFileInfos()
+	if err != nil || len(infos) != 2 {
+		t.Fatalf("expected 2 data files, got %+v (err: %v)", infos, err)
+	}
+	if !infos[0].Created.Equal(created) {
+		t.Errorf("expected the creation time to be restored from the manifest, got %v", infos[0].Created)
+	}
+	if !infos[1].Active || infos[1].Created.Equal(created) || string(infos[1].MinKey) != "e" {
+		t.Errorf("expected the new active file to be described from the file, got %+v", infos[1])
+	}
+
+	// The entry of a file whose size changed is ignored
+	store.Close()
+	manifest.Files[0].Size--
+	data, _ = json.Marshal(manifest)
+	afero.WriteFile(fs, filepath.Join("test_manifest.db", manifestFileName), data, 0666)
+	store, err = Open(fs, "test_manifest.db")
+	if err != nil {
+		t.Fatalf("failed to reopen store: %v", err)
+	}
+	defer store.Close()
+	if infos, _ := store.DataFileInfos(); infos[0].Created.Equal(created) {
+		t.Errorf("expected the creation time to be read from the file header")
+	}
+}
@@ -0,0 +1,47 @@
+package kvdb
+
+import (
+	"github.com/spf13/afero"
+)
+
+/*
+In-memory datastores
+
+CreateInMemory creates a datastore that is never written to disk, for tests and ephemeral caches. It is not a separate
+engine with it's own in-memory keydir and log: it's the on-disk engine (the same keydir, data files, records, merges
+and options) running over an afero.MemMapFs that only the datastore can reach. Every value is encoded into a record
+and read back from a file like on disk, so it's not faster than a datastore on a fast disk, and a value takes the size
+of it's record until a merge removes it. In exchange, it behaves exactly like a datastore on disk (TailLog, snapshots,
+AdoptFile, merges and Verify all work), without the parts that only matter for a datastore that is opened again:
+
+ 1. No metafile is written, the limits and the version are only kept in memory
+ 2. The manifest and the stats file are not written (Options.StatsFlushInterval is ignored)
+ 3. The directory options (Options.ActiveDir, MergedDir and HintDir) are ignored
+ 4. Close frees the files, so the memory is returned even if the datastore is still referenced
+
+Since MemMapFs can't be synced, Sync and GroupCommitSync cost nothing
+*/
+
+// inMemoryPath is the path of in-memory datastores, in their own file system
+const inMemoryPath = ":memory"
+
+// CreateInMemory creates a datastore whose contents are only kept in memory, and are lost when it's closed. It's the
+// on-disk engine over a memory file system, not an optimized in-memory store. If opts is nil, the default options are
+// used. See memory.go
+func CreateInMemory(opts *Options) (*DataStore, error) {
+	options := opts.orDefault()
+	options.ActiveDir, options.MergedDir, options.HintDir = "", "", ""
+	options.StatsFlushInterval = 0
+	return create(afero.NewMemMapFs(), inMemoryPath, options, true)
+}
+
+// InMemory returns true if the datastore was created by CreateInMemory
+func (dataStore *DataStore) InMemory() bool {
+	return dataStore.inMemory
+}
+
+// Fs returns the file system of the datastore's files, which is the only way to reach the files of an in-memory
+// datastore (for example, to copy a file to adopt into it)
+func (dataStore *DataStore) Fs() afero.Fs {
+	return dataStore.fs
+}
@@ -0,0 +1,136 @@
+package kvdb
+
+import (
+	"fmt"
+
+	"github.com/ananthvk/kvdb/internal/keydir"
+)
+
+/*
+Keydir memory limit
+
+Every key of the datastore is held in the keydir, so the memory used by the datastore grows with the number (and the
+size) of the keys, and nothing stops it until the process is killed for running out of memory. With
+Options.KeydirMemoryLimit, the estimated memory used by the keydir (Stats.KeydirMemoryBytes) is checked before every
+write that adds a key: once the new key would take the keydir over the limit, the write is rejected with a
+*MemoryLimitError. Writes to existing keys and deletes are always allowed, so the datastore stays usable, and deleting
+keys (or a merge that drops unreferenced content) brings it back under the limit.
+
+The limit is soft: it's checked against an estimate, and the memory used by values, caches and the Go runtime is not
+counted. The keydir is only kept in memory, so rejecting the write is the only option once the limit is reached.
+
+A MemoryEvent is sent to the functions registered with WatchMemory when the keydir goes over
+keydirMemoryWarningFraction of the limit (so that it can be acted upon before writes fail), when the first write is
+rejected, and when it goes back under the warning threshold
+*/
+
+// The keydir is close to it's limit when it uses this fraction of Options.KeydirMemoryLimit
+const keydirMemoryWarningFraction = 0.9
+
+// MemoryLevel is how close the keydir is to Options.KeydirMemoryLimit
+type MemoryLevel int
+
+const (
+	// MemoryOK is below the warning threshold
+	MemoryOK MemoryLevel = iota
+	// MemoryWarning is above keydirMemoryWarningFraction of the limit
+	MemoryWarning
+	// MemoryExceeded is at the limit, writes that add keys are rejected
+	MemoryExceeded
+)
+
+func (l MemoryLevel) String() string {
+	switch l {
+	case MemoryOK:
+		return "ok"
+	case MemoryWarning:
+		return "warning"
+	case MemoryExceeded:
+		return "exceeded"
+	}
+	return fmt.Sprintf("MemoryLevel(%d)", int(l))
+}
+
+// MemoryLimitError is returned by writes that are rejected because the keydir is at it's memory limit, it wraps
+// ErrMemoryLimit
+type MemoryLimitError struct {
+	KeydirBytes int64
+	Limit       int64
+}
+
+func (e *MemoryLimitError) Error() string {
+	return fmt.Sprintf("%s: keydir uses %d bytes, the limit is %d", ErrMemoryLimit, e.KeydirBytes, e.Limit)
+}
+
+func (e *MemoryLimitError) Unwrap() error {
+	return ErrMemoryLimit
+}
+
+// MemoryEvent is sent when the keydir crosses the warning threshold or the limit, in either direction
+type MemoryEvent struct {
+	Level       MemoryLevel
+	KeydirBytes int64
+	Limit       int64
+}
+
+// MemoryWatchFunc is called every time the memory level of the keydir changes
+type MemoryWatchFunc func(event MemoryEvent)
+
+// WatchMemory registers fn to be called every time the memory level of the keydir changes. fn is called inside the
+// write lock, so it must not block, and must not call back into the datastore. It returns a function that unregisters fn
+func (dataStore *DataStore) WatchMemory(fn MemoryWatchFunc) (cancel func()) {
+	return dataStore.memoryWatchers.add(fn)
+}
+
+type memoryState struct {
+	// Protected by the write lock
+	level          MemoryLevel
+	rejectedWrites uint64
+}
+
+// checkMemory returns a *MemoryLimitError if writing the key would add a key to the keydir and take it over the limit.
+// The caller must hold the write lock
+func (dataStore *DataStore) checkMemory(key []byte) error {
+	limit := dataStore.options.KeydirMemoryLimit
+	if limit <= 0 {
+		return nil
+	}
+	dataStore.updateMemory()
+	if _, exists := dataStore.keydir.GetKeydirRecord(key); exists {
+		return nil
+	}
+	used := dataStore.keydir.MemoryUsage()
+	if used+keydir.EntryMemoryUsage(len(key)) <= limit {
+		return nil
+	}
+	dataStore.memory.rejectedWrites++
+	if dataStore.memory.level != MemoryExceeded {
+		dataStore.memory.level = MemoryExceeded
+		dataStore.memoryWatchers.notify(MemoryEvent{Level: MemoryExceeded, KeydirBytes: used, Limit: limit})
+	}
+	return &MemoryLimitError{KeydirBytes: used, Limit: limit}
+}
+
+// updateMemory updates the memory level of the keydir after it has changed, and notifies the watchers if it did. The
+// level only goes up to MemoryExceeded when a write is rejected, and only comes back down from it to MemoryOK. The
+// caller must hold the write lock
+func (dataStore *DataStore) updateMemory() {
+	limit := dataStore.options.KeydirMemoryLimit
+	if limit <= 0 {
+		return
+	}
+	used := dataStore.keydir.MemoryUsage()
+	level := MemoryOK
+	if float64(used) >= keydirMemoryWarningFraction*float64(limit) {
+		level = MemoryWarning
+	}
+	if level == MemoryWarning && dataStore.memory.level == MemoryExceeded {
+		// Stays at the limit until it's back under the warning threshold, so that the level does not flip every time
+		// a write is rejected
+		return
+	}
+	if level != dataStore.memory.level {
+		dataStore.memory.level = level
+		dataStore.memoryWatchers.notify(MemoryEvent{Level: level, KeydirBytes: used, Limit: limit})
+	}
+}
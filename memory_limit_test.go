@@ -0,0 +1,58 @@
+package kvdb
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/ananthvk/kvdb/internal/keydir"
+	"github.com/spf13/afero"
+)
+
+func TestKeydirMemoryLimit(t *testing.T) {
+	// Room for 10 keys of 6 bytes
+	limit := 10 * keydir.EntryMemoryUsage(6)
+	store, err := CreateWithOptions(afero.NewMemMapFs(), "test_memory_limit.db", &Options{KeydirMemoryLimit: limit})
+	if err != nil {
+		t.Fatalf("could not create datastore: %v", err)
+	}
+	defer store.Close()
+	var levels []MemoryLevel
+	store.WatchMemory(func(event MemoryEvent) {
+		levels = append(levels, event.Level)
+	})
+
+	for i := range 10 {
+		if err := store.Put(fmt.Appendf(nil, "key%03d", i), []byte("value")); err != nil {
+			t.Fatalf("put %d failed: %v", i, err)
+		}
+	}
+	err = store.Put([]byte("key010"), []byte("value"))
+	var limitErr *MemoryLimitError
+	if !errors.As(err, &limitErr) || !errors.Is(err, ErrMemoryLimit) || limitErr.Limit != limit {
+		t.Fatalf("expected a *MemoryLimitError, got %v", err)
+	}
+	if _, err := store.Get([]byte("key010")); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("expected the rejected key not to be written, got %v", err)
+	}
+	// Existing keys can still be written
+	if err := store.Put([]byte("key000"), []byte("new value")); err != nil {
+		t.Errorf("expected an overwrite to be allowed, got %v", err)
+	}
+	stats, _ := store.Stats()
+	if stats.KeydirMemoryLevel != MemoryExceeded || stats.RejectedMemoryLimitWrites != 1 || stats.KeydirMemoryLimit != limit {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+
+	// Deleting keys brings the keydir back under the limit
+	for i := range 3 {
+		store.Delete(fmt.Appendf(nil, "key%03d", i))
+	}
+	if err := store.Put([]byte("key010"), []byte("value")); err != nil {
+		t.Errorf("expected the put to succeed after deletes, got %v", err)
+	}
+	expected := []MemoryLevel{MemoryWarning, MemoryExceeded, MemoryOK}
+	if fmt.Sprint(levels) != fmt.Sprint(expected) {
+		t.Errorf("expected the levels %v, got %v", expected, levels)
+	}
+}
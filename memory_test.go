@@ -0,0 +1,58 @@
+package kvdb
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/spf13/afero"
+)
+
+func TestCreateInMemory(t *testing.T) {
+	store, err := CreateInMemory(nil)
+	if err != nil {
+		t.Fatalf("failed to create in-memory store: %v", err)
+	}
+	if !store.InMemory() {
+		t.Errorf("expected InMemory to be true")
+	}
+	for i := range 100 {
+		if err := store.Put([]byte{byte(i)}, []byte("value")); err != nil {
+			t.Fatalf("put failed: %v", err)
+		}
+	}
+	store.Delete([]byte{0})
+	if err := store.Merge(); err != nil {
+		t.Fatalf("merge failed: %v", err)
+	}
+	if store.Size() != 99 {
+		t.Errorf("expected 99 keys, got %d", store.Size())
+	}
+	if _, err := store.Get([]byte{0}); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("expected deleted key to be missing, got %v", err)
+	}
+
+	fs := store.Fs()
+	if exists, _ := afero.Exists(fs, inMemoryPath+"/kvdb_store.meta"); exists {
+		t.Errorf("expected no metafile")
+	}
+	if err := store.Close(); err != nil {
+		t.Fatalf("close failed: %v", err)
+	}
+	if exists, _ := afero.Exists(fs, inMemoryPath); exists {
+		t.Errorf("expected the files to be freed by Close")
+	}
+}
+
+func TestCreateInMemoryWithOptions(t *testing.T) {
+	store, err := CreateInMemory(&Options{MaxKeySize: 4, ActiveDir: "/ignored"})
+	if err != nil {
+		t.Fatalf("failed to create in-memory store: %v", err)
+	}
+	defer store.Close()
+	if err := store.Put([]byte("too long"), []byte("value")); !errors.Is(err, ErrKeyTooLarge) {
+		t.Errorf("expected ErrKeyTooLarge, got %v", err)
+	}
+	if active, _, _ := store.DataDirs(); active != inMemoryPath+"/data" {
+		t.Errorf("expected the directory options to be ignored, got %s", active)
+	}
+}
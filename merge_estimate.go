@@ -0,0 +1,167 @@
+package kvdb
+
+import (
+	"cmp"
+	"time"
+
+	"github.com/ananthvk/kvdb/internal/datafile"
+	"github.com/ananthvk/kvdb/internal/keydir"
+	"github.com/ananthvk/kvdb/internal/record"
+)
+
+// Merge throughput assumed when estimating the duration of a merge, if no merge has completed yet (bytes per second)
+const defaultMergeThroughput = 100 * 1000 * 1000
+
+// FileStat describes a single data file
+type FileStat struct {
+	Id int
+	// Size of the file in bytes, including the file header
+	Size int64
+	// Number of keys whose current value is in this file, and the number of bytes taken by their records
+	LiveKeys  int
+	LiveBytes int64
+	// Active is true for the file that is currently being written to
+	Active bool
+	// Merged is true if the file was written by a merge
+	Merged bool
+}
+
+// GarbageBytes returns the number of bytes taken by stale records and tombstones, that a merge would reclaim
+func (f FileStat) GarbageBytes() int64 {
+	return max(f.Size-datafile.FileHeaderSize-f.LiveBytes, 0)
+}
+
+// MergeEstimate describes what a merge would do if it was started now
+type MergeEstimate struct {
+	// Files that would be merged
+	Files []FileStat
+	// Total size of the files that would be merged
+	InputBytes int64
+	// Approximate size of the files that the merge would write, and the number of keys in them
+	OutputBytes int64
+	LiveKeys    int
+	// InputBytes - OutputBytes
+	ReclaimedBytes int64
+	// Based on the throughput of the last successful merge, or defaultMergeThroughput if there is none
+	EstimatedDuration time.Duration
+}
+
+// FileStats returns statistics about every data file, in increasing order of file id. The keydir is scanned with
+// the read lock held, so writes are blocked while the live records are counted
+func (dataStore *DataStore) FileStats() ([]FileStat, error) {
+	if err := dataStore.gate.enter(); err != nil {
+		return nil, err
+	}
+	defer dataStore.gate.exit()
+	stats, _, err := dataStore.fileStats()
+	return stats, err
+}
+
+// fileStats returns the statistics of every data file, and for every file, the number of bytes that it's live records
+// would take if a merge rewrote them in the record format of new files
+func (dataStore *DataStore) fileStats() ([]FileStat, map[int]int64, error) {
+	fileManager := dataStore.fileManager
+	ids, err := fileManager.DataFileIds()
+	if err != nil {
+		return nil, nil, err
+	}
+	activeId := fileManager.GetActiveFileId()
+	// Files can have records in different formats, the size of a record depends on the format
+	formats := make(map[int]record.Format, len(ids))
+	for _, id := range ids {
+		if format, err := fileManager.DataFileFormat(id); err == nil {
+			formats[id] = format
+		}
+	}
+	outputFormat := fileManager.RecordFormat()
+
+	type usage struct {
+		keys        int
+		bytes       int64
+		outputBytes int64
+	}
+	usages := map[int]*usage{}
+	dataStore.mu.RLock()
+	dataStore.keydir.Range(func(key string, rec keydir.KeydirRecord) bool {
+		u := usages[rec.FileId]
+		if u == nil {
+			u = &usage{}
+			usages[rec.FileId] = u
+		}
+		u.keys++
+		u.bytes += cmp.Or(formats[rec.FileId], record.FormatV1).EncodedSize(uint32(len(key)), rec.ValueSize)
+		u.outputBytes += outputFormat.EncodedSize(uint32(len(key)), rec.ValueSize)
+		return true
+	})
+	dataStore.mu.RUnlock()
+
+	stats := make([]FileStat, 0, len(ids))
+	outputBytes := make(map[int]int64, len(ids))
+	for _, id := range ids {
+		size, err := fileManager.DataFileSize(id)
+		if _, ok := formats[id]; err != nil || !ok {
+			// The file could have been removed by a merge in the meantime
+			continue
+		}
+		stat := FileStat{
+			Id:     id,
+			Size:   size,
+			Active: id == activeId,
+			Merged: fileManager.IsMergedFile(id),
+		}
+		if u := usages[id]; u != nil {
+			stat.LiveKeys = u.keys
+			stat.LiveBytes = u.bytes
+			outputBytes[id] = u.outputBytes
+		}
+		stats = append(stats, stat)
+	}
+	return stats, outputBytes, nil
+}
+
+// EstimateMerge returns an estimate of the work a merge would do if it was started now, without modifying anything
+func (dataStore *DataStore) EstimateMerge() (MergeEstimate, error) {
+	if err := dataStore.gate.enter(); err != nil {
+		return MergeEstimate{}, err
+	}
+	defer dataStore.gate.exit()
+	immutableFiles, err := dataStore.fileManager.GetImmutableFiles()
+	if err != nil {
+		return MergeEstimate{}, err
+	}
+	fileStats, outputBytes, err := dataStore.fileStats()
+	if err != nil {
+		return MergeEstimate{}, err
+	}
+	toMerge := make(map[int]bool, len(immutableFiles))
+	for _, id := range immutableFiles {
+		toMerge[id] = true
+	}
+
+	var estimate MergeEstimate
+	var liveBytes int64
+	for _, stat := range fileStats {
+		if !toMerge[stat.Id] {
+			continue
+		}
+		estimate.Files = append(estimate.Files, stat)
+		estimate.InputBytes += stat.Size
+		estimate.LiveKeys += stat.LiveKeys
+		// The merge writes the records in the format of new files
+		liveBytes += outputBytes[stat.Id]
+	}
+	if liveBytes > 0 {
+		// Each output file has a header
+		maxSize := int64(max(dataStore.metaInfo.MaxDatafileSize, 1))
+		outputFiles := (liveBytes + maxSize - 1) / maxSize
+		estimate.OutputBytes = liveBytes + outputFiles*datafile.FileHeaderSize
+	}
+	estimate.ReclaimedBytes = max(estimate.InputBytes-estimate.OutputBytes, 0)
+
+	throughput := float64(defaultMergeThroughput)
+	if last := dataStore.counters.lastMerge.Load(); last != nil && last.err == nil && last.inputBytes > 0 && last.duration > 0 {
+		throughput = float64(last.inputBytes) / last.duration.Seconds()
+	}
+	estimate.EstimatedDuration = time.Duration(float64(estimate.InputBytes) / throughput * float64(time.Second))
+	return estimate, nil
+}
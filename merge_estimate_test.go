@@ -0,0 +1,66 @@
+package kvdb
+
+import (
+	"testing"
+
+	"github.com/ananthvk/kvdb/internal/datafile"
+	"github.com/spf13/afero"
+)
+
+func TestEstimateMerge(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	store := helperCreateMultipleDataFiles(t, fs, "test_estimate_merge.db")
+	store.Put([]byte("key1"), []byte("value1"))
+	store.Put([]byte("key2"), []byte("value2"))
+	store.Put([]byte("key3"), []byte("value3"))
+	store.Close()
+	store, err := Open(fs, "test_estimate_merge.db")
+	if err != nil {
+		t.Fatalf("failed to reopen store: %v", err)
+	}
+	defer store.Close()
+	// Makes key1 and key2 in the first file stale
+	store.Put([]byte("key1"), []byte("updated"))
+	store.Delete([]byte("key2"))
+
+	fileStats, err := store.FileStats()
+	if err != nil {
+		t.Fatalf("file stats failed: %v", err)
+	}
+	if len(fileStats) != 2 || fileStats[0].LiveKeys != 1 || fileStats[1].LiveKeys != 1 || !fileStats[1].Active {
+		t.Fatalf("unexpected file stats %+v", fileStats)
+	}
+
+	estimate, err := store.EstimateMerge()
+	if err != nil {
+		t.Fatalf("estimate failed: %v", err)
+	}
+	if len(estimate.Files) != 1 || estimate.Files[0].Id != 1 || estimate.LiveKeys != 1 {
+		t.Fatalf("unexpected estimate %+v", estimate)
+	}
+	if estimate.InputBytes != fileStats[0].Size || estimate.EstimatedDuration <= 0 {
+		t.Errorf("unexpected estimate %+v", estimate)
+	}
+	// The estimate does not change anything
+	if stats, _ := store.FileStats(); len(stats) != 2 {
+		t.Errorf("expected 2 data files after estimating, got %d", len(stats))
+	}
+
+	if err := store.Merge(); err != nil {
+		t.Fatalf("merge failed: %v", err)
+	}
+	fileStats, _ = store.FileStats()
+	var merged *FileStat
+	for i := range fileStats {
+		if fileStats[i].Merged {
+			merged = &fileStats[i]
+		}
+	}
+	if merged == nil {
+		t.Fatalf("expected a merged file in %+v", fileStats)
+	}
+	// The estimate matches the size of the merged file exactly
+	if merged.Size != estimate.OutputBytes || merged.GarbageBytes() != 0 || merged.Size-datafile.FileHeaderSize != merged.LiveBytes {
+		t.Errorf("expected merged file of %d bytes, got %+v", estimate.OutputBytes, *merged)
+	}
+}
@@ -0,0 +1,273 @@
+package kvdb
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/ananthvk/kvdb/internal/filemanager"
+	"github.com/ananthvk/kvdb/internal/utils"
+	"github.com/ananthvk/kvdb/internal/vfs"
+	"github.com/spf13/afero"
+)
+
+/*
+Merge manifest
+
+A merge writes it's output to temporary merge-<n> files, renames them to the data file ids it reserved, and then
+deletes the merged files. These steps are not atomic, and a crash in between leaves the datastore in a state that
+loses data when it's opened: if only some of the merged files were deleted, a key whose tombstone was in a deleted file
+comes back from an older file that is still there.
+
+So before the first rename, the merge writes a manifest (merge.manifest in the datastore directory) that lists the
+merged file ids, and the temporary name and final id of every output file. The manifest is written to a temporary file
+and renamed, so it's either complete or not there at all. It's removed after the merged files are deleted.
+
+When the datastore is opened, an interrupted merge is completed if every output file is there (under it's temporary or
+final name): the remaining outputs are renamed, and the merged files are deleted. If an output file is missing, the
+merge is rolled back instead, which is only possible while every merged file is still there. Temporary merge files
+without a manifest are from a merge that crashed before it was committed, they are deleted.
+
+AdoptFile uses the same manifest, with no inputs and the copied file as the only output. Temporary adopt-<n> files
+without a manifest are deleted, like temporary merge files
+*/
+
+const (
+	mergeManifestFileName = "merge.manifest"
+	mergeTempPrefix       = "merge-"
+	adoptTempPrefix       = "adopt-"
+)
+
+var ErrMergeRecovery = errors.New("could not recover interrupted merge")
+
+// MergeRecovery is what was done with a merge that was interrupted by a crash, when the datastore was opened
+type MergeRecovery string
+
+const (
+	MergeCompleted  MergeRecovery = "completed"
+	MergeRolledBack MergeRecovery = "rolled back"
+)
+
+type mergeManifest struct {
+	// Ids of the data files that were merged, and are deleted once the merge is committed
+	Inputs  []int                 `json:"inputs"`
+	Outputs []mergeManifestOutput `json:"outputs"`
+}
+
+type mergeManifestOutput struct {
+	// Name of the temporary data file (and it's hint file, and bloom filter) written by the merge
+	Temp string `json:"temp"`
+	Id   int    `json:"id"`
+}
+
+type mergePaths struct {
+	fs     afero.Fs
+	layout filemanager.Layout
+}
+
+// data returns the paths a file with the given name can have in the data directories. Merge outputs are in the
+// merged directory, adopted files in the active directory, and merged files in either
+func (p mergePaths) data(name string) []string {
+	return p.layout.DataPaths(name)
+}
+
+func (p mergePaths) hint(name string) string {
+	return p.layout.HintPath(name)
+}
+
+func (p mergePaths) exists(paths ...string) (bool, error) {
+	for _, path := range paths {
+		if exists, err := afero.Exists(p.fs, path); err != nil || exists {
+			return exists, err
+		}
+	}
+	return false, nil
+}
+
+// rename renames the data file oldName to newName, in the data directory it's in
+func (p mergePaths) rename(oldName string, newName string) error {
+	oldPath := p.layout.FindDataPath(p.fs, oldName)
+	return p.fs.Rename(oldPath, filepath.Join(filepath.Dir(oldPath), newName))
+}
+
+// remove removes the files at paths, it's not an error if a file does not exist
+func (p mergePaths) remove(paths ...string) error {
+	for _, path := range paths {
+		if err := p.fs.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeMergeManifest writes the manifest to a temporary file, syncs it, and renames it into place
+func writeMergeManifest(fs afero.Fs, path string, manifest *mergeManifest) error {
+	data, err := json.Marshal(manifest)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(fs, filepath.Join(path, mergeManifestFileName), data)
+}
+
+// writeFileAtomic writes data to a temporary file next to path, syncs it, and renames it to path, so that path has
+// either the old or the new contents after a crash
+func writeFileAtomic(fs afero.Fs, path string, data []byte) error {
+	tempPath := path + ".tmp"
+	file, err := fs.Create(tempPath)
+	if err != nil {
+		return err
+	}
+	_, err = file.Write(data)
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		fs.Remove(tempPath)
+		return err
+	}
+	return fs.Rename(tempPath, path)
+}
+
+// syncDir syncs the directory at path, so that the files created, renamed or removed in it survive a crash
+func syncDir(fs afero.Fs, path string) error {
+	return vfs.New(fs).SyncDir(path)
+}
+
+// removeMergeManifest removes the manifest after a merge has been committed
+func removeMergeManifest(fs afero.Fs, path string) error {
+	return mergePaths{fs: fs}.remove(filepath.Join(path, mergeManifestFileName))
+}
+
+// recoverMerge completes or rolls back a merge that was interrupted by a crash, and removes temporary merge files. It
+// must be called before the data directory is read. It returns an empty MergeRecovery if there was no interrupted merge
+func recoverMerge(fs afero.Fs, path string, layout filemanager.Layout) (MergeRecovery, error) {
+	p := mergePaths{fs, layout}
+	var recovery MergeRecovery
+	data, err := afero.ReadFile(fs, filepath.Join(path, mergeManifestFileName))
+	if err == nil {
+		var manifest mergeManifest
+		if err := json.Unmarshal(data, &manifest); err != nil {
+			return "", fmt.Errorf("%w: invalid manifest: %w", ErrMergeRecovery, err)
+		}
+		if recovery, err = p.recover(&manifest); err != nil {
+			return "", err
+		}
+		if err := removeMergeManifest(fs, path); err != nil {
+			return "", err
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return "", err
+	}
+	// A half written manifest
+	if err := p.remove(filepath.Join(path, mergeManifestFileName+".tmp")); err != nil {
+		return "", err
+	}
+
+	// Temporary files of the interrupted merge that were not renamed, or of a merge (or adoption) that was never committed
+	for _, dir := range layout.Dirs() {
+		entries, err := afero.ReadDir(fs, dir)
+		if err != nil {
+			return "", err
+		}
+		for _, entry := range entries {
+			if entry.IsDir() {
+				continue
+			}
+			if strings.HasPrefix(entry.Name(), mergeTempPrefix) || strings.HasPrefix(entry.Name(), adoptTempPrefix) {
+				if err := p.remove(filepath.Join(dir, entry.Name())); err != nil {
+					return "", err
+				}
+			}
+		}
+	}
+	return recovery, nil
+}
+
+// recover completes the merge described by the manifest if all of it's output files are there, otherwise it rolls it
+// back
+func (p mergePaths) recover(manifest *mergeManifest) (MergeRecovery, error) {
+	complete := true
+	for _, output := range manifest.Outputs {
+		tempExists, err := p.exists(p.data(output.Temp)...)
+		if err != nil {
+			return "", err
+		}
+		finalExists, err := p.exists(p.data(utils.GetDataFileName(output.Id))...)
+		if err != nil {
+			return "", err
+		}
+		if !tempExists && !finalExists {
+			complete = false
+		}
+	}
+
+	if complete {
+		for _, output := range manifest.Outputs {
+			tempExists, err := p.exists(p.data(output.Temp)...)
+			if err != nil {
+				return "", err
+			}
+			if !tempExists {
+				// Renamed before the crash
+				continue
+			}
+			// Same order as Merge, the bloom filter and the hint file are in place before the data file they describe
+			if bloomExists, err := p.exists(p.hint(output.Temp + ".bloom")); err != nil {
+				return "", err
+			} else if bloomExists {
+				if err := p.fs.Rename(p.hint(output.Temp+".bloom"), p.hint(utils.GetBloomFileName(output.Id))); err != nil {
+					return "", err
+				}
+			}
+			if hintExists, err := p.exists(p.hint(output.Temp)); err != nil {
+				return "", err
+			} else if hintExists {
+				if err := p.fs.Rename(p.hint(output.Temp), p.hint(utils.GetHintFileName(output.Id))); err != nil {
+					return "", err
+				}
+			}
+			if err := p.rename(output.Temp, utils.GetDataFileName(output.Id)); err != nil {
+				return "", err
+			}
+		}
+		for _, id := range manifest.Inputs {
+			if err := p.remove(p.data(utils.GetDataFileName(id))...); err != nil {
+				return "", err
+			}
+			if err := p.remove(p.hint(utils.GetHintFileName(id))); err != nil {
+				return "", err
+			}
+			if err := p.remove(p.hint(utils.GetBloomFileName(id))); err != nil {
+				return "", err
+			}
+		}
+		return MergeCompleted, nil
+	}
+
+	// Inputs are only deleted after every output was renamed, so all of them are still there, unless the files were
+	// changed after the crash
+	for _, id := range manifest.Inputs {
+		exists, err := p.exists(p.data(utils.GetDataFileName(id))...)
+		if err != nil {
+			return "", err
+		}
+		if !exists {
+			return "", fmt.Errorf("%w: output files and merged data file %d are both missing", ErrMergeRecovery, id)
+		}
+	}
+	for _, output := range manifest.Outputs {
+		files := []string{p.hint(output.Temp), p.hint(output.Temp + ".bloom"), p.hint(utils.GetHintFileName(output.Id)), p.hint(utils.GetBloomFileName(output.Id))}
+		files = append(files, p.data(output.Temp)...)
+		files = append(files, p.data(utils.GetDataFileName(output.Id))...)
+		if err := p.remove(files...); err != nil {
+			return "", err
+		}
+	}
+	return MergeRolledBack, nil
+}
@@ -0,0 +1,205 @@
+package kvdb
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ananthvk/kvdb/internal/utils"
+	"github.com/spf13/afero"
+)
+
+// helperMergeCrashStore creates a datastore with two immutable files (key2 is put in the first, and deleted in the
+// second) and an active file, merges it, and returns the contents of the merged files from before the merge, so that
+// the tests can put them back to simulate a crash in the middle of the merge. It also returns the manifest of the merge
+func helperMergeCrashStore(t *testing.T, fs afero.Fs, path string) (map[string][]byte, *mergeManifest) {
+	t.Helper()
+	store := helperCreateMultipleDataFiles(t, fs, path)
+	store.Put([]byte("key1"), []byte("value1"))
+	store.Put([]byte("key2"), []byte("value2"))
+	store.Close()
+	store, err := Open(fs, path)
+	if err != nil {
+		t.Fatalf("failed to reopen store: %v", err)
+	}
+	store.Delete([]byte("key2"))
+	store.Put([]byte("key3"), []byte("value3"))
+	store.Close()
+	store, err = Open(fs, path)
+	if err != nil {
+		t.Fatalf("failed to reopen store: %v", err)
+	}
+	// Creates the active file 3, so that files 1 and 2 are merged
+	store.Put([]byte("key4"), []byte("value4"))
+
+	before := map[string][]byte{}
+	for _, id := range []int{1, 2} {
+		name := filepath.Join(path, "data", utils.GetDataFileName(id))
+		data, err := afero.ReadFile(fs, name)
+		if err != nil {
+			t.Fatalf("could not read data file: %v", err)
+		}
+		before[name] = data
+	}
+	if err := store.Merge(); err != nil {
+		t.Fatalf("merge failed: %v", err)
+	}
+	store.Close()
+
+	// The merge output gets the first id after the active file
+	if exists, _ := afero.Exists(fs, filepath.Join(path, "data", utils.GetDataFileName(4))); !exists {
+		t.Fatalf("expected the merge output to be data file 4")
+	}
+	return before, &mergeManifest{Inputs: []int{1, 2}, Outputs: []mergeManifestOutput{{Temp: "merge-1", Id: 4}}}
+}
+
+func helperCheckKeys(t *testing.T, store *DataStore) {
+	t.Helper()
+	for _, key := range []string{"key1", "key3"} {
+		val, err := store.Get([]byte(key))
+		if err != nil || string(val) != "value"+key[3:] {
+			t.Errorf("%s: expected value%s, got %s (err: %v)", key, key[3:], val, err)
+		}
+	}
+	if _, err := store.Get([]byte("key2")); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("expected deleted key2 to stay deleted, got %v", err)
+	}
+}
+
+func TestMergeManifestIsRemoved(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	path := "test_merge_manifest.db"
+	helperMergeCrashStore(t, fs, path)
+	if exists, _ := afero.Exists(fs, filepath.Join(path, mergeManifestFileName)); exists {
+		t.Errorf("expected the manifest to be removed after the merge")
+	}
+}
+
+func TestOpenCompletesInterruptedMerge(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	path := "test_merge_complete.db"
+	before, manifest := helperMergeCrashStore(t, fs, path)
+
+	// Crash after the rename, while deleting the merged files: file 2 (with the tombstone of key2) was deleted, file 1
+	// was not. Without the manifest, key2 would come back from file 1
+	name := filepath.Join(path, "data", utils.GetDataFileName(1))
+	afero.WriteFile(fs, name, before[name], os.ModePerm)
+	if err := writeMergeManifest(fs, path, manifest); err != nil {
+		t.Fatalf("could not write manifest: %v", err)
+	}
+
+	store, err := Open(fs, path)
+	if err != nil {
+		t.Fatalf("failed to open store: %v", err)
+	}
+	defer store.Close()
+	if report := store.OpenReport(); report.InterruptedMerge != MergeCompleted {
+		t.Errorf("expected the merge to be completed, got %+v", report)
+	}
+	helperCheckKeys(t, store)
+	if exists, _ := afero.Exists(fs, name); exists {
+		t.Errorf("expected merged data file 1 to be deleted")
+	}
+	if exists, _ := afero.Exists(fs, filepath.Join(path, mergeManifestFileName)); exists {
+		t.Errorf("expected the manifest to be removed")
+	}
+}
+
+func TestOpenCompletesMergeBeforeRename(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	path := "test_merge_rename.db"
+	before, manifest := helperMergeCrashStore(t, fs, path)
+
+	// Crash right after the manifest was written: nothing was renamed or deleted yet
+	for name, data := range before {
+		afero.WriteFile(fs, name, data, os.ModePerm)
+	}
+	fs.Rename(filepath.Join(path, "data", utils.GetDataFileName(4)), filepath.Join(path, "data", "merge-1"))
+	fs.Rename(filepath.Join(path, "hint", utils.GetHintFileName(4)), filepath.Join(path, "hint", "merge-1"))
+	if err := writeMergeManifest(fs, path, manifest); err != nil {
+		t.Fatalf("could not write manifest: %v", err)
+	}
+
+	store, err := Open(fs, path)
+	if err != nil {
+		t.Fatalf("failed to open store: %v", err)
+	}
+	defer store.Close()
+	if report := store.OpenReport(); report.InterruptedMerge != MergeCompleted || len(report.UnknownFiles) != 0 {
+		t.Errorf("expected the merge to be completed, got %+v", report)
+	}
+	helperCheckKeys(t, store)
+	if exists, _ := afero.Exists(fs, filepath.Join(path, "hint", utils.GetHintFileName(4))); !exists {
+		t.Errorf("expected the hint file to be renamed")
+	}
+}
+
+func TestOpenRollsBackMergeWithMissingOutput(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	path := "test_merge_rollback.db"
+	before, manifest := helperMergeCrashStore(t, fs, path)
+
+	// The inputs are all there, but the output is gone
+	for name, data := range before {
+		afero.WriteFile(fs, name, data, os.ModePerm)
+	}
+	fs.Remove(filepath.Join(path, "data", utils.GetDataFileName(4)))
+	if err := writeMergeManifest(fs, path, manifest); err != nil {
+		t.Fatalf("could not write manifest: %v", err)
+	}
+
+	store, err := Open(fs, path)
+	if err != nil {
+		t.Fatalf("failed to open store: %v", err)
+	}
+	defer store.Close()
+	if report := store.OpenReport(); report.InterruptedMerge != MergeRolledBack {
+		t.Errorf("expected the merge to be rolled back, got %+v", report)
+	}
+	helperCheckKeys(t, store)
+	if exists, _ := afero.Exists(fs, filepath.Join(path, "hint", utils.GetHintFileName(4))); exists {
+		t.Errorf("expected the hint file of the output to be removed")
+	}
+}
+
+func TestOpenFailsWhenMergeCannotBeRecovered(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	path := "test_merge_unrecoverable.db"
+	_, manifest := helperMergeCrashStore(t, fs, path)
+
+	fs.Remove(filepath.Join(path, "data", utils.GetDataFileName(4)))
+	if err := writeMergeManifest(fs, path, manifest); err != nil {
+		t.Fatalf("could not write manifest: %v", err)
+	}
+	if _, err := Open(fs, path); !errors.Is(err, ErrMergeRecovery) {
+		t.Errorf("expected ErrMergeRecovery, got %v", err)
+	}
+}
+
+func TestOpenRemovesUncommittedMergeFiles(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	path := "test_merge_uncommitted.db"
+	helperCreateMergedStore(t, fs, path)
+	afero.WriteFile(fs, filepath.Join(path, "data", "merge-1"), []byte("partial"), os.ModePerm)
+	afero.WriteFile(fs, filepath.Join(path, "hint", "merge-1"), []byte("partial"), os.ModePerm)
+
+	store, err := Open(fs, path)
+	if err != nil {
+		t.Fatalf("failed to open store: %v", err)
+	}
+	defer store.Close()
+	if report := store.OpenReport(); report.InterruptedMerge != "" || len(report.UnknownFiles) != 0 {
+		t.Errorf("expected an empty report, got %+v", report)
+	}
+	// The leftover files would make the next merge fail, since it writes to the same names
+	store.Put([]byte("key4"), []byte("value4"))
+	store.Close()
+	if store, err = Open(fs, path); err != nil {
+		t.Fatalf("failed to reopen store: %v", err)
+	}
+	defer store.Close()
+	if err := store.Merge(); err != nil {
+		t.Errorf("merge failed: %v", err)
+	}
+}
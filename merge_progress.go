@@ -0,0 +1,25 @@
+package kvdb
+
+// MergeOptions changes how a merge is run
+type MergeOptions struct {
+	// Progress is called from the merging goroutine every few thousand records, and after every merged file (the last
+	// call reports every file as merged, and comes before the new files are committed). The merge waits for it, so it
+	// must return quickly, and it must not call Merge
+	Progress func(progress MergeProgress)
+}
+
+// Records scanned between calls of MergeOptions.Progress
+const mergeProgressInterval = 4 * cancelCheckInterval
+
+// MergeProgress describes how far a running merge is
+type MergeProgress struct {
+	// Number of immutable files being merged, and how many of them have been scanned completely
+	TotalFiles  int
+	MergedFiles int
+	// Total size of the files being merged, and the number of their bytes that have been scanned
+	TotalBytes   int64
+	ScannedBytes int64
+	// Records scanned so far, and how many of them were live (and copied into the new files)
+	ScannedRecords int64
+	LiveRecords    int64
+}
@@ -0,0 +1,60 @@
+package kvdb
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/spf13/afero"
+)
+
+func TestMergeProgress(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	path := "test_merge_progress.db"
+	// Three immutable files, each one overwrites the keys of the previous one
+	for i := range 3 {
+		store, err := Open(fs, path)
+		if i == 0 {
+			store, err = Create(fs, path)
+		}
+		if err != nil {
+			t.Fatalf("failed to open store: %v", err)
+		}
+		for j := range 5000 {
+			store.Put([]byte(fmt.Sprintf("key%d", j)), []byte(fmt.Sprintf("value%d-%d", i, j)))
+		}
+		store.Close()
+	}
+	store, err := Open(fs, path)
+	if err != nil {
+		t.Fatalf("failed to open store: %v", err)
+	}
+	defer store.Close()
+	store.Put([]byte("key0"), []byte("latest"))
+
+	var calls []MergeProgress
+	err = store.MergeWithOptions(context.Background(), &MergeOptions{Progress: func(progress MergeProgress) {
+		calls = append(calls, progress)
+	}})
+	if err != nil {
+		t.Fatalf("merge failed: %v", err)
+	}
+	if len(calls) <= 3 {
+		t.Fatalf("expected progress within the files, got %d calls", len(calls))
+	}
+	for i := 1; i < len(calls); i++ {
+		if calls[i].ScannedBytes < calls[i-1].ScannedBytes || calls[i].ScannedRecords < calls[i-1].ScannedRecords {
+			t.Errorf("expected progress to increase, got %+v after %+v", calls[i], calls[i-1])
+		}
+	}
+	last := calls[len(calls)-1]
+	if last.TotalFiles != 3 || last.MergedFiles != 3 || last.ScannedBytes != last.TotalBytes || last.TotalBytes == 0 {
+		t.Errorf("expected every file to be merged, got %+v", last)
+	}
+	if last.ScannedRecords != 15000 || last.LiveRecords != 4999 {
+		t.Errorf("expected 15000 scanned and 4999 live records, got %+v", last)
+	}
+	if stats, _ := store.Stats(); stats.Merges != 1 {
+		t.Errorf("expected one merge, got %d", stats.Merges)
+	}
+}
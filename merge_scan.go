@@ -0,0 +1,195 @@
+package kvdb
+
+import (
+	"sync"
+
+	"github.com/ananthvk/kvdb/internal/record"
+	"github.com/spf13/afero"
+)
+
+/*
+Concurrent merge scans
+
+A merge reads the immutable data files one after the other, and checks every record against the keydir before it's
+written to the merge output. Reading a file (and checking the checksum of every record) is most of the work, so with
+Options.MergeParallelism > 1 the files are read ahead by a pool of workers, up to MergeParallelism files at the same time.
+The merge still takes the records of a file in order, and the files in order, so the output is the same as the one of a
+sequential merge.
+
+Workers are started in the order of the files, and a worker holds it's slot until it has read all of it's file, so the
+file the merge is writing is always being read. Each worker queues at most mergePrefetchBatches batches of records, which
+bounds the memory used by a worker that is ahead of the merge
+*/
+
+// A worker sends the records it has read in batches of mergePrefetchRecords records (or mergePrefetchBytes bytes of keys
+// and values), and has at most mergePrefetchBatches batches queued
+const (
+	mergePrefetchRecords = 256
+	mergePrefetchBytes   = 1024 * 1024
+	mergePrefetchBatches = 4
+)
+
+// mergeSource is the records of a data file that is being merged, a *record.Scanner reads them as the merge needs them,
+// and a prefetchScanner gets them from a worker that read them ahead
+type mergeSource interface {
+	Scan() (record.Record, int64, error)
+	Close() error
+}
+
+type scannedRecord struct {
+	rec    record.Record
+	offset int64
+}
+
+type prefetchBatch struct {
+	records []scannedRecord
+	// Set in the last batch of the file, io.EOF if the whole file was read
+	err error
+}
+
+// prefetchScanner returns the records of a data file read by a worker of a mergeScanPool. Unlike record.Scanner, the
+// key and value of a record are not overwritten by the next Scan
+type prefetchScanner struct {
+	path    string
+	limits  record.Limits
+	keyring *record.Keyring
+	// Closed once the worker has opened the file, openErr is set if it could not be opened
+	opened  chan struct{}
+	openErr error
+	batches chan prefetchBatch
+
+	current []scannedRecord
+	err     error
+}
+
+// Scan returns the next record of the file, and it's offset
+func (p *prefetchScanner) Scan() (record.Record, int64, error) {
+	for len(p.current) == 0 {
+		if p.err != nil {
+			return record.Record{}, 0, p.err
+		}
+		batch := <-p.batches
+		p.current, p.err = batch.records, batch.err
+	}
+	next := p.current[0]
+	p.current = p.current[1:]
+	return next.rec, next.offset, nil
+}
+
+// Close does nothing, the worker closes the file once it has been read, or when the pool is closed
+func (p *prefetchScanner) Close() error {
+	return nil
+}
+
+// run reads the file, and sends it's records in batches, until the file has been read or stop is closed
+func (p *prefetchScanner) run(fs afero.Fs, stop <-chan struct{}) {
+	scanner, err := record.NewScanner(fs, p.path)
+	p.openErr = err
+	close(p.opened)
+	if err != nil {
+		return
+	}
+	defer scanner.Close()
+	scanner.SetLimits(p.limits)
+	scanner.SetKeyring(p.keyring)
+
+	var batch prefetchBatch
+	batchBytes := 0
+	for {
+		rec, offset, err := scanner.Scan()
+		if err != nil {
+			batch.err = err
+		} else {
+			// The scanner reuses it's buffer, so the key and value are copied
+			buf := make([]byte, len(rec.Key)+len(rec.Value))
+			keySize := copy(buf, rec.Key)
+			copy(buf[keySize:], rec.Value)
+			rec.Key, rec.Value = buf[:keySize:keySize], buf[keySize:]
+			batch.records = append(batch.records, scannedRecord{rec: rec, offset: offset})
+			batchBytes += len(buf)
+		}
+		if batch.err == nil && len(batch.records) < mergePrefetchRecords && batchBytes < mergePrefetchBytes {
+			continue
+		}
+		select {
+		case p.batches <- batch:
+		case <-stop:
+			return
+		}
+		if batch.err != nil {
+			return
+		}
+		batch, batchBytes = prefetchBatch{}, 0
+	}
+}
+
+// mergeScanPool reads the data files of a merge ahead, with up to parallelism files read at the same time
+type mergeScanPool struct {
+	scanners []*prefetchScanner
+	stop     chan struct{}
+	wg       sync.WaitGroup
+}
+
+// newMergeScanPool starts reading the files at paths, in order
+func newMergeScanPool(fs afero.Fs, paths []string, limits record.Limits, keyring *record.Keyring, parallelism int) *mergeScanPool {
+	pool := &mergeScanPool{stop: make(chan struct{})}
+	for _, path := range paths {
+		pool.scanners = append(pool.scanners, &prefetchScanner{
+			path:    path,
+			limits:  limits,
+			keyring: keyring,
+			opened:  make(chan struct{}),
+			batches: make(chan prefetchBatch, mergePrefetchBatches),
+		})
+	}
+	slots := make(chan struct{}, parallelism)
+	pool.wg.Add(1)
+	go func() {
+		defer pool.wg.Done()
+		for _, scanner := range pool.scanners {
+			select {
+			case slots <- struct{}{}:
+			case <-pool.stop:
+				return
+			}
+			pool.wg.Add(1)
+			go func() {
+				defer pool.wg.Done()
+				defer func() { <-slots }()
+				scanner.run(fs, pool.stop)
+			}()
+		}
+	}()
+	return pool
+}
+
+// open returns the records of the i-th file, once it has been opened by a worker
+func (pool *mergeScanPool) open(i int) (mergeSource, error) {
+	scanner := pool.scanners[i]
+	<-scanner.opened
+	if scanner.openErr != nil {
+		return nil, scanner.openErr
+	}
+	return scanner, nil
+}
+
+// close stops the workers, and waits for them to close their files
+func (pool *mergeScanPool) close() {
+	close(pool.stop)
+	pool.wg.Wait()
+}
+
+// openMergeSource returns the records of the i-th file of a merge, from the pool if it's not nil, or from a scanner
+// that reads the file at path
+func openMergeSource(fs afero.Fs, pool *mergeScanPool, i int, path string, limits record.Limits, keyring *record.Keyring) (mergeSource, error) {
+	if pool != nil {
+		return pool.open(i)
+	}
+	scanner, err := record.NewScanner(fs, path)
+	if err != nil {
+		return nil, err
+	}
+	scanner.SetLimits(limits)
+	scanner.SetKeyring(keyring)
+	return scanner, nil
+}
@@ -0,0 +1,97 @@
+package kvdb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/spf13/afero"
+)
+
+// helperMergeScanStore creates a store with files immutable data files, each one overwrites or deletes some keys of the
+// previous ones, and opens it with the options. It returns the expected value of every key, "" for deleted keys
+func helperMergeScanStore(t *testing.T, fs afero.Fs, path string, files int, opts *Options) (*DataStore, map[string]string) {
+	t.Helper()
+	expected := map[string]string{}
+	for i := range files {
+		store, err := Open(fs, path)
+		if i == 0 {
+			store, err = Create(fs, path)
+		}
+		if err != nil {
+			t.Fatalf("failed to open store: %v", err)
+		}
+		for j := i * 100; j < i*100+1000; j++ {
+			key := fmt.Sprintf("key%d", j)
+			if j%7 == i {
+				store.Delete([]byte(key))
+				expected[key] = ""
+				continue
+			}
+			value := fmt.Sprintf("value%d-%d", i, j)
+			store.Put([]byte(key), []byte(value))
+			expected[key] = value
+		}
+		store.Close()
+	}
+	store, err := OpenWithOptions(fs, path, opts)
+	if err != nil {
+		t.Fatalf("failed to open store: %v", err)
+	}
+	t.Cleanup(func() { store.Close() })
+	return store, expected
+}
+
+func TestMergeParallelism(t *testing.T) {
+	for _, parallelism := range []int{0, 2, 4, 16} {
+		fs := afero.NewMemMapFs()
+		path := "test_merge_parallelism.db"
+		store, expected := helperMergeScanStore(t, fs, path, 8, &Options{MergeParallelism: parallelism})
+		if err := store.Merge(); err != nil {
+			t.Fatalf("parallelism %d: merge failed: %v", parallelism, err)
+		}
+		for key, value := range expected {
+			got, err := store.Get([]byte(key))
+			if value == "" {
+				if !errors.Is(err, ErrKeyNotFound) {
+					t.Errorf("parallelism %d: expected %s to be deleted, got %q, %v", parallelism, key, got, err)
+				}
+				continue
+			}
+			if err != nil || string(got) != value {
+				t.Errorf("parallelism %d: expected %s=%s, got %q, %v", parallelism, key, value, got, err)
+			}
+		}
+		if stats, _ := store.Stats(); stats.DataFiles != 2 {
+			t.Errorf("parallelism %d: expected the merged file and the active file, got %d files", parallelism, stats.DataFiles)
+		}
+	}
+}
+
+func TestMergeParallelismCancelled(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	path := "test_merge_parallelism_cancelled.db"
+	store, expected := helperMergeScanStore(t, fs, path, 8, &Options{MergeParallelism: 4})
+	before, _ := store.Stats()
+	calls := 0
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	err := store.MergeWithOptions(ctx, &MergeOptions{Progress: func(MergeProgress) {
+		// Cancel once the first file has been merged, while the workers are reading the next ones
+		if calls++; calls == 1 {
+			cancel()
+		}
+	}})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected the merge to be cancelled, got %v", err)
+	}
+	if stats, _ := store.Stats(); stats.DataFiles != before.DataFiles {
+		t.Errorf("expected the data files to be unchanged, got %d files, expected %d", stats.DataFiles, before.DataFiles)
+	}
+	for key, value := range expected {
+		if got, err := store.Get([]byte(key)); value != "" && (err != nil || string(got) != value) {
+			t.Errorf("expected %s=%s, got %q, %v", key, value, got, err)
+		}
+	}
+}
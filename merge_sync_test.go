@@ -0,0 +1,182 @@
+package kvdb
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/spf13/afero"
+)
+
+// syncRecordingFs records the syncs of files and directories, and the removals, in order. Syncs of the directories in
+// failDirs fail
+type syncRecordingFs struct {
+	afero.Fs
+	mu       sync.Mutex
+	events   []string
+	failDirs map[string]bool
+}
+
+type syncRecordingFile struct {
+	afero.File
+	fs   *syncRecordingFs
+	path string
+}
+
+func (fs *syncRecordingFs) record(event string) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	fs.events = append(fs.events, event)
+}
+
+func (fs *syncRecordingFs) Events() []string {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	return slices.Clone(fs.events)
+}
+
+func (fs *syncRecordingFs) wrap(file afero.File, path string, err error) (afero.File, error) {
+	if err != nil {
+		return nil, err
+	}
+	return &syncRecordingFile{File: file, fs: fs, path: filepath.Clean(path)}, nil
+}
+
+func (fs *syncRecordingFs) Open(name string) (afero.File, error) {
+	file, err := fs.Fs.Open(name)
+	return fs.wrap(file, name, err)
+}
+
+func (fs *syncRecordingFs) OpenFile(name string, flag int, perm os.FileMode) (afero.File, error) {
+	file, err := fs.Fs.OpenFile(name, flag, perm)
+	return fs.wrap(file, name, err)
+}
+
+func (fs *syncRecordingFs) Create(name string) (afero.File, error) {
+	file, err := fs.Fs.Create(name)
+	return fs.wrap(file, name, err)
+}
+
+func (fs *syncRecordingFs) Remove(name string) error {
+	fs.record("remove " + filepath.Clean(name))
+	return fs.Fs.Remove(name)
+}
+
+func (f *syncRecordingFile) Sync() error {
+	if info, err := f.Stat(); err == nil && info.IsDir() {
+		f.fs.record("syncdir " + f.path)
+		if f.fs.failDirs[f.path] {
+			return errors.New("sync failed")
+		}
+	} else {
+		f.fs.record("sync " + f.path)
+	}
+	return f.File.Sync()
+}
+
+// helperMergeSyncStore creates a store with two immutable data files, and opens it with the options
+func helperMergeSyncStore(t *testing.T, fs afero.Fs, path string, opts *Options) *DataStore {
+	t.Helper()
+	for i := range 2 {
+		store, err := Open(fs, path)
+		if i == 0 {
+			store, err = Create(fs, path)
+		}
+		if err != nil {
+			t.Fatalf("failed to open store: %v", err)
+		}
+		for j := range 10 {
+			store.Put([]byte(fmt.Sprintf("key%d", j)), []byte(fmt.Sprintf("value%d-%d", i, j)))
+		}
+		store.Close()
+	}
+	store, err := OpenWithOptions(fs, path, opts)
+	if err != nil {
+		t.Fatalf("failed to open store: %v", err)
+	}
+	t.Cleanup(func() { store.Close() })
+	// Start a new active file, so that both files are merged
+	store.Put([]byte("key0"), []byte("latest"))
+	return store
+}
+
+func TestStrictMergeSync(t *testing.T) {
+	path := "test_strict_merge_sync.db"
+	for _, strict := range []bool{false, true} {
+		fs := &syncRecordingFs{Fs: afero.NewMemMapFs()}
+		store := helperMergeSyncStore(t, fs, path, &Options{StrictMergeSync: strict})
+		start := len(fs.Events())
+		if err := store.Merge(); err != nil {
+			t.Fatalf("merge failed: %v", err)
+		}
+		events := fs.Events()[start:]
+		firstRemove := slices.IndexFunc(events, func(e string) bool {
+			return strings.HasPrefix(e, "remove "+filepath.Join(path, "data"))
+		})
+		if firstRemove < 0 {
+			t.Fatalf("expected the merged files to be removed, got %v", events)
+		}
+		for _, dir := range []string{path, filepath.Join(path, "data"), filepath.Join(path, "hint")} {
+			synced := slices.Index(events[:firstRemove], "syncdir "+dir)
+			if strict && synced < 0 {
+				t.Errorf("expected %s to be synced before the merged files are removed, got %v", dir, events)
+			}
+			if !strict && slices.Contains(events, "syncdir "+dir) {
+				t.Errorf("expected %s not to be synced without StrictMergeSync", dir)
+			}
+		}
+		// The new hint files are synced before the manifest is written, in both modes
+		hintSynced := slices.Index(events, "sync "+filepath.Join(path, "hint", "merge-1"))
+		manifestWritten := slices.Index(events, "sync "+filepath.Join(path, mergeManifestFileName+".tmp"))
+		if hintSynced < 0 || manifestWritten < 0 || hintSynced > manifestWritten {
+			t.Errorf("expected the hint file to be synced before the manifest is written, got %v", events)
+		}
+		if value, err := store.Get([]byte("key0")); err != nil || string(value) != "latest" {
+			t.Errorf("expected key0=latest, got %q, %v", value, err)
+		}
+	}
+}
+
+func TestStrictMergeSyncFailure(t *testing.T) {
+	path := "test_strict_merge_sync_failure.db"
+	fs := &syncRecordingFs{Fs: afero.NewMemMapFs()}
+	store := helperMergeSyncStore(t, fs, path, &Options{StrictMergeSync: true})
+	fs.failDirs = map[string]bool{filepath.Join(path, "data"): true}
+	if err := store.Merge(); err == nil {
+		t.Fatalf("expected the merge to fail")
+	}
+	for _, event := range fs.Events() {
+		if strings.HasPrefix(event, "remove "+filepath.Join(path, "data")) {
+			t.Errorf("expected the merged files to be kept, got %s", event)
+		}
+	}
+	if value, err := store.Get([]byte("key1")); err != nil || string(value) != "value1-1" {
+		t.Errorf("expected key1=value1-1, got %q, %v", value, err)
+	}
+	store.Close()
+
+	// The manifest was left in place, so the merge is completed when the datastore is opened
+	fs.failDirs = nil
+	store, err := Open(fs, path)
+	if err != nil {
+		t.Fatalf("failed to reopen store: %v", err)
+	}
+	defer store.Close()
+	if recovery := store.OpenReport().InterruptedMerge; recovery != MergeCompleted {
+		t.Errorf("expected the merge to be completed, got %q", recovery)
+	}
+	for j := range 10 {
+		expected := fmt.Sprintf("value1-%d", j)
+		if j == 0 {
+			expected = "latest"
+		}
+		if value, err := store.Get([]byte(fmt.Sprintf("key%d", j))); err != nil || string(value) != expected {
+			t.Errorf("expected key%d=%s, got %q, %v", j, expected, value, err)
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package kvdb
+
+import (
+	"fmt"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"testing"
+
+	"github.com/spf13/afero"
+)
+
+func TestMmapReads(t *testing.T) {
+	fs := afero.NewOsFs()
+	path := filepath.Join(t.TempDir(), "test_mmap_reads.db")
+	store, err := Create(fs, path)
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	expected := map[string]string{}
+	for i := range 100 {
+		key, value := fmt.Sprintf("key%d", i), fmt.Sprintf("value%d", i)
+		store.Put([]byte(key), []byte(value))
+		expected[key] = value
+	}
+	store.Close()
+
+	store, err = OpenWithOptions(fs, path, &Options{MmapReads: true})
+	if err != nil {
+		t.Fatalf("failed to reopen store: %v", err)
+	}
+	defer store.Close()
+	// The new active file is read with pread
+	for i := range 10 {
+		key, value := fmt.Sprintf("key%d", i), fmt.Sprintf("updated%d", i)
+		store.Put([]byte(key), []byte(value))
+		expected[key] = value
+	}
+	helperCheckValues(t, store, expected)
+	if runtime.GOOS == "windows" {
+		t.Skip("memory mapping is not supported on windows")
+	}
+	size, err := store.fileManager.DataFileSize(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats, err := store.Stats(); err != nil || stats.MappedBytes != size {
+		t.Errorf("expected only the immutable file (%d bytes) to be mapped, got %d, %v", size, stats.MappedBytes, err)
+	}
+
+	// Gets keep working while the merge replaces (and unmaps) the files they read
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+	for range 4 {
+		wg.Go(func() {
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				for key, value := range expected {
+					if got, err := store.Get([]byte(key)); err == nil && string(got) != value {
+						t.Errorf("expected %s=%s, got %s", key, value, got)
+					}
+				}
+			}
+		})
+	}
+	if err := store.Merge(); err != nil {
+		t.Fatalf("merge failed: %v", err)
+	}
+	close(stop)
+	wg.Wait()
+	helperCheckValues(t, store, expected)
+	if stats, err := store.Stats(); err != nil || stats.MappedBytes == 0 {
+		t.Errorf("expected the merged files to be mapped, got %d, %v", stats.MappedBytes, err)
+	}
+}
@@ -0,0 +1,64 @@
+package kvdb
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"testing"
+
+	"github.com/spf13/afero"
+)
+
+func TestMaxOpenFiles(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	if _, err := CreateWithOptions(fs, "test_small_budget.db", &Options{MaxOpenFiles: 3}); err == nil {
+		t.Errorf("expected a budget that is too small to be rejected")
+	}
+
+	// Every reopen starts a new data file
+	expected := map[string]string{}
+	for i := range 10 {
+		opts := &Options{MaxOpenFiles: 5}
+		store, err := OpenWithOptions(fs, "test_max_open_files.db", opts)
+		if errors.Is(err, ErrNotExist) {
+			store, err = CreateWithOptions(fs, "test_max_open_files.db", opts)
+		}
+		if err != nil {
+			t.Fatalf("failed to open store: %v", err)
+		}
+		key, value := fmt.Sprintf("key%d", i), fmt.Sprintf("value%d", i)
+		store.Put([]byte(key), []byte(value))
+		expected[key] = value
+		if i < 9 {
+			store.Close()
+			continue
+		}
+		defer store.Close()
+
+		helperCheckValues(t, store, expected)
+		stats, _ := store.Stats()
+		if stats.DataFiles < 10 || stats.OpenFiles > 5 || stats.ReaderEvictions == 0 {
+			t.Errorf("expected %d data files to be read with at most 5 open files, got %d open, %d evictions", stats.DataFiles, stats.OpenFiles, stats.ReaderEvictions)
+		}
+
+		// Value readers can't take the datastore over the budget
+		var readers []io.Closer
+		for key := range expected {
+			r, _, err := store.GetReader([]byte(key))
+			if errors.Is(err, ErrOpenFileLimit) {
+				break
+			}
+			if err != nil {
+				t.Fatalf("GetReader failed: %v", err)
+			}
+			readers = append(readers, r)
+		}
+		if stats, _ := store.Stats(); len(readers) == len(expected) || stats.OpenFiles > 5 {
+			t.Errorf("expected GetReader to fail once the budget is used, got %d readers and %d open files", len(readers), stats.OpenFiles)
+		}
+		for _, r := range readers {
+			r.Close()
+		}
+		helperCheckValues(t, store, expected)
+	}
+}
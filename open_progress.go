@@ -0,0 +1,24 @@
+package kvdb
+
+import "github.com/ananthvk/kvdb/internal/filemanager"
+
+// OpenProgress describes how far OpenWithContext is in building the keydir, see Options.OpenProgress
+type OpenProgress struct {
+	// Number of data files, and how many of them have been loaded
+	TotalFiles  int
+	LoadedFiles int
+	// Number of the loaded files that were loaded from their hint file, instead of being scanned
+	HintFiles int
+	// Records (or hints) read so far
+	Records int64
+}
+
+// openProgressFn returns the progress function of the file manager that calls fn, or nil if fn is nil
+func openProgressFn(fn func(OpenProgress)) func(filemanager.LoadProgress) {
+	if fn == nil {
+		return nil
+	}
+	return func(p filemanager.LoadProgress) {
+		fn(OpenProgress{TotalFiles: p.TotalFiles, LoadedFiles: p.LoadedFiles, HintFiles: p.HintFiles, Records: p.Records})
+	}
+}
@@ -0,0 +1,57 @@
+package kvdb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/spf13/afero"
+)
+
+func TestOpenWithContextProgress(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	store, err := CreateWithOptions(fs, "open_progress.db", &Options{MaxRecordsPerFile: 5000})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range 12000 {
+		store.Put(fmt.Appendf(nil, "key%d", i), []byte("value"))
+	}
+	store.Close()
+
+	var calls []OpenProgress
+	opts := &Options{OpenProgress: func(p OpenProgress) { calls = append(calls, p) }}
+	store, err = OpenWithContext(context.Background(), fs, "open_progress.db", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.Close()
+	if len(calls) < 4 {
+		t.Fatalf("expected progress within the files and after every file, got %+v", calls)
+	}
+	last := calls[len(calls)-1]
+	if last.TotalFiles != 3 || last.LoadedFiles != 3 || last.Records != 12000 || last.HintFiles != 0 {
+		t.Errorf("unexpected final progress %+v", last)
+	}
+
+	// Cancelled while the first file is loaded
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	opts = &Options{OpenProgress: func(OpenProgress) { cancel() }}
+	if _, err := OpenWithContext(ctx, fs, "open_progress.db", opts); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+	if _, err := OpenWithContext(ctx, fs, "open_progress.db", nil); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled for a context that's already done, got %v", err)
+	}
+
+	store, err = Open(fs, "open_progress.db")
+	if err != nil {
+		t.Fatalf("expected the datastore to open after a cancelled open, got %v", err)
+	}
+	defer store.Close()
+	if store.Size() != 12000 {
+		t.Errorf("expected 12000 keys, got %d", store.Size())
+	}
+}
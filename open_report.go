@@ -0,0 +1,37 @@
+package kvdb
+
+// OpenReport lists the problems found in the data directory when the datastore was opened. None of these prevent the
+// datastore from opening, but they may indicate data loss or files left behind by other programs
+type OpenReport struct {
+	// Names of files in the data directory that are not data files (for example, editor backups or core dumps).
+	// They are ignored, and do not affect the ids given to new data files
+	UnknownFiles []string
+	// Ids of data files that could not be read completely, the records that could not be read are not in the datastore
+	InvalidDataFiles []int
+	// Ids of data files whose hint file was ignored because it could not be read, or did not match the data file. The
+	// data file was scanned instead
+	IgnoredHintFiles []int
+	// Names of the files in the hint directory that belong to a data file that does not exist, or that were ignored
+	// (see IgnoredHintFiles). They were removed, unless the datastore was opened with HintOrphansKeep
+	OrphanHintFiles []string
+	// What was done with a merge that was interrupted by a crash, empty if there was none
+	InterruptedMerge MergeRecovery
+	// Id of the data file that new records are appended to (see Options.ReuseLastFile), 0 if writes start a new file
+	ReusedDataFile int
+}
+
+// OpenReport returns the problems found when the datastore was opened. The report is empty for a newly created datastore
+func (dataStore *DataStore) OpenReport() OpenReport {
+	if !dataStore.opened() {
+		return OpenReport{}
+	}
+	report := dataStore.fileManager.LoadReport()
+	return OpenReport{
+		UnknownFiles:     report.UnknownFiles,
+		InvalidDataFiles: report.InvalidDataFiles,
+		IgnoredHintFiles: report.IgnoredHintFiles,
+		OrphanHintFiles:  dataStore.openOrphanHintFiles,
+		InterruptedMerge: dataStore.mergeRecovery,
+		ReusedDataFile:   dataStore.reusedFile,
+	}
+}
@@ -0,0 +1,49 @@
+package kvdb
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/spf13/afero"
+)
+
+func TestOpenReportUnknownFiles(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	store := helperCreateMultipleDataFiles(t, fs, "test_open_report.db")
+	store.Put([]byte("key1"), []byte("value1"))
+	store.Close()
+
+	dataDir := filepath.Join("test_open_report.db", "data")
+	unknown := []string{"0000000001.dat~", "0000000001.dat.bak", "core.99999", "123", "42.swp", "+000000050.dat"}
+	for _, name := range unknown {
+		afero.WriteFile(fs, filepath.Join(dataDir, name), []byte("not a data file"), 0644)
+	}
+	// Named like a data file, but not a valid one
+	afero.WriteFile(fs, filepath.Join(dataDir, "0000000002.dat"), []byte("garbage"), 0644)
+
+	store, err := Open(fs, "test_open_report.db")
+	if err != nil {
+		t.Fatalf("failed to reopen store: %v", err)
+	}
+	defer store.Close()
+
+	report := store.OpenReport()
+	if len(report.UnknownFiles) != len(unknown) {
+		t.Errorf("expected unknown files %v, got %v", unknown, report.UnknownFiles)
+	}
+	if len(report.InvalidDataFiles) != 1 || report.InvalidDataFiles[0] != 2 {
+		t.Errorf("expected invalid data files [2], got %v", report.InvalidDataFiles)
+	}
+
+	// Unknown files must not affect file id allocation, the next data file is the one after 0000000002.dat
+	if err := store.Put([]byte("key2"), []byte("value2")); err != nil {
+		t.Fatalf("put failed: %v", err)
+	}
+	if exists, _ := afero.Exists(fs, filepath.Join(dataDir, "0000000003.dat")); !exists {
+		t.Errorf("expected new data file 0000000003.dat to be created")
+	}
+	val, err := store.Get([]byte("key1"))
+	if err != nil || string(val) != "value1" {
+		t.Errorf("expected value1, got %s (err: %v)", val, err)
+	}
+}
@@ -0,0 +1,289 @@
+package kvdb
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"github.com/ananthvk/kvdb/internal/filemanager"
+	"github.com/ananthvk/kvdb/internal/record"
+	"github.com/spf13/afero"
+)
+
+// Options configures the behaviour of a datastore, it's passed to CreateWithOptions and OpenWithOptions.
+// The zero value is valid, and is the same as the default options
+type Options struct {
+	// Interceptors are run in order for every Put and Delete, see WriteInterceptor
+	Interceptors []WriteInterceptor
+
+	// LockProfileRate enables the lock contention profiler, one in every LockProfileRate acquisitions of the datastore
+	// and file manager locks is timed, and the wait times are reported in Stats.LockContention. While a sampled
+	// acquisition waits, the goroutine carries the pprof label kvdb_lock=<site>. 0 (the default) disables profiling
+	LockProfileRate int
+
+	// Write stall thresholds, the datastore stalls when it has more than StallDataFiles data files, or more than
+	// StallUnsyncedBytes bytes written since the last Sync. 0 disables a threshold. See StallError
+	StallDataFiles     int
+	StallUnsyncedBytes int64
+	// While stalled, writes are delayed by StallDelay (10ms if not set), or rejected with a *StallError if
+	// RejectStalledWrites is true
+	StallDelay          time.Duration
+	RejectStalledWrites bool
+
+	// Largest key and value sizes (in bytes) of a new datastore, larger writes fail with ErrKeyTooLarge or
+	// ErrValueTooLarge. They are only used by CreateWithOptions, and are stored in the metafile, so they can't be changed
+	// when the datastore is opened. 0 uses the default limits (1000 bytes for keys and 1 MB for values)
+	MaxKeySize   int
+	MaxValueSize int
+
+	// ActiveDir, MergedDir and HintDir put the files of a new datastore in separate directories (which may be on
+	// different disks), instead of ${path}/data and ${path}/hint: ActiveDir has the active data file, and the files
+	// sealed by rotations until they are merged, MergedDir has the data files written by merges (ActiveDir if it's not
+	// set), and HintDir has the hint files and bloom filters. So the write path can be on fast storage, and the merged
+	// files on capacity storage. Relative directories are relative to path, and directories outside it must be empty
+	// or not exist. Like the size limits, they are only used by CreateWithOptions, and are stored in the metafile. See
+	// internal/filemanager/layout.go
+	ActiveDir string
+	MergedDir string
+	HintDir   string
+
+	// RecordFormat is the format of the records of new data files, RecordFormatV1 (the default) or RecordFormatV2.
+	// Data files with either format are read, so it can be changed every time the datastore is opened. A merge writes
+	// it's output in this format, so merging rewrites the records of older files
+	RecordFormat RecordFormat
+
+	// Checksum is the algorithm of the checksums of the records of new data files, ChecksumCRC32 (the default),
+	// ChecksumCRC32C or ChecksumXXHash64. The algorithm is stored in the header of every data file, so, like the
+	// record format, it can be changed every time the datastore is opened, and a merge rewrites older files with it
+	Checksum Checksum
+
+	// StrictMergeSync makes a merge durable before it deletes the merged files: the merge fails if the new data and
+	// hint files could not be synced, and the directory of the merge manifest, and the data and hint directories, are
+	// synced after the manifest is written and after the new files are renamed. Without it, a crash right after a
+	// merge can lose the renames (and the manifest) on file systems that don't order metadata updates, while the
+	// deletions of the merged files survive. It's off by default, since every merge syncs three more directories
+	StrictMergeSync bool
+
+	// MmapReads serves reads of immutable data files from a memory mapping of the file instead of with a pread system
+	// call per read, which is faster for read heavy workloads. The active file is always read with pread, and so are
+	// all files on platforms (and file systems, like afero.MemMapFs) that can't be mapped. Mapped files count towards
+	// the address space (and page cache) of the process, see Stats.MappedBytes
+	MmapReads bool
+
+	// PreallocateDataFiles reserves disk space for the maximum size of every new data file (128 MB) when it's created,
+	// and frees what was not used when the file is sealed, so that files that are appended to over a long time are not
+	// fragmented. The size of the files doesn't change. It needs a file system with preallocation (on Linux), and is
+	// ignored everywhere else. See internal/vfs/vfs.go
+	PreallocateDataFiles bool
+
+	// WriteBufferSize buffers up to WriteBufferSize bytes of records of the active file in memory, so that a Put makes
+	// one write system call per buffer instead of two or three per record. The buffer is written to the file when it's
+	// full, by Sync and Close, and every WriteBufferFlushInterval (100ms if not set). Records that are still in the
+	// buffer are read from memory, so Gets see every write. After a crash of the process, the writes that were still
+	// buffered are lost, like the writes that were not synced are after a crash of the machine. 0 (the default)
+	// disables the buffer. See write_buffer.go
+	WriteBufferSize          int
+	WriteBufferFlushInterval time.Duration
+
+	// ValueCacheBytes enables an LRU cache of recently read values with a budget of ValueCacheBytes bytes, so that
+	// repeated Gets of hot keys are served from memory. Cached values are dropped when their key is overwritten or
+	// deleted, and when their file is removed by a merge. 0 (the default) disables the cache. See Stats.ValueCacheHits
+	ValueCacheBytes int64
+
+	// MergeBloomFilters makes merges write a bloom filter of the keys of every merged data file (next to it's hint file,
+	// as <id>.bloom). The datastore doesn't use them, they let tools that read the data files directly skip files that
+	// can't have a key, see MayContainKey
+	MergeBloomFilters bool
+
+	// MergeParallelism is the number of data files a merge reads at the same time. With more than 1, the files are read
+	// ahead by a pool of workers while the merge writes the records of the current file, which makes merges faster on
+	// fast disks. The output is the same as with 0 or 1 (the default), which read the files one after the other
+	MergeParallelism int
+
+	// StatsFlushInterval makes the counters of Stats (operations, merges, and the last merge) survive restarts: they
+	// are written to a stats file in the datastore directory every StatsFlushInterval and when the datastore is closed,
+	// and are read back when it's opened. After a crash, the operations since the last flush are lost. 0 (the default)
+	// disables the stats file, and the counters start from zero every time the datastore is opened
+	StatsFlushInterval time.Duration
+
+	// GroupCommit queues every Put for a single writer goroutine, which writes the Puts that are waiting in batches of
+	// up to GroupCommitMaxBatch (256 if not set) under one acquisition of the write lock. With GroupCommitSync, the
+	// active file is synced once per batch before the Puts return, so every Put is durable without a Sync per write.
+	// It improves throughput when many goroutines write at the same time, at the cost of some latency for a single
+	// writer. See group_commit.go
+	GroupCommit         bool
+	GroupCommitMaxBatch int
+	GroupCommitSync     bool
+
+	// KeydirMemoryLimit is a soft limit on the estimated memory used by the keydir (Stats.KeydirMemoryBytes), in bytes.
+	// Writes that would add a key and take the keydir over the limit are rejected with a *MemoryLimitError, writes to
+	// existing keys and deletes are not. WatchMemory reports when the keydir gets close to the limit. 0 (the default)
+	// disables the limit. See memory_limit.go
+	KeydirMemoryLimit int64
+
+	// HintOrphans is what is done with the files in the hint directory whose data file does not exist, and with the hint
+	// files that don't match their data file, when the datastore is opened and after a merge: HintOrphansRemove (the
+	// default) deletes them, HintOrphansKeep leaves them in place. Either way they are reported in
+	// OpenReport.OrphanHintFiles and Stats.OrphanHintFiles. See hint_orphans.go
+	HintOrphans HintOrphanPolicy
+
+	// MaxOpenFiles is a budget for the number of files the datastore keeps open at the same time (Stats.OpenFiles), so
+	// that the file descriptor limit of a process can be shared by several datastores. The readers of the data files
+	// are closed, least recently used first, to stay within it, and GetReader returns ErrOpenFileLimit if a value
+	// reader does not fit. It must leave room for the active file, a merge and one cached reader (at least 5, plus
+	// MergeParallelism-1 for parallel merges). 0 (the default) disables the budget, a reader is kept open for every
+	// data file that is read. See internal/filemanager/open_files.go
+	MaxOpenFiles int
+
+	// The active data file is sealed, and writes move on to a new file, once it reaches the maximum size of a data file,
+	// or once it has MaxRecordsPerFile records, or on the first write after it's older than MaxFileAge. The record limit
+	// bounds the time it takes to scan a file without a hint file, and the age limit keeps the records of a data file
+	// within a time span, so that whole files can be dropped by their age. 0 (the default) disables either limit. Files
+	// written by merges are only limited by size
+	MaxRecordsPerFile int
+	MaxFileAge        time.Duration
+
+	// Retention drops the immutable data files whose newest record is older than Retention, every RetentionInterval (1
+	// minute if not set), without merging them. The keys whose latest value is in a dropped file are removed, unless
+	// RetentionKeepLatest is set, in which case their records are copied to the active file first. 0 (the default)
+	// disables it. See retention.go
+	Retention           time.Duration
+	RetentionInterval   time.Duration
+	RetentionKeepLatest bool
+
+	// ReuseLastFile makes OpenWithOptions append new records to the data file that was active when the datastore was
+	// closed, if it's not full and was read completely (it does not end with a partial record), instead of starting a
+	// new data file on the first write. It keeps datastores that are opened and closed often from accumulating small
+	// data files. See internal/filemanager/reuse_file.go
+	ReuseLastFile bool
+
+	// HintsOnRotate writes the hint file of the active data file in the background once it's sealed (and of any other
+	// sealed data file without one), so that the datastore opens without scanning the files written since the last
+	// merge. It's DataStore.GenerateHints, run on every rotation. See hints.go
+	HintsOnRotate bool
+
+	// EncryptionKey makes new data files encrypted with AES-GCM: the key and value of every record (and the keys of
+	// their hint files) are encrypted, the record headers are not. It must be 16, 24 or 32 bytes long (AES-128, AES-192
+	// or AES-256). If it's not set, the key is read from EncryptionKeyFile (raw, or hex encoded), a path in the file
+	// system of the datastore. PreviousEncryptionKeys only decrypt the files written before the key was rotated, a
+	// merge rewrites their records with the current key (or in the clear if only previous keys are given) once the
+	// file that was active is sealed. The datastore can't be opened without the keys of it's encrypted data files
+	// (ErrNoEncryptionKey, ErrDecrypt), and Repair refuses encrypted files. See internal/record/cipher.go
+	EncryptionKey          []byte
+	EncryptionKeyFile      string
+	PreviousEncryptionKeys [][]byte
+
+	// OpenProgress is called while OpenWithOptions (or OpenWithContext) builds the keydir, every few thousand records
+	// and after every data file, so that the progress of opening a large datastore can be shown. Opening waits for it,
+	// so it must return quickly. It's not called by CreateWithOptions
+	OpenProgress func(progress OpenProgress)
+
+	// FileIdAllocator gives out the ids of new data files, a counter that starts after the largest id in the datastore
+	// (NewCounterAllocator) is used if it's nil. See FileIdAllocator
+	FileIdAllocator FileIdAllocator
+}
+
+// RecordFormat is the encoding of the records of a data file
+type RecordFormat int
+
+const (
+	// RecordFormatV1 records have a fixed 20 byte header, they can be read by every version of kvdb
+	RecordFormatV1 RecordFormat = iota + 1
+	// RecordFormatV2 records store the key and value sizes as varints, the header is 11 bytes for keys and values
+	// shorter than 128 bytes. Data files with v2 records can't be opened by older versions of kvdb
+	RecordFormatV2
+)
+
+// recordFormat returns the format of the records of new data files
+func (opts *Options) recordFormat() (record.Format, error) {
+	switch opts.RecordFormat {
+	case 0, RecordFormatV1:
+		return record.FormatV1, nil
+	case RecordFormatV2:
+		return record.FormatV2, nil
+	}
+	return 0, fmt.Errorf("unknown record format %d", opts.RecordFormat)
+}
+
+// Checksum is the algorithm of the checksums of the records of a data file
+type Checksum int
+
+const (
+	// ChecksumCRC32 is CRC32 with the IEEE polynomial, data files with it can be read by every version of kvdb
+	ChecksumCRC32 Checksum = iota + 1
+	// ChecksumCRC32C is CRC32 with the Castagnoli polynomial, which is computed with SSE4.2 (or the ARMv8 CRC
+	// instructions) and is much faster to check during merges and scans of large stores
+	ChecksumCRC32C
+	// ChecksumXXHash64 is the low 32 bits of the xxHash64 of the record, it's faster than CRC32C on CPUs without CRC
+	// instructions
+	ChecksumXXHash64
+)
+
+// checksum returns the algorithm of the checksums of new data files. Data files with CRC32C or xxHash64 checksums
+// can't be opened by older versions of kvdb
+func (opts *Options) checksum() (record.Checksum, error) {
+	switch opts.Checksum {
+	case 0, ChecksumCRC32:
+		return record.ChecksumCRC32, nil
+	case ChecksumCRC32C:
+		return record.ChecksumCRC32C, nil
+	case ChecksumXXHash64:
+		return record.ChecksumXXHash64, nil
+	}
+	return 0, fmt.Errorf("unknown checksum algorithm %d", opts.Checksum)
+}
+
+// keyring returns the keys of encrypted data files, nil if no key was given. The key file is read from fs
+func (opts *Options) keyring(fs afero.Fs) (*record.Keyring, error) {
+	key := opts.EncryptionKey
+	if key == nil && opts.EncryptionKeyFile != "" {
+		contents, err := afero.ReadFile(fs, opts.EncryptionKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("could not read the encryption key file: %w", err)
+		}
+		key = bytes.TrimRight(contents, "\r\n")
+		if decoded, err := hex.DecodeString(string(key)); err == nil {
+			key = decoded
+		}
+	}
+	if key == nil && len(opts.PreviousEncryptionKeys) == 0 {
+		return nil, nil
+	}
+	return record.NewKeyring(key, opts.PreviousEncryptionKeys...)
+}
+
+// fileIdAllocator returns the allocator of the ids of new data files
+func (opts *Options) fileIdAllocator() FileIdAllocator {
+	if opts.FileIdAllocator == nil {
+		return NewCounterAllocator()
+	}
+	return opts.FileIdAllocator
+}
+
+// DefaultOptions returns the options used by Create and Open
+func DefaultOptions() Options {
+	return Options{}
+}
+
+// openFileExtra returns the number of files a merge reads at the same time, in addition to the reserved files of the open
+// file budget
+func (opts *Options) openFileExtra() int {
+	return max(opts.MergeParallelism, 1) - 1
+}
+
+// checkMaxOpenFiles returns an error if the open file budget is too small for the datastore
+func (opts *Options) checkMaxOpenFiles() error {
+	if minimum := filemanager.MinOpenFiles(opts.openFileExtra()); opts.MaxOpenFiles < 0 || (opts.MaxOpenFiles != 0 && opts.MaxOpenFiles < minimum) {
+		return fmt.Errorf("invalid open file budget %d, it must be at least %d", opts.MaxOpenFiles, minimum)
+	}
+	return nil
+}
+
+// orDefault returns a copy of the options, or the default options if opts is nil
+func (opts *Options) orDefault() Options {
+	if opts == nil {
+		return DefaultOptions()
+	}
+	return *opts
+}
@@ -0,0 +1,70 @@
+package kvdb
+
+import (
+	"cmp"
+
+	"github.com/ananthvk/kvdb/internal/datafile"
+	"github.com/ananthvk/kvdb/internal/filemanager"
+	"github.com/ananthvk/kvdb/internal/keydir"
+	"github.com/ananthvk/kvdb/internal/record"
+)
+
+/*
+Reclaimable bytes
+
+Every overwrite and delete leaves a stale record (and a tombstone) behind in the data files, which only a merge frees.
+The keydir keeps a count of the bytes taken by the records it points to in every data file, which it updates as keys
+are added, replaced, deleted and moved by merges. The file manager tracks the size of every data file, so the dead bytes
+of a file (stale records and tombstones) are it's size, less the file header and it's live bytes, without reading the
+data files or scanning the keydir.
+
+ReclaimableBytes is the sum of the dead bytes of the immutable files, i.e. what a merge started now would free (a merge
+does not touch the active file). It's exact, except that a merge that changes the record format also shrinks (or
+grows) the live records it rewrites, EstimateMerge accounts for that. Between the time the files of a merge are moved
+into the data directory and the time the keydir is updated, the new files are briefly counted as dead
+*/
+
+// setupLiveBytes makes the keydir count the live bytes of every data file. The size of a record depends on the format
+// of it's file, the format of each file is looked up once
+func setupLiveBytes(fm *filemanager.FileManager, kd *keydir.Keydir) {
+	// Only used by the keydir, with the lock of the datastore held
+	formats := map[int]record.Format{}
+	kd.TrackLiveBytes(func(fileId int, keySize int, valueSize uint32) int64 {
+		format, ok := formats[fileId]
+		if !ok {
+			format, _ = fm.DataFileFormat(fileId)
+			format = cmp.Or(format, record.FormatV1)
+			formats[fileId] = format
+		}
+		return format.EncodedSize(uint32(keySize), valueSize)
+	})
+}
+
+// ReclaimableBytes returns the number of bytes taken by stale records and tombstones in the immutable data files, that a
+// merge would free. It's kept up to date as keys are written, so it's cheap to call
+func (dataStore *DataStore) ReclaimableBytes() int64 {
+	if !dataStore.opened() {
+		return 0
+	}
+	activeId := dataStore.fileManager.GetActiveFileId()
+	var reclaimable int64
+	for id, dead := range dataStore.deadBytes() {
+		if id != activeId {
+			reclaimable += dead
+		}
+	}
+	return reclaimable
+}
+
+// deadBytes returns the number of bytes taken by stale records and tombstones in every data file, by file id
+func (dataStore *DataStore) deadBytes() map[int]int64 {
+	dataStore.mu.RLock()
+	sizes := dataStore.fileManager.DataFileSizes()
+	live := dataStore.keydir.LiveBytes()
+	dataStore.mu.RUnlock()
+	dead := make(map[int]int64, len(sizes))
+	for id, size := range sizes {
+		dead[id] = max(size-datafile.FileHeaderSize-live[id], 0)
+	}
+	return dead
+}
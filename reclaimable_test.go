@@ -0,0 +1,68 @@
+package kvdb
+
+import (
+	"testing"
+
+	"github.com/spf13/afero"
+)
+
+// reclaimableFromFileStats returns the garbage bytes of the immutable files, counted by scanning the keydir
+func reclaimableFromFileStats(t *testing.T, store *DataStore) int64 {
+	t.Helper()
+	fileStats, err := store.FileStats()
+	if err != nil {
+		t.Fatalf("file stats failed: %v", err)
+	}
+	var garbage int64
+	for _, stat := range fileStats {
+		if !stat.Active {
+			garbage += stat.GarbageBytes()
+		}
+	}
+	return garbage
+}
+
+func TestReclaimableBytes(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	store := helperCreateMultipleDataFiles(t, fs, "test_reclaimable.db")
+	store.Put([]byte("key1"), []byte("value1"))
+	store.Put([]byte("key2"), []byte("value2"))
+	store.Put([]byte("key3"), []byte("value3"))
+	store.Close()
+	store, err := Open(fs, "test_reclaimable.db")
+	if err != nil {
+		t.Fatalf("failed to reopen store: %v", err)
+	}
+	defer store.Close()
+	// Counted from the keydir that was read when opening
+	if got, want := store.ReclaimableBytes(), reclaimableFromFileStats(t, store); got != want {
+		t.Errorf("expected %d reclaimable bytes after opening, got %d", want, got)
+	}
+
+	before := store.ReclaimableBytes()
+	store.Put([]byte("key1"), []byte("updated"))
+	store.Delete([]byte("key2"))
+	store.Put([]byte("key4"), []byte("value4"))
+	got := store.ReclaimableBytes()
+	if got <= before {
+		t.Errorf("expected the overwrite and delete to add reclaimable bytes, got %d then %d", before, got)
+	}
+	if want := reclaimableFromFileStats(t, store); got != want {
+		t.Errorf("expected %d reclaimable bytes, got %d", want, got)
+	}
+	if stats, _ := store.Stats(); stats.ReclaimableBytes != got {
+		t.Errorf("expected Stats.ReclaimableBytes %d, got %d", got, stats.ReclaimableBytes)
+	}
+
+	if err := store.Merge(); err != nil {
+		t.Fatalf("merge failed: %v", err)
+	}
+	if got := store.ReclaimableBytes(); got != 0 {
+		t.Errorf("expected no reclaimable bytes after a merge, got %d", got)
+	}
+	// Overwriting a key in the merged file makes it's record stale
+	store.Put([]byte("key3"), []byte("updated"))
+	if got, want := store.ReclaimableBytes(), reclaimableFromFileStats(t, store); got == 0 || got != want {
+		t.Errorf("expected %d reclaimable bytes after the merge, got %d", want, got)
+	}
+}
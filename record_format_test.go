@@ -0,0 +1,152 @@
+package kvdb
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/ananthvk/kvdb/internal/record"
+	"github.com/spf13/afero"
+)
+
+// helperCheckFormats checks that every data file of the store has records in the given format
+func helperCheckFormats(t *testing.T, store *DataStore, ids []int, format record.Format) {
+	t.Helper()
+	for _, id := range ids {
+		got, err := store.fileManager.DataFileFormat(id)
+		if err != nil {
+			t.Fatalf("failed to read the format of file %d: %v", id, err)
+		}
+		if got != format {
+			t.Errorf("expected file %d to have v%d records, got v%d", id, format, got)
+		}
+	}
+}
+
+func helperCheckValues(t *testing.T, store *DataStore, expected map[string]string) {
+	t.Helper()
+	for key, value := range expected {
+		got, err := store.Get([]byte(key))
+		if err != nil || string(got) != value {
+			t.Errorf("expected %s=%s, got %q, %v", key, value, got, err)
+		}
+	}
+}
+
+func TestRecordFormatV2(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	path := "test_record_format.db"
+	store, err := Create(fs, path)
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	expected := map[string]string{}
+	for i := range 50 {
+		key, value := fmt.Sprintf("key%d", i), fmt.Sprintf("value%d", i)
+		store.Put([]byte(key), []byte(value))
+		expected[key] = value
+	}
+	store.Close()
+
+	// The v1 file written before is still read, new files get v2 records
+	store, err = OpenWithOptions(fs, path, &Options{RecordFormat: RecordFormatV2})
+	if err != nil {
+		t.Fatalf("failed to reopen store: %v", err)
+	}
+	for i := range 25 {
+		key, value := fmt.Sprintf("key%d", i), fmt.Sprintf("updated%d", i)
+		store.Put([]byte(key), []byte(value))
+		expected[key] = value
+	}
+	store.Delete([]byte("key49"))
+	delete(expected, "key49")
+	helperCheckValues(t, store, expected)
+	helperCheckFormats(t, store, []int{1}, record.FormatV1)
+	helperCheckFormats(t, store, []int{2}, record.FormatV2)
+
+	// key0 was written in both formats, the v2 record is 9 bytes smaller
+	if err := store.Sync(); err != nil {
+		t.Fatalf("sync failed: %v", err)
+	}
+	stats, err := store.FileStats()
+	if err != nil || len(stats) != 2 {
+		t.Fatalf("unexpected file stats %+v, %v", stats, err)
+	}
+	if v1, v2 := stats[0].LiveBytes+stats[0].GarbageBytes(), stats[1].LiveBytes+stats[1].GarbageBytes(); v1 <= v2 {
+		t.Errorf("expected the v2 file to be smaller, got %d and %d bytes", v1, v2)
+	}
+
+	// Merge rewrites the v1 records in v2, a write after reopening starts a new file, so that files 1 and 2 are merged
+	store.Close()
+	store, err = OpenWithOptions(fs, path, &Options{RecordFormat: RecordFormatV2})
+	if err != nil {
+		t.Fatalf("failed to reopen store: %v", err)
+	}
+	store.Put([]byte("key1"), []byte("latest"))
+	expected["key1"] = "latest"
+	estimate, err := store.EstimateMerge()
+	if err != nil {
+		t.Fatalf("estimate failed: %v", err)
+	}
+	if err := store.Merge(); err != nil {
+		t.Fatalf("merge failed: %v", err)
+	}
+	stats, err = store.FileStats()
+	if err != nil {
+		t.Fatalf("file stats failed: %v", err)
+	}
+	var mergedIds []int
+	var mergedBytes int64
+	for _, stat := range stats {
+		if stat.Merged {
+			mergedIds = append(mergedIds, stat.Id)
+			mergedBytes += stat.Size
+			if stat.GarbageBytes() != 0 {
+				t.Errorf("expected no garbage in merged file %+v", stat)
+			}
+		}
+	}
+	if len(mergedIds) == 0 {
+		t.Fatalf("expected merged files in %+v", stats)
+	}
+	helperCheckFormats(t, store, mergedIds, record.FormatV2)
+	if mergedBytes != estimate.OutputBytes {
+		t.Errorf("expected merged files of %d bytes, got %d", estimate.OutputBytes, mergedBytes)
+	}
+	helperCheckValues(t, store, expected)
+	store.Close()
+
+	// The v2 files are read (with and without hint files) when the store is opened with the default format
+	if err := fs.RemoveAll(path + "/hint"); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Mkdir(path+"/hint", 0755); err != nil {
+		t.Fatal(err)
+	}
+	store, err = Open(fs, path)
+	if err != nil {
+		t.Fatalf("failed to reopen store: %v", err)
+	}
+	defer store.Close()
+	helperCheckValues(t, store, expected)
+	if _, err := store.Get([]byte("key49")); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("expected ErrKeyNotFound for a deleted key, got %v", err)
+	}
+	store.Put([]byte("key0"), []byte("v1 again"))
+	helperCheckFormats(t, store, []int{store.fileManager.GetActiveFileId()}, record.FormatV1)
+}
+
+func TestRecordFormatInvalid(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	if _, err := CreateWithOptions(fs, "test_record_format_invalid.db", &Options{RecordFormat: 3}); err == nil {
+		t.Errorf("expected an error for an unknown record format")
+	}
+	store, err := Create(fs, "test_record_format_invalid.db")
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	store.Close()
+	if _, err := OpenWithOptions(fs, "test_record_format_invalid.db", &Options{RecordFormat: -1}); err == nil {
+		t.Errorf("expected an error for an unknown record format")
+	}
+}
@@ -0,0 +1,321 @@
+package kvdb
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/ananthvk/kvdb/internal/datafile"
+	"github.com/ananthvk/kvdb/internal/filemanager"
+	"github.com/ananthvk/kvdb/internal/hintfile"
+	"github.com/ananthvk/kvdb/internal/metafile"
+	"github.com/ananthvk/kvdb/internal/record"
+	"github.com/ananthvk/kvdb/internal/utils"
+	"github.com/spf13/afero"
+)
+
+/*
+Repair
+
+Repair reads every record of every data file, and checks it's CRC. Files in which every record is good are left as they
+are. A damaged file is rewritten: the good records are copied (with their original timestamps, tombstones included)
+into a new file, which then replaces the damaged file, so that the file keeps it's id and the order of writes across
+files is unchanged.
+
+A record whose CRC does not match is skipped using the sizes in it's header, and reading continues with the next
+record. If a header can't be read (the file ends in the middle of a record, or the sizes in the header are invalid),
+the record boundaries after it are unknown, so the rest of the file is dropped as a damaged tail. A file whose file
+header is invalid is renamed to <name>.corrupt, which is ignored when the datastore is opened.
+
+Hint files are rebuilt from the good records of every data file that has one, since a hint file that describes a
+damaged (or rewritten) data file would be rejected, or worse, would point to the wrong records. Hint files are only
+written for files that contain no tombstones, the hint file of any other file is removed. Data files without a hint
+file do not get one.
+*/
+
+const (
+	repairPrefix        = "repair"
+	corruptedFileSuffix = ".corrupt"
+)
+
+// RepairReport describes what Repair changed
+type RepairReport struct {
+	// Data files that had damaged records, in file id order
+	Files []RepairedFile
+	// Ids of the data files whose hint files were rebuilt, and whose hint files were removed
+	RebuiltHints []int
+	RemovedHints []int
+}
+
+// RepairedFile describes the records dropped from a damaged data file
+type RepairedFile struct {
+	Id int
+	// Number of records copied to the repaired file
+	KeptRecords int
+	// Number of records (and their total size in bytes) that were dropped because their CRC did not match
+	DroppedRecords int
+	DroppedBytes   int64
+	// Number of bytes at the end of the file that were dropped because they could not be read as records
+	DroppedTailBytes int64
+	// Error that caused the tail to be dropped, nil if DroppedTailBytes is 0
+	TailError error
+	// true if the file header could not be read. The file is renamed to <name>.corrupt, and no records are kept
+	InvalidHeader bool
+}
+
+// Damaged returns true if anything was dropped from the file
+func (f RepairedFile) Damaged() bool {
+	return f.InvalidHeader || f.DroppedRecords > 0 || f.DroppedTailBytes > 0
+}
+
+// Repair checks every data file of the datastore at path, drops the damaged records, and rebuilds the hint files. It
+// must not be called while the datastore is open (in this or in any other process). The returned report lists what was
+// dropped. An error is returned if the datastore does not exist, or if a file could not be read or written, in which
+// case the files that were repaired before the error are listed in the report
+func Repair(fs afero.Fs, path string) (*RepairReport, error) {
+	exists, err := metafile.IsDatastore(fs, path)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, ErrNotExist
+	}
+	metainfo, err := metafile.ReadMetaFile(fs, path)
+	if err != nil {
+		return nil, err
+	}
+	layout := layoutOf(path, metainfo)
+	fm, err := filemanager.NewFileManagerWithLayout(fs, layout, metainfo.MaxDatafileSize, filemanager.NewCounterAllocator())
+	if err != nil {
+		return nil, err
+	}
+	ids, err := fm.DataFileIds()
+	if err != nil {
+		return nil, err
+	}
+
+	report := &RepairReport{}
+	for _, id := range ids {
+		if err := repairFile(fs, layout, id, fm.HasHintFile(id), limitsOf(metainfo), report); err != nil {
+			return report, fmt.Errorf("repair data file %d: %w", id, err)
+		}
+	}
+	return report, nil
+}
+
+// repairedRecord is the position of a good record in the data file being repaired
+type repairedRecord struct {
+	offset int64
+	put    bool
+}
+
+func repairFile(fs afero.Fs, layout filemanager.Layout, id int, hasHint bool, limits record.Limits, report *RepairReport) error {
+	dataFilePath := layout.DataFilePath(fs, id)
+	hintFilePath := layout.HintPath(utils.GetHintFileName(id))
+
+	header, err := datafile.ReadFileHeader(fs, dataFilePath)
+	if err != nil {
+		report.Files = append(report.Files, RepairedFile{Id: id, InvalidHeader: true})
+		if hasHint {
+			if err := fs.Remove(hintFilePath); err != nil {
+				return err
+			}
+			report.RemovedHints = append(report.RemovedHints, id)
+		}
+		return fs.Rename(dataFilePath, dataFilePath+corruptedFileSuffix)
+	}
+	// Without the keys, every record of an encrypted file would look damaged
+	if header.Encoding().Cipher != datafile.CipherNone {
+		return fmt.Errorf("encrypted data files can't be repaired: %w", ErrNoEncryptionKey)
+	}
+
+	good, result, err := checkRecords(fs, dataFilePath, id, limits)
+	if err != nil {
+		return err
+	}
+	allPuts := true
+	for _, rec := range good {
+		allPuts = allPuts && rec.put
+	}
+	// The hint files of log files (see GenerateHints) are not rebuilt, they can be written again once the file is repaired
+	logFile := false
+	if hasHint {
+		if logFile, err = hintfile.IsLogFile(fs, hintFilePath); err != nil {
+			return err
+		}
+	}
+	writeHint := hasHint && allPuts && !logFile
+	if !result.Damaged() && !writeHint {
+		if hasHint {
+			if err := fs.Remove(hintFilePath); err != nil {
+				return err
+			}
+			report.RemovedHints = append(report.RemovedHints, id)
+		}
+		return nil
+	}
+
+	// The new files are written under temporary names, which are ignored when the datastore is opened
+	tempDataPath := filepath.Join(filepath.Dir(dataFilePath), fmt.Sprintf("%s-%d", repairPrefix, id))
+	tempHintPath := layout.HintPath(fmt.Sprintf("%s-%d", repairPrefix, id))
+	if err := copyRecords(fs, dataFilePath, tempDataPath, tempHintPath, header, good, result.Damaged(), writeHint, limits); err != nil {
+		fs.Remove(tempDataPath)
+		fs.Remove(tempHintPath)
+		return err
+	}
+
+	// Same order as merge, the hint file is in place before the data file it describes
+	if writeHint {
+		if err := fs.Rename(tempHintPath, hintFilePath); err != nil {
+			return err
+		}
+		report.RebuiltHints = append(report.RebuiltHints, id)
+	} else if hasHint {
+		if err := fs.Remove(hintFilePath); err != nil {
+			return err
+		}
+		report.RemovedHints = append(report.RemovedHints, id)
+	}
+	if result.Damaged() {
+		if err := fs.Rename(tempDataPath, dataFilePath); err != nil {
+			return err
+		}
+		report.Files = append(report.Files, result)
+	}
+	return nil
+}
+
+// checkRecords reads every record of the data file, and returns the good records, and what was dropped
+func checkRecords(fs afero.Fs, dataFilePath string, id int, limits record.Limits) ([]repairedRecord, RepairedFile, error) {
+	result := RepairedFile{Id: id}
+	info, err := fs.Stat(dataFilePath)
+	if err != nil {
+		return nil, result, err
+	}
+	dataSize := info.Size() - datafile.FileHeaderSize
+
+	reader, err := record.NewReader(fs, dataFilePath)
+	if err != nil {
+		return nil, result, err
+	}
+	defer reader.Close()
+	reader.SetLimits(limits)
+
+	var good []repairedRecord
+	var offset int64
+	for offset < dataSize {
+		rec, err := reader.ReadKeyAt(offset)
+		if err == nil && offset+rec.Size > dataSize {
+			err = errors.New("record extends past the end of the file")
+		}
+		if err != nil {
+			result.DroppedTailBytes = dataSize - offset
+			result.TailError = fmt.Errorf("record at offset %d: %w", offset, err)
+			break
+		}
+		if _, err := reader.ReadRecordAtStrict(offset); err != nil {
+			if !errors.Is(err, record.ErrCrcChecksumMismatch) {
+				return nil, result, err
+			}
+			result.DroppedRecords++
+			result.DroppedBytes += rec.Size
+		} else {
+			good = append(good, repairedRecord{offset: offset, put: record.IsPut(rec.Header.RecordType)})
+			result.KeptRecords++
+		}
+		offset += rec.Size
+	}
+	return good, result, nil
+}
+
+// copyRecords writes the good records to a new data file (if the data file is damaged), and their hints to a new hint
+// file (if writeHint is true)
+func copyRecords(fs afero.Fs, dataFilePath, tempDataPath, tempHintPath string, header *datafile.FileHeader, good []repairedRecord, rewriteData, writeHint bool, limits record.Limits) error {
+	reader, err := record.NewReader(fs, dataFilePath)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+	reader.SetLimits(limits)
+
+	// Remove the files left behind by an earlier repair that failed
+	for _, tempPath := range []string{tempDataPath, tempHintPath} {
+		if err := fs.Remove(tempPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+
+	var dataWriter *record.Writer
+	var hintWriter *hintfile.Writer
+	defer func() {
+		if dataWriter != nil {
+			dataWriter.Close()
+		}
+		if hintWriter != nil {
+			hintWriter.Close()
+		}
+	}()
+	if rewriteData {
+		if err := datafile.WriteFileHeaderWithEncoding(fs, tempDataPath, header.Timestamp, header.Encoding()); err != nil {
+			return err
+		}
+		if dataWriter, err = record.NewBufferedWriter(fs, tempDataPath); err != nil {
+			return err
+		}
+		dataWriter.SetLimits(limits)
+	}
+	if writeHint {
+		if hintWriter, err = hintfile.NewWriter(fs, tempHintPath); err != nil {
+			return err
+		}
+		hintWriter.SetLimits(limits)
+	}
+
+	for _, good := range good {
+		rec, err := reader.ReadRecordAtStrict(good.offset)
+		if err != nil {
+			return err
+		}
+		newPos := good.offset + datafile.FileHeaderSize
+		if dataWriter != nil {
+			if good.put {
+				newPos, err = dataWriter.WriteRecordWithHeader(rec.Header, rec.Key, rec.Value)
+			} else {
+				newPos, err = dataWriter.WriteTombstoneWithTs(rec.Key, rec.Header.Timestamp)
+			}
+			if err != nil {
+				return err
+			}
+		}
+		if hintWriter != nil {
+			err = hintWriter.WriteHintRecord(&hintfile.HintRecord{
+				Timestamp: rec.Header.Timestamp,
+				KeySize:   rec.Header.KeySize,
+				ValueSize: rec.Header.ValueSize,
+				ValuePos:  newPos - datafile.FileHeaderSize,
+				Key:       rec.Key,
+				Immutable: rec.Header.RecordType == record.RecordTypePutImmutable,
+				ValueType: rec.Header.ValueType,
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+	if dataWriter != nil {
+		err := dataWriter.Close()
+		dataWriter = nil
+		if err != nil {
+			return err
+		}
+	}
+	if hintWriter != nil {
+		err := hintWriter.Close()
+		hintWriter = nil
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
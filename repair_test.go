@@ -0,0 +1,160 @@
+package kvdb
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/ananthvk/kvdb/internal/datafile"
+	"github.com/ananthvk/kvdb/internal/record"
+	"github.com/ananthvk/kvdb/internal/utils"
+	"github.com/spf13/afero"
+)
+
+// helperCorruptByte flips the byte at the given offset (from the start of the file) of the data file
+func helperCorruptByte(t *testing.T, fs afero.Fs, path string, id int, offset int64) {
+	t.Helper()
+	file, err := fs.OpenFile(filepath.Join(path, "data", utils.GetDataFileName(id)), os.O_RDWR, 0666)
+	if err != nil {
+		t.Fatalf("could not open data file: %v", err)
+	}
+	defer file.Close()
+	var buf [1]byte
+	if _, err := file.ReadAt(buf[:], offset); err != nil {
+		t.Fatalf("could not read data file: %v", err)
+	}
+	buf[0] ^= 0xFF
+	if _, err := file.WriteAt(buf[:], offset); err != nil {
+		t.Fatalf("could not write data file: %v", err)
+	}
+}
+
+func TestRepairDropsDamagedRecords(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	path := "test_repair.db"
+	store := helperCreateMultipleDataFiles(t, fs, path)
+	store.Put([]byte("key1"), []byte("value1"))
+	store.Put([]byte("key2"), []byte("value2"))
+	store.Put([]byte("key3"), []byte("value3"))
+	store.Close()
+
+	// Corrupt the value of key2, and leave half a record at the end of the file
+	recordSize := record.FormatV1.EncodedSize(4, 6)
+	helperCorruptByte(t, fs, path, 1, datafile.FileHeaderSize+recordSize+recordSize-6)
+	file, _ := fs.OpenFile(filepath.Join(path, "data", utils.GetDataFileName(1)), os.O_APPEND|os.O_WRONLY, 0666)
+	file.Write([]byte("partial"))
+	file.Close()
+
+	report, err := Repair(fs, path)
+	if err != nil {
+		t.Fatalf("repair failed: %v", err)
+	}
+	if len(report.Files) != 1 {
+		t.Fatalf("expected one repaired file, got %+v", report.Files)
+	}
+	repaired := report.Files[0]
+	if repaired.Id != 1 || repaired.KeptRecords != 2 || repaired.DroppedRecords != 1 || repaired.DroppedBytes != recordSize ||
+		repaired.DroppedTailBytes != 7 || repaired.TailError == nil || !repaired.Damaged() {
+		t.Errorf("unexpected repair report: %+v", repaired)
+	}
+
+	store, err = Open(fs, path)
+	if err != nil {
+		t.Fatalf("could not open repaired store: %v", err)
+	}
+	defer store.Close()
+	if report := store.OpenReport(); len(report.InvalidDataFiles) != 0 || len(report.UnknownFiles) != 0 {
+		t.Errorf("expected the repaired store to open cleanly, got %+v", report)
+	}
+	for _, key := range []string{"key1", "key3"} {
+		if value, err := store.Get([]byte(key)); err != nil || string(value) != "value"+key[3:] {
+			t.Errorf("%s: expected value%s, got %q (err: %v)", key, key[3:], value, err)
+		}
+	}
+	if _, err := store.Get([]byte("key2")); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("expected the damaged record to be dropped, got %v", err)
+	}
+}
+
+func TestRepairRebuildsHintFiles(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	path := "test_repair_hints.db"
+	helperCreateMergedStore(t, fs, path)
+
+	// File 3 is the merge output (key1, key2), corrupt the value of key1
+	helperCorruptByte(t, fs, path, 3, datafile.FileHeaderSize+record.FormatV1.EncodedSize(4, 6)-5)
+	report, err := Repair(fs, path)
+	if err != nil {
+		t.Fatalf("repair failed: %v", err)
+	}
+	if len(report.Files) != 1 || report.Files[0].Id != 3 || report.Files[0].DroppedRecords != 1 {
+		t.Errorf("unexpected repaired files: %+v", report.Files)
+	}
+	if !slices.Equal(report.RebuiltHints, []int{3}) || len(report.RemovedHints) != 0 {
+		t.Errorf("expected the hint file of file 3 to be rebuilt, got %+v", report)
+	}
+
+	store, err := Open(fs, path)
+	if err != nil {
+		t.Fatalf("could not open repaired store: %v", err)
+	}
+	defer store.Close()
+	if report := store.OpenReport(); len(report.IgnoredHintFiles) != 0 {
+		t.Errorf("expected the rebuilt hint file to be used, got %+v", report)
+	}
+	if _, err := store.Get([]byte("key1")); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("expected key1 to be dropped, got %v", err)
+	}
+	for _, key := range []string{"key2", "key3"} {
+		if value, err := store.Get([]byte(key)); err != nil || string(value) != "value"+key[3:] {
+			t.Errorf("%s: expected value%s, got %q (err: %v)", key, key[3:], value, err)
+		}
+	}
+}
+
+func TestRepairInvalidFileHeader(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	path := "test_repair_header.db"
+	store := helperCreateMultipleDataFiles(t, fs, path)
+	store.Put([]byte("key1"), []byte("value1"))
+	store.Close()
+	helperCorruptByte(t, fs, path, 1, 1)
+
+	report, err := Repair(fs, path)
+	if err != nil {
+		t.Fatalf("repair failed: %v", err)
+	}
+	if len(report.Files) != 1 || !report.Files[0].InvalidHeader {
+		t.Errorf("expected the file to be reported with an invalid header, got %+v", report.Files)
+	}
+	if exists, _ := afero.Exists(fs, filepath.Join(path, "data", utils.GetDataFileName(1)+".corrupt")); !exists {
+		t.Errorf("expected the file to be renamed")
+	}
+	store, err = Open(fs, path)
+	if err != nil {
+		t.Fatalf("could not open repaired store: %v", err)
+	}
+	defer store.Close()
+	if store.Size() != 0 {
+		t.Errorf("expected no keys, got %d", store.Size())
+	}
+}
+
+func TestRepairUndamagedStore(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	path := "test_repair_clean.db"
+	helperCreateMergedStore(t, fs, path)
+
+	report, err := Repair(fs, path)
+	if err != nil {
+		t.Fatalf("repair failed: %v", err)
+	}
+	if len(report.Files) != 0 {
+		t.Errorf("expected no repaired files, got %+v", report.Files)
+	}
+	if _, err := Repair(fs, "does_not_exist.db"); !errors.Is(err, ErrNotExist) {
+		t.Errorf("expected ErrNotExist, got %v", err)
+	}
+}
@@ -0,0 +1,178 @@
+package kvdb
+
+import (
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/ananthvk/kvdb/internal/datafile"
+	"github.com/ananthvk/kvdb/internal/keydir"
+)
+
+/*
+Retention by age
+
+For telemetry-style data, where old records are not needed anymore, a merge is an expensive way to get rid of them: it
+reads every immutable data file and copies the live records. ExpireDataFiles drops the immutable data files whose
+newest record is older than maxAge instead, without reading them, and removes the keys that point into them from the
+keydir. With Options.Retention, it runs every RetentionInterval (1 minute if not set) until the datastore is closed.
+The active data file is never dropped, so Options.MaxFileAge should be set too, so that the active file is sealed.
+
+Dropping whole files is safe because the timestamps of the records grow with the files: every record of a sealed file
+is older than the records written after it, and a merge only copies records that were written before it started. So
+if a file is dropped, every older version of it's keys (and every record hidden by a tombstone in it) is in a file that
+is dropped as well, and no stale value comes back when the keydir is rebuilt. Writes with explicit timestamps
+(PutWithTimestamp) can break this order. The files whose key and timestamp ranges are not known (adopted files, and
+files that could not be read completely) are not dropped.
+
+The keys whose latest value is in a dropped file are removed from the datastore, and Watch gets a delete event for
+each of them (with WatchEvent.Expired set), like for Delete, so that replicas and watched keys see them go. No
+tombstone is written, the event has the location of the dropped record and a new timestamp. With
+Options.RetentionKeepLatest they are kept: their records are copied (with the same timestamp) to the active file before
+the files are dropped, so that only the values that were overwritten or deleted are lost. Write-once keys are always
+kept this way. ExpireDataFiles holds the merge lock, so it waits for a running merge (or snapshot) to finish
+*/
+
+// defaultRetentionInterval is the time between two checks of Options.Retention if RetentionInterval is not set
+const defaultRetentionInterval = time.Minute
+
+// ExpireResult is what ExpireDataFiles did
+type ExpireResult struct {
+	// Ids of the data files that were dropped
+	RemovedFileIds []int
+	// Number of keys that were removed because their latest value was in a dropped file
+	ExpiredKeys int
+	// Number of keys whose latest value was copied to the active file before it's file was dropped
+	KeptKeys int
+}
+
+// ExpireDataFiles drops the immutable data files whose newest record is older than maxAge, and removes the keys whose
+// latest value is in them (or copies them to the active file, with Options.RetentionKeepLatest), see retention.go
+func (dataStore *DataStore) ExpireDataFiles(maxAge time.Duration) (ExpireResult, error) {
+	if err := dataStore.gate.enter(); err != nil {
+		return ExpireResult{}, err
+	}
+	defer dataStore.gate.exit()
+	dataStore.mergeLock.Lock()
+	defer dataStore.mergeLock.Unlock()
+
+	immutableFiles, err := dataStore.fileManager.GetImmutableFiles()
+	if err != nil {
+		return ExpireResult{}, err
+	}
+	cutoff := time.Now().Add(-maxAge)
+	expiring := map[int]bool{}
+	var result ExpireResult
+	for _, id := range immutableFiles {
+		meta, ok := dataStore.fileManager.FileMeta(id)
+		if ok && meta.Records > 0 && meta.MaxTimestamp.Before(cutoff) {
+			expiring[id] = true
+			result.RemovedFileIds = append(result.RemovedFileIds, id)
+		}
+	}
+	if len(expiring) == 0 {
+		return result, nil
+	}
+
+	dataStore.lockProfiler.Lock(&dataStore.mu, "datastore.expire")
+	var keys []string
+	dataStore.keydir.Range(func(key string, rec keydir.KeydirRecord) bool {
+		if expiring[rec.FileId] {
+			keys = append(keys, key)
+		}
+		return true
+	})
+	// The records are copied first, and the other keys are only removed once every copy is synced, so that a failed
+	// copy leaves the keydir unchanged (except for the keys that were copied)
+	var expired []string
+	for _, key := range keys {
+		rec, _ := dataStore.keydir.GetKeydirRecord([]byte(key))
+		if !dataStore.options.RetentionKeepLatest && !rec.Immutable {
+			expired = append(expired, key)
+			continue
+		}
+		if err := dataStore.keepRecord([]byte(key), rec); err != nil {
+			// The files are not dropped, the keys that were copied have two records with the same timestamp
+			dataStore.mu.Unlock()
+			return ExpireResult{}, err
+		}
+		result.KeptKeys++
+	}
+	if result.KeptKeys > 0 {
+		// The copies must survive a crash before the files with the originals are removed
+		if err := dataStore.fileManager.Sync(); err != nil {
+			dataStore.mu.Unlock()
+			return ExpireResult{}, err
+		}
+	}
+	for _, key := range expired {
+		rec, _ := dataStore.keydir.GetKeydirRecord([]byte(key))
+		ts := dataStore.nextTimestamp([]byte(key))
+		dataStore.keydir.DeleteRecord([]byte(key))
+		result.ExpiredKeys++
+		dataStore.watchers.notify(WatchEvent{Type: WriteTypeDelete, Key: []byte(key), FileId: rec.FileId,
+			Offset: rec.ValuePos, Timestamp: ts, Expired: true})
+	}
+	dataStore.updateMemory()
+	dataStore.mu.Unlock()
+
+	dataStore.fileManager.RemoveDataFiles(result.RemovedFileIds)
+	dataStore.mu.Lock()
+	dataStore.updateStall()
+	dataStore.mu.Unlock()
+	dataStore.markManifestDirty()
+	return result, nil
+}
+
+// keepRecord copies the record of the key to the active file, with the same header, and points the keydir to the
+// copy. The caller must hold the write lock
+func (dataStore *DataStore) keepRecord(key []byte, current keydir.KeydirRecord) error {
+	rec, err := dataStore.fileManager.ReadValueAt(current.FileId, current.ValuePos)
+	if err != nil {
+		return err
+	}
+	fileId, offset, err := dataStore.fileManager.WriteRecordWithHeader(rec.Header, key, rec.Value)
+	if err != nil {
+		return err
+	}
+	dataStore.appendSignal.notify()
+	current.FileId, current.ValuePos = fileId, offset-datafile.FileHeaderSize
+	dataStore.keydir.Add(key, current)
+	return nil
+}
+
+// setupRetention drops the expired data files every RetentionInterval if Options.Retention is set, until the
+// datastore is closed
+func (dataStore *DataStore) setupRetention() {
+	maxAge := dataStore.options.Retention
+	if maxAge <= 0 {
+		return
+	}
+	interval := dataStore.options.RetentionInterval
+	if interval <= 0 {
+		interval = defaultRetentionInterval
+	}
+	done := dataStore.gate.ctx.Done()
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+			result, err := dataStore.ExpireDataFiles(maxAge)
+			if err != nil {
+				if !errors.Is(err, ErrClosed) {
+					slog.Warn("could not drop the expired data files", "path", dataStore.path, "error", err)
+				}
+				continue
+			}
+			if len(result.RemovedFileIds) > 0 {
+				slog.Info("dropped expired data files", "path", dataStore.path, "files", result.RemovedFileIds,
+					"expired_keys", result.ExpiredKeys, "kept_keys", result.KeptKeys)
+			}
+		}
+	}()
+}
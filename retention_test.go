@@ -0,0 +1,145 @@
+package kvdb
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/ananthvk/kvdb/internal/datafile"
+	"github.com/ananthvk/kvdb/internal/utils"
+	"github.com/spf13/afero"
+)
+
+// helperRetentionStore writes a and b (data file 1), c and d (data file 2) two hours ago, and then overwrites b, in a
+// store whose data files have at most 2 records
+func helperRetentionStore(t *testing.T, fs afero.Fs, opts *Options) *DataStore {
+	t.Helper()
+	opts.MaxRecordsPerFile = 2
+	store, err := CreateWithOptions(fs, "retention.db", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := time.Now().Add(-2 * time.Hour)
+	for i, key := range []string{"a", "b", "c", "d"} {
+		if err := store.PutWithTimestamp([]byte(key), []byte("old"), old.Add(time.Duration(i)*time.Millisecond)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	store.Put([]byte("b"), []byte("new"))
+	store.Put([]byte("e"), []byte("new"))
+	return store
+}
+
+func TestExpireDataFiles(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	store := helperRetentionStore(t, fs, &Options{})
+	var deleted []string
+	cancel := store.Watch(func(event WatchEvent) {
+		if event.Type != WriteTypeDelete || !event.Expired || event.FileId > 2 {
+			t.Errorf("unexpected watch event %+v", event)
+		}
+		deleted = append(deleted, string(event.Key))
+	})
+	result, err := store.ExpireDataFiles(time.Hour)
+	if err != nil {
+		t.Fatalf("ExpireDataFiles failed: %v", err)
+	}
+	cancel()
+	if !slices.Equal(result.RemovedFileIds, []int{1, 2}) || result.ExpiredKeys != 3 || result.KeptKeys != 0 {
+		t.Errorf("unexpected result %+v", result)
+	}
+	// Every expired key gets a delete event
+	slices.Sort(deleted)
+	if !slices.Equal(deleted, []string{"a", "c", "d"}) {
+		t.Errorf("expected delete events for the expired keys, got %v", deleted)
+	}
+	check := func(store *DataStore) {
+		t.Helper()
+		for _, key := range []string{"a", "c", "d"} {
+			if _, err := store.Get([]byte(key)); err != ErrKeyNotFound {
+				t.Errorf("%s: expected the key to have expired, got %v", key, err)
+			}
+		}
+		if value, err := store.Get([]byte("b")); err != nil || string(value) != "new" {
+			t.Errorf("b: expected the latest value, got %q, %v", value, err)
+		}
+	}
+	check(store)
+	if result, _ := store.ExpireDataFiles(time.Hour); len(result.RemovedFileIds) != 0 {
+		t.Errorf("expected nothing left to expire, got %+v", result)
+	}
+	store.Close()
+
+	// The old values don't come back when the keydir is rebuilt
+	store, err = Open(fs, "retention.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	check(store)
+}
+
+func TestExpireDataFilesKeepLatest(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	store := helperRetentionStore(t, fs, &Options{RetentionKeepLatest: true})
+	result, err := store.ExpireDataFiles(time.Hour)
+	if err != nil {
+		t.Fatalf("ExpireDataFiles failed: %v", err)
+	}
+	if len(result.RemovedFileIds) != 2 || result.ExpiredKeys != 0 || result.KeptKeys != 3 {
+		t.Errorf("unexpected result %+v", result)
+	}
+	store.Close()
+
+	store, err = Open(fs, "retention.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	for key, want := range map[string]string{"a": "old", "b": "new", "c": "old", "d": "old", "e": "new"} {
+		if value, err := store.Get([]byte(key)); err != nil || string(value) != want {
+			t.Errorf("%s: expected %s, got %q, %v", key, want, value, err)
+		}
+	}
+}
+
+func TestExpireDataFilesKeepFailure(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	store, err := CreateWithOptions(fs, "retention_failure.db", &Options{MaxRecordsPerFile: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	// Data file 1 has a and b, data file 2 has the write-once key (which is kept) and c, e is in the active file
+	store.Put([]byte("a"), []byte("old"))
+	store.Put([]byte("b"), []byte("old"))
+	store.PutWithOptions([]byte("once"), []byte("old"), &PutOptions{WriteOnce: true})
+	store.Put([]byte("c"), []byte("old"))
+	store.Put([]byte("e"), []byte("new"))
+
+	// The records of data file 2 can't be read, so the write-once key can't be copied
+	file, err := fs.OpenFile(filepath.Join("retention_failure.db", "data", utils.GetDataFileName(2)), os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.Truncate(datafile.FileHeaderSize)
+	file.Close()
+
+	time.Sleep(time.Millisecond)
+	if _, err := store.ExpireDataFiles(time.Nanosecond); err == nil {
+		t.Fatal("expected the copy of the write-once key to fail")
+	}
+	// No key was removed, and no file was dropped
+	for _, key := range []string{"a", "b", "c", "once"} {
+		if _, ok := store.keydir.GetKeydirRecord([]byte(key)); !ok {
+			t.Errorf("%s: expected the key to be kept after the failure", key)
+		}
+	}
+	for _, key := range []string{"a", "b"} {
+		if value, err := store.Get([]byte(key)); err != nil || string(value) != "old" {
+			t.Errorf("%s: expected the value to be readable, got %q, %v", key, value, err)
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package kvdb
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/spf13/afero"
+)
+
+func TestReuseLastFile(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	store, err := Create(fs, "reuse.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.Put([]byte("key0"), []byte("value0"))
+	store.Close()
+
+	opts := &Options{ReuseLastFile: true}
+	for i := 1; i <= 3; i++ {
+		store, err := OpenWithOptions(fs, "reuse.db", opts)
+		if err != nil {
+			t.Fatalf("failed to open the store: %v", err)
+		}
+		if report := store.OpenReport(); report.ReusedDataFile != 1 {
+			t.Errorf("expected the data file 1 to be reused, got %d", report.ReusedDataFile)
+		}
+		if err := store.Put(fmt.Appendf(nil, "key%d", i), fmt.Appendf(nil, "value%d", i)); err != nil {
+			t.Fatalf("put failed: %v", err)
+		}
+		store.Close()
+	}
+	store, err = Open(fs, "reuse.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stats, _ := store.Stats()
+	if stats.DataFiles != 1 {
+		t.Errorf("expected the writes of every open to be in 1 data file, got %d", stats.DataFiles)
+	}
+	for i := range 4 {
+		if value, err := store.Get(fmt.Appendf(nil, "key%d", i)); err != nil || string(value) != fmt.Sprintf("value%d", i) {
+			t.Errorf("key%d: expected value%d, got %q, %v", i, i, value, err)
+		}
+	}
+	store.Put([]byte("key4"), []byte("value4"))
+	store.Close()
+
+	// A file that ends with a partial record is not appended to
+	file, _ := fs.OpenFile(filepath.Join("reuse.db", "data", "0000000002.dat"), os.O_APPEND|os.O_WRONLY, 0)
+	file.Write([]byte{1, 2, 3})
+	file.Close()
+	store, err = OpenWithOptions(fs, "reuse.db", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report := store.OpenReport(); report.ReusedDataFile != 0 {
+		t.Errorf("expected a damaged data file not to be reused, got %d", report.ReusedDataFile)
+	}
+	store.Put([]byte("key5"), []byte("value5"))
+	if id := store.fileManager.GetActiveFileId(); id != 3 {
+		t.Errorf("expected writes to go to a new data file, got %d", id)
+	}
+	store.Close()
+
+	// Neither is a file with a different record format
+	store, err = OpenWithOptions(fs, "reuse.db", &Options{ReuseLastFile: true, RecordFormat: RecordFormatV2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report := store.OpenReport(); report.ReusedDataFile != 0 {
+		t.Errorf("expected a data file with another format not to be reused, got %d", report.ReusedDataFile)
+	}
+	store.Close()
+}
+
+func TestReuseLastFileRotationLimits(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	opts := &Options{ReuseLastFile: true, MaxRecordsPerFile: 3}
+	store, err := CreateWithOptions(fs, "limits.db", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.Put([]byte("key0"), []byte("value0"))
+	store.Put([]byte("key1"), []byte("value1"))
+	store.Close()
+
+	// The records already in the reused file count towards the limit
+	store, err = OpenWithOptions(fs, "limits.db", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	store.Put([]byte("key2"), []byte("value2"))
+	store.Put([]byte("key3"), []byte("value3"))
+	if id := store.fileManager.GetActiveFileId(); id != 2 {
+		t.Errorf("expected the fourth record to be in data file 2, got %d", id)
+	}
+}
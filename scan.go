@@ -0,0 +1,281 @@
+package kvdb
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"sort"
+	"time"
+
+	"github.com/ananthvk/kvdb/internal/datafile"
+	"github.com/ananthvk/kvdb/internal/keydir"
+	"github.com/ananthvk/kvdb/internal/record"
+)
+
+/*
+Filtered scans
+
+Scan visits the keys of the datastore that match a ScanFilter, in ascending key order. The filter is evaluated against
+the keydir entry of every key (the timestamp of it's value, the size of the value, and whether it's write-once) before
+anything is read from the data files, so a scan that matches a few keys only reads the values of those keys, and a scan
+with KeysOnly reads no values at all.
+
+Deleted keys are not in the keydir. With ScanTombstones, the data files are read for the tombstones of keys that are
+not live anymore, and each deleted key is visited once, with the timestamp of it's latest tombstone. That reads every
+data file, and a tombstone is only found while it's data file has not been merged (a merge drops the tombstones of the
+files it merges), so it's meant for things like finding the keys deleted since a recent point in time.
+
+The keys are matched (and the tombstones found) when the scan starts, writes made during the scan are not visited, but
+a key that is overwritten or deleted during the scan is visited with the value it had when the scan started. Merges
+wait for the scan to finish, since they would remove the data files of the values that are still to be read.
+
+The file manager knows the range of keys and timestamps in every data file (see internal/filemanager/file_meta.go), so
+the tombstone scan skips the files that can't have a tombstone in the key range (Prefix, StartKey, EndKey) and the time
+range of the filter, which are most of them when the store is merged and the keys are clustered
+*/
+
+// ScanTypes is a set of kinds of keys visited by Scan
+type ScanTypes uint8
+
+const (
+	// ScanPuts are keys written with Put (and the other write methods)
+	ScanPuts ScanTypes = 1 << iota
+	// ScanWriteOnce are write-once keys, see PutOptions.WriteOnce
+	ScanWriteOnce
+	// ScanTombstones are deleted keys whose tombstone is still in the data files
+	ScanTombstones
+
+	// ScanLive is every key that exists, the default
+	ScanLive = ScanPuts | ScanWriteOnce
+)
+
+// ScanFilter selects the keys visited by Scan, the zero value matches every live key
+type ScanFilter struct {
+	// Only keys that start with Prefix, and keys from StartKey (inclusive) to EndKey (exclusive), if they are not empty
+	Prefix   []byte
+	StartKey []byte
+	EndKey   []byte
+	// Only keys written after ModifiedAfter, and before ModifiedBefore, if they are not zero
+	ModifiedAfter  time.Time
+	ModifiedBefore time.Time
+	// Only values of at least MinValueSize bytes, and of at most MaxValueSize bytes if it's not zero. Tombstones have no
+	// value, these do not apply to them
+	MinValueSize uint32
+	MaxValueSize uint32
+	// Kinds of keys to visit, ScanLive if it's zero
+	Types ScanTypes
+	// KeysOnly skips reading the values, ScanEntry.Value is nil
+	KeysOnly bool
+}
+
+// ScanEntry is a key visited by Scan
+type ScanEntry struct {
+	Key []byte
+	// Value is nil for tombstones, and with ScanFilter.KeysOnly
+	Value     []byte
+	ValueSize uint32
+	// WriteTypeDelete for tombstones
+	Type      WriteType
+	WriteOnce bool
+	// Time at which the value (or the tombstone) was written
+	Timestamp time.Time
+}
+
+func (f *ScanFilter) types() ScanTypes {
+	if f.Types == 0 {
+		return ScanLive
+	}
+	return f.Types
+}
+
+// keyRange returns the range of keys [start, end) matched by the filter, an empty end has no upper bound
+func (f *ScanFilter) keyRange() ([]byte, []byte) {
+	start, end := f.StartKey, f.EndKey
+	if len(f.Prefix) == 0 {
+		return start, end
+	}
+	if bytes.Compare(f.Prefix, start) > 0 {
+		start = f.Prefix
+	}
+	if prefixEnd := prefixSuccessor(f.Prefix); prefixEnd != nil && (len(end) == 0 || bytes.Compare(prefixEnd, end) < 0) {
+		end = prefixEnd
+	}
+	return start, end
+}
+
+// prefixSuccessor returns the smallest key that is larger than every key with the prefix, or nil if there is none (the
+// prefix is all 0xff bytes)
+func prefixSuccessor(prefix []byte) []byte {
+	end := bytes.Clone(prefix)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return end[:i+1]
+		}
+	}
+	return nil
+}
+
+// matchKey returns true if the key is within the key range of the filter
+func (f *ScanFilter) matchKey(key []byte) bool {
+	start, end := f.keyRange()
+	if bytes.Compare(key, start) < 0 {
+		return false
+	}
+	return (len(end) == 0 || bytes.Compare(key, end) < 0) && bytes.HasPrefix(key, f.Prefix)
+}
+
+// matchTime returns true if the timestamp is within the time range of the filter
+func (f *ScanFilter) matchTime(ts time.Time) bool {
+	if !f.ModifiedAfter.IsZero() && !ts.After(f.ModifiedAfter) {
+		return false
+	}
+	return f.ModifiedBefore.IsZero() || ts.Before(f.ModifiedBefore)
+}
+
+// matchLive returns true if the keydir entry of a live key matches the filter
+func (f *ScanFilter) matchLive(rec keydir.KeydirRecord) bool {
+	kind := ScanPuts
+	if rec.Immutable {
+		kind = ScanWriteOnce
+	}
+	if f.types()&kind == 0 || !f.matchTime(rec.Timestamp) || rec.ValueSize < f.MinValueSize {
+		return false
+	}
+	return f.MaxValueSize == 0 || rec.ValueSize <= f.MaxValueSize
+}
+
+// Scan calls fn with every key that matches the filter (every live key if filter is nil) in ascending key order, until
+// fn returns an error, which is returned. The entry is only valid until fn returns. See scan.go
+func (dataStore *DataStore) Scan(filter *ScanFilter, fn func(entry ScanEntry) error) error {
+	if err := dataStore.gate.enter(); err != nil {
+		return err
+	}
+	defer dataStore.gate.exit()
+	var f ScanFilter
+	if filter != nil {
+		f = *filter
+	}
+
+	// Prevent merge from deleting the files of the values that are still to be read
+	dataStore.mergeLock.Lock()
+	defer dataStore.mergeLock.Unlock()
+
+	live := map[string]keydir.KeydirRecord{}
+	if f.types()&ScanLive != 0 {
+		dataStore.mu.RLock()
+		dataStore.keydir.Range(func(key string, rec keydir.KeydirRecord) bool {
+			if f.matchLive(rec) && f.matchKey([]byte(key)) {
+				live[key] = rec
+			}
+			return true
+		})
+		dataStore.mu.RUnlock()
+	}
+	var tombstones map[string]time.Time
+	if f.types()&ScanTombstones != 0 {
+		var err error
+		if tombstones, err = dataStore.scanTombstones(&f); err != nil {
+			return err
+		}
+	}
+
+	keys := make([]string, 0, len(live)+len(tombstones))
+	for key := range live {
+		keys = append(keys, key)
+	}
+	for key := range tombstones {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		entry := ScanEntry{Key: []byte(key)}
+		if rec, ok := live[key]; ok {
+			entry.ValueSize, entry.WriteOnce, entry.Timestamp = rec.ValueSize, rec.Immutable, rec.Timestamp
+			if !f.KeysOnly {
+				value, err := dataStore.fileManager.ReadValueAt(rec.FileId, rec.ValuePos)
+				if err != nil {
+					return err
+				}
+				entry.Value = value.Value
+			}
+		} else {
+			entry.Type, entry.Timestamp = WriteTypeDelete, tombstones[key]
+		}
+		if err := fn(entry); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// scanTombstones reads every data file, and returns the keys that are not live, with the timestamp of their latest
+// tombstone if it matches the time range of the filter. The caller must hold the merge lock
+func (dataStore *DataStore) scanTombstones(f *ScanFilter) (map[string]time.Time, error) {
+	// Only the records that were complete when the scan started are read from the active file, they must be in the file
+	dataStore.mu.RLock()
+	sizes := dataStore.fileManager.DataFileSizes()
+	err := dataStore.fileManager.Flush()
+	dataStore.mu.RUnlock()
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]int, 0, len(sizes))
+	for id := range sizes {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+
+	start, end := f.keyRange()
+	latest := map[string]time.Time{}
+	for _, id := range ids {
+		// Skip the files that can't have a matching tombstone
+		if meta, ok := dataStore.fileManager.FileMeta(id); ok && (!meta.HasKeysIn(start, end) || !meta.HasTimestampsIn(f.ModifiedAfter, f.ModifiedBefore)) {
+			dataStore.counters.scanSkippedFiles.Add(1)
+			continue
+		}
+		if err := dataStore.scanFileTombstones(id, sizes[id]-datafile.FileHeaderSize, latest); err != nil {
+			return nil, err
+		}
+	}
+
+	tombstones := map[string]time.Time{}
+	dataStore.mu.RLock()
+	defer dataStore.mu.RUnlock()
+	for key, ts := range latest {
+		if _, exists := dataStore.keydir.GetKeydirRecord([]byte(key)); !exists && f.matchTime(ts) && f.matchKey([]byte(key)) {
+			tombstones[key] = ts
+		}
+	}
+	return tombstones, nil
+}
+
+// scanFileTombstones records the timestamp of the tombstones in the first size bytes of records of the data file, if
+// they are newer than the ones in latest
+func (dataStore *DataStore) scanFileTombstones(id int, size int64, latest map[string]time.Time) error {
+	scanner, err := record.NewScanner(dataStore.fs, dataStore.fileManager.DataFilePath(id))
+	if err != nil {
+		return err
+	}
+	defer scanner.Close()
+	scanner.SetLimits(limitsOf(dataStore.metaInfo))
+	scanner.SetKeyring(dataStore.fileManager.Keyring())
+	for end := int64(0); end < size; {
+		rec, offset, err := scanner.Scan()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		end = offset + rec.Size
+		if rec.Header.RecordType != record.RecordTypeDelete {
+			continue
+		}
+		if ts, ok := latest[string(rec.Key)]; !ok || rec.Header.Timestamp.After(ts) {
+			latest[string(rec.Key)] = rec.Header.Timestamp
+		}
+	}
+	return nil
+}
@@ -0,0 +1,133 @@
+package kvdb
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/afero"
+)
+
+// helperScan returns the entries visited by Scan, as key=value strings (key=- for tombstones)
+func helperScan(t *testing.T, store *DataStore, filter *ScanFilter) []string {
+	t.Helper()
+	var entries []string
+	err := store.Scan(filter, func(entry ScanEntry) error {
+		value := string(entry.Value)
+		if entry.Type == WriteTypeDelete {
+			value = "-"
+		}
+		entries = append(entries, string(entry.Key)+"="+value)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("scan failed: %v", err)
+	}
+	return entries
+}
+
+func TestScan(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	store := helperCreateMultipleDataFiles(t, fs, "test_scan.db")
+	defer store.Close()
+	base := time.Now().Add(-time.Hour).Truncate(time.Second)
+	store.PutWithTimestamp([]byte("b"), []byte("small"), base)
+	store.PutWithTimestamp([]byte("a"), []byte("a larger value"), base.Add(time.Minute))
+	store.PutWithTimestamp([]byte("d"), []byte("deleted"), base)
+	store.DeleteWithTimestamp([]byte("d"), base.Add(2*time.Minute))
+	store.PutWithTimestamp([]byte("e"), []byte("deleted"), base)
+	store.DeleteWithTimestamp([]byte("e"), base.Add(time.Second))
+	store.PutWithOptions([]byte("c"), []byte("once"), &PutOptions{WriteOnce: true})
+
+	tests := []struct {
+		name   string
+		filter *ScanFilter
+		want   string
+	}{
+		{"all", nil, "a=a larger value,b=small,c=once"},
+		{"modified after", &ScanFilter{ModifiedAfter: base}, "a=a larger value,c=once"},
+		{"modified before", &ScanFilter{ModifiedBefore: base.Add(time.Minute)}, "b=small"},
+		{"value size", &ScanFilter{MinValueSize: 5, MaxValueSize: 5}, "b=small"},
+		{"write-once", &ScanFilter{Types: ScanWriteOnce}, "c=once"},
+		{"tombstones", &ScanFilter{Types: ScanTombstones}, "d=-,e=-"},
+		{"tombstones after", &ScanFilter{Types: ScanPuts | ScanTombstones, ModifiedAfter: base.Add(time.Second)}, "a=a larger value,d=-"},
+	}
+	for _, test := range tests {
+		if got := strings.Join(helperScan(t, store, test.filter), ","); got != test.want {
+			t.Errorf("%s: expected %q, got %q", test.name, test.want, got)
+		}
+	}
+
+	// A key that was written again after it's tombstone is live
+	store.Put([]byte("e"), []byte("back"))
+	if got := strings.Join(helperScan(t, store, &ScanFilter{Types: ScanTombstones}), ","); got != "d=-" {
+		t.Errorf("expected only d to be deleted, got %q", got)
+	}
+
+	var sizes []uint32
+	store.Scan(&ScanFilter{KeysOnly: true}, func(entry ScanEntry) error {
+		if entry.Value != nil {
+			t.Errorf("expected no value with KeysOnly, got %q", entry.Value)
+		}
+		sizes = append(sizes, entry.ValueSize)
+		return nil
+	})
+	if len(sizes) != 4 || sizes[0] != uint32(len("a larger value")) {
+		t.Errorf("expected the value sizes of 4 keys, got %v", sizes)
+	}
+
+	stop := errors.New("stop")
+	visited := 0
+	err := store.Scan(nil, func(entry ScanEntry) error {
+		visited++
+		return stop
+	})
+	if !errors.Is(err, stop) || visited != 1 {
+		t.Errorf("expected the scan to stop at the first error, got %v after %d keys", err, visited)
+	}
+}
+
+func TestScanKeyRange(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	store := helperCreateMultipleDataFiles(t, fs, "test_scan_key_range.db")
+	for _, key := range []string{"a1", "a2", "b1", "b2"} {
+		store.Put([]byte(key), []byte("value"))
+	}
+	store.Delete([]byte("a2"))
+
+	// The ranges of the first file are collected when it's read on open
+	store.Close()
+	store, err := Open(fs, "test_scan_key_range.db")
+	if err != nil {
+		t.Fatalf("failed to reopen store: %v", err)
+	}
+	defer store.Close()
+	store.Put([]byte("c1"), []byte("value"))
+	store.Delete([]byte("c1"))
+
+	tests := []struct {
+		name   string
+		filter *ScanFilter
+		want   string
+	}{
+		{"prefix", &ScanFilter{Prefix: []byte("b")}, "b1=value,b2=value"},
+		{"range", &ScanFilter{StartKey: []byte("a2"), EndKey: []byte("b2")}, "b1=value"},
+		{"prefix and range", &ScanFilter{Prefix: []byte("b"), StartKey: []byte("a"), EndKey: []byte("b2")}, "b1=value"},
+		{"tombstones", &ScanFilter{Prefix: []byte("a"), Types: ScanTombstones}, "a2=-"},
+	}
+	for _, test := range tests {
+		if got := strings.Join(helperScan(t, store, test.filter), ","); got != test.want {
+			t.Errorf("%s: expected %q, got %q", test.name, test.want, got)
+		}
+	}
+
+	// Only the active file can have a tombstone of a key that starts with c
+	before, _ := store.Stats()
+	if got := strings.Join(helperScan(t, store, &ScanFilter{Prefix: []byte("c"), Types: ScanTombstones}), ","); got != "c1=-" {
+		t.Errorf("expected c1 to be deleted, got %q", got)
+	}
+	if after, _ := store.Stats(); after.ScanSkippedFiles != before.ScanSkippedFiles+1 {
+		t.Errorf("expected the first data file to be skipped")
+	}
+}
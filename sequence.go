@@ -0,0 +1,55 @@
+package kvdb
+
+import (
+	"time"
+)
+
+/*
+Monotonic record timestamps
+
+The timestamp in the header of every record decides which record of a key wins: when the keydir is built, when a merge
+keeps the latest record, and when a replica applies the records of it's primary in order. Wall-clock time can't be
+trusted for that, two writes can get the same time (within a microsecond, the precision it's stored with), and the
+clock can go back (NTP adjustments, a restored VM), after which a write could lose to an older one.
+
+So the timestamps of new records come from a per-store sequence instead: every write gets the current time, or one
+microsecond after the previous record written by the datastore if the current time is not after it. The sequence never
+goes back and never repeats, and it stays close to wall-clock time, so timestamps still mean when a key was written
+(for Scan, ExportSnapshot and replication lag). When the datastore is opened, the sequence continues from the newest
+record in the data files, so it survives restarts (and a clock that went back while the datastore was closed).
+
+Records with timestamps given by the caller (PutWithTimestamp, replicas, adopted files) keep them, and move the sequence
+forward if they are newer. The sequence is kept in the timestamp field instead of a new header field, so the data files
+and hint files stay readable by older versions
+*/
+
+// nextTimestamp returns the timestamp of a new record of the key, the next value of the sequence. It's also after the
+// timestamp of the key's current record, which can be newer than the sequence if it was adopted. The caller must hold
+// the write lock
+func (dataStore *DataStore) nextTimestamp(key []byte) time.Time {
+	ts := time.UnixMicro(time.Now().UnixMicro())
+	if !ts.After(dataStore.lastTimestamp) {
+		ts = dataStore.lastTimestamp.Add(time.Microsecond)
+	}
+	if rec, ok := dataStore.keydir.GetKeydirRecord(key); ok && !ts.After(rec.Timestamp) {
+		ts = rec.Timestamp.Add(time.Microsecond)
+	}
+	dataStore.lastTimestamp = ts
+	return ts
+}
+
+// observeTimestamp moves the sequence forward to a timestamp that was not given by nextTimestamp, if it's newer. The
+// caller must hold the write lock
+func (dataStore *DataStore) observeTimestamp(ts time.Time) {
+	if ts.After(dataStore.lastTimestamp) {
+		dataStore.lastTimestamp = ts
+	}
+}
+
+// LastTimestamp returns the timestamp of the newest record written (or read when the datastore was opened), the last
+// value of the sequence of record timestamps
+func (dataStore *DataStore) LastTimestamp() time.Time {
+	dataStore.mu.RLock()
+	defer dataStore.mu.RUnlock()
+	return dataStore.lastTimestamp
+}
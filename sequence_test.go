@@ -0,0 +1,54 @@
+package kvdb
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/spf13/afero"
+)
+
+func TestMonotonicTimestamps(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	store := helperCreateMultipleDataFiles(t, fs, "test_monotonic_timestamps.db")
+
+	// Writes within the same microsecond get distinct timestamps
+	var previous time.Time
+	for i := range 1000 {
+		key := []byte(fmt.Sprintf("key%d", i%10))
+		if i%3 == 0 {
+			store.Delete(key)
+		} else {
+			store.Put(key, []byte("value"))
+		}
+		ts := store.LastTimestamp()
+		if !ts.After(previous) {
+			t.Fatalf("expected write %d to have a timestamp after %v, got %v", i, previous, ts)
+		}
+		previous = ts
+	}
+
+	// A record from a clock that is ahead (or a local clock that went back) moves the sequence forward
+	future := time.Now().Add(time.Hour).Truncate(time.Second)
+	store.PutWithTimestamp([]byte("replicated"), []byte("value"), future)
+	store.Put([]byte("other"), []byte("value"))
+	if rec, _ := store.keydir.GetKeydirRecord([]byte("other")); !rec.Timestamp.After(future) {
+		t.Errorf("expected the write to be after %v, got %v", future, rec.Timestamp)
+	}
+
+	// The sequence continues after a restart
+	last := store.LastTimestamp()
+	store.Close()
+	store, err := Open(fs, "test_monotonic_timestamps.db")
+	if err != nil {
+		t.Fatalf("failed to reopen store: %v", err)
+	}
+	defer store.Close()
+	if !store.LastTimestamp().Equal(last) {
+		t.Errorf("expected the sequence to continue from %v, got %v", last, store.LastTimestamp())
+	}
+	store.Delete([]byte("replicated"))
+	if _, err := store.Get([]byte("replicated")); err != ErrKeyNotFound {
+		t.Errorf("expected the delete to win over the record from the future, got %v", err)
+	}
+}
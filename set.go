@@ -0,0 +1,165 @@
+package kvdb
+
+import (
+	"encoding/binary"
+	"slices"
+)
+
+// Set is a set of members stored as the value of a single key, see types.go. It's a handle, nothing is read until a
+// method is called, and the set exists as long as it has a member
+type Set struct {
+	dataStore *DataStore
+	key       []byte
+}
+
+// Set returns the set stored at the key. The methods of the set fail with a *WrongTypeError if the key holds a value of
+// another type
+func (dataStore *DataStore) Set(key []byte) *Set {
+	return &Set{dataStore: dataStore, key: key}
+}
+
+// Key returns the key of the set
+func (s *Set) Key() []byte {
+	return s.key
+}
+
+// Add adds the members to the set with a single write, and returns the number of members that are new
+func (s *Set) Add(members ...[]byte) (int, error) {
+	added := 0
+	err := s.dataStore.updateStructured(s.key, ValueTypeSet, "datastore.set_add", func(encoded []byte, _ bool) ([]byte, error) {
+		current, err := decodeSet(encoded)
+		if err != nil {
+			return nil, err
+		}
+		for _, member := range members {
+			if _, ok := current[string(member)]; !ok {
+				current[string(member)] = struct{}{}
+				added++
+			}
+		}
+		return encodeSet(current), nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return added, nil
+}
+
+// Remove removes the members from the set, and returns the number of members that were removed. The set is deleted
+// when it's last member is removed
+func (s *Set) Remove(members ...[]byte) (int, error) {
+	removed := 0
+	err := s.dataStore.updateStructured(s.key, ValueTypeSet, "datastore.set_remove", func(encoded []byte, exists bool) ([]byte, error) {
+		current, err := decodeSet(encoded)
+		if err != nil {
+			return nil, err
+		}
+		for _, member := range members {
+			if _, ok := current[string(member)]; ok {
+				delete(current, string(member))
+				removed++
+			}
+		}
+		if !exists {
+			return nil, nil
+		}
+		return encodeSet(current), nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return removed, nil
+}
+
+// Members returns the members of the set in ascending order, it's empty if the set does not exist
+func (s *Set) Members() ([][]byte, error) {
+	encoded, _, err := s.dataStore.readStructured(s.key, ValueTypeSet)
+	if err != nil {
+		return nil, err
+	}
+	current, err := decodeSet(encoded)
+	if err != nil {
+		return nil, err
+	}
+	members := make([][]byte, 0, len(current))
+	for member := range current {
+		members = append(members, []byte(member))
+	}
+	slices.SortFunc(members, func(a, b []byte) int {
+		return slices.Compare(a, b)
+	})
+	return members, nil
+}
+
+// Contains returns true if the member is in the set
+func (s *Set) Contains(member []byte) (bool, error) {
+	encoded, _, err := s.dataStore.readStructured(s.key, ValueTypeSet)
+	if err != nil {
+		return false, err
+	}
+	current, err := decodeSet(encoded)
+	if err != nil {
+		return false, err
+	}
+	_, ok := current[string(member)]
+	return ok, nil
+}
+
+// Len returns the number of members of the set, 0 if the set does not exist
+func (s *Set) Len() (int, error) {
+	encoded, _, err := s.dataStore.readStructured(s.key, ValueTypeSet)
+	if err != nil || len(encoded) == 0 {
+		return 0, err
+	}
+	n, m := binary.Uvarint(encoded)
+	if m <= 0 {
+		return 0, errInvalidStructured
+	}
+	return int(n), nil
+}
+
+// encodeSet returns the encoding of the members (see types.go), nil if there are none
+func encodeSet(members map[string]struct{}) []byte {
+	if len(members) == 0 {
+		return nil
+	}
+	sorted := make([]string, 0, len(members))
+	size := binary.MaxVarintLen64
+	for member := range members {
+		sorted = append(sorted, member)
+		size += binary.MaxVarintLen32 + len(member)
+	}
+	slices.Sort(sorted)
+	encoded := make([]byte, 0, size)
+	encoded = binary.AppendUvarint(encoded, uint64(len(members)))
+	for _, member := range sorted {
+		encoded = appendBytes(encoded, []byte(member))
+	}
+	return encoded
+}
+
+// decodeSet decodes the encoding of a set, an empty encoding is an empty set
+func decodeSet(encoded []byte) (map[string]struct{}, error) {
+	if len(encoded) == 0 {
+		return map[string]struct{}{}, nil
+	}
+	n, m := binary.Uvarint(encoded)
+	// Every member takes at least a byte
+	if m <= 0 || n > uint64(len(encoded)) {
+		return nil, errInvalidStructured
+	}
+	encoded = encoded[m:]
+	members := make(map[string]struct{}, n)
+	for range n {
+		var member []byte
+		var ok bool
+		if member, encoded, ok = cutBytes(encoded); !ok {
+			return nil, errInvalidStructured
+		}
+		members[string(member)] = struct{}{}
+	}
+	if len(encoded) != 0 || len(members) != int(n) {
+		return nil, errInvalidStructured
+	}
+	return members, nil
+}
@@ -0,0 +1,65 @@
+package kvdb
+
+import (
+	"errors"
+	"slices"
+	"testing"
+
+	"github.com/spf13/afero"
+)
+
+func TestSet(t *testing.T) {
+	store := helperCreateMultipleDataFiles(t, afero.NewMemMapFs(), "test_set.db")
+	defer store.Close()
+	set := store.Set([]byte("set"))
+
+	if n, err := set.Add([]byte("b"), []byte("a"), []byte("b")); err != nil || n != 2 {
+		t.Fatalf("expected 2 new members, got %d (err: %v)", n, err)
+	}
+	if n, err := set.Add([]byte("a"), []byte("c")); err != nil || n != 1 {
+		t.Fatalf("expected 1 new member, got %d (err: %v)", n, err)
+	}
+	if members, err := set.Members(); err != nil || !slices.Equal(strs(members), []string{"a", "b", "c"}) {
+		t.Errorf("expected a b c, got %v (err: %v)", strs(members), err)
+	}
+	if ok, err := set.Contains([]byte("b")); err != nil || !ok {
+		t.Errorf("expected b to be a member (err: %v)", err)
+	}
+	if ok, err := set.Contains([]byte("d")); err != nil || ok {
+		t.Errorf("expected d not to be a member (err: %v)", err)
+	}
+	if n, err := set.Remove([]byte("a"), []byte("d")); err != nil || n != 1 {
+		t.Errorf("expected 1 removed member, got %d (err: %v)", n, err)
+	}
+	if n, err := set.Len(); err != nil || n != 2 {
+		t.Errorf("expected 2 members, got %d (err: %v)", n, err)
+	}
+	if n, err := set.Remove([]byte("b"), []byte("c")); err != nil || n != 2 {
+		t.Errorf("expected 2 removed members, got %d (err: %v)", n, err)
+	}
+	if _, err := store.Get([]byte("set")); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("expected the empty set to be deleted, got %v", err)
+	}
+	if members, err := set.Members(); err != nil || len(members) != 0 {
+		t.Errorf("expected no members, got %v (err: %v)", strs(members), err)
+	}
+
+	store.Put([]byte("string"), []byte("value"))
+	_, err := store.Set([]byte("string")).Add([]byte("a"))
+	var wrongType *WrongTypeError
+	if !errors.As(err, &wrongType) || wrongType.Type != ValueTypeString || wrongType.Expected != ValueTypeSet {
+		t.Errorf("expected a WrongTypeError on a string, got %v", err)
+	}
+	if _, err := store.Set([]byte("string")).Contains([]byte("a")); !errors.Is(err, ErrWrongType) {
+		t.Errorf("expected ErrWrongType on a string, got %v", err)
+	}
+}
+
+func TestDecodeSetInvalid(t *testing.T) {
+	encoded := encodeSet(map[string]struct{}{"a": {}, "bc": {}})
+	for _, invalid := range [][]byte{encoded[:len(encoded)-1], append(encoded, 0), {0xff}, {5, 1, 'a'}} {
+		if _, err := decodeSet(invalid); err == nil {
+			t.Errorf("expected %v to be invalid", invalid)
+		}
+	}
+}
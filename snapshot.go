@@ -0,0 +1,207 @@
+package kvdb
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/ananthvk/kvdb/internal/datafile"
+	"github.com/ananthvk/kvdb/internal/filemanager"
+	"github.com/ananthvk/kvdb/internal/hintfile"
+	"github.com/ananthvk/kvdb/internal/keydir"
+	"github.com/ananthvk/kvdb/internal/metafile"
+	"github.com/ananthvk/kvdb/internal/record"
+	"github.com/ananthvk/kvdb/internal/utils"
+)
+
+/*
+Snapshot directory layout
+
+A snapshot is a read only copy of the live keys in the datastore, meant to be consumed by external readers (for example
+analytics scripts), without having to coordinate with the running store. The layout is stable, and is as follows
+
+	snapshot/
+		MANIFEST
+		data/
+			0000000001.dat
+			0000000002.dat
+			...
+		hint/
+			0000000001.hint
+			0000000002.hint
+			...
+
+Data and hint files use the same format as the datastore, but a snapshot only contains PUT records (no tombstones, no
+stale values), and every key appears exactly once. Keys are written in ascending byte order, across files in ascending
+file id order.
+
+MANIFEST is written last, so a snapshot without a MANIFEST is incomplete. It's a key=value file, for example
+
+	type=kvdb-snapshot
+	version=1.0.0
+	datafile_version=2.0.0
+	created=2026-02-10T11:32:00.118157Z
+	key_count=3
+	file_count=1
+	file=0000000001.dat,3,219
+
+Each `file` line contains the data file name, the number of records in it, and it's size in bytes, in file id order
+*/
+
+const (
+	snapshotType         = "kvdb-snapshot"
+	snapshotVersion      = "1.0.0"
+	snapshotManifestName = "MANIFEST"
+)
+
+// SnapshotFile is a data file of a snapshot, as listed in it's MANIFEST
+type SnapshotFile struct {
+	Name    string
+	Records int
+	Size    int64
+}
+
+// ExportSnapshotDir writes a snapshot of all keys currently present in the datastore to a new directory at path.
+// The path must not exist, or must be an empty directory. Writes are allowed while the snapshot is being exported, and
+// are not part of the snapshot. Merge is blocked until the export completes
+func (dataStore *DataStore) ExportSnapshotDir(path string) error {
+	if err := dataStore.gate.enter(); err != nil {
+		return err
+	}
+	defer dataStore.gate.exit()
+	if valid, reason, err := metafile.IsValidPath(dataStore.fs, path); err != nil || !valid {
+		if err != nil {
+			return err
+		}
+		return errors.New(reason)
+	}
+
+	// Prevent merge from deleting the files referenced by the keydir copy
+	dataStore.mergeLock.Lock()
+	defer dataStore.mergeLock.Unlock()
+
+	dataStore.mu.RLock()
+	entries := make(map[string]keydir.KeydirRecord, dataStore.keydir.Size())
+	dataStore.keydir.Range(func(key string, rec keydir.KeydirRecord) bool {
+		entries[key] = rec
+		return true
+	})
+	dataStore.mu.RUnlock()
+
+	keys := make([]string, 0, len(entries))
+	for key := range entries {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	dataDirPath := filepath.Join(path, "data")
+	hintDirPath := filepath.Join(path, "hint")
+	if err := dataStore.fs.MkdirAll(dataDirPath, os.ModePerm); err != nil {
+		return err
+	}
+	if err := dataStore.fs.MkdirAll(hintDirPath, os.ModePerm); err != nil {
+		return err
+	}
+
+	var files []SnapshotFile
+	writer := filemanager.NewRotateWriter(dataStore.fs, dataStore.metaInfo.MaxDatafileSize, true, func() (string, error) {
+		name := utils.GetDataFileName(len(files) + 1)
+		files = append(files, SnapshotFile{Name: name})
+		return filepath.Join(dataDirPath, name), nil
+	})
+	writer.SetLimits(limitsOf(dataStore.metaInfo))
+	writer.SetFormat(dataStore.fileManager.RecordFormat())
+	writer.SetChecksum(dataStore.fileManager.Checksum())
+	writer.SetKeyring(dataStore.fileManager.Keyring())
+	defer writer.Close()
+
+	var hintWriter *hintfile.Writer
+	defer func() {
+		if hintWriter != nil {
+			hintWriter.Close()
+		}
+	}()
+
+	for _, key := range keys {
+		loc := entries[key]
+		rec, err := dataStore.fileManager.ReadValueAt(loc.FileId, loc.ValuePos)
+		if err != nil {
+			return err
+		}
+		fileCount := len(files)
+		_, offset, err := writer.WriteRecordWithHeader(rec.Header, []byte(key), rec.Value)
+		if err != nil {
+			return err
+		}
+
+		// A new data file was started, so start the corresponding hint file
+		if len(files) != fileCount {
+			if hintWriter != nil {
+				if err := hintWriter.Close(); err != nil {
+					return err
+				}
+			}
+			hintWriter, err = hintfile.NewWriter(dataStore.fs, filepath.Join(hintDirPath, utils.GetHintFileName(len(files))))
+			if err != nil {
+				return err
+			}
+			hintWriter.SetLimits(limitsOf(dataStore.metaInfo))
+			hintWriter.SetKeyring(dataStore.fileManager.Keyring())
+		}
+
+		current := &files[len(files)-1]
+		current.Records++
+		current.Size = offset + writer.EncodedSize(uint32(len(key)), rec.Header.ValueSize)
+		err = hintWriter.WriteHintRecord(&hintfile.HintRecord{
+			Timestamp: rec.Header.Timestamp,
+			KeySize:   uint32(len(key)),
+			ValueSize: rec.Header.ValueSize,
+			ValuePos:  offset - datafile.FileHeaderSize,
+			Key:       []byte(key),
+			Immutable: rec.Header.RecordType == record.RecordTypePutImmutable,
+			ValueType: rec.Header.ValueType,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	if err := writer.Close(); err != nil {
+		return err
+	}
+	if hintWriter != nil {
+		if err := hintWriter.Close(); err != nil {
+			return err
+		}
+		hintWriter = nil
+	}
+
+	return dataStore.writeSnapshotManifest(path, len(keys), files)
+}
+
+func (dataStore *DataStore) writeSnapshotManifest(path string, keyCount int, files []SnapshotFile) error {
+	file, err := dataStore.fs.Create(filepath.Join(path, snapshotManifestName))
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	writer := bufio.NewWriter(file)
+	fmt.Fprintf(writer, "type=%s\n", snapshotType)
+	fmt.Fprintf(writer, "version=%s\n", snapshotVersion)
+	fmt.Fprintf(writer, "datafile_version=%s\n", datafile.Version())
+	fmt.Fprintf(writer, "created=%s\n", time.Now().UTC().Format(time.RFC3339Nano))
+	fmt.Fprintf(writer, "key_count=%d\n", keyCount)
+	fmt.Fprintf(writer, "file_count=%d\n", len(files))
+	for _, f := range files {
+		fmt.Fprintf(writer, "file=%s,%d,%d\n", f.Name, f.Records, f.Size)
+	}
+	if err := writer.Flush(); err != nil {
+		return err
+	}
+	return file.Sync()
+}
@@ -0,0 +1,130 @@
+package kvdb
+
+import (
+	"bufio"
+	"cmp"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ananthvk/kvdb/internal/record"
+	"github.com/spf13/afero"
+)
+
+// SnapshotManifest is the MANIFEST of a snapshot directory, see the layout in snapshot.go
+type SnapshotManifest struct {
+	Version         string
+	DatafileVersion string
+	Created         time.Time
+	KeyCount        int
+	// Data files of the snapshot, in file id order
+	Files []SnapshotFile
+}
+
+// ReadSnapshotManifest reads the MANIFEST of the snapshot directory at path. A snapshot without a MANIFEST is
+// incomplete, the returned error wraps os.ErrNotExist in that case
+func ReadSnapshotManifest(fs afero.Fs, path string) (*SnapshotManifest, error) {
+	file, err := fs.Open(filepath.Join(path, snapshotManifestName))
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	manifest := &SnapshotManifest{}
+	var snapshotKind string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		name, value, ok := strings.Cut(scanner.Text(), "=")
+		if !ok {
+			continue
+		}
+		switch name {
+		case "type":
+			snapshotKind = value
+		case "version":
+			manifest.Version = value
+		case "datafile_version":
+			manifest.DatafileVersion = value
+		case "created":
+			manifest.Created, err = time.Parse(time.RFC3339Nano, value)
+		case "key_count":
+			manifest.KeyCount, err = strconv.Atoi(value)
+		case "file":
+			var f SnapshotFile
+			f, err = parseSnapshotFile(value)
+			manifest.Files = append(manifest.Files, f)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid snapshot manifest %q line: %w", name, err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if snapshotKind != snapshotType {
+		return nil, fmt.Errorf("not a snapshot manifest, type is %q", snapshotKind)
+	}
+	return manifest, nil
+}
+
+// parseSnapshotFile parses the value of a file line of the manifest, <name>,<records>,<size>
+func parseSnapshotFile(value string) (SnapshotFile, error) {
+	fields := strings.Split(value, ",")
+	if len(fields) != 3 {
+		return SnapshotFile{}, errors.New("expected name, records and size")
+	}
+	records, err1 := strconv.Atoi(fields[1])
+	size, err2 := strconv.ParseInt(fields[2], 10, 64)
+	if err := errors.Join(err1, err2); err != nil {
+		return SnapshotFile{}, err
+	}
+	return SnapshotFile{Name: fields[0], Records: records, Size: size}, nil
+}
+
+// ScanSnapshotDir calls fn with every key and value of the complete snapshot at path, in ascending key order, until fn
+// returns an error, which is returned. The key and value are only valid until fn returns. The records are read with
+// their CRC checked, and key and value sizes up to the given limits (DefaultLimits if they are zero)
+func ScanSnapshotDir(fs afero.Fs, path string, maxKeySize, maxValueSize int, fn func(key, value []byte) error) error {
+	manifest, err := ReadSnapshotManifest(fs, path)
+	if err != nil {
+		return err
+	}
+	limits := record.Limits{
+		MaxKeySize:   cmp.Or(maxKeySize, record.DefaultLimits.MaxKeySize),
+		MaxValueSize: cmp.Or(maxValueSize, record.DefaultLimits.MaxValueSize),
+	}
+	for _, f := range manifest.Files {
+		if err := scanSnapshotFile(fs, filepath.Join(path, "data", f.Name), limits, fn); err != nil {
+			return fmt.Errorf("snapshot file %s: %w", f.Name, err)
+		}
+	}
+	return nil
+}
+
+func scanSnapshotFile(fs afero.Fs, path string, limits record.Limits, fn func(key, value []byte) error) error {
+	scanner, err := record.NewScanner(fs, path)
+	if err != nil {
+		return err
+	}
+	defer scanner.Close()
+	scanner.SetLimits(limits)
+	for {
+		rec, _, err := scanner.Scan()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if !record.IsPut(rec.Header.RecordType) {
+			continue
+		}
+		if err := fn(rec.Key, rec.Value); err != nil {
+			return err
+		}
+	}
+}
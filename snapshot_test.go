@@ -0,0 +1,138 @@
+package kvdb
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ananthvk/kvdb/internal/hintfile"
+	"github.com/ananthvk/kvdb/internal/record"
+	"github.com/spf13/afero"
+)
+
+func TestExportSnapshotDir(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	store := helperCreateMultipleDataFiles(t, fs, "test_snapshot.db")
+	defer store.Close()
+
+	store.Put([]byte("key2"), []byte("value2"))
+	store.Put([]byte("key1"), []byte("value1"))
+	store.Put([]byte("key3"), []byte("value3"))
+	store.Put([]byte("key1"), []byte("value1_updated"))
+	store.Delete([]byte("key3"))
+
+	if err := store.ExportSnapshotDir("snapshot"); err != nil {
+		t.Fatalf("export failed: %v", err)
+	}
+
+	// Writes after the export are not part of the snapshot
+	store.Put([]byte("key4"), []byte("value4"))
+
+	scanner, err := record.NewScanner(fs, filepath.Join("snapshot", "data", "0000000001.dat"))
+	if err != nil {
+		t.Fatalf("could not open snapshot data file: %v", err)
+	}
+	defer scanner.Close()
+	var got []string
+	for {
+		rec, _, err := scanner.Scan()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			t.Fatalf("scan failed: %v", err)
+		}
+		got = append(got, string(rec.Key)+"="+string(rec.Value))
+	}
+	expected := "key1=value1_updated,key2=value2"
+	if strings.Join(got, ",") != expected {
+		t.Errorf("expected records %s, got %s", expected, strings.Join(got, ","))
+	}
+
+	// Hint records should point at the records in the data file
+	hintScanner, err := hintfile.NewScanner(fs, filepath.Join("snapshot", "hint", "0000000001.hint"))
+	if err != nil {
+		t.Fatalf("could not open snapshot hint file: %v", err)
+	}
+	defer hintScanner.Close()
+	reader, err := record.NewReader(fs, filepath.Join("snapshot", "data", "0000000001.dat"))
+	if err != nil {
+		t.Fatalf("could not open snapshot data file: %v", err)
+	}
+	defer reader.Close()
+	for {
+		hint, err := hintScanner.Scan()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			t.Fatalf("hint scan failed: %v", err)
+		}
+		rec, err := reader.ReadRecordAtStrict(hint.ValuePos)
+		if err != nil {
+			t.Fatalf("could not read record for hint %s: %v", hint.Key, err)
+		}
+		if string(rec.Key) != string(hint.Key) {
+			t.Errorf("hint for %s points to record with key %s", hint.Key, rec.Key)
+		}
+	}
+
+	manifest, err := afero.ReadFile(fs, filepath.Join("snapshot", "MANIFEST"))
+	if err != nil {
+		t.Fatalf("could not read manifest: %v", err)
+	}
+	for _, line := range []string{"type=kvdb-snapshot", "key_count=2", "file_count=1", "file=0000000001.dat,2,"} {
+		if !strings.Contains(string(manifest), line) {
+			t.Errorf("expected manifest to contain %q, got:\n%s", line, manifest)
+		}
+	}
+
+	// Exporting to a non empty directory fails
+	if err := store.ExportSnapshotDir("snapshot"); err == nil {
+		t.Errorf("expected export to an existing snapshot directory to fail")
+	}
+}
+
+func TestScanSnapshotDir(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	store := helperCreateMultipleDataFiles(t, fs, "test_scan_snapshot.db")
+	defer store.Close()
+	store.Put([]byte("key2"), []byte("value2"))
+	store.Put([]byte("key1"), []byte("value1"))
+	store.Delete([]byte("key2"))
+	if err := store.ExportSnapshotDir("snapshot"); err != nil {
+		t.Fatalf("export failed: %v", err)
+	}
+
+	manifest, err := ReadSnapshotManifest(fs, "snapshot")
+	if err != nil {
+		t.Fatalf("could not read manifest: %v", err)
+	}
+	if manifest.KeyCount != store.Size() || len(manifest.Files) != 1 || manifest.Files[0].Records != manifest.KeyCount || manifest.Created.IsZero() {
+		t.Errorf("unexpected manifest %+v", manifest)
+	}
+
+	var keys []string
+	err = ScanSnapshotDir(fs, "snapshot", 0, 0, func(key, value []byte) error {
+		if want, _ := store.Get(key); string(value) != string(want) {
+			t.Errorf("expected %q for %q, got %q", want, key, value)
+		}
+		keys = append(keys, string(key))
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("scan failed: %v", err)
+	}
+	if len(keys) != 1 || keys[0] != "key1" {
+		t.Errorf("expected only key1, got %v", keys)
+	}
+
+	// A snapshot without a MANIFEST is incomplete
+	fs.Remove(filepath.Join("snapshot", "MANIFEST"))
+	if _, err := ReadSnapshotManifest(fs, "snapshot"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected a not exist error for an incomplete snapshot, got %v", err)
+	}
+}
@@ -0,0 +1,122 @@
+package kvdb
+
+import (
+	"fmt"
+	"time"
+)
+
+/*
+Write stalls
+
+Writes only append to the active data file, so they stay fast even when the background work that keeps the datastore
+healthy falls behind: merges that can't keep up with rotation leave more and more data files (slower startup, more open
+readers, more disk space), and syncs that can't keep up leave more and more bytes that are lost on a crash. Instead of
+letting this go on until something breaks, the datastore enters an explicit stall mode when one of the configured
+thresholds (Options.StallDataFiles, Options.StallUnsyncedBytes) is exceeded. While stalled, writes are either delayed
+by Options.StallDelay (to give the background work room to catch up), or rejected with a *StallError if
+Options.RejectStalledWrites is set. The stall ends as soon as the datastore is back under the thresholds, i.e. after a
+merge or a sync.
+
+The thresholds are checked on every write, and after every merge and sync. The number of data files is tracked by the
+file manager, so the check does not read the data directory
+*/
+
+const defaultStallDelay = 10 * time.Millisecond
+
+// StallError is returned by writes that are rejected because the datastore is stalled, it wraps ErrWriteStalled
+type StallError struct {
+	// Why the datastore is stalled
+	Reason        string
+	DataFiles     int
+	UnsyncedBytes int64
+}
+
+func (e *StallError) Error() string {
+	return fmt.Sprintf("%s: %s", ErrWriteStalled, e.Reason)
+}
+
+func (e *StallError) Unwrap() error {
+	return ErrWriteStalled
+}
+
+// StallEvent is sent when the datastore enters or leaves stall mode
+type StallEvent struct {
+	// true when the datastore enters stall mode, false when it leaves it
+	Stalled bool
+	// Why the datastore is stalled, empty when it leaves stall mode
+	Reason        string
+	DataFiles     int
+	UnsyncedBytes int64
+}
+
+// StallWatchFunc is called every time the datastore enters or leaves stall mode
+type StallWatchFunc func(event StallEvent)
+
+// WatchStalls registers fn to be called every time the datastore enters or leaves stall mode. fn is called inside the
+// write lock, so it must not block, and must not call back into the datastore. It returns a function that unregisters fn
+func (dataStore *DataStore) WatchStalls(fn StallWatchFunc) (cancel func()) {
+	return dataStore.stallWatchers.add(fn)
+}
+
+type stallState struct {
+	// Protected by the write lock
+	event          StallEvent
+	stalledWrites  uint64
+	rejectedWrites uint64
+}
+
+// lockForWrite acquires the write lock, after delaying the write if the datastore is stalled (so that the delay does
+// not block readers)
+func (dataStore *DataStore) lockForWrite(site string) {
+	if dataStore.stalled.Load() && !dataStore.options.RejectStalledWrites {
+		delay := dataStore.options.StallDelay
+		if delay <= 0 {
+			delay = defaultStallDelay
+		}
+		time.Sleep(delay)
+	}
+	dataStore.lockProfiler.Lock(&dataStore.mu, site)
+}
+
+// checkStall updates the stall state before a write, and returns a *StallError if the write must be rejected. The caller
+// must hold the write lock
+func (dataStore *DataStore) checkStall() error {
+	if !dataStore.updateStall() {
+		return nil
+	}
+	state := &dataStore.stall
+	state.stalledWrites++
+	if !dataStore.options.RejectStalledWrites {
+		return nil
+	}
+	state.rejectedWrites++
+	return &StallError{Reason: state.event.Reason, DataFiles: state.event.DataFiles, UnsyncedBytes: state.event.UnsyncedBytes}
+}
+
+// updateStall checks the thresholds, enters or leaves stall mode, and returns true if the datastore is stalled. The
+// caller must hold the write lock
+func (dataStore *DataStore) updateStall() bool {
+	maxFiles, maxUnsynced := dataStore.options.StallDataFiles, dataStore.options.StallUnsyncedBytes
+	if maxFiles <= 0 && maxUnsynced <= 0 {
+		return false
+	}
+	state := &dataStore.stall
+	dataFiles, _ := dataStore.fileManager.DataFileStats()
+	unsynced := dataStore.fileManager.UnsyncedBytes()
+
+	reason := ""
+	switch {
+	case maxFiles > 0 && dataFiles > maxFiles:
+		reason = fmt.Sprintf("%d data files, more than the limit of %d (merge is not keeping up)", dataFiles, maxFiles)
+	case maxUnsynced > 0 && unsynced > maxUnsynced:
+		reason = fmt.Sprintf("%d unsynced bytes, more than the limit of %d (sync is not keeping up)", unsynced, maxUnsynced)
+	}
+	stalled := reason != ""
+	changed := stalled != state.event.Stalled
+	state.event = StallEvent{Stalled: stalled, Reason: reason, DataFiles: dataFiles, UnsyncedBytes: unsynced}
+	if changed {
+		dataStore.stalled.Store(stalled)
+		dataStore.stallWatchers.notify(state.event)
+	}
+	return stalled
+}
@@ -0,0 +1,109 @@
+package kvdb
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/spf13/afero"
+)
+
+func TestStallUnsyncedBytesRejectsWrites(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	store, err := CreateWithOptions(fs, "test_stall_unsynced.db", &Options{StallUnsyncedBytes: 100, RejectStalledWrites: true})
+	if err != nil {
+		t.Fatalf("could not create datastore: %v", err)
+	}
+	defer store.Close()
+	var events []StallEvent
+	store.WatchStalls(func(event StallEvent) {
+		events = append(events, event)
+	})
+
+	value := make([]byte, 60)
+	if err := store.Put([]byte("key1"), value); err != nil {
+		t.Fatalf("put failed: %v", err)
+	}
+	if err := store.Put([]byte("key2"), value); err != nil {
+		t.Fatalf("put failed: %v", err)
+	}
+	err = store.Put([]byte("key3"), value)
+	var stallErr *StallError
+	if !errors.Is(err, ErrWriteStalled) || !errors.As(err, &stallErr) || stallErr.UnsyncedBytes <= 100 {
+		t.Fatalf("expected a stall error, got %v", err)
+	}
+	if err := store.Delete([]byte("key1")); !errors.Is(err, ErrWriteStalled) {
+		t.Errorf("expected deletes to be rejected too, got %v", err)
+	}
+	if _, err := store.Get([]byte("key3")); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("expected the rejected write not to be applied, got %v", err)
+	}
+	stats, _ := store.Stats()
+	if !stats.Stalled || stats.StallReason == "" || stats.StalledWrites != 2 || stats.RejectedWrites != 2 {
+		t.Errorf("unexpected stall stats: %+v", stats)
+	}
+
+	// Syncing ends the stall
+	if err := store.Sync(); err != nil {
+		t.Fatalf("sync failed: %v", err)
+	}
+	if err := store.Put([]byte("key3"), value); err != nil {
+		t.Errorf("expected the write to succeed after sync, got %v", err)
+	}
+	if len(events) != 2 || !events[0].Stalled || events[1].Stalled {
+		t.Errorf("expected a stall and a recovery event, got %+v", events)
+	}
+}
+
+func TestStallDataFilesEndsAfterMerge(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	store := helperCreateMultipleDataFiles(t, fs, "test_stall_files.db")
+	// Every time the datastore is opened and written to, a new data file is created
+	for i := range 3 {
+		store.Put([]byte("key"), []byte{byte(i)})
+		store.Close()
+		var err error
+		store, err = OpenWithOptions(fs, "test_stall_files.db", &Options{StallDataFiles: 2, RejectStalledWrites: true})
+		if err != nil {
+			t.Fatalf("could not open datastore: %v", err)
+		}
+	}
+	defer store.Close()
+
+	if err := store.Put([]byte("key"), []byte("value")); !errors.Is(err, ErrWriteStalled) {
+		t.Fatalf("expected a stall error, got %v", err)
+	}
+	if err := store.Merge(); err != nil {
+		t.Fatalf("merge failed: %v", err)
+	}
+	stats, _ := store.Stats()
+	if stats.Stalled {
+		t.Errorf("expected the merge to end the stall: %+v", stats)
+	}
+	if err := store.Put([]byte("key"), []byte("value")); err != nil {
+		t.Errorf("expected the write to succeed after merge, got %v", err)
+	}
+}
+
+func TestStallDelaysWrites(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	store, err := CreateWithOptions(fs, "test_stall_delay.db", &Options{StallUnsyncedBytes: 1, StallDelay: 20 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("could not create datastore: %v", err)
+	}
+	defer store.Close()
+
+	store.Put([]byte("key1"), []byte("value"))
+	store.Put([]byte("key2"), []byte("value"))
+	start := time.Now()
+	if err := store.Put([]byte("key3"), []byte("value")); err != nil {
+		t.Fatalf("expected delayed writes to succeed, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 20*time.Millisecond {
+		t.Errorf("expected the write to be delayed, took %s", elapsed)
+	}
+	stats, _ := store.Stats()
+	if !stats.Stalled || stats.StalledWrites == 0 || stats.RejectedWrites != 0 {
+		t.Errorf("unexpected stall stats: %+v", stats)
+	}
+}
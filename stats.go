@@ -0,0 +1,212 @@
+package kvdb
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// Stats is a point in time view of the datastore, meant for monitoring. The counters are reset when the datastore is
+// opened, unless it was opened with Options.StatsFlushInterval, which keeps them over the lifetime of the datastore
+type Stats struct {
+	// Path of the datastore
+	Path string
+	// Version of the application that created the datastore
+	Version string
+	// Number of keys in the datastore
+	Keys int
+
+	// Number of data files (including the active file), and their total size in bytes
+	DataFiles     int
+	DataFileBytes int64
+	// Bytes taken by stale records and tombstones in the immutable data files, that a merge would free
+	ReclaimableBytes int64
+	// Id of the data file that is currently being written to
+	ActiveFileId int
+
+	// Number of successful operations since the datastore was opened
+	Gets    uint64
+	Puts    uint64
+	Deletes uint64
+
+	// Number of merges since the datastore was opened, and how many of them failed
+	Merges       uint64
+	FailedMerges uint64
+	// MergeInProgress is true if a merge is currently running
+	MergeInProgress bool
+	// Start time, duration and error (if any) of the last completed merge, zero if no merge has completed
+	LastMergeTime     time.Time
+	LastMergeDuration time.Duration
+	LastMergeError    error
+
+	Interceptors InterceptorStats
+
+	// Estimated memory used by the in-memory index, in bytes
+	KeydirMemoryBytes int64
+	// Number of data files that have an open reader in the reader cache
+	OpenReaders int
+	// Number of files the datastore has open (data files, hint files, merge outputs and value readers), the budget set
+	// with Options.MaxOpenFiles (0 if there is none), and the number of readers closed to stay within it
+	OpenFiles       int64
+	MaxOpenFiles    int
+	ReaderEvictions uint64
+	// Estimated memory used by the caches of the datastore (the reader cache, the value cache, and the sorted keys used
+	// by ListKeysPage), in bytes. The sorted keys share their bytes with the keydir, so only the slice is counted
+	CacheMemoryBytes int64
+	// Value cache counters, zero unless the datastore was opened with Options.ValueCacheBytes. The memory used by the
+	// cached values is included in CacheMemoryBytes
+	ValueCacheHits    uint64
+	ValueCacheMisses  uint64
+	ValueCacheEntries int
+	// Total size of the data files that are memory mapped by the reader cache, 0 unless the datastore was opened with
+	// Options.MmapReads. The mappings are not part of the Go heap
+	MappedBytes int64
+
+	// Sampled lock wait times per site, the site with the most total wait time first. Empty unless the datastore was
+	// opened with Options.LockProfileRate
+	LockContention []LockContentionStats
+
+	// Stalled is true while the datastore is in stall mode, StallReason describes the threshold that was exceeded
+	Stalled     bool
+	StallReason string
+	// Number of writes made while stalled (delayed or rejected), and how many of them were rejected
+	StalledWrites  uint64
+	RejectedWrites uint64
+	// Bytes written since the last Sync
+	UnsyncedBytes int64
+
+	// Number of batches written by the group commit writer, and the number of Puts in them. Zero unless the datastore
+	// was opened with Options.GroupCommit
+	GroupCommits    uint64
+	GroupCommitPuts uint64
+
+	// Limit of the keydir memory (0 if there is none), how close the keydir is to it, and the number of writes that
+	// were rejected because of it. See Options.KeydirMemoryLimit
+	KeydirMemoryLimit         int64
+	KeydirMemoryLevel         MemoryLevel
+	RejectedMemoryLimitWrites uint64
+
+	// Number of orphaned hint files found when the datastore was opened and after merges, see Options.HintOrphans
+	OrphanHintFiles uint64
+	// Number of data files that Scan did not have to read, because of their key and timestamp ranges
+	ScanSkippedFiles uint64
+}
+
+// LockContentionStats is the time spent waiting for the datastore or file manager lock at a site (e.g.
+// "datastore.get", "filemanager.write"), over the sampled lock acquisitions
+type LockContentionStats struct {
+	Site      string
+	Samples   uint64
+	TotalWait time.Duration
+	MaxWait   time.Duration
+}
+
+// MeanWait returns the average wait time of the sampled acquisitions
+func (s LockContentionStats) MeanWait() time.Duration {
+	if s.Samples == 0 {
+		return 0
+	}
+	return s.TotalWait / time.Duration(s.Samples)
+}
+
+type storeCounters struct {
+	gets    atomic.Uint64
+	puts    atomic.Uint64
+	deletes atomic.Uint64
+
+	merges          atomic.Uint64
+	failedMerges    atomic.Uint64
+	mergeInProgress atomic.Bool
+	lastMerge       atomic.Pointer[mergeResult]
+
+	orphanHintFiles atomic.Uint64
+	// Data files that Scan did not read because of their key and timestamp ranges
+	scanSkippedFiles atomic.Uint64
+}
+
+type mergeResult struct {
+	start    time.Time
+	duration time.Duration
+	err      error
+	// Total size of the files that were merged, used to estimate the duration of the next merge
+	inputBytes int64
+}
+
+func (c *storeCounters) recordMerge(start time.Time, inputBytes int64, err error) {
+	c.merges.Add(1)
+	if err != nil {
+		c.failedMerges.Add(1)
+	}
+	c.lastMerge.Store(&mergeResult{start: start, duration: time.Since(start), err: err, inputBytes: inputBytes})
+}
+
+// Stats returns statistics about the datastore. The error is ErrClosed for the zero value DataStore, and nil otherwise
+// (also after Close)
+func (dataStore *DataStore) Stats() (Stats, error) {
+	if !dataStore.opened() {
+		return Stats{}, ErrClosed
+	}
+	dataFiles, dataFileBytes := dataStore.fileManager.DataFileStats()
+	stats := Stats{
+		Path:             dataStore.path,
+		Version:          dataStore.metaInfo.Version,
+		Keys:             dataStore.Size(),
+		DataFiles:        dataFiles,
+		DataFileBytes:    dataFileBytes,
+		ActiveFileId:     dataStore.fileManager.GetActiveFileId(),
+		Gets:             dataStore.counters.gets.Load(),
+		Puts:             dataStore.counters.puts.Load(),
+		Deletes:          dataStore.counters.deletes.Load(),
+		Merges:           dataStore.counters.merges.Load(),
+		FailedMerges:     dataStore.counters.failedMerges.Load(),
+		MergeInProgress:  dataStore.counters.mergeInProgress.Load(),
+		Interceptors:     dataStore.InterceptorStats(),
+		OrphanHintFiles:  dataStore.counters.orphanHintFiles.Load(),
+		ScanSkippedFiles: dataStore.counters.scanSkippedFiles.Load(),
+	}
+	dataStore.mu.RLock()
+	stats.KeydirMemoryBytes = dataStore.keydir.MemoryUsage()
+	stats.Stalled = dataStore.stall.event.Stalled
+	stats.StallReason = dataStore.stall.event.Reason
+	stats.StalledWrites = dataStore.stall.stalledWrites
+	stats.RejectedWrites = dataStore.stall.rejectedWrites
+	stats.KeydirMemoryLimit = dataStore.options.KeydirMemoryLimit
+	stats.KeydirMemoryLevel = dataStore.memory.level
+	stats.RejectedMemoryLimitWrites = dataStore.memory.rejectedWrites
+	dataStore.mu.RUnlock()
+	stats.ReclaimableBytes = dataStore.ReclaimableBytes()
+	stats.UnsyncedBytes = dataStore.fileManager.UnsyncedBytes()
+	stats.OpenReaders = dataStore.fileManager.OpenReaders()
+	stats.OpenFiles = dataStore.fileManager.OpenFiles()
+	stats.MaxOpenFiles = dataStore.fileManager.MaxOpenFiles()
+	stats.ReaderEvictions = dataStore.fileManager.ReaderEvictions()
+	stats.CacheMemoryBytes = dataStore.fileManager.ReaderCacheMemoryUsage()
+	stats.MappedBytes = dataStore.fileManager.MappedBytes()
+	valueCache := dataStore.fileManager.ValueCacheStats()
+	stats.ValueCacheHits = valueCache.Hits
+	stats.ValueCacheMisses = valueCache.Misses
+	stats.ValueCacheEntries = valueCache.Entries
+	stats.CacheMemoryBytes += valueCache.Bytes
+	if snapshot := dataStore.keySnapshot.Load(); snapshot != nil {
+		stats.CacheMemoryBytes += snapshot.memoryUsage()
+	}
+	for _, site := range dataStore.lockProfiler.Sites() {
+		stats.LockContention = append(stats.LockContention, LockContentionStats{
+			Site:      site.Name,
+			Samples:   site.Samples,
+			TotalWait: site.TotalWait,
+			MaxWait:   site.MaxWait,
+		})
+	}
+
+	if dataStore.committer != nil {
+		stats.GroupCommits = dataStore.committer.batches.Load()
+		stats.GroupCommitPuts = dataStore.committer.puts.Load()
+	}
+
+	if last := dataStore.counters.lastMerge.Load(); last != nil {
+		stats.LastMergeTime = last.start
+		stats.LastMergeDuration = last.duration
+		stats.LastMergeError = last.err
+	}
+	return stats, nil
+}
@@ -0,0 +1,143 @@
+package kvdb
+
+import (
+	"encoding/json"
+	"errors"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/spf13/afero"
+)
+
+/*
+Stats file
+
+The counters of Stats live in memory, and start from zero every time the datastore is opened. With
+Options.StatsFlushInterval set, they are also written to kvdb_stats.json in the datastore directory every interval, and
+when the datastore is closed, and are read back when it's opened, so that Stats reports the counters (and the last
+merge) over the lifetime of the datastore. The file is written to a temporary file and renamed, so a crash leaves
+either the previous or the new counters. After a crash, the operations since the last flush are not counted, so the
+counters are approximate.
+
+A stats file that can't be read is ignored, and the counters start from zero. The file is not used unless the option is
+set, so it can be deleted at any time to reset the counters
+*/
+
+const statsFileName = "kvdb_stats.json"
+
+type statsFile struct {
+	Gets         uint64          `json:"gets"`
+	Puts         uint64          `json:"puts"`
+	Deletes      uint64          `json:"deletes"`
+	Merges       uint64          `json:"merges"`
+	FailedMerges uint64          `json:"failed_merges"`
+	LastMerge    *statsFileMerge `json:"last_merge,omitempty"`
+}
+
+type statsFileMerge struct {
+	Start      time.Time     `json:"start"`
+	Duration   time.Duration `json:"duration"`
+	Error      string        `json:"error,omitempty"`
+	InputBytes int64         `json:"input_bytes"`
+}
+
+// snapshot returns the current counters in the format of the stats file
+func (c *storeCounters) snapshot() *statsFile {
+	stats := &statsFile{
+		Gets:         c.gets.Load(),
+		Puts:         c.puts.Load(),
+		Deletes:      c.deletes.Load(),
+		Merges:       c.merges.Load(),
+		FailedMerges: c.failedMerges.Load(),
+	}
+	if last := c.lastMerge.Load(); last != nil {
+		stats.LastMerge = &statsFileMerge{Start: last.start, Duration: last.duration, InputBytes: last.inputBytes}
+		if last.err != nil {
+			stats.LastMerge.Error = last.err.Error()
+		}
+	}
+	return stats
+}
+
+// restore sets the counters to the ones read from a stats file, the error of the last merge is restored as a plain
+// error with the same message
+func (c *storeCounters) restore(stats *statsFile) {
+	c.gets.Store(stats.Gets)
+	c.puts.Store(stats.Puts)
+	c.deletes.Store(stats.Deletes)
+	c.merges.Store(stats.Merges)
+	c.failedMerges.Store(stats.FailedMerges)
+	if last := stats.LastMerge; last != nil {
+		result := &mergeResult{start: last.Start, duration: last.Duration, inputBytes: last.InputBytes}
+		if last.Error != "" {
+			result.err = errors.New(last.Error)
+		}
+		c.lastMerge.Store(result)
+	}
+}
+
+// readStatsFile reads the stats file of the datastore at path, it returns nil if there is no stats file, or if it
+// can't be read
+func readStatsFile(fs afero.Fs, path string) *statsFile {
+	data, err := afero.ReadFile(fs, filepath.Join(path, statsFileName))
+	if err != nil {
+		return nil
+	}
+	var stats statsFile
+	if err := json.Unmarshal(data, &stats); err != nil {
+		return nil
+	}
+	return &stats
+}
+
+// statsFlusher writes the counters of a datastore to it's stats file
+type statsFlusher struct {
+	// Held while the file is written, so that the background flush and the flush of Close don't write the temporary
+	// file at the same time
+	mu       sync.Mutex
+	fs       afero.Fs
+	path     string
+	counters *storeCounters
+	stopped  chan struct{}
+}
+
+// startStatsFlusher restores the counters from the stats file, and flushes them every interval until done is closed
+func startStatsFlusher(fs afero.Fs, path string, counters *storeCounters, interval time.Duration, done <-chan struct{}) *statsFlusher {
+	if stats := readStatsFile(fs, path); stats != nil {
+		counters.restore(stats)
+	}
+	f := &statsFlusher{fs: fs, path: path, counters: counters, stopped: make(chan struct{})}
+	go func() {
+		defer close(f.stopped)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				// A failed flush is retried on the next tick, and by Close
+				f.flush()
+			}
+		}
+	}()
+	return f
+}
+
+// flush writes the current counters to the stats file
+func (f *statsFlusher) flush() error {
+	data, err := json.Marshal(f.counters.snapshot())
+	if err != nil {
+		return err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return writeFileAtomic(f.fs, filepath.Join(f.path, statsFileName), data)
+}
+
+// close waits for the background flushes to stop, and flushes the counters a last time
+func (f *statsFlusher) close() error {
+	<-f.stopped
+	return f.flush()
+}
@@ -0,0 +1,108 @@
+package kvdb
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/spf13/afero"
+)
+
+func TestStatsFileSurvivesReopen(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	path := "test_stats_file.db"
+	opts := &Options{StatsFlushInterval: time.Hour}
+	store, err := CreateWithOptions(fs, path, opts)
+	if err != nil {
+		t.Fatalf("could not create datastore: %v", err)
+	}
+	store.Put([]byte("key1"), []byte("value1"))
+	store.Put([]byte("key2"), []byte("value2"))
+	store.Get([]byte("key1"))
+	store.Delete([]byte("key2"))
+	if err := store.Merge(); err != nil {
+		t.Fatalf("merge failed: %v", err)
+	}
+	before, _ := store.Stats()
+	if err := store.Close(); err != nil {
+		t.Fatalf("close failed: %v", err)
+	}
+
+	store, err = OpenWithOptions(fs, path, opts)
+	if err != nil {
+		t.Fatalf("could not open datastore: %v", err)
+	}
+	defer store.Close()
+	store.Get([]byte("key1"))
+	stats, _ := store.Stats()
+	if stats.Puts != 2 || stats.Gets != 2 || stats.Deletes != 1 || stats.Merges != 1 || stats.FailedMerges != 0 {
+		t.Errorf("expected the counters to continue, got %+v", stats)
+	}
+	if !stats.LastMergeTime.Equal(before.LastMergeTime) || stats.LastMergeDuration != before.LastMergeDuration {
+		t.Errorf("expected the last merge to be restored, got %v (%v), expected %v (%v)", stats.LastMergeTime,
+			stats.LastMergeDuration, before.LastMergeTime, before.LastMergeDuration)
+	}
+
+	// Without the option, the counters start from zero
+	store.Close()
+	store, err = Open(fs, path)
+	if err != nil {
+		t.Fatalf("could not open datastore: %v", err)
+	}
+	defer store.Close()
+	if stats, _ := store.Stats(); stats.Puts != 0 || stats.Merges != 0 || !stats.LastMergeTime.IsZero() {
+		t.Errorf("expected the counters to be reset, got %+v", stats)
+	}
+}
+
+func TestStatsFileFlushedOnInterval(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	path := "test_stats_file_interval.db"
+	store, err := CreateWithOptions(fs, path, &Options{StatsFlushInterval: 5 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("could not create datastore: %v", err)
+	}
+	defer store.Close()
+	for range 3 {
+		store.Put([]byte("key"), []byte("value"))
+	}
+
+	// The datastore is not closed, like after a crash, the counters are read from the last flush
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if stats := readStatsFile(fs, path); stats != nil && stats.Puts == 3 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the counters to be flushed, got %+v", readStatsFile(fs, path))
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestStatsFileDamaged(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	path := "test_stats_file_damaged.db"
+	store, err := Create(fs, path)
+	if err != nil {
+		t.Fatalf("could not create datastore: %v", err)
+	}
+	store.Put([]byte("key"), []byte("value"))
+	store.Close()
+	afero.WriteFile(fs, filepath.Join(path, statsFileName), []byte("{not json"), 0666)
+
+	store, err = OpenWithOptions(fs, path, &Options{StatsFlushInterval: time.Hour})
+	if err != nil {
+		t.Fatalf("expected a damaged stats file to be ignored, got %v", err)
+	}
+	store.Put([]byte("key"), []byte("value"))
+	if stats, _ := store.Stats(); stats.Puts != 1 {
+		t.Errorf("expected the counters to start from zero, got %d puts", stats.Puts)
+	}
+	if err := store.Close(); err != nil {
+		t.Fatalf("close failed: %v", err)
+	}
+	if stats := readStatsFile(fs, path); stats == nil || stats.Puts != 1 {
+		t.Errorf("expected the stats file to be rewritten on close, got %+v", stats)
+	}
+}
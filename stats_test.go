@@ -0,0 +1,110 @@
+package kvdb
+
+import (
+	"testing"
+
+	"github.com/spf13/afero"
+)
+
+func TestStats(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	store := helperCreateMultipleDataFiles(t, fs, "test_stats.db")
+	defer store.Close()
+
+	store.Put([]byte("key1"), []byte("value1"))
+	store.Put([]byte("key2"), []byte("value2"))
+	store.Get([]byte("key1"))
+	store.Delete([]byte("key2"))
+
+	if err := store.Merge(); err != nil {
+		t.Fatalf("merge failed: %v", err)
+	}
+
+	stats, err := store.Stats()
+	if err != nil {
+		t.Fatalf("stats failed: %v", err)
+	}
+	if stats.Keys != 1 {
+		t.Errorf("expected 1 key, got %d", stats.Keys)
+	}
+	if stats.Puts != 2 || stats.Gets != 1 || stats.Deletes != 1 {
+		t.Errorf("expected 2 puts, 1 get, 1 delete, got %d puts, %d gets, %d deletes", stats.Puts, stats.Gets, stats.Deletes)
+	}
+	if stats.DataFiles != 1 || stats.ActiveFileId != 1 || stats.DataFileBytes == 0 {
+		t.Errorf("unexpected data file stats: %+v", stats)
+	}
+	if stats.Merges != 1 || stats.FailedMerges != 0 || stats.LastMergeTime.IsZero() || stats.MergeInProgress {
+		t.Errorf("unexpected merge stats: %+v", stats)
+	}
+}
+
+func TestStatsLockContention(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	store, err := CreateWithOptions(fs, "test_lock_contention.db", &Options{LockProfileRate: 1})
+	if err != nil {
+		t.Fatalf("could not create datastore: %v", err)
+	}
+	defer store.Close()
+
+	store.Put([]byte("key1"), []byte("value1"))
+	store.Get([]byte("key1"))
+	store.Get([]byte("key1"))
+
+	stats, err := store.Stats()
+	if err != nil {
+		t.Fatalf("stats failed: %v", err)
+	}
+	samples := map[string]uint64{}
+	for _, site := range stats.LockContention {
+		samples[site.Site] = site.Samples
+	}
+	if samples["datastore.get"] != 2 || samples["datastore.put"] != 1 || samples["filemanager.write"] != 1 {
+		t.Errorf("unexpected lock contention stats: %+v", stats.LockContention)
+	}
+	if samples["filemanager.get_reader"] == 0 {
+		t.Errorf("expected reads to sample the file manager lock: %+v", stats.LockContention)
+	}
+}
+
+func TestStatsLockContentionDisabled(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	store := helperCreateMultipleDataFiles(t, fs, "test_lock_contention_disabled.db")
+	defer store.Close()
+
+	store.Put([]byte("key1"), []byte("value1"))
+	store.Get([]byte("key1"))
+
+	stats, err := store.Stats()
+	if err != nil {
+		t.Fatalf("stats failed: %v", err)
+	}
+	if len(stats.LockContention) != 0 {
+		t.Errorf("expected no lock contention stats, got %+v", stats.LockContention)
+	}
+}
+
+func TestMergeRecordsInputBytes(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	path := "test_merge_input_bytes.db"
+	store := helperCreateMultipleDataFiles(t, fs, path)
+	store.Put([]byte("key1"), []byte("value1"))
+	store.Put([]byte("key1"), []byte("value2"))
+	store.Close()
+	store, err := Open(fs, path)
+	if err != nil {
+		t.Fatalf("failed to reopen store: %v", err)
+	}
+	defer store.Close()
+	store.Put([]byte("key2"), []byte("value2"))
+
+	estimate, err := store.EstimateMerge()
+	if err != nil {
+		t.Fatalf("estimate failed: %v", err)
+	}
+	if err := store.Merge(); err != nil {
+		t.Fatalf("merge failed: %v", err)
+	}
+	if last := store.counters.lastMerge.Load(); last == nil || last.inputBytes != estimate.InputBytes || last.inputBytes == 0 {
+		t.Errorf("expected the merge to record %d input bytes, got %+v", estimate.InputBytes, last)
+	}
+}
@@ -1,18 +1,24 @@
 package kvdb
 
 import (
+	"cmp"
+	"context"
 	"errors"
 	"fmt"
 	"io"
+	"math"
 	"os"
 	"path/filepath"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/ananthvk/kvdb/internal/bloom"
 	"github.com/ananthvk/kvdb/internal/datafile"
 	"github.com/ananthvk/kvdb/internal/filemanager"
 	"github.com/ananthvk/kvdb/internal/hintfile"
 	"github.com/ananthvk/kvdb/internal/keydir"
+	"github.com/ananthvk/kvdb/internal/lockprof"
 	"github.com/ananthvk/kvdb/internal/metafile"
 	"github.com/ananthvk/kvdb/internal/record"
 	"github.com/ananthvk/kvdb/internal/utils"
@@ -28,12 +34,58 @@ type DataStore struct {
 	mu          sync.RWMutex
 	// To ensure that only one merge can occur at a time
 	mergeLock sync.Mutex
+	options   Options
+
+	interceptorStats interceptorCounters
+	counters         storeCounters
+	watchers         watchers[WatchEvent]
+	mergeWatchers    watchers[MergeEvent]
+	appendSignal     appendSignal
+	// nil unless Options.LockProfileRate is set
+	lockProfiler *lockprof.Profiler
+	// Sorted keys used by ListKeysPage
+	keySnapshot atomic.Pointer[keySnapshot]
+
+	stall         stallState
+	stalled       atomic.Bool
+	stallWatchers watchers[StallEvent]
+	// Level of the keydir memory use, see memory_limit.go
+	memory         memoryState
+	memoryWatchers watchers[MemoryEvent]
+	// Timestamp of the newest record, the last value of the sequence of record timestamps, see sequence.go
+	lastTimestamp time.Time
+	// What Open did with a merge that was interrupted by a crash
+	mergeRecovery MergeRecovery
+	// Orphaned hint files found by Open, see hint_orphans.go
+	openOrphanHintFiles []string
+	// Id of the data file that Open reused as the active file (Options.ReuseLastFile), 0 if there was none
+	reusedFile int
+	// Operations in flight, and the state of Close, see close.go
+	gate *closeGate
+	// Writes the counters to the stats file, nil unless Options.StatsFlushInterval is set
+	statsFlusher *statsFlusher
+	// Writes the Puts in batches, nil unless Options.GroupCommit is set, see group_commit.go
+	committer *groupCommitter
+	// Background writes of the manifest, see manifest.go. manifestMu is held while the file is written
+	manifestMu      sync.Mutex
+	manifestDirty   chan struct{}
+	manifestStopped chan struct{}
+	// Signals the background writer of hint files that the active file was sealed, nil unless Options.HintsOnRotate
+	// is set, see hints.go
+	hintsPending chan struct{}
+	// true for datastores created by CreateInMemory, see memory.go
+	inMemory bool
 }
 
 const (
 	datastoreType          = "kvdb"            // Type of store
 	version                = "1.0.0"           // Version of the application
 	defaultMaxDatafileSize = 128 * 1000 * 1000 // In bytes (128 MB)
+
+	// Long operations that take a context check it after every cancelCheckInterval keys or records
+	cancelCheckInterval = 1024
+	// How often MergeContext checks the context while it waits for another merge to finish
+	mergeLockPollInterval = 10 * time.Millisecond
 )
 
 // Create creates a datastore at the given path, if the path exists and an existing key store
@@ -41,6 +93,40 @@ const (
 // is returned. Otherwise, the directory is created (along with all it's parents), and the datastore
 // is initialized
 func Create(fs afero.Fs, path string) (*DataStore, error) {
+	return CreateWithOptions(fs, path, nil)
+}
+
+// CreateWithOptions is like Create, but configures the datastore with the given options. If opts is nil, the default
+// options are used
+func CreateWithOptions(fs afero.Fs, path string, opts *Options) (*DataStore, error) {
+	return create(fs, path, opts.orDefault(), false)
+}
+
+// create implements CreateWithOptions and CreateInMemory, the metafile of an in-memory datastore is not written
+func create(fs afero.Fs, path string, options Options, inMemory bool) (*DataStore, error) {
+	// Count the files opened by the datastore, see Options.MaxOpenFiles
+	fs = filemanager.NewCountingFs(fs)
+	if options.MaxKeySize < 0 || options.MaxValueSize < 0 || options.MaxKeySize > math.MaxUint32 || options.MaxValueSize > math.MaxUint32 {
+		return nil, fmt.Errorf("invalid key or value size limit (%d, %d)", options.MaxKeySize, options.MaxValueSize)
+	}
+	recordFormat, err := options.recordFormat()
+	if err != nil {
+		return nil, err
+	}
+	checksum, err := options.checksum()
+	if err != nil {
+		return nil, err
+	}
+	keyring, err := options.keyring(fs)
+	if err != nil {
+		return nil, err
+	}
+	if err := options.checkHintOrphanPolicy(); err != nil {
+		return nil, err
+	}
+	if err := options.checkMaxOpenFiles(); err != nil {
+		return nil, err
+	}
 	// Check if it's a valid path to create a datastore
 	if valid, reason, err := metafile.IsValidPath(fs, path); err != nil || !valid {
 		if err != nil {
@@ -59,37 +145,153 @@ func Create(fs afero.Fs, path string) (*DataStore, error) {
 		Version:         version,
 		Created:         time.Now().String(),
 		MaxDatafileSize: defaultMaxDatafileSize,
+		MaxKeySize:      cmp.Or(options.MaxKeySize, record.DefaultLimits.MaxKeySize),
+		MaxValueSize:    cmp.Or(options.MaxValueSize, record.DefaultLimits.MaxValueSize),
+		ActiveDir:       options.ActiveDir,
+		MergedDir:       options.MergedDir,
+		HintDir:         options.HintDir,
 	}
-	// Write the metafile
-	if err := metafile.WriteMetaFile(fs, path, metainfo); err != nil {
+	// Make the data and hint folders (${path}/data and ${path}/hint, unless the options move them), before the metafile
+	// is written, so that a directory that can't be used does not leave a datastore behind
+	layout := layoutOf(path, metainfo)
+	if err := createLayout(fs, layout); err != nil {
 		return nil, err
 	}
 
-	// Make the data/ folder
-	if err := fs.Mkdir(filepath.Join(path, "data"), os.ModePerm); err != nil {
-		return nil, err
+	// Write the metafile
+	if !inMemory {
+		if err := metafile.WriteMetaFile(fs, path, metainfo); err != nil {
+			return nil, err
+		}
 	}
 
-	// Make the hint/ folder
-	if err := fs.Mkdir(filepath.Join(path, "hint"), os.ModePerm); err != nil {
+	fm, err := filemanager.NewFileManagerWithLayout(fs, layout, defaultMaxDatafileSize, options.fileIdAllocator())
+	if err != nil {
 		return nil, err
 	}
-
-	fm, err := filemanager.NewFileManager(fs, path, defaultMaxDatafileSize)
-	if err != nil {
+	profiler := lockprof.New(options.LockProfileRate)
+	fm.SetLockProfiler(profiler)
+	fm.SetLimits(limitsOf(metainfo))
+	fm.SetRecordFormat(recordFormat)
+	fm.SetChecksum(checksum)
+	fm.SetKeyring(keyring)
+	fm.SetMmapReads(options.MmapReads)
+	fm.SetPreallocate(options.PreallocateDataFiles)
+	fm.SetWriteBufferSize(options.WriteBufferSize)
+	fm.SetRotationLimits(options.MaxRecordsPerFile, options.MaxFileAge)
+	if err := fm.SetMaxOpenFiles(options.MaxOpenFiles, options.openFileExtra()); err != nil {
+		fm.Close()
 		return nil, err
 	}
-	return &DataStore{
-		fs:          fs,
-		path:        path,
-		metaInfo:    metainfo,
-		keydir:      keydir.NewKeydir(),
-		fileManager: fm,
-	}, nil
+	kd := keydir.NewKeydir()
+	setupValueCache(fm, kd, options.ValueCacheBytes)
+	setupLiveBytes(fm, kd)
+	dataStore := &DataStore{
+		fs:           fs,
+		path:         path,
+		metaInfo:     metainfo,
+		keydir:       kd,
+		fileManager:  fm,
+		options:      options,
+		lockProfiler: profiler,
+		gate:         newCloseGate(),
+		inMemory:     inMemory,
+	}
+	dataStore.setupStatsFlusher()
+	dataStore.setupGroupCommit()
+	dataStore.setupHintsOnRotate()
+	dataStore.setupManifest()
+	dataStore.setupWriteBuffer()
+	dataStore.setupRetention()
+	return dataStore, nil
+}
+
+// setupStatsFlusher restores the counters from the stats file, and starts flushing them, if
+// Options.StatsFlushInterval is set
+func (dataStore *DataStore) setupStatsFlusher() {
+	if dataStore.options.StatsFlushInterval <= 0 {
+		return
+	}
+	dataStore.statsFlusher = startStatsFlusher(dataStore.fs, dataStore.path, &dataStore.counters,
+		dataStore.options.StatsFlushInterval, dataStore.gate.ctx.Done())
+}
+
+// setupValueCache enables the value cache of the file manager if size is not 0, values are dropped from the cache when
+// their key is overwritten or deleted
+func setupValueCache(fm *filemanager.FileManager, kd *keydir.Keydir, size int64) {
+	if size <= 0 {
+		return
+	}
+	fm.SetValueCacheSize(size)
+	kd.SetOnRemove(func(rec keydir.KeydirRecord) {
+		fm.InvalidateValue(rec.FileId, rec.ValuePos)
+	})
+}
+
+// limitsOf returns the key and value size limits stored in the metafile, metafiles written before the limits were
+// configurable don't have them, and use the defaults
+func limitsOf(metainfo *metafile.MetaData) record.Limits {
+	return record.Limits{
+		MaxKeySize:   cmp.Or(metainfo.MaxKeySize, record.DefaultLimits.MaxKeySize),
+		MaxValueSize: cmp.Or(metainfo.MaxValueSize, record.DefaultLimits.MaxValueSize),
+	}
+}
+
+// MaxKeySize returns the largest key size (in bytes) accepted by the datastore
+func (dataStore *DataStore) MaxKeySize() int {
+	if !dataStore.opened() {
+		return 0
+	}
+	return limitsOf(dataStore.metaInfo).MaxKeySize
+}
+
+// MaxValueSize returns the largest value size (in bytes) accepted by the datastore
+func (dataStore *DataStore) MaxValueSize() int {
+	if !dataStore.opened() {
+		return 0
+	}
+	return limitsOf(dataStore.metaInfo).MaxValueSize
 }
 
 // Open opens the datastore at the specified location. If the datastore does not exist, an error is returned
 func Open(fs afero.Fs, path string) (*DataStore, error) {
+	return OpenWithOptions(fs, path, nil)
+}
+
+// OpenWithOptions is like Open, but configures the datastore with the given options. If opts is nil, the default
+// options are used
+func OpenWithOptions(fs afero.Fs, path string, opts *Options) (*DataStore, error) {
+	return OpenWithContext(context.Background(), fs, path, opts)
+}
+
+// OpenWithContext is like OpenWithOptions, but stops opening the datastore and returns the context's error if ctx is
+// done before the keydir is built, which takes most of the time it takes to open a large datastore. Nothing is
+// written while the keydir is built, so the datastore can be opened again later. Options.OpenProgress is called while
+// the data files are loaded
+func OpenWithContext(ctx context.Context, fs afero.Fs, path string, opts *Options) (*DataStore, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	options := opts.orDefault()
+	fs = filemanager.NewCountingFs(fs)
+	recordFormat, err := options.recordFormat()
+	if err != nil {
+		return nil, err
+	}
+	checksum, err := options.checksum()
+	if err != nil {
+		return nil, err
+	}
+	keyring, err := options.keyring(fs)
+	if err != nil {
+		return nil, err
+	}
+	if err := options.checkHintOrphanPolicy(); err != nil {
+		return nil, err
+	}
+	if err := options.checkMaxOpenFiles(); err != nil {
+		return nil, err
+	}
 	exists, err := metafile.IsDatastore(fs, path)
 	if err != nil {
 		return nil, err
@@ -107,28 +309,91 @@ func Open(fs afero.Fs, path string) (*DataStore, error) {
 		return nil, errors.New("metafile corrupted, not a kvdb")
 	}
 
-	fm, err := filemanager.NewFileManager(fs, path, metainfo.MaxDatafileSize)
+	// Finish a merge that was interrupted by a crash before the data directory is read
+	layout := layoutOf(path, metainfo)
+	recovery, err := recoverMerge(fs, path, layout)
+	if err != nil {
+		return nil, err
+	}
+
+	fm, err := filemanager.NewFileManagerWithLayout(fs, layout, metainfo.MaxDatafileSize, options.fileIdAllocator())
 	if err != nil {
 		return nil, err
 	}
-	kd, err := fm.ReadKeydir()
+	fm.SetLimits(limitsOf(metainfo))
+	fm.SetRecordFormat(recordFormat)
+	fm.SetChecksum(checksum)
+	fm.SetKeyring(keyring)
+	fm.SetMmapReads(options.MmapReads)
+	fm.SetPreallocate(options.PreallocateDataFiles)
+	fm.SetWriteBufferSize(options.WriteBufferSize)
+	fm.SetRotationLimits(options.MaxRecordsPerFile, options.MaxFileAge)
+	if err := fm.SetMaxOpenFiles(options.MaxOpenFiles, options.openFileExtra()); err != nil {
+		fm.Close()
+		return nil, err
+	}
+	kd, err := fm.ReadKeydirContext(ctx, openProgressFn(options.OpenProgress))
 	if err != nil {
+		fm.Close()
+		return nil, err
+	}
+	reusedFile := 0
+	if options.ReuseLastFile {
+		reused, err := fm.ReuseLastFile()
+		if err != nil {
+			fm.Close()
+			return nil, err
+		}
+		if reused {
+			reusedFile = fm.GetActiveFileId()
+		}
+	}
+	restoreManifest(fs, path, fm)
+	setupValueCache(fm, kd, options.ValueCacheBytes)
+	setupLiveBytes(fm, kd)
+	profiler := lockprof.New(options.LockProfileRate)
+	fm.SetLockProfiler(profiler)
+	dataStore := &DataStore{
+		fs:            fs,
+		path:          path,
+		keydir:        kd,
+		metaInfo:      metainfo,
+		fileManager:   fm,
+		options:       options,
+		lockProfiler:  profiler,
+		mergeRecovery: recovery,
+		reusedFile:    reusedFile,
+		gate:          newCloseGate(),
+		lastTimestamp: fm.MaxTimestamp(),
+	}
+	if err := dataStore.openHintOrphans(); err != nil {
+		fm.Close()
 		return nil, err
 	}
-	return &DataStore{
-		fs:          fs,
-		path:        path,
-		keydir:      kd,
-		metaInfo:    metainfo,
-		fileManager: fm,
-	}, nil
+	dataStore.setupStatsFlusher()
+	dataStore.setupGroupCommit()
+	dataStore.setupHintsOnRotate()
+	dataStore.setupManifest()
+	dataStore.setupWriteBuffer()
+	dataStore.setupRetention()
+	return dataStore, nil
 }
 
 // Get returns the value associated with the key. If the key does not exist, `ErrNotFound` is returned, in case of any
 // other errors, the error is returned
 func (dataStore *DataStore) Get(key []byte) ([]byte, error) {
-	dataStore.mu.RLock()
+	if err := dataStore.gate.enter(); err != nil {
+		return nil, err
+	}
+	defer dataStore.gate.exit()
+	dataStore.lockProfiler.Lock(dataStore.mu.RLocker(), "datastore.get")
 	defer dataStore.mu.RUnlock()
+	dataStore.counters.gets.Add(1)
+	return dataStore.get(key)
+}
+
+// get reads the value of the key, the caller must hold the lock
+func (dataStore *DataStore) get(key []byte) ([]byte, error) {
 	rec, ok := dataStore.keydir.GetKeydirRecord(key)
 	if !ok {
 		return nil, ErrKeyNotFound
@@ -140,60 +405,429 @@ func (dataStore *DataStore) Get(key []byte) ([]byte, error) {
 	return record.Value, nil
 }
 
+// GetInto is like Get, but reads the value into dst, and returns it. dst is reused if it's large enough (the value is
+// dst[:len(value)]), otherwise a larger slice is allocated, as with append. Servers that read many values can keep a
+// buffer per connection, so that a Get does not allocate
+func (dataStore *DataStore) GetInto(key []byte, dst []byte) ([]byte, error) {
+	if err := dataStore.gate.enter(); err != nil {
+		return dst[:0], err
+	}
+	defer dataStore.gate.exit()
+	dataStore.lockProfiler.Lock(dataStore.mu.RLocker(), "datastore.get")
+	defer dataStore.mu.RUnlock()
+	dataStore.counters.gets.Add(1)
+	rec, ok := dataStore.keydir.GetKeydirRecord(key)
+	if !ok {
+		return dst[:0], ErrKeyNotFound
+	}
+	return dataStore.fileManager.ReadValueInto(rec.FileId, rec.ValuePos, dst)
+}
+
 // Put sets the value for the specified key. It returns an error if the operation was not successful
 func (dataStore *DataStore) Put(key []byte, value []byte) error {
-	dataStore.mu.Lock()
+	if err := dataStore.gate.enter(); err != nil {
+		return err
+	}
+	defer dataStore.gate.exit()
+	if dataStore.committer != nil {
+		return dataStore.committer.put(key, value)
+	}
+	dataStore.lockForWrite("datastore.put")
 	defer dataStore.mu.Unlock()
-	fileId, offset, err := dataStore.fileManager.Write(key, value, false)
+	return dataStore.put(key, value)
+}
+
+// PutWithTimestamp is like Put, but the record gets the timestamp ts (with microsecond precision, as it's stored)
+// instead of the current time. It's meant for replicas, which keep the timestamps of the primary's records, so that
+// files merged on the primary can be adopted with AdoptOptions.OnlyMatching
+func (dataStore *DataStore) PutWithTimestamp(key []byte, value []byte, ts time.Time) error {
+	if err := dataStore.gate.enter(); err != nil {
+		return err
+	}
+	defer dataStore.gate.exit()
+	dataStore.lockForWrite("datastore.put")
+	defer dataStore.mu.Unlock()
+	return dataStore.putAt(key, value, ts)
+}
+
+// checkWrite returns ErrNilKey, ErrKeyTooLarge or ErrValueTooLarge if the key and value can't be written, so that an
+// invalid write is rejected before anything (including a new data file) is written
+func (dataStore *DataStore) checkWrite(key []byte, value []byte) error {
+	if key == nil {
+		return ErrNilKey
+	}
+	if maxKeySize := dataStore.MaxKeySize(); len(key) > maxKeySize {
+		return fmt.Errorf("%w: %d bytes, the limit is %d", ErrKeyTooLarge, len(key), maxKeySize)
+	}
+	if maxValueSize := dataStore.MaxValueSize(); len(value) > maxValueSize {
+		return fmt.Errorf("%w: %d bytes, the limit is %d", ErrValueTooLarge, len(value), maxValueSize)
+	}
+	return nil
+}
+
+// put writes the key value pair and updates the keydir, the caller must hold the write lock
+func (dataStore *DataStore) put(key []byte, value []byte) error {
+	return dataStore.putAt(key, value, dataStore.nextTimestamp(key))
+}
+
+func (dataStore *DataStore) putAt(key []byte, value []byte, ts time.Time) error {
+	return dataStore.putRecord(key, value, ts, false, ValueTypeString)
+}
+
+// putRecord writes the key value pair with the timestamp ts, as a write-once key if writeOnce is true, and with the
+// value type t in the record header. The caller must hold the write lock
+func (dataStore *DataStore) putRecord(key []byte, value []byte, ts time.Time, writeOnce bool, t ValueType) error {
+	req, err := dataStore.preparePut(key, value)
 	if err != nil {
 		return err
 	}
-	dataStore.keydir.AddKeydirRecord(key, fileId, uint32(len(value)), offset-datafile.FileHeaderSize, time.Now())
+	header := dataStore.putHeader(ts, writeOnce, t)
+	fileId, offset, err := dataStore.fileManager.WriteRecordWithHeader(header, req.Key, req.Value)
+	if err == nil {
+		dataStore.putWritten(req, header, fileId, offset)
+	}
+	dataStore.runAfterInterceptors(req, err)
 	return err
 }
 
+// preparePut checks that the pair can be written, and runs the before interceptors. If the pair can't be written, the
+// after interceptors are run (if the before interceptors were) and the error is returned. The caller must hold the
+// write lock, write the record of the returned request, and run the after interceptors
+func (dataStore *DataStore) preparePut(key []byte, value []byte) (*WriteRequest, error) {
+	if err := dataStore.checkWrite(key, value); err != nil {
+		return nil, err
+	}
+	if err := dataStore.checkStall(); err != nil {
+		return nil, err
+	}
+	req := &WriteRequest{Type: WriteTypePut, Key: key, Value: value}
+	if err := dataStore.runBeforeInterceptors(req); err != nil {
+		return nil, err
+	}
+	// Interceptors can change the key and value
+	err := dataStore.checkWrite(req.Key, req.Value)
+	if err == nil {
+		err = dataStore.checkImmutable(req.Key)
+	}
+	if err == nil {
+		err = dataStore.checkMemory(req.Key)
+	}
+	if err != nil {
+		dataStore.runAfterInterceptors(req, err)
+		return nil, err
+	}
+	return req, nil
+}
+
+// putHeader returns the header of a put record with the timestamp ts, see putRecord
+func (dataStore *DataStore) putHeader(ts time.Time, writeOnce bool, t ValueType) record.Header {
+	recordType := uint8(record.RecordTypePut)
+	if writeOnce {
+		recordType = record.RecordTypePutImmutable
+	}
+	// The keydir keeps the timestamp with the precision it's stored with, so that it's the same after a restart
+	ts = time.UnixMicro(ts.UnixMicro())
+	dataStore.observeTimestamp(ts)
+	return record.Header{Timestamp: ts, RecordType: recordType, ValueType: headerValueType(t)}
+}
+
+// putWritten updates the keydir and notifies the watchers after the record of req was written with header at the
+// offset (from the start of the file) of the data file
+func (dataStore *DataStore) putWritten(req *WriteRequest, header record.Header, fileId int, offset int64) {
+	dataStore.appendSignal.notify()
+	rec := keydir.KeydirRecord{
+		FileId:    fileId,
+		ValueSize: uint32(len(req.Value)),
+		ValuePos:  offset - datafile.FileHeaderSize,
+		Timestamp: header.Timestamp,
+		Immutable: header.RecordType == record.RecordTypePutImmutable,
+		ValueType: header.ValueType,
+	}
+	dataStore.keydir.Add(req.Key, rec)
+	dataStore.updateMemory()
+	dataStore.counters.puts.Add(1)
+	dataStore.watchers.notify(WatchEvent{Type: WriteTypePut, Key: req.Key, Value: req.Value, FileId: fileId,
+		Offset: rec.ValuePos, Timestamp: rec.Timestamp, ValueType: valueTypeOf(rec)})
+}
+
 // Delete deletes the value associated with the specified key. No error will be returned if the key does not exist.
 // An error is returned if the deletion failed due to some other reason.
 func (dataStore *DataStore) Delete(key []byte) error {
-	dataStore.mu.Lock()
-	defer dataStore.mu.Unlock()
-	_, _, err := dataStore.fileManager.Write(key, nil, true)
-	if err != nil {
+	if err := dataStore.gate.enter(); err != nil {
 		return err
 	}
-	dataStore.keydir.DeleteRecord(key)
+	defer dataStore.gate.exit()
+	dataStore.lockForWrite("datastore.delete")
+	defer dataStore.mu.Unlock()
+	_, err := dataStore.deleteKey(key)
 	return err
 }
 
-// DeleteWithExists deletes the value associated with the specified key. No error will be returned if the key does not exist.
-// An error is returned if the deletion failed due to some other reason.
-// true is returned if the key existed, and false if the key did not exist
-func (dataStore *DataStore) DeleteWithExists(key []byte) (bool, error) {
-	dataStore.mu.Lock()
+// DeleteWithTimestamp is like Delete, but the tombstone gets the timestamp ts instead of the current time, see
+// PutWithTimestamp
+func (dataStore *DataStore) DeleteWithTimestamp(key []byte, ts time.Time) error {
+	if err := dataStore.gate.enter(); err != nil {
+		return err
+	}
+	defer dataStore.gate.exit()
+	dataStore.lockForWrite("datastore.delete")
 	defer dataStore.mu.Unlock()
+	_, err := dataStore.deleteKeyAt(key, ts)
+	return err
+}
+
+// deleteKey writes a tombstone for the key and removes it from the keydir, the caller must hold the write lock.
+// It returns true if the key existed before deletion
+func (dataStore *DataStore) deleteKey(key []byte) (bool, error) {
+	return dataStore.deleteKeyAt(key, dataStore.nextTimestamp(key))
+}
+
+func (dataStore *DataStore) deleteKeyAt(key []byte, ts time.Time) (bool, error) {
+	if err := dataStore.checkWrite(key, nil); err != nil {
+		return false, err
+	}
+	if err := dataStore.checkStall(); err != nil {
+		return false, err
+	}
+	req := &WriteRequest{Type: WriteTypeDelete, Key: key}
+	if err := dataStore.runBeforeInterceptors(req); err != nil {
+		return false, err
+	}
+	if err := dataStore.checkWrite(req.Key, nil); err != nil {
+		dataStore.runAfterInterceptors(req, err)
+		return false, err
+	}
+	if err := dataStore.checkImmutable(req.Key); err != nil {
+		dataStore.runAfterInterceptors(req, err)
+		return false, err
+	}
 	// TODO: Check if we should write a record if the did not exist ?
 	// i.e. should the keydir check below come first
-	_, _, err := dataStore.fileManager.Write(key, nil, true)
+	ts = time.UnixMicro(ts.UnixMicro())
+	dataStore.observeTimestamp(ts)
+	fileId, offset, err := dataStore.fileManager.WriteWithTs(req.Key, nil, true, ts)
+	existed := false
+	if err == nil {
+		dataStore.appendSignal.notify()
+		existed = dataStore.keydir.DeleteRecordWithExists(req.Key)
+		dataStore.counters.deletes.Add(1)
+		dataStore.updateMemory()
+		if existed {
+			dataStore.watchers.notify(WatchEvent{Type: WriteTypeDelete, Key: req.Key, FileId: fileId, Offset: offset - datafile.FileHeaderSize, Timestamp: ts})
+		}
+	}
+	dataStore.runAfterInterceptors(req, err)
+	return existed, err
+}
+
+// DeleteWithExists deletes the value associated with the specified key, and returns true if the key existed, and false
+// if it did not (for the count of a DEL command). No error will be returned if the key does not exist, an error is
+// returned if the deletion failed due to some other reason
+func (dataStore *DataStore) DeleteWithExists(key []byte) (bool, error) {
+	if err := dataStore.gate.enter(); err != nil {
+		return false, err
+	}
+	defer dataStore.gate.exit()
+	dataStore.lockForWrite("datastore.delete")
+	defer dataStore.mu.Unlock()
+	return dataStore.deleteKey(key)
+}
+
+// GetDelete returns the value associated with the key, and deletes the key. Both happen under a single lock
+// acquisition, so no other write can happen in between. If the key does not exist, `ErrKeyNotFound` is returned, and
+// nothing is written
+func (dataStore *DataStore) GetDelete(key []byte) ([]byte, error) {
+	if err := dataStore.gate.enter(); err != nil {
+		return nil, err
+	}
+	defer dataStore.gate.exit()
+	dataStore.lockForWrite("datastore.get_delete")
+	defer dataStore.mu.Unlock()
+	value, err := dataStore.get(key)
 	if err != nil {
+		return nil, err
+	}
+	dataStore.counters.gets.Add(1)
+	if _, err := dataStore.deleteKey(key); err != nil {
+		return nil, err
+	}
+	return value, nil
+}
+
+// GetSet sets the value for the key, and returns the previous value. Both happen under a single lock acquisition, so no
+// other write can happen in between. existed is false (and old is nil) if the key did not exist before
+func (dataStore *DataStore) GetSet(key []byte, value []byte) (old []byte, existed bool, err error) {
+	if err := dataStore.gate.enter(); err != nil {
+		return nil, false, err
+	}
+	defer dataStore.gate.exit()
+	dataStore.lockForWrite("datastore.get_set")
+	defer dataStore.mu.Unlock()
+	old, err = dataStore.get(key)
+	if err == nil {
+		existed = true
+		dataStore.counters.gets.Add(1)
+	} else if !errors.Is(err, ErrKeyNotFound) {
+		return nil, false, err
+	}
+	if err := dataStore.put(key, value); err != nil {
+		return nil, false, err
+	}
+	return old, existed, nil
+}
+
+// PutIfAbsent sets the value of the key only if the key does not exist, and returns true if it was written. The check
+// and the write happen under a single lock acquisition, so of the PutIfAbsents of a key that run at the same time,
+// only one writes it. Nothing is written if the key exists
+func (dataStore *DataStore) PutIfAbsent(key []byte, value []byte) (bool, error) {
+	if err := dataStore.gate.enter(); err != nil {
 		return false, err
 	}
-	return dataStore.keydir.DeleteRecordWithExists(key), nil
+	defer dataStore.gate.exit()
+	dataStore.lockForWrite("datastore.put_if_absent")
+	defer dataStore.mu.Unlock()
+	if _, exists := dataStore.keydir.GetKeydirRecord(key); exists {
+		return false, nil
+	}
+	if err := dataStore.put(key, value); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// PutWithPrevious is like GetSet, but only returns the previous value, which is nil if the key did not exist. A previous
+// value that is empty is returned as an empty slice that is not nil, so that the two can be told apart
+func (dataStore *DataStore) PutWithPrevious(key []byte, value []byte) ([]byte, error) {
+	old, existed, err := dataStore.GetSet(key, value)
+	if err != nil {
+		return nil, err
+	}
+	if existed && old == nil {
+		old = []byte{}
+	}
+	return old, nil
 }
 
 // ListKeys returns a list of all keys in the datastore. Note: This is intended to be
 // used for debug or inspection.
 func (dataStore *DataStore) ListKeys() ([]string, error) {
+	if err := dataStore.gate.enter(); err != nil {
+		return nil, err
+	}
+	defer dataStore.gate.exit()
 	dataStore.mu.RLock()
 	defer dataStore.mu.RUnlock()
 	return dataStore.keydir.GetAllKeys(), nil
 }
+
+// ListKeysContext is like ListKeys, but stops and returns the context's error if ctx is done before all the keys are
+// copied. Writes are blocked while the keys are copied, so a deadline also bounds how long they wait
+func (dataStore *DataStore) ListKeysContext(ctx context.Context) ([]string, error) {
+	if err := dataStore.gate.enter(); err != nil {
+		return nil, err
+	}
+	defer dataStore.gate.exit()
+	dataStore.mu.RLock()
+	defer dataStore.mu.RUnlock()
+	keys := make([]string, 0, dataStore.keydir.Size())
+	var err error
+	dataStore.keydir.Range(func(key string, _ keydir.KeydirRecord) bool {
+		if len(keys)%cancelCheckInterval == 0 {
+			if err = ctx.Err(); err != nil {
+				return false
+			}
+		}
+		keys = append(keys, key)
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// Merge compacts all immutable data files, i.e. it rewrites the live records in them into new data files (along with
+// hint files), and deletes the old files. The active file is not merged. Reads and writes can continue while a merge is running
 func (dataStore *DataStore) Merge() error {
-	dataStore.mergeLock.Lock()
+	return dataStore.MergeContext(context.Background())
+}
+
+// MergeContext is like Merge, but the merge is cancelled if ctx is done before the merged files are committed (including
+// while it waits for another merge to finish). A cancelled merge removes the files it wrote, leaves the datastore as it
+// was, and returns the context's error. Once the merge starts renaming files, it runs to completion. Close cancels a
+// running merge in the same way, it returns ErrClosed
+func (dataStore *DataStore) MergeContext(ctx context.Context) error {
+	return dataStore.MergeWithOptions(ctx, nil)
+}
+
+// MergeWithOptions is MergeContext with options, opts can be nil
+func (dataStore *DataStore) MergeWithOptions(ctx context.Context, opts *MergeOptions) error {
+	if opts == nil {
+		opts = &MergeOptions{}
+	}
+	if err := dataStore.gate.enter(); err != nil {
+		return err
+	}
+	defer dataStore.gate.exit()
+	for !dataStore.mergeLock.TryLock() {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-dataStore.gate.ctx.Done():
+			return ErrClosed
+		case <-time.After(mergeLockPollInterval):
+		}
+	}
 	defer dataStore.mergeLock.Unlock()
-	immutableFiles, err := dataStore.fileManager.GetImmutableFiles()
+	dataStore.counters.mergeInProgress.Store(true)
+	defer dataStore.counters.mergeInProgress.Store(false)
+
+	start := time.Now()
+	event, inputBytes, err := dataStore.merge(ctx, opts.Progress)
+	dataStore.counters.recordMerge(start, inputBytes, err)
 	if err != nil {
 		return err
 	}
+	dataStore.mergeHintOrphans()
+	// The merge removed data files, which can end a stall
+	dataStore.mu.Lock()
+	dataStore.updateStall()
+	dataStore.mu.Unlock()
+	dataStore.mergeWatchers.notify(event)
+	return nil
+}
+
+// mergeCancelled returns the context's error if ctx is done, and ErrClosed if the datastore is being closed
+func (dataStore *DataStore) mergeCancelled(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if dataStore.gate.ctx.Err() != nil {
+		return ErrClosed
+	}
+	return nil
+}
+
+// merge implements Merge, the caller must hold the merge lock. It also returns the total size of the merged files.
+// progressFn may be nil
+func (dataStore *DataStore) merge(ctx context.Context, progressFn func(MergeProgress)) (MergeEvent, int64, error) {
+	immutableFiles, err := dataStore.fileManager.GetImmutableFiles()
+	if err != nil {
+		return MergeEvent{}, 0, err
+	}
+	if progressFn == nil {
+		progressFn = func(MergeProgress) {}
+	}
+	progress := MergeProgress{TotalFiles: len(immutableFiles)}
+	layout := dataStore.fileManager.Layout()
+	fileSizes := make(map[int]int64, len(immutableFiles))
+	for _, dataFile := range immutableFiles {
+		if info, err := dataStore.fs.Stat(dataStore.fileManager.DataFilePath(dataFile)); err == nil {
+			fileSizes[dataFile] = info.Size()
+			progress.TotalBytes += info.Size()
+		}
+	}
+	inputBytes := progress.TotalBytes
 
 	type valueLoc struct {
 		path         string
@@ -202,21 +836,67 @@ func (dataStore *DataStore) Merge() error {
 		sourceFileId int
 	}
 	valueLocations := map[string]valueLoc{}
+	// Values of PutContent without references, which are not copied, see content.go
+	droppedContent := map[string]keydir.KeydirRecord{}
 	mergeWriter, err := dataStore.fileManager.NewMergeWriter()
 	if err != nil {
-		return err
+		return MergeEvent{}, 0, err
 	}
 	defer mergeWriter.Close()
 
 	var currentHintWriter *hintfile.Writer
 	var lastDataFilePath string = ""
+	// Hashes of the keys written to every file, for it's bloom filter
+	var bloomHashes map[string][]uint64
+	if dataStore.options.MergeBloomFilters {
+		bloomHashes = map[string][]uint64{}
+	}
 
-	for _, dataFile := range immutableFiles {
-		filePath := filepath.Join(dataStore.path, "data", utils.GetDataFileName(dataFile))
-		scanner, err := record.NewScanner(dataStore.fs, filePath)
+	// Remove the temporary files if the merge is cancelled before it's committed
+	committed := false
+	defer func() {
+		if !committed {
+			if currentHintWriter != nil {
+				currentHintWriter.Close()
+			}
+			mergeWriter.Close()
+			for _, mergeFilePath := range mergeWriter.GetFilePaths() {
+				dataStore.fs.Remove(mergeFilePath)
+				dataStore.fs.Remove(layout.HintPath(filepath.Base(mergeFilePath)))
+				dataStore.fs.Remove(layout.HintPath(bloomFileName(mergeFilePath)))
+			}
+		}
+	}()
+
+	limits := limitsOf(dataStore.metaInfo)
+	keyring := dataStore.fileManager.Keyring()
+	// With MergeParallelism, the files are read ahead by a pool of workers, see merge_scan.go
+	var pool *mergeScanPool
+	if dataStore.options.MergeParallelism > 1 {
+		paths := make([]string, len(immutableFiles))
+		for i, dataFile := range immutableFiles {
+			paths[i] = dataStore.fileManager.DataFilePath(dataFile)
+		}
+		pool = newMergeScanPool(dataStore.fs, paths, limits, keyring, dataStore.options.MergeParallelism)
+		defer pool.close()
+	}
+
+	scanned := 0
+	// Bytes of the files that were scanned completely
+	var mergedFileBytes int64
+	for i, dataFile := range immutableFiles {
+		if err := dataStore.mergeCancelled(ctx); err != nil {
+			return MergeEvent{}, 0, err
+		}
+		filePath := dataStore.fileManager.DataFilePath(dataFile)
+		scanner, err := openMergeSource(dataStore.fs, pool, i, filePath, limits, keyring)
 		if err != nil {
 			// TODO: Skip this file from merge
 			fmt.Fprintf(os.Stderr, "Could not open file with id %d for merging\n", dataFile)
+			progress.MergedFiles++
+			mergedFileBytes += fileSizes[dataFile]
+			progress.ScannedBytes = mergedFileBytes
+			progressFn(progress)
 			continue
 		}
 
@@ -227,15 +907,29 @@ func (dataStore *DataStore) Merge() error {
 					break
 				}
 				// TODO: Skip this file
-				return err
+				scanner.Close()
+				return MergeEvent{}, 0, err
+			}
+			scanned++
+			if scanned%cancelCheckInterval == 0 {
+				if err := dataStore.mergeCancelled(ctx); err != nil {
+					scanner.Close()
+					return MergeEvent{}, 0, err
+				}
+			}
+			progress.ScannedRecords++
+			progress.ScannedBytes = mergedFileBytes + datafile.FileHeaderSize + offset + rec.Size
+			if scanned%mergeProgressInterval == 0 {
+				progressFn(progress)
 			}
 
 			// Check if the record is active
-			var exists bool
+			var exists, garbage bool
 			var kdRecord keydir.KeydirRecord
 
-			dataStore.mu.RLock()
+			dataStore.lockProfiler.Lock(dataStore.mu.RLocker(), "datastore.merge_lookup")
 			kdRecord, exists = dataStore.keydir.GetKeydirRecord(rec.Key)
+			garbage = exists && dataStore.isGarbageContent(rec.Key)
 			dataStore.mu.RUnlock()
 
 			// This record is stale, skip it
@@ -248,21 +942,32 @@ func (dataStore *DataStore) Merge() error {
 				continue
 			}
 
-			filePath, newPos, err := mergeWriter.WriteWithTs(rec.Key, rec.Value, false, rec.Header.Timestamp)
+			if garbage {
+				droppedContent[string(rec.Key)] = kdRecord
+				continue
+			}
+
+			filePath, newPos, err := mergeWriter.WriteRecordWithHeader(rec.Header, rec.Key, rec.Value)
 			if err != nil {
-				return err
+				return MergeEvent{}, 0, err
 			}
 
 			// If the file path has changed, we need to create a new hint file writer
 			if filePath != lastDataFilePath {
 				if currentHintWriter != nil {
-					currentHintWriter.Close()
+					err := currentHintWriter.Close()
+					currentHintWriter = nil
+					if err != nil && dataStore.options.StrictMergeSync {
+						return MergeEvent{}, 0, err
+					}
 				}
-				hintPath := filepath.Join(dataStore.path, "hint", filepath.Base(filePath))
+				hintPath := layout.HintPath(filepath.Base(filePath))
 				currentHintWriter, err = hintfile.NewWriter(dataStore.fs, hintPath)
 				if err != nil {
-					return err
+					return MergeEvent{}, 0, err
 				}
+				currentHintWriter.SetLimits(limitsOf(dataStore.metaInfo))
+				currentHintWriter.SetKeyring(keyring)
 				lastDataFilePath = filePath
 			}
 
@@ -271,11 +976,13 @@ func (dataStore *DataStore) Merge() error {
 				Timestamp: rec.Header.Timestamp,
 				KeySize:   rec.Header.KeySize,
 				ValueSize: rec.Header.ValueSize,
-				ValuePos:  newPos,
+				ValuePos:  newPos - datafile.FileHeaderSize,
 				Key:       rec.Key,
+				Immutable: rec.Header.RecordType == record.RecordTypePutImmutable,
+				ValueType: rec.Header.ValueType,
 			})
 			if err != nil {
-				return err
+				return MergeEvent{}, 0, err
 			}
 
 			valueLocations[string(rec.Key)] = valueLoc{
@@ -284,41 +991,114 @@ func (dataStore *DataStore) Merge() error {
 				ts:           rec.Header.Timestamp,
 				sourceFileId: dataFile,
 			}
+			progress.LiveRecords++
+			if bloomHashes != nil {
+				bloomHashes[filePath] = append(bloomHashes[filePath], bloom.Hash(rec.Key))
+			}
 		}
 		scanner.Close()
+		progress.MergedFiles++
+		mergedFileBytes += fileSizes[dataFile]
+		progress.ScannedBytes = mergedFileBytes
+		progressFn(progress)
 	}
 
+	// Closing the writers syncs the new data and hint files, with StrictMergeSync the merge fails if they could not be
+	// synced, otherwise the errors are ignored
+	var syncErr error
 	if currentHintWriter != nil {
-		currentHintWriter.Close()
+		syncErr = currentHintWriter.Close()
+		currentHintWriter = nil
+	}
+	if err := dataStore.mergeCancelled(ctx); err != nil {
+		return MergeEvent{}, 0, err
+	}
+	syncErr = errors.Join(syncErr, mergeWriter.Sync(), mergeWriter.Close())
+	if syncErr != nil && dataStore.options.StrictMergeSync {
+		return MergeEvent{}, 0, syncErr
 	}
-
-	// TODO: fsync the directory (after rename)
-	mergeWriter.Sync()
-	mergeWriter.Close()
 
 	tempFilesList := mergeWriter.GetFilePaths()
+	if bloomHashes != nil {
+		if err := writeMergeBloomFilters(dataStore.fs, layout, tempFilesList, bloomHashes); err != nil {
+			return MergeEvent{}, 0, err
+		}
+	}
 
 	// Get the write lock, reserve the file Ids
-	dataStore.mu.Lock()
-	startId := dataStore.fileManager.IncrementNextDataFileNumber(len(tempFilesList))
+	dataStore.lockProfiler.Lock(&dataStore.mu, "datastore.merge_commit")
+	startId, err := dataStore.fileManager.AllocateDataFileIds(len(tempFilesList))
 	dataStore.mu.Unlock()
+	if err != nil {
+		return MergeEvent{}, 0, err
+	}
+
+	// Record the renames and deletions below, so that they can be completed (or rolled back) when the datastore is
+	// opened after a crash, see merge_manifest.go
+	manifest := &mergeManifest{Inputs: immutableFiles}
+	for i, mergeFilePath := range tempFilesList {
+		manifest.Outputs = append(manifest.Outputs, mergeManifestOutput{Temp: filepath.Base(mergeFilePath), Id: startId + i})
+	}
+	if err := writeMergeManifest(dataStore.fs, dataStore.path, manifest); err != nil {
+		return MergeEvent{}, 0, err
+	}
+	if dataStore.options.StrictMergeSync {
+		// The manifest must survive a crash before any file is renamed
+		if err := syncDir(dataStore.fs, dataStore.path); err != nil {
+			removeMergeManifest(dataStore.fs, dataStore.path)
+			return MergeEvent{}, 0, err
+		}
+	}
+	// From here on, the temporary files are renamed (or removed by the recovery when the datastore is opened)
+	committed = true
 
 	// Now, rename all temporary files starting from startId
 	// Also rename hint files
 	realFileIds := make(map[string]int)
+	event := MergeEvent{RemovedFileIds: immutableFiles}
 	for i, mergeFilePath := range tempFilesList {
 		realId := startId + i
-		dataStore.fs.Rename(mergeFilePath, filepath.Join(dataStore.path, "data", utils.GetDataFileName(realId)))
+		// The hint file is renamed first, so that a merged data file never exists without it's hint file (TailLog uses
+		// this to tell merged files apart from log files)
+		if bloomHashes != nil {
+			bloomPath := layout.HintPath(bloomFileName(mergeFilePath))
+			if err := dataStore.fs.Rename(bloomPath, layout.HintPath(utils.GetBloomFileName(realId))); err != nil {
+				return MergeEvent{}, 0, err
+			}
+		}
+		hintPath := layout.HintPath(filepath.Base(mergeFilePath))
+		if err := dataStore.fs.Rename(hintPath, layout.HintPath(utils.GetHintFileName(realId))); err != nil {
+			// The merged files are still there, and the keydir still points to them. The manifest is left in place,
+			// so the merge is completed the next time the datastore is opened
+			return MergeEvent{}, 0, err
+		}
 
-		hintPath := filepath.Join(dataStore.path, "hint", filepath.Base(mergeFilePath))
-		dataStore.fs.Rename(hintPath, filepath.Join(dataStore.path, "hint", utils.GetHintFileName(realId)))
+		dataFilePath := filepath.Join(layout.MergedDir, utils.GetDataFileName(realId))
+		if err := dataStore.fs.Rename(mergeFilePath, dataFilePath); err != nil {
+			return MergeEvent{}, 0, err
+		}
+		if err := dataStore.fileManager.AddDataFile(realId, mergeWriter.FileMeta(mergeFilePath)); err != nil {
+			return MergeEvent{}, 0, err
+		}
+		event.Files = append(event.Files, dataFilePath)
 
 		// To be used when updating keydir
 		realFileIds[mergeFilePath] = realId
 	}
 
+	if dataStore.options.StrictMergeSync {
+		// The renames must survive a crash before the merged files are deleted. If the directories can't be synced, the
+		// merged files and the manifest are left in place, and the merge is completed when the datastore is opened
+		if err := syncDir(dataStore.fs, layout.HintDir); err != nil {
+			return MergeEvent{}, 0, err
+		}
+		if err := syncDir(dataStore.fs, layout.MergedDir); err != nil {
+			return MergeEvent{}, 0, err
+		}
+	}
+
 	// Get the write lock, and update keydir with new Ids
-	dataStore.mu.Lock()
+	dataStore.lockProfiler.Lock(&dataStore.mu, "datastore.merge_commit")
 
 	for key, loc := range valueLocations {
 		// Only update if the key in keydir is still pointing to old file (i.e. the value has not been updated)
@@ -326,47 +1106,60 @@ func (dataStore *DataStore) Merge() error {
 		current, exists := dataStore.keydir.GetKeydirRecord(keyBytes)
 		if exists && current.FileId == loc.sourceFileId {
 			realID := realFileIds[loc.path]
-			dataStore.keydir.AddKeydirRecord(keyBytes, realID, current.ValueSize, loc.offset-datafile.FileHeaderSize, current.Timestamp)
+			current.FileId, current.ValuePos = realID, loc.offset-datafile.FileHeaderSize
+			dataStore.keydir.Add(keyBytes, current)
 		}
 	}
+	dataStore.dropGarbageContent(droppedContent)
+	dataStore.updateMemory()
 	dataStore.mu.Unlock()
 
 	// Delete old immutable files & hints
-	for _, dataFile := range immutableFiles {
-		filePath := filepath.Join(dataStore.path, "data", utils.GetDataFileName(dataFile))
-		hintFilePath := filepath.Join(dataStore.path, "hint", utils.GetHintFileName(dataFile))
-		dataStore.fs.Remove(filePath)
-		dataStore.fs.Remove(hintFilePath)
+	dataStore.fileManager.RemoveDataFiles(immutableFiles)
+	if err := removeMergeManifest(dataStore.fs, dataStore.path); err != nil {
+		return MergeEvent{}, 0, err
 	}
+	dataStore.markManifestDirty()
 
-	dataStore.fileManager.CloseAndDeleteReaders(immutableFiles)
-
-	return nil
+	return event, inputBytes, nil
 }
 
 func (dataStore *DataStore) Sync() error {
+	if err := dataStore.gate.enter(); err != nil {
+		return err
+	}
+	defer dataStore.gate.exit()
 	dataStore.mu.Lock()
 	defer dataStore.mu.Unlock()
-	return dataStore.fileManager.Sync()
+	if err := dataStore.fileManager.Sync(); err != nil {
+		return err
+	}
+	dataStore.updateStall()
+	return nil
 }
 
 // Size returns the number of keys present in the datastore
 func (dataStore *DataStore) Size() int {
+	if !dataStore.opened() {
+		return 0
+	}
 	dataStore.mu.RLock()
 	defer dataStore.mu.RUnlock()
 	return dataStore.keydir.Size()
 }
 
-// Close closes the datastore, writes pending changes (if any), and frees resources
-func (dataStore *DataStore) Close() error {
-	dataStore.mu.Lock()
-	defer dataStore.mu.Unlock()
-	if err := dataStore.fileManager.Sync(); err != nil {
-		return err
+// RandomKey returns a random key of the datastore, without listing the keys, or ErrKeyNotFound if the datastore is
+// empty. Every key can be returned, but not with the same probability
+func (dataStore *DataStore) RandomKey() ([]byte, error) {
+	if err := dataStore.gate.enter(); err != nil {
+		return nil, err
 	}
-	err1 := dataStore.fileManager.Close()
-	if err1 != nil {
-		return err1
+	defer dataStore.gate.exit()
+	dataStore.mu.RLock()
+	defer dataStore.mu.RUnlock()
+	key, ok := dataStore.keydir.RandomKey()
+	if !ok {
+		return nil, ErrKeyNotFound
 	}
-	return nil
+	return []byte(key), nil
 }
@@ -14,11 +14,67 @@ func BenchmarkRead(b *testing.B) {
 	}
 	key := []byte("small key")
 	store.Put(key, []byte("The quick brown fox jumps over the lazy dogs"))
+	b.ReportAllocs()
 	for b.Loop() {
 		store.Get(key)
 	}
 }
 
+// BenchmarkReadInto is BenchmarkRead with GetInto and a reused buffer, compare the allocations with BenchmarkRead
+func BenchmarkReadInto(b *testing.B) {
+	testFS := afero.NewMemMapFs()
+	store, err := Create(testFS, "test_into.dat")
+	if err != nil {
+		b.Fatalf("could not create datastore %v", err)
+	}
+	key := []byte("small key")
+	store.Put(key, []byte("The quick brown fox jumps over the lazy dogs"))
+	var buf []byte
+	b.ReportAllocs()
+	for b.Loop() {
+		buf, _ = store.GetInto(key, buf)
+	}
+}
+
+// BenchmarkReadParallel reads from many goroutines while a writer updates another key, and reports the sampled wait
+// time per lock site (in ns per sampled acquisition)
+func BenchmarkReadParallel(b *testing.B) {
+	testFS := afero.NewMemMapFs()
+	store, err := CreateWithOptions(testFS, "test_parallel.dat", &Options{LockProfileRate: 64})
+	if err != nil {
+		b.Fatalf("could not create datastore %v", err)
+	}
+	defer store.Close()
+	key := []byte("small key")
+	store.Put(key, []byte("The quick brown fox jumps over the lazy dogs"))
+
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			default:
+				store.Put([]byte("other key"), []byte("value"))
+			}
+		}
+	}()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			store.Get(key)
+		}
+	})
+	close(done)
+
+	stats, err := store.Stats()
+	if err != nil {
+		b.Fatalf("stats failed: %v", err)
+	}
+	for _, site := range stats.LockContention {
+		b.ReportMetric(float64(site.MeanWait().Nanoseconds()), site.Site+"-wait-ns")
+	}
+}
+
 func BenchmarkWriteLargeData(b *testing.B) {
 	testFS := afero.NewMemMapFs()
 	store, err := Create(testFS, "test_write.dat")
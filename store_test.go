@@ -1,9 +1,18 @@
 package kvdb
 
 import (
+	"context"
 	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
+	"github.com/ananthvk/kvdb/internal/hintfile"
+	"github.com/ananthvk/kvdb/internal/record"
 	"github.com/spf13/afero"
 )
 
@@ -341,6 +350,108 @@ func TestMergeMultipleTimes(t *testing.T) {
 	}
 }
 
+func helperCreateMergedStore(t *testing.T, fs afero.Fs, path string) {
+	t.Helper()
+	store := helperCreateMultipleDataFiles(t, fs, path)
+	store.Put([]byte("key1"), []byte("value1"))
+	store.Put([]byte("key2"), []byte("value2"))
+	store.Close()
+	store, err := Open(fs, path)
+	if err != nil {
+		t.Fatalf("failed to reopen store: %v", err)
+	}
+	store.Put([]byte("key3"), []byte("value3"))
+	if err := store.Merge(); err != nil {
+		t.Fatalf("merge failed: %v", err)
+	}
+	store.Close()
+}
+
+func TestMergeReopenWithHints(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	helperCreateMergedStore(t, fs, "test_merge_reopen.db")
+
+	store, err := Open(fs, "test_merge_reopen.db")
+	if err != nil {
+		t.Fatalf("failed to reopen store: %v", err)
+	}
+	defer store.Close()
+	for _, key := range []string{"key1", "key2", "key3"} {
+		val, err := store.Get([]byte(key))
+		if err != nil || string(val) != "value"+key[3:] {
+			t.Errorf("%s: expected value%s, got %s (err: %v)", key, key[3:], val, err)
+		}
+	}
+}
+
+func TestStaleHintFileIsIgnored(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	helperCreateMergedStore(t, fs, "test_stale_hint.db")
+
+	hints, err := afero.Glob(fs, filepath.Join("test_stale_hint.db", "hint", "*"))
+	if err != nil || len(hints) != 1 {
+		t.Fatalf("expected 1 hint file, got %v (err: %v)", hints, err)
+	}
+	// Replace the hint file with one that does not describe the data file
+	fs.Remove(hints[0])
+	writer, err := hintfile.NewWriter(fs, hints[0])
+	if err != nil {
+		t.Fatalf("could not create hint file: %v", err)
+	}
+	writer.WriteHintRecord(&hintfile.HintRecord{Timestamp: time.Now(), KeySize: 5, ValueSize: 6, ValuePos: 0, Key: []byte("bogus")})
+	writer.Close()
+
+	store, err := Open(fs, "test_stale_hint.db")
+	if err != nil {
+		t.Fatalf("failed to reopen store: %v", err)
+	}
+	defer store.Close()
+	if _, err := store.Get([]byte("bogus")); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("expected ErrKeyNotFound for key from stale hint, got %v", err)
+	}
+	if report := store.OpenReport(); len(report.IgnoredHintFiles) != 1 {
+		t.Errorf("expected the hint file to be reported as ignored, got %+v", report)
+	}
+	for _, key := range []string{"key1", "key2", "key3"} {
+		val, err := store.Get([]byte(key))
+		if err != nil || string(val) != "value"+key[3:] {
+			t.Errorf("%s: expected value%s, got %s (err: %v)", key, key[3:], val, err)
+		}
+	}
+}
+
+func TestCorruptHintFileIsIgnored(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	helperCreateMergedStore(t, fs, "test_corrupt_hint.db")
+
+	hints, err := afero.Glob(fs, filepath.Join("test_corrupt_hint.db", "hint", "*.hint"))
+	if err != nil || len(hints) != 1 {
+		t.Fatalf("expected 1 hint file, got %v (err: %v)", hints, err)
+	}
+	// Flip a bit of the key of the last hint, so that it's checksum no longer matches
+	data, err := afero.ReadFile(fs, hints[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[len(data)-hintfile.HintChecksumSize-1] ^= 0x01
+	afero.WriteFile(fs, hints[0], data, 0666)
+
+	store, err := Open(fs, "test_corrupt_hint.db")
+	if err != nil {
+		t.Fatalf("failed to reopen store: %v", err)
+	}
+	defer store.Close()
+	if report := store.OpenReport(); len(report.IgnoredHintFiles) != 1 {
+		t.Errorf("expected the hint file to be reported as ignored, got %+v", report)
+	}
+	for _, key := range []string{"key1", "key2", "key3"} {
+		val, err := store.Get([]byte(key))
+		if err != nil || string(val) != "value"+key[3:] {
+			t.Errorf("%s: expected value%s, got %s (err: %v)", key, key[3:], val, err)
+		}
+	}
+}
+
 func TestStoreBasicTests(t *testing.T) {
 	fs := afero.NewMemMapFs()
 	store, err := Create(fs, "0.dat")
@@ -433,3 +544,444 @@ func TestStoreMultiple(t *testing.T) {
 		t.Errorf("expected final1, got %s", val)
 	}
 }
+
+func TestGetDelete(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	store := helperCreateMultipleDataFiles(t, fs, "test_get_delete.db")
+	defer store.Close()
+
+	if _, err := store.GetDelete([]byte("missing")); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("expected ErrKeyNotFound, got %v", err)
+	}
+	store.Put([]byte("key"), []byte("value"))
+	store.Sync()
+	sizeBefore, _ := store.fileManager.DataFileSize(store.fileManager.GetActiveFileId())
+
+	value, err := store.GetDelete([]byte("key"))
+	if err != nil || string(value) != "value" {
+		t.Fatalf("expected value, got %q, err %v", value, err)
+	}
+	if _, err := store.Get([]byte("key")); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("expected the key to be deleted, got %v", err)
+	}
+
+	// A single tombstone is appended
+	store.Sync()
+	sizeAfter, _ := store.fileManager.DataFileSize(store.fileManager.GetActiveFileId())
+	if sizeAfter-sizeBefore != record.FormatV1.EncodedSize(3, 0) {
+		t.Errorf("expected one tombstone to be written, file grew by %d bytes", sizeAfter-sizeBefore)
+	}
+}
+
+func TestGetDeleteConcurrentConsumers(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	store := helperCreateMultipleDataFiles(t, fs, "test_get_delete_concurrent.db")
+	defer store.Close()
+
+	const items = 200
+	for i := range items {
+		store.Put(fmt.Appendf(nil, "item%d", i), []byte("value"))
+	}
+	var wg sync.WaitGroup
+	var consumed atomic.Int64
+	for range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range items {
+				if _, err := store.GetDelete(fmt.Appendf(nil, "item%d", i)); err == nil {
+					consumed.Add(1)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if consumed.Load() != items {
+		t.Errorf("expected every item to be consumed exactly once, consumed %d", consumed.Load())
+	}
+}
+
+func TestGetSet(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	store := helperCreateMultipleDataFiles(t, fs, "test_get_set.db")
+	defer store.Close()
+
+	old, existed, err := store.GetSet([]byte("key"), []byte("value1"))
+	if err != nil || existed || old != nil {
+		t.Fatalf("expected no previous value, got %q, %v, err %v", old, existed, err)
+	}
+	old, existed, err = store.GetSet([]byte("key"), []byte("value2"))
+	if err != nil || !existed || string(old) != "value1" {
+		t.Fatalf("expected value1, got %q, %v, err %v", old, existed, err)
+	}
+	value, err := store.Get([]byte("key"))
+	if err != nil || string(value) != "value2" {
+		t.Errorf("expected value2, got %q, err %v", value, err)
+	}
+}
+
+func TestGetSetConcurrentWriters(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	store := helperCreateMultipleDataFiles(t, fs, "test_get_set_concurrent.db")
+	defer store.Close()
+
+	// Every value that is set is returned as the previous value exactly once (or is the final value), if no two GetSets
+	// see the same previous value
+	store.Put([]byte("key"), []byte("initial"))
+	var mu sync.Mutex
+	seen := map[string]int{}
+	var wg sync.WaitGroup
+	for w := range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range 100 {
+				old, existed, err := store.GetSet([]byte("key"), fmt.Appendf(nil, "%d-%d", w, i))
+				if err != nil || !existed {
+					t.Errorf("expected a previous value, got %v, err %v", existed, err)
+					return
+				}
+				mu.Lock()
+				seen[string(old)]++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	final, _ := store.Get([]byte("key"))
+	seen[string(final)]++
+	if len(seen) != 8*100+1 {
+		t.Errorf("expected %d distinct values, got %d", 8*100+1, len(seen))
+	}
+	for value, count := range seen {
+		if count != 1 {
+			t.Errorf("value %q was seen %d times", value, count)
+		}
+	}
+}
+
+func TestPutIfAbsent(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	store := helperCreateMultipleDataFiles(t, fs, "test_put_if_absent.db")
+	defer store.Close()
+
+	var wg sync.WaitGroup
+	var written atomic.Int64
+	for w := range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ok, err := store.PutIfAbsent([]byte("lock"), fmt.Appendf(nil, "owner%d", w))
+			if err != nil {
+				t.Errorf("put if absent failed: %v", err)
+			}
+			if ok {
+				written.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+	if written.Load() != 1 {
+		t.Errorf("expected exactly one writer to win, got %d", written.Load())
+	}
+	value, err := store.Get([]byte("lock"))
+	if err != nil || !strings.HasPrefix(string(value), "owner") {
+		t.Errorf("expected the value of the winner, got %q, err %v", value, err)
+	}
+
+	// The key can be written again once it's deleted
+	store.Delete([]byte("lock"))
+	if ok, err := store.PutIfAbsent([]byte("lock"), []byte("again")); err != nil || !ok {
+		t.Errorf("expected the deleted key to be written, got %v, err %v", ok, err)
+	}
+}
+
+func TestPutWithPrevious(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	store := helperCreateMultipleDataFiles(t, fs, "test_put_with_previous.db")
+	defer store.Close()
+
+	old, err := store.PutWithPrevious([]byte("key"), []byte{})
+	if err != nil || old != nil {
+		t.Fatalf("expected no previous value, got %q, err %v", old, err)
+	}
+	old, err = store.PutWithPrevious([]byte("key"), []byte("value"))
+	if err != nil || old == nil || len(old) != 0 {
+		t.Fatalf("expected an empty previous value that is not nil, got %#v, err %v", old, err)
+	}
+	old, err = store.PutWithPrevious([]byte("key"), []byte("value2"))
+	if err != nil || string(old) != "value" {
+		t.Fatalf("expected value, got %q, err %v", old, err)
+	}
+	if _, err := store.PutWithPrevious(make([]byte, store.MaxKeySize()+1), []byte("value")); !errors.Is(err, ErrKeyTooLarge) {
+		t.Errorf("expected ErrKeyTooLarge, got %v", err)
+	}
+}
+
+func TestDefaultSizeLimits(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	store := helperCreateMultipleDataFiles(t, fs, "test_default_limits.db")
+	defer store.Close()
+
+	if err := store.Put(make([]byte, store.MaxKeySize()+1), []byte("value")); !errors.Is(err, ErrKeyTooLarge) {
+		t.Errorf("expected ErrKeyTooLarge, got %v", err)
+	}
+	if err := store.Put([]byte("key"), make([]byte, store.MaxValueSize()+1)); !errors.Is(err, ErrValueTooLarge) {
+		t.Errorf("expected ErrValueTooLarge, got %v", err)
+	}
+}
+
+func TestInvalidWritesRejectedBeforeWriting(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	var befores int
+	var afterErr error
+	grow := WriteInterceptor{
+		Name: "grow",
+		Before: func(req *WriteRequest) error {
+			befores++
+			if string(req.Key) == "grow" {
+				req.Value = []byte("more than eight bytes")
+			}
+			return nil
+		},
+		After: func(req *WriteRequest, err error) { afterErr = err },
+	}
+	store, err := CreateWithOptions(fs, "test_invalid_writes.db", &Options{MaxKeySize: 8, MaxValueSize: 8, Interceptors: []WriteInterceptor{grow}})
+	if err != nil {
+		t.Fatalf("error creating datastore: %v", err)
+	}
+	defer store.Close()
+
+	if err := store.Put(nil, []byte("value")); !errors.Is(err, ErrNilKey) {
+		t.Errorf("expected ErrNilKey, got %v", err)
+	}
+	if err := store.Put([]byte("a long key"), []byte("value")); !errors.Is(err, ErrKeyTooLarge) {
+		t.Errorf("expected ErrKeyTooLarge, got %v", err)
+	}
+	if err := store.Put([]byte("key"), []byte("a long value")); !errors.Is(err, ErrValueTooLarge) {
+		t.Errorf("expected ErrValueTooLarge, got %v", err)
+	}
+	if err := store.Delete(nil); !errors.Is(err, ErrNilKey) {
+		t.Errorf("expected ErrNilKey for a delete, got %v", err)
+	}
+	if _, err := store.DeleteWithExists([]byte("a long key")); !errors.Is(err, ErrKeyTooLarge) {
+		t.Errorf("expected ErrKeyTooLarge for a delete, got %v", err)
+	}
+	if befores != 0 {
+		t.Errorf("expected invalid writes to be rejected before the interceptors, got %d calls", befores)
+	}
+	// A value made too large by an interceptor is rejected too
+	if err := store.Put([]byte("grow"), []byte("value")); !errors.Is(err, ErrValueTooLarge) || !errors.Is(afterErr, ErrValueTooLarge) {
+		t.Errorf("expected ErrValueTooLarge after the interceptor, got %v (after: %v)", err, afterErr)
+	}
+
+	// Data files are created by the first write, nothing was written
+	if entries, _ := afero.ReadDir(fs, filepath.Join("test_invalid_writes.db", "data")); len(entries) != 0 {
+		t.Errorf("expected no data files, got %d", len(entries))
+	}
+	if stats, _ := store.Stats(); stats.Puts != 0 || stats.Deletes != 0 {
+		t.Errorf("expected no writes to be counted, got %d puts and %d deletes", stats.Puts, stats.Deletes)
+	}
+	// Empty keys are valid
+	if err := store.Put([]byte{}, []byte("value")); err != nil {
+		t.Errorf("expected an empty key to be written, got %v", err)
+	}
+}
+
+func TestConfiguredSizeLimits(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	path := "test_configured_limits.db"
+	store, err := CreateWithOptions(fs, path, &Options{MaxKeySize: 2000, MaxValueSize: 4 * 1000 * 1000})
+	if err != nil {
+		t.Fatalf("error creating datastore: %v", err)
+	}
+	bigKey := make([]byte, 1500)
+	bigValue := make([]byte, 2*1000*1000)
+	bigValue[len(bigValue)-1] = 'x'
+	if err := store.Put(bigKey, bigValue); err != nil {
+		t.Fatalf("expected the value to be within the limits, got %v", err)
+	}
+	if err := store.Put([]byte("key"), make([]byte, 4*1000*1000+1)); !errors.Is(err, ErrValueTooLarge) {
+		t.Errorf("expected ErrValueTooLarge, got %v", err)
+	}
+	store.Close()
+
+	// The limits are read from the metafile, and are used to read the data files and the hint files written by merge
+	store, err = Open(fs, path)
+	if err != nil {
+		t.Fatalf("failed to reopen store: %v", err)
+	}
+	if store.MaxKeySize() != 2000 || store.MaxValueSize() != 4*1000*1000 {
+		t.Errorf("expected the limits from the metafile, got %d and %d", store.MaxKeySize(), store.MaxValueSize())
+	}
+	store.Put([]byte("key"), []byte("value"))
+	if err := store.Merge(); err != nil {
+		t.Fatalf("merge failed: %v", err)
+	}
+	store.Close()
+
+	store, err = Open(fs, path)
+	if err != nil {
+		t.Fatalf("failed to reopen store: %v", err)
+	}
+	defer store.Close()
+	if report := store.OpenReport(); len(report.IgnoredHintFiles) != 0 || len(report.InvalidDataFiles) != 0 {
+		t.Errorf("expected every file to be read, got %+v", report)
+	}
+	value, err := store.Get(bigKey)
+	if err != nil || len(value) != len(bigValue) || value[len(value)-1] != 'x' {
+		t.Errorf("expected the large value, got %d bytes (err: %v)", len(value), err)
+	}
+}
+
+func TestCreateRejectsInvalidSizeLimits(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	if _, err := CreateWithOptions(fs, "test_invalid_limits.db", &Options{MaxValueSize: -1}); err == nil {
+		t.Errorf("expected an error for a negative limit")
+	}
+	if exists, _ := afero.Exists(fs, "test_invalid_limits.db"); exists {
+		t.Errorf("expected the datastore not to be created")
+	}
+}
+
+// cancelAfterContext is a context whose Err starts returning context.Canceled after it has been called n times, to
+// cancel an operation at a deterministic point
+type cancelAfterContext struct {
+	context.Context
+	n int
+}
+
+func (c *cancelAfterContext) Err() error {
+	if c.n <= 0 {
+		return context.Canceled
+	}
+	c.n--
+	return nil
+}
+
+func TestListKeysContext(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	store := helperCreateMultipleDataFiles(t, fs, "test_list_keys_context.db")
+	defer store.Close()
+	for i := range 3000 {
+		store.Put(fmt.Appendf(nil, "key%d", i), []byte("value"))
+	}
+
+	keys, err := store.ListKeysContext(context.Background())
+	if err != nil || len(keys) != 3000 {
+		t.Fatalf("expected 3000 keys, got %d (err: %v)", len(keys), err)
+	}
+	if _, err := store.ListKeysContext(&cancelAfterContext{Context: context.Background(), n: 1}); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+}
+
+func TestMergeContextCancelled(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	path := "test_merge_context.db"
+	store, err := Create(fs, path)
+	if err != nil {
+		t.Fatalf("error creating datastore: %v", err)
+	}
+	for i := range 3000 {
+		store.Put(fmt.Appendf(nil, "key%d", i), []byte("value"))
+	}
+	store.Close()
+	store, err = Open(fs, path)
+	if err != nil {
+		t.Fatalf("failed to reopen store: %v", err)
+	}
+	defer store.Close()
+	store.Put([]byte("key0"), []byte("updated"))
+
+	// Cancelled after the first records were written to the merge file
+	err = store.MergeContext(&cancelAfterContext{Context: context.Background(), n: 2})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+	for _, dir := range []string{"data", "hint"} {
+		entries, _ := afero.ReadDir(fs, filepath.Join(path, dir))
+		for _, entry := range entries {
+			if strings.HasPrefix(entry.Name(), mergeTempPrefix) {
+				t.Errorf("expected the temporary merge file %s/%s to be removed", dir, entry.Name())
+			}
+		}
+	}
+	if stats, _ := store.Stats(); stats.FailedMerges != 1 {
+		t.Errorf("expected the cancelled merge to be counted as failed, got %d", stats.FailedMerges)
+	}
+
+	if err := store.MergeContext(context.Background()); err != nil {
+		t.Fatalf("merge failed: %v", err)
+	}
+	for i := range 3000 {
+		value, err := store.Get(fmt.Appendf(nil, "key%d", i))
+		if err != nil || (i != 0 && string(value) != "value") {
+			t.Fatalf("key%d: unexpected value %q (err: %v)", i, value, err)
+		}
+	}
+}
+
+func TestMergeContextWaitsForRunningMerge(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	store := helperCreateMultipleDataFiles(t, fs, "test_merge_context_wait.db")
+	defer store.Close()
+
+	store.mergeLock.Lock()
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	err := store.MergeContext(ctx)
+	store.mergeLock.Unlock()
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected context.DeadlineExceeded, got %v", err)
+	}
+}
+
+func TestGetInto(t *testing.T) {
+	for _, opts := range []*Options{nil, {ValueCacheBytes: 1 << 20}, {RecordFormat: RecordFormatV2}} {
+		store, err := CreateWithOptions(afero.NewMemMapFs(), "test_get_into.db", opts)
+		if err != nil {
+			t.Fatalf("could not create datastore: %v", err)
+		}
+		store.Put([]byte("key"), []byte("value"))
+		store.Put([]byte("empty"), []byte{})
+
+		buf := make([]byte, 0, 64)
+		value, err := store.GetInto([]byte("key"), buf)
+		if err != nil || string(value) != "value" || &value[0] != &buf[:1][0] {
+			t.Errorf("expected the value to be read into the buffer, got %q, %v", value, err)
+		}
+		if value, err := store.GetInto([]byte("empty"), buf); err != nil || len(value) != 0 {
+			t.Errorf("expected an empty value, got %q, %v", value, err)
+		}
+		if value, err := store.GetInto([]byte("key"), nil); err != nil || string(value) != "value" {
+			t.Errorf("expected the value in a new slice, got %q, %v", value, err)
+		}
+		if _, err := store.GetInto([]byte("missing"), buf); !errors.Is(err, ErrKeyNotFound) {
+			t.Errorf("expected ErrKeyNotFound, got %v", err)
+		}
+		allocs := testing.AllocsPerRun(100, func() {
+			buf, _ = store.GetInto([]byte("key"), buf)
+		})
+		if allocs != 0 {
+			t.Errorf("expected GetInto not to allocate, got %v allocations", allocs)
+		}
+		store.Close()
+	}
+}
+
+func TestRandomKey(t *testing.T) {
+	store := helperCreateMultipleDataFiles(t, afero.NewMemMapFs(), "test_random_key.db")
+	defer store.Close()
+	if _, err := store.RandomKey(); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("expected ErrKeyNotFound on an empty datastore, got %v", err)
+	}
+	store.Put([]byte("a"), []byte("1"))
+	store.Put([]byte("b"), []byte("2"))
+	store.Delete([]byte("b"))
+	for range 10 {
+		if key, err := store.RandomKey(); err != nil || string(key) != "a" {
+			t.Errorf("expected a, got %q (err: %v)", key, err)
+		}
+	}
+}
@@ -0,0 +1,145 @@
+package kvdb
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/ananthvk/kvdb/internal/datafile"
+	"github.com/ananthvk/kvdb/internal/keydir"
+	"github.com/ananthvk/kvdb/internal/record"
+	"github.com/spf13/afero"
+)
+
+// PutReader sets the value of the key to the next size bytes of r, so that a large value does not have to be held in
+// memory. If r ends before size bytes (io.ErrUnexpectedEOF is returned) or fails, nothing is written. Bytes of r after
+// the first size bytes are not read.
+//
+// The value is first copied into a temporary file of the datastore's file system (see spoolValue), and the write lock
+// is only taken to copy it into the data file, so a slow r does not block the other reads and writes. Interceptors see
+// the request with a nil Value, and changes they make to the Value are ignored. Watchers get the value read back from
+// the data file, so the value is only read into memory if the datastore is being watched
+func (dataStore *DataStore) PutReader(key []byte, r io.Reader, size int64) error {
+	if err := dataStore.gate.enter(); err != nil {
+		return err
+	}
+	defer dataStore.gate.exit()
+	if size < 0 || size > int64(dataStore.MaxValueSize()) {
+		return fmt.Errorf("%w: %d bytes, the limit is %d", ErrValueTooLarge, size, dataStore.MaxValueSize())
+	}
+	if err := dataStore.checkWrite(key, nil); err != nil {
+		return err
+	}
+	value, release, err := dataStore.spoolValue(io.LimitReader(r, size), size)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	dataStore.lockForWrite("datastore.put_reader")
+	defer dataStore.mu.Unlock()
+	if err := dataStore.checkStall(); err != nil {
+		return err
+	}
+	req := &WriteRequest{Type: WriteTypePut, Key: key}
+	if err := dataStore.runBeforeInterceptors(req); err != nil {
+		return err
+	}
+	req.Value = nil
+	if err := dataStore.checkWrite(req.Key, nil); err != nil {
+		dataStore.runAfterInterceptors(req, err)
+		return err
+	}
+	if err := dataStore.checkImmutable(req.Key); err != nil {
+		dataStore.runAfterInterceptors(req, err)
+		return err
+	}
+	if err := dataStore.checkMemory(req.Key); err != nil {
+		dataStore.runAfterInterceptors(req, err)
+		return err
+	}
+	ts := dataStore.nextTimestamp(req.Key)
+	valueType := headerValueType(ValueTypeString)
+	header := record.Header{Timestamp: ts, RecordType: record.RecordTypePut, ValueType: valueType, ValueSize: uint32(size)}
+	fileId, offset, err := dataStore.fileManager.WriteRecordFromReader(header, req.Key, value)
+	if err == nil {
+		dataStore.appendSignal.notify()
+		dataStore.keydir.Add(req.Key, keydir.KeydirRecord{
+			FileId:    fileId,
+			ValueSize: uint32(size),
+			ValuePos:  offset - datafile.FileHeaderSize,
+			Timestamp: ts,
+			ValueType: valueType,
+		})
+		dataStore.updateMemory()
+		dataStore.counters.puts.Add(1)
+		if dataStore.watchers.active() {
+			if rec, readErr := dataStore.fileManager.ReadValueAt(fileId, offset-datafile.FileHeaderSize); readErr == nil {
+				dataStore.watchers.notify(WatchEvent{Type: WriteTypePut, Key: req.Key, Value: rec.Value, FileId: fileId,
+					Offset: offset - datafile.FileHeaderSize, Timestamp: ts, ValueType: ValueTypeString})
+			}
+		}
+	}
+	dataStore.runAfterInterceptors(req, err)
+	return err
+}
+
+// spoolValue copies the next size bytes of r into a temporary file, and returns a reader of the copy, and a function
+// that removes it. The value of an encrypted datastore is read into memory instead: it's sealed whole when it's
+// written, and must not be stored unencrypted
+func (dataStore *DataStore) spoolValue(r io.Reader, size int64) (io.Reader, func(), error) {
+	if dataStore.fileManager.Keyring().CanEncrypt() {
+		value := make([]byte, size)
+		if _, err := io.ReadFull(r, value); err != nil {
+			if errors.Is(err, io.EOF) {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, nil, err
+		}
+		return bytes.NewReader(value), func() {}, nil
+	}
+	spool, err := afero.TempFile(dataStore.fs, "", "kvdb-put-")
+	if err != nil {
+		return nil, nil, err
+	}
+	release := func() {
+		spool.Close()
+		dataStore.fs.Remove(spool.Name())
+	}
+	copied, err := io.CopyN(spool, r, size)
+	if errors.Is(err, io.EOF) && copied < size {
+		err = io.ErrUnexpectedEOF
+	}
+	if err == nil {
+		_, err = spool.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		release()
+		return nil, nil, err
+	}
+	return spool, release, nil
+}
+
+// GetReader returns a reader of the value of the key, and the size of the value. The value is read from the data file
+// as the reader is read, so that a large value can be streamed without holding it in memory. The reader is also an
+// io.Seeker and an io.ReaderAt (so it can be passed to http.ServeContent), and must be closed by the caller.
+//
+// The reader has the value of the key at the time GetReader was called, writes and merges after that do not change
+// what it reads, and it can still be read after the datastore is closed. Each reader keeps the data file open, so
+// readers should not be kept around. If the key does not exist, ErrKeyNotFound is returned
+func (dataStore *DataStore) GetReader(key []byte) (io.ReadCloser, int64, error) {
+	if err := dataStore.gate.enter(); err != nil {
+		return nil, 0, err
+	}
+	defer dataStore.gate.exit()
+	// The file is opened under the lock, so that a merge can't remove it in between
+	dataStore.lockProfiler.Lock(dataStore.mu.RLocker(), "datastore.get_reader")
+	defer dataStore.mu.RUnlock()
+	rec, ok := dataStore.keydir.GetKeydirRecord(key)
+	if !ok {
+		return nil, 0, ErrKeyNotFound
+	}
+	dataStore.counters.gets.Add(1)
+	return dataStore.fileManager.OpenValue(rec.FileId, rec.ValuePos)
+}
@@ -0,0 +1,209 @@
+package kvdb
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/afero"
+)
+
+// failingReader returns n bytes of data, and then fails
+type failingReader struct {
+	n int
+}
+
+func (r *failingReader) Read(p []byte) (int, error) {
+	if r.n == 0 {
+		return 0, errors.New("read failed")
+	}
+	n := min(r.n, len(p))
+	r.n -= n
+	return n, nil
+}
+
+func TestPutReader(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	path := "test_put_reader.db"
+	store, err := Create(fs, path)
+	if err != nil {
+		t.Fatalf("could not create datastore: %v", err)
+	}
+	var watched []byte
+	store.Watch(func(event WatchEvent) {
+		watched = bytes.Clone(event.Value)
+	})
+
+	blob := bytes.Repeat([]byte("0123456789"), 50_000)
+	if err := store.PutReader([]byte("blob"), bytes.NewReader(blob), int64(len(blob))); err != nil {
+		t.Fatalf("put reader failed: %v", err)
+	}
+	if !bytes.Equal(watched, blob) {
+		t.Errorf("expected the watcher to get the value, got %d bytes", len(watched))
+	}
+	// Only size bytes are read
+	r := strings.NewReader("valuerest")
+	if err := store.PutReader([]byte("key"), r, 5); err != nil {
+		t.Fatalf("put reader failed: %v", err)
+	}
+	if rest, _ := io.ReadAll(r); string(rest) != "rest" {
+		t.Errorf("expected the rest of the reader not to be read, got %q", rest)
+	}
+
+	// A failed read writes nothing
+	if err := store.PutReader([]byte("short"), strings.NewReader("abc"), 10); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("expected io.ErrUnexpectedEOF, got %v", err)
+	}
+	if err := store.PutReader([]byte("failed"), &failingReader{n: 100}, 1000); err == nil {
+		t.Errorf("expected the read error")
+	}
+	if err := store.PutReader([]byte("large"), strings.NewReader(""), int64(store.MaxValueSize())+1); !errors.Is(err, ErrValueTooLarge) {
+		t.Errorf("expected ErrValueTooLarge, got %v", err)
+	}
+	store.Put([]byte("after"), []byte("value"))
+	store.Close()
+
+	// The records after a failed read are readable after a restart
+	store, err = Open(fs, path)
+	if err != nil {
+		t.Fatalf("could not open datastore: %v", err)
+	}
+	defer store.Close()
+	if report := store.OpenReport(); len(report.InvalidDataFiles) != 0 {
+		t.Errorf("expected no invalid data files, got %+v", report)
+	}
+	for key, expected := range map[string]string{"blob": string(blob), "key": "value", "after": "value"} {
+		if value, err := store.Get([]byte(key)); err != nil || string(value) != expected {
+			t.Errorf("%s: expected %d bytes, got %d bytes, %v", key, len(expected), len(value), err)
+		}
+	}
+	for _, key := range []string{"short", "failed", "large"} {
+		if _, err := store.Get([]byte(key)); !errors.Is(err, ErrKeyNotFound) {
+			t.Errorf("%s: expected ErrKeyNotFound, got %v", key, err)
+		}
+	}
+}
+
+func TestPutReaderValueType(t *testing.T) {
+	store, err := Create(afero.NewMemMapFs(), "test_put_reader_type.db")
+	if err != nil {
+		t.Fatalf("could not create datastore: %v", err)
+	}
+	defer store.Close()
+	store.Hash([]byte("hash")).Set([]byte("field"), []byte("value"))
+	encoded, _ := store.Get([]byte("hash"))
+
+	// Values written by PutReader are strings, even the encoding of a hash, and they replace a hash
+	for key, value := range map[string][]byte{"string": []byte("value"), "copy": encoded, "hash": []byte("ab")} {
+		if err := store.PutReader([]byte(key), bytes.NewReader(value), int64(len(value))); err != nil {
+			t.Fatalf("%s: put reader failed: %v", key, err)
+		}
+	}
+	for _, key := range []string{"string", "copy", "hash"} {
+		if rec, _ := store.keydir.GetKeydirRecord([]byte(key)); rec.ValueType != uint8(ValueTypeString)+1 {
+			t.Errorf("%s: expected the type string in the keydir, got %d", key, rec.ValueType)
+		}
+		if got, err := store.Type([]byte(key)); err != nil || got != ValueTypeString {
+			t.Errorf("%s: expected string, got %s, %v", key, got, err)
+		}
+	}
+}
+
+func TestPutReaderDoesNotBlockWrites(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	store, err := Create(fs, "test_put_reader_blocking.db")
+	if err != nil {
+		t.Fatalf("could not create datastore: %v", err)
+	}
+	defer store.Close()
+
+	pr, pw := io.Pipe()
+	done := make(chan error, 1)
+	go func() {
+		done <- store.PutReader([]byte("slow"), pr, 10)
+	}()
+	pw.Write([]byte("01234"))
+
+	// The value has not arrived yet, other writes and reads are not blocked
+	written := make(chan error, 1)
+	go func() {
+		written <- store.Put([]byte("other"), []byte("value"))
+	}()
+	select {
+	case err := <-written:
+		if err != nil {
+			t.Fatalf("put failed: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected a put not to wait for the value of PutReader")
+	}
+	if _, err := store.Get([]byte("slow")); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("expected the key not to be written before the value arrives, got %v", err)
+	}
+
+	pw.Write([]byte("56789"))
+	if err := <-done; err != nil {
+		t.Fatalf("put reader failed: %v", err)
+	}
+	if value, err := store.Get([]byte("slow")); err != nil || string(value) != "0123456789" {
+		t.Errorf("expected the value, got %q, %v", value, err)
+	}
+	// The temporary file is removed
+	if files, _ := afero.Glob(fs, filepath.Join(os.TempDir(), "kvdb-put-*")); len(files) != 0 {
+		t.Errorf("expected the temporary file to be removed, got %v", files)
+	}
+}
+
+func TestGetReader(t *testing.T) {
+	store, err := Create(afero.NewMemMapFs(), "test_get_reader.db")
+	if err != nil {
+		t.Fatalf("could not create datastore: %v", err)
+	}
+	defer store.Close()
+
+	blob := bytes.Repeat([]byte("0123456789"), 50_000)
+	store.Put([]byte("blob"), blob)
+	store.Put([]byte("other"), []byte("value"))
+	r, size, err := store.GetReader([]byte("blob"))
+	if err != nil {
+		t.Fatalf("get reader failed: %v", err)
+	}
+	defer r.Close()
+	if size != int64(len(blob)) {
+		t.Errorf("expected a size of %d, got %d", len(blob), size)
+	}
+
+	// The reader keeps reading the old value after the key is overwritten, and it's file is merged
+	store.Put([]byte("blob"), []byte("new value"))
+	if err := store.Merge(); err != nil {
+		t.Fatalf("merge failed: %v", err)
+	}
+	got, err := io.ReadAll(r)
+	if err != nil || !bytes.Equal(got, blob) {
+		t.Errorf("expected the value, got %d bytes, %v", len(got), err)
+	}
+	seeker := r.(io.ReadSeeker)
+	if _, err := seeker.Seek(-4, io.SeekEnd); err != nil {
+		t.Fatalf("seek failed: %v", err)
+	}
+	if rest, _ := io.ReadAll(seeker); string(rest) != "6789" {
+		t.Errorf("expected the end of the value after seeking, got %q", rest)
+	}
+
+	r, _, err = store.GetReader([]byte("blob"))
+	if err != nil {
+		t.Fatalf("get reader failed: %v", err)
+	}
+	if got, _ := io.ReadAll(r); string(got) != "new value" {
+		t.Errorf("expected the new value, got %q", got)
+	}
+	r.Close()
+	if _, _, err := store.GetReader([]byte("missing")); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("expected ErrKeyNotFound, got %v", err)
+	}
+}
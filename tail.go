@@ -0,0 +1,175 @@
+package kvdb
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/ananthvk/kvdb/internal/record"
+)
+
+/*
+The data files written by Put and Delete form an append only log of every write (including tombstones for keys that did
+not exist), which TailLog exposes for change data capture. A position in the log is a data file id, and an offset from
+the first record in that file.
+
+Merge writes the live records into new data files (which have hint files), these are not part of the log and are
+skipped while tailing (the hint files written by GenerateHints don't take a file out of the log). Merge also deletes
+the files it has merged, a tailer that has not finished reading a merged file gets ErrLogTruncated, and has to rebuild
+it's state from the datastore. Files added with AdoptFile are part of the log
+*/
+
+// LogRecord is a record read from the log
+type LogRecord struct {
+	Type WriteType
+	Key  []byte
+	// Value is nil for deletes
+	Value     []byte
+	Timestamp time.Time
+	// Position of the record in the log
+	FileId int
+	Offset int64
+	// Offset of the record after this one, TailLog(FileId, NextOffset) continues after this record
+	NextOffset int64
+}
+
+// LogTailer reads records from the log, as they are appended. It's not safe for concurrent use
+type LogTailer struct {
+	dataStore *DataStore
+	fileId    int
+	offset    int64
+}
+
+// TailLog returns a tailer that reads the log starting at the given position, i.e. the first record returned is the one
+// at fromOffset in the data file fromFileId. TailLog(0, 0) starts at the oldest record that is still in the log
+func (dataStore *DataStore) TailLog(fromFileId int, fromOffset int64) (*LogTailer, error) {
+	if fromFileId < 0 || fromOffset < 0 {
+		return nil, ErrLogTruncated
+	}
+	return &LogTailer{dataStore: dataStore, fileId: fromFileId, offset: fromOffset}, nil
+}
+
+// Next returns the next record in the log. If there are no more records, it blocks until a record is appended, ctx is
+// done, or the datastore is closed (ErrClosed is returned)
+func (t *LogTailer) Next(ctx context.Context) (LogRecord, error) {
+	dataStore := t.dataStore
+	for {
+		if err := dataStore.gate.enter(); err != nil {
+			return LogRecord{}, err
+		}
+		// Writes happen with the write lock held, so a record is never read while it is being written
+		dataStore.mu.RLock()
+		signal := dataStore.appendSignal.wait()
+		rec, found, err := t.read()
+		if err == nil && !found {
+			found, err = t.advance()
+		}
+		dataStore.mu.RUnlock()
+		dataStore.gate.exit()
+		if err != nil {
+			return LogRecord{}, err
+		}
+		if rec != nil {
+			return *rec, nil
+		}
+		if found {
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return LogRecord{}, ctx.Err()
+		case <-dataStore.gate.ctx.Done():
+			return LogRecord{}, ErrClosed
+		case <-signal:
+		}
+	}
+}
+
+// read reads the record at the current position, false is returned if there is no record at the position yet
+func (t *LogTailer) read() (*LogRecord, bool, error) {
+	if t.fileId == 0 {
+		// Before the first data file
+		return nil, false, nil
+	}
+	fileManager := t.dataStore.fileManager
+	// The records that are still in the write buffer are read from the tail of the file manager
+	rec, err := fileManager.ReadRecordAtStrict(t.fileId, t.offset)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, false, ErrLogTruncated
+		}
+		if errors.Is(err, io.EOF) {
+			return nil, false, nil
+		}
+		// The file may have been merged and deleted while it was being read
+		if !fileManager.HasDataFile(t.fileId) {
+			return nil, false, ErrLogTruncated
+		}
+		return nil, false, err
+	}
+	logRecord := &LogRecord{
+		Type:       WriteTypePut,
+		Key:        rec.Key,
+		Value:      rec.Value,
+		Timestamp:  rec.Header.Timestamp,
+		FileId:     t.fileId,
+		Offset:     t.offset,
+		NextOffset: t.offset + rec.Size,
+	}
+	if rec.Header.RecordType == record.RecordTypeDelete {
+		logRecord.Type = WriteTypeDelete
+		logRecord.Value = nil
+	}
+	t.offset += rec.Size
+	return logRecord, true, nil
+}
+
+// advance moves to the start of the next data file in the log, if the current file is no longer being written to. It
+// returns false if there is no next file yet
+func (t *LogTailer) advance() (bool, error) {
+	fileManager := t.dataStore.fileManager
+	if t.fileId != 0 && t.fileId == fileManager.GetActiveFileId() {
+		return false, nil
+	}
+	ids, err := fileManager.DataFileIds()
+	if err != nil {
+		return false, err
+	}
+	for _, id := range ids {
+		if id > t.fileId && !fileManager.IsMergedFile(id) {
+			t.fileId = id
+			t.offset = 0
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// appendSignal is used to wake up tailers when a record is appended to the log
+type appendSignal struct {
+	mu sync.Mutex
+	ch chan struct{}
+}
+
+// wait returns a channel that is closed when the next record is appended
+func (s *appendSignal) wait() <-chan struct{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ch == nil {
+		s.ch = make(chan struct{})
+	}
+	return s.ch
+}
+
+func (s *appendSignal) notify() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ch != nil {
+		close(s.ch)
+		s.ch = nil
+	}
+}
@@ -0,0 +1,107 @@
+package kvdb
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/spf13/afero"
+)
+
+func helperNextLogRecord(t *testing.T, tailer *LogTailer) LogRecord {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	rec, err := tailer.Next(ctx)
+	if err != nil {
+		t.Fatalf("next failed: %v", err)
+	}
+	return rec
+}
+
+func TestTailLog(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	store := helperCreateMultipleDataFiles(t, fs, "test_tail_log.db")
+	store.Put([]byte("key1"), []byte("value1"))
+	// Tombstones are part of the log, even if the key does not exist
+	store.Delete([]byte("missing"))
+	store.Close()
+	store, err := Open(fs, "test_tail_log.db")
+	if err != nil {
+		t.Fatalf("failed to reopen store: %v", err)
+	}
+	defer store.Close()
+	store.Put([]byte("key2"), []byte("value2"))
+
+	tailer, err := store.TailLog(0, 0)
+	if err != nil {
+		t.Fatalf("tail failed: %v", err)
+	}
+	expected := []string{"PUT key1 value1", "DELETE missing ", "PUT key2 value2"}
+	var last LogRecord
+	for i, exp := range expected {
+		last = helperNextLogRecord(t, tailer)
+		if got := last.Type.String() + " " + string(last.Key) + " " + string(last.Value); got != exp {
+			t.Errorf("record %d: expected %q, got %q", i, exp, got)
+		}
+	}
+	if last.FileId != 2 || last.Offset != 0 {
+		t.Errorf("expected the last record at file 2 offset 0, got file %d offset %d", last.FileId, last.Offset)
+	}
+
+	// Next blocks until a record is appended
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		store.Put([]byte("key3"), []byte("value3"))
+	}()
+	if rec := helperNextLogRecord(t, tailer); string(rec.Key) != "key3" {
+		t.Errorf("expected key3, got %s", rec.Key)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := tailer.Next(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected context.DeadlineExceeded, got %v", err)
+	}
+
+	// Continue from a saved position
+	resumed, _ := store.TailLog(last.FileId, last.NextOffset)
+	if rec := helperNextLogRecord(t, resumed); string(rec.Key) != "key3" {
+		t.Errorf("expected key3 after resuming, got %s", rec.Key)
+	}
+}
+
+func TestTailLogAfterMerge(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	store := helperCreateMultipleDataFiles(t, fs, "test_tail_log_merge.db")
+	store.Put([]byte("key1"), []byte("value1"))
+	store.Put([]byte("key2"), []byte("value2"))
+	store.Close()
+	store, err := Open(fs, "test_tail_log_merge.db")
+	if err != nil {
+		t.Fatalf("failed to reopen store: %v", err)
+	}
+	defer store.Close()
+	store.Put([]byte("key3"), []byte("value3"))
+
+	stale, _ := store.TailLog(1, 0)
+	helperNextLogRecord(t, stale)
+
+	if err := store.Merge(); err != nil {
+		t.Fatalf("merge failed: %v", err)
+	}
+	store.Put([]byte("key4"), []byte("value4"))
+
+	// The merged file was deleted
+	if _, err := stale.Next(context.Background()); !errors.Is(err, ErrLogTruncated) {
+		t.Errorf("expected ErrLogTruncated, got %v", err)
+	}
+
+	// Records in the merged files are not part of the log
+	tailer, _ := store.TailLog(0, 0)
+	for _, key := range []string{"key3", "key4"} {
+		if rec := helperNextLogRecord(t, tailer); string(rec.Key) != key {
+			t.Errorf("expected %s, got %s", key, rec.Key)
+		}
+	}
+}
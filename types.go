@@ -0,0 +1,207 @@
+package kvdb
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/ananthvk/kvdb/internal/keydir"
+)
+
+/*
+Structured values
+
+Hashes, lists and sets are stored as the value of a single key, so they are written, merged, replicated and snapshotted
+like any other value, and an update of a structured value is a single write. The value is the encoding of the type:
+
+  - hash: the number of fields, then every field followed by it's value, in ascending field order
+  - list: the number of elements, then every element from the head of the list to it's tail
+  - set: the number of members, then every member, in ascending order
+
+Numbers are uvarints, and fields, values, elements and members are a uvarint length followed by the bytes.
+
+The type of a value is recorded in the ValueType byte of it's record header (the type plus one), in the hints of the
+record, and in the keydir, it's the only source of the type: the bytes of a value are never looked at, so a string can
+hold any bytes (including the encoding of a hash). Records written before types were recorded have 0x0, they are
+strings. The methods of a type fail with ErrWrongType on a key that holds a value of another type (a string included),
+and Get returns the encoding of a structured value, GetWithType returns it along with the type.
+
+An update reads the value, changes it and writes it back with the write lock held, so concurrent updates of the same key
+are not lost. A structured value that becomes empty is deleted, like in Redis, so a key never holds an empty hash,
+list or set. Structured values are meant to be small: every update rewrites the whole value, which is limited by
+Options.MaxValueSize
+*/
+
+// ValueType is the type of the value of a key, see types.go
+type ValueType uint8
+
+const (
+	ValueTypeString ValueType = iota
+	ValueTypeHash
+	ValueTypeList
+	ValueTypeSet
+)
+
+func (t ValueType) String() string {
+	switch t {
+	case ValueTypeString:
+		return "string"
+	case ValueTypeHash:
+		return "hash"
+	case ValueTypeList:
+		return "list"
+	case ValueTypeSet:
+		return "set"
+	}
+	return "unknown"
+}
+
+// WrongTypeError is returned by the methods of a structured type when the key holds a value of another type, it wraps
+// ErrWrongType
+type WrongTypeError struct {
+	Key []byte
+	// Type of the value of the key, and the type of the method
+	Type     ValueType
+	Expected ValueType
+}
+
+func (e *WrongTypeError) Error() string {
+	return fmt.Sprintf("%s: %q is a %s, not a %s", ErrWrongType, e.Key, e.Type, e.Expected)
+}
+
+func (e *WrongTypeError) Unwrap() error {
+	return ErrWrongType
+}
+
+// Returned when a structured value can't be decoded
+var errInvalidStructured = errors.New("invalid structured value")
+
+// headerValueType returns the ValueType byte of the record header of a value of type t
+func headerValueType(t ValueType) uint8 {
+	return uint8(t) + 1
+}
+
+// valueTypeOf returns the type of the value of a keydir record, records written before types were recorded are strings
+func valueTypeOf(rec keydir.KeydirRecord) ValueType {
+	if rec.ValueType == 0 {
+		return ValueTypeString
+	}
+	return ValueType(rec.ValueType - 1)
+}
+
+// Type returns the type of the value of the key, or ErrKeyNotFound if the key does not exist. The type is read from the
+// keydir, see types.go
+func (dataStore *DataStore) Type(key []byte) (ValueType, error) {
+	if err := dataStore.gate.enter(); err != nil {
+		return 0, err
+	}
+	defer dataStore.gate.exit()
+	dataStore.lockProfiler.Lock(dataStore.mu.RLocker(), "datastore.get")
+	defer dataStore.mu.RUnlock()
+	dataStore.counters.gets.Add(1)
+	rec, ok := dataStore.keydir.GetKeydirRecord(key)
+	if !ok {
+		return 0, ErrKeyNotFound
+	}
+	return valueTypeOf(rec), nil
+}
+
+// GetWithType is Get, and also returns the type of the value. The value of a structured type is it's encoding, it can
+// be written back as it is with PutOptions.ValueType (for example to copy a key to another datastore)
+func (dataStore *DataStore) GetWithType(key []byte) ([]byte, ValueType, error) {
+	if err := dataStore.gate.enter(); err != nil {
+		return nil, 0, err
+	}
+	defer dataStore.gate.exit()
+	dataStore.lockProfiler.Lock(dataStore.mu.RLocker(), "datastore.get")
+	defer dataStore.mu.RUnlock()
+	dataStore.counters.gets.Add(1)
+	rec, ok := dataStore.keydir.GetKeydirRecord(key)
+	if !ok {
+		return nil, 0, ErrKeyNotFound
+	}
+	value, err := dataStore.get(key)
+	if err != nil {
+		return nil, 0, err
+	}
+	return value, valueTypeOf(rec), nil
+}
+
+// readStructured returns the encoding of the value of the key, which must be of type t, and false if the key does not
+// exist
+func (dataStore *DataStore) readStructured(key []byte, t ValueType) ([]byte, bool, error) {
+	if err := dataStore.gate.enter(); err != nil {
+		return nil, false, err
+	}
+	defer dataStore.gate.exit()
+	dataStore.lockProfiler.Lock(dataStore.mu.RLocker(), "datastore.get")
+	defer dataStore.mu.RUnlock()
+	dataStore.counters.gets.Add(1)
+	return dataStore.getStructured(key, t)
+}
+
+// getStructured is readStructured, the caller must hold the lock
+func (dataStore *DataStore) getStructured(key []byte, t ValueType) ([]byte, bool, error) {
+	rec, ok := dataStore.keydir.GetKeydirRecord(key)
+	if !ok {
+		return nil, false, nil
+	}
+	if valueType := valueTypeOf(rec); valueType != t {
+		return nil, false, &WrongTypeError{Key: key, Type: valueType, Expected: t}
+	}
+	encoded, err := dataStore.get(key)
+	if errors.Is(err, ErrKeyNotFound) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return encoded, true, nil
+}
+
+// updateStructured calls update with the encoding of the value of the key, which must be of type t (nil and false if
+// the key does not exist), and writes the encoding it returns, or deletes the key if it returns nil. Nothing is written
+// if update returns an error. The write lock is held from the read to the write
+func (dataStore *DataStore) updateStructured(key []byte, t ValueType, site string, update func(encoded []byte, exists bool) ([]byte, error)) error {
+	if err := dataStore.gate.enter(); err != nil {
+		return err
+	}
+	defer dataStore.gate.exit()
+	dataStore.lockForWrite(site)
+	defer dataStore.mu.Unlock()
+	encoded, exists, err := dataStore.getStructured(key, t)
+	if err != nil {
+		return err
+	}
+	if exists {
+		dataStore.counters.gets.Add(1)
+	}
+	updated, err := update(encoded, exists)
+	if err != nil {
+		return err
+	}
+	if updated == nil {
+		if exists {
+			_, err = dataStore.deleteKey(key)
+		}
+		return err
+	}
+	return dataStore.putRecord(key, updated, dataStore.nextTimestamp(key), false, t)
+}
+
+// appendBytes appends b to the encoding, as it's length followed by the bytes
+func appendBytes(encoded []byte, b []byte) []byte {
+	encoded = binary.AppendUvarint(encoded, uint64(len(b)))
+	return append(encoded, b...)
+}
+
+// cutBytes reads the bytes appended by appendBytes from the start of the encoding, and returns the rest of the encoding.
+// It returns false if the encoding is too short
+func cutBytes(encoded []byte) ([]byte, []byte, bool) {
+	n, m := binary.Uvarint(encoded)
+	if m <= 0 || n > uint64(len(encoded)-m) {
+		return nil, nil, false
+	}
+	end := m + int(n)
+	return encoded[m:end:end], encoded[end:], true
+}
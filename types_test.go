@@ -0,0 +1,109 @@
+package kvdb
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/spf13/afero"
+)
+
+func TestType(t *testing.T) {
+	for _, format := range []RecordFormat{RecordFormatV1, RecordFormatV2} {
+		fs := afero.NewMemMapFs()
+		store, err := CreateWithOptions(fs, "test_type.db", &Options{RecordFormat: format})
+		if err != nil {
+			t.Fatal(err)
+		}
+		store.Put([]byte("string"), []byte("value"))
+		store.Hash([]byte("hash")).Set([]byte("field"), []byte("value"))
+		store.List([]byte("list")).PushBack([]byte("value"))
+		store.Set([]byte("set")).Add([]byte("member"))
+		expected := map[string]ValueType{
+			"string": ValueTypeString, "hash": ValueTypeHash, "list": ValueTypeList, "set": ValueTypeSet,
+		}
+		check := func(stage string) {
+			t.Helper()
+			for key, want := range expected {
+				if got, err := store.Type([]byte(key)); err != nil || got != want {
+					t.Errorf("format %d, %s: expected %s to be a %s, got %s (err: %v)", format, stage, key, want, got, err)
+				}
+			}
+			if _, err := store.Type([]byte("missing")); !errors.Is(err, ErrKeyNotFound) {
+				t.Errorf("format %d, %s: expected ErrKeyNotFound, got %v", format, stage, err)
+			}
+		}
+		check("after the writes")
+
+		// Both headers keep the type, so it's in the keydir after the data file is scanned
+		store.Close()
+		if store, err = Open(fs, "test_type.db"); err != nil {
+			t.Fatal(err)
+		}
+		if rec, _ := store.keydir.GetKeydirRecord([]byte("hash")); rec.ValueType != uint8(ValueTypeHash)+1 {
+			t.Errorf("format %d: unexpected value type 0x%x in the keydir", format, rec.ValueType)
+		}
+		check("after a reopen")
+
+		// The keys are loaded from hint files after a merge, which have the type too
+		if err := store.Merge(); err != nil {
+			t.Fatal(err)
+		}
+		store.Close()
+		if store, err = Open(fs, "test_type.db"); err != nil {
+			t.Fatal(err)
+		}
+		if rec, _ := store.keydir.GetKeydirRecord([]byte("set")); rec.ValueType != uint8(ValueTypeSet)+1 {
+			t.Errorf("format %d: unexpected value type 0x%x in the keydir after a merge", format, rec.ValueType)
+		}
+		check("after a merge")
+		store.Close()
+	}
+}
+
+func TestTypeIgnoresValueBytes(t *testing.T) {
+	store, err := Create(afero.NewMemMapFs(), "test_type_bytes.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	// Binary strings that start like the tag that structured values used to have, and the encoding of a hash
+	hash := store.Hash([]byte("hash"))
+	if _, err := hash.Set([]byte("field"), []byte("value")); err != nil {
+		t.Fatal(err)
+	}
+	encoded, t2, err := store.GetWithType([]byte("hash"))
+	if err != nil || t2 != ValueTypeHash {
+		t.Fatalf("expected a hash, got %s, %v", t2, err)
+	}
+	values := map[string][]byte{
+		"tagged":  []byte("\xffkv\x01garbage"),
+		"unknown": []byte("\xffkv\xff"),
+		"encoded": encoded,
+	}
+	for key, value := range values {
+		if err := store.Put([]byte(key), value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for key, value := range values {
+		got, valueType, err := store.GetWithType([]byte(key))
+		if err != nil || valueType != ValueTypeString || !bytes.Equal(got, value) {
+			t.Errorf("%s: expected the string %q, got the %s %q, %v", key, value, valueType, got, err)
+		}
+		if _, err := store.Hash([]byte(key)).GetAll(); !errors.Is(err, ErrWrongType) {
+			t.Errorf("%s: expected ErrWrongType, got %v", key, err)
+		}
+	}
+
+	// The encoding can be written back as a hash
+	if err := store.PutWithOptions([]byte("copy"), encoded, &PutOptions{ValueType: ValueTypeHash}); err != nil {
+		t.Fatal(err)
+	}
+	if fields, err := store.Hash([]byte("copy")).GetAll(); err != nil || string(fields["field"]) != "value" {
+		t.Errorf("expected the copied hash, got %v, %v", fields, err)
+	}
+	if err := store.PutWithOptions([]byte("copy"), encoded, &PutOptions{ValueType: 9}); err == nil {
+		t.Errorf("expected an error for an unknown value type")
+	}
+}
@@ -0,0 +1,77 @@
+package kvdb
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/spf13/afero"
+)
+
+func TestValueCache(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	path := "test_value_cache.db"
+	store, err := CreateWithOptions(fs, path, &Options{ValueCacheBytes: 1 << 20})
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer store.Close()
+	expected := map[string]string{}
+	for i := range 20 {
+		key, value := fmt.Sprintf("key%d", i), fmt.Sprintf("value%d", i)
+		store.Put([]byte(key), []byte(value))
+		expected[key] = value
+	}
+	helperCheckValues(t, store, expected)
+	helperCheckValues(t, store, expected)
+	stats, _ := store.Stats()
+	if stats.ValueCacheHits != 20 || stats.ValueCacheMisses != 20 || stats.ValueCacheEntries != 20 {
+		t.Errorf("expected 20 hits and misses, got %d hits, %d misses, %d entries", stats.ValueCacheHits, stats.ValueCacheMisses, stats.ValueCacheEntries)
+	}
+
+	// A returned value can be modified without changing the cached value
+	value, _ := store.Get([]byte("key0"))
+	value[0] = 'X'
+
+	// Overwritten and deleted keys are dropped from the cache
+	store.Put([]byte("key1"), []byte("updated"))
+	expected["key1"] = "updated"
+	store.Delete([]byte("key2"))
+	delete(expected, "key2")
+	if stats, _ := store.Stats(); stats.ValueCacheEntries != 18 {
+		t.Errorf("expected 18 entries, got %d", stats.ValueCacheEntries)
+	}
+	helperCheckValues(t, store, expected)
+
+	// A merge drops the values of the merged files
+	store.Close()
+	store, err = OpenWithOptions(fs, path, &Options{ValueCacheBytes: 1 << 20})
+	if err != nil {
+		t.Fatalf("failed to reopen store: %v", err)
+	}
+	helperCheckValues(t, store, expected)
+	store.Put([]byte("key3"), []byte("updated"))
+	expected["key3"] = "updated"
+	if err := store.Merge(); err != nil {
+		t.Fatalf("merge failed: %v", err)
+	}
+	if stats, _ := store.Stats(); stats.ValueCacheEntries != 0 {
+		t.Errorf("expected the cache to be empty after the merge, got %d entries", stats.ValueCacheEntries)
+	}
+	helperCheckValues(t, store, expected)
+	if stats, _ := store.Stats(); stats.ValueCacheEntries != len(expected) {
+		t.Errorf("expected %d entries, got %d", len(expected), stats.ValueCacheEntries)
+	}
+}
+
+func TestValueCacheDisabled(t *testing.T) {
+	store, err := Create(afero.NewMemMapFs(), "test_value_cache_disabled.db")
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer store.Close()
+	store.Put([]byte("key"), []byte("value"))
+	store.Get([]byte("key"))
+	if stats, _ := store.Stats(); stats.ValueCacheHits != 0 || stats.ValueCacheMisses != 0 || stats.ValueCacheEntries != 0 {
+		t.Errorf("expected no value cache stats, got %+v", stats)
+	}
+}
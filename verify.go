@@ -0,0 +1,224 @@
+package kvdb
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/ananthvk/kvdb/internal/datafile"
+	"github.com/ananthvk/kvdb/internal/keydir"
+	"github.com/ananthvk/kvdb/internal/record"
+)
+
+/*
+Verify
+
+Verify checks that the keydir of an open datastore is the one that would be built from it's data files. It rebuilds a
+second keydir by scanning every data file with strict CRC checks (hint files are not used, so a hint file that went
+stale does not hide a problem), replaying the records in file id order like Open does, and compares the two:
+
+  - an entry of the keydir that does not point to a good record of it's key (wrong key, wrong sizes, bad CRC, or a
+    file that can't be read) is dangling, a Get of the key returns garbage or fails
+  - an entry that points to a good record, but not to the latest record of the key in the data files, is mismatched,
+    the key changes value when the datastore is reopened
+  - a live record in the data files whose key is not in the keydir is unreachable, the key comes back when the
+    datastore is reopened
+
+The merge lock and the read lock are held while the files are scanned, so merges and writes wait for Verify to finish
+(reads do not). It reads every data file, so it takes about as long as opening the datastore without hint files
+*/
+
+// VerifyDiscrepancyKind is the kind of a difference between the keydir and the data files found by Verify
+type VerifyDiscrepancyKind string
+
+const (
+	DanglingEntry     VerifyDiscrepancyKind = "dangling keydir entry"
+	MismatchedEntry   VerifyDiscrepancyKind = "mismatched keydir entry"
+	UnreachableRecord VerifyDiscrepancyKind = "unreachable live record"
+)
+
+// VerifyDiscrepancy is a key whose entry in the keydir does not match the data files
+type VerifyDiscrepancy struct {
+	Kind VerifyDiscrepancyKind
+	Key  string
+	// Position of the key in the keydir and of the latest record of the key in the data files, the file id is 0 if
+	// there is none. Offsets are from the first record of the file
+	KeydirFileId int
+	KeydirOffset int64
+	RecordFileId int
+	RecordOffset int64
+	// What is wrong with a dangling entry
+	Detail string
+}
+
+func (d VerifyDiscrepancy) String() string {
+	s := fmt.Sprintf("%s %q: keydir %d@%d, data files %d@%d", d.Kind, d.Key, d.KeydirFileId, d.KeydirOffset, d.RecordFileId, d.RecordOffset)
+	if d.Detail != "" {
+		s += " (" + d.Detail + ")"
+	}
+	return s
+}
+
+// VerifyFileError is a data file that could not be read completely, the records after Offset were not checked
+type VerifyFileError struct {
+	FileId int
+	Offset int64
+	Err    error
+}
+
+func (e VerifyFileError) String() string {
+	return fmt.Sprintf("data file %d: record at offset %d: %s", e.FileId, e.Offset, e.Err)
+}
+
+// VerifyReport is the result of Verify
+type VerifyReport struct {
+	// Number of data files and records that were scanned, and of keys in the keydir
+	Files   int
+	Records int
+	Keys    int
+	// Data files with a bad header, a bad CRC or a truncated record
+	FileErrors    []VerifyFileError
+	Discrepancies []VerifyDiscrepancy
+}
+
+// OK returns true if no problem was found
+func (r *VerifyReport) OK() bool {
+	return len(r.FileErrors) == 0 && len(r.Discrepancies) == 0
+}
+
+// Verify rescans every data file with strict CRC checks, and cross-checks the keydir against the records, see verify.go.
+// The problems that were found are returned in the report, an error is only returned if Verify could not run
+func (dataStore *DataStore) Verify() (*VerifyReport, error) {
+	if err := dataStore.gate.enter(); err != nil {
+		return nil, err
+	}
+	defer dataStore.gate.exit()
+	dataStore.mergeLock.Lock()
+	defer dataStore.mergeLock.Unlock()
+	dataStore.lockProfiler.Lock(dataStore.mu.RLocker(), "datastore.verify")
+	defer dataStore.mu.RUnlock()
+
+	// The buffered records of the active file are scanned too
+	if err := dataStore.fileManager.Flush(); err != nil {
+		return nil, err
+	}
+	ids, err := dataStore.fileManager.DataFileIds()
+	if err != nil {
+		return nil, err
+	}
+	report := &VerifyReport{Files: len(ids), Keys: dataStore.keydir.Size()}
+	rebuilt := keydir.NewKeydir()
+	for _, id := range ids {
+		records, err := dataStore.verifyScan(rebuilt, id)
+		report.Records += records
+		if err != nil {
+			report.FileErrors = append(report.FileErrors, *err)
+		}
+	}
+
+	readers := map[int]*record.Reader{}
+	defer func() {
+		for _, reader := range readers {
+			reader.Close()
+		}
+	}()
+	dataStore.keydir.Range(func(key string, entry keydir.KeydirRecord) bool {
+		latest, found := rebuilt.GetKeydirRecord([]byte(key))
+		d := VerifyDiscrepancy{Key: key, KeydirFileId: entry.FileId, KeydirOffset: entry.ValuePos}
+		if found {
+			d.RecordFileId, d.RecordOffset = latest.FileId, latest.ValuePos
+		}
+		if detail := dataStore.verifyEntry(readers, key, entry); detail != "" {
+			d.Kind, d.Detail = DanglingEntry, detail
+			report.Discrepancies = append(report.Discrepancies, d)
+		} else if !found || latest.FileId != entry.FileId || latest.ValuePos != entry.ValuePos {
+			d.Kind = MismatchedEntry
+			report.Discrepancies = append(report.Discrepancies, d)
+		}
+		return true
+	})
+	rebuilt.Range(func(key string, latest keydir.KeydirRecord) bool {
+		if _, ok := dataStore.keydir.GetKeydirRecord([]byte(key)); !ok {
+			report.Discrepancies = append(report.Discrepancies, VerifyDiscrepancy{
+				Kind:         UnreachableRecord,
+				Key:          key,
+				RecordFileId: latest.FileId,
+				RecordOffset: latest.ValuePos,
+			})
+		}
+		return true
+	})
+	return report, nil
+}
+
+// verifyScan replays the records of the data file into kd, and returns the number of records that were read, and where
+// the scan stopped if the file could not be read completely
+func (dataStore *DataStore) verifyScan(kd *keydir.Keydir, id int) (int, *VerifyFileError) {
+	path := dataStore.fileManager.DataFilePath(id)
+	if _, err := datafile.ReadFileHeader(dataStore.fs, path); err != nil {
+		return 0, &VerifyFileError{FileId: id, Err: err}
+	}
+	scanner, err := record.NewScanner(dataStore.fs, path)
+	if err != nil {
+		return 0, &VerifyFileError{FileId: id, Err: err}
+	}
+	defer scanner.Close()
+	scanner.SetLimits(limitsOf(dataStore.metaInfo))
+	scanner.SetKeyring(dataStore.fileManager.Keyring())
+	records := 0
+	var end int64
+	for {
+		rec, offset, err := scanner.Scan()
+		if errors.Is(err, io.EOF) {
+			return records, nil
+		}
+		if err != nil {
+			return records, &VerifyFileError{FileId: id, Offset: end, Err: err}
+		}
+		records++
+		end = offset + rec.Size
+		if rec.Header.RecordType == record.RecordTypeDelete {
+			kd.DeleteRecordIfNotNewer(rec.Key, rec.Header.Timestamp)
+		} else {
+			kd.Add(rec.Key, keydir.KeydirRecord{
+				FileId:    id,
+				ValueSize: rec.Header.ValueSize,
+				ValuePos:  offset,
+				Timestamp: rec.Header.Timestamp,
+				Immutable: rec.Header.RecordType == record.RecordTypePutImmutable,
+				ValueType: rec.Header.ValueType,
+			})
+		}
+	}
+}
+
+// verifyEntry reads the record the keydir entry points to with a strict CRC check, and returns what does not match the
+// entry, or an empty string if it matches
+func (dataStore *DataStore) verifyEntry(readers map[int]*record.Reader, key string, entry keydir.KeydirRecord) string {
+	reader, ok := readers[entry.FileId]
+	if !ok {
+		var err error
+		reader, err = record.NewReader(dataStore.fs, dataStore.fileManager.DataFilePath(entry.FileId))
+		if err != nil {
+			return fmt.Sprintf("could not open data file: %s", err)
+		}
+		reader.SetLimits(limitsOf(dataStore.metaInfo))
+		reader.SetKeyring(dataStore.fileManager.Keyring())
+		readers[entry.FileId] = reader
+	}
+	rec, err := reader.ReadRecordAtStrict(entry.ValuePos)
+	switch {
+	case err != nil:
+		return fmt.Sprintf("could not read record: %s", err)
+	case !record.IsPut(rec.Header.RecordType):
+		return "record is a tombstone"
+	case !bytes.Equal(rec.Key, []byte(key)):
+		return fmt.Sprintf("record has key %q", rec.Key)
+	case rec.Header.ValueSize != entry.ValueSize:
+		return fmt.Sprintf("record has a %d byte value, keydir has %d", rec.Header.ValueSize, entry.ValueSize)
+	case (rec.Header.RecordType == record.RecordTypePutImmutable) != entry.Immutable:
+		return "write-once flag does not match"
+	}
+	return ""
+}
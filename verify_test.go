@@ -0,0 +1,87 @@
+package kvdb
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/spf13/afero"
+)
+
+func TestVerify(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	store, err := Create(fs, "test_verify.db")
+	if err != nil {
+		t.Fatalf("could not create datastore: %v", err)
+	}
+	defer store.Close()
+	store.Put([]byte("a"), []byte("old"))
+	store.Put([]byte("a"), []byte("new"))
+	store.Put([]byte("b"), []byte("value"))
+	store.Put([]byte("c"), []byte("value"))
+	store.Put([]byte("d"), []byte("value"))
+	store.Delete([]byte("d"))
+
+	report, err := store.Verify()
+	if err != nil {
+		t.Fatalf("verify failed: %v", err)
+	}
+	if !report.OK() || report.Files != 1 || report.Records != 6 || report.Keys != 3 {
+		t.Fatalf("expected a clean report, got %+v", report)
+	}
+
+	// Break the keydir: a points to it's old value, b to the middle of a record, and c is missing
+	first, _ := store.keydir.GetKeydirRecord([]byte("a"))
+	first.ValuePos = 0
+	store.keydir.Add([]byte("a"), first)
+	b, _ := store.keydir.GetKeydirRecord([]byte("b"))
+	b.ValuePos += 3
+	store.keydir.Add([]byte("b"), b)
+	store.keydir.DeleteRecord([]byte("c"))
+
+	report, err = store.Verify()
+	if err != nil {
+		t.Fatalf("verify failed: %v", err)
+	}
+	kinds := map[string]VerifyDiscrepancyKind{}
+	for _, d := range report.Discrepancies {
+		kinds[d.Key] = d.Kind
+	}
+	expected := map[string]VerifyDiscrepancyKind{"a": MismatchedEntry, "b": DanglingEntry, "c": UnreachableRecord}
+	if len(kinds) != len(expected) {
+		t.Errorf("expected %v, got %+v", expected, report.Discrepancies)
+	}
+	for key, kind := range expected {
+		if kinds[key] != kind {
+			t.Errorf("%s: expected %s, got %q", key, kind, kinds[key])
+		}
+	}
+}
+
+func TestVerifyCorruptRecord(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	store, err := Create(fs, "test_verify_corrupt.db")
+	if err != nil {
+		t.Fatalf("could not create datastore: %v", err)
+	}
+	defer store.Close()
+	store.Put([]byte("a"), []byte("value"))
+	store.Put([]byte("b"), []byte("value"))
+	rec, _ := store.keydir.GetKeydirRecord([]byte("b"))
+
+	// Flip the last byte of the value of b
+	path := filepath.Join("test_verify_corrupt.db", "data", "0000000001.dat")
+	data, _ := afero.ReadFile(fs, path)
+	data[len(data)-1] ^= 0xff
+	afero.WriteFile(fs, path, data, 0644)
+
+	report, err := store.Verify()
+	if err != nil {
+		t.Fatalf("verify failed: %v", err)
+	}
+	if len(report.FileErrors) != 1 || report.FileErrors[0].FileId != 1 || report.FileErrors[0].Offset != rec.ValuePos {
+		t.Errorf("expected the corrupt record of file 1, got %+v", report.FileErrors)
+	}
+	if len(report.Discrepancies) != 1 || report.Discrepancies[0].Key != "b" || report.Discrepancies[0].Kind != DanglingEntry {
+		t.Errorf("expected b to be dangling, got %+v", report.Discrepancies)
+	}
+}
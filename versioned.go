@@ -0,0 +1,70 @@
+package kvdb
+
+import (
+	"fmt"
+	"time"
+)
+
+/*
+Versioned writes
+
+Every live key has a version, which is the timestamp of it's record in microseconds since the Unix epoch. The timestamp
+is already stored in the record header and in the keydir (and in hint files), so versions need no extra space, and they
+survive restarts and merges, which keep the timestamps of the records they move.
+
+Record timestamps only ever increase (see sequence.go), so the version changes on every write of the key. A key that
+does not exist has version 0.
+
+PutVersion is an optimistic compare-and-swap: read the value and it's version with GetVersion, compute the new value,
+and write it with the version that was read. If another writer got in first, PutVersion fails with ErrVersionMismatch,
+and the caller can read the key again and retry. Writes with explicit timestamps (PutWithTimestamp, replication) keep
+the timestamps they are given
+*/
+
+// versionOf returns the version of a keydir record
+func versionOf(ts time.Time) uint64 {
+	return uint64(ts.UnixMicro())
+}
+
+// GetVersion returns the value of the key and it's version. If the key does not exist, `ErrKeyNotFound` is returned
+func (dataStore *DataStore) GetVersion(key []byte) ([]byte, uint64, error) {
+	if err := dataStore.gate.enter(); err != nil {
+		return nil, 0, err
+	}
+	defer dataStore.gate.exit()
+	dataStore.lockProfiler.Lock(dataStore.mu.RLocker(), "datastore.get")
+	defer dataStore.mu.RUnlock()
+	dataStore.counters.gets.Add(1)
+	rec, ok := dataStore.keydir.GetKeydirRecord(key)
+	if !ok {
+		return nil, 0, ErrKeyNotFound
+	}
+	value, err := dataStore.fileManager.ReadValueAt(rec.FileId, rec.ValuePos)
+	if err != nil {
+		return nil, 0, err
+	}
+	return value.Value, versionOf(rec.Timestamp), nil
+}
+
+// PutVersion sets the value of the key if it's version is expectedVersion (0 if the key must not exist), and returns
+// the new version. If the key has another version, nothing is written and ErrVersionMismatch is returned
+func (dataStore *DataStore) PutVersion(key []byte, value []byte, expectedVersion uint64) (uint64, error) {
+	if err := dataStore.gate.enter(); err != nil {
+		return 0, err
+	}
+	defer dataStore.gate.exit()
+	dataStore.lockForWrite("datastore.put_version")
+	defer dataStore.mu.Unlock()
+	var current uint64
+	if rec, ok := dataStore.keydir.GetKeydirRecord(key); ok {
+		current = versionOf(rec.Timestamp)
+	}
+	if current != expectedVersion {
+		return 0, fmt.Errorf("%w: expected version %d, key %q is at version %d", ErrVersionMismatch, expectedVersion, key, current)
+	}
+	ts := dataStore.nextTimestamp(key)
+	if err := dataStore.putRecord(key, value, ts, false, ValueTypeString); err != nil {
+		return 0, err
+	}
+	return versionOf(ts), nil
+}
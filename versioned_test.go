@@ -0,0 +1,58 @@
+package kvdb
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/spf13/afero"
+)
+
+func TestPutVersion(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	store := helperCreateMultipleDataFiles(t, fs, "test_put_version.db")
+
+	if _, err := store.PutVersion([]byte("key"), []byte("value"), 1); !errors.Is(err, ErrVersionMismatch) {
+		t.Fatalf("expected a version mismatch for a key that does not exist, got %v", err)
+	}
+	v1, err := store.PutVersion([]byte("key"), []byte("value1"), 0)
+	if err != nil || v1 == 0 {
+		t.Fatalf("expected the key to be created, got version %d, %v", v1, err)
+	}
+	v2, err := store.PutVersion([]byte("key"), []byte("value2"), v1)
+	if err != nil || v2 <= v1 {
+		t.Fatalf("expected a newer version than %d, got %d, %v", v1, v2, err)
+	}
+
+	// Another writer got in first
+	if _, err := store.PutVersion([]byte("key"), []byte("stale"), v1); !errors.Is(err, ErrVersionMismatch) {
+		t.Fatalf("expected a version mismatch, got %v", err)
+	}
+	value, version, err := store.GetVersion([]byte("key"))
+	if err != nil || string(value) != "value2" || version != v2 {
+		t.Fatalf("expected value2 at version %d, got %q at %d, %v", v2, value, version, err)
+	}
+
+	// Every write changes the version, even within the same microsecond
+	for range 100 {
+		store.Put([]byte("key"), []byte("value"))
+		_, next, _ := store.GetVersion([]byte("key"))
+		if next <= version {
+			t.Fatalf("expected the version to increase after %d, got %d", version, next)
+		}
+		version = next
+	}
+
+	// Versions survive a restart
+	store.Close()
+	store, err = Open(fs, "test_put_version.db")
+	if err != nil {
+		t.Fatalf("failed to reopen store: %v", err)
+	}
+	defer store.Close()
+	if _, reopened, _ := store.GetVersion([]byte("key")); reopened != version {
+		t.Errorf("expected version %d after reopening, got %d", version, reopened)
+	}
+	if _, err := store.PutVersion([]byte("key"), []byte("value3"), version); err != nil {
+		t.Errorf("expected the put to succeed after reopening, got %v", err)
+	}
+}
@@ -0,0 +1,92 @@
+package kvdb
+
+import (
+	"sync"
+	"time"
+)
+
+// WatchEvent describes a write that has been applied to the datastore
+type WatchEvent struct {
+	Type WriteType
+	Key  []byte
+	// Value is nil for deletes, ValueType is the type of the value (see types.go)
+	Value     []byte
+	ValueType ValueType
+	// Id of the data file the record (or tombstone) was written to, and it's offset from the first record in the file
+	FileId int
+	Offset int64
+	// Timestamp of the record (or tombstone), as it's stored in the data file
+	Timestamp time.Time
+	// Expired is set for the deletes of keys whose data file was dropped by ExpireDataFiles, which have no tombstone.
+	// FileId and Offset are those of the dropped record, and Timestamp is the time of the delete
+	Expired bool
+}
+
+// WatchFunc is called for every event. Key and Value are only valid during the call, and must be copied if they
+// are needed afterwards
+type WatchFunc func(event WatchEvent)
+
+// MergeEvent describes a completed merge
+type MergeEvent struct {
+	// Paths of the sealed data files written by the merge, in the order in which they were written. Each data file has
+	// a hint file with the same name in the hint directory
+	Files []string
+	// Ids of the data files that were merged and deleted
+	RemovedFileIds []int
+}
+
+// MergeWatchFunc is called after every successful merge
+type MergeWatchFunc func(event MergeEvent)
+
+type watchers[E any] struct {
+	mu     sync.RWMutex
+	nextId uint64
+	funcs  map[uint64]func(E)
+}
+
+// Watch registers fn to be called after every successful Put, and after every Delete of a key that existed (including
+// the keys removed by ExpireDataFiles).
+// Writes made by Merge are not reported. fn is called inside the write lock, in the order in which writes are applied,
+// so it must not block, and must not call back into the datastore. It returns a function that unregisters fn
+func (dataStore *DataStore) Watch(fn WatchFunc) (cancel func()) {
+	return dataStore.watchers.add(fn)
+}
+
+// WatchMerges registers fn to be called after every successful merge. It's meant for shipping the merged files as a
+// whole (for example, to a replica that adopts them with AdoptFile) instead of replaying the records in them.
+// fn is called with the merge lock held, so the files in the event are not removed by another merge until fn returns,
+// fn may read the files but must not call Merge. It returns a function that unregisters fn
+func (dataStore *DataStore) WatchMerges(fn MergeWatchFunc) (cancel func()) {
+	return dataStore.mergeWatchers.add(fn)
+}
+
+func (w *watchers[E]) add(fn func(E)) (cancel func()) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.funcs == nil {
+		w.funcs = map[uint64]func(E){}
+	}
+	id := w.nextId
+	w.nextId++
+	w.funcs[id] = fn
+	return func() {
+		w.mu.Lock()
+		defer w.mu.Unlock()
+		delete(w.funcs, id)
+	}
+}
+
+func (w *watchers[E]) notify(event E) {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	for _, fn := range w.funcs {
+		fn(event)
+	}
+}
+
+// active returns true if at least one function is registered
+func (w *watchers[E]) active() bool {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	return len(w.funcs) > 0
+}
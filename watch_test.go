@@ -0,0 +1,91 @@
+package kvdb
+
+import (
+	"testing"
+
+	"github.com/spf13/afero"
+)
+
+func TestWatch(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	store := helperCreateMultipleDataFiles(t, fs, "test_watch.db")
+	defer store.Close()
+
+	var events []string
+	var positions [][2]int64
+	cancel := store.Watch(func(event WatchEvent) {
+		events = append(events, event.Type.String()+" "+string(event.Key)+" "+string(event.Value))
+		positions = append(positions, [2]int64{int64(event.FileId), event.Offset})
+	})
+
+	store.Put([]byte("key1"), []byte("value1"))
+	store.Delete([]byte("key1"))
+	// Deleting a key that does not exist is not reported
+	store.Delete([]byte("missing"))
+	store.PatchJSON([]byte("doc"), "", map[string]int{"a": 1})
+
+	cancel()
+	store.Put([]byte("key2"), []byte("value2"))
+
+	expected := []string{"PUT key1 value1", "DELETE key1 ", `PUT doc {"a":1}`}
+	if len(events) != len(expected) {
+		t.Fatalf("expected events %v, got %v", expected, events)
+	}
+	for i := range expected {
+		if events[i] != expected[i] {
+			t.Errorf("event %d: expected %q, got %q", i, expected[i], events[i])
+		}
+	}
+	// The first record is written at the start of the first data file
+	if positions[0] != [2]int64{1, 0} || positions[1][0] != 1 || positions[1][1] <= 0 {
+		t.Errorf("unexpected record positions %v", positions)
+	}
+}
+
+func TestWatchMerges(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	store := helperCreateMultipleDataFiles(t, fs, "test_watch_merges.db")
+	store.Put([]byte("key1"), []byte("value1"))
+	store.Put([]byte("key2"), []byte("value2"))
+	// Reopen so that the first file becomes immutable
+	store.Close()
+	store, err := Open(fs, "test_watch_merges.db")
+	if err != nil {
+		t.Fatalf("failed to reopen store: %v", err)
+	}
+	defer store.Close()
+	store.Put([]byte("key1"), []byte("value1_updated"))
+
+	var events []MergeEvent
+	cancel := store.WatchMerges(func(event MergeEvent) {
+		events = append(events, event)
+	})
+	if err := store.Merge(); err != nil {
+		t.Fatalf("merge failed: %v", err)
+	}
+	cancel()
+	store.Merge()
+
+	if len(events) != 1 {
+		t.Fatalf("expected 1 merge event, got %d", len(events))
+	}
+	event := events[0]
+	if len(event.Files) != 1 || len(event.RemovedFileIds) != 1 || event.RemovedFileIds[0] != 1 {
+		t.Fatalf("unexpected merge event %+v", event)
+	}
+
+	// The merged file can be shipped to another datastore as a whole
+	replica := helperCreateMultipleDataFiles(t, fs, "test_watch_merges_replica.db")
+	defer replica.Close()
+	if err := replica.AdoptFile(event.Files[0]); err != nil {
+		t.Fatalf("adopt failed: %v", err)
+	}
+	val, err := replica.Get([]byte("key2"))
+	if err != nil || string(val) != "value2" {
+		t.Errorf("expected value2, got %s (err: %v)", val, err)
+	}
+	// key1 was superseded by a newer write, so it's not in the merged file
+	if _, err := replica.Get([]byte("key1")); err != ErrKeyNotFound {
+		t.Errorf("expected ErrKeyNotFound for key1, got %v", err)
+	}
+}
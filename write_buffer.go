@@ -0,0 +1,57 @@
+package kvdb
+
+import (
+	"time"
+)
+
+/*
+Write buffer
+
+Without a buffer, every record written to the active file takes two or three write system calls (the header, the key
+and value, and the checksum). With Options.WriteBufferSize, the records are appended to an in-memory buffer of the
+active file instead, and the buffer is written to the file with a single system call when:
+
+  - it's full (a record larger than the buffer is written directly)
+  - the active file is synced (Sync, group commit with GroupCommitSync, Close) or sealed by a rotation
+  - a value is streamed with PutReader (the value is not kept in memory)
+  - Scan with ScanTombstones and Verify read the data files, they flush the buffer first
+  - every Options.WriteBufferFlushInterval, by a background goroutine, so that buffered writes reach the file (and the
+    page cache) even when there are no more writes, reads or syncs
+
+The keydir is updated when the record is in the buffer, the offsets of the records are the offsets they will have in
+the file. Get (and the other reads by location, and LogTailer) find the records that are still in the buffer in the
+tail cache of the file manager, so they see every write that returned without flushing the buffer, see
+internal/filemanager/write_tail.go.
+
+Writes that are in the buffer are lost if the process crashes, so a buffered datastore gives up the "written means in
+the page cache" guarantee of the unbuffered one, the guarantee of Sync is not changed. If a flush fails, the buffer
+keeps returning the error, and the next write (or Sync) fails with it
+*/
+
+const defaultWriteBufferFlushInterval = 100 * time.Millisecond
+
+// setupWriteBuffer starts flushing the write buffer in the background, if Options.WriteBufferSize is set
+func (dataStore *DataStore) setupWriteBuffer() {
+	if dataStore.options.WriteBufferSize <= 0 {
+		return
+	}
+	interval := dataStore.options.WriteBufferFlushInterval
+	if interval <= 0 {
+		interval = defaultWriteBufferFlushInterval
+	}
+	done := dataStore.gate.ctx.Done()
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				// Close flushes the buffer when it syncs the active file
+				return
+			case <-ticker.C:
+				// An error is returned by the next write or Sync
+				dataStore.fileManager.Flush()
+			}
+		}
+	}()
+}
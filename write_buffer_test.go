@@ -0,0 +1,164 @@
+package kvdb
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ananthvk/kvdb/internal/datafile"
+	"github.com/ananthvk/kvdb/internal/utils"
+	"github.com/spf13/afero"
+)
+
+func TestWriteBufferReadYourWrites(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	path := "test_write_buffer.db"
+	opts := &Options{WriteBufferSize: 1 << 20, WriteBufferFlushInterval: time.Hour}
+	store, err := CreateWithOptions(fs, path, opts)
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	dataFile := filepath.Join(path, "data", utils.GetDataFileName(1))
+	for i := range 100 {
+		key := fmt.Appendf(nil, "key%d", i)
+		if err := store.Put(key, []byte("value")); err != nil {
+			t.Fatalf("put failed: %v", err)
+		}
+		if i%10 == 0 {
+			store.Delete(key)
+		}
+	}
+	if info, err := fs.Stat(dataFile); err != nil || info.Size() > datafile.FileHeaderSize {
+		t.Errorf("expected the records to be buffered, got %v (err: %v)", info.Size(), err)
+	}
+
+	// Reads flush the buffer
+	for i := range 100 {
+		val, err := store.Get(fmt.Appendf(nil, "key%d", i))
+		if i%10 == 0 {
+			if err == nil {
+				t.Errorf("key%d: expected deleted key to be missing", i)
+			}
+			continue
+		}
+		if err != nil || string(val) != "value" {
+			t.Errorf("key%d: expected value, got %s (err: %v)", i, val, err)
+		}
+	}
+	store.Put([]byte("deleted"), []byte("value"))
+	store.Delete([]byte("deleted"))
+	found := false
+	store.Scan(&ScanFilter{Types: ScanTombstones}, func(entry ScanEntry) error {
+		found = found || string(entry.Key) == "deleted"
+		return nil
+	})
+	if !found {
+		t.Errorf("expected the scan to find the buffered tombstone")
+	}
+	store.Put([]byte("last"), []byte("value"))
+	if report, err := store.Verify(); err != nil || !report.OK() {
+		t.Errorf("expected verify to pass, got %+v (err: %v)", report, err)
+	}
+	store.Close()
+
+	store, err = Open(fs, path)
+	if err != nil {
+		t.Fatalf("failed to reopen store: %v", err)
+	}
+	defer store.Close()
+	if store.Size() != 91 {
+		t.Errorf("expected 91 keys after reopen, got %d", store.Size())
+	}
+}
+
+func TestWriteBufferFlushedOnInterval(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	path := "test_write_buffer_interval.db"
+	opts := &Options{WriteBufferSize: 1 << 20, WriteBufferFlushInterval: 10 * time.Millisecond}
+	store, err := CreateWithOptions(fs, path, opts)
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer store.Close()
+	store.Put([]byte("key"), []byte("value"))
+	dataFile := filepath.Join(path, "data", utils.GetDataFileName(1))
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if info, err := fs.Stat(dataFile); err == nil && info.Size() > datafile.FileHeaderSize {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the buffer to be flushed by the timer")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestWriteBufferInterleavedPutGet(t *testing.T) {
+	// A small buffer that is flushed in the middle of records, and no timer, so most reads hit the tail
+	opts := &Options{WriteBufferSize: 256, WriteBufferFlushInterval: time.Hour}
+	store, err := CreateWithOptions(afero.NewMemMapFs(), "test_write_buffer_interleaved.db", opts)
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	var wg sync.WaitGroup
+	for w := range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			// Every writer has it's own key, and reads it back after every write
+			key := fmt.Appendf(nil, "key%d", w)
+			for i := range 500 {
+				value := bytes.Repeat(fmt.Appendf(nil, "%d-%d,", w, i), i%50)
+				if err := store.Put(key, value); err != nil {
+					t.Errorf("put failed: %v", err)
+					return
+				}
+				got, err := store.Get(key)
+				if err != nil || !bytes.Equal(got, value) {
+					t.Errorf("%s: expected the value of write %d, got %q (err: %v)", key, i, got, err)
+					return
+				}
+			}
+		}()
+	}
+	// Readers of a shared key never see a value that was not written
+	shared := []byte("shared")
+	store.Put(shared, []byte("0"))
+	for range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range 500 {
+				if got, err := store.Get(shared); err != nil || len(got) == 0 {
+					t.Errorf("shared: expected a value, got %q (err: %v)", got, err)
+					return
+				}
+			}
+		}()
+	}
+	for i := range 500 {
+		store.Put(shared, strconv.AppendInt(nil, int64(i), 10))
+	}
+	wg.Wait()
+
+	tailer, err := store.TailLog(0, 0)
+	if err != nil {
+		t.Fatalf("failed to tail the log: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	// 8 writers, the first and the other writes of the shared key
+	for i := range 8*500 + 501 {
+		if _, err := tailer.Next(ctx); err != nil {
+			t.Fatalf("record %d: expected the buffered records to be tailed, got %v", i, err)
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package kvdb
+
+import (
+	"fmt"
+	"time"
+)
+
+/*
+Write-once keys
+
+A key written with PutOptions.WriteOnce can't be overwritten or deleted, every later Put or Delete of the key (including
+the pairs of PutBatch) returns ErrImmutableKey. This suits content-addressed storage, where the key is the hash of the
+value, so the value of a key never has to change.
+
+The put is stored with it's own record type (record.RecordTypePutImmutable), and hint files flag it in the top bit of the
+key size, so a write-once key stays write-once when the datastore is reopened, and when it's moved by a merge, a repair
+or a snapshot. Records of adopted files don't replace or delete write-once keys either
+*/
+
+// PutOptions changes how a key is written, see PutWithOptions
+type PutOptions struct {
+	// WriteOnce makes the key write-once, i.e. it can't be overwritten or deleted once the put succeeds
+	WriteOnce bool
+	// ValueType is the type of the value, the value of a structured type must be it's encoding as returned by
+	// GetWithType (see types.go). It's meant for copying keys, such as a replica applying the writes of it's primary
+	ValueType ValueType
+	// Timestamp of the record instead of the current time, if it's not zero, see PutWithTimestamp
+	Timestamp time.Time
+}
+
+// PutWithOptions is Put with options, opts can be nil
+func (dataStore *DataStore) PutWithOptions(key []byte, value []byte, opts *PutOptions) error {
+	if opts == nil {
+		opts = &PutOptions{}
+	}
+	if opts.ValueType > ValueTypeSet {
+		return fmt.Errorf("unknown value type %d", opts.ValueType)
+	}
+	if err := dataStore.gate.enter(); err != nil {
+		return err
+	}
+	defer dataStore.gate.exit()
+	dataStore.lockForWrite("datastore.put")
+	defer dataStore.mu.Unlock()
+	ts := opts.Timestamp
+	if ts.IsZero() {
+		ts = dataStore.nextTimestamp(key)
+	}
+	return dataStore.putRecord(key, value, ts, opts.WriteOnce, opts.ValueType)
+}
+
+// checkImmutable returns ErrImmutableKey if the key is write-once, the caller must hold the write lock
+func (dataStore *DataStore) checkImmutable(key []byte) error {
+	if rec, ok := dataStore.keydir.GetKeydirRecord(key); ok && rec.Immutable {
+		return fmt.Errorf("%w: %q", ErrImmutableKey, key)
+	}
+	return nil
+}
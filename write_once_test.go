@@ -0,0 +1,122 @@
+package kvdb
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/ananthvk/kvdb/internal/hintfile"
+	"github.com/spf13/afero"
+)
+
+// helperCheckWriteOnce checks that the key has the value, and can't be overwritten or deleted
+func helperCheckWriteOnce(t *testing.T, store *DataStore, key, value string) {
+	t.Helper()
+	if got, err := store.Get([]byte(key)); err != nil || string(got) != value {
+		t.Errorf("expected %s=%s, got %q, %v", key, value, got, err)
+	}
+	if err := store.Put([]byte(key), []byte("other")); !errors.Is(err, ErrImmutableKey) {
+		t.Errorf("expected ErrImmutableKey when overwriting %s, got %v", key, err)
+	}
+	if err := store.Delete([]byte(key)); !errors.Is(err, ErrImmutableKey) {
+		t.Errorf("expected ErrImmutableKey when deleting %s, got %v", key, err)
+	}
+}
+
+func TestWriteOnce(t *testing.T) {
+	store, err := Create(afero.NewMemMapFs(), "test_write_once.db")
+	if err != nil {
+		t.Fatalf("could not create datastore: %v", err)
+	}
+	defer store.Close()
+
+	if err := store.PutWithOptions([]byte("blob"), []byte("contents"), &PutOptions{WriteOnce: true}); err != nil {
+		t.Fatalf("put failed: %v", err)
+	}
+	helperCheckWriteOnce(t, store, "blob", "contents")
+	if err := store.PutWithOptions([]byte("blob"), []byte("contents"), &PutOptions{WriteOnce: true}); !errors.Is(err, ErrImmutableKey) {
+		t.Errorf("expected ErrImmutableKey when writing a write-once key again, got %v", err)
+	}
+	if _, err := store.GetDelete([]byte("blob")); !errors.Is(err, ErrImmutableKey) {
+		t.Errorf("expected ErrImmutableKey from GetDelete, got %v", err)
+	}
+
+	// A batch with a write-once key writes nothing
+	n, err := store.PutBatch([]KeyValue{{Key: []byte("a"), Value: []byte("1")}, {Key: []byte("blob"), Value: []byte("2")}})
+	if !errors.Is(err, ErrImmutableKey) || n != 0 {
+		t.Errorf("expected the batch to fail with ErrImmutableKey, got %d, %v", n, err)
+	}
+	if _, err := store.Get([]byte("a")); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("expected the batch not to write a, got %v", err)
+	}
+
+	// Other keys are not affected, and PutWithOptions with nil options is Put
+	if err := store.PutWithOptions([]byte("a"), []byte("1"), nil); err != nil {
+		t.Fatalf("put failed: %v", err)
+	}
+	if err := store.Put([]byte("a"), []byte("2")); err != nil {
+		t.Errorf("expected a to be overwritten, got %v", err)
+	}
+}
+
+func TestWriteOnceSurvivesReopenAndMerge(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	path := "test_write_once_reopen.db"
+	store, err := Create(fs, path)
+	if err != nil {
+		t.Fatalf("could not create datastore: %v", err)
+	}
+	store.Put([]byte("mutable"), []byte("1"))
+	store.PutWithOptions([]byte("blob"), []byte("contents"), &PutOptions{WriteOnce: true})
+	store.Close()
+
+	// The keydir is built by scanning the data file
+	store, err = Open(fs, path)
+	if err != nil {
+		t.Fatalf("could not open datastore: %v", err)
+	}
+	helperCheckWriteOnce(t, store, "blob", "contents")
+
+	// The merge copies the record to a new data file, and flags it in the hint file
+	store.Put([]byte("mutable"), []byte("2"))
+	store.Close()
+	store, err = Open(fs, path)
+	if err != nil {
+		t.Fatalf("could not open datastore: %v", err)
+	}
+	if err := store.Merge(); err != nil {
+		t.Fatalf("merge failed: %v", err)
+	}
+	helperCheckWriteOnce(t, store, "blob", "contents")
+	store.Close()
+
+	hints, err := afero.Glob(fs, filepath.Join(path, "hint", "*.hint"))
+	if err != nil || len(hints) != 1 {
+		t.Fatalf("expected one hint file, got %v, %v", hints, err)
+	}
+	scanner, err := hintfile.NewScanner(fs, hints[0])
+	if err != nil {
+		t.Fatalf("could not open hint file: %v", err)
+	}
+	for {
+		hint, err := scanner.Scan()
+		if err != nil {
+			break
+		}
+		if hint.Immutable != (string(hint.Key) == "blob") || int(hint.KeySize) != len(hint.Key) {
+			t.Errorf("unexpected hint %+v", hint)
+		}
+	}
+	scanner.Close()
+
+	// The keydir is built from the hint file
+	store, err = Open(fs, path)
+	if err != nil {
+		t.Fatalf("could not open datastore: %v", err)
+	}
+	defer store.Close()
+	helperCheckWriteOnce(t, store, "blob", "contents")
+	if err := store.Put([]byte("mutable"), []byte("3")); err != nil {
+		t.Errorf("expected mutable to be overwritten, got %v", err)
+	}
+}